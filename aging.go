@@ -0,0 +1,64 @@
+package main
+
+import "math"
+
+// AgingConfig boosts transactions that have waited many blocks, so under
+// constant load a low tip delays a transaction rather than starving it.
+// Once a transaction has waited GraceBlocks pending blocks, every further
+// block adds TipPerBlock wei per gas to its score, up to MaxTip. The boost
+// only orders the build: it is never paid, so profits and reports ignore
+// it.
+type AgingConfig struct {
+	TipPerBlock int64  `json:"tip_per_block"` // wei per gas per block waited; 0 disables aging
+	GraceBlocks uint64 `json:"grace_blocks"`  // blocks waited before the boost starts
+	MaxTip      int64  `json:"max_tip"`       // cap on the boost in wei per gas; 0 is uncapped
+}
+
+// Enabled reports whether aging boosts anything
+func (c AgingConfig) Enabled() bool { return c.TipPerBlock > 0 }
+
+// Boost returns the score tx earns for having waited since its SeenBlock
+// when block is being built, saturating at math.MaxInt64 when uncapped.
+// Transactions of unknown age get nothing.
+func (c AgingConfig) Boost(tx *Transaction, block uint64) int64 {
+	if !c.Enabled() || tx.SeenBlock == 0 || block <= tx.SeenBlock+c.GraceBlocks {
+		return 0
+	}
+	waited := min(block-tx.SeenBlock-c.GraceBlocks, math.MaxInt64)
+	tip := saturatingMul(int64(waited), c.TipPerBlock)
+	if c.MaxTip > 0 {
+		tip = min(tip, c.MaxTip)
+	}
+	return saturatingMul(tip, tx.PackGas())
+}
+
+// Score is what builds rank tx by: its profit plus any aging boost, the
+// adjustment of a custom scorer and any contract boosts
+func (tx *Transaction) Score() int64 {
+	return saturatingAdd(tx.Profit(), tx.Boost)
+}
+
+// stamp sets the pool-derived fields tx is scored with
+func (p *TxPool) stamp(tx *Transaction) {
+	tx.BaseFee = p.BaseFee
+	adj := p.scoreAdjustment(tx)
+	tx.Boost = saturatingAdd(saturatingAdd(p.Aging.Boost(tx, p.Block), adj), p.contractBoost(tx, saturatingAdd(tx.Profit(), adj)))
+}
+
+// SetBlock records the number of the block being built, re-sorting the
+// heaps by the aging boosts and scores it changes
+func (p *TxPool) SetBlock(block uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setBlock(block)
+}
+
+func (p *TxPool) setBlock(block uint64) {
+	if block == p.Block {
+		return
+	}
+	p.Block = block
+	if p.Aging.Enabled() || p.customScored() {
+		p.restamp()
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAgingBoost(t *testing.T) {
+	gwei := int64(1_000_000_000)
+	tx := &Transaction{Hash: "0x01", GasLimit: 30_000_000, SeenBlock: 100}
+	for _, tc := range []struct {
+		name  string
+		cfg   AgingConfig
+		block uint64
+		want  int64
+	}{
+		{"in grace", AgingConfig{TipPerBlock: gwei, GraceBlocks: 5}, 105, 0},
+		{"after grace", AgingConfig{TipPerBlock: gwei, GraceBlocks: 5}, 108, 3 * gwei * 30_000_000},
+		{"capped", AgingConfig{TipPerBlock: gwei, MaxTip: 2 * gwei}, 1100, 2 * gwei * 30_000_000},
+		{"uncapped long wait", AgingConfig{TipPerBlock: gwei}, 1100, math.MaxInt64},
+		{"uncapped huge wait", AgingConfig{TipPerBlock: 1}, math.MaxUint64, math.MaxInt64},
+	} {
+		if got := tc.cfg.Boost(tx, tc.block); got != tc.want {
+			t.Errorf("%s: boost %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSaturatingMul(t *testing.T) {
+	for _, tc := range []struct{ x, y, want int64 }{
+		{3, 4, 12},
+		{-3, 4, -12},
+		{0, math.MinInt64, 0},
+		{math.MaxInt64, 2, math.MaxInt64},
+		{math.MaxInt64, -2, math.MinInt64},
+		{math.MinInt64, -1, math.MaxInt64},
+		{-1, math.MinInt64, math.MaxInt64},
+		{math.MinInt64, 1, math.MinInt64},
+	} {
+		if got := saturatingMul(tc.x, tc.y); got != tc.want {
+			t.Errorf("saturatingMul(%d, %d) = %d, want %d", tc.x, tc.y, got, tc.want)
+		}
+	}
+}
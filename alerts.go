@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Events alerts are fired for
+const (
+	AlertRPCOutage    = "rpc_outage"       // the node failed rpc_failures polls or syncs in a row
+	AlertRPCRecovered = "rpc_recovered"    // a sync succeeded again after an outage
+	AlertBuildFailure = "build_failure"    // a build returned an error
+	AlertHighValue    = "high_value_block" // a block worth at least high_value was built
+	AlertStarvation   = "pool_starvation"  // the pool had too few executable transactions for starvation_heads heads
+)
+
+// AlertEvents lists the events webhooks may subscribe to
+var AlertEvents = []string{AlertRPCOutage, AlertRPCRecovered, AlertBuildFailure, AlertHighValue, AlertStarvation}
+
+// Webhook payload formats
+const (
+	WebhookJSON      = "json"      // the Alert itself, or the rendered template
+	WebhookSlack     = "slack"     // incoming webhook {"text": ...}
+	WebhookDiscord   = "discord"   // webhook {"content": ...}
+	WebhookPagerDuty = "pagerduty" // Events API v2
+)
+
+// DefaultAlertTemplate is the message text of chat and PagerDuty alerts
+const DefaultAlertTemplate = `[{{.Severity}}] {{.Summary}}{{if .Suppressed}} ({{.Suppressed}} more since the last alert){{end}}`
+
+// AlertsConfig configures the webhooks operational events are posted to
+type AlertsConfig struct {
+	Webhooks        []WebhookConfig `json:"webhooks"`
+	MinInterval     Duration        `json:"min_interval"`     // least time between alerts of one event to one webhook
+	RPCFailures     int             `json:"rpc_failures"`     // failed head polls or syncs in a row that make an outage
+	HighValue       int64           `json:"high_value"`       // block profit in wei that alerts; 0 disables
+	StarvationTxs   int             `json:"starvation_txs"`   // executable transactions at or below which the pool starves
+	StarvationHeads int             `json:"starvation_heads"` // starved heads in a row before alerting; 0 disables
+}
+
+// WebhookConfig is one alert destination
+type WebhookConfig struct {
+	URL    string   `json:"url"`
+	Format string   `json:"format"` // json (the default), slack, discord or pagerduty
+	Events []string `json:"events"` // empty subscribes to all
+
+	// Template is a text/template executed with the Alert: the message
+	// text for slack, discord and pagerduty, the whole body for json
+	Template string `json:"template"`
+
+	// RoutingKey is the PagerDuty integration key
+	RoutingKey string `json:"routing_key"`
+}
+
+// Alert is one operational event
+type Alert struct {
+	Event      string    `json:"event"`
+	Severity   string    `json:"severity"` // critical, error, warning or info
+	Summary    string    `json:"summary"`
+	Head       uint64    `json:"head,omitempty"`
+	Value      int64     `json:"value,omitempty"`      // block profit in wei
+	Suppressed int       `json:"suppressed,omitempty"` // alerts of the event rate limited since the last one sent
+	At         time.Time `json:"at"`
+}
+
+// webhook is a configured destination with its rate limiting state
+type webhook struct {
+	WebhookConfig
+	tmpl       *template.Template
+	last       map[string]time.Time // by event
+	suppressed map[string]int
+}
+
+// Alerter turns what serve observes into alerts and posts them to the
+// configured webhooks, at most one per event and webhook every
+// MinInterval. A nil Alerter does nothing; its methods are safe for
+// concurrent use.
+type Alerter struct {
+	Out  io.Writer // delivery errors are reported here
+	HTTP *http.Client
+
+	cfg   AlertsConfig
+	mu    sync.Mutex
+	hooks []*webhook
+	wg    sync.WaitGroup
+
+	rpcFailures int
+	outage      bool
+	starved     int
+}
+
+// NewAlerter returns an alerter for cfg, or nil if no webhook is configured
+func NewAlerter(cfg AlertsConfig) (*Alerter, error) {
+	if len(cfg.Webhooks) == 0 {
+		return nil, nil
+	}
+	a := &Alerter{Out: io.Discard, HTTP: newHTTPClient(10 * time.Second), cfg: cfg}
+	for i, wc := range cfg.Webhooks {
+		text := wc.Template
+		if text == "" {
+			text = DefaultAlertTemplate
+		}
+		tmpl, err := template.New(fmt.Sprintf("alerts.webhooks[%d]", i)).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		a.hooks = append(a.hooks, &webhook{
+			WebhookConfig: wc,
+			tmpl:          tmpl,
+			last:          make(map[string]time.Time),
+			suppressed:    make(map[string]int),
+		})
+	}
+	return a, nil
+}
+
+// Fire posts alert to every webhook subscribed to its event that has not
+// sent one within MinInterval, counting it as suppressed for the others
+func (a *Alerter) Fire(ctx context.Context, alert Alert) {
+	if a == nil {
+		return
+	}
+	if alert.At.IsZero() {
+		alert.At = time.Now().UTC()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, h := range a.hooks {
+		if len(h.Events) > 0 && !slices.Contains(h.Events, alert.Event) {
+			continue
+		}
+		if last, ok := h.last[alert.Event]; ok && alert.At.Sub(last) < time.Duration(a.cfg.MinInterval) {
+			h.suppressed[alert.Event]++
+			continue
+		}
+		h.last[alert.Event] = alert.At
+		sent := alert
+		sent.Suppressed, h.suppressed[alert.Event] = h.suppressed[alert.Event], 0
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			if err := a.post(ctx, h, sent); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(a.Out, "Error posting %s alert to %s: %v\n", sent.Event, h.URL, err)
+			}
+		}()
+	}
+}
+
+// Wait blocks until every alert fired so far has been delivered or failed
+func (a *Alerter) Wait() {
+	if a != nil {
+		a.wg.Wait()
+	}
+}
+
+// post delivers alert to h in its format
+func (a *Alerter) post(ctx context.Context, h *webhook, alert Alert) error {
+	body, err := h.payload(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(msg))}
+	}
+	return nil
+}
+
+// payload renders the request body alert is posted with
+func (h *webhook) payload(alert Alert) ([]byte, error) {
+	var text bytes.Buffer
+	if err := h.tmpl.Execute(&text, alert); err != nil {
+		return nil, err
+	}
+	switch h.Format {
+	case WebhookSlack:
+		return json.Marshal(map[string]string{"text": text.String()})
+	case WebhookDiscord:
+		return json.Marshal(map[string]string{"content": text.String()})
+	case WebhookPagerDuty:
+		// Outages and their recovery share a dedup key, so a recovery
+		// resolves the incident its outage opened
+		action, key := "trigger", "bce-"+alert.Event
+		switch alert.Event {
+		case AlertRPCOutage:
+			key = "bce-rpc"
+		case AlertRPCRecovered:
+			action, key = "resolve", "bce-rpc"
+		}
+		return json.Marshal(map[string]any{
+			"routing_key":  h.RoutingKey,
+			"event_action": action,
+			"dedup_key":    key,
+			"payload": map[string]any{
+				"summary":        text.String(),
+				"source":         "block-construction-engine",
+				"severity":       alert.Severity,
+				"timestamp":      alert.At.Format(time.RFC3339),
+				"custom_details": alert,
+			},
+		})
+	}
+	if h.Template == "" {
+		return json.Marshal(alert)
+	}
+	return text.Bytes(), nil
+}
+
+// RPCFailed counts a failed head poll or pool sync, firing an outage alert
+// once RPCFailures have failed in a row
+func (a *Alerter) RPCFailed(ctx context.Context, err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.rpcFailures++
+	fire := a.rpcFailures == a.cfg.RPCFailures
+	if fire {
+		a.outage = true
+	}
+	n := a.rpcFailures
+	a.mu.Unlock()
+	if fire {
+		a.Fire(ctx, Alert{Event: AlertRPCOutage, Severity: "critical",
+			Summary: fmt.Sprintf("RPC unavailable: %d calls failed in a row, last: %v", n, err)})
+	}
+}
+
+// RPCOK resets the failure count, firing a recovery alert if it had made
+// an outage
+func (a *Alerter) RPCOK(ctx context.Context, head uint64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	recovered := a.outage
+	a.rpcFailures, a.outage = 0, false
+	a.mu.Unlock()
+	if recovered {
+		a.Fire(ctx, Alert{Event: AlertRPCRecovered, Severity: "info", Head: head,
+			Summary: fmt.Sprintf("RPC recovered at head #%d", head)})
+	}
+}
+
+// BuildFailed fires a build failure alert
+func (a *Alerter) BuildFailed(ctx context.Context, head uint64, err error) {
+	a.Fire(ctx, Alert{Event: AlertBuildFailure, Severity: "error", Head: head,
+		Summary: fmt.Sprintf("building on head #%d failed: %v", head, err)})
+}
+
+// Built fires a high value alert if the block of selected transactions
+// built on head is worth at least HighValue
+func (a *Alerter) Built(ctx context.Context, head uint64, selected []*Transaction) {
+	if a == nil || a.cfg.HighValue <= 0 {
+		return
+	}
+	profit := int64(0)
+	for _, tx := range selected {
+		profit += tx.Profit()
+	}
+	if profit < a.cfg.HighValue {
+		return
+	}
+	a.Fire(ctx, Alert{Event: AlertHighValue, Severity: "info", Head: head, Value: profit,
+		Summary: fmt.Sprintf("block on head #%d is worth %s (%d txs)", head, FormatWei(profit), len(selected))})
+}
+
+// Synced checks the executable transactions pooled on head for
+// starvation, alerting once it has lasted StarvationHeads heads
+func (a *Alerter) Synced(ctx context.Context, head uint64, executable int) {
+	if a == nil || a.cfg.StarvationHeads <= 0 {
+		return
+	}
+	a.mu.Lock()
+	if executable > a.cfg.StarvationTxs {
+		a.starved = 0
+	} else {
+		a.starved++
+	}
+	fire := a.starved == a.cfg.StarvationHeads
+	a.mu.Unlock()
+	if fire {
+		a.Fire(ctx, Alert{Event: AlertStarvation, Severity: "warning", Head: head,
+			Summary: fmt.Sprintf("pool starved: %d executable transactions at head #%d, %d heads in a row", executable, head, a.cfg.StarvationHeads)})
+	}
+}
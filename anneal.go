@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SearchConfig bounds the local search run by the "anneal" strategy
+type SearchConfig struct {
+	Iterations  int      `json:"iterations"`
+	Temperature float64  `json:"temperature"` // initial temperature, as a fraction of the mean candidate profit
+	Budget      Duration `json:"budget"`      // wall-clock limit; 0 is unlimited
+	Seed        uint64   `json:"seed"`
+
+	stopAfter int // replay: stop after this many iterations, as the recorded run did
+}
+
+// DefaultSearchConfig is used unless builder.search says otherwise
+func DefaultSearchConfig() SearchConfig {
+	return SearchConfig{Iterations: 20000, Temperature: 0.5, Budget: Duration(100 * time.Millisecond), Seed: 1}
+}
+
+// SearchResult reports how much the local search improved on greedy
+type SearchResult struct {
+	Baseline    int64 // greedy score: profit plus any aging boosts
+	Profit      int64 // best score found
+	Iterations  int
+	Accepted    int // moves taken, including worsening ones
+	Improved    int // moves that set a new best
+	BudgetSpent bool
+}
+
+// Improvement returns Profit - Baseline
+func (r *SearchResult) Improvement() int64 { return r.Profit - r.Baseline }
+
+// selectAnneal packs greedily, then runs simulated annealing over the set
+// of included transactions: each move inserts a random excluded candidate,
+// evicting whatever conflicts with it and random transactions until it
+// fits, and is kept if it gains profit or, with a probability that cools
+// over the run, even if it loses some. Required and lane transactions are
+// never evicted. The RNG is seeded, so a run is reproduced by stopping
+// after the same number of iterations.
+func (p *TxPool) selectAnneal(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	cfg := p.Search
+	start := time.Now()
+	greedy, err := p.selectGreedy(ctx, gasLimit, best)
+	if err != nil {
+		return nil, err
+	}
+
+	fixed := map[string]bool{}
+	prefix := 0
+	for _, tx := range greedy {
+		if !slices.Contains(p.Required, tx.Hash) && !p.inLane(tx) {
+			break
+		}
+		fixed[tx.Hash] = true
+		prefix++
+	}
+
+	merged, sandwiches := p.matchedBundles()
+	candidates := slices.Concat(p.Heap.TxHeap, merged)
+	bundles := slices.Concat(merged, sandwiches)
+	// Heap layout depends on insertion order; hash order doesn't
+	slices.SortFunc(candidates, func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) })
+
+	s := &searchState{in: map[string]*Transaction{}, graph: p.conflictGraph(bundles...), pool: p}
+	for _, tx := range greedy {
+		s.add(tx)
+	}
+	res := &SearchResult{Baseline: s.profit, Profit: s.profit}
+	bestSet := slices.Clone(greedy)
+	if len(candidates) == 0 || cfg.Iterations <= 0 {
+		p.lastSearch.Store(res)
+		return greedy, nil
+	}
+
+	mean := 0.0
+	for _, tx := range candidates {
+		mean += float64(tx.Score())
+	}
+	t0 := cfg.Temperature * mean / float64(len(candidates))
+	rng := rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x5bd1e995))
+	budget := time.Duration(cfg.Budget)
+
+	for i := 0; i < cfg.Iterations; i++ {
+		if cfg.stopAfter > 0 && i >= cfg.stopAfter {
+			break
+		}
+		if i%64 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if budget > 0 && time.Since(start) > budget {
+				res.BudgetSpent = true
+				break
+			}
+		}
+		res.Iterations++
+		cand := candidates[rng.IntN(len(candidates))]
+		if s.in[cand.Hash] != nil || cand.GasLimit > gasLimit {
+			continue
+		}
+		evict, ok := s.evictionsFor(cand, gasLimit, fixed, rng)
+		if !ok {
+			continue
+		}
+		delta := cand.Score()
+		for _, tx := range evict {
+			delta -= tx.Score()
+		}
+		temp := t0 * (1 - float64(i)/float64(cfg.Iterations))
+		if delta < 0 && (temp <= 0 || rng.Float64() >= math.Exp(float64(delta)/temp)) {
+			continue
+		}
+		for _, tx := range evict {
+			s.remove(tx)
+		}
+		s.add(cand)
+		res.Accepted++
+		if s.profit > res.Profit {
+			res.Profit = s.profit
+			res.Improved++
+			bestSet = s.block(greedy[:prefix])
+			if best != nil {
+				best.Offer(bestSet)
+			}
+		}
+	}
+	p.lastSearch.Store(res)
+	return bestSet, nil
+}
+
+// inLane reports whether tx matches any priority lane
+func (p *TxPool) inLane(tx *Transaction) bool {
+	return p.inRevealed(tx) || slices.Contains(p.Committed, tx.Hash) || p.inExpress(tx) || slices.ContainsFunc(p.Lanes, func(l *Lane) bool { return l.Match(tx) })
+}
+
+// searchState is the block under local search
+type searchState struct {
+	in     map[string]*Transaction
+	order  []*Transaction
+	gas    int64 // estimated gas of the set
+	profit int64
+	graph  *ConflictGraph
+	pool   *TxPool // read-locked, for senders' lower nonces
+}
+
+func (s *searchState) add(tx *Transaction) {
+	s.in[tx.Hash] = tx
+	s.order = append(s.order, tx)
+	s.gas += tx.PackGas()
+	s.profit += tx.Score()
+}
+
+func (s *searchState) remove(tx *Transaction) {
+	delete(s.in, tx.Hash)
+	s.order = slices.DeleteFunc(s.order, func(o *Transaction) bool { return o == tx })
+	s.gas -= tx.PackGas()
+	s.profit -= tx.Score()
+}
+
+// evictionsFor returns the included transactions that must go for cand to
+// fit: those conflicting with it, then random ones until the gas fits,
+// each with its sender's later nonces unless cand takes its place. It
+// fails if a fixed transaction would have to go, if cand would run without
+// a lower nonce of its sender, or if it would still take its sender or
+// contract over its gas quota.
+func (s *searchState) evictionsFor(cand *Transaction, gasLimit int64, fixed map[string]bool, rng *rand.Rand) ([]*Transaction, bool) {
+	var evict []*Transaction
+	out := map[string]bool{}
+	freed := int64(0)
+	drop := func(tx *Transaction) bool {
+		txs := s.withLater(tx, cand)
+		if slices.ContainsFunc(txs, func(t *Transaction) bool { return fixed[t.Hash] }) {
+			return false
+		}
+		for _, t := range txs {
+			if !out[t.Hash] {
+				evict = append(evict, t)
+				out[t.Hash] = true
+				freed += t.PackGas()
+			}
+		}
+		return true
+	}
+	for id := range s.graph.Neighbors(cand.Hash) {
+		if tx := s.in[id]; tx != nil && !drop(tx) {
+			return nil, false
+		}
+	}
+	for s.gas-freed+cand.PackGas()+s.headroom(cand, out) > gasLimit {
+		if len(out) >= len(s.order)-len(fixed) {
+			return nil, false
+		}
+		tx := s.order[rng.IntN(len(s.order))]
+		if fixed[tx.Hash] || out[tx.Hash] {
+			continue
+		}
+		if !drop(tx) {
+			return nil, false
+		}
+	}
+	if s.gapped(cand, out) {
+		return nil, false
+	}
+	if q := s.graph.quotas; q != nil && q.over(cand, func(hash string) bool { return s.in[hash] != nil && !out[hash] }, gasLimit) {
+		return nil, false
+	}
+	return evict, true
+}
+
+// withLater returns tx and the included transactions of its sender at
+// later nonces, which can't run without it unless cand takes its nonce
+func (s *searchState) withLater(tx, cand *Transaction) []*Transaction {
+	txs := []*Transaction{tx}
+	if tx.From == "" || len(tx.Bundle) > 0 || slotOf(cand) == slotOf(tx) {
+		return txs
+	}
+	for _, t := range s.order {
+		if t.From == tx.From && t.Nonce > tx.Nonce && len(t.Bundle) == 0 {
+			txs = append(txs, t)
+		}
+	}
+	return txs
+}
+
+// gapped reports whether cand would run without a lower pooled nonce of
+// its sender once out are evicted
+func (s *searchState) gapped(cand *Transaction, out map[string]bool) bool {
+	lower := s.pool.lowerNonces(cand)
+	if len(lower) == 0 || len(cand.Bundle) > 0 {
+		return false
+	}
+	filled := map[nonceSlot]bool{}
+	for _, tx := range s.order {
+		if !out[tx.Hash] {
+			filled[slotOf(tx)] = true
+		}
+	}
+	for _, t := range lower {
+		if !filled[slotOf(t)] {
+			return true
+		}
+	}
+	return false
+}
+
+// headroom is the gas to keep free on top of the estimated gas of the set
+// with cand in and out evicted. Sets are counted on estimated gas but
+// reordered freely, so room is kept for the largest gap between a gas limit
+// and its estimate; then every transaction's limit fits wherever it lands.
+func (s *searchState) headroom(cand *Transaction, out map[string]bool) int64 {
+	h := cand.GasLimit - cand.PackGas()
+	for _, tx := range s.order {
+		if !out[tx.Hash] {
+			h = max(h, tx.GasLimit-tx.PackGas())
+		}
+	}
+	return h
+}
+
+// block returns the state as a block: the fixed prefix, then the rest by
+// descending score
+func (s *searchState) block(prefix []*Transaction) []*Transaction {
+	rest := make([]*Transaction, 0, len(s.order))
+	for _, tx := range s.order {
+		if !slices.Contains(prefix, tx) {
+			rest = append(rest, tx)
+		}
+	}
+	slices.SortStableFunc(rest, func(a, b *Transaction) int { return cmpScore(b, a) })
+	return append(slices.Clone(prefix), rest...)
+}
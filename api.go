@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// maxSubmissionSize bounds the body of a private submission
+const maxSubmissionSize = 1 << 20
+
+// APIServer exposes the pool over HTTP:
+//
+//	GET  /pool       public pooled transactions; private ones are never listed
+//	POST /private    submit private transactions (bearer token required)
+//	POST /bundle     submit a searcher bundle, which may reference MEV-Share
+//	                 hints by hash (bearer token required)
+//	POST /bundle/cancel
+//	                 withdraw the bundle a searcher submitted under a
+//	                 replacementUuid (bearer token required)
+//	GET  /searchers  searcher reputations, or with ?searcher= one searcher's
+//	POST /searchers/reset
+//	                 forget a searcher's history (bearer token required)
+//	GET  /snapshot   the whole pool, private transactions included, as
+//	                 the snapshot command writes it (bearer token required)
+//	GET  /ws/blocks  WebSocket pushing every built block candidate as JSON,
+//	                 if Feed is set
+//	GET  /metrics    pool size, memory and heap operation latencies,
+//	                 scan budget hits, shadow-mode comparisons and
+//	                 transaction lifecycle
+//	                 aggregates in the Prometheus text format, if Shadow or
+//	                 Lifecycle is set
+//	GET  /lifecycle  inclusion and churn aggregates, or with ?hash= one
+//	                 transaction's lifecycle, if Lifecycle is set
+//	GET  /express    the express lane auction: the round, its holder and
+//	                 the bids so far for the next, if Express is set
+//	POST /express/bid
+//	                 bid for the next round's express lane (bearer token
+//	                 required), if Express is set
+//	GET  /encrypted  encrypted transactions awaiting keys and the decrypted
+//	                 ones, if Encrypted is set
+//	POST /encrypted/submit
+//	                 hold an encrypted transaction for its key (bearer
+//	                 token required), if Encrypted is set
+//	POST /encrypted/key
+//	                 decrypt the transactions waiting for a released key
+//	                 (bearer token required), if Encrypted is set
+//	GET  /commits    the commit-reveal experiment's rounds, if CommitReveal
+//	                 is set
+//	POST /commit     commit to a transaction by hash (bearer token
+//	                 required), if CommitReveal is set
+//	POST /reveal     reveal a committed transaction (bearer token
+//	                 required), if CommitReveal is set
+//	POST /rpc        JSON-RPC for wallets: eth_sendRawTransaction into the
+//	                 pool, other eth_ calls proxied upstream, if Ingress is set
+//
+// With GRPC set it also answers gRPC calls to the Builder service of
+// builder.proto on the same listener.
+//
+// With Dashboard set it also serves a web dashboard of the pool, the last
+// built block and recent bids at /dashboard, which polls its data from
+// /dashboard/state.
+//
+// With Debug set it also serves the net/http/pprof profiles under
+// /debug/pprof/ and runtime and pool statistics at /debug/pool. Those are
+// unauthenticated, so only enable them on a private listener.
+type APIServer struct {
+	Pool         Mempool
+	Hints        *HintBook
+	Feed         *BuildFeed        // block candidates pushed to /ws/blocks; nil disables it
+	Relays       *Submitter        // bid submission metrics for /debug/pool; may be nil
+	Shadow       *Shadow           // shadow comparisons for /metrics; nil disables it
+	Lifecycle    *Lifecycle        // transaction lifecycles for /lifecycle and /metrics; nil disables them
+	Heap         *HeapMetrics      // pool heap operation timings for /metrics and /debug/pool; nil disables them
+	Scans        *ScanStats        // budgeted packer scans for /metrics; nil disables them
+	Breakers     []*CircuitBreaker // RPC endpoint health for /metrics and /debug/pool
+	Dashboard    *Dashboard        // serves the web dashboard if set
+	Ingress      *Ingress          // serves the JSON-RPC endpoint at /rpc if set
+	Express      *ExpressLane      // takes express lane bids at /express if set
+	Encrypted    *EncryptedPool    // takes encrypted transactions at /encrypted if set
+	CommitReveal *CommitReveal     // takes commitments and reveals at /commit and /reveal if set
+	GRPC         bool
+	Debug        bool
+	Tokens       []string      // accepted bearer tokens for /private and /bundle
+	PrivateTTL   time.Duration // how long private transactions are kept
+}
+
+// NewAPIServer returns a server over pool configured from cfg
+func NewAPIServer(pool Mempool, hints *HintBook, cfg PrivateConfig) *APIServer {
+	return &APIServer{Pool: pool, Hints: hints, Tokens: cfg.Tokens, PrivateTTL: time.Duration(cfg.TTL)}
+}
+
+// Handler returns the HTTP handler serving the API
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pool", allowMethod(http.MethodGet, s.handlePool))
+	mux.HandleFunc("/private", allowMethod(http.MethodPost, s.handlePrivate))
+	mux.HandleFunc("/bundle", allowMethod(http.MethodPost, s.handleBundle))
+	mux.HandleFunc("/bundle/cancel", allowMethod(http.MethodPost, s.handleCancelBundle))
+	mux.HandleFunc("/searchers", allowMethod(http.MethodGet, s.handleSearchers))
+	mux.HandleFunc("/searchers/reset", allowMethod(http.MethodPost, s.handleResetSearcher))
+	mux.HandleFunc("/snapshot", allowMethod(http.MethodGet, s.handleSnapshot))
+	if s.Encrypted != nil {
+		mux.HandleFunc("/encrypted", allowMethod(http.MethodGet, s.handleEncrypted))
+		mux.HandleFunc("/encrypted/submit", allowMethod(http.MethodPost, s.handleEncryptedSubmit))
+		mux.HandleFunc("/encrypted/key", allowMethod(http.MethodPost, s.handleEncryptedKey))
+	}
+	if s.CommitReveal != nil {
+		mux.HandleFunc("/commits", allowMethod(http.MethodGet, s.handleCommits))
+		mux.HandleFunc("/commit", allowMethod(http.MethodPost, s.handleCommit))
+		mux.HandleFunc("/reveal", allowMethod(http.MethodPost, s.handleReveal))
+	}
+	if s.Express != nil {
+		mux.HandleFunc("/express", allowMethod(http.MethodGet, s.handleExpress))
+		mux.HandleFunc("/express/bid", allowMethod(http.MethodPost, s.handleExpressBid))
+	}
+	if s.Ingress != nil {
+		mux.HandleFunc("/rpc", allowMethod(http.MethodPost, s.Ingress.ServeHTTP))
+	}
+	if s.Dashboard != nil {
+		mux.HandleFunc("/dashboard", allowMethod(http.MethodGet, s.handleDashboard))
+		mux.HandleFunc("/dashboard/state", allowMethod(http.MethodGet, s.handleDashboardState))
+	}
+	if s.Lifecycle != nil {
+		mux.HandleFunc("/lifecycle", allowMethod(http.MethodGet, s.handleLifecycle))
+	}
+	mux.HandleFunc("/metrics", allowMethod(http.MethodGet, s.handleMetrics))
+	if s.Feed != nil {
+		mux.HandleFunc("/ws/blocks", allowMethod(http.MethodGet, s.handleBlocksWS))
+	}
+	if s.Debug {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		mux.HandleFunc("/debug/pool", allowMethod(http.MethodGet, s.handleDebugPool))
+	}
+	if !s.GRPC {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPC(r) {
+			s.handleGRPC(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// allowMethod answers 405 to requests with any other method
+func allowMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// protoContentType selects the builder.proto encoding over JSON, for
+// exchanging transactions between engine instances
+const protoContentType = "application/x-protobuf"
+
+func (s *APIServer) handlePool(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), protoContentType) {
+		w.Header().Set("Content-Type", protoContentType)
+		w.Write(MarshalTransactionList(s.Pool.PublicTxs()))
+		return
+	}
+	writeHTTPJSON(w, s.Pool.PublicTxs())
+}
+
+func (s *APIServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	writeHTTPJSON(w, s.Pool.Snapshot())
+}
+
+// PrivateResult reports what happened to one submitted transaction
+type PrivateResult struct {
+	Hash   string `json:"hash"`
+	Result string `json:"result"` // added, replaced or rejected
+}
+
+// handlePrivate accepts one transaction object or an array of them, in the
+// format written by the fetch command, or a protobuf TransactionList
+func (s *APIServer) handlePrivate(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.backpressure(w) {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var txs []*Transaction
+	if r.Header.Get("Content-Type") == protoContentType {
+		if txs, err = UnmarshalTransactionList(body); err == nil {
+			err = checkSubmission(txs)
+		}
+	} else {
+		txs, err = decodeSubmission(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]PrivateResult, len(txs))
+	for i, tx := range txs {
+		tx.ReceivedAt = received
+		results[i] = PrivateResult{Hash: tx.Hash, Result: s.Pool.AddPrivate(tx, s.PrivateTTL).String()}
+	}
+	writeHTTPJSON(w, results)
+}
+
+func (s *APIServer) handleBundle(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var bundle Bundle
+	if r.Header.Get("Content-Type") == protoContentType {
+		err = bundle.UnmarshalProto(body)
+	} else {
+		err = json.Unmarshal(body, &bundle)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.Hints.AddBundle(&bundle); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHTTPJSON(w, map[string]string{"id": bundle.ID})
+}
+
+func (s *APIServer) handleCancelBundle(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var c BundleCancellation
+	if r.Header.Get("Content-Type") == protoContentType {
+		err = c.UnmarshalProto(body)
+	} else {
+		err = json.Unmarshal(body, &c)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.Hints.CancelBundle(c.Searcher, c.ReplacementUUID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeHTTPJSON(w, map[string]string{"id": id})
+}
+
+// authorized checks the bearer token in constant time
+func (s *APIServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, t := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSubmission(body []byte) ([]*Transaction, error) {
+	var txs []*Transaction
+	if err := json.Unmarshal(body, &txs); err != nil {
+		var tx Transaction
+		if err := json.Unmarshal(body, &tx); err != nil {
+			return nil, fmt.Errorf("expected a transaction object or array: %w", err)
+		}
+		txs = []*Transaction{&tx}
+	}
+	if err := checkSubmission(txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// checkSubmission validates submitted transactions, over HTTP or gRPC
+func checkSubmission(txs []*Transaction) error {
+	for i, tx := range txs {
+		if tx == nil || tx.Hash == "" {
+			return fmt.Errorf("transaction %d: missing hash", i)
+		}
+		if tx.GasLimit <= 0 {
+			return fmt.Errorf("transaction %d: gasLimit must be positive", i)
+		}
+		if tx.ConflictsWith == nil {
+			tx.ConflictsWith = []string{}
+		}
+	}
+	if len(txs) == 0 {
+		return errors.New("no transactions submitted")
+	}
+	return nil
+}
+
+// writeHTTPJSON writes v as a JSON response body
+func writeHTTPJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// attributesKept is how many parents' payload attributes an AttributesBook
+// remembers; only the latest few heads are ever built on
+const attributesKept = 64
+
+// PayloadAttributes are what the consensus layer fixes about the next
+// block (Engine API PayloadAttributesV3) and the parent it is built on. A
+// candidate block is assembled against them rather than guessed values, so
+// its withdrawals, randomness and beacon root match what the proposer
+// will seal.
+type PayloadAttributes struct {
+	ParentHash            Hash         `json:"parentHash"`
+	Timestamp             HexUint64    `json:"timestamp"`
+	PrevRandao            Hash         `json:"prevRandao"`
+	SuggestedFeeRecipient Address      `json:"suggestedFeeRecipient"`
+	Withdrawals           []Withdrawal `json:"withdrawals"`
+	ParentBeaconBlockRoot *Hash        `json:"parentBeaconBlockRoot,omitempty"`
+}
+
+// DefaultPayloadAttributes returns the attributes built with when the
+// consensus layer hasn't announced any: the current time, no randomness,
+// withdrawals or beacon root, and feeRecipient
+func DefaultPayloadAttributes(parent *Header, feeRecipient Address) (*PayloadAttributes, error) {
+	parentHash, err := HexToHash(parent.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("parent hash: %w", err)
+	}
+	// Blocks must be strictly later than their parent
+	timestamp := max(uint64(time.Now().Unix()), parent.Timestamp+1)
+	return &PayloadAttributes{ParentHash: parentHash, Timestamp: HexUint64(timestamp), SuggestedFeeRecipient: feeRecipient}, nil
+}
+
+// Check fails unless a's block can be built on parent: it must name parent
+// and be strictly later
+func (a *PayloadAttributes) Check(parent *Header) error {
+	if !strings.EqualFold(a.ParentHash.Hex(), parent.Hash) {
+		return fmt.Errorf("payload attributes are for parent %s, not %s", a.ParentHash.Hex(), parent.Hash)
+	}
+	if uint64(a.Timestamp) <= parent.Timestamp {
+		return fmt.Errorf("payload timestamp %d is not after parent timestamp %d", a.Timestamp, parent.Timestamp)
+	}
+	return nil
+}
+
+// LoadPayloadAttributes reads attributes from a JSON file
+func LoadPayloadAttributes(path string) (*PayloadAttributes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a PayloadAttributes
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &a, nil
+}
+
+// beaconPayloadAttributes is the data of a beacon node payload_attributes
+// event, whose numbers are decimal strings
+type beaconPayloadAttributes struct {
+	Data struct {
+		ParentBlockHash   Hash `json:"parent_block_hash"`
+		PayloadAttributes struct {
+			Timestamp             uint64  `json:"timestamp,string"`
+			PrevRandao            Hash    `json:"prev_randao"`
+			SuggestedFeeRecipient Address `json:"suggested_fee_recipient"`
+			Withdrawals           []struct {
+				Index          uint64  `json:"index,string"`
+				ValidatorIndex uint64  `json:"validator_index,string"`
+				Address        Address `json:"address"`
+				Amount         uint64  `json:"amount,string"`
+			} `json:"withdrawals"`
+			ParentBeaconBlockRoot *Hash `json:"parent_beacon_block_root"`
+		} `json:"payload_attributes"`
+	} `json:"data"`
+}
+
+func (e *beaconPayloadAttributes) attributes() *PayloadAttributes {
+	pa := &e.Data.PayloadAttributes
+	a := &PayloadAttributes{
+		ParentHash:            e.Data.ParentBlockHash,
+		Timestamp:             HexUint64(pa.Timestamp),
+		PrevRandao:            pa.PrevRandao,
+		SuggestedFeeRecipient: pa.SuggestedFeeRecipient,
+		Withdrawals:           make([]Withdrawal, len(pa.Withdrawals)),
+		ParentBeaconBlockRoot: pa.ParentBeaconBlockRoot,
+	}
+	for i, w := range pa.Withdrawals {
+		a.Withdrawals[i] = Withdrawal{Index: HexUint64(w.Index), ValidatorIndex: HexUint64(w.ValidatorIndex), Address: w.Address, Amount: HexUint64(w.Amount)}
+	}
+	return a
+}
+
+// AttributesBook keeps the payload attributes a beacon node announces,
+// by the parent they build on. Its methods are safe for concurrent use.
+type AttributesBook struct {
+	URL  string // beacon node API base URL
+	HTTP *http.Client
+
+	mu       sync.Mutex
+	byParent map[Hash]*PayloadAttributes
+	order    []Hash // parents in arrival order, oldest first
+}
+
+func NewAttributesBook(url string) *AttributesBook {
+	return &AttributesBook{URL: strings.TrimSuffix(url, "/"), HTTP: httpClient, byParent: make(map[Hash]*PayloadAttributes)}
+}
+
+// Add records a, replacing earlier attributes for its parent and
+// forgetting the oldest parents past attributesKept
+func (b *AttributesBook) Add(a *PayloadAttributes) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.byParent[a.ParentHash]; !ok {
+		b.order = append(b.order, a.ParentHash)
+	}
+	b.byParent[a.ParentHash] = a
+	for len(b.order) > attributesKept {
+		delete(b.byParent, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// For returns the attributes announced for building on parent, or nil
+func (b *AttributesBook) For(parent string) *PayloadAttributes {
+	hash, err := HexToHash(parent)
+	if err != nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.byParent[hash]
+}
+
+// Subscribe streams the beacon node's payload_attributes events to fn
+// until ctx is cancelled or the stream ends, in which case it returns
+// io.EOF. Events that don't decode are skipped.
+func (b *AttributesBook) Subscribe(ctx context.Context, fn func(*PayloadAttributes)) error {
+	return subscribeEvents(ctx, b.HTTP, b.URL+"/eth/v1/events?topics=payload_attributes", func(data []byte) {
+		var e beaconPayloadAttributes
+		if json.Unmarshal(data, &e) == nil && e.Data.PayloadAttributes.Timestamp != 0 {
+			fn(e.attributes())
+		}
+	})
+}
+
+// PayloadAttributes returns the attributes to build head's child with:
+// those the beacon node announced for head if there are any, else
+// defaults. The block pays recipient unless it is zero, when announced
+// attributes keep their suggested fee recipient.
+func (e *Env) PayloadAttributes(head *Header, recipient Address) (*PayloadAttributes, error) {
+	if e.Attributes != nil {
+		if a := e.Attributes.For(head.Hash); a != nil {
+			attrs := *a
+			if !recipient.IsZero() {
+				attrs.SuggestedFeeRecipient = recipient
+			}
+			return &attrs, nil
+		}
+		fmt.Fprintf(e.Out, "No payload attributes announced for %s, building with defaults\n", head.Hash)
+	}
+	return DefaultPayloadAttributes(head, recipient)
+}
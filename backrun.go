@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Uniswap V2 style router and pair selectors
+var (
+	selectorSwapExactTokensForTokens = []byte{0x38, 0xed, 0x17, 0x39}
+	selectorGetReserves              = []byte{0x09, 0x02, 0xf1, 0xac}
+)
+
+// DEXPool is a constant-product pair the backrun analyzer knows about
+type DEXPool struct {
+	Address Address
+	Router  Address // router whose swaps trade against this pair
+	Token0  Address
+	Token1  Address
+	FeeBps  int64
+
+	reserve0, reserve1 *big.Int
+}
+
+// BackrunAnalyzer looks for large pending swaps through known routers and
+// prices the arbitrage left behind between pools of the same pair. The
+// value of the best backrun is credited to the swap's MEVBonus, since
+// including the swap is what opens the opportunity.
+type BackrunAnalyzer struct {
+	RPC     *RPCClient
+	Pools   []*DEXPool
+	WBERA   Address  // profits are measured in this token
+	MinSwap *big.Int // smallest amountIn worth simulating
+}
+
+// NewBackrunAnalyzer returns an analyzer configured from cfg, or nil if
+// backrun detection is disabled
+func NewBackrunAnalyzer(rpc *RPCClient, cfg BackrunConfig) (*BackrunAnalyzer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	a := &BackrunAnalyzer{RPC: rpc, MinSwap: big.NewInt(cfg.MinSwap)}
+	var err error
+	if a.WBERA, err = HexToAddress(cfg.WBERA); err != nil {
+		return nil, fmt.Errorf("backrun.wbera: %w", err)
+	}
+	for i, pc := range cfg.Pools {
+		p := &DEXPool{FeeBps: pc.FeeBps}
+		for _, f := range []struct {
+			dst *Address
+			s   string
+		}{{&p.Address, pc.Address}, {&p.Router, pc.Router}, {&p.Token0, pc.Token0}, {&p.Token1, pc.Token1}} {
+			if *f.dst, err = HexToAddress(f.s); err != nil {
+				return nil, fmt.Errorf("backrun.pools[%d]: %w", i, err)
+			}
+		}
+		a.Pools = append(a.Pools, p)
+	}
+	return a, nil
+}
+
+// Swap is a decoded swapExactTokensForTokens call along a single pair
+type Swap struct {
+	AmountIn *big.Int
+	TokenIn  Address
+	TokenOut Address
+}
+
+// DecodeSwap decodes router calldata for a direct two-token
+// swapExactTokensForTokens, returning false for anything else
+func DecodeSwap(input []byte) (*Swap, bool) {
+	if len(input) < 4+5*32 || !bytes.Equal(input[:4], selectorSwapExactTokensForTokens) {
+		return nil, false
+	}
+	args := input[4:]
+	word := func(i int) []byte { return args[i*32 : (i+1)*32] }
+	offset := new(big.Int).SetBytes(word(2))
+	if !offset.IsInt64() || offset.Int64()%32 != 0 || offset.Int64()/32+3 > int64(len(args)/32) {
+		return nil, false
+	}
+	at := int(offset.Int64() / 32)
+	if n := new(big.Int).SetBytes(word(at)); !n.IsInt64() || n.Int64() != 2 {
+		return nil, false
+	}
+	s := &Swap{AmountIn: new(big.Int).SetBytes(word(0))}
+	copy(s.TokenIn[:], word(at + 1)[12:])
+	copy(s.TokenOut[:], word(at + 2)[12:])
+	return s, true
+}
+
+// Analyze fetches the reserves of every known pool, simulates each large
+// pending swap and credits its backrun value to the swap's MEVBonus. It
+// returns the number of opportunities found.
+func (a *BackrunAnalyzer) Analyze(ctx context.Context, pool Mempool) (found int, err error) {
+	ctx, span := StartSpan(ctx, "backrun.simulate", "backrun.pools", len(a.Pools))
+	defer func() {
+		span.SetAttr("backrun.found", found)
+		span.SetError(err)
+		span.End()
+	}()
+	if err := a.fetchReserves(ctx); err != nil {
+		return 0, err
+	}
+	for _, tx := range pool.Txs() {
+		swap, ok := DecodeSwap(tx.Input)
+		if !ok || swap.AmountIn.Cmp(a.MinSwap) < 0 {
+			continue
+		}
+		to, err := HexToAddress(tx.To)
+		if err != nil {
+			continue
+		}
+		value := a.backrunValue(to, swap)
+		if value <= 0 {
+			continue
+		}
+		credited := *tx
+		credited.MEVBonus = tx.MEVBonus + value
+		pool.AddTx(&credited)
+		found++
+	}
+	return found, nil
+}
+
+func (a *BackrunAnalyzer) fetchReserves(ctx context.Context) error {
+	results := make([]string, len(a.Pools))
+	elems := make([]BatchElem, len(a.Pools))
+	for i, p := range a.Pools {
+		call := map[string]string{"to": p.Address.Hex(), "data": EncodeHexBytes(selectorGetReserves)}
+		elems[i] = BatchElem{Method: "eth_call", Params: []any{call, "latest"}, Result: &results[i]}
+	}
+	if err := a.RPC.BatchCall(ctx, elems); err != nil {
+		return err
+	}
+	for i, p := range a.Pools {
+		p.reserve0, p.reserve1 = nil, nil
+		if elems[i].Error != nil {
+			continue
+		}
+		data, err := ParseHexBytes(results[i])
+		if err != nil || len(data) < 64 {
+			continue
+		}
+		p.reserve0 = new(big.Int).SetBytes(data[:32])
+		p.reserve1 = new(big.Int).SetBytes(data[32:64])
+	}
+	return nil
+}
+
+// backrunValue simulates swap on the router's pool for its pair and
+// returns the WBERA profit of the best arbitrage against another pool of
+// the same pair, or 0
+func (a *BackrunAnalyzer) backrunValue(router Address, swap *Swap) int64 {
+	if swap.TokenIn != a.WBERA && swap.TokenOut != a.WBERA {
+		return 0
+	}
+	var hit *DEXPool
+	for _, p := range a.Pools {
+		if p.Router == router && p.hasPair(swap.TokenIn, swap.TokenOut) && p.reserve0 != nil {
+			hit = p
+			break
+		}
+	}
+	if hit == nil {
+		return 0
+	}
+	// Reserves of the victim pool after the swap, as (WBERA, other token)
+	w1, t1 := hit.reserves(a.WBERA)
+	w1, t1 = new(big.Int).Set(w1), new(big.Int).Set(t1)
+	if swap.TokenIn == a.WBERA {
+		out := amountOut(swap.AmountIn, w1, t1, hit.FeeBps)
+		w1.Add(w1, swap.AmountIn)
+		t1.Sub(t1, out)
+	} else {
+		out := amountOut(swap.AmountIn, t1, w1, hit.FeeBps)
+		t1.Add(t1, swap.AmountIn)
+		w1.Sub(w1, out)
+	}
+
+	best := int64(0)
+	for _, p := range a.Pools {
+		if p == hit || !p.hasPair(swap.TokenIn, swap.TokenOut) || p.reserve0 == nil {
+			continue
+		}
+		w2, t2 := p.reserves(a.WBERA)
+		// Buy the other token where it is cheap, sell where it is dear
+		profit := bestArbitrage(w1, t1, hit.FeeBps, w2, t2, p.FeeBps)
+		if alt := bestArbitrage(w2, t2, p.FeeBps, w1, t1, hit.FeeBps); alt > profit {
+			profit = alt
+		}
+		best = max(best, profit)
+	}
+	return best
+}
+
+func (p *DEXPool) hasPair(a, b Address) bool {
+	return (p.Token0 == a && p.Token1 == b) || (p.Token0 == b && p.Token1 == a)
+}
+
+// reserves returns the pool reserves ordered as (token, other token)
+func (p *DEXPool) reserves(token Address) (*big.Int, *big.Int) {
+	if p.Token0 == token {
+		return p.reserve0, p.reserve1
+	}
+	return p.reserve1, p.reserve0
+}
+
+// amountOut is the constant-product output for amountIn after the fee
+func amountOut(amountIn, reserveIn, reserveOut *big.Int, feeBps int64) *big.Int {
+	in := new(big.Int).Mul(amountIn, big.NewInt(10000-feeBps))
+	num := new(big.Int).Mul(in, reserveOut)
+	den := new(big.Int).Mul(reserveIn, big.NewInt(10000))
+	den.Add(den, in)
+	if den.Sign() == 0 {
+		return new(big.Int)
+	}
+	return num.Div(num, den)
+}
+
+// bestArbitrage returns the WBERA profit of spending WBERA on pool 1
+// (reserves wa, ta) and selling the proceeds on pool 2 (wb, tb) at the
+// optimal size. The two hops compose into one constant-product curve, whose
+// optimum is found in closed form and then priced exactly.
+func bestArbitrage(wa, ta *big.Int, feeA int64, wb, tb *big.Int, feeB int64) int64 {
+	f := func(x *big.Int) float64 { v, _ := new(big.Float).SetInt(x).Float64(); return v }
+	ra, rb := float64(10000-feeA)/10000, float64(10000-feeB)/10000
+	// Composite reserves of WBERA -> token (pool 1) -> WBERA (pool 2)
+	den := f(tb) + rb*f(ta)
+	if den <= 0 {
+		return 0
+	}
+	ein := f(wa) * f(tb) / den
+	eout := rb * f(ta) * f(wb) / den
+	x := (math.Sqrt(ein*eout*ra) - ein) / ra
+	if !(x > 0) || math.IsInf(x, 0) {
+		return 0
+	}
+	in, _ := big.NewFloat(x).Int(nil)
+	mid := amountOut(in, wa, ta, feeA)
+	out := amountOut(mid, tb, wb, feeB)
+	profit := out.Sub(out, in)
+	if profit.Sign() <= 0 {
+		return 0
+	}
+	if !profit.IsInt64() {
+		return math.MaxInt64
+	}
+	return profit.Int64()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Archive holds recorded pool states, from snapshots and build traces, by
+// the number of the block they were captured to build
+type Archive map[uint64]*Snapshot
+
+// LoadArchive reads every snapshot and trace among the .json files of
+// dir, skipping other files and those recorded without a block number. Of
+// several captured for the same block, the latest wins.
+func LoadArchive(dir string) (Archive, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	a := Archive{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var probe struct {
+			Txs    json.RawMessage `json:"txs"`
+			Inputs json.RawMessage `json:"inputs"`
+		}
+		if json.Unmarshal(data, &probe) != nil {
+			continue
+		}
+		var snap *Snapshot
+		switch {
+		case probe.Txs != nil:
+			snap, err = LoadSnapshot(path)
+		case probe.Inputs != nil:
+			var t *Trace
+			if t, err = LoadTrace(path); err == nil {
+				snap = t.Snapshot()
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if old := a[snap.Block]; snap.Block > 0 && (old == nil || snap.CreatedAt.After(old.CreatedAt)) {
+			a[snap.Block] = snap
+		}
+	}
+	return a, nil
+}
+
+// Snapshot returns the pool state the trace was built from
+func (t *Trace) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: t.CreatedAt,
+		Source:    "trace",
+		Block:     t.Block,
+		BaseFee:   t.BaseFee,
+		Txs:       slices.Concat(t.Inputs, t.Low),
+		Required:  t.Required,
+	}
+	sortByArrival(s.Txs)
+	return s
+}
+
+var backtestCommand = &Command{
+	Name:    "backtest",
+	Summary: "rebuild historical blocks and compare profit with the canonical ones",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		from := fs.Uint64("from", 0, "first block number")
+		to := fs.Uint64("to", 0, "last block number (default: -from)")
+		archive := fs.String("archive", "", "rebuild from the pools recorded in this directory of snapshots and traces (default: each block's own transactions)")
+		return func(ctx context.Context, env *Env) error {
+			if *from == 0 {
+				return errors.New("backtest requires -from")
+			}
+			var pools Archive
+			if *archive != "" {
+				var err error
+				if pools, err = LoadArchive(*archive); err != nil {
+					return fmt.Errorf("loading archive: %w", err)
+				}
+			}
+			strategy := env.Config.Builder.Strategy
+			last := max(*to, *from)
+			var oursTotal, chainTotal int64
+			compared, ahead, missing := 0, 0, 0
+			for n := *from; n <= last; n++ {
+				header, txs, malformed, err := FetchBlock(ctx, env.RPC, n)
+				if err != nil {
+					return fmt.Errorf("block %d: %w", n, err)
+				}
+				pool := env.NewPool()
+				if pools != nil {
+					snap := pools[n]
+					if snap == nil {
+						missing++
+						fmt.Fprintf(env.Out, "#%d | no recorded pool\n", n)
+						continue
+					}
+					snap.Restore(pool)
+				}
+				pool.SetBaseFee(header.BaseFee)
+				chainProfit := int64(0)
+				canonical := make(map[string]bool, len(txs))
+				for _, tx := range txs {
+					if pools == nil {
+						pool.AddTx(tx)
+					}
+					tx.BaseFee = header.BaseFee // also when rejected
+					chainProfit += tx.Profit()
+					canonical[tx.Hash] = true
+				}
+				selected, err := SelectWithStrategy(ctx, pool, strategy, header.GasLimit, nil)
+				if err != nil {
+					return err
+				}
+				ourProfit, overlap := int64(0), 0
+				for _, tx := range selected {
+					ourProfit += tx.Profit()
+					if canonical[tx.Hash] {
+						overlap++
+					}
+				}
+				compared++
+				if ourProfit > chainProfit {
+					ahead++
+				}
+				oursTotal += ourProfit
+				chainTotal += chainProfit
+				fmt.Fprintf(env.Out, "#%d | txs %d/%d | overlap %d | ours %s | chain %s | skipped %d malformed\n",
+					n, len(selected), len(txs), overlap, FormatWei(ourProfit), FormatWei(chainProfit), malformed)
+			}
+			fmt.Fprintf(env.Out, "\nTotal over %d blocks: ours %s | chain %s | ours ahead in %d\n", compared, FormatWei(oursTotal), FormatWei(chainTotal), ahead)
+			if missing > 0 {
+				fmt.Fprintf(env.Out, "%d blocks had no recorded pool\n", missing)
+			}
+			return nil
+		}
+	},
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// ValueWei returns the wei tx transfers
+func (tx *Transaction) ValueWei() *big.Int {
+	if tx.Value == nil {
+		return new(big.Int)
+	}
+	return tx.Value
+}
+
+// Cost returns the most tx can take from its sender: its value plus its
+// gas limit at its gas price (the fee cap of a dynamic-fee transaction)
+func (tx *Transaction) Cost() *big.Int {
+	cost := new(big.Int).Mul(big.NewInt(tx.GasPrice), big.NewInt(tx.GasLimit))
+	return cost.Add(cost, tx.ValueWei())
+}
+
+// txSenders returns the distinct known senders of txs, in order
+func txSenders(txs []*Transaction) []string {
+	var senders []string
+	seen := make(map[string]bool)
+	for _, tx := range txs {
+		if tx.From != "" && !seen[tx.From] {
+			seen[tx.From] = true
+			senders = append(senders, tx.From)
+		}
+	}
+	return senders
+}
+
+// FetchBalances returns the latest balance of every sender of txs, fetched
+// with one batch of eth_getBalance calls
+func FetchBalances(ctx context.Context, rpc *RPCClient, txs []*Transaction) (map[string]*big.Int, error) {
+	senders := txSenders(txs)
+	if len(senders) == 0 {
+		return nil, nil
+	}
+	hexes := make([]string, len(senders))
+	elems := make([]BatchElem, len(senders))
+	for i, from := range senders {
+		elems[i] = BatchElem{Method: "eth_getBalance", Params: []any{from, "latest"}, Result: &hexes[i]}
+	}
+	if err := rpc.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+	balances := make(map[string]*big.Int, len(senders))
+	for i, from := range senders {
+		if elems[i].Error != nil {
+			return nil, fmt.Errorf("balance of %s: %w", from, elems[i].Error)
+		}
+		balance, err := ParseHexBig(hexes[i])
+		if err != nil {
+			return nil, fmt.Errorf("balance of %s: %w", from, err)
+		}
+		balances[from] = balance
+	}
+	return balances, nil
+}
+
+// SetBalances replaces the known sender balances and re-partitions every
+// sender's transactions, parking in Queued those its balance can't pay
+// for. Senders missing from balances are treated as able to pay for
+// everything.
+func (p *TxPool) SetBalances(balances map[string]*big.Int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Balances = balances
+	for from := range p.bySender {
+		p.reschedule(from)
+	}
+}
+
+// SetBalances sets the sender balances of every shard
+func (s *ShardedPool) SetBalances(balances map[string]*big.Int) {
+	for _, shard := range s.Shards {
+		shard.SetBalances(balances)
+	}
+}
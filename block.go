@@ -0,0 +1,249 @@
+package main
+
+import "fmt"
+
+// EmptyUncleHash is the hash of an empty ommers list
+var EmptyUncleHash = Keccak256(rlpList())
+
+// Log is an event emitted while executing a transaction
+type Log struct {
+	Address Address  `json:"address"`
+	Topics  []Hash   `json:"topics"`
+	Data    HexBytes `json:"data"`
+}
+
+// TxResult is the simulated outcome of a transaction at its block position
+type TxResult struct {
+	GasUsed int64  `json:"gasUsed"`
+	Status  uint64 `json:"status"` // 1 success, 0 reverted
+	Logs    []Log  `json:"logs"`
+}
+
+// Receipt is a consensus transaction receipt
+type Receipt struct {
+	Type              byte   `json:"type"`
+	Status            uint64 `json:"status"`
+	CumulativeGasUsed uint64 `json:"cumulativeGasUsed"`
+	Bloom             Bloom  `json:"logsBloom"`
+	Logs              []Log  `json:"logs"`
+}
+
+// EncodeRLP returns the consensus encoding, prefixed with the type byte for
+// typed (EIP-2718) receipts
+func (r *Receipt) EncodeRLP() []byte {
+	logs := make([][]byte, len(r.Logs))
+	for i, l := range r.Logs {
+		topics := make([][]byte, len(l.Topics))
+		for j, t := range l.Topics {
+			topics[j] = rlpBytes(t[:])
+		}
+		logs[i] = rlpList(rlpBytes(l.Address[:]), rlpList(topics...), rlpBytes(l.Data))
+	}
+	enc := rlpList(rlpUint(r.Status), rlpUint(r.CumulativeGasUsed), rlpBytes(r.Bloom[:]), rlpList(logs...))
+	if r.Type == 0 {
+		return enc
+	}
+	return append([]byte{r.Type}, enc...)
+}
+
+// Add sets the bloom bits for data (an address or topic)
+func (b *Bloom) Add(data []byte) {
+	h := Keccak256(data)
+	for i := 0; i < 6; i += 2 {
+		bit := (uint(h[i])<<8 | uint(h[i+1])) & 2047
+		b[len(b)-1-int(bit/8)] |= 1 << (bit % 8)
+	}
+}
+
+// Or merges other into b
+func (b *Bloom) Or(other *Bloom) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+// LogsBloom computes the bloom filter over logs
+func LogsBloom(logs []Log) Bloom {
+	var b Bloom
+	for _, l := range logs {
+		b.Add(l.Address[:])
+		for _, t := range l.Topics {
+			b.Add(t[:])
+		}
+	}
+	return b
+}
+
+// txType returns the EIP-2718 type of a raw transaction (0 for legacy)
+func txType(raw []byte) byte {
+	if len(raw) == 0 || raw[0] >= 0xc0 {
+		return 0
+	}
+	return raw[0]
+}
+
+// encodeWithdrawal returns the RLP encoding used in withdrawalsRoot
+func encodeWithdrawal(w *Withdrawal) []byte {
+	return rlpList(rlpUint(uint64(w.Index)), rlpUint(uint64(w.ValidatorIndex)), rlpBytes(w.Address[:]), rlpUint(uint64(w.Amount)))
+}
+
+// BlockHeader is a full execution-layer header (Cancun layout)
+type BlockHeader struct {
+	ParentHash       Hash
+	UncleHash        Hash
+	Coinbase         Address
+	StateRoot        Hash
+	TxHash           Hash
+	ReceiptHash      Hash
+	Bloom            Bloom
+	Number           uint64
+	GasLimit         uint64
+	GasUsed          uint64
+	Time             uint64
+	Extra            []byte
+	MixDigest        Hash // prevRandao
+	BaseFee          uint64
+	WithdrawalsHash  Hash
+	BlobGasUsed      uint64
+	ExcessBlobGas    uint64
+	ParentBeaconRoot *Hash // omitted from the encoding when nil
+}
+
+// EncodeRLP returns the RLP encoding of the header
+func (h *BlockHeader) EncodeRLP() []byte {
+	fields := [][]byte{
+		rlpBytes(h.ParentHash[:]),
+		rlpBytes(h.UncleHash[:]),
+		rlpBytes(h.Coinbase[:]),
+		rlpBytes(h.StateRoot[:]),
+		rlpBytes(h.TxHash[:]),
+		rlpBytes(h.ReceiptHash[:]),
+		rlpBytes(h.Bloom[:]),
+		rlpUint(0), // difficulty
+		rlpUint(h.Number),
+		rlpUint(h.GasLimit),
+		rlpUint(h.GasUsed),
+		rlpUint(h.Time),
+		rlpBytes(h.Extra),
+		rlpBytes(h.MixDigest[:]),
+		rlpBytes(make([]byte, 8)), // nonce
+		rlpUint(h.BaseFee),
+		rlpBytes(h.WithdrawalsHash[:]),
+		rlpUint(h.BlobGasUsed),
+		rlpUint(h.ExcessBlobGas),
+	}
+	if h.ParentBeaconRoot != nil {
+		fields = append(fields, rlpBytes(h.ParentBeaconRoot[:]))
+	}
+	return rlpList(fields...)
+}
+
+// Hash returns the block hash, the Keccak-256 of the encoded header
+func (h *BlockHeader) Hash() Hash {
+	return Keccak256(h.EncodeRLP())
+}
+
+// Block is an assembled block: header, body and the receipts its header
+// commits to
+type Block struct {
+	Header       *BlockHeader
+	Transactions [][]byte // raw transactions
+	Withdrawals  []Withdrawal
+	Receipts     []*Receipt
+}
+
+// EncodeRLP returns the RLP encoding of [header, transactions, ommers, withdrawals]
+func (b *Block) EncodeRLP() []byte {
+	txs := make([][]byte, len(b.Transactions))
+	for i, raw := range b.Transactions {
+		if txType(raw) == 0 {
+			txs[i] = raw // legacy transactions are embedded as RLP lists
+		} else {
+			txs[i] = rlpBytes(raw)
+		}
+	}
+	ws := make([][]byte, len(b.Withdrawals))
+	for i := range b.Withdrawals {
+		ws[i] = encodeWithdrawal(&b.Withdrawals[i])
+	}
+	return rlpList(b.Header.EncodeRLP(), rlpList(txs...), rlpList(), rlpList(ws...))
+}
+
+// AssembleBlock computes the header commitments for a block template from
+// per-transaction simulation results and fills them into its payload. With
+// nil results, each transaction is assumed to succeed using its full gas
+// limit and emit no logs. The state root can only come from execution and
+// is left as in the template.
+func AssembleBlock(t *BlockTemplate, results []TxResult) (*Block, error) {
+	p := t.Payload
+	if results != nil && len(results) != len(p.Transactions) {
+		return nil, fmt.Errorf("have %d results for %d transactions", len(results), len(p.Transactions))
+	}
+
+	receipts := make([]*Receipt, len(p.Transactions))
+	encReceipts := make([][]byte, len(p.Transactions))
+	raws := make([][]byte, len(p.Transactions))
+	var bloom Bloom
+	cumulative := uint64(0)
+	for i, raw := range p.Transactions {
+		res := TxResult{Status: 1}
+		if results != nil {
+			res = results[i]
+		} else if i < len(t.Transactions) {
+			res.GasUsed = t.Transactions[i].GasLimit
+		}
+		cumulative += uint64(res.GasUsed)
+		r := &Receipt{
+			Type:              txType(raw),
+			Status:            res.Status,
+			CumulativeGasUsed: cumulative,
+			Bloom:             LogsBloom(res.Logs),
+			Logs:              res.Logs,
+		}
+		bloom.Or(&r.Bloom)
+		receipts[i] = r
+		encReceipts[i] = r.EncodeRLP()
+		raws[i] = raw
+	}
+	if cumulative > uint64(p.GasLimit) {
+		return nil, fmt.Errorf("block uses %d gas, over its %d limit", cumulative, p.GasLimit)
+	}
+
+	encWithdrawals := make([][]byte, len(p.Withdrawals))
+	for i := range p.Withdrawals {
+		encWithdrawals[i] = encodeWithdrawal(&p.Withdrawals[i])
+	}
+
+	h := &BlockHeader{
+		ParentHash:       p.ParentHash,
+		UncleHash:        EmptyUncleHash,
+		Coinbase:         p.FeeRecipient,
+		StateRoot:        p.StateRoot,
+		TxHash:           DeriveListRoot(raws),
+		ReceiptHash:      DeriveListRoot(encReceipts),
+		Bloom:            bloom,
+		Number:           uint64(p.BlockNumber),
+		GasLimit:         uint64(p.GasLimit),
+		GasUsed:          cumulative,
+		Time:             uint64(p.Timestamp),
+		Extra:            p.ExtraData,
+		MixDigest:        p.PrevRandao,
+		BaseFee:          uint64(p.BaseFeePerGas),
+		WithdrawalsHash:  DeriveListRoot(encWithdrawals),
+		BlobGasUsed:      uint64(p.BlobGasUsed),
+		ExcessBlobGas:    uint64(p.ExcessBlobGas),
+		ParentBeaconRoot: t.ParentBeaconRoot,
+	}
+
+	p.ReceiptsRoot = h.ReceiptHash
+	p.LogsBloom = h.Bloom
+	p.GasUsed = HexUint64(h.GasUsed)
+	p.BlockHash = h.Hash()
+
+	return &Block{
+		Header:       h,
+		Transactions: raws,
+		Withdrawals:  p.Withdrawals,
+		Receipts:     receipts,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ContractBoost raises the score of transactions calling certain
+// contracts, optionally only certain functions of them, to encode value
+// fees miss: keeping a DEX's pools balanced, liquidations landing, liquid
+// staking flowing. Like aging, a boost only orders the build; it is never
+// paid, so profits and reports ignore it.
+type ContractBoost struct {
+	Config    BoostConfig
+	to        map[string]bool
+	selectors [][]byte
+}
+
+// NewContractBoosts compiles boost configs
+func NewContractBoosts(cfgs []BoostConfig) ([]*ContractBoost, error) {
+	boosts := make([]*ContractBoost, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		b := &ContractBoost{Config: cfg, to: map[string]bool{}}
+		for _, a := range cfg.To {
+			b.to[strings.ToLower(a)] = true
+		}
+		for _, s := range cfg.Selectors {
+			sel, err := ParseHexBytes(s)
+			if err != nil || len(sel) != 4 {
+				return nil, fmt.Errorf("boost %s: selector %q is not 4 bytes of hex", cfg.Name, s)
+			}
+			b.selectors = append(b.selectors, sel)
+		}
+		boosts = append(boosts, b)
+	}
+	return boosts, nil
+}
+
+// Match reports whether tx calls one of the boost's contracts and, if it
+// lists selectors, one of those functions
+func (b *ContractBoost) Match(tx *Transaction) bool {
+	if !b.to[tx.To] {
+		return false
+	}
+	return len(b.selectors) == 0 || len(tx.Input) >= 4 && slices.ContainsFunc(b.selectors, func(sel []byte) bool {
+		return bytes.Equal(tx.Input[:4], sel)
+	})
+}
+
+// contractBoost returns what the pool's boosts add to score, tx's score
+// before them. Every matching boost applies in config order: its
+// multiplier scales the score so far, then its bonus is added. The result
+// saturates at the int64 range scores are kept in.
+func (p *TxPool) contractBoost(tx *Transaction, score int64) int64 {
+	v := float64(score)
+	matched := false
+	for _, b := range p.Boosts {
+		if !b.Match(tx) {
+			continue
+		}
+		matched = true
+		if b.Config.Multiplier > 0 {
+			v *= b.Config.Multiplier
+		}
+		v += float64(b.Config.Bonus)
+	}
+	switch {
+	case !matched:
+		return 0
+	case v >= math.MaxInt64:
+		v = math.MaxInt64
+	case v <= math.MinInt64:
+		v = math.MinInt64
+	}
+	return int64(v) - score
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, without retrying, when every endpoint's
+// breaker is open, rather than spending the slot waiting on dead nodes
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker tracks the health of one RPC endpoint. Threshold failures
+// in a row (transport errors and 5xx answers; RPC errors mean the node is
+// up) open it, and calls are routed to the next endpoint whose breaker is
+// closed. Every Cooldown while open the endpoint is probed in the
+// background with eth_chainId, never with a build's own calls, and the
+// breaker closes once a probe succeeds. Its methods are safe for
+// concurrent use.
+type CircuitBreaker struct {
+	Endpoint  string
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int       // in a row
+	openedAt time.Time // or last probed; zero while closed
+	probing  bool
+	trips    int
+}
+
+// BreakerStatus is a breaker's state as served at /debug/pool
+type BreakerStatus struct {
+	Endpoint string    `json:"endpoint"`
+	State    string    `json:"state"` // closed, open or probing
+	Failures int       `json:"failures"`
+	Trips    int       `json:"trips"` // times it has opened
+	OpenedAt time.Time `json:"openedAt,omitzero"`
+}
+
+// NewCircuitBreakers returns a breaker for each endpoint, in order
+func NewCircuitBreakers(endpoints []string, threshold int, cooldown time.Duration) []*CircuitBreaker {
+	breakers := make([]*CircuitBreaker, len(endpoints))
+	for i, ep := range endpoints {
+		breakers[i] = &CircuitBreaker{Endpoint: ep, Threshold: threshold, Cooldown: cooldown}
+	}
+	return breakers
+}
+
+// allow reports whether a call may go to the endpoint at now and, if not,
+// whether it is due a probe, which the caller must then run and report to
+// probed
+func (b *CircuitBreaker) allow(now time.Time) (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.openedAt.IsZero():
+		return true, false
+	case !b.probing && now.Sub(b.openedAt) >= b.Cooldown:
+		b.probing = true
+		return false, true
+	}
+	return false, false
+}
+
+// record counts a call's outcome, opening the breaker on the Threshold-th
+// failure in a row
+func (b *CircuitBreaker) record(failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold && b.openedAt.IsZero() {
+		b.openedAt = now
+		b.trips++
+	}
+}
+
+// probed closes the breaker if the probe succeeded and otherwise waits
+// another Cooldown
+func (b *CircuitBreaker) probed(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures, b.openedAt = 0, time.Time{}
+	} else {
+		b.openedAt = now
+	}
+}
+
+// Status returns the breaker's state
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{Endpoint: b.Endpoint, State: "closed", Failures: b.failures, Trips: b.trips, OpenedAt: b.openedAt}
+	switch {
+	case b.probing:
+		s.State = "probing"
+	case !b.openedAt.IsZero():
+		s.State = "open"
+	}
+	return s
+}
+
+// endpointFailed reports whether err says the endpoint is down rather
+// than that the call was bad or the caller gave up
+func endpointFailed(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500
+	}
+	return true
+}
+
+// route picks the endpoint for a call at now: the first whose breaker is
+// closed, starting probes of any that are due one
+func (c *RPCClient) route(now time.Time) (string, *CircuitBreaker, error) {
+	if len(c.Breakers) == 0 {
+		return c.Endpoint, nil, nil
+	}
+	for _, b := range c.Breakers {
+		ok, probe := b.allow(now)
+		if probe {
+			go c.probe(b)
+		}
+		if ok {
+			return b.Endpoint, b, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w on all %d endpoints", ErrCircuitOpen, len(c.Breakers))
+}
+
+// probe checks whether b's endpoint answers eth_chainId within the client's
+// timeout
+func (c *RPCClient) probe(b *CircuitBreaker) {
+	timeout := c.HTTP.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	body, err := c.send(ctx, b.Endpoint, RPCRequest{JSONRPC: "2.0", Method: "eth_chainId", Params: []any{}, ID: int(c.nextID.Add(1))})
+	if err == nil {
+		_, err = io.Copy(io.Discard, body)
+		body.Close()
+	}
+	b.probed(err, time.Now())
+}
+
+// writeBreakerMetrics writes the breakers in the Prometheus text exposition
+// format
+func writeBreakerMetrics(w io.Writer, breakers []*CircuitBreaker) {
+	if len(breakers) == 0 {
+		return
+	}
+	statuses := make([]BreakerStatus, len(breakers))
+	for i, b := range breakers {
+		statuses[i] = b.Status()
+	}
+	series := func(name, kind, help string, v func(BreakerStatus) any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, s := range statuses {
+			fmt.Fprintf(w, "%s{endpoint=%q} %v\n", name, s.Endpoint, v(s))
+		}
+	}
+	series("bce_rpc_breaker_open", "gauge", "Whether the endpoint's circuit breaker is open.", func(s BreakerStatus) any {
+		if s.State == "closed" {
+			return 0
+		}
+		return 1
+	})
+	series("bce_rpc_breaker_trips_total", "counter", "Times the endpoint's circuit breaker has opened.", func(s BreakerStatus) any { return s.Trips })
+}
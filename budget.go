@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ScanBudget bounds how much of the pool a packer scans: once it has
+// scanned for Time, or examined Candidates candidates, it stops and returns
+// what it has selected so far. Unlike builder.deadline, which abandons the
+// build for the best block offered by then, running out of budget is not
+// an error: the block goes through the proposal hooks, ordering and
+// validation as usual. The strategies that scan candidates in rank order
+// ("greedy", "greedy-density", "fcfs", "fair" and the greedy pass of
+// "anneal") honour it; the others rely on the deadline. Zero fields are
+// unbounded.
+type ScanBudget struct {
+	Time       Duration `json:"time"`
+	Candidates int      `json:"candidates"`
+}
+
+// ScanResult reports how much of the pool a budgeted scan examined
+type ScanResult struct {
+	Candidates int  // offered to the scan, deprioritized ones included
+	Examined   int  // looked at before the scan ended
+	Exhausted  bool // the budget ran out before the block filled
+}
+
+// Fraction returns the share of the candidates examined
+func (r *ScanResult) Fraction() float64 {
+	if r.Candidates == 0 {
+		return 1
+	}
+	return float64(r.Examined) / float64(r.Candidates)
+}
+
+type scanKey struct{}
+
+// withScanBudget attaches b to ctx for the packers of a build, starting
+// its clock now
+func withScanBudget(ctx context.Context, b ScanBudget) context.Context {
+	if b == (ScanBudget{}) {
+		return ctx
+	}
+	s := &scan{max: b.Candidates}
+	if b.Time > 0 {
+		s.until = time.Now().Add(time.Duration(b.Time))
+	}
+	return context.WithValue(ctx, scanKey{}, s)
+}
+
+// scan is one packer's budgeted scan. Its methods do nothing on a nil
+// scan, which is unbudgeted.
+type scan struct {
+	until time.Time
+	max   int
+	res   ScanResult
+}
+
+// startScan returns the budget attached to ctx for a scan of candidates,
+// or nil if there is none
+func startScan(ctx context.Context, candidates int) *scan {
+	b, _ := ctx.Value(scanKey{}).(*scan)
+	if b == nil {
+		return nil
+	}
+	s := *b
+	s.res = ScanResult{Candidates: candidates}
+	return &s
+}
+
+// next counts one more candidate examined, reporting false instead once
+// the budget is spent
+func (s *scan) next() bool {
+	if s == nil {
+		return true
+	}
+	if s.max > 0 && s.res.Examined >= s.max || !s.until.IsZero() && time.Now().After(s.until) {
+		s.res.Exhausted = true
+		return false
+	}
+	s.res.Examined++
+	return true
+}
+
+// finishScan records s as the pool's LastScan
+func (p *TxPool) finishScan(s *scan) {
+	if s != nil {
+		res := s.res
+		p.lastScan.Store(&res)
+	}
+}
+
+// ScanStats accumulates budgeted scans across builds for /metrics. It is
+// safe for concurrent use.
+type ScanStats struct {
+	mu         sync.Mutex
+	scans      int
+	exhausted  int
+	examined   int64
+	candidates int64
+	last       ScanResult
+}
+
+// Record adds a build's scan
+func (s *ScanStats) Record(r *ScanResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scans++
+	if r.Exhausted {
+		s.exhausted++
+	}
+	s.examined += int64(r.Examined)
+	s.candidates += int64(r.Candidates)
+	s.last = *r
+}
+
+// WriteMetrics writes the scans in the Prometheus text exposition format
+func (s *ScanStats) WriteMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metric := func(name, kind, help string, v any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("bce_build_scans_total", "counter", "Budgeted packer scans.", s.scans)
+	metric("bce_build_scan_budget_hits_total", "counter", "Scans stopped by the scan budget.", s.exhausted)
+	metric("bce_build_scan_examined_total", "counter", "Candidates examined by budgeted scans.", s.examined)
+	metric("bce_build_scan_candidates_total", "counter", "Candidates offered to budgeted scans.", s.candidates)
+	metric("bce_build_scan_last_examined_ratio", "gauge", "Share of the candidates the last scan examined.", s.last.Fraction())
+}
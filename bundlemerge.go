@@ -0,0 +1,323 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// maxExactMerge is the most bundles of one cluster whose subsets are all
+// tried; larger clusters are merged greedily by value
+const maxExactMerge = 12
+
+// mergeCandidate is a matched bundle considered for merging
+type mergeCandidate struct {
+	id      string
+	members []*Transaction
+	tx      *Transaction // the bundle merged on its own
+}
+
+// mergeOverlapping combines bundles competing for the same opportunity,
+// those sharing a transaction or whose members conflict, transitively. Of
+// each such cluster it keeps the most valuable subset that can run
+// together, as one entry listing a shared transaction once: no two members
+// conflict, and the members' orders within their bundles agree. A cluster
+// whose best subset is a single bundle keeps that bundle as is. Every
+// bundle left out is recorded in dropped with the reason. Called with the
+// book locked.
+func (b *HintBook) mergeOverlapping(cands []mergeCandidate) []*Transaction {
+	clear(b.composites)
+	clear(b.dropped)
+	var out []*Transaction
+	for _, cluster := range mergeClusters(cands) {
+		if len(cluster) == 1 {
+			out = append(out, cluster[0].tx)
+			continue
+		}
+		// Most valuable first, so ties and the greedy fallback favor them
+		slices.SortFunc(cluster, func(x, y mergeCandidate) int {
+			return cmp.Or(cmp.Compare(y.tx.Profit(), x.tx.Profit()), strings.Compare(x.id, y.id))
+		})
+		n := len(cluster)
+		why := make([][]string, n) // why[i][j]: why i and j can't run together, "" if they can
+		for i := range cluster {
+			why[i] = make([]string, n)
+			for j := range i {
+				why[i][j] = incompatible(cluster[i].members, cluster[j].members)
+				why[j][i] = why[i][j]
+			}
+		}
+
+		value := func(set []int) (*Transaction, bool) {
+			if len(set) == 1 {
+				return cluster[set[0]].tx, true
+			}
+			return b.compose(cluster, set)
+		}
+		var best []int
+		var bestTx *Transaction
+		consider := func(set []int) {
+			tx, ok := value(set)
+			if ok && (bestTx == nil || tx.Profit() > bestTx.Profit()) {
+				best, bestTx = slices.Clone(set), tx
+			}
+		}
+		compatible := func(set []int, i int) bool {
+			return !slices.ContainsFunc(set, func(j int) bool { return why[i][j] != "" })
+		}
+		if n <= maxExactMerge {
+			var search func(i int, set []int)
+			search = func(i int, set []int) {
+				if i == n {
+					if len(set) > 0 {
+						consider(set)
+					}
+					return
+				}
+				if compatible(set, i) {
+					search(i+1, append(set, i))
+				}
+				search(i+1, set)
+			}
+			search(0, nil)
+		} else {
+			set := []int{0}
+			consider(set)
+			for i := 1; i < n; i++ {
+				if compatible(best, i) {
+					consider(append(slices.Clone(best), i))
+				}
+			}
+		}
+
+		out = append(out, bestTx)
+		kept := make([]string, len(best))
+		for k, i := range best {
+			kept[k] = cluster[i].id
+		}
+		if len(best) > 1 {
+			b.composites[bestTx.Hash] = kept
+		}
+		for i, c := range cluster {
+			if slices.Contains(best, i) {
+				continue
+			}
+			reason := fmt.Sprintf("adds no value to %s", strings.Join(kept, ", "))
+			for _, j := range best {
+				if why[i][j] != "" {
+					reason = fmt.Sprintf("can't run with %s: %s", cluster[j].id, why[i][j])
+					break
+				}
+			}
+			b.dropped[c.id] = reason
+		}
+	}
+	return out
+}
+
+// mergeClusters groups cands into clusters linked by shared or
+// conflicting members
+func mergeClusters(cands []mergeCandidate) [][]mergeCandidate {
+	parent := make([]int, len(cands))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	owner := map[string]int{}
+	for i, c := range cands {
+		for _, m := range c.members {
+			if j, ok := owner[m.Hash]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				owner[m.Hash] = i
+			}
+		}
+	}
+	for i := range cands {
+		for j := range i {
+			if find(i) != find(j) && memberConflict(cands[i].members, cands[j].members) != "" {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+	groups := map[int][]mergeCandidate{}
+	for i, c := range cands {
+		groups[find(i)] = append(groups[find(i)], c)
+	}
+	return slices.Collect(maps.Values(groups))
+}
+
+// incompatible explains why bundles of members x and y can't be merged, or
+// returns "" if they can: a member of one conflicts with a different
+// member of the other, or they run their shared members in different
+// orders
+func incompatible(x, y []*Transaction) string {
+	if why := memberConflict(x, y); why != "" {
+		return why
+	}
+	position := map[string]int{}
+	for i, a := range x {
+		position[a.Hash] = i
+	}
+	last, lastHash := -1, ""
+	for _, c := range y {
+		if i, ok := position[c.Hash]; ok {
+			if i < last {
+				return fmt.Sprintf("runs %s and %s in the other order", lastHash, c.Hash)
+			}
+			last, lastHash = i, c.Hash
+		}
+	}
+	return ""
+}
+
+// memberConflict names a member of x conflicting with a different member
+// of y, by declaration or by using the same sender and nonce, or returns
+// "" if there is none
+func memberConflict(x, y []*Transaction) string {
+	for _, a := range x {
+		for _, c := range y {
+			if a.Hash == c.Hash {
+				continue
+			}
+			if slices.Contains(a.ConflictsWith, c.Hash) || slices.Contains(c.ConflictsWith, a.Hash) ||
+				a.From != "" && a.From == c.From && a.Nonce == c.Nonce {
+				return fmt.Sprintf("%s conflicts with %s", a.Hash, c.Hash)
+			}
+		}
+	}
+	return ""
+}
+
+// compose merges the bundles set of cluster into one entry, each shared
+// transaction once, in an order keeping every bundle's own, or reports
+// false if there is none. A bundle's simulated payment counts over what
+// its members declared, and the entry has the reputation of the least
+// reputable searcher.
+func (b *HintBook) compose(cluster []mergeCandidate, set []int) (*Transaction, bool) {
+	ids := make([]string, len(set))
+	lists := make([][]*Transaction, len(set))
+	for k, i := range set {
+		ids[k], lists[k] = cluster[i].id, cluster[i].members
+	}
+	members, ok := unionOrder(lists)
+	if !ok {
+		return nil, false
+	}
+	sorted := slices.Sorted(slices.Values(ids))
+	tx := mergeBundle("merged:"+strings.Join(sorted, "+"), members)
+	tx.Reputation = 1
+	for _, id := range ids {
+		tx.Reputation = min(tx.Reputation, b.reputation(b.bundles[id].Searcher))
+		if v, ok := b.mev[id]; ok {
+			tx.MEVBonus += v
+			for _, m := range b.resolve(b.bundles[id]) {
+				tx.MEVBonus -= m.MEVBonus
+			}
+		}
+	}
+	return tx, true
+}
+
+// unionOrder lists the distinct transactions of lists in an order that
+// keeps each list's, earlier lists first where free to choose, or reports
+// false if the lists' orders contradict each other
+func unionOrder(lists [][]*Transaction) ([]*Transaction, bool) {
+	index := map[string]int{}
+	var nodes []*Transaction
+	for _, list := range lists {
+		for _, tx := range list {
+			if _, ok := index[tx.Hash]; !ok {
+				index[tx.Hash] = len(nodes)
+				nodes = append(nodes, tx)
+			}
+		}
+	}
+	waiting := make([]int, len(nodes))
+	next := make([][]int, len(nodes))
+	for _, list := range lists {
+		for k := 1; k < len(list); k++ {
+			from, to := index[list[k-1].Hash], index[list[k].Hash]
+			if !slices.Contains(next[from], to) {
+				next[from] = append(next[from], to)
+				waiting[to]++
+			}
+		}
+	}
+	var ready []int
+	for i, n := range waiting {
+		if n == 0 {
+			ready = append(ready, i)
+		}
+	}
+	out := make([]*Transaction, 0, len(nodes))
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		out = append(out, nodes[i])
+		for _, j := range next[i] {
+			if waiting[j]--; waiting[j] == 0 {
+				at, _ := slices.BinarySearch(ready, j)
+				ready = slices.Insert(ready, at, j)
+			}
+		}
+	}
+	return out, len(out) == len(nodes)
+}
+
+// composite resolves the members of merged entry id and the lower-case
+// hashes of those allowed to revert, which are those every bundle listing
+// them allows to, or reports false if id isn't a merged entry of the last
+// build or its bundles are gone. Called with the book locked.
+func (b *HintBook) composite(id string) ([]*Transaction, map[string]bool, bool) {
+	ids, ok := b.composites[id]
+	if !ok {
+		return nil, nil, false
+	}
+	lists := make([][]*Transaction, len(ids))
+	listed := map[string]int{}
+	allowed := map[string]int{}
+	for k, source := range ids {
+		bundle := b.bundles[source]
+		if bundle == nil {
+			return nil, nil, false
+		}
+		if lists[k] = b.resolve(bundle); lists[k] == nil {
+			return nil, nil, false
+		}
+		for _, m := range lists[k] {
+			listed[strings.ToLower(m.Hash)]++
+		}
+		for _, h := range bundle.RevertingTxHashes {
+			allowed[strings.ToLower(h)]++
+		}
+	}
+	members, ok := unionOrder(lists)
+	if !ok {
+		return nil, nil, false
+	}
+	reverting := map[string]bool{}
+	for h, n := range allowed {
+		if n == listed[h] {
+			reverting[h] = true
+		}
+	}
+	return members, reverting, true
+}
+
+// MergeReport returns the merged entries of the last build, each with the
+// IDs of the bundles it combines, and the bundles left out of them, each
+// with the reason
+func (b *HintBook) MergeReport() (merged map[string][]string, dropped map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return maps.Clone(b.composites), maps.Clone(b.dropped)
+}
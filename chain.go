@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ElasticityMultiplier is the EIP-1559 ratio between block gas limit and
+// gas target
+const ElasticityMultiplier = 2
+
+// DefaultBlockGasLimit is used when the chain can't be queried
+// https://docs.berachain.com/learn/help/faqs#what-do-berachain-s-performance-metrics-look-like
+const DefaultBlockGasLimit = int64(30000000)
+
+// Header holds the block header fields the builder needs
+type Header struct {
+	Number     uint64
+	Hash       string
+	ParentHash string
+	GasLimit   int64
+	GasUsed    int64
+	BaseFee    int64 // zero on pre-London blocks
+	Timestamp  uint64
+}
+
+// GasTarget returns the EIP-1559 gas target implied by the gas limit
+func (h *Header) GasTarget() int64 {
+	return h.GasLimit / ElasticityMultiplier
+}
+
+// rpcHeader is a header as returned by eth_getBlockByNumber
+type rpcHeader struct {
+	Number        string `json:"number"`
+	Hash          string `json:"hash"`
+	ParentHash    string `json:"parentHash"`
+	GasLimit      string `json:"gasLimit"`
+	GasUsed       string `json:"gasUsed"`
+	BaseFeePerGas string `json:"baseFeePerGas"`
+	Timestamp     string `json:"timestamp"`
+}
+
+func (rh *rpcHeader) toHeader() (*Header, error) {
+	h := &Header{Hash: rh.Hash, ParentHash: rh.ParentHash}
+	var err error
+	if h.Number, err = ParseHexUint64(rh.Number); err != nil {
+		return nil, fmt.Errorf("header number: %w", err)
+	}
+	if h.GasLimit, err = ParseHexInt64(rh.GasLimit); err != nil {
+		return nil, fmt.Errorf("header gasLimit: %w", err)
+	}
+	if h.GasUsed, err = ParseHexInt64(rh.GasUsed); err != nil {
+		return nil, fmt.Errorf("header gasUsed: %w", err)
+	}
+	if h.Timestamp, err = ParseHexUint64(rh.Timestamp); err != nil {
+		return nil, fmt.Errorf("header timestamp: %w", err)
+	}
+	if rh.BaseFeePerGas != "" {
+		if h.BaseFee, err = ParseHexInt64(rh.BaseFeePerGas); err != nil {
+			return nil, fmt.Errorf("header baseFeePerGas: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// FetchHeader fetches the header for a block tag ("latest", "pending", ...)
+// or 0x-encoded number
+func FetchHeader(ctx context.Context, rpc *RPCClient, block string) (*Header, error) {
+	var rh *rpcHeader
+	if err := rpc.Call(ctx, &rh, "eth_getBlockByNumber", block, false); err != nil {
+		return nil, err
+	}
+	if rh == nil {
+		return nil, fmt.Errorf("block %s not found", block)
+	}
+	return rh.toHeader()
+}
+
+// HeadWatcher polls the chain for new heads and tracks the live gas limit
+type HeadWatcher struct {
+	RPC      *RPCClient
+	Interval time.Duration
+	OnHead   func(*Header) // optional, called for every new head
+
+	mu   sync.RWMutex
+	head *Header
+}
+
+func NewHeadWatcher(rpc *RPCClient, interval time.Duration) *HeadWatcher {
+	return &HeadWatcher{RPC: rpc, Interval: interval}
+}
+
+// Head returns the most recently observed head, or nil before the first poll
+func (w *HeadWatcher) Head() *Header {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.head
+}
+
+// GasLimit returns the live block gas limit, or DefaultBlockGasLimit if no
+// head has been observed yet
+func (w *HeadWatcher) GasLimit() int64 {
+	if h := w.Head(); h != nil {
+		return h.GasLimit
+	}
+	return DefaultBlockGasLimit
+}
+
+// Poll fetches the latest header once, recording it if it is new
+func (w *HeadWatcher) Poll(ctx context.Context) (*Header, error) {
+	h, err := FetchHeader(ctx, w.RPC, "latest")
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	isNew := w.head == nil || w.head.Hash != h.Hash
+	if isNew {
+		w.head = h
+	}
+	w.mu.Unlock()
+	if isNew && w.OnHead != nil {
+		w.OnHead(h)
+	}
+	return h, nil
+}
+
+// Run polls until ctx is cancelled. Poll errors are reported through errFn
+// (if set) and do not stop the watcher.
+func (w *HeadWatcher) Run(ctx context.Context, errFn func(error)) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Poll(ctx); err != nil && ctx.Err() == nil && errFn != nil {
+			errFn(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// FetchBlock fetches a block with its full transaction objects. Transactions
+// that fail to decode are returned separately so callers can report them.
+func FetchBlock(ctx context.Context, rpc *RPCClient, number uint64) (*Header, []*Transaction, int, error) {
+	var block *rpcBlock
+	if err := rpc.Call(ctx, &block, "eth_getBlockByNumber", EncodeHexUint64(number), true); err != nil {
+		return nil, nil, 0, err
+	}
+	if block == nil {
+		return nil, nil, 0, fmt.Errorf("block %d not found", number)
+	}
+	return block.decode()
+}
+
+// FetchBlockTxHashes fetches the hashes of the transactions of a block
+func FetchBlockTxHashes(ctx context.Context, rpc *RPCClient, number uint64) ([]string, error) {
+	var block *struct {
+		Transactions []string `json:"transactions"`
+	}
+	if err := rpc.Call(ctx, &block, "eth_getBlockByNumber", EncodeHexUint64(number), false); err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return block.Transactions, nil
+}
+
+// rpcBlock is a block with full transactions as returned by
+// eth_getBlockByNumber
+type rpcBlock struct {
+	rpcHeader
+	Transactions []rpcTransaction `json:"transactions"`
+}
+
+// decode converts the block, skipping and counting malformed transactions
+func (b *rpcBlock) decode() (*Header, []*Transaction, int, error) {
+	header, err := b.toHeader()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	txs := make([]*Transaction, 0, len(b.Transactions))
+	malformed := 0
+	for _, rtx := range b.Transactions {
+		tx, _, err := rtx.toTransaction()
+		if err != nil {
+			malformed++
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return header, txs, malformed, nil
+}
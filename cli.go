@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Command is a CLI subcommand with its own flag set
+type Command struct {
+	Name    string
+	Summary string
+	// Setup registers the command's flags on fs and returns its run function
+	Setup func(fs *flag.FlagSet) func(ctx context.Context, env *Env) error
+}
+
+// Env carries the resolved configuration shared by every command
+type Env struct {
+	Config    *Config
+	RPC       *RPCClient
+	Out       io.Writer
+	TraceDir  string             // record a replayable trace of every build here if set
+	Policy    *AddressPolicy     // compliance lists applied to every pool
+	Backrun   *BackrunAnalyzer   // prices backruns of pending swaps; nil if disabled
+	StateDiff *StateDiffAnalyzer // adds storage conflicts from traces; nil if disabled
+	State     *StateCache        // state prefetched per slot; nil if disabled
+	Incentive *IncentiveBook     // credits PoL incentives from reward vaults; nil if disabled
+	MEV       *MEVSimulator      // simulates payments to the builder; nil if disabled
+	Gas       *GasEstimator      // estimates gas used for packing; nil if disabled
+	Revert    *RevertGuard       // drops reverting bundles from built blocks; nil if disabled
+	Oversub   *Oversubscriber    // packs past the gas limit and trims on simulation; nil if disabled
+	Coinbase  *SigningKey        // builder coinbase paying the proposer; nil if unset
+	Proposers *RegistrationBook  // proposer registrations from the relay; nil if unset
+	Schedule  *ProposerSchedule  // slots of our own validators; nil builds every slot
+	Relays    *Submitter         // relays sealed bids are submitted to; nil if none
+	Lanes     []*Lane            // top-of-block lanes applied to every pool
+	Express   *ExpressLane       // auctions the top of the block; nil if disabled
+	Encrypted *EncryptedPool     // holds encrypted transactions for their keys; nil if disabled
+	Commits   *CommitReveal      // the commit-reveal ordering experiment; nil if disabled
+	Hooks     []*Hook            // proposal hooks applied to every pool
+	Boosts    []*ContractBoost   // contract score boosts applied to every pool
+	Tracer    *Tracer            // exports build spans; nil if tracing is off
+	Dashboard *Dashboard         // collects builds and bids for the web dashboard; nil if off
+	Lifecycle *Lifecycle         // follows every pooled transaction to its outcome; nil if off
+	Alerts    *Alerter           // posts operational alerts to webhooks; nil if none
+	Heap      *HeapMetrics       // times every pool's heap operations; nil if off
+	Scans     *ScanStats         // budgeted scans of every build; nil without a scan budget
+
+	// CuttingBoards reads proposers' reward allocations; nil unless
+	// builder.cutting_board is set
+	CuttingBoards *CuttingBoards
+
+	// Attributes holds the payload attributes the beacon node announces;
+	// nil unless beacon.url is set
+	Attributes *AttributesBook
+
+	// Source and Seed describe where the pool came from, for traces
+	Source string
+	Seed   uint64
+}
+
+// NewPool returns an empty pool configured from the pool settings
+func (e *Env) NewPool() *TxPool {
+	pool := NewTxPool()
+	pool.Seen.TTL = time.Duration(e.Config.Pool.SeenTTL)
+	pool.Quarantine.Max = e.Config.Pool.QuarantineSize
+	pool.MinGasPrice = e.Config.Pool.MinGasPrice
+	pool.ChainID = e.Config.ChainID
+	pool.MinTip = e.Config.Pool.MinTip
+	pool.TipFloor = TipFloorMode(e.Config.Pool.TipFloor)
+	pool.MaxPerSender = e.Config.Pool.MaxPerSender
+	pool.MaxSenderGas = e.Config.Pool.MaxSenderGas
+	pool.MaxBytes = e.Config.Pool.MaxBytes
+	pool.MaxTxGas = e.Config.Builder.GasLimit
+	pool.Policy = e.Policy
+	pool.Lanes = e.Lanes
+	pool.Hooks = e.Hooks
+	pool.Boosts = e.Boosts
+	pool.Quotas = NewGasQuotas(e.Config.Builder.Quotas)
+	pool.Hints.TTL = time.Duration(e.Config.MEVShare.HintTTL)
+	pool.Hints.Sandwich = SandwichPolicy(e.Config.MEVShare.SandwichPolicy)
+	pool.Hints.Merge = e.Config.MEVShare.MergeBundles
+	pool.Search = e.Config.Builder.Search
+	pool.Parallel = e.Config.Builder.Parallel
+	pool.Exact = e.Config.Builder.Exact
+	pool.Fair = e.Config.Builder.Fair
+	pool.Ranking = Ranking(e.Config.Builder.Ranking)
+	if e.StateDiff != nil {
+		pool.Access = e.StateDiff.Access
+	}
+	pool.Aging = e.Config.Pool.Aging
+	pool.Heap.Metrics, pool.Low.Metrics = e.Heap, e.Heap
+	pool.Scorer = e.Config.Builder.Scorer
+	pool.Weights = e.Config.Builder.Weights
+	return pool
+}
+
+// GasLimit resolves the block gas limit: an explicit override, then the
+// configured limit, then the live chain limit, then DefaultBlockGasLimit
+func (e *Env) GasLimit(ctx context.Context, override int64) int64 {
+	if override > 0 {
+		return override
+	}
+	if e.Config.Builder.GasLimit > 0 {
+		return e.Config.Builder.GasLimit
+	}
+	head, err := FetchHeader(ctx, e.RPC, "latest")
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error fetching latest header, using default gas limit: %v\n", err)
+		return DefaultBlockGasLimit
+	}
+	fmt.Fprintf(e.Out, "Block #%d gas limit: %d (target %d)\n", head.Number, head.GasLimit, head.GasTarget())
+	return head.GasLimit
+}
+
+// SlotTarget returns the gas limit to build head's child with and the
+// registration of that slot's proposer, nil if there is none: an override
+// or builder.gas_limit wins, else head's limit is moved toward the
+// proposer's preference. A failed lookup is reported and treated as
+// unregistered.
+func (e *Env) SlotTarget(ctx context.Context, head *Header, override int64) (int64, *ValidatorRegistration) {
+	var reg *ValidatorRegistration
+	if e.Proposers != nil {
+		var err error
+		if reg, err = e.Proposers.ForSlot(ctx, head.Number+1); err != nil {
+			fmt.Fprintf(e.Out, "Error fetching proposer registrations: %v\n", err)
+		}
+	}
+	if reg != nil {
+		fmt.Fprintf(e.Out, "Slot %d proposer %s: gas limit %d, fee recipient %s\n", head.Number+1, EncodeHexBytes(reg.Pubkey[:]), reg.GasLimit, reg.FeeRecipient)
+	}
+	switch {
+	case override > 0:
+		return override, reg
+	case e.Config.Builder.GasLimit > 0:
+		return e.Config.Builder.GasLimit, reg
+	case reg != nil && reg.GasLimit > 0:
+		return TargetGasLimit(head.GasLimit, int64(reg.GasLimit)), reg
+	}
+	return head.GasLimit, reg
+}
+
+// FeeRecipient resolves the proposer fee recipient built blocks pay: the
+// flag value if given, else builder.fee_recipient; zero if neither is set
+func (e *Env) FeeRecipient(flag string) (Address, error) {
+	if flag != "" {
+		return HexToAddress(flag)
+	}
+	if e.Config.Builder.FeeRecipient != "" {
+		return HexToAddress(e.Config.Builder.FeeRecipient)
+	}
+	return Address{}, nil
+}
+
+// CheckChain fails if the RPC endpoint serves a different chain than the
+// configured profile
+func (e *Env) CheckChain(ctx context.Context) error {
+	return VerifyChainID(ctx, e.RPC, e.Config.ChainID)
+}
+
+// AnalyzeBackruns credits backrun value to pending swaps in pool if
+// backrun detection is enabled
+func (e *Env) AnalyzeBackruns(ctx context.Context, pool Mempool) {
+	if e.Backrun == nil {
+		return
+	}
+	n, err := e.Backrun.Analyze(ctx, pool)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error analyzing backruns: %v\n", err)
+		return
+	}
+	if n > 0 {
+		fmt.Fprintf(e.Out, "Found %d backrun opportunities\n", n)
+	}
+}
+
+// SimulateMEV sets the MEVBonus of pool's transactions and hints' bundles
+// to what simulation shows they pay the builder, if enabled
+func (e *Env) SimulateMEV(ctx context.Context, pool Mempool, hints *HintBook) {
+	if e.MEV == nil {
+		return
+	}
+	n, err := e.MEV.Analyze(ctx, pool, hints)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error simulating builder payments: %v\n", err)
+		return
+	}
+	if n > 0 {
+		fmt.Fprintf(e.Out, "Simulated builder payments for %d transactions and bundles\n", n)
+	}
+}
+
+// ApplyIncentives credits pool's transactions feeding a reward vault with
+// its incentives, re-reading the rates once they are stale, if enabled
+func (e *Env) ApplyIncentives(ctx context.Context, pool Mempool) {
+	if e.Incentive == nil {
+		return
+	}
+	n, err := e.Incentive.Apply(ctx, pool)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error reading PoL incentives: %v\n", err)
+		return
+	}
+	if n > 0 {
+		fmt.Fprintf(e.Out, "Credited PoL incentives to %d transactions\n", n)
+	}
+}
+
+// AnalyzeStateDiffs adds storage conflicts found by tracing to pool if
+// state-diff detection is enabled, reporting failures without aborting
+func (e *Env) AnalyzeStateDiffs(ctx context.Context, pool Mempool) {
+	if e.StateDiff == nil {
+		return
+	}
+	n, err := e.StateDiff.Analyze(ctx, pool)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error tracing state diffs: %v\n", err)
+		return
+	}
+	if n > 0 {
+		fmt.Fprintf(e.Out, "Found storage conflicts for %d transactions\n", n)
+	}
+}
+
+// PrefetchState warms the state cache for building on head with the
+// accounts and storage pool's transactions use, if prefetching is enabled
+func (e *Env) PrefetchState(ctx context.Context, head *Header, pool Mempool) {
+	if e.State == nil {
+		return
+	}
+	var traced map[Address][]Hash
+	if e.StateDiff != nil {
+		traced = e.StateDiff.Slots()
+	}
+	n, err := e.State.Prefetch(ctx, head, pool.Txs(), traced)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error prefetching state: %v\n", err)
+		return
+	}
+	hits, misses := e.State.Stats()
+	fmt.Fprintf(e.Out, "Prefetched state of %d accounts (cache hit rate %.1f%%)\n", n, 100*float64(hits)/float64(max(hits+misses, 1)))
+}
+
+// CheckNonces fetches the confirmed nonces of pool's senders and drops
+// the transactions already superseded on chain, if nonce tracking is on
+func (e *Env) CheckNonces(ctx context.Context, pool Mempool) {
+	if !e.Config.Pool.TrackNonces {
+		return
+	}
+	var nonces map[string]uint64
+	var err error
+	if e.State != nil {
+		nonces, err = e.State.Nonces(ctx, pool.Txs())
+	} else {
+		nonces, err = FetchNonces(ctx, e.RPC, pool.Txs())
+	}
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error fetching sender nonces: %v\n", err)
+		return
+	}
+	if n := pool.SetNonces(nonces); n > 0 {
+		fmt.Fprintf(e.Out, "Dropped %d transactions with stale nonces\n", n)
+	}
+}
+
+// CheckBalances fetches the balances of pool's senders, so transactions
+// they can't pay for are left out of builds, if balance checks are enabled
+func (e *Env) CheckBalances(ctx context.Context, pool Mempool) {
+	if !e.Config.Pool.CheckBalances {
+		return
+	}
+	var balances map[string]*big.Int
+	var err error
+	if e.State != nil {
+		balances, err = e.State.Balances(ctx, pool.Txs())
+	} else {
+		balances, err = FetchBalances(ctx, e.RPC, pool.Txs())
+	}
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error fetching sender balances: %v\n", err)
+		return
+	}
+	pool.SetBalances(balances)
+}
+
+// EstimateGas learns gas usage from the blocks up to head and sets the
+// estimates of pool's transactions if estimation is enabled
+func (e *Env) EstimateGas(ctx context.Context, pool Mempool, head uint64) {
+	if e.Gas == nil {
+		return
+	}
+	if err := e.Gas.LearnUpTo(ctx, e.RPC, head, e.Config.Builder.GasEstimate.History); err != nil {
+		fmt.Fprintf(e.Out, "Error learning gas usage: %v\n", err)
+	}
+	if err := e.Gas.Simulate(ctx, e.RPC, pool); err != nil {
+		fmt.Fprintf(e.Out, "Error simulating gas usage: %v\n", err)
+	}
+	e.Gas.Apply(pool)
+	if a := e.Gas.Accuracy(); a.Matched > 0 {
+		fmt.Fprintf(e.Out, "Gas estimates: mined packed txs used %.1f%% of their estimate (%d txs)\n", 100*a.Utilization(), a.Matched)
+	}
+}
+
+// commonFlags are accepted by every subcommand
+type commonFlags struct {
+	config      string
+	chain       string
+	endpoint    string
+	maxAttempts int
+	backoff     time.Duration
+	traceDir    string
+	reportDir   string
+}
+
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	c := &commonFlags{}
+	fs.StringVar(&c.config, "config", "", "path to a .toml or .json config file")
+	fs.StringVar(&c.chain, "chain", "", fmt.Sprintf("chain profile %v (default mainnet, or the config file's chain)", ProfileNames()))
+	fs.StringVar(&c.endpoint, "rpc", DefaultRPCEndpoint, "Berachain JSON-RPC endpoint")
+	fs.IntVar(&c.maxAttempts, "rpc-max-attempts", DefaultRetryPolicy().MaxAttempts, "maximum attempts per RPC call")
+	fs.DurationVar(&c.backoff, "rpc-backoff", DefaultRetryPolicy().BaseDelay, "initial retry backoff, doubled per attempt")
+	fs.StringVar(&c.traceDir, "trace-dir", "", "record a replayable trace of every build in this directory")
+	fs.StringVar(&c.reportDir, "report-dir", "", "write a profit report of every build in this directory")
+	return c
+}
+
+// env loads the config file and applies flags given explicitly on the
+// command line on top of it
+func (c *commonFlags) env(fs *flag.FlagSet) (*Env, error) {
+	cfg := DefaultConfig()
+	switch {
+	case c.config != "":
+		var err error
+		if cfg, err = LoadConfig(c.config, c.chain); err != nil {
+			return nil, err
+		}
+	case c.chain != "":
+		profile, err := LookupProfile(c.chain)
+		if err != nil {
+			return nil, err
+		}
+		cfg = ProfileConfig(profile)
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "rpc":
+			cfg.RPC.Endpoints = []string{c.endpoint}
+		case "rpc-max-attempts":
+			cfg.RPC.MaxAttempts = c.maxAttempts
+		case "rpc-backoff":
+			cfg.RPC.Backoff = Duration(c.backoff)
+		case "report-dir":
+			cfg.Report.Dir = c.reportDir
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	policy, err := NewAddressPolicy(cfg.Compliance)
+	if err != nil {
+		return nil, fmt.Errorf("loading compliance lists: %w", err)
+	}
+	UseHTTPConfig(cfg.HTTP)
+	rpc := cfg.RPC.NewClient()
+	if path := cfg.Keys.JWTSecretFile; path != "" {
+		secret, err := LoadJWTSecret(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading JWT secret: %w", err)
+		}
+		rpc.Auth = NewJWTAuth(secret)
+	}
+	backrun, err := NewBackrunAnalyzer(rpc, cfg.Backrun)
+	if err != nil {
+		return nil, err
+	}
+	lanes, err := NewLanes(cfg.Lanes)
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := NewHooks(cfg.Builder.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	boosts, err := NewContractBoosts(cfg.Builder.Boosts)
+	if err != nil {
+		return nil, err
+	}
+
+	var coinbase *SigningKey
+	if path := cfg.Keys.CoinbaseKeyFile; path != "" {
+		if coinbase, err = LoadSigningKey(path); err != nil {
+			return nil, fmt.Errorf("loading coinbase key: %w", err)
+		}
+		if want := cfg.Builder.Coinbase; want != "" && !strings.EqualFold(want, coinbase.Address().Hex()) {
+			return nil, fmt.Errorf("coinbase key is for %s, but builder.coinbase is %s", coinbase.Address(), want)
+		}
+	}
+	// Searchers pay whoever the block's fee recipient is: the builder's
+	// coinbase when it has one, the proposer otherwise
+	recipient := cfg.Builder.FeeRecipient
+	if coinbase != nil {
+		recipient = coinbase.Address().Hex()
+	}
+	workers := NewWorkerPool(cfg.Simulation)
+	mev, err := NewMEVSimulator(rpc, recipient, cfg.MEVBonus, workers)
+	if err != nil {
+		return nil, err
+	}
+	state := NewStateCache(rpc, cfg.State)
+	profile, err := LookupProfile(cfg.Chain)
+	if err != nil {
+		return nil, err
+	}
+	local := NewLocalEVM(state, cfg.Simulation, cfg.ChainID, cfg.Forks, profile.BlockTime)
+	if mev != nil {
+		mev.Local = local
+	}
+	stateDiff := NewStateDiffAnalyzer(rpc, cfg.StateDiff, workers)
+	if stateDiff != nil {
+		stateDiff.Local = local
+	}
+
+	var proposers *RegistrationBook
+	if cfg.Relay.URL != "" {
+		proposers = NewRegistrationBook(NewRelayClient(cfg.Relay.URL))
+	}
+
+	relays, err := NewSubmitter(cfg.Relay)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := NewProposerSchedule(cfg.Relay)
+	if err != nil {
+		return nil, err
+	}
+	var attributes *AttributesBook
+	if cfg.Beacon.URL != "" {
+		attributes = NewAttributesBook(cfg.Beacon.URL)
+	}
+
+	var boards *CuttingBoards
+	if cfg.Builder.CuttingBoard {
+		beraChef, err := HexToAddress(cfg.PoL.BeraChef)
+		if err != nil {
+			return nil, fmt.Errorf("pol.berachef: %w", err)
+		}
+		boards = NewCuttingBoards(rpc, beraChef)
+	}
+	incentives, err := NewIncentiveBook(rpc, cfg.PoL.RewardVaultFactory, cfg.Builder.Incentives)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts, err := NewAlerter(cfg.Alerts)
+	if err != nil {
+		return nil, err
+	}
+	if alerts != nil {
+		alerts.Out = os.Stdout
+	}
+
+	var tracer *Tracer
+	if cfg.Tracing.Endpoint != "" {
+		tracer = NewTracer(cfg.Tracing.Endpoint, cfg.Tracing.Service)
+		tracer.OnError = func(err error) { fmt.Fprintf(os.Stderr, "Error exporting traces: %v\n", err) }
+	}
+
+	return &Env{
+		Config:    cfg,
+		RPC:       rpc,
+		Out:       os.Stdout,
+		TraceDir:  c.traceDir,
+		Policy:    policy,
+		Backrun:   backrun,
+		StateDiff: stateDiff,
+		State:     state,
+		MEV:       mev,
+		Incentive: incentives,
+		Gas:       NewGasEstimator(cfg.Builder.GasEstimate),
+		Revert:    NewRevertGuard(rpc, cfg.Builder.RevertProtection),
+		Oversub:   NewOversubscriber(rpc, cfg.Builder.Oversubscribe),
+		Coinbase:  coinbase,
+		Proposers: proposers,
+		Schedule:  schedule,
+		Relays:    relays,
+		Lanes:     lanes,
+		Express:   NewExpressLane(cfg.ExpressLane),
+		Encrypted: NewEncryptedPool(cfg.Encrypted),
+		Commits:   NewCommitReveal(cfg.CommitReveal),
+		Hooks:     hooks,
+		Boosts:    boosts,
+		Tracer:    tracer,
+		Alerts:    alerts,
+
+		CuttingBoards: boards,
+		Attributes:    attributes,
+		Source:        "rpc",
+	}, nil
+}
+
+// RunCLI dispatches args to a subcommand and returns the process exit code
+func RunCLI(ctx context.Context, args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	var cmd *Command
+	for _, c := range Commands {
+		if c.Name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	common := addCommonFlags(fs)
+	run := cmd.Setup(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	env, err := common.env(fs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	err = run(WithTracer(ctx, env.Tracer), env)
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := env.Tracer.Close(closeCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting traces: %v\n", err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range Commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "\nRun '<command> -h' for the flags of a command.\n")
+}
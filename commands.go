@@ -0,0 +1,813 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cspannos/block-construction-engine-poc/mockrpc"
+)
+
+// Commands lists the available subcommands in help order
+var Commands = []*Command{
+	fetchCommand,
+	buildCommand,
+	serveCommand,
+	simulateCommand,
+	backtestCommand,
+	replayCommand,
+	snapshotCommand,
+	restoreCommand,
+	feesCommand,
+	topCommand,
+}
+
+var fetchCommand = &Command{
+	Name:    "fetch",
+	Summary: "dump the pending mempool as JSON",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		out := fs.String("o", "", "write to file instead of stdout; a .csv or .pb name picks that fixture format")
+		return func(ctx context.Context, env *Env) error {
+			if err := env.CheckChain(ctx); err != nil {
+				return err
+			}
+			pool := env.NewPool()
+			if err := pool.FetchTransactions(ctx, env.RPC); err != nil {
+				return fmt.Errorf("fetching transactions: %w", err)
+			}
+			if ext := strings.ToLower(filepath.Ext(*out)); ext == ".csv" || ext == ".pb" {
+				return SaveFixture(*out, pool.Txs())
+			}
+			return writeJSON(*out, pool.Txs())
+		}
+	},
+}
+
+var buildCommand = &Command{
+	Name:    "build",
+	Summary: "fetch the mempool and build one block",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		gasLimit := fs.Int64("gas-limit", 0, "block gas limit (default: config, then live chain limit)")
+		payloadOut := fs.String("payload", "", "write the block as an execution payload JSON to this file")
+		payloadSSZ := fs.String("payload-ssz", "", "write the block as an SSZ-encoded execution payload to this file")
+		blockRLP := fs.String("block-rlp", "", "write the assembled block as RLP to this file")
+		feeRecipient := fs.String("fee-recipient", "", "proposer fee recipient address (default: config)")
+		inclusionList := fs.String("inclusion-list", "", "JSON file of hashes and raw transactions the block must include (default: config)")
+		submit := fs.Bool("submit", false, "bid the block to the relays of relay.submit")
+		attributesFile := fs.String("attributes", "", "JSON payload attributes (parentHash, timestamp, prevRandao, withdrawals, ...) to assemble the block with")
+		ranking := fs.String("ranking", "", "candidate order of greedy strategies, profit or density (default: config)")
+		return func(ctx context.Context, env *Env) error {
+			if err := overrideRanking(env, *ranking); err != nil {
+				return err
+			}
+			if *submit && env.Relays == nil {
+				return errors.New("-submit needs relays configured under relay.submit")
+			}
+			recipient, err := env.FeeRecipient(*feeRecipient)
+			if err != nil {
+				return fmt.Errorf("-fee-recipient: %w", err)
+			}
+			if err := env.CheckChain(ctx); err != nil {
+				return err
+			}
+			ctx, span := StartSpan(ctx, "build")
+			defer span.End()
+			var limit int64
+			var reg *ValidatorRegistration
+			if env.Proposers != nil {
+				head, err := FetchHeader(ctx, env.RPC, "latest")
+				if err != nil {
+					return fmt.Errorf("fetching head: %w", err)
+				}
+				limit, reg = env.SlotTarget(ctx, head, *gasLimit)
+				if reg != nil && *feeRecipient == "" {
+					recipient = reg.FeeRecipient
+				}
+			} else {
+				limit = env.GasLimit(ctx, *gasLimit)
+			}
+			pool := env.NewPool()
+			pool.MaxTxGas = limit
+			env.ApplyCuttingBoard(ctx, pool, reg)
+			if err := pool.FetchTransactions(ctx, env.RPC); err != nil {
+				return fmt.Errorf("fetching transactions: %w", err)
+			}
+			for reason, n := range pool.Quarantine.Counts() {
+				fmt.Fprintf(env.Out, "Quarantined %d malformed transactions (%s)\n", n, reason)
+			}
+			for reason, n := range pool.Stats().Rejections {
+				fmt.Fprintf(env.Out, "Rejected %d transactions (%s)\n", n, reason)
+			}
+			if path := cmp.Or(*inclusionList, env.Config.Builder.InclusionList); path != "" {
+				list, err := LoadInclusionList(path)
+				if err != nil {
+					return err
+				}
+				if err := list.Apply(pool); err != nil {
+					return err
+				}
+				fmt.Fprintf(env.Out, "Inclusion list: %d transactions required\n", pool.Stats().Required)
+			}
+			if env.State != nil {
+				head, err := FetchHeader(ctx, env.RPC, "latest")
+				if err != nil {
+					return fmt.Errorf("fetching head: %w", err)
+				}
+				env.PrefetchState(ctx, head, pool)
+			}
+			env.CheckNonces(ctx, pool)
+			env.CheckBalances(ctx, pool)
+			env.SimulateMEV(ctx, pool, pool.Hints)
+			env.ApplyIncentives(ctx, pool)
+			env.AnalyzeBackruns(ctx, pool)
+			env.AnalyzeStateDiffs(ctx, pool)
+			if env.Gas != nil {
+				head, err := FetchHeader(ctx, env.RPC, "latest")
+				if err != nil {
+					return fmt.Errorf("fetching head: %w", err)
+				}
+				env.EstimateGas(ctx, pool, head.Number)
+			}
+			stats := pool.Stats()
+			if stats.Queued > 0 {
+				fmt.Fprintf(env.Out, "Queued %d transactions waiting on a nonce gap\n", stats.Queued)
+			}
+			if stats.Low > 0 {
+				fmt.Fprintf(env.Out, "Deprioritized %d transactions tipping below %d wei\n", stats.Low, pool.MinTip)
+			}
+			selected, err := buildAndPrint(ctx, env, pool, limit)
+			if err != nil || (*payloadOut == "" && *payloadSSZ == "" && *blockRLP == "" && !*submit) {
+				return err
+			}
+
+			parent, err := FetchHeader(ctx, env.RPC, "latest")
+			if err != nil {
+				return fmt.Errorf("fetching parent header: %w", err)
+			}
+			var attrs *PayloadAttributes
+			if *attributesFile != "" {
+				if attrs, err = LoadPayloadAttributes(*attributesFile); err != nil {
+					return err
+				}
+				if !recipient.IsZero() {
+					attrs.SuggestedFeeRecipient = recipient
+				}
+			} else if attrs, err = DefaultPayloadAttributes(parent, recipient); err != nil {
+				return err
+			}
+			tmpl, block, err := assemblePayload(ctx, env, parent, attrs, limit, reg, selected)
+			if err != nil {
+				return err
+			}
+			if *submit {
+				if reg == nil {
+					return fmt.Errorf("slot %d has no registered proposer to bid to", parent.Number+1)
+				}
+				if err := submitBid(ctx, env, tmpl, parent.Number+1, reg); err != nil {
+					return err
+				}
+			}
+			if *blockRLP != "" {
+				if err := os.WriteFile(*blockRLP, block.EncodeRLP(), 0o644); err != nil {
+					return err
+				}
+			}
+			if *payloadSSZ != "" {
+				data, err := tmpl.Payload.MarshalSSZ()
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(*payloadSSZ, data, 0o644); err != nil {
+					return err
+				}
+			}
+			if *payloadOut != "" {
+				return writeJSON(*payloadOut, tmpl)
+			}
+			return nil
+		}
+	},
+}
+
+var serveCommand = &Command{
+	Name:    "serve",
+	Summary: "run the continuous builder, rebuilding on every new head",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		interval := fs.Duration("interval", 2*time.Second, "head polling interval")
+		listen := fs.String("listen", "", "serve the HTTP API on this address (default: config)")
+		shadowMode := fs.Bool("shadow", false, "never bid; compare every candidate with the canonical block that lands instead")
+		shadowOut := fs.String("shadow-out", "", "with -shadow, append each comparison to this file as a JSON line")
+		return func(ctx context.Context, env *Env) error {
+			if err := env.CheckChain(ctx); err != nil {
+				return err
+			}
+			var shadow *Shadow
+			if *shadowMode {
+				shadow = NewShadow()
+				if *shadowOut != "" {
+					f, err := os.OpenFile(*shadowOut, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					shadow.Out = f
+				}
+			}
+			env.Heap = NewHeapMetrics()
+			if env.Config.Builder.ScanBudget != (ScanBudget{}) {
+				env.Scans = &ScanStats{}
+			}
+			pool := NewShardedPool(env.Config.Pool.Shards, env.NewPool)
+			feed := NewBuildFeed()
+			addr := env.Config.API.Listen
+			if *listen != "" {
+				addr = *listen
+			}
+			if addr != "" {
+				api := NewAPIServer(pool, pool.Hints, env.Config.Private)
+				api.Feed = feed
+				api.Relays = env.Relays
+				api.Shadow = shadow
+				api.Heap = env.Heap
+				api.Scans = env.Scans
+				api.Breakers = env.RPC.Breakers
+				if env.Config.API.Dashboard {
+					env.Dashboard = NewDashboard()
+					api.Dashboard = env.Dashboard
+				}
+				if env.Config.API.Lifecycle {
+					env.Lifecycle = NewLifecycle()
+					api.Lifecycle = env.Lifecycle
+				}
+				api.Express = env.Express
+				api.Encrypted = env.Encrypted
+				api.CommitReveal = env.Commits
+				api.GRPC = env.Config.API.GRPC
+				api.Debug = env.Config.API.Debug
+				if env.Config.API.Ingress.Enabled {
+					api.Ingress = NewIngress(pool, env.RPC, env.Config.API.Ingress, env.Config.RPC, env.Config.Private)
+					api.Ingress.Out = env.Out
+				}
+				srv := &http.Server{Addr: addr, Handler: api.Handler()}
+				if api.GRPC {
+					// gRPC clients speak HTTP/2 without TLS (h2c)
+					srv.Protocols = new(http.Protocols)
+					srv.Protocols.SetHTTP1(true)
+					srv.Protocols.SetUnencryptedHTTP2(true)
+				}
+				go func() {
+					<-ctx.Done()
+					srv.Close()
+				}()
+				go func() {
+					if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+						fmt.Fprintf(env.Out, "Error serving API: %v\n", err)
+					}
+				}()
+				fmt.Fprintf(env.Out, "API listening on %s\n", addr)
+			}
+			if url := env.Config.MEVShare.URL; url != "" {
+				go streamHints(ctx, env, url, pool.Hints)
+			}
+			if env.Attributes != nil {
+				go streamAttributes(ctx, env)
+			}
+			p2p, err := NewP2P(env.Config.P2P, env.Config.ChainID, pool)
+			if err != nil {
+				return err
+			}
+			if p2p != nil {
+				p2p.Out = env.Out
+				go func() {
+					if err := p2p.Run(ctx); err != nil {
+						fmt.Fprintf(env.Out, "Error running p2p: %v\n", err)
+					}
+				}()
+			}
+			watcher := NewHeadWatcher(env.RPC, *interval)
+			cancelSlot := context.CancelFunc(func() {})
+			defer func() { cancelSlot() }()
+			watcher.OnHead = func(h *Header) {
+				// A new head supersedes the bids and arrivals for the last one
+				cancelSlot()
+				ctx, span := StartSpan(ctx, "build", "block.parent_number", h.Number, "block.parent_hash", h.Hash)
+				defer span.End()
+				pool.Hints.Prune()
+				if shadow != nil {
+					go compareShadow(ctx, env, shadow, h.Number)
+				}
+				gasLimit, reg := env.SlotTarget(ctx, h, 0)
+				pool.SetMaxTxGas(gasLimit)
+				skip := env.SkipSlot(h, reg)
+				if !skip {
+					env.ApplyCuttingBoard(ctx, pool, reg)
+				}
+				before := pool.Stats().Pooled
+				removed, err := pool.SyncPending(ctx, env.RPC)
+				if err != nil {
+					span.SetError(err)
+					fmt.Fprintf(env.Out, "Error fetching transactions: %v\n", err)
+					if ctx.Err() == nil {
+						env.Alerts.RPCFailed(ctx, err)
+					}
+					return
+				}
+				env.Alerts.RPCOK(ctx, h.Number)
+				stats := pool.Stats()
+				env.Alerts.Synced(ctx, h.Number, stats.Executable)
+				pooled := stats.Pooled
+				fmt.Fprintf(env.Out, "\nHead #%d %s | pool %d (-%d)\n", h.Number, h.Hash, pooled, removed)
+				if env.Dashboard != nil {
+					env.Dashboard.Synced(h.Number, pooled-before+removed, removed, pooled)
+				}
+				env.PrefetchState(ctx, h, pool)
+				env.CheckNonces(ctx, pool)
+				env.TrackLifecycle(ctx, pool, h.Number)
+				env.TrackSearchers(ctx, pool.Hints, h.Number)
+				if skip {
+					return
+				}
+				env.CheckBalances(ctx, pool)
+				env.SimulateMEV(ctx, pool, pool.Hints)
+				env.ApplyIncentives(ctx, pool)
+				env.AnalyzeBackruns(ctx, pool)
+				env.AnalyzeStateDiffs(ctx, pool)
+				env.EstimateGas(ctx, pool, h.Number)
+				seq := arrivals.Load()
+				merged := pool.Merge()
+				selected, err := buildAndPrint(ctx, env, merged, gasLimit)
+				if err != nil {
+					if ctx.Err() == nil {
+						span.SetError(err)
+						fmt.Fprintf(env.Out, "Error building block: %v\n", err)
+						env.Alerts.BuildFailed(ctx, h.Number, err)
+					}
+					return
+				}
+				env.Alerts.Built(ctx, h.Number, selected)
+				if env.Dashboard != nil {
+					env.Dashboard.Built(h.Number, NewBuildReport(env, merged, gasLimit, selected))
+				}
+				if env.Gas != nil {
+					env.Gas.Packed(selected)
+				}
+				if env.Lifecycle != nil {
+					env.Lifecycle.Built(h.Number, merged, selected)
+				}
+				pool.Hints.Built(selected)
+				if shadow != nil {
+					feed.Publish(NewBlockCandidate(h.Number, env.Config.Builder.Strategy, gasLimit, selected))
+					shadow.Record(h.Number+1, selected)
+					return
+				}
+				var slotCtx context.Context
+				slotCtx, cancelSlot = context.WithCancel(ctx)
+				bidding := env.Relays != nil && reg != nil && env.Schedule.Ours(reg)
+				cancelBid := context.CancelFunc(func() {})
+				publish := func(selected []*Transaction) {
+					feed.Publish(NewBlockCandidate(h.Number, env.Config.Builder.Strategy, gasLimit, selected))
+					if bidding {
+						// A later candidate supersedes the bid for the last
+						cancelBid()
+						cancelBid = startBid(slotCtx, env, pool.Hints, h, gasLimit, reg, selected)
+					}
+				}
+				publish(selected)
+				if inc := env.Config.Builder.Incremental; inc.Enabled {
+					go followArrivals(slotCtx, env, pool, NewCandidate(merged, gasLimit, selected), seq, time.Duration(inc.Interval), publish)
+				}
+			}
+			err = watcher.Run(ctx, func(err error) {
+				fmt.Fprintf(env.Out, "Error polling head: %v\n", err)
+				env.Alerts.RPCFailed(ctx, err)
+			})
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+	},
+}
+
+var simulateCommand = &Command{
+	Name:    "simulate",
+	Summary: "build a block from a local fixture without network access",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		fixture := fs.String("fixture", "", "JSON, CSV or protobuf (.pb) fixture file (as written by fetch -o)")
+		synthetic := fs.Int("synthetic", 0, "generate this many synthetic transactions instead of reading a fixture")
+		seed := fs.Uint64("seed", 1, "RNG seed for -synthetic")
+		save := fs.String("save", "", "write the simulated mempool to this .json, .csv or .pb fixture")
+		mock := fs.Bool("mock-rpc", false, "serve the mempool from an in-process mock RPC and build through the RPC fetch path")
+		gasLimit := fs.Int64("gas-limit", DefaultBlockGasLimit, "block gas limit")
+		ranking := fs.String("ranking", "", "candidate order of greedy strategies, profit or density (default: config)")
+		return func(ctx context.Context, env *Env) error {
+			if err := overrideRanking(env, *ranking); err != nil {
+				return err
+			}
+			ctx, span := StartSpan(ctx, "build", "builder.source", "simulate")
+			defer span.End()
+			var txs []*Transaction
+			switch {
+			case *synthetic > 0:
+				txs = SyntheticMempool(*synthetic, *seed)
+				env.Source, env.Seed = "synthetic", *seed
+			case *fixture != "":
+				env.Source = "fixture"
+				var err error
+				if txs, err = LoadFixture(*fixture); err != nil {
+					return err
+				}
+			default:
+				return errors.New("simulate requires -fixture or -synthetic")
+			}
+			if *save != "" {
+				if err := SaveFixture(*save, txs); err != nil {
+					return err
+				}
+			}
+			pool := env.NewPool()
+			if *mock {
+				server := mockrpc.New()
+				defer server.Close()
+				server.SetPending(mockTxs(txs))
+				server.NewHead(*gasLimit)
+				env.Source = "mock"
+				env.Config.RPC.Endpoints = []string{server.URL}
+				env.RPC = env.Config.RPC.NewClient()
+				if err := pool.FetchTransactions(ctx, env.RPC); err != nil {
+					return fmt.Errorf("fetching transactions: %w", err)
+				}
+				env.CheckNonces(ctx, pool)
+				env.CheckBalances(ctx, pool)
+				_, err := buildAndPrint(ctx, env, pool, env.GasLimit(ctx, 0))
+				return err
+			}
+			for _, tx := range txs {
+				pool.AddTx(tx)
+			}
+			_, err := buildAndPrint(ctx, env, pool, *gasLimit)
+			return err
+		}
+	},
+}
+
+// mockTxs converts txs for a mock node to serve
+func mockTxs(txs []*Transaction) []*mockrpc.Tx {
+	out := make([]*mockrpc.Tx, len(txs))
+	for i, tx := range txs {
+		out[i] = &mockrpc.Tx{
+			Hash:      tx.Hash,
+			From:      tx.From,
+			To:        tx.To,
+			Type:      tx.Type,
+			ChainID:   tx.ChainID,
+			Nonce:     tx.Nonce,
+			GasPrice:  tx.GasPrice,
+			GasTipCap: tx.GasTipCap,
+			GasLimit:  tx.GasLimit,
+			Value:     tx.Value,
+			Input:     tx.Input,
+			Raw:       tx.Raw,
+		}
+		for _, t := range tx.AccessList {
+			out[i].AccessList = append(out[i].AccessList, mockrpc.AccessTuple(t))
+		}
+	}
+	return out
+}
+
+// streamHints feeds MEV-Share hints into hints until ctx is cancelled,
+// resubscribing with backoff whenever the stream drops
+func streamHints(ctx context.Context, env *Env, url string, hints *HintBook) {
+	client := NewHintClient(url)
+	retry := env.Config.RPC.RetryPolicy()
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		err := client.Subscribe(ctx, func(h *Hint) {
+			attempt = 0
+			hints.AddHint(h)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Fprintf(env.Out, "Hint stream dropped, resubscribing: %v\n", err)
+		select {
+		case <-time.After(retry.Backoff(attempt)):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// streamAttributes feeds the beacon node's payload attributes into
+// env.Attributes until ctx is cancelled, resubscribing with backoff
+// whenever the stream drops
+func streamAttributes(ctx context.Context, env *Env) {
+	retry := env.Config.RPC.RetryPolicy()
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		err := env.Attributes.Subscribe(ctx, func(a *PayloadAttributes) {
+			attempt = 0
+			env.Attributes.Add(a)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Fprintf(env.Out, "Payload attributes stream dropped, resubscribing: %v\n", err)
+		select {
+		case <-time.After(retry.Backoff(attempt)):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// overrideRanking applies a -ranking flag over builder.ranking for this
+// build
+func overrideRanking(env *Env, ranking string) error {
+	if ranking == "" {
+		return nil
+	}
+	if r := Ranking(ranking); r != RankProfit && r != RankDensity {
+		return fmt.Errorf("-ranking: unknown ranking %q (want profit or density)", ranking)
+	}
+	env.Config.Builder.Ranking = ranking
+	return nil
+}
+
+// buildAndPrint selects transactions from pool with the configured strategy,
+// prints the block, records a trace and exports a report if enabled
+func buildAndPrint(ctx context.Context, env *Env, pool *TxPool, gasLimit int64) ([]*Transaction, error) {
+	strategy := env.Config.Builder.Strategy
+	deadline := time.Duration(env.Config.Builder.Deadline)
+	budget := env.Config.Builder.ScanBudget
+	if env.Express != nil {
+		pool.Express = env.Express.Grant(time.Now())
+	}
+	if env.Encrypted != nil {
+		pool.Encrypted = env.Encrypted.Set(time.Now())
+	}
+	if env.Commits != nil {
+		pool.Committed = env.Commits.Slots(time.Now())
+	}
+	var trim *TrimResult
+	build := func() ([]*Transaction, bool, error) {
+		if env.Oversub == nil {
+			return SelectWithDeadline(ctx, pool, strategy, gasLimit, deadline, budget)
+		}
+		selected, timedOut, err := SelectWithDeadline(ctx, pool, strategy, env.Oversub.Limit(gasLimit), deadline, budget)
+		if err != nil {
+			return nil, false, err
+		}
+		trimmed, res, err := env.Oversub.Trim(ctx, pool, selected, gasLimit-pool.Encrypted.reserved())
+		if err != nil {
+			fmt.Fprintf(env.Out, "Error trimming oversubscribed block, built to the limit instead: %v\n", err)
+			trim = nil
+			return SelectWithDeadline(ctx, pool, strategy, gasLimit, deadline, budget)
+		}
+		trim = res
+		return trimmed, timedOut, nil
+	}
+	selected, timedOut, err := build()
+	if err != nil {
+		return nil, fmt.Errorf("building block: %w", err)
+	}
+	if env.Revert != nil {
+		var dropped []string
+		selected, timedOut, dropped, err = env.Revert.Protect(ctx, pool, selected, timedOut, build)
+		switch {
+		case selected == nil:
+			return nil, fmt.Errorf("rebuilding block: %w", err)
+		case err != nil:
+			fmt.Fprintf(env.Out, "Error simulating block, built without revert protection: %v\n", err)
+		case len(dropped) > 0:
+			fmt.Fprintf(env.Out, "Revert protection dropped %d reverting entries: %s\n", len(dropped), strings.Join(dropped, ", "))
+			if pool.Hints != nil {
+				pool.Hints.Reverted(dropped)
+			}
+		}
+	}
+	if timedOut {
+		fmt.Fprintf(env.Out, "Build deadline of %s hit, using the best block so far\n", deadline)
+	}
+	if r := pool.LastScan(); r != nil {
+		if env.Scans != nil {
+			env.Scans.Record(r)
+		}
+		if r.Exhausted {
+			fmt.Fprintf(env.Out, "Scan budget spent after examining %d of %d candidates (%.1f%%)\n", r.Examined, r.Candidates, 100*r.Fraction())
+		}
+	}
+	if trim != nil {
+		confirmed := ""
+		if !trim.Confirmed {
+			confirmed = ", unconfirmed"
+		}
+		fmt.Fprintf(env.Out, "Oversubscribed: packed %d entries, trimmed %d after %d simulations to %d gas (%.1f%% of the limit%s)\n",
+			trim.Packed, len(trim.Dropped), trim.Rounds, trim.GasUsed, 100*float64(trim.GasUsed)/float64(gasLimit), confirmed)
+	}
+	if g := pool.Express; g != nil && slices.ContainsFunc(selected, pool.inExpress) {
+		fmt.Fprintf(env.Out, "Express lane: round %d held by %s for %d wei\n", g.Round, g.Controller, g.Price)
+	}
+	PrintBlock(env.Out, selected, gasLimit)
+	if gas, estimated := packedGas(selected); estimated {
+		fmt.Fprintf(env.Out, "Estimated gas used: %d (%.1f%% of the limit)\n", gas, 100*float64(gas)/float64(gasLimit))
+	}
+	if r := pool.LastSearch(); strategy == "anneal" && r != nil {
+		gain := 0.0
+		if r.Baseline > 0 {
+			gain = 100 * float64(r.Improvement()) / float64(r.Baseline)
+		}
+		fmt.Fprintf(env.Out, "Local search: +%s over greedy (+%.2f%%) in %d iterations, %d accepted, %d improving\n",
+			FormatWei(r.Improvement()), gain, r.Iterations, r.Accepted, r.Improved)
+	}
+	if pool.Hints != nil && pool.Hints.Merge {
+		merged, dropped := pool.Hints.MergeReport()
+		for _, id := range slices.Sorted(maps.Keys(merged)) {
+			fmt.Fprintf(env.Out, "Merged bundles %s into one entry\n", strings.Join(merged[id], ", "))
+		}
+		for _, id := range slices.Sorted(maps.Keys(dropped)) {
+			fmt.Fprintf(env.Out, "Dropped bundle %s: %s\n", id, dropped[id])
+		}
+	}
+	if r := pool.LastExact(); strategy == "wis" && r != nil {
+		spent := ""
+		if r.BudgetSpent {
+			spent = "; budget spent, the rest solved greedily"
+		}
+		fmt.Fprintf(env.Out, "Conflict components: %d of %d solved exactly in %d nodes%s\n", r.Exact, r.Components, r.Nodes, spent)
+	}
+	if r := pool.LastParallel(); strategy == "parallel" && r != nil {
+		fmt.Fprintf(env.Out, "Parallel packing: %d transactions in %d waves (%.1f per wave); %d of %d speculated rolled back over %d rounds\n",
+			r.Packed, r.Waves, float64(r.Packed)/float64(max(r.Waves, 1)), r.RolledBack, r.Speculated, r.Rounds)
+	}
+	if env.TraceDir != "" {
+		trace := NewTrace(pool, env.Source, strategy, env.Seed, gasLimit, selected)
+		trace.TimedOut = timedOut
+		if r := pool.LastScan(); r != nil && r.Exhausted {
+			trace.ScanExamined = r.Examined
+		}
+		path, err := trace.Save(env.TraceDir)
+		if err != nil {
+			return nil, fmt.Errorf("saving trace: %w", err)
+		}
+		fmt.Fprintf(env.Out, "Trace: %s\n", path)
+	}
+	if env.Config.Report.Enabled() {
+		if err := NewBuildReport(env, pool, gasLimit, selected).Export(ctx, env.Config.Report); err != nil {
+			return nil, fmt.Errorf("exporting report: %w", err)
+		}
+	}
+	return selected, nil
+}
+
+// assemblePayload turns the selected transactions into an execution
+// payload on parent with attrs, paying their fee recipient (which must be
+// the registered one if reg is not nil) through the coinbase if a coinbase
+// key is set
+func assemblePayload(ctx context.Context, env *Env, parent *Header, attrs *PayloadAttributes, gasLimit int64, reg *ValidatorRegistration, selected []*Transaction) (*BlockTemplate, *Block, error) {
+	recipient := attrs.SuggestedFeeRecipient
+	if env.Coinbase != nil && recipient.IsZero() {
+		return nil, nil, errors.New("a fee recipient (-fee-recipient, builder.fee_recipient or a proposer registration) is required to pay the proposer from the coinbase key")
+	}
+	if err := FetchRawTransactions(ctx, env.RPC, selected); err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := NewBlockTemplate(parent, attrs, gasLimit, selected)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.Coinbase != nil {
+		nonce, err := FetchNonce(ctx, env.RPC, env.Coinbase.Address())
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := tmpl.AddPayment(env.Coinbase, env.Config.ChainID, nonce, recipient); err != nil {
+			return nil, nil, fmt.Errorf("paying the proposer: %w", err)
+		}
+		fmt.Fprintf(env.Out, "Proposer payment: %s from %s to %s (tx %s)\n",
+			FormatWei(tmpl.Value), env.Coinbase.Address(), recipient, tmpl.Payment.Hash)
+	}
+	var results []TxResult
+	if env.Config.Builder.Execute {
+		res, delta, err := ExecutePayload(ctx, env.RPC, tmpl)
+		if err != nil {
+			fmt.Fprintf(env.Out, "Executing payload: %v; using estimates\n", err)
+		} else if err := tmpl.Executed(delta); err != nil {
+			return nil, nil, err
+		} else {
+			results = res
+		}
+	}
+	fmt.Fprintf(env.Out, "Block value: %s to %s\n", FormatWei(tmpl.Value), tmpl.ProposerRecipient())
+	if reg != nil {
+		if err := tmpl.CheckFeeRecipient(reg.FeeRecipient); err != nil {
+			return nil, nil, err
+		}
+	}
+	block, err := AssembleBlock(tmpl, results)
+	if err != nil {
+		return nil, nil, err
+	}
+	gas := "estimated"
+	if results != nil {
+		gas = "executed"
+	}
+	fmt.Fprintf(env.Out, "Block hash: %s (gas used %d, %s)\n", block.Header.Hash(), block.Header.GasUsed, gas)
+	return tmpl, block, nil
+}
+
+// startBid assembles selected into a payload for the slot after h and
+// bids it in the background, returning a function that cancels the bid.
+// It doesn't bid if a bundle in selected has been cancelled since.
+func startBid(ctx context.Context, env *Env, hints *HintBook, h *Header, gasLimit int64, reg *ValidatorRegistration, selected []*Transaction) context.CancelFunc {
+	// Bid on copies: raw encodings are filled in as the pool is read
+	bid := make([]*Transaction, len(selected))
+	for i, tx := range selected {
+		c := *tx
+		bid[i] = &c
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		attrs, err := env.PayloadAttributes(h, reg.FeeRecipient)
+		var tmpl *BlockTemplate
+		if err == nil {
+			tmpl, _, err = assemblePayload(ctx, env, h, attrs, gasLimit, reg, bid)
+		}
+		if err == nil {
+			// Checked as late as possible: a searcher may cancel up to
+			// the moment the bid goes out
+			if ids := hints.Cancelled(bid); len(ids) > 0 {
+				err = fmt.Errorf("cancelled bundles %s", strings.Join(ids, ", "))
+			}
+		}
+		if err == nil {
+			err = submitBid(ctx, env, tmpl, h.Number+1, reg)
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(env.Out, "Error bidding for slot %d: %v\n", h.Number+1, err)
+		}
+	}()
+	return cancel
+}
+
+// submitBid seals tmpl into a bid for slot and submits it to the relays,
+// printing each relay's outcome
+func submitBid(ctx context.Context, env *Env, tmpl *BlockTemplate, slot uint64, reg *ValidatorRegistration) error {
+	ctx, span := StartSpan(ctx, "relay.submit", "relay.slot", slot, "relay.count", len(env.Relays.Relays))
+	defer span.End()
+	req, err := env.Relays.Seal(ctx, tmpl, slot, reg.Pubkey)
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("sealing bid: %w", err)
+	}
+	results := env.Relays.Submit(ctx, req)
+	if env.Dashboard != nil {
+		env.Dashboard.Submitted(slot, results)
+	}
+	accepted := 0
+	for _, res := range results {
+		switch {
+		case res.Pending:
+			fmt.Fprintf(env.Out, "Relay %s: bid sent (optimistic)\n", res.URL)
+		case res.Err != nil:
+			fmt.Fprintf(env.Out, "Relay %s: bid failed after %s: %v\n", res.URL, res.Latency.Round(time.Millisecond), res.Err)
+		default:
+			accepted++
+			fmt.Fprintf(env.Out, "Relay %s: bid accepted in %s\n", res.URL, res.Latency.Round(time.Millisecond))
+		}
+	}
+	span.SetAttr("relay.accepted", accepted)
+	return nil
+}
+
+// packedGas returns the gas txs are counted as using and whether any of
+// that is an estimate rather than a gas limit
+func packedGas(txs []*Transaction) (int64, bool) {
+	gas, estimated := int64(0), false
+	for _, tx := range txs {
+		gas += tx.PackGas()
+		estimated = estimated || tx.PackGas() < tx.GasLimit
+	}
+	return gas, estimated
+}
+
+// writeJSON writes v as indented JSON to path, or stdout if path is empty
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommitReveal is an experimental two-phase ordering on top of the pool,
+// for prototyping MEV-mitigation schemes. In phase one, a round of Round
+// wall-clock time, senders commit to transactions by hash alone: the
+// keccak256 of the signed transaction followed by a secret salt. When the
+// round closes its commitments are ordered by the keccak256 of each
+// commitment and a seed hashing all of the round's, which no committer
+// knew while committing, so nobody can pick their place. In phase two,
+// from then on, a sender reveals the transaction and salt; it is pooled
+// privately and slotted at its commitment's place in every block, after
+// the decrypted transactions and ahead of the express lane and lanes, with
+// earlier rounds first. Commitments never revealed leave no gap. A round's
+// slots are kept for Rounds rounds after it closes. Its methods are safe
+// for concurrent use.
+type CommitReveal struct {
+	Round  time.Duration
+	Rounds int64 // closed rounds whose slots are kept
+
+	mu     sync.Mutex
+	rounds map[int64][]*Commitment // by round, in arrival order until closed
+	closed map[int64]bool          // rounds ordered
+}
+
+// Commitment is one phase-one commitment and what became of it
+type Commitment struct {
+	Hash  string `json:"hash"`            // keccak256 of the signed transaction and salt
+	Round int64  `json:"round"`           // in which it was committed
+	Key   string `json:"key,omitempty"`   // orders the round once it closes
+	Tx    string `json:"tx,omitempty"`    // the revealed transaction's hash
+	Error string `json:"error,omitempty"` // why a reveal was refused
+}
+
+// NewCommitReveal returns the experiment configured from cfg, or nil if it
+// is disabled
+func NewCommitReveal(cfg CommitRevealConfig) *CommitReveal {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &CommitReveal{
+		Round:  time.Duration(cfg.Round),
+		Rounds: int64(cfg.Rounds),
+		rounds: map[int64][]*Commitment{},
+		closed: map[int64]bool{},
+	}
+}
+
+// RoundAt returns the round t falls in
+func (c *CommitReveal) RoundAt(t time.Time) int64 {
+	return t.UnixNano() / int64(c.Round)
+}
+
+// Commit records a commitment in the round open at now and returns the
+// round
+func (c *CommitReveal) Commit(hash string, now time.Time) (int64, error) {
+	h, err := HexToHash(hash)
+	if err != nil {
+		return 0, fmt.Errorf("hash: %w", err)
+	}
+	hash = h.Hex()
+	round := c.RoundAt(now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settle(round)
+	for r, commits := range c.rounds {
+		if slices.ContainsFunc(commits, func(o *Commitment) bool { return o.Hash == hash }) {
+			return 0, fmt.Errorf("%s was committed in round %d", hash, r)
+		}
+	}
+	c.rounds[round] = append(c.rounds[round], &Commitment{Hash: hash, Round: round})
+	return round, nil
+}
+
+// settle orders every round before current not yet closed and forgets
+// those past the slots kept. Called with c locked.
+func (c *CommitReveal) settle(current int64) {
+	for r, commits := range c.rounds {
+		switch {
+		case r < current-c.Rounds:
+			delete(c.rounds, r)
+			delete(c.closed, r)
+		case r < current && !c.closed[r]:
+			var all []byte
+			for _, cm := range slices.SortedFunc(slices.Values(commits), func(a, b *Commitment) int { return strings.Compare(a.Hash, b.Hash) }) {
+				all = append(all, cm.Hash...)
+			}
+			seed := Keccak256(all)
+			for _, cm := range commits {
+				cm.Key = Keccak256([]byte(cm.Hash), seed[:]).Hex()
+			}
+			slices.SortFunc(commits, func(a, b *Commitment) int { return strings.Compare(a.Key, b.Key) })
+			c.closed[r] = true
+		}
+	}
+}
+
+// Reveal opens the commitment to raw, a signed transaction, and salt if
+// its round has closed, pooling the transaction privately in mempool until
+// its slots expire
+func (c *CommitReveal) Reveal(raw, salt []byte, mempool Mempool, now time.Time) (*Commitment, error) {
+	hash := Keccak256(raw, salt).Hex()
+	current := c.RoundAt(now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settle(current)
+	var cm *Commitment
+	for _, commits := range c.rounds {
+		if i := slices.IndexFunc(commits, func(o *Commitment) bool { return o.Hash == hash }); i >= 0 {
+			cm = commits[i]
+		}
+	}
+	switch {
+	case cm == nil:
+		return nil, fmt.Errorf("nothing committed to %s", hash)
+	case !c.closed[cm.Round]:
+		return nil, fmt.Errorf("round %d is still taking commitments", cm.Round)
+	case cm.Tx != "":
+		return nil, fmt.Errorf("%s was revealed as %s", hash, cm.Tx)
+	}
+	tx, err := RecoverSender(raw)
+	if err != nil {
+		cm.Error = err.Error()
+		return nil, err
+	}
+	tx.ReceivedAt = now
+	ttl := time.Duration(cm.Round+c.Rounds+1)*c.Round - time.Duration(now.UnixNano())
+	if res, reason := mempool.Submit(tx, max(ttl, c.Round)); res == TxRejected && reason != RejectDuplicate {
+		cm.Error = fmt.Sprintf("rejected: %s", reason)
+		return nil, fmt.Errorf("transaction %s", cm.Error)
+	}
+	cm.Tx, cm.Error = tx.Hash, ""
+	return cm, nil
+}
+
+// Slots returns the revealed transactions in their committed order for a
+// build at now: the closed rounds still kept, earliest first
+func (c *CommitReveal) Slots(now time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settle(c.RoundAt(now))
+	var hashes []string
+	for _, r := range slices.Sorted(maps.Keys(c.closed)) {
+		for _, cm := range c.rounds[r] {
+			if cm.Tx != "" {
+				hashes = append(hashes, cm.Tx)
+			}
+		}
+	}
+	return hashes
+}
+
+// Status returns every round's commitments, open ones in arrival order and
+// closed ones in their slot order
+func (c *CommitReveal) Status(now time.Time) map[int64][]Commitment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settle(c.RoundAt(now))
+	out := make(map[int64][]Commitment, len(c.rounds))
+	for r, commits := range c.rounds {
+		for _, cm := range commits {
+			out[r] = append(out[r], *cm)
+		}
+	}
+	return out
+}
+
+// handleCommits returns every kept round's commitments
+func (s *APIServer) handleCommits(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, s.CommitReveal.Status(time.Now()))
+}
+
+// handleCommit records a commitment, given as {"hash": ...}
+func (s *APIServer) handleCommit(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	round, err := s.CommitReveal.Commit(req.Hash, received)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHTTPJSON(w, map[string]int64{"round": round})
+}
+
+// handleReveal opens a commitment, given as {"raw": "0x...", "salt":
+// "0x..."}
+func (s *APIServer) handleReveal(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req struct {
+		Raw  HexBytes `json:"raw"`
+		Salt HexBytes `json:"salt"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cm, err := s.CommitReveal.Reveal(req.Raw, req.Salt, s.Pool, received)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHTTPJSON(w, cm)
+}
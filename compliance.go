@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExclusionRule names the compliance rule that kept a transaction out of
+// the pool
+type ExclusionRule string
+
+const (
+	RuleBlockedSender    ExclusionRule = "blocked_sender"
+	RuleBlockedRecipient ExclusionRule = "blocked_recipient"
+	RuleNotAllowlisted   ExclusionRule = "not_allowlisted"
+)
+
+// AddressPolicy excludes transactions from built blocks by address. Any
+// transaction whose sender or recipient is blocklisted is excluded; if the
+// allowlist is non-empty, so is any transaction whose sender isn't on it.
+// Every exclusion is written to Audit as a JSON line.
+type AddressPolicy struct {
+	Block map[Address]bool
+	Allow map[Address]bool
+	Audit io.Writer
+
+	mu sync.Mutex
+}
+
+// AuditEntry is one line of the compliance audit log
+type AuditEntry struct {
+	At   time.Time     `json:"at"`
+	Hash string        `json:"hash"`
+	From string        `json:"from,omitempty"`
+	To   string        `json:"to,omitempty"`
+	Rule ExclusionRule `json:"rule"`
+}
+
+// NewAddressPolicy builds the policy described by cfg, loading list files.
+// It returns nil if no lists are configured.
+func NewAddressPolicy(cfg ComplianceConfig) (*AddressPolicy, error) {
+	p := &AddressPolicy{Block: map[Address]bool{}, Allow: map[Address]bool{}, Audit: os.Stderr}
+	for _, src := range []struct {
+		dst   map[Address]bool
+		addrs []string
+		file  string
+	}{
+		{p.Block, cfg.Blocklist, cfg.BlocklistFile},
+		{p.Allow, cfg.Allowlist, cfg.AllowlistFile},
+	} {
+		addrs := src.addrs
+		if src.file != "" {
+			more, err := readAddressFile(src.file)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, more...)
+		}
+		for _, s := range addrs {
+			a, err := HexToAddress(s)
+			if err != nil {
+				return nil, err
+			}
+			src.dst[a] = true
+		}
+	}
+	if len(p.Block) == 0 && len(p.Allow) == 0 {
+		return nil, nil
+	}
+	if cfg.AuditLog != "" {
+		f, err := os.OpenFile(cfg.AuditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		p.Audit = f
+	}
+	return p, nil
+}
+
+// readAddressFile reads one address per line, ignoring blank lines and
+// # comments
+func readAddressFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var addrs []string
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		s, _, _ := strings.Cut(sc.Text(), "#")
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if _, err := HexToAddress(s); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		addrs = append(addrs, s)
+	}
+	return addrs, sc.Err()
+}
+
+// Check returns the rule tx violates, or "" if it may be included
+func (p *AddressPolicy) Check(tx *Transaction) ExclusionRule {
+	from, fromErr := HexToAddress(tx.From)
+	to, toErr := HexToAddress(tx.To)
+	switch {
+	case fromErr == nil && p.Block[from]:
+		return RuleBlockedSender
+	case toErr == nil && p.Block[to]:
+		return RuleBlockedRecipient
+	case len(p.Allow) > 0 && (fromErr != nil || !p.Allow[from]):
+		return RuleNotAllowlisted
+	}
+	return ""
+}
+
+// Exclude checks tx and records an audit entry if it is excluded
+func (p *AddressPolicy) Exclude(tx *Transaction) bool {
+	rule := p.Check(tx)
+	if rule == "" {
+		return false
+	}
+	line, _ := json.Marshal(AuditEntry{At: time.Now().UTC(), Hash: tx.Hash, From: tx.From, To: tx.To, Rule: rule})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Audit.Write(append(line, '\n'))
+	return true
+}
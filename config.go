@@ -0,0 +1,1257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Config is the engine configuration, loadable from a TOML or JSON file
+type Config struct {
+	Chain        string             `json:"chain"` // chain profile supplying the defaults below
+	ChainID      uint64             `json:"chain_id"`
+	PoL          PoLContracts       `json:"pol"`
+	Forks        Forks              `json:"forks"`
+	RPC          RPCConfig          `json:"rpc"`
+	Builder      BuilderConfig      `json:"builder"`
+	Pool         PoolConfig         `json:"pool"`
+	Oracle       OracleConfig       `json:"oracle"`
+	Lanes        []LaneConfig       `json:"lanes"`
+	ExpressLane  ExpressLaneConfig  `json:"express_lane"`
+	Encrypted    EncryptedConfig    `json:"encrypted"`
+	CommitReveal CommitRevealConfig `json:"commit_reveal"`
+	HTTP         HTTPConfig         `json:"http"`
+	Keys         KeysConfig         `json:"keys"`
+	Compliance   ComplianceConfig   `json:"compliance"`
+	API          APIConfig          `json:"api"`
+	Private      PrivateConfig      `json:"private"`
+	MEVShare     MEVShareConfig     `json:"mevshare"`
+	Relay        RelayConfig        `json:"relay"`
+	Backrun      BackrunConfig      `json:"backrun"`
+	StateDiff    StateDiffConfig    `json:"statediff"`
+	MEVBonus     MEVBonusConfig     `json:"mevbonus"`
+	Simulation   SimulationConfig   `json:"simulation"`
+	State        StateConfig        `json:"state"`
+	Report       ReportConfig       `json:"report"`
+	Tracing      TracingConfig      `json:"tracing"`
+	Alerts       AlertsConfig       `json:"alerts"`
+	P2P          P2PConfig          `json:"p2p"`
+	Beacon       BeaconConfig       `json:"beacon"`
+}
+
+// BeaconConfig configures the consensus client serve follows for the
+// payload attributes of each block
+type BeaconConfig struct {
+	URL string `json:"url"` // beacon node API base URL; empty builds with default attributes
+}
+
+// RPCConfig configures the upstream JSON-RPC endpoints
+type RPCConfig struct {
+	Endpoints    []string `json:"endpoints"` // primary first
+	Timeout      Duration `json:"timeout"`
+	MaxAttempts  int      `json:"max_attempts"`
+	Backoff      Duration `json:"backoff"`
+	MaxBackoff   Duration `json:"max_backoff"`
+	MaxBatchSize int      `json:"max_batch_size"`
+
+	// MaxResponseSize caps the bytes read from one response; responses are
+	// decoded as they stream in, so this bounds memory rather than buffering
+	MaxResponseSize int64 `json:"max_response_size"`
+
+	// BreakerFailures failures in a row open an endpoint's circuit breaker,
+	// routing calls to the next endpoint until a probe, every
+	// BreakerCooldown, finds it answering again; 0 disables the breakers
+	// and every call goes to the primary
+	BreakerFailures int      `json:"breaker_failures"`
+	BreakerCooldown Duration `json:"breaker_cooldown"`
+}
+
+// BuilderConfig configures block packing
+type BuilderConfig struct {
+	Strategy string   `json:"strategy"`
+	Scorer   string   `json:"scorer"`    // registered Scorer transactions are ranked by
+	GasLimit int64    `json:"gas_limit"` // 0 follows the live chain gas limit
+	Deadline Duration `json:"deadline"`  // return the best block so far after this long; 0 waits
+
+	// ScanBudget stops the packer's scan of the pool once spent, keeping
+	// what it selected
+	ScanBudget ScanBudget `json:"scan_budget"`
+
+	// FeeRecipient is the proposer's address that built blocks pay, unless
+	// a -fee-recipient flag or a proposer registration says otherwise
+	FeeRecipient string `json:"fee_recipient"`
+
+	// Coinbase is the builder's own fee-recipient address; it must match
+	// keys.coinbase_key_file, guarding against loading the wrong key
+	Coinbase string `json:"coinbase"`
+
+	// InclusionList is a JSON file of hashes and raw transactions every
+	// block must include; the build fails if they can't be
+	InclusionList string `json:"inclusion_list"`
+
+	// Search tunes the local search run after the greedy pack by "anneal"
+	Search SearchConfig `json:"search"`
+
+	// Ranking orders the candidates of "greedy", "anneal" and "parallel":
+	// "profit" by score, "density" by score per unit of gas
+	Ranking string `json:"ranking"`
+
+	// Exact bounds the branch-and-bound solver "wis" runs on conflict
+	// components
+	Exact ExactConfig `json:"exact"`
+
+	// Parallel tunes the speculative packing of "parallel"
+	Parallel ParallelConfig `json:"parallel"`
+
+	// Fair sets the arrival buckets of "fair"
+	Fair FairConfig `json:"fair"`
+
+	// Weights weighs tips, MEV and PoL bonuses in the default score
+	Weights ScoreWeights `json:"weights"`
+
+	// CuttingBoard weights each PoL bonus by the share of its reward vault
+	// in the proposer's BGT allocation, read from pol.berachef; proposers
+	// are known from relay.url registrations
+	CuttingBoard bool `json:"cutting_board"`
+
+	// Incentives credits transactions feeding a reward vault with its
+	// incentives, read from pol.reward_vault_factory's vaults
+	Incentives IncentivesConfig `json:"incentives"`
+
+	// GasEstimate packs on expected gas used instead of gas limits
+	GasEstimate GasEstimateConfig `json:"gas_estimate"`
+
+	// Execute runs assembled payloads with eth_simulateV1, so their
+	// receipts, logs bloom, gas used and value come from execution rather
+	// than gas limits
+	Execute bool `json:"execute"`
+
+	// Incremental keeps serve's candidate current as transactions arrive
+	// mid-slot
+	Incremental IncrementalConfig `json:"incremental"`
+
+	// RevertProtection drops bundles and marked transactions that would
+	// revert in the built block
+	RevertProtection RevertProtectionConfig `json:"revert_protection"`
+
+	// Oversubscribe packs past the gas limit on estimated gas used and
+	// trims the block once simulation has measured it
+	Oversubscribe OversubscribeConfig `json:"oversubscribe"`
+
+	// Hooks run around the packer in order, applying ordering rules such
+	// as system transactions first
+	Hooks []HookConfig `json:"hooks"`
+
+	// Quotas cap the gas one sender or contract may take in a block
+	Quotas QuotaConfig `json:"quotas"`
+
+	// Boosts raise the score of transactions calling chosen contracts
+	Boosts []BoostConfig `json:"boosts"`
+}
+
+// IncentivesConfig configures reading PoL incentive rates from the reward
+// vaults and crediting them as PoL bonuses
+type IncentivesConfig struct {
+	Enabled  bool             `json:"enabled"`
+	TTL      Duration         `json:"ttl"`        // how long read rates are reused
+	BGTPerTx float64          `json:"bgt_per_tx"` // BGT of a vault's incentives one transaction feeding it is credited
+	Tokens   []IncentiveToken `json:"tokens"`
+}
+
+// IncentiveToken prices an incentive token
+type IncentiveToken struct {
+	Address string  `json:"address"`
+	Price   float64 `json:"price"` // BERA wei per token base unit
+}
+
+// BoostConfig configures one contract boost
+type BoostConfig struct {
+	Name       string   `json:"name"`
+	To         []string `json:"to"`         // contracts whose callers are boosted
+	Selectors  []string `json:"selectors"`  // only these 4-byte function selectors, if set
+	Multiplier float64  `json:"multiplier"` // scales the score; 0 leaves it
+	Bonus      int64    `json:"bonus"`      // wei added to the score
+}
+
+// QuotaConfig configures per-sender and per-contract gas caps, enforced as
+// blocks are packed
+type QuotaConfig struct {
+	SenderShare float64         `json:"sender_share"` // most of the block's gas one sender may take, as a fraction; 0 is uncapped
+	Contracts   []ContractQuota `json:"contracts"`
+}
+
+// ContractQuota caps the gas of the transactions calling one contract
+// directly; with both caps set the lower applies
+type ContractQuota struct {
+	Address string  `json:"address"`
+	MaxGas  int64   `json:"max_gas"` // gas per block
+	Share   float64 `json:"share"`   // fraction of the block's gas
+}
+
+// HookConfig configures one proposal hook
+type HookConfig struct {
+	Hook string   `json:"hook"` // registered ProposalHook; "system-first" is built in
+	To   []string `json:"to"`   // recipient addresses the hook applies to
+	From []string `json:"from"` // sender addresses the hook applies to
+}
+
+// RevertProtectionConfig configures simulating built blocks for reverts
+type RevertProtectionConfig struct {
+	Enabled bool `json:"enabled"`
+	Rounds  int  `json:"rounds"` // rebuilds to re-pack freed gas before reverting entries are just dropped
+}
+
+// OversubscribeConfig configures packing past the gas limit and trimming
+// after simulation
+type OversubscribeConfig struct {
+	Enabled bool    `json:"enabled"`
+	Factor  float64 `json:"factor"` // extra gas packed, as a fraction of the limit
+	Rounds  int     `json:"rounds"` // simulations of the trimmed block before it is taken as is
+}
+
+// GasEstimateConfig configures gas-used estimation from mined receipts
+// and, optionally, simulation
+type GasEstimateConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Margin      float64 `json:"margin"`       // safety margin over the estimate, as a fraction
+	MinSamples  int     `json:"min_samples"`  // mined transactions to a contract before it is estimated
+	History     int     `json:"history"`      // recent blocks learned from before the first build
+	SimulateTxs int     `json:"simulate_txs"` // most profitable transactions traced per build for their refund-aware gas; 0 traces none
+}
+
+// PoolConfig configures pool admission
+type PoolConfig struct {
+	MinGasPrice    int64    `json:"min_gas_price"`  // fee floor in wei
+	MinTip         int64    `json:"min_tip"`        // priority fee floor in wei per gas
+	TipFloor       string   `json:"tip_floor"`      // reject or deprioritize txs below min_tip
+	MaxPerSender   int      `json:"max_per_sender"` // pooled txs per sender; 0 is unlimited
+	MaxSenderGas   int64    `json:"max_sender_gas"` // aggregate gas limit of a sender's pooled txs; 0 is unlimited
+	MaxBytes       int64    `json:"max_bytes"`      // approximate memory pooled txs may hold; 0 is unbounded
+	SeenTTL        Duration `json:"seen_ttl"`
+	QuarantineSize int      `json:"quarantine_size"`
+	Shards         int      `json:"shards"`         // serve splits the pool by sender over this many locks
+	CheckBalances  bool     `json:"check_balances"` // leave out transactions their sender can't pay for
+	TrackNonces    bool     `json:"track_nonces"`   // drop stale nonces and promote from the confirmed one
+
+	// Aging boosts transactions that have waited many blocks; see AgingConfig
+	Aging AgingConfig `json:"aging"`
+}
+
+// OracleConfig configures the fee oracle
+type OracleConfig struct {
+	Blocks     int `json:"blocks"`     // recent blocks to sample
+	Percentile int `json:"percentile"` // tip percentile suggested as the inclusion floor
+}
+
+// ExpressLaneConfig configures the express lane auction; see ExpressLane
+type ExpressLaneConfig struct {
+	Enabled bool     `json:"enabled"`
+	Round   Duration `json:"round"`   // length of each auctioned window
+	Reserve int64    `json:"reserve"` // least bid accepted, in wei per block
+	MaxGas  int64    `json:"max_gas"` // gas the winner's transactions may take per block; 0 is unbounded
+}
+
+// EncryptedConfig configures threshold-encrypted submissions; see
+// EncryptedPool
+type EncryptedConfig struct {
+	Enabled bool     `json:"enabled"`
+	TTL     Duration `json:"ttl"`     // how long a ciphertext waits for its key
+	MaxGas  int64    `json:"max_gas"` // committed gas held at once; 0 is unbounded
+}
+
+// CommitRevealConfig configures the commit-reveal ordering experiment; see
+// CommitReveal
+type CommitRevealConfig struct {
+	Enabled bool     `json:"enabled"`
+	Round   Duration `json:"round"`  // length of each commitment phase
+	Rounds  int      `json:"rounds"` // closed rounds whose slots are kept
+}
+
+// LaneConfig reserves block space for a class of transactions
+type LaneConfig struct {
+	Name   string   `json:"name"`
+	MaxTxs int      `json:"max_txs"`
+	MaxGas int64    `json:"max_gas"`
+	To     []string `json:"to"`   // recipient addresses routed to this lane
+	From   []string `json:"from"` // sender addresses routed to this lane
+
+	// Selectors restricts the lane to calls of these 4-byte function
+	// selectors, such as an oracle's price update
+	Selectors []string `json:"selectors"`
+}
+
+// KeysConfig points at key material used for signing and authentication
+type KeysConfig struct {
+	BuilderKeyFile string `json:"builder_key_file"`
+
+	// JWTSecretFile is the execution client's jwtsecret file. If set, every
+	// RPC request carries an HS256 token signed with it, so rpc.endpoints
+	// may be the client's authenticated port.
+	JWTSecretFile string `json:"jwt_secret_file"`
+
+	// CoinbaseKeyFile holds the hex secp256k1 key of the builder's coinbase
+	// account. If set, built payloads pay fees to it and end with a
+	// transaction passing the proceeds on to the proposer.
+	CoinbaseKeyFile string `json:"coinbase_key_file"`
+}
+
+// ComplianceConfig lists addresses to exclude from built blocks
+type ComplianceConfig struct {
+	Blocklist     []string `json:"blocklist"`
+	BlocklistFile string   `json:"blocklist_file"` // one address per line
+	Allowlist     []string `json:"allowlist"`      // if set, only these senders are included
+	AllowlistFile string   `json:"allowlist_file"`
+	AuditLog      string   `json:"audit_log"` // JSON lines, one per exclusion; stderr if unset
+}
+
+// APIConfig configures the HTTP API served by the serve command
+type APIConfig struct {
+	Listen string `json:"listen"` // address such as "127.0.0.1:8080"; empty disables the API
+	GRPC   bool   `json:"grpc"`   // also answer gRPC calls (builder.proto) on the listener, over HTTP/2
+	Debug  bool   `json:"debug"`  // also serve /debug/pprof and /debug/pool; keep the API private
+
+	// Dashboard serves a web dashboard at /dashboard. Like Debug it is
+	// unauthenticated and shows pool contents, so keep the API private.
+	Dashboard bool `json:"dashboard"`
+
+	// Lifecycle follows every pooled transaction from arrival to being
+	// mined or evicted, serving the aggregates at /lifecycle and /metrics
+	Lifecycle bool `json:"lifecycle"`
+
+	// Ingress serves a JSON-RPC endpoint at /rpc that wallets can send
+	// transactions to
+	Ingress IngressConfig `json:"ingress"`
+}
+
+// IngressConfig configures the /rpc endpoint. It is unauthenticated, like
+// any public RPC.
+type IngressConfig struct {
+	Enabled bool     `json:"enabled"`
+	Forward []string `json:"forward"` // nodes submissions are also sent to; empty keeps them private to the builder
+}
+
+// PrivateConfig configures private order-flow submission
+type PrivateConfig struct {
+	Tokens []string `json:"tokens"` // bearer tokens accepted by POST /private; none disables it
+	TTL    Duration `json:"ttl"`    // how long a private transaction is kept unmined
+}
+
+// RelayConfig configures the MEV-Boost relay proposers register with and
+// the relays built blocks are bid to
+type RelayConfig struct {
+	URL string `json:"url"` // builder API base URL; empty ignores registrations
+
+	// BuilderPubkey is the BLS public key bids are signed with, by Signer:
+	// a command given the 0x-prefixed signing root as its last argument
+	// that prints the 0x-prefixed BLS signature
+	BuilderPubkey string `json:"builder_pubkey"`
+	Signer        string `json:"signer"`
+
+	// GenesisForkVersion is the chain's genesis fork version, part of the
+	// builder signing domain
+	GenesisForkVersion string `json:"genesis_fork_version"`
+
+	Submit []RelayTargetConfig `json:"submit"` // relays every sealed bid goes to
+
+	// Validators are the BLS public keys of our own proposers; if set,
+	// serve only builds and bids for their slots, unless ShadowOtherSlots
+	// keeps it building the rest without bidding
+	Validators       []string `json:"validators"`
+	ShadowOtherSlots bool     `json:"shadow_other_slots"`
+}
+
+// RelayTargetConfig configures submission to one relay
+type RelayTargetConfig struct {
+	URL     string   `json:"url"`
+	Timeout Duration `json:"timeout"` // per submission
+
+	// Optimistic relays aren't waited on: a bid counts as sent once
+	// posted and the relay's verdict only lands in its metrics
+	Optimistic bool `json:"optimistic"`
+
+	// Cancellations lets a later bid for the slot replace an earlier,
+	// higher one (the relay's cancellations mode)
+	Cancellations bool `json:"cancellations"`
+}
+
+// P2PConfig configures receiving transactions from execution clients over
+// devp2p; see P2P
+type P2PConfig struct {
+	Peers       []string `json:"peers"`         // enode URLs of execution clients to dial
+	Listen      string   `json:"listen"`        // also accept peers on this address, such as ":30303"
+	NodeKeyFile string   `json:"node_key_file"` // hex secp256k1 node key; empty makes a new identity every run
+	Hold        Duration `json:"hold"`          // how long a gossiped transaction is kept before the RPC node has it
+	Redial      Duration `json:"redial"`        // wait before redialling a dropped peer
+}
+
+// MEVShareConfig configures consumption of an MEV-Share hint stream
+type MEVShareConfig struct {
+	URL     string   `json:"url"`      // event stream; empty disables hints
+	HintTTL Duration `json:"hint_ttl"` // how long hints are kept for bundles to match
+
+	// SandwichPolicy is reject, deprioritize or allow for bundles that
+	// enclose a victim between two transactions of the same searcher
+	SandwichPolicy string `json:"sandwich_policy"`
+
+	// MergeBundles combines bundles sharing a transaction or conflicting
+	// into their most valuable compatible subset
+	MergeBundles bool `json:"merge_bundles"`
+}
+
+// BackrunConfig configures backrun detection against known DEX pools
+type BackrunConfig struct {
+	Enabled bool            `json:"enabled"`
+	WBERA   string          `json:"wbera"`    // token backrun profits are measured in
+	MinSwap int64           `json:"min_swap"` // smallest swap amountIn simulated, in token base units
+	Pools   []DEXPoolConfig `json:"pools"`
+}
+
+// StateDiffConfig configures storage conflict detection by tracing pending
+// transactions; the node must serve debug_traceCall
+type StateDiffConfig struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode"`    // write or read-write
+	MaxTxs  int    `json:"max_txs"` // most profitable transactions traced per build; 0 traces all
+}
+
+// MEVBonusConfig configures simulating what pending transactions and
+// bundles pay the builder directly; the node must serve eth_simulateV1
+type MEVBonusConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxTxs     int  `json:"max_txs"`     // most profitable transactions simulated per build; 0 simulates all
+	MaxBundles int  `json:"max_bundles"` // bundles simulated per build, most reputable searchers' first; 0 simulates all
+}
+
+// SimulationConfig configures how state-diff tracing and MEV bonus
+// simulation reach the node, or whether they run locally
+type SimulationConfig struct {
+	Local       bool     `json:"local"`        // execute against the state cache first, falling back to the node
+	Workers     int      `json:"workers"`      // concurrent simulations; 0 sends each analysis as one batch
+	TaskTimeout Duration `json:"task_timeout"` // per simulation with workers; 0 is none
+}
+
+// IncrementalConfig configures inserting transactions that arrive
+// mid-slot into the block already built for it
+type IncrementalConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"` // how often arrivals are checked for
+}
+
+// StateConfig configures the per-slot state cache
+type StateConfig struct {
+	Prefetch     bool `json:"prefetch"`
+	MaxContracts int  `json:"max_contracts"` // most called contracts prefetched per slot; 0 prefetches all
+	MaxSlots     int  `json:"max_slots"`     // traced storage slots prefetched per contract; 0 prefetches all
+}
+
+// DEXPoolConfig describes a Uniswap V2 style pair
+type DEXPoolConfig struct {
+	Address string `json:"address"`
+	Router  string `json:"router"`
+	Token0  string `json:"token0"`
+	Token1  string `json:"token1"`
+	FeeBps  int64  `json:"fee_bps"`
+}
+
+// ReportConfig controls the per-build profit report export
+type ReportConfig struct {
+	Dir     string `json:"dir"`     // write one report file per build here
+	Webhook string `json:"webhook"` // POST each report to this URL
+	Format  string `json:"format"`  // json or csv
+}
+
+// Enabled reports whether reports should be produced at all
+func (c *ReportConfig) Enabled() bool { return c.Dir != "" || c.Webhook != "" }
+
+// TracingConfig configures span export to an OTLP/HTTP collector
+type TracingConfig struct {
+	Endpoint string `json:"endpoint"` // traces URL such as "http://localhost:4318/v1/traces"; empty disables tracing
+	Service  string `json:"service"`  // service.name reported with every span
+}
+
+// DefaultConfig returns the configuration used when no file is given
+func DefaultConfig() *Config {
+	return ProfileConfig(ChainProfiles["mainnet"])
+}
+
+// ProfileConfig returns the default configuration for a chain profile
+func ProfileConfig(p *ChainProfile) *Config {
+	retry := DefaultRetryPolicy()
+	return &Config{
+		Chain:   p.Name,
+		ChainID: p.ChainID,
+		PoL:     p.PoL,
+		Forks:   p.Forks,
+		RPC: RPCConfig{
+			Endpoints:       append([]string(nil), p.Endpoints...),
+			Timeout:         Duration(10 * time.Second),
+			MaxAttempts:     retry.MaxAttempts,
+			Backoff:         Duration(retry.BaseDelay),
+			MaxBackoff:      Duration(retry.MaxDelay),
+			MaxBatchSize:    DefaultMaxBatchSize,
+			MaxResponseSize: DefaultMaxResponseSize,
+			BreakerFailures: 5,
+			BreakerCooldown: Duration(5 * time.Second),
+		},
+		Builder: BuilderConfig{
+			Strategy: "greedy",
+			Scorer:   DefaultScorer,
+			Weights:  DefaultScoreWeights(),
+			GasLimit: p.GasLimit,
+			Search:   DefaultSearchConfig(),
+			Parallel: DefaultParallelConfig(),
+			Exact:    DefaultExactConfig(),
+			Fair:     DefaultFairConfig(),
+			Ranking:  string(RankProfit),
+			GasEstimate: GasEstimateConfig{
+				Margin:     0.1,
+				MinSamples: 3,
+				History:    10,
+			},
+			RevertProtection: RevertProtectionConfig{Rounds: 3},
+			Oversubscribe:    OversubscribeConfig{Factor: 0.1, Rounds: 2},
+			Execute:          true,
+			Incremental:      IncrementalConfig{Interval: Duration(250 * time.Millisecond)},
+			Incentives: IncentivesConfig{
+				TTL:      Duration(DefaultCuttingBoardTTL),
+				BGTPerTx: 0.01,
+			},
+		},
+		Pool: PoolConfig{
+			TipFloor:       string(TipFloorReject),
+			MaxPerSender:   DefaultMaxPerSender,
+			MaxBytes:       DefaultMaxPoolBytes,
+			SeenTTL:        Duration(DefaultSeenTTL),
+			QuarantineSize: DefaultQuarantineSize,
+			Shards:         1,
+			CheckBalances:  true,
+			TrackNonces:    true,
+		},
+		Oracle: OracleConfig{
+			Blocks:     20,
+			Percentile: 25,
+		},
+		ExpressLane:  ExpressLaneConfig{Round: Duration(time.Minute)},
+		Encrypted:    EncryptedConfig{TTL: Duration(time.Minute), MaxGas: 5_000_000},
+		CommitReveal: CommitRevealConfig{Round: Duration(2 * time.Second), Rounds: 4},
+		HTTP:         DefaultHTTPConfig(),
+		Private: PrivateConfig{
+			TTL: Duration(2 * time.Minute),
+		},
+		Relay: RelayConfig{GenesisForkVersion: "0x00000000"},
+		MEVShare: MEVShareConfig{
+			HintTTL:        Duration(DefaultHintTTL),
+			SandwichPolicy: string(SandwichReject),
+		},
+		Backrun: BackrunConfig{
+			WBERA:   p.WBERA,
+			MinSwap: 1e18,
+		},
+		StateDiff: StateDiffConfig{
+			Mode:   StateDiffWrite,
+			MaxTxs: 200,
+		},
+		MEVBonus: MEVBonusConfig{
+			MaxTxs:     200,
+			MaxBundles: 100,
+		},
+		Simulation: SimulationConfig{
+			TaskTimeout: Duration(2 * time.Second),
+		},
+		State: StateConfig{
+			MaxContracts: 64,
+			MaxSlots:     64,
+		},
+		Report: ReportConfig{
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			Service: "block-construction-engine",
+		},
+		Alerts: AlertsConfig{
+			MinInterval: Duration(5 * time.Minute),
+			RPCFailures: 3,
+		},
+		P2P: P2PConfig{
+			Hold:   Duration(DefaultP2PHold),
+			Redial: Duration(DefaultP2PRedial),
+		},
+	}
+}
+
+// LoadConfig reads a .toml or .json config file on top of the defaults of
+// its chain profile and validates the result. A non-empty chain overrides
+// the file's chain key.
+func LoadConfig(path string, chain string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	raw := data
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		tree, err := parseTOML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if raw, err = json.Marshal(tree); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".json":
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format (want .toml or .json)", path)
+	}
+
+	if chain == "" {
+		var head struct {
+			Chain string `json:"chain"`
+		}
+		json.Unmarshal(raw, &head)
+		chain = head.Chain
+	}
+	cfg := DefaultConfig()
+	if chain != "" {
+		profile, err := LookupProfile(chain)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cfg = ProfileConfig(profile)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, describeJSONError(err))
+	}
+	if chain != "" {
+		cfg.Chain = chain
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: invalid config:\n%w", path, err)
+	}
+	return cfg, nil
+}
+
+// describeJSONError rewrites decoder errors in terms of config keys
+func describeJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	if msg, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return fmt.Errorf("unknown key %s", msg)
+	}
+	return err
+}
+
+// Validate checks the config for values the engine can't run with and
+// reports every problem found, one per line
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("  %s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if _, err := LookupProfile(c.Chain); err != nil {
+		fail("chain", "%v", err)
+	}
+	for key, addr := range map[string]string{
+		"pol.bgt":                     c.PoL.BGT,
+		"pol.berachef":                c.PoL.BeraChef,
+		"pol.block_reward_controller": c.PoL.BlockRewardController,
+		"pol.distributor":             c.PoL.Distributor,
+		"pol.reward_vault_factory":    c.PoL.RewardVaultFactory,
+		"builder.fee_recipient":       c.Builder.FeeRecipient,
+		"builder.coinbase":            c.Builder.Coinbase,
+	} {
+		if addr == "" {
+			continue
+		}
+		if _, err := HexToAddress(addr); err != nil {
+			fail(key, "%v", err)
+		}
+	}
+
+	if len(c.RPC.Endpoints) == 0 {
+		fail("rpc.endpoints", "at least one endpoint is required")
+	}
+	for i, ep := range c.RPC.Endpoints {
+		u, err := url.Parse(ep)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(fmt.Sprintf("rpc.endpoints[%d]", i), "%q is not an http(s) URL", ep)
+		}
+	}
+	if c.RPC.Timeout <= 0 {
+		fail("rpc.timeout", "must be positive")
+	}
+	if c.RPC.MaxAttempts < 1 {
+		fail("rpc.max_attempts", "must be at least 1")
+	}
+	if c.RPC.Backoff < 0 || c.RPC.MaxBackoff < 0 || c.RPC.MaxBackoff != 0 && c.RPC.MaxBackoff < c.RPC.Backoff {
+		fail("rpc.max_backoff", "must be 0 (uncapped) or at least rpc.backoff (%s)", c.RPC.Backoff)
+	}
+	if c.RPC.MaxBatchSize < 1 {
+		fail("rpc.max_batch_size", "must be at least 1")
+	}
+	if c.RPC.MaxResponseSize < 0 {
+		fail("rpc.max_response_size", "must not be negative")
+	}
+	if c.RPC.BreakerFailures < 0 {
+		fail("rpc.breaker_failures", "must not be negative")
+	}
+	if c.RPC.BreakerFailures > 0 && c.RPC.BreakerCooldown <= 0 {
+		fail("rpc.breaker_cooldown", "must be positive")
+	}
+
+	if _, err := LookupPacker(c.Builder.Strategy); err != nil {
+		fail("builder.strategy", "%v", err)
+	}
+	if _, err := LookupScorer(c.Builder.Scorer); err != nil {
+		fail("builder.scorer", "%v", err)
+	}
+	for i, hook := range c.Builder.Hooks {
+		key := fmt.Sprintf("builder.hooks[%d]", i)
+		for j, addr := range slices.Concat(hook.To, hook.From) {
+			if _, err := HexToAddress(addr); err != nil {
+				fail(fmt.Sprintf("%s.to/from[%d]", key, j), "%v", err)
+			}
+		}
+		if _, err := NewHooks([]HookConfig{hook}); err != nil {
+			fail(key, "%v", err)
+		}
+	}
+	for i, b := range c.Builder.Boosts {
+		key := fmt.Sprintf("builder.boosts[%d]", i)
+		if len(b.To) == 0 {
+			fail(key+".to", "must list at least one contract")
+		}
+		for j, addr := range b.To {
+			if _, err := HexToAddress(addr); err != nil {
+				fail(fmt.Sprintf("%s.to[%d]", key, j), "%v", err)
+			}
+		}
+		if b.Multiplier < 0 || math.IsNaN(b.Multiplier) || math.IsInf(b.Multiplier, 0) {
+			fail(key+".multiplier", "must be a non-negative number")
+		}
+		if _, err := NewContractBoosts([]BoostConfig{b}); err != nil {
+			fail(key+".selectors", "%v", err)
+		}
+	}
+	if s := c.Builder.Quotas.SenderShare; s < 0 || s > 1 || math.IsNaN(s) {
+		fail("builder.quotas.sender_share", "must be between 0 and 1")
+	}
+	for i, q := range c.Builder.Quotas.Contracts {
+		key := fmt.Sprintf("builder.quotas.contracts[%d]", i)
+		if _, err := HexToAddress(q.Address); err != nil {
+			fail(key+".address", "%v", err)
+		}
+		switch {
+		case q.MaxGas < 0:
+			fail(key+".max_gas", "must not be negative")
+		case q.Share < 0 || q.Share > 1 || math.IsNaN(q.Share):
+			fail(key+".share", "must be between 0 and 1")
+		case q.MaxGas == 0 && q.Share == 0:
+			fail(key, "needs max_gas or share")
+		}
+	}
+	if c.Builder.CuttingBoard && c.Relay.URL == "" {
+		fail("builder.cutting_board", "needs relay.url to know the proposer of each slot")
+	}
+	if inc := c.Builder.Incentives; inc.Enabled {
+		if c.PoL.RewardVaultFactory == "" {
+			fail("builder.incentives.enabled", "needs pol.reward_vault_factory to discover vaults")
+		}
+		if inc.TTL < 0 {
+			fail("builder.incentives.ttl", "must not be negative")
+		}
+		if inc.BGTPerTx < 0 || math.IsNaN(inc.BGTPerTx) || math.IsInf(inc.BGTPerTx, 0) {
+			fail("builder.incentives.bgt_per_tx", "must be a non-negative number")
+		}
+		for i, t := range inc.Tokens {
+			key := fmt.Sprintf("builder.incentives.tokens[%d]", i)
+			if _, err := HexToAddress(t.Address); err != nil {
+				fail(key+".address", "%v", err)
+			}
+			if t.Price < 0 || math.IsNaN(t.Price) || math.IsInf(t.Price, 0) {
+				fail(key+".price", "must be a non-negative number")
+			}
+		}
+	}
+	for key, w := range map[string]float64{"tip": c.Builder.Weights.Tip, "mev": c.Builder.Weights.MEV, "pol": c.Builder.Weights.PoL} {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			fail("builder.weights."+key, "must be a non-negative number")
+		}
+	}
+	if c.Builder.Deadline < 0 {
+		fail("builder.deadline", "must not be negative")
+	}
+	if c.Builder.ScanBudget.Time < 0 {
+		fail("builder.scan_budget.time", "must not be negative")
+	}
+	if c.Builder.ScanBudget.Candidates < 0 {
+		fail("builder.scan_budget.candidates", "must not be negative")
+	}
+	if c.Builder.GasLimit < 0 {
+		fail("builder.gas_limit", "must not be negative")
+	}
+	if c.Builder.Search.Iterations < 0 {
+		fail("builder.search.iterations", "must not be negative")
+	}
+	if c.Builder.Search.Temperature < 0 {
+		fail("builder.search.temperature", "must not be negative")
+	}
+	if c.Builder.Search.Budget < 0 {
+		fail("builder.search.budget", "must not be negative")
+	}
+	if r := Ranking(c.Builder.Ranking); r != RankProfit && r != RankDensity {
+		fail("builder.ranking", "unknown ranking %q (want profit or density)", c.Builder.Ranking)
+	}
+	if c.Builder.Exact.MaxComponent < 0 {
+		fail("builder.exact.max_component", "must not be negative")
+	}
+	if c.Builder.Exact.Budget < 0 {
+		fail("builder.exact.budget", "must not be negative")
+	}
+	if c.Builder.Fair.Bucket <= 0 {
+		fail("builder.fair.bucket", "must be positive")
+	}
+	if c.Builder.Parallel.Workers < 0 {
+		fail("builder.parallel.workers", "must not be negative")
+	}
+	if c.Builder.Parallel.Window < 1 {
+		fail("builder.parallel.window", "must be at least 1")
+	}
+	if c.Builder.GasEstimate.Margin < 0 {
+		fail("builder.gas_estimate.margin", "must not be negative")
+	}
+	if c.Builder.GasEstimate.MinSamples < 1 {
+		fail("builder.gas_estimate.min_samples", "must be at least 1")
+	}
+	if c.Builder.GasEstimate.SimulateTxs < 0 {
+		fail("builder.gas_estimate.simulate_txs", "must not be negative")
+	}
+	if c.Builder.GasEstimate.History < 0 {
+		fail("builder.gas_estimate.history", "must not be negative")
+	}
+	if c.Builder.RevertProtection.Rounds < 0 {
+		fail("builder.revert_protection.rounds", "must not be negative")
+	}
+	if c.Builder.Oversubscribe.Factor < 0 {
+		fail("builder.oversubscribe.factor", "must not be negative")
+	}
+	if c.Builder.Oversubscribe.Rounds < 1 {
+		fail("builder.oversubscribe.rounds", "must be at least 1")
+	}
+
+	if c.Pool.MinGasPrice < 0 {
+		fail("pool.min_gas_price", "must not be negative")
+	}
+	if c.Pool.MinTip < 0 {
+		fail("pool.min_tip", "must not be negative")
+	}
+	if mode := TipFloorMode(c.Pool.TipFloor); mode != TipFloorReject && mode != TipFloorDeprioritize {
+		fail("pool.tip_floor", "unknown mode %q (want reject or deprioritize)", c.Pool.TipFloor)
+	}
+	if c.Pool.MaxPerSender < 0 {
+		fail("pool.max_per_sender", "must not be negative")
+	}
+	if c.Pool.MaxSenderGas < 0 {
+		fail("pool.max_sender_gas", "must not be negative")
+	}
+	if c.Pool.MaxBytes < 0 {
+		fail("pool.max_bytes", "must not be negative")
+	}
+	if c.Pool.SeenTTL <= 0 {
+		fail("pool.seen_ttl", "must be positive")
+	}
+	if c.Pool.QuarantineSize < 0 {
+		fail("pool.quarantine_size", "must not be negative")
+	}
+	if c.Pool.Shards < 1 {
+		fail("pool.shards", "must be at least 1")
+	}
+	if c.Pool.Aging.TipPerBlock < 0 {
+		fail("pool.aging.tip_per_block", "must not be negative")
+	}
+	if c.Pool.Aging.MaxTip < 0 {
+		fail("pool.aging.max_tip", "must not be negative")
+	}
+
+	if c.Oracle.Blocks < 1 {
+		fail("oracle.blocks", "must be at least 1")
+	}
+	if c.Oracle.Percentile < 0 || c.Oracle.Percentile > 100 {
+		fail("oracle.percentile", "must be between 0 and 100")
+	}
+
+	names := map[string]bool{}
+	laneGas := int64(0)
+	for i, lane := range c.Lanes {
+		key := fmt.Sprintf("lanes[%d]", i)
+		if lane.Name == "" {
+			fail(key+".name", "is required")
+		} else if names[lane.Name] {
+			fail(key+".name", "duplicate lane %q", lane.Name)
+		}
+		names[lane.Name] = true
+		if lane.MaxTxs < 0 {
+			fail(key+".max_txs", "must not be negative")
+		}
+		if lane.MaxGas < 0 {
+			fail(key+".max_gas", "must not be negative")
+		}
+		if len(lane.To) == 0 && len(lane.From) == 0 {
+			fail(key, "needs to or from addresses")
+		}
+		for j, addr := range slices.Concat(lane.To, lane.From) {
+			if _, err := HexToAddress(addr); err != nil {
+				fail(fmt.Sprintf("%s.to/from[%d]", key, j), "%v", err)
+			}
+		}
+		for j, sel := range lane.Selectors {
+			if b, err := ParseHexBytes(sel); err != nil || len(b) != 4 {
+				fail(fmt.Sprintf("%s.selectors[%d]", key, j), "%q is not 4 bytes of hex", sel)
+			}
+		}
+		laneGas += lane.MaxGas
+	}
+	if c.Builder.GasLimit > 0 && laneGas > c.Builder.GasLimit {
+		fail("lanes", "reserve %d gas in total, more than builder.gas_limit %d", laneGas, c.Builder.GasLimit)
+	}
+	if c.ExpressLane.Round <= 0 {
+		fail("express_lane.round", "must be positive")
+	}
+	if c.ExpressLane.Reserve < 0 {
+		fail("express_lane.reserve", "must not be negative")
+	}
+	if c.ExpressLane.MaxGas < 0 {
+		fail("express_lane.max_gas", "must not be negative")
+	}
+	if c.Encrypted.TTL <= 0 {
+		fail("encrypted.ttl", "must be positive")
+	}
+	if c.Encrypted.MaxGas < 0 {
+		fail("encrypted.max_gas", "must not be negative")
+	}
+	if c.CommitReveal.Round <= 0 {
+		fail("commit_reveal.round", "must be positive")
+	}
+	if c.CommitReveal.Rounds < 1 {
+		fail("commit_reveal.rounds", "must be at least 1")
+	}
+	if c.HTTP.MaxIdleConns < 0 {
+		fail("http.max_idle_conns", "must not be negative")
+	}
+	if c.HTTP.MaxIdleConnsPerHost < 1 {
+		fail("http.max_idle_conns_per_host", "must be at least 1")
+	}
+	if c.HTTP.MaxConnsPerHost < 0 {
+		fail("http.max_conns_per_host", "must not be negative")
+	}
+	if c.HTTP.IdleTimeout < 0 {
+		fail("http.idle_timeout", "must not be negative")
+	}
+	if c.HTTP.DialTimeout < 0 {
+		fail("http.dial_timeout", "must not be negative")
+	}
+
+	for key, path := range map[string]string{
+		"builder.inclusion_list": c.Builder.InclusionList,
+		"keys.builder_key_file":  c.Keys.BuilderKeyFile,
+		"keys.jwt_secret_file":   c.Keys.JWTSecretFile,
+		"keys.coinbase_key_file": c.Keys.CoinbaseKeyFile,
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail(key, "%v", err)
+		}
+	}
+	if c.Builder.Coinbase != "" && c.Keys.CoinbaseKeyFile == "" {
+		fail("builder.coinbase", "needs keys.coinbase_key_file to pay proposers from it")
+	}
+	if c.Keys.CoinbaseKeyFile != "" && c.ChainID == 0 {
+		fail("chain_id", "must be set to sign proposer payments with keys.coinbase_key_file")
+	}
+
+	for key, addrs := range map[string][]string{
+		"compliance.blocklist": c.Compliance.Blocklist,
+		"compliance.allowlist": c.Compliance.Allowlist,
+	} {
+		for i, addr := range addrs {
+			if _, err := HexToAddress(addr); err != nil {
+				fail(fmt.Sprintf("%s[%d]", key, i), "%v", err)
+			}
+		}
+	}
+	for key, path := range map[string]string{
+		"compliance.blocklist_file": c.Compliance.BlocklistFile,
+		"compliance.allowlist_file": c.Compliance.AllowlistFile,
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail(key, "%v", err)
+		}
+	}
+
+	if c.Private.TTL <= 0 {
+		fail("private.ttl", "must be positive")
+	}
+	for i, token := range c.Private.Tokens {
+		if len(token) < 16 {
+			fail(fmt.Sprintf("private.tokens[%d]", i), "must be at least 16 characters")
+		}
+	}
+
+	for i, node := range c.API.Ingress.Forward {
+		if u, err := url.Parse(node); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(fmt.Sprintf("api.ingress.forward[%d]", i), "%q is not an http(s) URL", node)
+		}
+	}
+
+	if c.MEVShare.URL != "" {
+		if u, err := url.Parse(c.MEVShare.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("mevshare.url", "%q is not an http(s) URL", c.MEVShare.URL)
+		}
+	}
+	if c.Beacon.URL != "" {
+		if u, err := url.Parse(c.Beacon.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("beacon.url", "%q is not an http(s) URL", c.Beacon.URL)
+		}
+	}
+	if c.Relay.URL != "" {
+		if u, err := url.Parse(c.Relay.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("relay.url", "%q is not an http(s) URL", c.Relay.URL)
+		}
+	}
+	for i, target := range c.Relay.Submit {
+		key := fmt.Sprintf("relay.submit[%d]", i)
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(key+".url", "%q is not an http(s) URL", target.URL)
+		}
+		if target.Timeout <= 0 {
+			fail(key+".timeout", "must be positive")
+		}
+	}
+	for i, v := range c.Relay.Validators {
+		var pubkey BLSPubkey
+		if err := pubkey.UnmarshalText([]byte(v)); err != nil {
+			fail(fmt.Sprintf("relay.validators[%d]", i), "%v", err)
+		}
+	}
+	if len(c.Relay.Validators) > 0 && c.Relay.URL == "" {
+		fail("relay.validators", "needs relay.url for the proposer schedule")
+	}
+	if len(c.Relay.Submit) > 0 {
+		if c.Relay.URL == "" {
+			fail("relay.url", "is needed for the proposer registrations bids are made to")
+		}
+		if c.Relay.Signer == "" {
+			fail("relay.signer", "is needed to sign bids")
+		}
+		var pubkey BLSPubkey
+		if err := pubkey.UnmarshalText([]byte(c.Relay.BuilderPubkey)); err != nil {
+			fail("relay.builder_pubkey", "%v", err)
+		}
+		var version [4]byte
+		if err := decodeFixedHex(c.Relay.GenesisForkVersion, version[:]); err != nil {
+			fail("relay.genesis_fork_version", "%v", err)
+		}
+	}
+	if c.MEVShare.HintTTL <= 0 {
+		fail("mevshare.hint_ttl", "must be positive")
+	}
+	switch SandwichPolicy(c.MEVShare.SandwichPolicy) {
+	case SandwichReject, SandwichDeprioritize, SandwichAllow:
+	default:
+		fail("mevshare.sandwich_policy", "unknown policy %q (want reject, deprioritize or allow)", c.MEVShare.SandwichPolicy)
+	}
+
+	if c.Backrun.Enabled {
+		if _, err := HexToAddress(c.Backrun.WBERA); err != nil {
+			fail("backrun.wbera", "%v", err)
+		}
+		if c.Backrun.MinSwap < 0 {
+			fail("backrun.min_swap", "must not be negative")
+		}
+		for i, pc := range c.Backrun.Pools {
+			key := fmt.Sprintf("backrun.pools[%d]", i)
+			for field, addr := range map[string]string{"address": pc.Address, "router": pc.Router, "token0": pc.Token0, "token1": pc.Token1} {
+				if _, err := HexToAddress(addr); err != nil {
+					fail(key+"."+field, "%v", err)
+				}
+			}
+			if pc.FeeBps < 0 || pc.FeeBps >= 10000 {
+				fail(key+".fee_bps", "must be in [0, 10000)")
+			}
+		}
+	}
+
+	if c.StateDiff.Mode != StateDiffWrite && c.StateDiff.Mode != StateDiffReadWrite {
+		fail("statediff.mode", "unknown mode %q (want %s or %s)", c.StateDiff.Mode, StateDiffWrite, StateDiffReadWrite)
+	}
+	if c.StateDiff.MaxTxs < 0 {
+		fail("statediff.max_txs", "must not be negative")
+	}
+
+	if c.MEVBonus.MaxTxs < 0 {
+		fail("mevbonus.max_txs", "must not be negative")
+	}
+	if c.MEVBonus.MaxBundles < 0 {
+		fail("mevbonus.max_bundles", "must not be negative")
+	}
+	if c.MEVBonus.Enabled && c.Keys.CoinbaseKeyFile == "" && c.Builder.FeeRecipient == "" {
+		fail("mevbonus.enabled", "needs keys.coinbase_key_file or builder.fee_recipient to credit payments to")
+	}
+
+	if c.Simulation.Workers < 0 {
+		fail("simulation.workers", "must not be negative")
+	}
+	if c.Simulation.TaskTimeout < 0 {
+		fail("simulation.task_timeout", "must not be negative")
+	}
+	if c.Simulation.Local && !c.State.Prefetch {
+		fail("simulation.local", "needs state.prefetch for the state it executes against")
+	}
+	if c.Builder.Incremental.Enabled && c.Builder.Incremental.Interval <= 0 {
+		fail("builder.incremental.interval", "must be positive")
+	}
+	if c.State.MaxContracts < 0 {
+		fail("state.max_contracts", "must not be negative")
+	}
+	if c.State.MaxSlots < 0 {
+		fail("state.max_slots", "must not be negative")
+	}
+
+	if c.Report.Format != "json" && c.Report.Format != "csv" {
+		fail("report.format", "unknown format %q (want json or csv)", c.Report.Format)
+	}
+	if c.Report.Webhook != "" {
+		if u, err := url.Parse(c.Report.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("report.webhook", "%q is not an http(s) URL", c.Report.Webhook)
+		}
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			fail("tracing.endpoint", "%q is not an http(s) URL", c.Tracing.Endpoint)
+		}
+		if c.Tracing.Service == "" {
+			fail("tracing.service", "must not be empty")
+		}
+	}
+
+	for i, peer := range c.P2P.Peers {
+		if _, err := ParseEnode(peer); err != nil {
+			fail(fmt.Sprintf("p2p.peers[%d]", i), "%v", err)
+		}
+	}
+	if c.P2P.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.P2P.Listen); err != nil {
+			fail("p2p.listen", "%v", err)
+		}
+	}
+	if c.P2P.Hold < 0 {
+		fail("p2p.hold", "must not be negative")
+	}
+	if c.P2P.Redial <= 0 {
+		fail("p2p.redial", "must be positive")
+	}
+	if c.Alerts.MinInterval < 0 {
+		fail("alerts.min_interval", "must not be negative")
+	}
+	if c.Alerts.RPCFailures <= 0 {
+		fail("alerts.rpc_failures", "must be positive")
+	}
+	if c.Alerts.HighValue < 0 {
+		fail("alerts.high_value", "must not be negative")
+	}
+	if c.Alerts.StarvationTxs < 0 {
+		fail("alerts.starvation_txs", "must not be negative")
+	}
+	if c.Alerts.StarvationHeads < 0 {
+		fail("alerts.starvation_heads", "must not be negative")
+	}
+	for i, hook := range c.Alerts.Webhooks {
+		key := fmt.Sprintf("alerts.webhooks[%d]", i)
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(key+".url", "%q is not an http(s) URL", hook.URL)
+		}
+		switch hook.Format {
+		case "", WebhookJSON, WebhookSlack, WebhookDiscord:
+		case WebhookPagerDuty:
+			if hook.RoutingKey == "" {
+				fail(key+".routing_key", "is needed for pagerduty")
+			}
+		default:
+			fail(key+".format", "unknown format %q (want json, slack, discord or pagerduty)", hook.Format)
+		}
+		for j, event := range hook.Events {
+			if !slices.Contains(AlertEvents, event) {
+				fail(fmt.Sprintf("%s.events[%d]", key, j), "unknown event %q (want one of %s)", event, strings.Join(AlertEvents, ", "))
+			}
+		}
+		if _, err := template.New(key).Parse(hook.Template); err != nil {
+			fail(key+".template", "%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RetryPolicy returns the retry policy described by the RPC config
+func (c *RPCConfig) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		BaseDelay:   time.Duration(c.Backoff),
+		MaxDelay:    time.Duration(c.MaxBackoff),
+	}
+}
+
+// NewClient builds an RPC client for the primary endpoint, failing over to
+// the others in order behind circuit breakers if they are enabled
+func (c *RPCConfig) NewClient() *RPCClient {
+	rpc := NewRPCClient(c.Endpoints[0])
+	rpc.HTTP.Timeout = time.Duration(c.Timeout)
+	rpc.Retry = c.RetryPolicy()
+	rpc.MaxBatchSize = c.MaxBatchSize
+	rpc.MaxResponseSize = c.MaxResponseSize
+	if c.BreakerFailures > 0 {
+		rpc.Breakers = NewCircuitBreakers(c.Endpoints, c.BreakerFailures, time.Duration(c.BreakerCooldown))
+	}
+	return rpc
+}
+
+// Duration is a time.Duration written as a string such as "10s" or "250ms"
+type Duration time.Duration
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("expected a duration string like \"10s\", got %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
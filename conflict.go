@@ -0,0 +1,253 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ConflictGraph is the undirected graph of transactions that can't share a
+// block. Transactions declare conflicts one-sidedly in ConflictsWith and
+// Bundle; the graph makes every edge symmetric, so a conflict is honoured
+// whichever side is selected first. Two bundles sharing a member conflict
+// with each other, as do two transactions from one sender at one nonce.
+type ConflictGraph struct {
+	adj    map[string]map[string]bool
+	quotas *quotaIndex // gas quotas to respect; nil if none
+}
+
+// NewConflictGraph builds the graph of txs
+func NewConflictGraph(txs ...*Transaction) *ConflictGraph {
+	g := &ConflictGraph{adj: make(map[string]map[string]bool)}
+	owners := map[string][]string{} // bundle member -> bundles containing it
+	slots := map[string][]string{}  // sender and nonce -> transactions using it
+	for _, tx := range txs {
+		g.node(tx.Hash)
+		if tx.From != "" {
+			slot := fmt.Sprintf("%s/%d", tx.From, tx.Nonce)
+			for _, other := range slots[slot] {
+				g.link(tx.Hash, other)
+			}
+			slots[slot] = append(slots[slot], tx.Hash)
+		}
+		for _, id := range slices.Concat(tx.ConflictsWith, tx.Bundle) {
+			g.link(tx.Hash, id)
+		}
+		for _, m := range tx.Bundle {
+			for _, other := range owners[m] {
+				g.link(tx.Hash, other)
+			}
+			owners[m] = append(owners[m], tx.Hash)
+		}
+	}
+	return g
+}
+
+func (g *ConflictGraph) node(hash string) map[string]bool {
+	if g.adj[hash] == nil {
+		g.adj[hash] = make(map[string]bool)
+	}
+	return g.adj[hash]
+}
+
+func (g *ConflictGraph) link(a, b string) {
+	if a == b {
+		return
+	}
+	g.node(a)[b] = true
+	g.node(b)[a] = true
+}
+
+// Neighbors returns the set of hashes conflicting with hash
+func (g *ConflictGraph) Neighbors(hash string) map[string]bool { return g.adj[hash] }
+
+// Conflicts reports whether hash conflicts with any hash in used
+func (g *ConflictGraph) Conflicts(hash string, used map[string]bool) bool {
+	adj := g.adj[hash]
+	if len(used) < len(adj) {
+		for id := range used {
+			if adj[id] {
+				return true
+			}
+		}
+		return false
+	}
+	for id := range adj {
+		if used[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Components returns the connected components among the given transactions,
+// ignoring edges to anything outside them. Components and their members
+// come out in the order of txs.
+func (g *ConflictGraph) Components(txs []*Transaction) [][]*Transaction {
+	byHash := make(map[string]*Transaction, len(txs))
+	for _, tx := range txs {
+		byHash[tx.Hash] = tx
+	}
+	index := make(map[string]int, len(txs))
+	for i, tx := range txs {
+		index[tx.Hash] = i
+	}
+	seen := make(map[string]bool, len(txs))
+	var comps [][]*Transaction
+	for _, root := range txs {
+		if seen[root.Hash] {
+			continue
+		}
+		seen[root.Hash] = true
+		comp := []*Transaction{root}
+		for i := 0; i < len(comp); i++ {
+			for id := range g.adj[comp[i].Hash] {
+				if tx, ok := byHash[id]; ok && !seen[id] {
+					seen[id] = true
+					comp = append(comp, tx)
+				}
+			}
+		}
+		slices.SortFunc(comp, func(a, b *Transaction) int { return index[a.Hash] - index[b.Hash] })
+		comps = append(comps, comp)
+	}
+	return comps
+}
+
+// greedySet approximates the maximum-score set of mutually non-conflicting
+// transactions within comp greedily by score over (1 + remaining
+// conflicts), which is within a factor of the maximum degree of the
+// optimum
+func (g *ConflictGraph) greedySet(comp []*Transaction) []*Transaction {
+	left := make(map[string]*Transaction, len(comp))
+	for _, tx := range comp {
+		left[tx.Hash] = tx
+	}
+	degree := func(tx *Transaction) int64 {
+		d := int64(1)
+		for id := range g.adj[tx.Hash] {
+			if left[id] != nil {
+				d++
+			}
+		}
+		return d
+	}
+	var out []*Transaction
+	for len(left) > 0 {
+		var pick *Transaction
+		var pickDeg int64
+		for _, tx := range comp {
+			if left[tx.Hash] == nil {
+				continue
+			}
+			// Compare score/degree without division: a/da > b/db
+			d := degree(tx)
+			if pick == nil {
+				pick, pickDeg = tx, d
+				continue
+			}
+			if a, b := tx.Score()*pickDeg, pick.Score()*d; a > b || a == b && cmpScore(tx, pick) > 0 {
+				pick, pickDeg = tx, d
+			}
+		}
+		out = append(out, pick)
+		delete(left, pick.Hash)
+		for id := range g.adj[pick.Hash] {
+			delete(left, id)
+		}
+	}
+	return out
+}
+
+// conflictGraph builds the graph of everything a build can pick from: the
+// pooled transactions plus the given bundles, bound by the pool's quotas
+func (p *TxPool) conflictGraph(bundles ...*Transaction) *ConflictGraph {
+	txs := slices.Collect(maps.Values(p.AllTxs))
+	slices.SortFunc(txs, func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) })
+	txs = append(txs, bundles...)
+	g := NewConflictGraph(txs...)
+	if p.Quotas != nil {
+		g.quotas = newQuotaIndex(p.Quotas, txs)
+	}
+	return g
+}
+
+// selectWIS opens the block like selectGreedy, then picks a maximum-profit
+// independent set from each conflict component among the queued
+// transactions and bundles (exactly within the bounds of Exact, otherwise
+// greedily; see exactSearch), packs the union by profit into the gas
+// left, tops up with whatever still fits and doesn't conflict, and fills any
+// remaining space with deprioritized transactions. Gas is not part of the
+// per-component objective, so a chosen transaction that no longer fits is
+// simply skipped.
+func (p *TxPool) selectWIS(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	queued, low := slices.Clone(p.Heap.TxHeap), slices.Clone(p.Low.TxHeap)
+	merged, sandwiches := p.matchedBundles()
+	queued = append(queued, merged...)
+	low = append(low, sandwiches...)
+	graph := p.conflictGraph(slices.Concat(queued[p.Heap.Len():], low[p.Low.Len():])...)
+
+	selected, usedGas, used, err := p.openBlock(gasLimit, graph)
+	if err != nil {
+		return nil, err
+	}
+	if best != nil {
+		best.Offer(selected)
+	}
+	chains := p.nonceChains(selected)
+	var take func(tx *Transaction)
+	take = func(tx *Transaction) {
+		if used[tx.Hash] || graph.Conflicts(tx.Hash, used) || usedGas+tx.GasLimit > gasLimit || graph.OverQuota(tx, used, gasLimit) {
+			chains.skip(tx)
+			return
+		}
+		if chains.wait(tx) {
+			return
+		}
+		usedGas += tx.PackGas()
+		used[tx.Hash] = true
+		selected = append(selected, tx)
+		if best != nil {
+			best.Offer(selected)
+		}
+		for _, t := range chains.taken(tx) {
+			take(t)
+		}
+	}
+
+	// Candidates in heap order, minus anything ruled out by the opening
+	heap.Init(&queued)
+	var candidates []*Transaction
+	for queued.Len() > 0 {
+		tx := heap.Pop(&queued).(*Transaction)
+		if !used[tx.Hash] && !graph.Conflicts(tx.Hash, used) {
+			candidates = append(candidates, tx)
+		}
+	}
+	var chosen []*Transaction
+	search := newExactSearch(p.Exact)
+	for _, comp := range graph.Components(candidates) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		chosen = append(chosen, search.independentSet(graph, comp)...)
+	}
+	p.lastExact.Store(&search.res)
+	slices.SortStableFunc(chosen, func(a, b *Transaction) int { return cmpScore(b, a) })
+	for _, tx := range chosen {
+		take(tx)
+	}
+	for _, h := range []TxHeap{candidates, low} {
+		heap.Init(&h)
+		for h.Len() > 0 && usedGas < gasLimit {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			take(heap.Pop(&h).(*Transaction))
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// BeraChef selector of getActiveRewardAllocation(bytes valPubkey)
+var selectorGetActiveRewardAllocation = []byte{0xda, 0x6f, 0x75, 0x63}
+
+// cuttingBoardDenominator is what BeraChef allocation percentages are out of
+const cuttingBoardDenominator = 10000
+
+// DefaultCuttingBoardTTL is how long a fetched cutting board is reused
+const DefaultCuttingBoardTTL = 5 * time.Minute
+
+// CuttingBoard is a validator's active BGT reward allocation in BeraChef:
+// the share of its emissions each reward vault receives. Vault incentives
+// are paid to validators in proportion to the emissions they direct to the
+// vault, so a transaction's PoL incentive only reaches the proposer to the
+// extent its board covers the transaction's vault.
+type CuttingBoard struct {
+	Pubkey     BLSPubkey           `json:"pubkey"`
+	StartBlock uint64              `json:"startBlock"`
+	Shares     map[Address]float64 `json:"shares"` // by reward vault, summing to 1
+}
+
+// Share returns the fraction of tx's PoL incentive that benefits the
+// board's validator: that of the vault its incentive is paid through
+// (PoLVault, else the contract it calls if that is a vault on the board).
+// Transactions whose vault is unknown keep their whole incentive.
+func (b *CuttingBoard) Share(tx *Transaction) float64 {
+	if b == nil {
+		return 1
+	}
+	if tx.PoLVault != "" {
+		vault, err := HexToAddress(tx.PoLVault)
+		if err != nil {
+			return 1
+		}
+		return b.Shares[vault]
+	}
+	if to, err := HexToAddress(tx.To); err == nil {
+		if share, ok := b.Shares[to]; ok {
+			return share
+		}
+	}
+	return 1
+}
+
+// FetchCuttingBoard reads the active reward allocation of the validator
+// with pubkey from the BeraChef contract
+func FetchCuttingBoard(ctx context.Context, rpc *RPCClient, beraChef Address, pubkey BLSPubkey) (*CuttingBoard, error) {
+	// getActiveRewardAllocation(bytes): the offset of the bytes, their
+	// length and the 48 pubkey bytes padded to two words
+	data := make([]byte, 4+32*4)
+	copy(data, selectorGetActiveRewardAllocation)
+	data[4+31] = 0x20
+	data[4+63] = byte(len(pubkey))
+	copy(data[4+64:], pubkey[:])
+	call := map[string]string{"to": beraChef.Hex(), "data": EncodeHexBytes(data)}
+	var result string
+	if err := rpc.Call(ctx, &result, "eth_call", call, "latest"); err != nil {
+		return nil, fmt.Errorf("reading cutting board: %w", err)
+	}
+	out, err := ParseHexBytes(result)
+	if err != nil {
+		return nil, fmt.Errorf("reading cutting board: %w", err)
+	}
+	board, err := decodeRewardAllocation(out)
+	if err != nil {
+		return nil, fmt.Errorf("decoding cutting board: %w", err)
+	}
+	board.Pubkey = pubkey
+	return board, nil
+}
+
+// decodeRewardAllocation decodes the ABI encoding of the tuple
+// (uint64 startBlock, (address receiver, uint96 percentageNumerator)[] weights)
+func decodeRewardAllocation(data []byte) (*CuttingBoard, error) {
+	word := func(off uint64) (*big.Int, error) {
+		if off+32 > uint64(len(data)) || off+32 < off {
+			return nil, errors.New("short return data")
+		}
+		return new(big.Int).SetBytes(data[off : off+32]), nil
+	}
+	offset := func(off uint64) (uint64, error) {
+		w, err := word(off)
+		if err != nil {
+			return 0, err
+		}
+		if !w.IsUint64() || w.Uint64() > uint64(len(data)) {
+			return 0, errors.New("offset out of range")
+		}
+		return w.Uint64(), nil
+	}
+	tuple, err := offset(0)
+	if err != nil {
+		return nil, err
+	}
+	start, err := word(tuple)
+	if err != nil {
+		return nil, err
+	}
+	weights, err := offset(tuple + 32)
+	if err != nil {
+		return nil, err
+	}
+	n, err := offset(tuple + weights)
+	if err != nil {
+		return nil, err
+	}
+	board := &CuttingBoard{StartBlock: start.Uint64(), Shares: make(map[Address]float64, n)}
+	at := tuple + weights + 32
+	if n*64 > uint64(len(data))-at {
+		return nil, errors.New("short return data")
+	}
+	for i := range n {
+		entry := data[at+i*64 : at+i*64+64]
+		var vault Address
+		copy(vault[:], entry[12:32])
+		pct := binary.BigEndian.Uint64(entry[56:64])
+		board.Shares[vault] += float64(pct) / cuttingBoardDenominator
+	}
+	return board, nil
+}
+
+// CuttingBoards caches the cutting boards of proposers for TTL. Its methods
+// are safe for concurrent use.
+type CuttingBoards struct {
+	RPC      *RPCClient
+	BeraChef Address
+	TTL      time.Duration
+
+	mu     sync.Mutex
+	boards map[BLSPubkey]cachedBoard
+}
+
+type cachedBoard struct {
+	board   *CuttingBoard
+	fetched time.Time
+}
+
+func NewCuttingBoards(rpc *RPCClient, beraChef Address) *CuttingBoards {
+	return &CuttingBoards{RPC: rpc, BeraChef: beraChef, TTL: DefaultCuttingBoardTTL, boards: make(map[BLSPubkey]cachedBoard)}
+}
+
+// For returns the cutting board of the validator with pubkey, from the
+// cache if fetched within TTL
+func (c *CuttingBoards) For(ctx context.Context, pubkey BLSPubkey) (*CuttingBoard, error) {
+	c.mu.Lock()
+	cached, ok := c.boards[pubkey]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetched) < c.TTL {
+		return cached.board, nil
+	}
+	board, err := FetchCuttingBoard(ctx, c.RPC, c.BeraChef, pubkey)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.boards[pubkey] = cachedBoard{board: board, fetched: time.Now()}
+	c.mu.Unlock()
+	return board, nil
+}
+
+// SetCuttingBoard weights PoL incentives in the default score by board,
+// re-sorting the heaps; nil weights them fully
+func (p *TxPool) SetCuttingBoard(board *CuttingBoard) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if board == p.CuttingBoard {
+		return
+	}
+	p.CuttingBoard = board
+	p.restamp()
+}
+
+// SetCuttingBoard sets the cutting board of every shard
+func (s *ShardedPool) SetCuttingBoard(board *CuttingBoard) {
+	for _, shard := range s.Shards {
+		shard.SetCuttingBoard(board)
+	}
+}
+
+// ApplyCuttingBoard weights pool's PoL incentives by the cutting board of
+// reg's proposer if cutting board weighting is on, and fully otherwise or
+// if the proposer is unknown
+func (e *Env) ApplyCuttingBoard(ctx context.Context, pool interface{ SetCuttingBoard(*CuttingBoard) }, reg *ValidatorRegistration) {
+	if e.CuttingBoards == nil {
+		return
+	}
+	var board *CuttingBoard
+	if reg != nil {
+		var err error
+		if board, err = e.CuttingBoards.For(ctx, reg.Pubkey); err != nil {
+			fmt.Fprintf(e.Out, "Error fetching cutting board, weighting PoL incentives fully: %v\n", err)
+		}
+	}
+	pool.SetCuttingBoard(board)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// orderEntry is a block entry's own transactions: itself, or a bundle's
+// members in bundle order
+type orderEntry struct {
+	tx      *Transaction
+	members []*Transaction
+}
+
+// orderEntries resolves the members of the bundles among txs; one whose
+// members are no longer known stands for itself. Called with the pool
+// read-locked.
+func (p *TxPool) orderEntries(txs []*Transaction) []orderEntry {
+	entries := make([]orderEntry, len(txs))
+	for i, tx := range txs {
+		entries[i] = orderEntry{tx: tx, members: []*Transaction{tx}}
+		if len(tx.Bundle) > 0 && p.Hints != nil {
+			if members, _, ok := p.Hints.Members(tx.Hash); ok {
+				entries[i].members = members
+			}
+		}
+	}
+	return entries
+}
+
+// dependencies returns, for each of txs, the indexes of those it must
+// follow: the entries holding its sender's lower nonces, and those holding
+// a transaction it names in MustFollow. A bundle depends on what any of its
+// members does, bar the other members.
+func (p *TxPool) dependencies(txs []*Transaction) [][]int {
+	entries := p.orderEntries(txs)
+	index := make(map[string]int, len(txs))
+	type sent struct{ nonce, entry int }
+	bySender := make(map[string][]sent)
+	for i, e := range entries {
+		index[e.tx.Hash] = i
+		for _, m := range e.members {
+			index[m.Hash] = i
+			if m.From != "" {
+				bySender[m.From] = append(bySender[m.From], sent{m.Nonce, i})
+			}
+		}
+	}
+
+	deps := make([][]int, len(txs))
+	add := func(i, j int) {
+		if i != j && !slices.Contains(deps[i], j) {
+			deps[i] = append(deps[i], j)
+		}
+	}
+	for _, nonces := range bySender {
+		slices.SortStableFunc(nonces, func(a, b sent) int { return cmp.Compare(a.nonce, b.nonce) })
+		for k := 1; k < len(nonces); k++ {
+			if nonces[k].nonce > nonces[k-1].nonce {
+				add(nonces[k].entry, nonces[k-1].entry)
+			}
+		}
+	}
+	for i, e := range entries {
+		for _, m := range e.members {
+			for _, h := range m.MustFollow {
+				if j, ok := index[h]; ok {
+					add(i, j)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// orderBlock sorts txs so every one follows those it depends on (see
+// dependencies), keeping the packer's order wherever no dependency forces
+// another: each step takes the earliest transaction whose dependencies are
+// all placed. It fails if the dependencies form a cycle, as a sender's
+// nonces split across two bundles in opposite orders would. Called with the
+// pool read-locked.
+func (p *TxPool) orderBlock(txs []*Transaction) ([]*Transaction, error) {
+	deps := p.dependencies(txs)
+	waiting := make([]int, len(txs))
+	dependents := make([][]int, len(txs))
+	var ready []int
+	for i, d := range deps {
+		waiting[i] = len(d)
+		for _, j := range d {
+			dependents[j] = append(dependents[j], i)
+		}
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ordered := make([]*Transaction, 0, len(txs))
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, txs[i])
+		for _, d := range dependents[i] {
+			if waiting[d]--; waiting[d] == 0 {
+				at, _ := slices.BinarySearch(ready, d)
+				ready = slices.Insert(ready, at, d)
+			}
+		}
+	}
+	if len(ordered) < len(txs) {
+		var stuck []string
+		for i, n := range waiting {
+			if n > 0 {
+				stuck = append(stuck, txs[i].Hash)
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle among %s", strings.Join(stuck, ", "))
+	}
+	return ordered, nil
+}
+
+// orderProblems lists the dependencies txs violate in the order given,
+// including those between the members of each bundle
+func (p *TxPool) orderProblems(txs []*Transaction) []string {
+	var problems []string
+	for i, deps := range p.dependencies(txs) {
+		for _, j := range deps {
+			if j > i {
+				problems = append(problems, fmt.Sprintf("%s comes before %s, which it must follow", txs[i].Hash, txs[j].Hash))
+			}
+		}
+	}
+	for _, e := range p.orderEntries(txs) {
+		if err := memberOrder(e.members); err != nil {
+			problems = append(problems, fmt.Sprintf("bundle %s: %v", e.tx.Hash, err))
+		}
+	}
+	return problems
+}
+
+// memberOrder checks that a bundle runs each sender's members in nonce
+// order, and each member after the others it names in MustFollow
+func memberOrder(members []*Transaction) error {
+	if len(members) < 2 {
+		return nil
+	}
+	last := make(map[string]int)
+	seen := make(map[string]bool, len(members))
+	member := make(map[string]bool, len(members))
+	for _, m := range members {
+		member[m.Hash] = true
+	}
+	for _, m := range members {
+		if n, ok := last[m.From]; ok && m.From != "" && m.Nonce <= n {
+			return fmt.Errorf("%s runs nonce %d after nonce %d of the same sender", m.Hash, m.Nonce, n)
+		}
+		for _, h := range m.MustFollow {
+			if member[h] && !seen[h] {
+				return fmt.Errorf("%s runs before %s, which it must follow", m.Hash, h)
+			}
+		}
+		if m.From != "" {
+			last[m.From] = m.Nonce
+		}
+		seen[m.Hash] = true
+	}
+	return nil
+}
+
+// nonceSlot is a sender's nonce, which one transaction in a block may use
+type nonceSlot struct {
+	from  string
+	nonce int
+}
+
+func slotOf(tx *Transaction) nonceSlot { return nonceSlot{tx.From, tx.Nonce} }
+
+// lowerNonces returns the pooled transactions of tx's sender at the nonces
+// it must follow, from the sender's confirmed nonce (if known) up. Called
+// with the pool read-locked.
+func (p *TxPool) lowerNonces(tx *Transaction) []*Transaction {
+	if tx.From == "" {
+		return nil
+	}
+	confirmed, known := p.Nonces[tx.From]
+	var txs []*Transaction
+	for _, t := range p.bySender[tx.From] {
+		if t.Nonce < tx.Nonce && (!known || uint64(t.Nonce) >= confirmed) {
+			txs = append(txs, t)
+		}
+	}
+	return txs
+}
+
+// nonceGaps returns the index of each of txs that runs a nonce of its
+// sender while the block leaves out a lower one still pooled, which the
+// chain would never reach, with the first nonce it lacks. Called with the
+// pool read-locked.
+func (p *TxPool) nonceGaps(txs []*Transaction) map[int]int {
+	entries := p.orderEntries(txs)
+	filled := make(map[nonceSlot]bool)
+	for _, e := range entries {
+		for _, m := range e.members {
+			filled[slotOf(m)] = true
+		}
+	}
+	gaps := make(map[int]int)
+	for i, e := range entries {
+		for _, m := range e.members {
+			for _, t := range p.lowerNonces(m) {
+				if filled[slotOf(t)] {
+					continue
+				}
+				if n, ok := gaps[i]; !ok || t.Nonce < n {
+					gaps[i] = t.Nonce
+				}
+			}
+		}
+	}
+	return gaps
+}
+
+// dropNonceGaps removes the transactions nonceGaps finds among txs, and
+// then those their removal leaves with a gap in turn. Injected and
+// required transactions stay, for validateBlock to report. Called with the
+// pool read-locked.
+func (p *TxPool) dropNonceGaps(txs []*Transaction, injected map[string]bool) []*Transaction {
+	for {
+		gaps := p.nonceGaps(txs)
+		kept := make([]*Transaction, 0, len(txs))
+		for i, tx := range txs {
+			if _, gap := gaps[i]; !gap || injected[tx.Hash] || slices.Contains(p.Required, tx.Hash) {
+				kept = append(kept, tx)
+			}
+		}
+		if len(kept) == len(txs) {
+			return txs
+		}
+		txs = kept
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"container/heap"
+	_ "embed"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// dashboardTop is how many of the best pending transactions the dashboard lists
+const dashboardTop = 25
+
+// dashboardSubmissions is how many recent relay submissions it keeps
+const dashboardSubmissions = 50
+
+// dashboardChurn is how many heads of pool churn it keeps
+const dashboardChurn = 30
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// Dashboard holds what the web dashboard shows beyond the pool itself: the
+// last built block, pool churn and recent relay submissions. Its methods
+// are safe for concurrent use.
+type Dashboard struct {
+	mu          sync.Mutex
+	head        uint64
+	block       *BuildReport
+	churn       []ChurnSample      // oldest first
+	submissions []SubmissionRecord // oldest first
+}
+
+// ChurnSample is how the pool changed when it synced on a head
+type ChurnSample struct {
+	Head    uint64 `json:"head"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"` // mined, dropped upstream or expired
+	Pooled  int    `json:"pooled"`
+}
+
+// SubmissionRecord is one relay's answer to a bid
+type SubmissionRecord struct {
+	Slot    uint64        `json:"slot"`
+	URL     string        `json:"url"`
+	Status  string        `json:"status"` // accepted, failed or pending
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latencyNs"`
+	At      time.Time     `json:"at"`
+}
+
+// DashboardTx is a pending transaction as the dashboard lists it
+type DashboardTx struct {
+	Hash     string `json:"hash"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	GasPrice int64  `json:"gasPrice"`
+	Gas      int64  `json:"gas"` // as packed
+	Profit   int64  `json:"profit"`
+	Score    int64  `json:"score"`
+}
+
+// DashboardState is what /dashboard/state returns
+type DashboardState struct {
+	Pool        PoolStats          `json:"pool"`
+	Top         []DashboardTx      `json:"top"`             // best executable transactions, by score
+	Head        uint64             `json:"head"`            // head the last block was built on
+	Block       *BuildReport       `json:"block,omitempty"` // the last built block
+	Churn       []ChurnSample      `json:"churn"`           // newest first
+	Submissions []SubmissionRecord `json:"submissions"`     // newest first
+	Relays      []RelayStats       `json:"relays,omitempty"`
+	Shadow      *ShadowStats       `json:"shadow,omitempty"`
+}
+
+func NewDashboard() *Dashboard {
+	return &Dashboard{}
+}
+
+// Built records r as the latest block, built on head
+func (d *Dashboard) Built(head uint64, r *BuildReport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.head, d.block = head, r
+}
+
+// Synced records the pool's churn on head
+func (d *Dashboard) Synced(head uint64, added, removed, pooled int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.churn = append(d.churn, ChurnSample{Head: head, Added: added, Removed: removed, Pooled: pooled})
+	if n := len(d.churn) - dashboardChurn; n > 0 {
+		d.churn = slices.Delete(d.churn, 0, n)
+	}
+}
+
+// Submitted records the relays' answers to the bid for slot
+func (d *Dashboard) Submitted(slot uint64, results []RelayResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now().UTC()
+	for _, res := range results {
+		rec := SubmissionRecord{Slot: slot, URL: res.URL, Status: "accepted", Latency: res.Latency, At: now}
+		switch {
+		case res.Pending:
+			rec.Status = "pending"
+		case res.Err != nil:
+			rec.Status, rec.Error = "failed", res.Err.Error()
+		}
+		d.submissions = append(d.submissions, rec)
+	}
+	if n := len(d.submissions) - dashboardSubmissions; n > 0 {
+		d.submissions = slices.Delete(d.submissions, 0, n)
+	}
+}
+
+// Top returns the n best executable transactions, by score
+func (p *TxPool) Top(n int) []*Transaction {
+	p.mu.RLock()
+	h := slices.Clone(p.Heap.TxHeap)
+	p.mu.RUnlock()
+	heap.Init(&h)
+	top := make([]*Transaction, 0, min(n, h.Len()))
+	for len(top) < n && h.Len() > 0 {
+		top = append(top, heap.Pop(&h).(*Transaction))
+	}
+	return top
+}
+
+// Top returns the n best executable transactions across the shards
+func (s *ShardedPool) Top(n int) []*Transaction {
+	var txs []*Transaction
+	for _, shard := range s.Shards {
+		txs = append(txs, shard.Top(n)...)
+	}
+	slices.SortFunc(txs, func(a, b *Transaction) int { return cmpScore(b, a) })
+	return txs[:min(n, len(txs))]
+}
+
+func (s *APIServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+func (s *APIServer) handleDashboardState(w http.ResponseWriter, r *http.Request) {
+	st := DashboardState{Pool: s.Pool.Stats()}
+	for _, tx := range s.Pool.Top(dashboardTop) {
+		st.Top = append(st.Top, DashboardTx{
+			Hash:     tx.Hash,
+			From:     tx.From,
+			To:       tx.To,
+			GasPrice: tx.GasPrice,
+			Gas:      tx.PackGas(),
+			Profit:   tx.Profit(),
+			Score:    tx.Score(),
+		})
+	}
+	d := s.Dashboard
+	d.mu.Lock()
+	st.Head, st.Block = d.head, d.block
+	st.Churn = slices.Clone(d.churn)
+	st.Submissions = slices.Clone(d.submissions)
+	d.mu.Unlock()
+	slices.Reverse(st.Churn)
+	slices.Reverse(st.Submissions)
+	if s.Relays != nil {
+		st.Relays = s.Relays.Stats()
+	}
+	if s.Shadow != nil {
+		stats := s.Shadow.Stats()
+		st.Shadow = &stats
+	}
+	writeHTTPJSON(w, st)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BestBlock tracks the highest-scoring block a running build has produced
+// so far, so a build cut short by its deadline still has something to
+// return. It is safe for concurrent use.
+type BestBlock struct {
+	mu    sync.Mutex
+	txs   []*Transaction
+	score int64
+	set   bool
+}
+
+// Offer records txs if they beat the current best and reports whether
+// they did. txs is copied.
+func (b *BestBlock) Offer(txs []*Transaction) bool {
+	score := int64(0)
+	for _, tx := range txs {
+		score += tx.Score()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.set && score <= b.score {
+		return false
+	}
+	b.txs, b.score, b.set = slices.Clone(txs), score, true
+	return true
+}
+
+// Get returns the best block so far and its score
+func (b *BestBlock) Get() ([]*Transaction, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.txs, b.score
+}
+
+// SelectWithDeadline runs strategy over pool, giving up after deadline (if
+// positive) and returning the best block found by then. timedOut reports
+// whether the deadline cut the build short. Cancelling ctx itself still
+// fails the build. The strategy's scan is bounded by budget; see
+// ScanBudget and LastScan.
+func SelectWithDeadline(ctx context.Context, pool *TxPool, strategy string, gasLimit int64, deadline time.Duration, budget ScanBudget) (txs []*Transaction, timedOut bool, err error) {
+	ctx, span := StartSpan(ctx, "select", "builder.strategy", strategy, "builder.gas_limit", gasLimit)
+	defer func() {
+		gas, profit := int64(0), int64(0)
+		for _, tx := range txs {
+			gas += tx.PackGas()
+			profit += tx.Profit()
+		}
+		span.SetAttr("block.txs", len(txs), "block.gas", gas, "block.profit_wei", profit, "builder.timed_out", timedOut)
+		span.SetError(err)
+		span.End()
+	}()
+	buildCtx := withScanBudget(ctx, budget)
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		buildCtx, cancel = context.WithTimeout(buildCtx, deadline)
+		defer cancel()
+	}
+	best := &BestBlock{}
+	txs, err = SelectWithStrategy(buildCtx, pool, strategy, gasLimit, best)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		partial, _ := best.Get()
+		if partial == nil {
+			partial = []*Transaction{}
+		}
+		// The best block predates the hooks, so they run on it now
+		pool.mu.RLock()
+		txs, injected, err := pool.packWithHooks(ctx, gasLimit, func(int64) ([]*Transaction, error) { return partial, nil })
+		if err == nil {
+			txs, err = pool.orderBlock(pool.dropNonceGaps(txs, injected))
+		}
+		if err == nil {
+			if err = pool.validateBlock(gasLimit, txs, injected); err != nil {
+				err = fmt.Errorf("strategy %q built an invalid block: %w", strategy, err)
+			}
+		}
+		pool.mu.RUnlock()
+		if err != nil {
+			return nil, false, err
+		}
+		return txs, true, nil
+	}
+	return txs, false, err
+}
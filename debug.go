@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// DebugStats is the /debug/pool snapshot of the pool and the Go runtime
+type DebugStats struct {
+	Pool       PoolStats              `json:"pool"`
+	Hints      int                    `json:"hints"`
+	Bundles    int                    `json:"bundles"`
+	Goroutines int                    `json:"goroutines"`
+	Relays     []RelayStats           `json:"relays,omitempty"` // bid submissions per relay
+	Lifecycle  *LifecycleStats        `json:"lifecycle,omitempty"`
+	Heap       map[HeapOp]HeapOpStats `json:"heap,omitempty"`     // pool heap operation latencies and allocations
+	Breakers   []BreakerStatus        `json:"breakers,omitempty"` // RPC endpoint circuit breakers
+	Memory     struct {
+		HeapAlloc   uint64 `json:"heapAlloc"`   // bytes of live and not yet collected objects
+		HeapInuse   uint64 `json:"heapInuse"`   // bytes in in-use spans
+		HeapObjects uint64 `json:"heapObjects"` // allocated objects
+		Sys         uint64 `json:"sys"`         // bytes obtained from the OS
+	} `json:"memory"`
+	GC struct {
+		NumGC         uint32        `json:"numGC"`
+		NextGC        uint64        `json:"nextGC"` // heap size that triggers the next cycle
+		LastGC        time.Time     `json:"lastGC"`
+		PauseTotal    time.Duration `json:"pauseTotalNs"`
+		LastPause     time.Duration `json:"lastPauseNs"`
+		GCCPUFraction float64       `json:"gcCPUFraction"`
+	} `json:"gc"`
+}
+
+// NewDebugStats collects the pool counters and runtime statistics. It stops
+// the world briefly to read the memory statistics.
+func NewDebugStats(pool Mempool, hints *HintBook) *DebugStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	d := &DebugStats{Pool: pool.Stats(), Goroutines: runtime.NumGoroutine()}
+	if hints != nil {
+		d.Hints, d.Bundles = hints.Len()
+	}
+	d.Memory.HeapAlloc = ms.HeapAlloc
+	d.Memory.HeapInuse = ms.HeapInuse
+	d.Memory.HeapObjects = ms.HeapObjects
+	d.Memory.Sys = ms.Sys
+	d.GC.NumGC = ms.NumGC
+	d.GC.NextGC = ms.NextGC
+	if ms.LastGC > 0 {
+		d.GC.LastGC = time.Unix(0, int64(ms.LastGC)).UTC()
+	}
+	d.GC.PauseTotal = time.Duration(ms.PauseTotalNs)
+	if ms.NumGC > 0 {
+		d.GC.LastPause = time.Duration(ms.PauseNs[(ms.NumGC+255)%256])
+	}
+	d.GC.GCCPUFraction = ms.GCCPUFraction
+	return d
+}
+
+func (s *APIServer) handleDebugPool(w http.ResponseWriter, r *http.Request) {
+	d := NewDebugStats(s.Pool, s.Hints)
+	if s.Relays != nil {
+		d.Relays = s.Relays.Stats()
+	}
+	if s.Lifecycle != nil {
+		st := s.Lifecycle.Stats()
+		d.Lifecycle = &st
+	}
+	if s.Heap != nil {
+		d.Heap = s.Heap.Stats()
+	}
+	for _, b := range s.Breakers {
+		d.Breakers = append(d.Breakers, b.Status())
+	}
+	writeHTTPJSON(w, d)
+}
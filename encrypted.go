@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// EncryptedTx is a threshold-encrypted transaction, held as ciphertext
+// until the key for its KeyID is released. The signed transaction is
+// sealed with AES-256-GCM under that key, the nonce ahead of the sealed
+// bytes, and the commitments as additional data, so they can't be altered
+// without decryption failing. Combining the keypers' shares into the key
+// happens before it reaches the builder.
+type EncryptedTx struct {
+	ID         string    `json:"id"`         // keccak256 of the ciphertext
+	KeyID      string    `json:"keyId"`      // identity whose released key decrypts it, such as an epoch
+	Ciphertext HexBytes  `json:"ciphertext"` // GCM nonce, then the sealed signed transaction
+	GasLimit   int64     `json:"gasLimit"`   // committed: the most gas the transaction may declare
+	Size       int       `json:"size"`       // committed: the signed transaction's length in bytes
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// commitments returns the additional data the ciphertext is sealed with
+func (e *EncryptedTx) commitments() []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(e.GasLimit))
+	return binary.BigEndian.AppendUint64(b, uint64(e.Size))
+}
+
+// decrypt opens the ciphertext with key and checks the transaction inside
+// against the commitments
+func (e *EncryptedTx) decrypt(key []byte) (*Transaction, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed := e.Ciphertext[:gcm.NonceSize()], e.Ciphertext[gcm.NonceSize():]
+	raw, err := gcm.Open(nil, nonce, sealed, e.commitments())
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+	if len(raw) != e.Size {
+		return nil, fmt.Errorf("decrypts to %d bytes, committed %d", len(raw), e.Size)
+	}
+	tx, err := RecoverSender(raw)
+	if err != nil {
+		return nil, err
+	}
+	if tx.GasLimit > e.GasLimit {
+		return nil, fmt.Errorf("gas limit %d is over the committed %d", tx.GasLimit, e.GasLimit)
+	}
+	return tx, nil
+}
+
+// EncryptedPool holds encrypted transactions until their keys arrive.
+// Every build reserves the committed gas of those still encrypted, leaving
+// it unused rather than packing the space, so a key released before the
+// block is sealed finds room for its transactions: they are decrypted,
+// pooled privately and open every block from then on, after the required
+// transactions and ahead of the express lane and lanes, in the order their
+// ciphertexts arrived, as that order was fixed before anyone could read
+// them. Entries whose key hasn't come within TTL are dropped. Its methods
+// are safe for concurrent use.
+type EncryptedPool struct {
+	TTL    time.Duration // how long a ciphertext waits for its key, and its transaction keeps its place once decrypted
+	MaxGas int64         // committed gas held at once; 0 is unbounded
+
+	mu       sync.Mutex
+	entries  []*EncryptedTx // awaiting keys, in arrival order
+	revealed []revealedTx   // decrypted, in the order their ciphertexts arrived
+}
+
+// revealedTx is a decrypted transaction keeping its place at the top of
+// the block
+type revealedTx struct {
+	hash       string
+	receivedAt time.Time // of its ciphertext
+}
+
+// NewEncryptedPool returns a pool configured from cfg, or nil if encrypted
+// submissions are disabled
+func NewEncryptedPool(cfg EncryptedConfig) *EncryptedPool {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &EncryptedPool{TTL: time.Duration(cfg.TTL), MaxGas: cfg.MaxGas}
+}
+
+// Add holds e until its key arrives, setting its ID
+func (p *EncryptedPool) Add(e *EncryptedTx) error {
+	switch {
+	case e.KeyID == "":
+		return errors.New("keyId is required")
+	case e.GasLimit < txBaseGas:
+		return fmt.Errorf("committed gas limit %d is under the %d of any transaction", e.GasLimit, txBaseGas)
+	case e.Size <= 0:
+		return errors.New("committed size must be positive")
+	case len(e.Ciphertext) <= 12+16:
+		return errors.New("ciphertext is too short to hold a GCM nonce and tag")
+	}
+	e.ID = Keccak256(e.Ciphertext).Hex()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(e.ReceivedAt)
+	if slices.ContainsFunc(p.entries, func(o *EncryptedTx) bool { return o.ID == e.ID }) {
+		return fmt.Errorf("%s is already held", e.ID)
+	}
+	if held := p.held(); p.MaxGas > 0 && held+e.GasLimit > p.MaxGas {
+		return fmt.Errorf("committing %d gas would hold %d, over the limit of %d", e.GasLimit, held+e.GasLimit, p.MaxGas)
+	}
+	p.entries = append(p.entries, e)
+	return nil
+}
+
+// held returns the committed gas of the entries awaiting keys. Called with
+// the pool locked.
+func (p *EncryptedPool) held() int64 {
+	gas := int64(0)
+	for _, e := range p.entries {
+		gas += e.GasLimit
+	}
+	return gas
+}
+
+// prune drops the entries and decrypted transactions older than TTL.
+// Called with the pool locked.
+func (p *EncryptedPool) prune(now time.Time) {
+	cutoff := now.Add(-p.TTL)
+	p.entries = slices.DeleteFunc(p.entries, func(e *EncryptedTx) bool { return e.ReceivedAt.Before(cutoff) })
+	p.revealed = slices.DeleteFunc(p.revealed, func(r revealedTx) bool { return r.receivedAt.Before(cutoff) })
+}
+
+// RevealResult is what became of one entry when its key arrived
+type RevealResult struct {
+	ID     string `json:"id"`
+	Hash   string `json:"hash,omitempty"`   // of the decrypted transaction
+	Result string `json:"result,omitempty"` // its admission
+	Error  string `json:"error,omitempty"`  // why it couldn't be decrypted or pooled
+}
+
+// Reveal decrypts every entry waiting for keyID with key and submits the
+// transactions to mempool, kept privately for the pool's TTL. Entries that
+// don't decrypt or don't match their commitments are dropped.
+func (p *EncryptedPool) Reveal(keyID string, key []byte, mempool Mempool, now time.Time) ([]RevealResult, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, want 32", len(key))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(now)
+	results := []RevealResult{}
+	var keep []*EncryptedTx
+	for _, e := range p.entries {
+		if e.KeyID != keyID {
+			keep = append(keep, e)
+			continue
+		}
+		r := RevealResult{ID: e.ID}
+		tx, err := e.decrypt(key)
+		if err == nil {
+			tx.ReceivedAt = now
+			res, reason := mempool.Submit(tx, p.TTL)
+			r.Hash, r.Result = tx.Hash, res.String()
+			if res == TxRejected && reason != RejectDuplicate {
+				err = fmt.Errorf("rejected: %s", reason)
+			}
+		}
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			p.revealed = append(p.revealed, revealedTx{hash: tx.Hash, receivedAt: e.ReceivedAt})
+		}
+		results = append(results, r)
+	}
+	p.entries = keep
+	slices.SortStableFunc(p.revealed, func(a, b revealedTx) int { return a.receivedAt.Compare(b.receivedAt) })
+	return results, nil
+}
+
+// EncryptedSet is what the encrypted pool asks of one build
+type EncryptedSet struct {
+	Reserved int64    `json:"reserved"`           // committed gas of the transactions still encrypted
+	Revealed []string `json:"revealed,omitempty"` // decrypted transactions opening the block, in order
+}
+
+// Set returns what a build at now must reserve and place, or nil if
+// nothing
+func (p *EncryptedPool) Set(now time.Time) *EncryptedSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(now)
+	if len(p.entries) == 0 && len(p.revealed) == 0 {
+		return nil
+	}
+	s := &EncryptedSet{Reserved: p.held()}
+	for _, r := range p.revealed {
+		s.Revealed = append(s.Revealed, r.hash)
+	}
+	return s
+}
+
+// reserved returns the gas s holds back from the packer, 0 if s is nil
+func (s *EncryptedSet) reserved() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.Reserved
+}
+
+// revealedSince reports whether s places a decrypted transaction built,
+// the set a block was built with, didn't
+func (s *EncryptedSet) revealedSince(built *EncryptedSet) bool {
+	if s == nil {
+		return false
+	}
+	return slices.ContainsFunc(s.Revealed, func(hash string) bool {
+		return built == nil || !slices.Contains(built.Revealed, hash)
+	})
+}
+
+// EncryptedStatus is the encrypted pool as served at /encrypted
+type EncryptedStatus struct {
+	Pending  []*EncryptedTx `json:"pending"` // awaiting keys, in arrival order
+	Reserved int64          `json:"reserved"`
+	Revealed []string       `json:"revealed"`
+}
+
+// Status returns the entries awaiting keys and the decrypted transactions
+func (p *EncryptedPool) Status(now time.Time) EncryptedStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(now)
+	st := EncryptedStatus{Pending: slices.Clone(p.entries), Reserved: p.held(), Revealed: []string{}}
+	if st.Pending == nil {
+		st.Pending = []*EncryptedTx{}
+	}
+	for _, r := range p.revealed {
+		st.Revealed = append(st.Revealed, r.hash)
+	}
+	return st
+}
+
+// revealed returns the decrypted transactions s places, nil if s is nil
+func (s *EncryptedSet) revealed() []string {
+	if s == nil {
+		return nil
+	}
+	return s.Revealed
+}
+
+// fillSlots picks the transactions of hashes whose places in the block are
+// fixed, such as decrypted ones, in that order within gasLimit, skipping
+// any no longer pooled or in conflict with those in used or picked before
+func (p *TxPool) fillSlots(hashes []string, gasLimit int64, used map[string]bool, graph *ConflictGraph) []*Transaction {
+	var selected []*Transaction
+	taken := maps.Clone(used)
+	gas := int64(0)
+	for _, hash := range hashes {
+		tx := p.AllTxs[hash]
+		if tx == nil || taken[hash] || gas+tx.GasLimit > gasLimit || graph.Conflicts(hash, taken) {
+			continue
+		}
+		gas += tx.PackGas()
+		taken[hash] = true
+		selected = append(selected, tx)
+	}
+	return selected
+}
+
+// inRevealed reports whether tx is a decrypted transaction keeping its
+// place
+func (p *TxPool) inRevealed(tx *Transaction) bool {
+	return slices.Contains(p.Encrypted.revealed(), tx.Hash)
+}
+
+// handleEncrypted returns the entries awaiting keys and the decrypted
+// transactions
+func (s *APIServer) handleEncrypted(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, s.Encrypted.Status(time.Now()))
+}
+
+// handleEncryptedSubmit holds a JSON EncryptedTx until its key arrives
+func (s *APIServer) handleEncryptedSubmit(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var e EncryptedTx
+	if err := json.Unmarshal(body, &e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.ReceivedAt = received
+	if err := s.Encrypted.Add(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHTTPJSON(w, map[string]string{"id": e.ID})
+}
+
+// handleEncryptedKey decrypts the entries waiting for a released key, given
+// as {"keyId": ..., "key": "0x..."}
+func (s *APIServer) handleEncryptedKey(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req struct {
+		KeyID string   `json:"keyId"`
+		Key   HexBytes `json:"key"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := s.Encrypted.Reveal(req.KeyID, req.Key, s.Pool, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHTTPJSON(w, results)
+}
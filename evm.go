@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// Calls and creates that fail without running any code, returning the gas
+// they were given
+var (
+	errDepth               = errors.New("max call depth exceeded")
+	errInsufficientBalance = errors.New("insufficient balance for transfer")
+	errNonceMax            = errors.New("nonce has max value")
+)
+
+// Creates that fail exceptionally
+var (
+	errCollision   = errors.New("contract address collision")
+	errCodeSize    = errors.New("max code size exceeded")
+	errInvalidCode = errors.New("invalid code: must not begin with 0xef")
+)
+
+// delegationPrefix starts the code of an account that delegates to
+// another's (EIP-7702)
+var delegationPrefix = []byte{0xef, 0x01, 0x00}
+
+// LocalEVM executes transactions in process against the state a
+// StateCache holds, so simulating one costs microseconds once its state
+// is cached rather than a round trip to the node. State it hasn't cached
+// yet is read from the node as execution reaches it.
+//
+// It implements the Cancun rules, with Prague's calldata floor and code
+// delegations when the chain has them, and all the precompiles but the
+// alt_bn128, KZG and BLS12-381 ones. Execution that reaches one of those,
+// BLOBBASEFEE or a block hash older than the head's parent fails with
+// errUnsupported, as do blob and set-code transactions and those with no
+// recipient not known to be creations, and the caller falls back to the
+// node.
+//
+// It is written on the builder's own Keccak, secp256k1, RLP and trie code
+// rather than go-ethereum's core/vm, which would be its first dependency
+// outside the standard library and keep it from building offline; its
+// gas, logs and state writes were checked against core/vm's.
+type LocalEVM struct {
+	State     stateReader
+	ChainID   uint64
+	BlockTime time.Duration // the block executed in is this long after the head
+	Prague    bool
+}
+
+// NewLocalEVM returns an EVM executing against state, or nil if local
+// simulation is disabled
+func NewLocalEVM(state *StateCache, cfg SimulationConfig, chainID uint64, forks Forks, blockTime time.Duration) *LocalEVM {
+	if !cfg.Local || state == nil {
+		return nil
+	}
+	return &LocalEVM{State: state, ChainID: chainID, BlockTime: blockTime, Prague: forks.Prague}
+}
+
+// ExecResult is a transaction's outcome in local execution
+type ExecResult struct {
+	TxResult
+	Transfers []Transfer // native value it moved, but not fees; none if it reverted
+}
+
+// Execute runs txs in order, each on the state the one before it left, in
+// the block after the cache's head with coinbase as fee recipient. As
+// with eth_simulateV1 nonces aren't checked, but senders must afford their
+// gas and value. It returns each transaction's outcome and the storage the
+// run read and wrote.
+func (l *LocalEVM) Execute(ctx context.Context, coinbase Address, txs []*Transaction) ([]ExecResult, *AccessSet, error) {
+	head := l.State.Head()
+	if head == nil {
+		return nil, nil, fmt.Errorf("state cache has no block")
+	}
+	block := &blockContext{
+		Coinbase: coinbase,
+		Number:   head.Number + 1,
+		Time:     head.Timestamp + uint64(max(l.BlockTime/time.Second, 1)),
+		GasLimit: head.GasLimit,
+		BaseFee:  NextBaseFee(head),
+		ChainID:  l.ChainID,
+		parent:   head,
+	}
+	state := newEVMState(ctx, l.State)
+	results := make([]ExecResult, len(txs))
+	for i, tx := range txs {
+		e := &evm{state: state, block: block, prague: l.Prague}
+		res, err := e.apply(tx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("executing %s: %w", tx.Hash, err)
+		}
+		results[i] = res
+	}
+	return results, state.access(), nil
+}
+
+// blockContext is the block local execution runs in
+type blockContext struct {
+	Coinbase Address
+	Number   uint64
+	Time     uint64
+	GasLimit int64
+	BaseFee  int64
+	ChainID  uint64
+	parent   *Header
+}
+
+// blockHash returns the hash of block n, which BLOCKHASH answers for the
+// 256 blocks before this one. Only the parent's and its parent's are
+// known.
+func (b *blockContext) blockHash(n *big.Int) (Hash, error) {
+	if !n.IsUint64() || n.Uint64() >= b.Number || b.Number-n.Uint64() > 256 {
+		return Hash{}, nil
+	}
+	switch n.Uint64() {
+	case b.parent.Number:
+		return HexToHash(b.parent.Hash)
+	case b.parent.Number - 1:
+		return HexToHash(b.parent.ParentHash)
+	}
+	return Hash{}, errUnsupported
+}
+
+// evm executes one transaction
+type evm struct {
+	state    *evmState
+	block    *blockContext
+	origin   Address
+	gasPrice *big.Int
+	prague   bool
+	depth    int
+}
+
+// precompile returns the precompile at addr, if there is one
+func (e *evm) precompile(addr Address) *precompile {
+	if p := precompiles[addr]; p != nil {
+		return p
+	}
+	if e.prague && slices.Contains(praguePrecompiles, addr) {
+		return unsupportedPrecompile
+	}
+	return nil
+}
+
+// delegation returns the account addr's code delegates to, if it does
+func (e *evm) delegation(addr Address) (Address, bool) {
+	if !e.prague {
+		return Address{}, false
+	}
+	code := e.state.code(addr)
+	if len(code) != len(delegationPrefix)+len(Address{}) || !bytes.HasPrefix(code, delegationPrefix) {
+		return Address{}, false
+	}
+	return Address(code[len(delegationPrefix):]), true
+}
+
+func createAddress(from Address, nonce uint64) Address {
+	h := Keccak256(rlpList(rlpBytes(from[:]), rlpUint(nonce)))
+	return Address(h[12:])
+}
+
+func create2Address(from Address, salt, initHash Hash) Address {
+	h := Keccak256([]byte{0xff}, from[:], salt[:], initHash[:])
+	return Address(h[12:])
+}
+
+// floorGas is the least gas a transaction with input is charged since
+// Prague (EIP-7623)
+func floorGas(input []byte) uint64 {
+	tokens := uint64(0)
+	for _, b := range input {
+		if b == 0 {
+			tokens++
+		} else {
+			tokens += 4
+		}
+	}
+	return txBaseGas + 10*tokens
+}
+
+// apply executes tx: it buys its gas, runs it and refunds what is left,
+// crediting the tip to the coinbase
+func (e *evm) apply(tx *Transaction) (ExecResult, error) {
+	s := e.state
+	if tx.Type == 3 || tx.Type == 4 {
+		return ExecResult{}, fmt.Errorf("type %d transaction: %w", tx.Type, errUnsupported)
+	}
+	from, err := HexToAddress(tx.From)
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("sender: %w", err)
+	}
+	create := tx.Create
+	var to Address
+	switch {
+	case create:
+	case tx.To == "":
+		// Neither a known creation nor a known call, as for a transaction
+		// whose node didn't say which
+		return ExecResult{}, fmt.Errorf("unknown recipient: %w", errUnsupported)
+	default:
+		if to, err = HexToAddress(tx.To); err != nil {
+			return ExecResult{}, fmt.Errorf("recipient: %w", err)
+		}
+	}
+	value := new(big.Int)
+	if tx.Value != nil {
+		value.Set(tx.Value)
+	}
+
+	price := tx.GasPrice
+	if tx.DynamicFee() {
+		price = min(tx.GasPrice, e.block.BaseFee+tx.GasTipCap)
+	}
+	e.origin, e.gasPrice = from, big.NewInt(price)
+	intrinsic, floor := uint64(tx.IntrinsicGas()), uint64(0)
+	if e.prague {
+		floor = floorGas(tx.Input)
+	}
+	gasLimit := uint64(tx.GasLimit)
+	switch {
+	case tx.GasLimit < 0 || gasLimit < max(intrinsic, floor):
+		return ExecResult{}, fmt.Errorf("gas limit %d under the intrinsic %d", tx.GasLimit, max(intrinsic, floor))
+	case create && len(tx.Input) > maxInitCodeSize:
+		return ExecResult{}, fmt.Errorf("init code of %d bytes over the limit of %d", len(tx.Input), maxInitCodeSize)
+	}
+	fee := new(big.Int).Mul(e.gasPrice, new(big.Int).SetUint64(gasLimit))
+	if need := new(big.Int).Add(fee, value); s.balance(from).Cmp(need) < 0 {
+		return ExecResult{}, fmt.Errorf("insufficient funds: balance %s, need %s", s.balance(from), need)
+	}
+	s.subBalance(from, fee)
+
+	// Warm what every transaction touches (EIP-2929, EIP-2930, EIP-3651)
+	s.warmAddress(from)
+	s.warmAddress(e.block.Coinbase)
+	for addr := range precompiles {
+		s.warmAddress(addr)
+	}
+	if e.prague {
+		for _, addr := range praguePrecompiles {
+			s.warmAddress(addr)
+		}
+	}
+	for _, t := range tx.AccessList {
+		addr, err := HexToAddress(t.Address)
+		if err != nil {
+			return ExecResult{}, fmt.Errorf("access list: %w", err)
+		}
+		s.warmAddress(addr)
+		for _, key := range t.StorageKeys {
+			slot, err := HexToHash(key)
+			if err != nil {
+				return ExecResult{}, fmt.Errorf("access list: %w", err)
+			}
+			s.warmSlot(addr, slot)
+		}
+	}
+
+	gas := gasLimit - intrinsic
+	if create {
+		_, gas, err = e.create(from, tx.Input, gas, value, createAddress(from, s.nonce(from)))
+	} else {
+		s.warmAddress(to)
+		s.setNonce(from, s.nonce(from)+1)
+		codeAddr := to
+		if target, ok := e.delegation(to); ok {
+			s.warmAddress(target)
+			codeAddr = target
+		}
+		f := &frame{caller: from, address: to, value: value, input: tx.Input, gas: gas}
+		_, gas, err = e.execute(f, codeAddr, from, true)
+	}
+
+	used := gasLimit - gas
+	used -= min(s.refund, used/5) // EIP-3529
+	used = max(used, floor)
+	s.addBalance(from, new(big.Int).Mul(e.gasPrice, new(big.Int).SetUint64(gasLimit-used)))
+	if tip := price - e.block.BaseFee; tip > 0 {
+		s.addBalance(e.block.Coinbase, new(big.Int).Mul(big.NewInt(tip), new(big.Int).SetUint64(used)))
+	}
+	res := ExecResult{TxResult: TxResult{GasUsed: int64(used), Status: 1, Logs: s.logs}, Transfers: s.transfers}
+	if err != nil {
+		res.Status, res.Logs, res.Transfers = 0, nil, nil
+	}
+	if s.err != nil {
+		return ExecResult{}, s.err
+	}
+	s.finish()
+	return res, nil
+}
+
+// execute runs codeAddr's code in f, first moving f.value from from to
+// f.address if transfer is set. It returns the output and the gas left,
+// none if the code halted exceptionally; a failed call's changes are
+// undone.
+func (e *evm) execute(f *frame, codeAddr, from Address, transfer bool) ([]byte, uint64, error) {
+	s := e.state
+	if e.depth > maxCallDepth {
+		return nil, f.gas, errDepth
+	}
+	if transfer && s.balance(from).Cmp(f.value) < 0 {
+		return nil, f.gas, errInsufficientBalance
+	}
+	snap := s.snapshot()
+	if transfer {
+		s.transfer(from, f.address, f.value)
+	}
+	var ret []byte
+	var err error
+	if p := e.precompile(codeAddr); p != nil {
+		if cost := p.gas(f.input); !f.useGas(cost) {
+			err = errOutOfGas
+		} else if ret, err = p.run(f.input); errors.Is(err, errUnsupported) {
+			s.fail(fmt.Errorf("precompile %s: %w", codeAddr.Hex(), err))
+		}
+	} else {
+		f.code = s.code(codeAddr)
+		e.depth++
+		ret, err = e.run(f)
+		e.depth--
+	}
+	if err != nil {
+		s.revertTo(snap)
+		if err != errExecutionReverted {
+			f.gas = 0
+		}
+	}
+	return ret, f.gas, err
+}
+
+// create deploys the contract code returns at addr, endowed with value
+// from caller, and returns the output (only kept on revert) and the gas
+// left
+func (e *evm) create(caller Address, code []byte, gas uint64, value *big.Int, addr Address) ([]byte, uint64, error) {
+	s := e.state
+	if e.depth > maxCallDepth {
+		return nil, gas, errDepth
+	}
+	if s.balance(caller).Cmp(value) < 0 {
+		return nil, gas, errInsufficientBalance
+	}
+	nonce := s.nonce(caller)
+	if nonce+1 < nonce {
+		return nil, gas, errNonceMax
+	}
+	s.setNonce(caller, nonce+1)
+	s.warmAddress(addr)
+	if a := s.account(addr); a.nonce != 0 || a.codeHash != EmptyCodeHash || !a.fresh {
+		return nil, 0, errCollision // EIP-7610
+	}
+
+	snap := s.snapshot()
+	s.createAccount(addr)
+	s.transfer(caller, addr, value)
+	f := &frame{caller: caller, address: addr, value: value, code: code, gas: gas}
+	e.depth++
+	ret, err := e.run(f)
+	e.depth--
+	if err == nil {
+		switch {
+		case len(ret) > maxCodeSize:
+			err = errCodeSize
+		case len(ret) > 0 && ret[0] == 0xef:
+			err = errInvalidCode
+		case !f.useGas(gasCodeDeposit * uint64(len(ret))):
+			err = errOutOfGas
+		default:
+			s.setCode(addr, ret)
+		}
+	}
+	if err != nil {
+		s.revertTo(snap)
+		if err != errExecutionReverted {
+			f.gas = 0
+		}
+	}
+	return ret, f.gas, err
+}
+
+// opCreate runs CREATE or CREATE2
+func (e *evm) opCreate(f *frame, create2 bool) error {
+	s := e.state
+	value, offset, size := f.pop(), f.pop(), f.pop()
+	var salt Hash
+	if create2 {
+		salt = wordHash(f.pop())
+	}
+	if f.static {
+		return errWriteProtection
+	}
+	if !size.IsUint64() || size.Uint64() > maxInitCodeSize {
+		return errOutOfGas
+	}
+	words := toWordSize(size.Uint64())
+	gas := gasInitCodeWord * words
+	if create2 {
+		gas += gasKeccakWord * words
+	}
+	if !f.useGas(gas) {
+		return errOutOfGas
+	}
+	mem, err := f.memory(offset, size)
+	if err != nil {
+		return err
+	}
+	code := append([]byte(nil), mem...)
+	addr := createAddress(f.address, s.nonce(f.address))
+	if create2 {
+		addr = create2Address(f.address, salt, Keccak256(code))
+	}
+
+	gas = f.gas - f.gas/64
+	f.gas -= gas
+	ret, left, err := e.create(f.address, code, gas, value, addr)
+	f.gas += left
+	if err == nil {
+		f.push(new(big.Int).SetBytes(addr[:]))
+	} else {
+		f.push(new(big.Int))
+	}
+	f.returnData = nil
+	if err == errExecutionReverted {
+		f.returnData = ret
+	}
+	return nil
+}
+
+// opCall runs CALL, CALLCODE, DELEGATECALL or STATICCALL
+func (e *evm) opCall(f *frame, op byte) error {
+	s := e.state
+	requested, addr := f.pop(), wordAddress(f.pop())
+	value := new(big.Int)
+	if op == 0xf1 || op == 0xf2 {
+		value = f.pop()
+	}
+	inOffset, inSize, outOffset, outSize := f.pop(), f.pop(), f.pop(), f.pop()
+	if op == 0xf1 && f.static && value.Sign() != 0 {
+		return errWriteProtection
+	}
+
+	for _, r := range [][2]*big.Int{{inOffset, inSize}, {outOffset, outSize}} {
+		if !f.expand(r[0], r[1]) {
+			return errOutOfGas
+		}
+	}
+	if !e.accessAccount(f, addr) {
+		return errOutOfGas
+	}
+	codeAddr := addr
+	if target, ok := e.delegation(addr); ok {
+		if !e.accessAccount(f, target) {
+			return errOutOfGas
+		}
+		codeAddr = target
+	}
+	if value.Sign() != 0 {
+		if !f.useGas(gasCallValue) || (op == 0xf1 && !s.exists(addr) && !f.useGas(gasNewAccount)) {
+			return errOutOfGas
+		}
+	}
+
+	// All but a 64th of what is left may be passed on (EIP-150)
+	gas := f.gas - f.gas/64
+	if requested.IsUint64() && requested.Uint64() < gas {
+		gas = requested.Uint64()
+	}
+	f.gas -= gas
+	if value.Sign() != 0 {
+		gas += gasCallStipend
+	}
+
+	var input []byte
+	if inSize.Sign() != 0 {
+		input = append(input, f.mem[inOffset.Uint64():inOffset.Uint64()+inSize.Uint64()]...)
+	}
+	child := &frame{caller: f.address, address: addr, value: value, input: input, gas: gas, static: f.static}
+	transfer := true
+	switch op {
+	case 0xf2: // CALLCODE
+		child.address = f.address
+	case 0xf4: // DELEGATECALL
+		child.caller, child.address, child.value, transfer = f.caller, f.address, f.value, false
+	case 0xfa: // STATICCALL
+		child.static, transfer = true, false
+	}
+	ret, left, err := e.execute(child, codeAddr, f.address, transfer)
+	f.gas += left
+	f.pushBool(err == nil)
+	if (err == nil || err == errExecutionReverted) && outSize.Sign() != 0 {
+		o := outOffset.Uint64()
+		copy(f.mem[o:o+outSize.Uint64()], ret)
+	}
+	f.returnData = ret
+	return nil
+}
+
+// selfDestruct sends f's balance to beneficiary, deleting f's account at
+// the end of the transaction only if the transaction created it (EIP-6780)
+func (e *evm) selfDestruct(f *frame, beneficiary Address) error {
+	s := e.state
+	var gas uint64
+	if !s.addressWarm(beneficiary) {
+		s.warmAddress(beneficiary)
+		gas += gasColdAccount
+	}
+	balance := s.balance(f.address)
+	if balance.Sign() > 0 && !s.exists(beneficiary) {
+		gas += gasNewAccount
+	}
+	if !f.useGas(gas) {
+		return errOutOfGas
+	}
+	s.transfer(f.address, beneficiary, balance)
+	if s.created[f.address] {
+		s.selfDestruct(f.address)
+	}
+	return nil
+}
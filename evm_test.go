@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// memState is a stateReader holding its state in memory
+type memState struct {
+	head     Header
+	accounts map[Address]*AccountState
+	storage  map[Address]map[Hash]Hash
+	code     map[Address][]byte
+}
+
+func newMemState() *memState {
+	return &memState{
+		head: Header{
+			Number:     100,
+			Hash:       "0x" + strings.Repeat("11", 32),
+			ParentHash: "0x" + strings.Repeat("22", 32),
+			GasLimit:   30_000_000,
+			GasUsed:    15_000_000,
+			BaseFee:    7,
+			Timestamp:  1_700_000_000,
+		},
+		accounts: make(map[Address]*AccountState),
+		storage:  make(map[Address]map[Hash]Hash),
+		code:     make(map[Address][]byte),
+	}
+}
+
+func (m *memState) Head() *Header { return &m.head }
+
+func (m *memState) Account(_ context.Context, addr Address) (*AccountState, error) {
+	if a := m.accounts[addr]; a != nil {
+		return a, nil
+	}
+	return &AccountState{Balance: new(big.Int), CodeHash: EmptyCodeHash, StorageHash: EmptyRootHash}, nil
+}
+
+func (m *memState) Storage(_ context.Context, addr Address, slot Hash) (Hash, error) {
+	return m.storage[addr][slot], nil
+}
+
+func (m *memState) Code(_ context.Context, addr Address) ([]byte, error) {
+	return m.code[addr], nil
+}
+
+// set puts an account with code and storage slots in the state
+func (m *memState) set(addr Address, balance *big.Int, code []byte, slots map[Hash]Hash) {
+	a := &AccountState{Balance: balance, CodeHash: EmptyCodeHash, StorageHash: EmptyRootHash}
+	if len(code) > 0 {
+		a.Nonce, a.CodeHash = 1, Keccak256(code)
+		m.code[addr] = code
+	}
+	if len(slots) > 0 {
+		a.StorageHash = Hash{1}
+		m.storage[addr] = slots
+	}
+	m.accounts[addr] = a
+}
+
+var (
+	testSender   = Address{0xaa}
+	testCoinbase = Address{0xcb}
+	ether        = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+)
+
+func testContract(n byte) Address { return Address{0xc0, n} }
+
+// leftPad pads hex digits with zeros to n of them
+func leftPad(digits string, n int) string {
+	digits = strings.TrimPrefix(digits, "0x")
+	return strings.Repeat("0", max(n-len(digits), 0)) + digits
+}
+
+// asm assembles space-separated mnemonics, each PUSHn followed by its
+// operand, always in hex
+func asm(src string) []byte {
+	ops := map[string]byte{
+		"STOP": 0x00, "ADD": 0x01, "MUL": 0x02, "SUB": 0x03, "DIV": 0x04, "SDIV": 0x05, "MOD": 0x06, "SMOD": 0x07,
+		"ADDMOD": 0x08, "MULMOD": 0x09, "EXP": 0x0a, "SIGNEXTEND": 0x0b, "LT": 0x10, "GT": 0x11, "EQ": 0x14,
+		"ISZERO": 0x15, "AND": 0x16, "NOT": 0x19, "BYTE": 0x1a, "SHL": 0x1b, "SHR": 0x1c, "SAR": 0x1d, "KECCAK256": 0x20,
+		"ADDRESS": 0x30, "BALANCE": 0x31, "CALLER": 0x33, "CALLVALUE": 0x34, "CALLDATALOAD": 0x35, "CALLDATASIZE": 0x36,
+		"CALLDATACOPY": 0x37, "CODECOPY": 0x39, "RETURNDATASIZE": 0x3d, "RETURNDATACOPY": 0x3e, "BLOCKHASH": 0x40,
+		"COINBASE": 0x41, "NUMBER": 0x43, "SELFBALANCE": 0x47, "POP": 0x50, "MLOAD": 0x51, "MSTORE": 0x52,
+		"SLOAD": 0x54, "SSTORE": 0x55, "JUMP": 0x56, "JUMPI": 0x57, "GAS": 0x5a, "JUMPDEST": 0x5b, "TLOAD": 0x5c,
+		"TSTORE": 0x5d, "MCOPY": 0x5e, "PUSH0": 0x5f, "CREATE": 0xf0, "CALL": 0xf1, "RETURN": 0xf3,
+		"DELEGATECALL": 0xf4, "CREATE2": 0xf5, "STATICCALL": 0xfa, "REVERT": 0xfd, "SELFDESTRUCT": 0xff,
+	}
+	var code []byte
+	fields := strings.Fields(src)
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if op, ok := ops[f]; ok {
+			code = append(code, op)
+			continue
+		}
+		name := strings.TrimRight(f, "0123456789")
+		n, err := strconv.Atoi(f[len(name):])
+		if err != nil {
+			panic("unknown mnemonic " + f)
+		}
+		switch name {
+		case "PUSH":
+			i++
+			operand, err := hex.DecodeString(leftPad(fields[i], 2*n))
+			if err != nil || len(operand) != n {
+				panic("bad operand " + fields[i])
+			}
+			code = append(append(code, 0x5f+byte(n)), operand...)
+		case "DUP":
+			code = append(code, 0x7f+byte(n))
+		case "SWAP":
+			code = append(code, 0x8f+byte(n))
+		case "LOG":
+			code = append(code, 0xa0+byte(n))
+		default:
+			panic("unknown mnemonic " + f)
+		}
+	}
+	return code
+}
+
+func word(hexValue string) []byte {
+	b, err := hex.DecodeString(leftPad(hexValue, 64))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// testTx calls to, or creates a contract if it is nil
+func testTx(to *Address, input []byte, value int64) *Transaction {
+	tx := &Transaction{From: testSender.Hex(), GasLimit: 1_000_000, GasPrice: 10, Input: input, Value: big.NewInt(value)}
+	if to != nil {
+		tx.To = to.Hex()
+	} else {
+		tx.Create = true
+	}
+	return tx
+}
+
+// execute runs txs against state with a fresh EVM, failing the test on
+// error
+func execute(t *testing.T, state *memState, txs ...*Transaction) ([]ExecResult, *AccessSet) {
+	t.Helper()
+	for i, tx := range txs {
+		tx.Hash = fmt.Sprintf("tx%d", i)
+	}
+	l := &LocalEVM{State: state, ChainID: 80094, BlockTime: 2e9, Prague: true}
+	results, access, err := l.Execute(context.Background(), testCoinbase, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results, access
+}
+
+func checkResult(t *testing.T, res ExecResult, status uint64, gasUsed int64) {
+	t.Helper()
+	if res.Status != status || res.GasUsed != gasUsed {
+		t.Errorf("status %d and %d gas used, want %d and %d", res.Status, res.GasUsed, status, gasUsed)
+	}
+}
+
+func logWord(t *testing.T, res ExecResult, i int) *big.Int {
+	t.Helper()
+	if len(res.Logs) != 1 || len(res.Logs[0].Data) < 32*(i+1) {
+		t.Fatalf("logs %+v", res.Logs)
+	}
+	return new(big.Int).SetBytes(res.Logs[0].Data[32*i : 32*(i+1)])
+}
+
+func newSenderState() *memState {
+	m := newMemState()
+	m.set(testSender, ether, nil, nil)
+	return m
+}
+
+func TestExecuteStorageAcrossTransactions(t *testing.T) {
+	// Increments slot 0 and logs it; the second run finds it already set
+	counter := testContract(1)
+	m := newSenderState()
+	m.set(counter, new(big.Int), asm("PUSH1 0 SLOAD PUSH1 1 ADD DUP1 PUSH1 0 SSTORE PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 LOG0"), nil)
+	results, access := execute(t, m, testTx(&counter, nil, 0), testTx(&counter, nil, 0))
+	checkResult(t, results[0], 1, 43761)
+	checkResult(t, results[1], 1, 26661)
+	for i, want := range []int64{1, 2} {
+		if got := logWord(t, results[i], 0); got.Int64() != want {
+			t.Errorf("run %d logged %d, want %d", i, got, want)
+		}
+	}
+	key := storageKey(counter.Hex(), Hash{}.Hex())
+	if !access.Reads[key] || !access.Writes[key] || len(access.Writes) != 1 {
+		t.Errorf("access %+v", access)
+	}
+}
+
+func TestExecuteRefundsClearedSlot(t *testing.T) {
+	clearer := testContract(1)
+	m := newSenderState()
+	m.set(clearer, new(big.Int), asm("PUSH1 0 PUSH1 1 SSTORE"), map[Hash]Hash{{31: 1}: {31: 5}})
+	results, _ := execute(t, m, testTx(&clearer, nil, 0))
+	// 21000 + 6 + 2100 + 2900, less the 4800 refund
+	checkResult(t, results[0], 1, 21206)
+}
+
+func TestExecuteArithmetic(t *testing.T) {
+	minus8 := "fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff8"
+	minus16 := "fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff0"
+	calc := testContract(1)
+	m := newSenderState()
+	m.set(calc, new(big.Int), asm(
+		"PUSH1 3 PUSH32 "+minus8+" SDIV PUSH1 0 MSTORE "+
+			"PUSH1 3 PUSH32 "+minus8+" SMOD PUSH1 0x20 MSTORE "+
+			"PUSH1 0xff PUSH1 0 SIGNEXTEND PUSH1 0x40 MSTORE "+
+			"PUSH32 "+minus16+" PUSH1 2 SAR PUSH1 0x60 MSTORE "+
+			"PUSH1 3 PUSH1 2 EXP PUSH1 0x80 MSTORE "+
+			"PUSH1 7 PUSH1 5 PUSH1 4 MULMOD PUSH1 0xa0 MSTORE "+
+			"PUSH1 1 PUSH1 0 SUB PUSH1 0xc0 MSTORE "+
+			"PUSH2 0x1234 PUSH1 0x1f BYTE PUSH1 0xe0 MSTORE "+
+			"PUSH1 1 PUSH1 0xff SHL PUSH2 0x0100 MSTORE "+
+			"PUSH2 0x0120 PUSH1 0 LOG0"), nil)
+	results, _ := execute(t, m, testTx(&calc, nil, 0))
+	checkResult(t, results[0], 1, 23918)
+	minus := func(v int64) *big.Int { return new(big.Int).Add(tt256, big.NewInt(-v)) }
+	want := []*big.Int{minus(2), minus(2), minus(1), minus(4), big.NewInt(8), big.NewInt(6), minus(1), big.NewInt(0x34), tt255}
+	for i, w := range want {
+		if got := logWord(t, results[0], i); got.Cmp(w) != 0 {
+			t.Errorf("result %d is %x, want %x", i, got, w)
+		}
+	}
+}
+
+func TestExecuteRevertAndOutOfGas(t *testing.T) {
+	reverter, looper, hog := testContract(1), testContract(2), testContract(3)
+	m := newSenderState()
+	m.set(reverter, new(big.Int), asm("PUSH1 1 PUSH1 0 SSTORE PUSH1 0 PUSH1 0 REVERT"), nil)
+	m.set(looper, new(big.Int), asm("JUMPDEST PUSH1 0 JUMP"), nil)
+	m.set(hog, new(big.Int), asm("PUSH5 0100000000 MLOAD"), nil) // 4 GiB of memory
+	loop := testTx(&looper, nil, 0)
+	loop.GasLimit = 100_000
+	results, access := execute(t, m, testTx(&reverter, nil, 0), loop, testTx(&hog, nil, 0))
+	checkResult(t, results[0], 0, 43112)
+	checkResult(t, results[1], 0, 100_000)
+	checkResult(t, results[2], 0, 1_000_000)
+	key := storageKey(reverter.Hex(), Hash{}.Hex())
+	if !access.Reads[key] || len(access.Writes) != 0 {
+		t.Errorf("access %+v", access)
+	}
+}
+
+func TestExecuteTransfersToCoinbase(t *testing.T) {
+	// Forwards what it is sent to the block's coinbase
+	forwarder := testContract(1)
+	m := newSenderState()
+	m.set(forwarder, new(big.Int), asm("PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 CALLVALUE COINBASE GAS CALL"), nil)
+	direct := testTx(&testCoinbase, nil, 7)
+	results, _ := execute(t, m, testTx(&forwarder, nil, 1000), direct)
+	checkResult(t, results[0], 1, 52818)
+	checkResult(t, results[1], 1, 21000)
+	want := [][]Transfer{
+		{{From: testSender, To: forwarder, Value: big.NewInt(1000)}, {From: forwarder, To: testCoinbase, Value: big.NewInt(1000)}},
+		{{From: testSender, To: testCoinbase, Value: big.NewInt(7)}},
+	}
+	for i := range want {
+		if fmt.Sprint(results[i].Transfers) != fmt.Sprint(want[i]) {
+			t.Errorf("transaction %d transfers %v, want %v", i, results[i].Transfers, want[i])
+		}
+	}
+}
+
+func TestExecuteCallsAndCreates(t *testing.T) {
+	// The library writes slot 0, which a delegate call makes the caller's
+	// and a static call forbids; the caller logs both calls' success
+	library, caller := testContract(1), testContract(2)
+	m := newSenderState()
+	m.set(library, new(big.Int), asm("PUSH1 7 PUSH1 0 SSTORE"), nil)
+	lib := library.Hex()[2:]
+	m.set(caller, new(big.Int), asm(
+		"PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 "+lib+" GAS DELEGATECALL PUSH1 0 MSTORE "+
+			"PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 "+lib+" GAS STATICCALL PUSH1 0x20 MSTORE "+
+			"PUSH1 0x40 PUSH1 0 LOG0"), nil)
+
+	// Deploys code logging slot 0, which its constructor sets to 42
+	runtime := asm("PUSH1 0 SLOAD PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 LOG0")
+	initCode := append(asm(fmt.Sprintf("PUSH1 0x2a PUSH1 0 SSTORE PUSH1 %x PUSH1 0x11 PUSH1 0 CODECOPY PUSH1 %x PUSH1 0 RETURN", len(runtime), len(runtime))), runtime...)
+	created := createAddress(testSender, 1) // after the first transaction
+
+	results, access := execute(t, m, testTx(&caller, nil, 0), testTx(nil, initCode, 0), testTx(&created, nil, 0))
+	// The static call into the writer fails, burning what it was passed
+	checkResult(t, results[0], 1, 985994)
+	if logWord(t, results[0], 0).Int64() != 1 || logWord(t, results[0], 1).Int64() != 0 {
+		t.Errorf("delegate and static calls logged %x", results[0].Logs[0].Data)
+	}
+	checkResult(t, results[1], 1, 77708)
+	checkResult(t, results[2], 1, 23749)
+	if logWord(t, results[2], 0).Int64() != 42 {
+		t.Errorf("created contract logged %x", results[2].Logs[0].Data)
+	}
+	for _, addr := range []Address{caller, created} {
+		if key := storageKey(addr.Hex(), Hash{}.Hex()); !access.Writes[key] {
+			t.Errorf("%s not written, access %+v", key, access)
+		}
+	}
+	if key := storageKey(library.Hex(), Hash{}.Hex()); access.Writes[key] {
+		t.Errorf("library's own storage written")
+	}
+}
+
+func TestExecuteSelfDestructInConstructor(t *testing.T) {
+	// A contract destroyed in the transaction that created it is deleted,
+	// its endowment going to the coinbase
+	m := newSenderState()
+	results, _ := execute(t, m, testTx(nil, asm("COINBASE SELFDESTRUCT"), 5))
+	checkResult(t, results[0], 1, 83036)
+	created := createAddress(testSender, 0)
+	want := []Transfer{{From: testSender, To: created, Value: big.NewInt(5)}, {From: created, To: testCoinbase, Value: big.NewInt(5)}}
+	if fmt.Sprint(results[0].Transfers) != fmt.Sprint(want) {
+		t.Errorf("transfers %v, want %v", results[0].Transfers, want)
+	}
+}
+
+func TestExecuteUnknownRecipient(t *testing.T) {
+	// No recipient without being known as a creation falls back, rather
+	// than deploying the input as init code
+	tx := testTx(nil, asm("PUSH1 0 PUSH1 0 RETURN"), 0)
+	tx.Create = false
+	l := &LocalEVM{State: newSenderState(), ChainID: 80094, Prague: true}
+	if _, _, err := l.Execute(context.Background(), testCoinbase, []*Transaction{tx}); !errors.Is(err, errUnsupported) {
+		t.Errorf("executed as %v", err)
+	}
+}
+
+func TestExecutePrecompiles(t *testing.T) {
+	// Static-calls the precompile in the first word of calldata with the
+	// rest, logging its output with the call's success as topic
+	proxy := testContract(1)
+	m := newSenderState()
+	m.set(proxy, new(big.Int), asm(
+		"PUSH1 0x20 CALLDATASIZE SUB DUP1 PUSH1 0x20 PUSH1 0 CALLDATACOPY "+
+			"PUSH1 0 PUSH1 0 DUP3 PUSH1 0 PUSH1 0 CALLDATALOAD GAS STATICCALL "+
+			"RETURNDATASIZE PUSH1 0 PUSH1 0 RETURNDATACOPY RETURNDATASIZE PUSH1 0 LOG1"), nil)
+
+	key, _ := ParseSigningKey("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	digest := Keccak256([]byte("hello"))
+	r, s, recID := key.Sign(digest)
+	signer := key.Address()
+	sig := append(append(append(digest[:], word(fmt.Sprintf("%x", 27+recID))...), word(r.Text(16))...), word(s.Text(16))...)
+	highS := append(append(append(digest[:], word(fmt.Sprintf("%x", 28-recID))...), word(r.Text(16))...), word(new(big.Int).Sub(secpN, s).Text(16))...)
+
+	// EIP-152's fifth vector: the final block of BLAKE2b-512 of "abc"
+	blake := binary.BigEndian.AppendUint32(nil, 12)
+	for i, h := range blake2bIV {
+		if i == 0 {
+			h ^= 0x01010040
+		}
+		blake = binary.LittleEndian.AppendUint64(blake, h)
+	}
+	blake = append(blake, rightPad([]byte("abc"), 128)...)
+	blake = binary.LittleEndian.AppendUint64(blake, 3)
+	blake = append(blake, make([]byte, 8)...)
+	blake = append(blake, 1)
+	modexp := append(append(append(word("1"), word("1")...), word("1")...), 3, 5, 7)
+
+	tests := []struct {
+		name  string
+		addr  byte
+		input []byte
+		want  string
+	}{
+		{"ecrecover", 1, sig, "000000000000000000000000" + hex.EncodeToString(signer[:])},
+		{"ecrecover high s", 1, highS, "000000000000000000000000" + hex.EncodeToString(signer[:])},
+		{"ecrecover invalid", 1, append(digest[:], word("1b")...), ""},
+		{"sha256", 2, []byte("abc"), "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"ripemd160", 3, []byte("abc"), "0000000000000000000000008eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"identity", 4, []byte("abc"), "616263"},
+		{"modexp", 5, modexp, "05"}, // 3^5 % 7
+		{"modexp empty", 5, append(append(word("0"), word(strings.Repeat("ff", 32))...), word("0")...), ""},
+		{"blake2f", 9, blake, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := append(word(fmt.Sprintf("%x", tt.addr)), tt.input...)
+			results, _ := execute(t, m, testTx(&proxy, input, 0))
+			res := results[0]
+			if res.Status != 1 || len(res.Logs) != 1 || res.Logs[0].Topics[0] != (Hash{31: 1}) {
+				t.Fatalf("result %+v", res)
+			}
+			if got := hex.EncodeToString(res.Logs[0].Data); got != tt.want {
+				t.Errorf("output %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// The alt_bn128 ones aren't implemented, nor is BLOCKHASH past the
+	// parent's parent, so the caller falls back
+	old := testContract(2)
+	m.set(old, new(big.Int), asm("PUSH1 1 BLOCKHASH"), nil)
+	l := &LocalEVM{State: m, ChainID: 80094, Prague: true}
+	for _, input := range [][]byte{word("6"), word(old.Hex())} {
+		_, _, err := l.Execute(context.Background(), testCoinbase, []*Transaction{testTx(&proxy, input, 0)})
+		if !errors.Is(err, errUnsupported) {
+			t.Errorf("call to %x ran locally: %v", input[12:], err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"math/big"
+)
+
+// EmptyCodeHash is the code hash of an account without code
+var EmptyCodeHash = Keccak256()
+
+// stateReader is the state local execution starts from, as of a head;
+// StateCache serves it from the node
+type stateReader interface {
+	Head() *Header
+	Account(ctx context.Context, addr Address) (*AccountState, error)
+	Storage(ctx context.Context, addr Address, slot Hash) (Hash, error)
+	Code(ctx context.Context, addr Address) ([]byte, error)
+}
+
+// Transfer is native value moved from one account to another during
+// execution, by the transaction itself or a call, create or self-destruct
+// it makes
+type Transfer struct {
+	From, To Address
+	Value    *big.Int
+}
+
+// evmAccount is an account as execution has left it so far
+type evmAccount struct {
+	nonce     uint64
+	balance   *big.Int
+	codeHash  Hash
+	code      []byte
+	codeRead  bool          // code has been read or set
+	fresh     bool          // storage started out empty
+	pre       map[Hash]Hash // slot values before execution, as read
+	committed map[Hash]Hash // values left by earlier transactions of the run
+	dirty     map[Hash]Hash // values written by the current transaction
+}
+
+func (a *evmAccount) empty() bool {
+	return a.nonce == 0 && a.balance.Sign() == 0 && a.codeHash == EmptyCodeHash
+}
+
+type slotKey struct {
+	addr Address
+	slot Hash
+}
+
+// evmState is the state a run of transactions executes against: reads
+// fall through to a stateReader, and writes stay in the overlay, journaled
+// so that a failed call can be undone. The first read that fails is kept
+// in err and reads as zero from then on; the run's results are then not
+// to be trusted.
+type evmState struct {
+	ctx      context.Context
+	reader   stateReader
+	err      error
+	accounts map[Address]*evmAccount
+
+	// Per transaction, journaled
+	journal   []func()
+	warmAddrs map[Address]bool
+	warmSlots map[slotKey]bool
+	transient map[slotKey]Hash
+	created   map[Address]bool // by this transaction, so it may self-destruct (EIP-6780)
+	destructs map[Address]bool
+	refund    uint64
+	logs      []Log
+	transfers []Transfer
+
+	// Over the whole run, not journaled: every slot read or written, as a
+	// tracer sees it, reverted or not
+	touched map[slotKey]bool
+}
+
+func newEVMState(ctx context.Context, reader stateReader) *evmState {
+	s := &evmState{ctx: ctx, reader: reader, accounts: make(map[Address]*evmAccount), touched: make(map[slotKey]bool)}
+	s.reset()
+	return s
+}
+
+// reset clears what lasts only a transaction
+func (s *evmState) reset() {
+	s.journal = nil
+	s.warmAddrs = make(map[Address]bool)
+	s.warmSlots = make(map[slotKey]bool)
+	s.transient = make(map[slotKey]Hash)
+	s.created = make(map[Address]bool)
+	s.destructs = make(map[Address]bool)
+	s.refund = 0
+	s.logs = nil
+	s.transfers = nil
+}
+
+// fail records the first error reading state
+func (s *evmState) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *evmState) snapshot() int { return len(s.journal) }
+
+// revertTo undoes every change made since snapshot id
+func (s *evmState) revertTo(id int) {
+	for i := len(s.journal) - 1; i >= id; i-- {
+		s.journal[i]()
+	}
+	s.journal = s.journal[:id]
+}
+
+func (s *evmState) record(undo func()) { s.journal = append(s.journal, undo) }
+
+// account returns addr's account, reading it on first use
+func (s *evmState) account(addr Address) *evmAccount {
+	if a := s.accounts[addr]; a != nil {
+		return a
+	}
+	a := &evmAccount{balance: new(big.Int), codeHash: EmptyCodeHash, fresh: true, committed: map[Hash]Hash{}, dirty: map[Hash]Hash{}, pre: map[Hash]Hash{}}
+	if s.err == nil {
+		st, err := s.reader.Account(s.ctx, addr)
+		if err != nil {
+			s.fail(err)
+		} else {
+			a.nonce, a.balance = st.Nonce, new(big.Int).Set(st.Balance)
+			if st.CodeHash != (Hash{}) {
+				a.codeHash = st.CodeHash
+			}
+			a.fresh = st.StorageHash == (Hash{}) || st.StorageHash == EmptyRootHash
+		}
+	}
+	s.accounts[addr] = a
+	return a
+}
+
+func (s *evmState) exists(addr Address) bool { return !s.account(addr).empty() }
+
+func (s *evmState) balance(addr Address) *big.Int { return s.account(addr).balance }
+
+func (s *evmState) nonce(addr Address) uint64 { return s.account(addr).nonce }
+
+func (s *evmState) setNonce(addr Address, nonce uint64) {
+	a := s.account(addr)
+	prev := a.nonce
+	s.record(func() { a.nonce = prev })
+	a.nonce = nonce
+}
+
+func (s *evmState) addBalance(addr Address, v *big.Int) {
+	a := s.account(addr)
+	prev := a.balance
+	s.record(func() { a.balance = prev })
+	a.balance = new(big.Int).Add(prev, v)
+}
+
+func (s *evmState) subBalance(addr Address, v *big.Int) {
+	s.addBalance(addr, new(big.Int).Neg(v))
+}
+
+// transfer moves v from one account to another and records it
+func (s *evmState) transfer(from, to Address, v *big.Int) {
+	if v.Sign() == 0 {
+		return
+	}
+	s.subBalance(from, v)
+	s.addBalance(to, v)
+	if from != to {
+		n := len(s.transfers)
+		s.record(func() { s.transfers = s.transfers[:n] })
+		s.transfers = append(s.transfers, Transfer{From: from, To: to, Value: new(big.Int).Set(v)})
+	}
+}
+
+func (s *evmState) code(addr Address) []byte {
+	a := s.account(addr)
+	if !a.codeRead {
+		a.codeRead = true
+		if a.codeHash != EmptyCodeHash && s.err == nil {
+			code, err := s.reader.Code(s.ctx, addr)
+			if err != nil {
+				s.fail(err)
+			}
+			a.code = code
+		}
+	}
+	return a.code
+}
+
+func (s *evmState) setCode(addr Address, code []byte) {
+	a := s.account(addr)
+	prevHash, prevCode, prevRead := a.codeHash, a.code, a.codeRead
+	s.record(func() { a.codeHash, a.code, a.codeRead = prevHash, prevCode, prevRead })
+	a.codeHash, a.code, a.codeRead = Keccak256(code), code, true
+}
+
+// codeHash returns addr's code hash, or zero if it doesn't exist
+func (s *evmState) codeHash(addr Address) Hash {
+	if !s.exists(addr) {
+		return Hash{}
+	}
+	return s.account(addr).codeHash
+}
+
+// original returns slot's value as of the start of the current transaction
+func (s *evmState) original(addr Address, slot Hash) Hash {
+	a := s.account(addr)
+	s.touched[slotKey{addr, slot}] = true
+	if v, ok := a.committed[slot]; ok {
+		return v
+	}
+	if a.fresh {
+		return Hash{}
+	}
+	if v, ok := a.pre[slot]; ok {
+		return v
+	}
+	var v Hash
+	if s.err == nil {
+		var err error
+		if v, err = s.reader.Storage(s.ctx, addr, slot); err != nil {
+			s.fail(err)
+		}
+	}
+	a.pre[slot] = v
+	return v
+}
+
+// storage returns slot's current value
+func (s *evmState) storage(addr Address, slot Hash) Hash {
+	if v, ok := s.account(addr).dirty[slot]; ok {
+		s.touched[slotKey{addr, slot}] = true
+		return v
+	}
+	return s.original(addr, slot)
+}
+
+func (s *evmState) setStorage(addr Address, slot, v Hash) {
+	a := s.account(addr)
+	s.touched[slotKey{addr, slot}] = true
+	prev, had := a.dirty[slot]
+	s.record(func() {
+		if had {
+			a.dirty[slot] = prev
+		} else {
+			delete(a.dirty, slot)
+		}
+	})
+	a.dirty[slot] = v
+}
+
+func (s *evmState) transientStorage(addr Address, slot Hash) Hash {
+	return s.transient[slotKey{addr, slot}]
+}
+
+func (s *evmState) setTransientStorage(addr Address, slot, v Hash) {
+	key := slotKey{addr, slot}
+	prev := s.transient[key]
+	s.record(func() { s.transient[key] = prev })
+	s.transient[key] = v
+}
+
+// createAccount starts a contract at addr, keeping any balance it has
+func (s *evmState) createAccount(addr Address) {
+	a := s.account(addr)
+	prev := *a
+	s.record(func() {
+		*a = prev
+		delete(s.created, addr)
+	})
+	a.nonce, a.fresh, a.committed, a.dirty = 1, true, map[Hash]Hash{}, map[Hash]Hash{}
+	s.created[addr] = true
+}
+
+// selfDestruct marks addr, which must have been created by the current
+// transaction, to be deleted at its end
+func (s *evmState) selfDestruct(addr Address) {
+	s.record(func() { delete(s.destructs, addr) })
+	s.destructs[addr] = true
+}
+
+func (s *evmState) addressWarm(addr Address) bool { return s.warmAddrs[addr] }
+
+func (s *evmState) warmAddress(addr Address) {
+	if s.warmAddrs[addr] {
+		return
+	}
+	s.record(func() { delete(s.warmAddrs, addr) })
+	s.warmAddrs[addr] = true
+}
+
+func (s *evmState) slotWarm(addr Address, slot Hash) bool { return s.warmSlots[slotKey{addr, slot}] }
+
+func (s *evmState) warmSlot(addr Address, slot Hash) {
+	key := slotKey{addr, slot}
+	if s.warmSlots[key] {
+		return
+	}
+	s.record(func() { delete(s.warmSlots, key) })
+	s.warmSlots[key] = true
+}
+
+func (s *evmState) addRefund(gas uint64) {
+	prev := s.refund
+	s.record(func() { s.refund = prev })
+	s.refund += gas
+}
+
+func (s *evmState) subRefund(gas uint64) {
+	prev := s.refund
+	s.record(func() { s.refund = prev })
+	s.refund -= gas
+}
+
+func (s *evmState) addLog(l Log) {
+	n := len(s.logs)
+	s.record(func() { s.logs = s.logs[:n] })
+	s.logs = append(s.logs, l)
+}
+
+// finish ends the current transaction: self-destructed accounts are
+// deleted, written slots become its successors' originals, and what lasts
+// only a transaction is cleared
+func (s *evmState) finish() {
+	for addr := range s.destructs {
+		a := s.account(addr)
+		a.nonce, a.balance, a.fresh = 0, new(big.Int), true
+		a.codeHash, a.code, a.codeRead = EmptyCodeHash, nil, true
+		a.committed, a.dirty = map[Hash]Hash{}, map[Hash]Hash{}
+	}
+	for _, a := range s.accounts {
+		for slot, v := range a.dirty {
+			a.committed[slot] = v
+		}
+		clear(a.dirty)
+	}
+	s.reset()
+}
+
+// access returns the storage the run read and wrote, written meaning left
+// with a value other than it started with
+func (s *evmState) access() *AccessSet {
+	set := &AccessSet{Reads: map[string]bool{}, Writes: map[string]bool{}}
+	for key := range s.touched {
+		k := storageKey(key.addr.Hex(), key.slot.Hex())
+		set.Reads[k] = true
+		a := s.accounts[key.addr]
+		if s.storage(key.addr, key.slot) != a.pre[key.slot] {
+			set.Writes[k] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// ExactConfig bounds the exact conflict solver run by the "wis" strategy
+type ExactConfig struct {
+	MaxComponent int      `json:"max_component"` // largest conflict component solved exactly; bigger ones are solved greedily
+	Budget       Duration `json:"budget"`        // wall-clock limit on exact solving per build; 0 is unlimited
+
+	stopAfter int // replay: stop after this many search nodes, as the recorded run did
+}
+
+// DefaultExactConfig is used unless builder.exact says otherwise
+func DefaultExactConfig() ExactConfig {
+	return ExactConfig{MaxComponent: 64, Budget: Duration(50 * time.Millisecond)}
+}
+
+// ExactResult reports how the exact solver did over a "wis" build
+type ExactResult struct {
+	Components  int // conflict components of more than one transaction
+	Exact       int // of those, the ones solved to a proven optimum
+	Nodes       int // search nodes explored
+	BudgetSpent bool
+}
+
+// exactSearch solves the conflict components of one build by branch and
+// bound, sharing one time budget between them. Each search starts from the
+// greedy set, so a component the budget runs out on keeps the best set
+// found so far, never worse than greedy, and the components after it are
+// solved greedily.
+type exactSearch struct {
+	cfg      ExactConfig
+	deadline time.Time // zero if unlimited
+	res      ExactResult
+}
+
+func newExactSearch(cfg ExactConfig) *exactSearch {
+	s := &exactSearch{cfg: cfg}
+	if cfg.Budget > 0 {
+		s.deadline = time.Now().Add(time.Duration(cfg.Budget))
+	}
+	return s
+}
+
+// stopped reports whether the budget is spent, reading the clock every
+// 1024 nodes
+func (s *exactSearch) stopped() bool {
+	if !s.res.BudgetSpent {
+		s.res.BudgetSpent = s.cfg.stopAfter > 0 && s.res.Nodes >= s.cfg.stopAfter ||
+			s.res.Nodes%1024 == 0 && !s.deadline.IsZero() && time.Now().After(s.deadline)
+	}
+	return s.res.BudgetSpent
+}
+
+// independentSet returns the maximum-score set of mutually non-conflicting
+// transactions within comp, or the best found when the budget ran out.
+// Transactions are tried in descending score order, and a branch is pruned
+// once its score plus that of every later transaction it doesn't yet
+// conflict with can't beat the best set so far.
+func (s *exactSearch) independentSet(g *ConflictGraph, comp []*Transaction) []*Transaction {
+	if len(comp) <= 1 {
+		return comp
+	}
+	s.res.Components++
+	heuristic := g.greedySet(comp)
+	if len(comp) > s.cfg.MaxComponent || s.stopped() {
+		return heuristic
+	}
+
+	comp = slices.Clone(comp)
+	slices.SortStableFunc(comp, func(a, b *Transaction) int { return cmpScore(b, a) })
+	n, words := len(comp), (len(comp)+63)/64
+	index := make(map[string]int, n)
+	for i, tx := range comp {
+		index[tx.Hash] = i
+	}
+	masks := make([][]uint64, n)
+	for i, tx := range comp {
+		masks[i] = make([]uint64, words)
+		for id := range g.adj[tx.Hash] {
+			if j, ok := index[id]; ok {
+				masks[i][j/64] |= 1 << (j % 64)
+			}
+		}
+	}
+
+	best := make([]uint64, words)
+	bestScore := int64(0)
+	for _, tx := range heuristic {
+		i := index[tx.Hash]
+		best[i/64] |= 1 << (i % 64)
+		bestScore += tx.Score()
+	}
+	set := make([]uint64, words)
+	blocked := make([][]uint64, n+1) // per depth, so backtracking is free
+	for i := range blocked {
+		blocked[i] = make([]uint64, words)
+	}
+	isSet := func(b []uint64, i int) bool { return b[i/64]&(1<<(i%64)) != 0 }
+
+	var search func(i int, score int64)
+	search = func(i int, score int64) {
+		if s.stopped() {
+			return
+		}
+		s.res.Nodes++
+		bound := score
+		for j := i; j < n; j++ {
+			if !isSet(blocked[i], j) {
+				bound += max(comp[j].Score(), 0)
+			}
+		}
+		if bound <= bestScore {
+			return
+		}
+		if i == n {
+			copy(best, set)
+			bestScore = score
+			return
+		}
+		if !isSet(blocked[i], i) && comp[i].Score() > 0 {
+			for w := range blocked[i+1] {
+				blocked[i+1][w] = blocked[i][w] | masks[i][w]
+			}
+			set[i/64] |= 1 << (i % 64)
+			search(i+1, score+comp[i].Score())
+			set[i/64] &^= 1 << (i % 64)
+		}
+		copy(blocked[i+1], blocked[i])
+		search(i+1, score)
+	}
+	search(0, 0)
+	if !s.res.BudgetSpent {
+		s.res.Exact++
+	}
+
+	size := 0
+	for _, w := range best {
+		size += bits.OnesCount64(w)
+	}
+	out := make([]*Transaction, 0, size)
+	for i, tx := range comp {
+		if isSet(best, i) {
+			out = append(out, tx)
+		}
+	}
+	return out
+}
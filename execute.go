@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// executedCall is the part of an eth_simulateV1 call result execution uses
+type executedCall struct {
+	Status  HexUint64 `json:"status"`
+	GasUsed HexUint64 `json:"gasUsed"`
+	Logs    []Log     `json:"logs"`
+}
+
+// ExecutePayload runs t's transactions in block order with eth_simulateV1
+// on top of its parent, under the payload's number, timestamp, gas limit,
+// base fee, randomness, fee recipient and withdrawals, and returns each
+// transaction's outcome for AssembleBlock. Native transfers are traced to
+// work out the fee recipient's balance delta: the tips it earns on the gas
+// actually used plus what it is sent, less what it sends and pays in fees
+// itself, as when paying the proposer. Every transaction needs a known
+// sender.
+func ExecutePayload(ctx context.Context, rpc *RPCClient, t *BlockTemplate) (results []TxResult, delta int64, err error) {
+	p := t.Payload
+	ctx, span := StartSpan(ctx, "payload.execute", "block.number", uint64(p.BlockNumber), "block.txs", len(t.Transactions))
+	defer func() {
+		span.SetAttr("payload.value", delta)
+		span.SetError(err)
+		span.End()
+	}()
+	if len(t.Transactions) != len(p.Transactions) {
+		return nil, 0, fmt.Errorf("have %d transactions for %d payload entries", len(t.Transactions), len(p.Transactions))
+	}
+
+	calls := make([]map[string]string, len(t.Transactions))
+	for i, tx := range t.Transactions {
+		if tx.From == "" {
+			return nil, 0, fmt.Errorf("transaction %s has no known sender", tx.Hash)
+		}
+		calls[i] = callObject(tx)
+		calls[i]["nonce"] = EncodeHexUint64(uint64(tx.Nonce))
+	}
+	withdrawals := make([]map[string]string, len(p.Withdrawals))
+	for i, w := range p.Withdrawals {
+		withdrawals[i] = map[string]string{
+			"index":          EncodeHexUint64(uint64(w.Index)),
+			"validatorIndex": EncodeHexUint64(uint64(w.ValidatorIndex)),
+			"address":        w.Address.Hex(),
+			"amount":         EncodeHexUint64(uint64(w.Amount)),
+		}
+	}
+	overrides := map[string]any{
+		"number":        EncodeHexUint64(uint64(p.BlockNumber)),
+		"time":          EncodeHexUint64(uint64(p.Timestamp)),
+		"gasLimit":      EncodeHexUint64(uint64(p.GasLimit)),
+		"feeRecipient":  p.FeeRecipient.Hex(),
+		"prevRandao":    p.PrevRandao.Hex(),
+		"baseFeePerGas": EncodeHexUint64(uint64(p.BaseFeePerGas)),
+		"withdrawals":   withdrawals,
+	}
+	if t.ParentBeaconRoot != nil {
+		overrides["beaconRoot"] = t.ParentBeaconRoot.Hex()
+	}
+	params := map[string]any{
+		"blockStateCalls": []any{map[string]any{"blockOverrides": overrides, "calls": calls}},
+		"traceTransfers":  true,
+	}
+
+	var blocks []struct {
+		Calls []executedCall `json:"calls"`
+	}
+	parent := EncodeHexUint64(uint64(p.BlockNumber) - 1)
+	if err := rpc.Call(ctx, &blocks, "eth_simulateV1", params, parent); err != nil {
+		return nil, 0, fmt.Errorf("executing block: %w", err)
+	}
+	if len(blocks) != 1 || len(blocks[0].Calls) != len(calls) {
+		return nil, 0, fmt.Errorf("executing block: got results for %d blocks, want 1 of %d calls", len(blocks), len(calls))
+	}
+
+	baseFee := int64(p.BaseFeePerGas)
+	results = make([]TxResult, len(calls))
+	for i, call := range blocks[0].Calls {
+		tx := t.Transactions[i]
+		gas := int64(call.GasUsed)
+		in, out := nativeTransfers(call.Logs, p.FeeRecipient)
+		delta += max(tx.EffectiveTip(baseFee), 0)*gas + in - out
+		if sender, err := HexToAddress(tx.From); err == nil && sender == p.FeeRecipient {
+			delta -= (baseFee + max(tx.EffectiveTip(baseFee), 0)) * gas
+		}
+		results[i] = TxResult{
+			GasUsed: gas,
+			Status:  uint64(call.Status),
+			Logs:    slices.DeleteFunc(call.Logs, func(l Log) bool { return isTransferLog(&l) }),
+		}
+	}
+	return results, delta, nil
+}
+
+// Executed settles t's value on the fee recipient's balance delta from
+// ExecutePayload. Without a proposer payment the delta is the value; with
+// one the value is the payment, and the delta is what the builder keeps
+// after making it, which must not be negative.
+func (t *BlockTemplate) Executed(delta int64) error {
+	if t.Payment == nil {
+		t.Value = delta
+		return nil
+	}
+	if delta < 0 {
+		return fmt.Errorf("block earns %s less than the %s paid to the proposer", FormatWei(-delta), FormatWei(t.Value))
+	}
+	return nil
+}
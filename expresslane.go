@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expressHistory is how many settled rounds are kept for lookup
+const expressHistory = 16
+
+// ExpressLane auctions a reserved top of the block by the round: round n
+// is the rolling window of wall-clock time from n·Round to (n+1)·Round.
+// Sealed bids for the next round are taken until it begins; then the
+// highest wins, the earlier on a tie, and pays the second-highest bid or
+// the reserve price, whichever is higher, so bidding its true value is a
+// bidder's best strategy. Throughout the round the winner's transactions,
+// those sent from the controller address it bid for, open every block
+// after the required ones and ahead of the lanes, in nonce order and up to
+// MaxGas. The price is owed for each block its transactions open, and is
+// credited to the first of them as a direct payment to the builder, so it
+// counts in the block's value. Its methods are safe for concurrent use.
+type ExpressLane struct {
+	Round   time.Duration
+	Reserve int64 // least bid accepted, in wei per block
+	MaxGas  int64 // gas the winner's transactions may take per block; 0 is unbounded
+
+	mu     sync.Mutex
+	bids   map[int64][]ExpressBid  // by round, in arrival order
+	grants map[int64]*ExpressGrant // settled rounds; nil where nobody bid
+}
+
+// ExpressBid is a bid for the express lane of a round
+type ExpressBid struct {
+	Controller string `json:"controller"` // sender whose transactions take the lane
+	Round      int64  `json:"round"`      // 0 is the next round
+	Amount     int64  `json:"amount"`     // wei per block
+}
+
+// ExpressGrant is the outcome of a round's auction: who holds the lane and
+// what it pays
+type ExpressGrant struct {
+	Round      int64  `json:"round"`
+	Controller string `json:"controller"`
+	Price      int64  `json:"price"` // wei per block
+	MaxGas     int64  `json:"maxGas,omitempty"`
+	Bids       int    `json:"bids"`
+}
+
+// NewExpressLane returns an auction configured from cfg, or nil if the
+// express lane is disabled
+func NewExpressLane(cfg ExpressLaneConfig) *ExpressLane {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ExpressLane{
+		Round:   time.Duration(cfg.Round),
+		Reserve: cfg.Reserve,
+		MaxGas:  cfg.MaxGas,
+		bids:    map[int64][]ExpressBid{},
+		grants:  map[int64]*ExpressGrant{},
+	}
+}
+
+// RoundAt returns the round t falls in
+func (e *ExpressLane) RoundAt(t time.Time) int64 {
+	return t.UnixNano() / int64(e.Round)
+}
+
+// Bid enters b into the auction for the round after the one now falls in,
+// replacing the controller's earlier bid for it, and returns that round
+func (e *ExpressLane) Bid(b ExpressBid, now time.Time) (int64, error) {
+	addr, err := HexToAddress(b.Controller)
+	if err != nil {
+		return 0, fmt.Errorf("controller: %w", err)
+	}
+	next := e.RoundAt(now) + 1
+	if b.Round == 0 {
+		b.Round = next
+	}
+	if b.Round != next {
+		return 0, fmt.Errorf("round %d is not open for bids; round %d is", b.Round, next)
+	}
+	if b.Amount < max(e.Reserve, 1) {
+		return 0, fmt.Errorf("bid of %d wei is below the reserve of %d", b.Amount, e.Reserve)
+	}
+	b.Controller = strings.ToLower(addr.Hex())
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	bids := slices.DeleteFunc(e.bids[b.Round], func(o ExpressBid) bool { return o.Controller == b.Controller })
+	e.bids[b.Round] = append(bids, b)
+	return b.Round, nil
+}
+
+// Grant returns the holder of the lane at now, settling its round's
+// auction the first time, or nil if nobody bid for the round
+func (e *ExpressLane) Grant(now time.Time) *ExpressGrant {
+	round := e.RoundAt(now)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if g, ok := e.grants[round]; ok {
+		return g
+	}
+	bids := e.bids[round]
+	var g *ExpressGrant
+	if len(bids) > 0 {
+		// Stable, so the earlier of two equal bids wins
+		slices.SortStableFunc(bids, func(x, y ExpressBid) int { return cmp.Compare(y.Amount, x.Amount) })
+		price := e.Reserve
+		if len(bids) > 1 {
+			price = max(price, bids[1].Amount)
+		}
+		g = &ExpressGrant{Round: round, Controller: bids[0].Controller, Price: price, MaxGas: e.MaxGas, Bids: len(bids)}
+	}
+	e.grants[round] = g
+	for r := range e.bids {
+		if r <= round {
+			delete(e.bids, r)
+		}
+	}
+	for r := range e.grants {
+		if r <= round-expressHistory {
+			delete(e.grants, r)
+		}
+	}
+	return g
+}
+
+// ExpressStatus is the auction's state as served at /express
+type ExpressStatus struct {
+	Round     int64         `json:"round"`
+	EndsAt    time.Time     `json:"endsAt"`
+	Holder    *ExpressGrant `json:"holder"`   // of the current round; null if nobody won it
+	NextBids  int           `json:"nextBids"` // sealed bids for the next round so far
+	Reserve   int64         `json:"reserve"`  // least bid accepted, in wei per block
+	RoundSecs float64       `json:"roundSeconds"`
+}
+
+// Status returns the auction's state at now
+func (e *ExpressLane) Status(now time.Time) ExpressStatus {
+	holder := e.Grant(now)
+	round := e.RoundAt(now)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return ExpressStatus{
+		Round:     round,
+		EndsAt:    time.Unix(0, (round+1)*int64(e.Round)).UTC(),
+		Holder:    holder,
+		NextBids:  len(e.bids[round+1]),
+		Reserve:   e.Reserve,
+		RoundSecs: e.Round.Seconds(),
+	}
+}
+
+// fillExpress picks the transactions of the express lane's holder that
+// open the block, within gasLimit: its pooled executable ones in nonce
+// order, up to the first that doesn't fit or conflicts with those in used,
+// as skipping it would leave a nonce gap. The first is a copy crediting
+// the lane's price.
+func (p *TxPool) fillExpress(gasLimit int64, used map[string]bool, graph *ConflictGraph) []*Transaction {
+	g := p.Express
+	if g == nil {
+		return nil
+	}
+	var candidates []*Transaction
+	for hash, tx := range p.AllTxs {
+		if _, parked := p.Queued[hash]; !parked && !used[hash] && strings.EqualFold(tx.From, g.Controller) {
+			candidates = append(candidates, tx)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b *Transaction) int {
+		return cmp.Or(cmp.Compare(a.Nonce, b.Nonce), strings.Compare(a.Hash, b.Hash))
+	})
+	var selected []*Transaction
+	gas := int64(0)
+	for _, tx := range candidates {
+		if gas+tx.GasLimit > gasLimit || g.MaxGas > 0 && gas+tx.GasLimit > g.MaxGas || graph.Conflicts(tx.Hash, used) {
+			break
+		}
+		if len(selected) == 0 {
+			credited := *tx
+			credited.MEVBonus += g.Price
+			tx = &credited
+		}
+		gas += tx.PackGas()
+		selected = append(selected, tx)
+	}
+	return selected
+}
+
+// inExpress reports whether tx belongs to the express lane's holder
+func (p *TxPool) inExpress(tx *Transaction) bool {
+	return p.Express != nil && strings.EqualFold(tx.From, p.Express.Controller)
+}
+
+// handleExpress returns the auction's state
+func (s *APIServer) handleExpress(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, s.Express.Status(time.Now()))
+}
+
+// handleExpressBid enters a JSON ExpressBid into the auction for the next
+// round
+func (s *APIServer) handleExpressBid(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var bid ExpressBid
+	if err := json.Unmarshal(body, &bid); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	round, err := s.Express.Bid(bid, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeHTTPJSON(w, map[string]int64{"round": round})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"time"
+)
+
+// FairConfig tunes the "fair" strategy
+type FairConfig struct {
+	Bucket Duration `json:"bucket"` // arrivals within one bucket compete on profit
+}
+
+// DefaultFairConfig is used unless builder.fair says otherwise
+func DefaultFairConfig() FairConfig {
+	return FairConfig{Bucket: Duration(100 * time.Millisecond)}
+}
+
+// selectFCFS opens the block like selectGreedy, then packs in order of
+// arrival rather than profit, for operators who want latency-fair
+// ordering: each transaction or bundle is taken in the order the pool
+// stamped it (see Transaction.Seq), skipping any that doesn't fit the gas
+// left, conflicts with one taken or is over its quota, then the
+// deprioritized ones likewise. A transaction that arrived before its
+// sender's lower nonces waits for them and follows the last, and is left
+// out if any of them is. Matched bundles have no arrival and come after
+// the rest.
+func (p *TxPool) selectFCFS(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	return p.packInOrder(ctx, gasLimit, best, sortByArrival)
+}
+
+// selectFair is the hybrid of selectFCFS and selectGreedy: arrivals are
+// batched into buckets of Fair.Bucket by the time they were received (see
+// Transaction.SeenAt), and the buckets packed first come, first served,
+// each in the pool's Ranking order. A transaction can only be outbid by
+// one received in the same bucket, so a searcher gains nothing by being
+// faster than the bucket or paying for a later slot.
+func (p *TxPool) selectFair(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	bucket, rank := time.Duration(p.Fair.Bucket), p.rank()
+	return p.packInOrder(ctx, gasLimit, best, func(txs []*Transaction) {
+		slices.SortFunc(txs, func(a, b *Transaction) int {
+			return cmp.Or(cmp.Compare(arrivalBucket(a, bucket), arrivalBucket(b, bucket)), rank(b, a))
+		})
+	})
+}
+
+// arrivalBucket returns the bucket tx was received in, unknown last
+func arrivalBucket(tx *Transaction, bucket time.Duration) int64 {
+	if tx.SeenAt.IsZero() {
+		return math.MaxInt64
+	}
+	return tx.SeenAt.UnixNano() / int64(max(bucket, 1))
+}
+
+// packInOrder opens the block, then packs the queued and the deprioritized
+// candidates each in the order sort puts them, as selectFCFS describes
+func (p *TxPool) packInOrder(ctx context.Context, gasLimit int64, best *BestBlock, sort func([]*Transaction)) ([]*Transaction, error) {
+	queued, low := slices.Clone(p.Heap.TxHeap), slices.Clone(p.Low.TxHeap)
+	merged, sandwiches := p.matchedBundles()
+	queued = append(queued, merged...)
+	low = append(low, sandwiches...)
+	graph := p.conflictGraph(slices.Concat(queued[p.Heap.Len():], low[p.Low.Len():])...)
+
+	selected, usedGas, used, err := p.openBlock(gasLimit, graph)
+	if err != nil {
+		return nil, err
+	}
+	if best != nil {
+		best.Offer(selected)
+	}
+
+	chains := p.nonceChains(selected)
+	var take func(tx *Transaction)
+	take = func(tx *Transaction) {
+		if used[tx.Hash] || graph.Conflicts(tx.Hash, used) || usedGas+tx.GasLimit > gasLimit || graph.OverQuota(tx, used, gasLimit) {
+			chains.skip(tx)
+			return
+		}
+		if chains.wait(tx) {
+			return
+		}
+		usedGas += tx.PackGas()
+		used[tx.Hash] = true
+		selected = append(selected, tx)
+		if best != nil {
+			best.Offer(selected)
+		}
+		for _, t := range chains.taken(tx) {
+			take(t)
+		}
+	}
+	scan := startScan(ctx, len(queued)+len(low))
+	defer p.finishScan(scan)
+	for _, txs := range []TxHeap{queued, low} {
+		sort(txs)
+		for _, tx := range txs {
+			if usedGas >= gasLimit || !scan.next() {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			take(tx)
+		}
+	}
+	return selected, nil
+}
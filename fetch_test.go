@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cspannos/block-construction-engine-poc/mockrpc"
+)
+
+// mockNode serves txs from a mock node, returning it and a client for it
+// that retries without waiting long
+func mockNode(t *testing.T, txs []*Transaction) (*mockrpc.Server, *RPCClient) {
+	t.Helper()
+	server := mockrpc.New()
+	t.Cleanup(server.Close)
+	server.SetPending(mockTxs(txs))
+	server.NewHead(0)
+	rpc := NewRPCClient(server.URL)
+	rpc.Retry.BaseDelay = time.Millisecond
+	return server, rpc
+}
+
+func TestBuildFromMockNode(t *testing.T) {
+	ctx := context.Background()
+	txs := SyntheticMempool(40, 1)
+	server, rpc := mockNode(t, txs)
+
+	pool := NewTxPool()
+	if err := pool.FetchTransactions(ctx, rpc); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(pool.Txs()); got != len(txs) {
+		t.Fatalf("pooled %d of %d served", got, len(txs))
+	}
+	nonces, err := FetchNonces(ctx, rpc, pool.Txs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetNonces(nonces)
+
+	for _, strategy := range PackerNames() {
+		block, err := SelectWithStrategy(ctx, pool, strategy, mockrpc.DefaultGasLimit, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+		if len(block) == 0 {
+			t.Errorf("%s built an empty block", strategy)
+		}
+		if err := pool.ValidateBlock(mockrpc.DefaultGasLimit, block); err != nil {
+			t.Errorf("%s: %v", strategy, err)
+		}
+	}
+
+	// Mining the greedy block leaves the rest pending
+	block, err := SelectWithStrategy(ctx, pool, "greedy", mockrpc.DefaultGasLimit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var included []string
+	for _, tx := range block {
+		included = append(included, tx.Hash)
+	}
+	server.NewHead(0, included...)
+	removed, err := pool.SyncPending(ctx, rpc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != len(block) {
+		t.Errorf("sync removed %d, want the %d mined", removed, len(block))
+	}
+	for _, hash := range included {
+		if _, ok := pool.AllTxs[hash]; ok {
+			t.Errorf("mined %s still pooled", hash)
+		}
+	}
+}
+
+func TestFetchRetriesMockNodeFaults(t *testing.T) {
+	ctx := context.Background()
+	server, rpc := mockNode(t, SyntheticMempool(5, 1))
+	server.FailNextHTTP("eth_getBlockByNumber", 1, http.StatusServiceUnavailable)
+	server.FailNext("eth_getBlockByNumber", 1, &mockrpc.Error{Code: mockrpc.CodeServer, Message: "header not found"})
+
+	pool := NewTxPool()
+	if err := pool.FetchTransactions(ctx, rpc); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Calls("eth_getBlockByNumber"); got != 3 {
+		t.Errorf("%d calls, want 3", got)
+	}
+	if got := len(pool.Txs()); got != 5 {
+		t.Errorf("pooled %d, want 5", got)
+	}
+
+	rpc.Retry.MaxAttempts = 1
+	server.FailNextHTTP("eth_getBlockByNumber", 1, http.StatusBadGateway)
+	if err := pool.FetchTransactions(ctx, rpc); err == nil {
+		t.Error("fetch succeeded through a fault without retries")
+	}
+}
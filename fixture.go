@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fixtureColumns is the CSV header written and expected by fixture files.
+// conflictsWith holds hashes separated by ';'.
+var fixtureColumns = []string{"hash", "gasPrice", "gasLimit", "mevBonus", "polBonus", "nonce", "conflictsWith", "from", "to"}
+
+// LoadFixture reads transactions from a .json (array of transactions, as
+// written by the fetch command), .csv or .pb (builder.v1.TransactionList)
+// fixture file
+func LoadFixture(path string) ([]*Transaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var txs []*Transaction
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&txs)
+	case ".csv":
+		txs, err = readFixtureCSV(f)
+	case ".pb":
+		var data []byte
+		if data, err = io.ReadAll(f); err == nil {
+			txs, err = UnmarshalTransactionList(data)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported fixture format (want .json, .csv or .pb)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return txs, nil
+}
+
+// SaveFixture writes transactions to a .json, .csv or .pb fixture file
+func SaveFixture(path string, txs []*Transaction) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return writeJSON(path, txs)
+	case ".pb":
+		return os.WriteFile(path, MarshalTransactionList(txs), 0o644)
+	case ".csv":
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := writeFixtureCSV(f, txs); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return fmt.Errorf("%s: unsupported fixture format (want .json, .csv or .pb)", path)
+}
+
+func readFixtureCSV(r io.Reader) ([]*Transaction, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range fixtureColumns[:3] {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("missing required column %q", name)
+		}
+	}
+
+	var txs []*Transaction
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return txs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		num := func(name string) (int64, error) {
+			s := field(name)
+			if s == "" {
+				return 0, nil
+			}
+			v, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %s: %w", line, name, err)
+			}
+			return v, nil
+		}
+
+		tx := &Transaction{Hash: field("hash"), From: strings.ToLower(field("from")), To: strings.ToLower(field("to")), ConflictsWith: []string{}}
+		if tx.Hash == "" {
+			return nil, fmt.Errorf("line %d: empty hash", line)
+		}
+		if tx.GasPrice, err = num("gasPrice"); err != nil {
+			return nil, err
+		}
+		if tx.GasLimit, err = num("gasLimit"); err != nil {
+			return nil, err
+		}
+		if tx.MEVBonus, err = num("mevBonus"); err != nil {
+			return nil, err
+		}
+		if tx.PoLBonus, err = num("polBonus"); err != nil {
+			return nil, err
+		}
+		nonce, err := num("nonce")
+		if err != nil {
+			return nil, err
+		}
+		tx.Nonce = int(nonce)
+		if c := field("conflictsWith"); c != "" {
+			tx.ConflictsWith = strings.Split(c, ";")
+		}
+		txs = append(txs, tx)
+	}
+}
+
+func writeFixtureCSV(w io.Writer, txs []*Transaction) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fixtureColumns); err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		rec := []string{
+			tx.Hash,
+			strconv.FormatInt(tx.GasPrice, 10),
+			strconv.FormatInt(tx.GasLimit, 10),
+			strconv.FormatInt(tx.MEVBonus, 10),
+			strconv.FormatInt(tx.PoLBonus, 10),
+			strconv.Itoa(tx.Nonce),
+			strings.Join(tx.ConflictsWith, ";"),
+			tx.From,
+			tx.To,
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SyntheticMempool generates n plausible transactions deterministically from
+// seed: mostly transfers and swaps, a few large contract calls, occasional
+// MEV and PoL bonuses, and sparse conflicts between them.
+func SyntheticMempool(n int, seed uint64) []*Transaction {
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	const gwei = int64(1e9)
+	txs := make([]*Transaction, 0, n)
+	nonces := map[uint32]int{}
+	for i := 0; i < n; i++ {
+		var hash [32]byte
+		for j := range hash {
+			hash[j] = byte(rng.UintN(256))
+		}
+		// Spread transactions over n/8 senders without drawing from rng, so
+		// seeds keep producing the same fees and gas limits; each sender's
+		// nonces run from 0 without gaps
+		sender := binary.BigEndian.Uint32(hash[:4]) % uint32(max(n/8, 1))
+		tx := &Transaction{
+			Hash:          EncodeHexBytes(hash[:]),
+			From:          fmt.Sprintf("0x%040x", 0x5e4d+sender),
+			To:            EncodeHexBytes(hash[12:]), // calls, not creations, which need more intrinsic gas
+			GasPrice:      gwei + rng.Int64N(100*gwei),
+			Nonce:         nonces[sender],
+			ConflictsWith: []string{},
+		}
+		nonces[sender]++
+		switch r := rng.IntN(100); {
+		case r < 50:
+			tx.GasLimit = 21000
+		case r < 95:
+			tx.GasLimit = 50000 + rng.Int64N(450000)
+		default:
+			tx.GasLimit = 1000000 + rng.Int64N(4000000)
+		}
+		if rng.IntN(10) == 0 {
+			tx.MEVBonus = rng.Int64N(int64(1e16))
+		}
+		if rng.IntN(5) == 0 {
+			tx.PoLBonus = rng.Int64N(int64(1e15))
+		}
+		if len(txs) > 0 && rng.IntN(20) == 0 {
+			tx.ConflictsWith = append(tx.ConflictsWith, txs[rng.IntN(len(txs))].Hash)
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// gasRatioAlpha weights the newest sample in a contract's moving average
+const gasRatioAlpha = 0.2
+
+// txBaseGas is the least gas any transaction uses
+const txBaseGas = 21000
+
+// maxRefundQuotient caps a transaction's gas refund at its gas used over
+// this, since London (EIP-3529)
+const maxRefundQuotient = 5
+
+// GasEstimator predicts how much of its gas limit a transaction will use,
+// from the gasUsed/gas ratios of mined transactions to the same contract,
+// so blocks are packed on expected consumption rather than limits. The
+// EVM only requires each transaction's gas limit to fit in what the block
+// has left after the gas actually used before it, and that is the rule the
+// packers apply: a transaction fits if its full limit fits, then counts
+// only its estimate. Its methods are safe for concurrent use.
+//
+// With SimulateTxs set, the most profitable pooled transactions are also
+// traced on the pending block (see Simulate), and a transaction's own
+// simulated charge, refunds applied, takes precedence over its contract's
+// average.
+type GasEstimator struct {
+	Margin      float64 // added to every estimate, as a fraction of it
+	MinSamples  int     // mined transactions needed before a contract is estimated
+	SimulateTxs int     // most profitable transactions simulated per build; 0 simulates none
+
+	mu        sync.Mutex
+	ratios    map[string]*gasRatio // by lower-case recipient; "" for creations
+	learned   uint64               // last block learned from
+	simulated map[string]int64     // hash -> gas charged in simulation, refunds applied
+
+	// Accuracy against mined blocks of the transactions last packed
+	packed    map[string]int64 // hash -> estimate of the last candidate
+	estimated int64
+	realized  int64
+	matched   int
+}
+
+type gasRatio struct {
+	mean float64
+	n    int
+}
+
+// NewGasEstimator returns an estimator configured from cfg, or nil if
+// estimation is disabled
+func NewGasEstimator(cfg GasEstimateConfig) *GasEstimator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &GasEstimator{
+		Margin:      cfg.Margin,
+		MinSamples:  cfg.MinSamples,
+		SimulateTxs: cfg.SimulateTxs,
+		ratios:      make(map[string]*gasRatio),
+		simulated:   make(map[string]int64),
+	}
+}
+
+// Estimate returns the gas tx is expected to use, margin included: what it
+// was charged in simulation, else its contract's average ratio of its gas
+// limit, or its gas limit if neither is known
+func (e *GasEstimator) Estimate(tx *Transaction) int64 {
+	e.mu.Lock()
+	var r gasRatio
+	if known := e.ratios[strings.ToLower(tx.To)]; known != nil {
+		r = *known
+	}
+	charged, simulated := e.simulated[tx.Hash]
+	e.mu.Unlock()
+	var est int64
+	switch {
+	case simulated:
+		est = int64(math.Ceil(float64(charged) * (1 + e.Margin)))
+	case r.n == 0 || r.n < e.MinSamples:
+		return tx.GasLimit
+	default:
+		est = int64(math.Ceil(float64(tx.GasLimit) * r.mean * (1 + e.Margin)))
+	}
+	return min(max(est, txBaseGas), tx.GasLimit)
+}
+
+// structTrace is the part of a debug_traceCall struct-logger result the
+// estimator uses
+type structTrace struct {
+	Failed     bool `json:"failed"`
+	StructLogs []struct {
+		Op      string `json:"op"`
+		Gas     int64  `json:"gas"`     // left before the op
+		GasCost int64  `json:"gasCost"` // of the op
+		Refund  int64  `json:"refund"`  // refund counter before the op
+	} `json:"structLogs"`
+}
+
+// charged returns the gas tx is charged for the execution traced, the way
+// the chain settles it (EIP-3529): its intrinsic gas and the gas its code
+// used, less the refund counter left at the end, but at most a fifth of
+// that. A failed transaction's refunds are reverted with its state, and
+// one halting exceptionally burns its whole gas limit.
+func (t *structTrace) charged(tx *Transaction) int64 {
+	if len(t.StructLogs) == 0 {
+		if t.Failed {
+			return tx.GasLimit
+		}
+		return tx.IntrinsicGas() // no code ran
+	}
+	last := t.StructLogs[len(t.StructLogs)-1]
+	if t.Failed && last.Op != "REVERT" {
+		return tx.GasLimit
+	}
+	used := min(tx.GasLimit-(last.Gas-last.GasCost), tx.GasLimit)
+	if t.Failed {
+		return used
+	}
+	return used - min(last.Refund, used/maxRefundQuotient)
+}
+
+// Simulate traces the SimulateTxs most profitable pooled transactions not
+// simulated yet with debug_traceCall on the pending block, recording what
+// each would be charged. One whose trace fails is retried next time, and
+// transactions that left the pool are forgotten.
+func (e *GasEstimator) Simulate(ctx context.Context, rpc *RPCClient, pool Mempool) error {
+	if e.SimulateTxs <= 0 {
+		return nil
+	}
+	txs := pool.Txs()
+	slices.SortStableFunc(txs, func(x, y *Transaction) int { return cmpScore(y, x) })
+	txs = txs[:min(e.SimulateTxs, len(txs))]
+
+	e.mu.Lock()
+	live := make(map[string]bool, len(txs))
+	var pending []*Transaction
+	for _, tx := range txs {
+		live[tx.Hash] = true
+		if _, done := e.simulated[tx.Hash]; !done && tx.From != "" {
+			pending = append(pending, tx)
+		}
+	}
+	maps.DeleteFunc(e.simulated, func(hash string, _ int64) bool { return !live[hash] })
+	e.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	traces := make([]structTrace, len(pending))
+	elems := make([]BatchElem, len(pending))
+	logger := map[string]bool{"disableStack": true, "disableStorage": true, "enableMemory": false, "enableReturnData": false}
+	for i, tx := range pending {
+		elems[i] = BatchElem{Method: "debug_traceCall", Params: []any{callObject(tx), "pending", logger}, Result: &traces[i]}
+	}
+	if err := rpc.BatchCall(ctx, elems); err != nil {
+		return fmt.Errorf("simulating gas used: %w", err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, tx := range pending {
+		if elems[i].Error == nil {
+			e.simulated[tx.Hash] = traces[i].charged(tx)
+		}
+	}
+	return nil
+}
+
+// Apply sets the GasEstimate of every pooled transaction whose estimate
+// changed, returning how many were updated
+func (e *GasEstimator) Apply(pool Mempool) int {
+	n := 0
+	for _, tx := range pool.Txs() {
+		est := e.Estimate(tx)
+		if est == tx.GasLimit {
+			est = 0
+		}
+		if est == tx.GasEstimate {
+			continue
+		}
+		updated := *tx
+		updated.GasEstimate = est
+		pool.AddTx(&updated)
+		n++
+	}
+	return n
+}
+
+// Packed records the estimates of a built block's transactions, to be
+// checked against their gasUsed if they are mined
+func (e *GasEstimator) Packed(selected []*Transaction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.packed = make(map[string]int64, len(selected))
+	for _, tx := range selected {
+		e.packed[tx.Hash] = tx.PackGas()
+	}
+}
+
+// rpcReceipt is the part of a receipt the estimator uses
+type rpcReceipt struct {
+	TransactionHash string `json:"transactionHash"`
+	GasUsed         string `json:"gasUsed"`
+}
+
+// LearnUpTo learns from the blocks after the last one learned up to head,
+// but no more than the history most recent
+func (e *GasEstimator) LearnUpTo(ctx context.Context, rpc *RPCClient, head uint64, history int) error {
+	e.mu.Lock()
+	from := e.learned + 1
+	e.mu.Unlock()
+	if head >= uint64(history) {
+		from = max(from, head-uint64(history)+1)
+	}
+	for n := from; n <= head; n++ {
+		if err := e.Learn(ctx, rpc, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Learn folds the gasUsed/gas ratios of block number's transactions into
+// the per-contract averages, and scores the last packed estimates against
+// any of them that were mined
+func (e *GasEstimator) Learn(ctx context.Context, rpc *RPCClient, number uint64) error {
+	_, txs, _, err := FetchBlock(ctx, rpc, number)
+	if err != nil {
+		return err
+	}
+	var receipts []rpcReceipt
+	if err := rpc.Call(ctx, &receipts, "eth_getBlockReceipts", EncodeHexUint64(number)); err != nil {
+		return fmt.Errorf("fetching receipts of block %d: %w", number, err)
+	}
+	used := make(map[string]int64, len(receipts))
+	for _, r := range receipts {
+		if g, err := ParseHexInt64(r.GasUsed); err == nil {
+			used[strings.ToLower(r.TransactionHash)] = g
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.learned = max(e.learned, number)
+	for _, tx := range txs {
+		g, ok := used[strings.ToLower(tx.Hash)]
+		if !ok || tx.GasLimit <= 0 {
+			continue
+		}
+		key := strings.ToLower(tx.To)
+		r := e.ratios[key]
+		if r == nil {
+			r = &gasRatio{mean: float64(g) / float64(tx.GasLimit)}
+			e.ratios[key] = r
+		} else {
+			r.mean += gasRatioAlpha * (float64(g)/float64(tx.GasLimit) - r.mean)
+		}
+		r.n++
+		if est, ok := e.packed[tx.Hash]; ok {
+			e.estimated += est
+			e.realized += g
+			e.matched++
+			delete(e.packed, tx.Hash)
+		}
+	}
+	return nil
+}
+
+// GasAccuracy reports how the estimates of mined packed transactions
+// compared with the gas they used
+type GasAccuracy struct {
+	Matched   int   // packed transactions seen mined
+	Estimated int64 // their summed estimates
+	Realized  int64 // their summed gasUsed
+}
+
+// Utilization returns Realized / Estimated, the share of the estimated gas
+// actually used; below 1 the margin is generous, above 1 estimates ran short
+func (a GasAccuracy) Utilization() float64 {
+	if a.Estimated == 0 {
+		return 0
+	}
+	return float64(a.Realized) / float64(a.Estimated)
+}
+
+// Accuracy returns the running estimate accuracy
+func (e *GasEstimator) Accuracy() GasAccuracy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return GasAccuracy{Matched: e.matched, Estimated: e.estimated, Realized: e.realized}
+}
@@ -0,0 +1,3 @@
+module github.com/cspannos/block-construction-engine-poc
+
+go 1.24
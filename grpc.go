@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcService is the path prefix of the Builder service in builder.proto
+const grpcService = "/builder.v1.Builder/"
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnauthenticated   = 16
+)
+
+// GRPCError is a failed call's gRPC status
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *GRPCError) Error() string { return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message) }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &GRPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// isGRPC reports whether r is a gRPC call rather than a plain HTTP request
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// handleGRPC serves the Builder service of builder.proto. gRPC runs over
+// HTTP/2, so the listener must accept it (unencrypted, for h2c clients).
+// Messages are length-prefixed protobuf and the call's status goes in the
+// trailers. Compression isn't supported.
+func (s *APIServer) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	status := &GRPCError{Code: grpcOK}
+	if err := s.serveGRPC(w, r); err != nil && !errors.As(err, &status) {
+		status = &GRPCError{Code: grpcInternal, Message: err.Error()}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(status.Code))
+	if status.Message != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(status.Message))
+	}
+}
+
+func (s *APIServer) serveGRPC(w http.ResponseWriter, r *http.Request) error {
+	received := time.Now()
+	if r.Method != http.MethodPost {
+		return grpcErrorf(grpcUnimplemented, "method %s not allowed", r.Method)
+	}
+	method, _ := strings.CutPrefix(r.URL.Path, grpcService)
+	switch method {
+	case "SubmitTransaction":
+		if !s.authorized(r) {
+			return grpcErrorf(grpcUnauthenticated, "missing or unknown bearer token")
+		}
+		if s.Pool.Full() {
+			return grpcErrorf(grpcResourceExhausted, "pool is full")
+		}
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			return err
+		}
+		var tx Transaction
+		if err := tx.UnmarshalProto(msg); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		if err := checkSubmission([]*Transaction{&tx}); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		tx.ReceivedAt = received
+		result := s.Pool.AddPrivate(&tx, s.PrivateTTL)
+		resp := protoAppendString(nil, 1, tx.Hash)
+		return writeGRPCMessage(w, protoAppendString(resp, 2, result.String()))
+
+	case "SubmitBundle":
+		if !s.authorized(r) {
+			return grpcErrorf(grpcUnauthenticated, "missing or unknown bearer token")
+		}
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			return err
+		}
+		var bundle Bundle
+		if err := bundle.UnmarshalProto(msg); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		if err := s.Hints.AddBundle(&bundle); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		return writeGRPCMessage(w, protoAppendString(nil, 1, bundle.ID))
+
+	case "CancelBundle":
+		if !s.authorized(r) {
+			return grpcErrorf(grpcUnauthenticated, "missing or unknown bearer token")
+		}
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			return err
+		}
+		var c BundleCancellation
+		if err := c.UnmarshalProto(msg); err != nil {
+			return grpcErrorf(grpcInvalidArgument, "%v", err)
+		}
+		id, err := s.Hints.CancelBundle(c.Searcher, c.ReplacementUUID)
+		if err != nil {
+			return grpcErrorf(grpcNotFound, "%v", err)
+		}
+		return writeGRPCMessage(w, protoAppendString(nil, 1, id))
+
+	case "GetPoolStats":
+		if _, err := readGRPCMessage(r.Body); err != nil {
+			return err
+		}
+		stats := s.Pool.Stats()
+		return writeGRPCMessage(w, stats.MarshalProto())
+
+	case "StreamBuiltBlocks":
+		if s.Feed == nil {
+			return grpcErrorf(grpcUnimplemented, "no build feed on this server")
+		}
+		if _, err := readGRPCMessage(r.Body); err != nil {
+			return err
+		}
+		sub := s.Feed.subscribe()
+		defer s.Feed.unsubscribe(sub)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			return err
+		}
+		for {
+			select {
+			case c, ok := <-sub.ch:
+				if !ok {
+					return grpcErrorf(grpcResourceExhausted, "subscriber fell too far behind")
+				}
+				if err := writeGRPCMessage(w, c.MarshalProto()); err != nil {
+					return err
+				}
+			case <-r.Context().Done():
+				return nil
+			}
+		}
+	}
+	return grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+}
+
+// readGRPCMessage reads one length-prefixed request message
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxSubmissionSize {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes exceeds %d", size, maxSubmissionSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading message: %v", err)
+	}
+	return msg, nil
+}
+
+// writeGRPCMessage writes one length-prefixed response message and flushes
+// it to the client
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// grpcEscape percent-encodes a status message as the grpc-message trailer
+// requires
+func grpcEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"runtime/metrics"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HeapOp names an instrumented heap operation
+type HeapOp string
+
+const (
+	HeapPush   HeapOp = "push"
+	HeapPop    HeapOp = "pop"
+	HeapFix    HeapOp = "fix"
+	HeapRemove HeapOp = "remove"
+	HeapInit   HeapOp = "init"
+)
+
+// heapOps lists the operations in the order they are reported
+var heapOps = []HeapOp{HeapPush, HeapPop, HeapFix, HeapRemove, HeapInit}
+
+// The runtime's cumulative heap allocation counters
+const (
+	heapAllocObjects = "/gc/heap/allocs:objects"
+	heapAllocBytes   = "/gc/heap/allocs:bytes"
+)
+
+const (
+	heapWindow     = 4096 // latest latencies of each operation quantiles are taken over
+	heapAllocEvery = 64   // one call of each operation in this many has its allocations sampled
+)
+
+// HeapMetrics times the pool's heap operations and samples what they
+// allocate, so regressions in the pool's data structures show once the
+// mempool holds hundreds of thousands of entries. Its methods mirror those
+// of container/heap and are safe for concurrent use; on a nil HeapMetrics
+// they just run the operation.
+//
+// Allocations are read from the runtime's process-wide counters around a
+// sampled call, so allocations by other goroutines during it are counted
+// too: an upper bound, accurate while ingest is quiet.
+type HeapMetrics struct {
+	mu  sync.Mutex
+	ops map[HeapOp]*heapOpStats
+}
+
+// heapOpStats accumulates one operation's samples
+type heapOpStats struct {
+	count     int64
+	total     time.Duration
+	latencies []time.Duration // ring of the latest heapWindow
+	next      int
+
+	allocSamples, allocs, allocBytes uint64
+}
+
+// NewHeapMetrics returns an empty recorder
+func NewHeapMetrics() *HeapMetrics {
+	return &HeapMetrics{ops: map[HeapOp]*heapOpStats{}}
+}
+
+// Push is heap.Push, timed
+func (m *HeapMetrics) Push(h heap.Interface, x any) {
+	m.observe(HeapPush, func() { heap.Push(h, x) })
+}
+
+// Pop is heap.Pop, timed
+func (m *HeapMetrics) Pop(h heap.Interface) (x any) {
+	m.observe(HeapPop, func() { x = heap.Pop(h) })
+	return x
+}
+
+// Fix is heap.Fix, timed
+func (m *HeapMetrics) Fix(h heap.Interface, i int) {
+	m.observe(HeapFix, func() { heap.Fix(h, i) })
+}
+
+// Remove is heap.Remove, timed
+func (m *HeapMetrics) Remove(h heap.Interface, i int) (x any) {
+	m.observe(HeapRemove, func() { x = heap.Remove(h, i) })
+	return x
+}
+
+// Init is heap.Init, timed
+func (m *HeapMetrics) Init(h heap.Interface) {
+	m.observe(HeapInit, func() { heap.Init(h) })
+}
+
+// observe runs fn, recording its latency as op and, on every
+// heapAllocEvery-th call, its allocations
+func (m *HeapMetrics) observe(op HeapOp, fn func()) {
+	if m == nil {
+		fn()
+		return
+	}
+	m.mu.Lock()
+	s := m.ops[op]
+	if s == nil {
+		s = &heapOpStats{latencies: make([]time.Duration, 0, heapWindow)}
+		m.ops[op] = s
+	}
+	sample := s.count%heapAllocEvery == 0
+	m.mu.Unlock()
+
+	// Both reads go into one slice made up front, so that making it
+	// isn't counted
+	var allocs []metrics.Sample
+	if sample {
+		allocs = []metrics.Sample{{Name: heapAllocObjects}, {Name: heapAllocBytes}, {Name: heapAllocObjects}, {Name: heapAllocBytes}}
+		metrics.Read(allocs[:2])
+	}
+	start := time.Now()
+	fn()
+	elapsed := time.Since(start)
+	if sample {
+		metrics.Read(allocs[2:])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.count++
+	s.total += elapsed
+	if len(s.latencies) < heapWindow {
+		s.latencies = append(s.latencies, elapsed)
+	} else {
+		s.latencies[s.next] = elapsed
+	}
+	s.next = (s.next + 1) % heapWindow
+	if sample {
+		s.allocSamples++
+		s.allocs += allocs[2].Value.Uint64() - allocs[0].Value.Uint64()
+		s.allocBytes += allocs[3].Value.Uint64() - allocs[1].Value.Uint64()
+	}
+}
+
+// HeapOpStats summarizes one heap operation
+type HeapOpStats struct {
+	Count       int64         `json:"count"`
+	Total       time.Duration `json:"total"`
+	P50         time.Duration `json:"p50"` // over the latest heapWindow calls
+	P99         time.Duration `json:"p99"`
+	Max         time.Duration `json:"max"`
+	AllocsPerOp float64       `json:"allocsPerOp"` // of the sampled calls
+	BytesPerOp  float64       `json:"bytesPerOp"`
+}
+
+// Stats returns a summary of each operation recorded so far
+func (m *HeapMetrics) Stats() map[HeapOp]HeapOpStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[HeapOp]HeapOpStats, len(m.ops))
+	for op, s := range m.ops {
+		sorted := slices.Sorted(slices.Values(s.latencies))
+		st := HeapOpStats{Count: s.count, Total: s.total}
+		if n := len(sorted); n > 0 {
+			st.P50, st.P99, st.Max = sorted[(n-1)*50/100], sorted[(n-1)*99/100], sorted[n-1]
+		}
+		if s.allocSamples > 0 {
+			st.AllocsPerOp = float64(s.allocs) / float64(s.allocSamples)
+			st.BytesPerOp = float64(s.allocBytes) / float64(s.allocSamples)
+		}
+		out[op] = st
+	}
+	return out
+}
+
+// WriteMetrics writes the summaries in the Prometheus text exposition
+// format
+func (m *HeapMetrics) WriteMetrics(w io.Writer) {
+	stats := m.Stats()
+	fmt.Fprintf(w, "# HELP bce_pool_heap_op_seconds Latency of pool heap operations over the latest %d calls of each.\n# TYPE bce_pool_heap_op_seconds summary\n", heapWindow)
+	for _, op := range heapOps {
+		if st, ok := stats[op]; ok {
+			fmt.Fprintf(w, "bce_pool_heap_op_seconds{op=%q,quantile=\"0.5\"} %v\n", op, st.P50.Seconds())
+			fmt.Fprintf(w, "bce_pool_heap_op_seconds{op=%q,quantile=\"0.99\"} %v\n", op, st.P99.Seconds())
+			fmt.Fprintf(w, "bce_pool_heap_op_seconds_sum{op=%q} %v\n", op, st.Total.Seconds())
+			fmt.Fprintf(w, "bce_pool_heap_op_seconds_count{op=%q} %d\n", op, st.Count)
+		}
+	}
+	fmt.Fprintf(w, "# HELP bce_pool_heap_op_allocs Heap allocations per pool heap operation, sampled.\n# TYPE bce_pool_heap_op_allocs gauge\n")
+	for _, op := range heapOps {
+		if st, ok := stats[op]; ok {
+			fmt.Fprintf(w, "bce_pool_heap_op_allocs{op=%q} %v\n", op, st.AllocsPerOp)
+		}
+	}
+	fmt.Fprintf(w, "# HELP bce_pool_heap_op_alloc_bytes Bytes allocated per pool heap operation, sampled.\n# TYPE bce_pool_heap_op_alloc_bytes gauge\n")
+	for _, op := range heapOps {
+		if st, ok := stats[op]; ok {
+			fmt.Fprintf(w, "bce_pool_heap_op_alloc_bytes{op=%q} %v\n", op, st.BytesPerOp)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the hex parsing helpers
+var (
+	ErrEmptyHex      = errors.New("empty hex string")
+	ErrMissingPrefix = errors.New("hex string without 0x prefix")
+	ErrHexSyntax     = errors.New("invalid hex string")
+	ErrHexOverflow   = errors.New("hex number out of range")
+)
+
+// HexError wraps a parse failure with the offending input
+type HexError struct {
+	Input string
+	Err   error
+}
+
+func (e *HexError) Error() string {
+	return fmt.Sprintf("parsing %q: %v", e.Input, e.Err)
+}
+
+func (e *HexError) Unwrap() error { return e.Err }
+
+// hexDigits strips the 0x prefix and checks the remaining digits are non-empty
+func hexDigits(s string) (string, error) {
+	if s == "" {
+		return "", &HexError{s, ErrEmptyHex}
+	}
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return "", &HexError{s, ErrMissingPrefix}
+	}
+	digits := s[2:]
+	if digits == "" {
+		return "", &HexError{s, ErrEmptyHex}
+	}
+	return digits, nil
+}
+
+// ParseHexUint64 parses a 0x-prefixed quantity such as "0x1a"
+func ParseHexUint64(s string) (uint64, error) {
+	digits, err := hexDigits(s)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, &HexError{s, ErrHexOverflow}
+		}
+		return 0, &HexError{s, ErrHexSyntax}
+	}
+	return v, nil
+}
+
+// ParseHexInt64 parses a 0x-prefixed quantity that must fit in an int64
+func ParseHexInt64(s string) (int64, error) {
+	v, err := ParseHexUint64(s)
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxInt64 {
+		return 0, &HexError{s, ErrHexOverflow}
+	}
+	return int64(v), nil
+}
+
+// ParseHexBig parses a 0x-prefixed quantity of arbitrary size
+func ParseHexBig(s string) (*big.Int, error) {
+	digits, err := hexDigits(s)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := new(big.Int).SetString(digits, 16)
+	if !ok {
+		return nil, &HexError{s, ErrHexSyntax}
+	}
+	return v, nil
+}
+
+// ParseHexBytes decodes 0x-prefixed hex data; "0x" yields an empty slice
+func ParseHexBytes(s string) ([]byte, error) {
+	if s == "" {
+		return nil, &HexError{s, ErrEmptyHex}
+	}
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return nil, &HexError{s, ErrMissingPrefix}
+	}
+	b, err := hex.DecodeString(s[2:])
+	if err != nil {
+		return nil, &HexError{s, ErrHexSyntax}
+	}
+	return b, nil
+}
+
+// EncodeHexUint64 formats v as a 0x-prefixed quantity
+func EncodeHexUint64(v uint64) string {
+	return "0x" + strconv.FormatUint(v, 16)
+}
+
+// EncodeHexBig formats a non-negative v as a 0x-prefixed quantity
+func EncodeHexBig(v *big.Int) string {
+	return "0x" + v.Text(16)
+}
+
+// EncodeHexBytes formats b as 0x-prefixed hex data
+func EncodeHexBytes(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
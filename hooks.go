@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ProposalHook injects chain-specific ordering rules around the packer,
+// like a lane of a PrepareProposal pipeline: system transactions first,
+// PoL distribution calls, oracle updates. Both phases are called with the
+// pool read-locked, so they may read its fields but must not call methods
+// that lock it, and must be deterministic for traces to replay.
+type ProposalHook interface {
+	// Before returns the transactions that open the block, ahead of
+	// everything the packer picks, and gas to hold back from the packer
+	// for After to fill. Transactions it returns need not be pooled.
+	Before(ctx context.Context, pool *TxPool, gasLimit int64) (head []*Transaction, reserve int64, err error)
+
+	// After returns the packed block reordered, trimmed or extended
+	After(ctx context.Context, pool *TxPool, gasLimit int64, txs []*Transaction) ([]*Transaction, error)
+}
+
+// HookFuncs adapts a pair of functions to a ProposalHook; either may be nil
+// to leave its phase alone
+type HookFuncs struct {
+	BeforeFunc func(ctx context.Context, pool *TxPool, gasLimit int64) ([]*Transaction, int64, error)
+	AfterFunc  func(ctx context.Context, pool *TxPool, gasLimit int64, txs []*Transaction) ([]*Transaction, error)
+}
+
+func (h HookFuncs) Before(ctx context.Context, pool *TxPool, gasLimit int64) ([]*Transaction, int64, error) {
+	if h.BeforeFunc == nil {
+		return nil, 0, nil
+	}
+	return h.BeforeFunc(ctx, pool, gasLimit)
+}
+
+func (h HookFuncs) After(ctx context.Context, pool *TxPool, gasLimit int64, txs []*Transaction) ([]*Transaction, error) {
+	if h.AfterFunc == nil {
+		return txs, nil
+	}
+	return h.AfterFunc(ctx, pool, gasLimit, txs)
+}
+
+// Hook is a configured ProposalHook
+type Hook struct {
+	Config HookConfig
+	ProposalHook
+}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   = map[string]func(HookConfig) (ProposalHook, error){
+		"system-first": newSystemFirstHook,
+	}
+)
+
+// RegisterHook makes the hooks newHook makes from their config selectable
+// in [[builder.hooks]] under name. Like packers, hooks are compiled in from
+// an init function. It panics if name is taken.
+func RegisterHook(name string, newHook func(HookConfig) (ProposalHook, error)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	if _, ok := hooks[name]; ok {
+		panic(fmt.Sprintf("hook %q registered twice", name))
+	}
+	hooks[name] = newHook
+}
+
+// HookNames lists the registered hooks, sorted
+func HookNames() []string {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewHooks makes the hooks cfgs configure, in pipeline order
+func NewHooks(cfgs []HookConfig) ([]*Hook, error) {
+	out := make([]*Hook, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		hooksMu.RLock()
+		newHook, ok := hooks[cfg.Hook]
+		hooksMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown hook %q (want one of %s)", cfg.Hook, strings.Join(HookNames(), ", "))
+		}
+		h, err := newHook(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: %w", cfg.Hook, err)
+		}
+		out = append(out, &Hook{Config: cfg, ProposalHook: h})
+	}
+	return out, nil
+}
+
+// packWithHooks runs pack over the gas the pool's hooks leave it, between
+// their Before and After phases, and less the gas reserved for encrypted
+// transactions. It returns the block and the hashes of the transactions
+// hooks added from outside the pool. Called with the pool read-locked.
+func (p *TxPool) packWithHooks(ctx context.Context, gasLimit int64, pack func(gasLimit int64) ([]*Transaction, error)) ([]*Transaction, map[string]bool, error) {
+	held := min(p.Encrypted.reserved(), gasLimit)
+	if len(p.Hooks) == 0 {
+		txs, err := pack(gasLimit - held)
+		return txs, nil, err
+	}
+	var head []*Transaction
+	for _, h := range p.Hooks {
+		txs, reserve, err := h.Before(ctx, p, gasLimit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hook %s: %w", h.Config.Hook, err)
+		}
+		head = append(head, txs...)
+		held += reserve
+	}
+	for _, tx := range head {
+		held += tx.PackGas()
+	}
+	if held > gasLimit {
+		return nil, nil, fmt.Errorf("hooks hold back %d gas, over the limit of %d", held, gasLimit)
+	}
+	packed, err := pack(gasLimit - held)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The packer doesn't know the head, so drop what repeats or conflicts
+	// with it
+	txs := packed
+	if len(head) > 0 {
+		var bundles []*Transaction
+		for _, tx := range slices.Concat(head, packed) {
+			if len(tx.Bundle) > 0 {
+				bundles = append(bundles, tx)
+			}
+		}
+		graph := p.conflictGraph(bundles...)
+		used := make(map[string]bool, len(head)+len(packed))
+		txs = slices.Clone(head)
+		for _, tx := range head {
+			used[tx.Hash] = true
+		}
+		for _, tx := range packed {
+			if used[tx.Hash] || graph.Conflicts(tx.Hash, used) {
+				continue
+			}
+			used[tx.Hash] = true
+			txs = append(txs, tx)
+		}
+	}
+	for _, h := range p.Hooks {
+		if txs, err = h.After(ctx, p, gasLimit, txs); err != nil {
+			return nil, nil, fmt.Errorf("hook %s: %w", h.Config.Hook, err)
+		}
+	}
+
+	fromPacker := make(map[string]bool, len(packed))
+	for _, tx := range packed {
+		fromPacker[tx.Hash] = true
+	}
+	injected := map[string]bool{}
+	for _, tx := range txs {
+		if tx != nil && !fromPacker[tx.Hash] && p.AllTxs[tx.Hash] == nil {
+			injected[tx.Hash] = true
+		}
+	}
+	return txs, injected, nil
+}
+
+// systemFirstHook moves the transactions it matches to the top of the
+// block, keeping their packed order, along with their senders' earlier
+// nonces so none is moved ahead of a transaction it depends on
+type systemFirstHook struct {
+	to, from map[string]bool
+}
+
+func newSystemFirstHook(cfg HookConfig) (ProposalHook, error) {
+	h := &systemFirstHook{to: map[string]bool{}, from: map[string]bool{}}
+	for _, a := range cfg.To {
+		h.to[strings.ToLower(a)] = true
+	}
+	for _, a := range cfg.From {
+		h.from[strings.ToLower(a)] = true
+	}
+	if len(h.to) == 0 && len(h.from) == 0 {
+		return nil, errors.New("needs to or from addresses")
+	}
+	return h, nil
+}
+
+func (h *systemFirstHook) match(tx *Transaction) bool { return h.to[tx.To] || h.from[tx.From] }
+
+func (h *systemFirstHook) Before(context.Context, *TxPool, int64) ([]*Transaction, int64, error) {
+	return nil, 0, nil
+}
+
+func (h *systemFirstHook) After(_ context.Context, _ *TxPool, _ int64, txs []*Transaction) ([]*Transaction, error) {
+	upTo := map[string]int{} // highest matched nonce by sender
+	for _, tx := range txs {
+		if h.match(tx) && tx.From != "" {
+			if n, ok := upTo[tx.From]; !ok || tx.Nonce > n {
+				upTo[tx.From] = tx.Nonce
+			}
+		}
+	}
+	first := make([]*Transaction, 0, len(txs))
+	var rest []*Transaction
+	for _, tx := range txs {
+		if n, ok := upTo[tx.From]; h.match(tx) || ok && tx.Nonce <= n {
+			first = append(first, tx)
+		} else {
+			rest = append(rest, tx)
+		}
+	}
+	return append(first, rest...), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPConfig tunes the connection pool every outgoing HTTP client shares:
+// those of the RPC nodes, relays, the beacon and MEV-Share event streams,
+// webhooks and the trace exporter
+type HTTPConfig struct {
+	MaxIdleConns        int      `json:"max_idle_conns"`          // idle connections kept across all hosts; 0 is unlimited
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host"` // idle connections kept per host
+	MaxConnsPerHost     int      `json:"max_conns_per_host"`      // connections per host, idle or not; 0 is unlimited
+	IdleTimeout         Duration `json:"idle_timeout"`            // idle connections are closed after this; 0 keeps them
+	DialTimeout         Duration `json:"dial_timeout"`            // to establish a TCP connection; 0 waits
+	KeepAlive           Duration `json:"keep_alive"`              // TCP keep-alive probe interval; negative disables probes
+	HTTP2               bool     `json:"http2"`                   // negotiate HTTP/2 with TLS endpoints
+}
+
+// DefaultHTTPConfig keeps enough idle connections per host for a build's
+// concurrent RPC calls, where Go's default transport keeps two
+func DefaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		MaxIdleConns:        256,
+		MaxIdleConnsPerHost: 64,
+		IdleTimeout:         Duration(90 * time.Second),
+		DialTimeout:         Duration(5 * time.Second),
+		KeepAlive:           Duration(30 * time.Second),
+		HTTP2:               true,
+	}
+}
+
+// NewHTTPTransport returns a transport configured from cfg
+func NewHTTPTransport(cfg HTTPConfig) *http.Transport {
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.DialTimeout), KeepAlive: time.Duration(cfg.KeepAlive)}
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2)
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.IdleTimeout),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     cfg.HTTP2,
+		Protocols:             protocols,
+	}
+}
+
+// httpTransport is the transport every client made by newHTTPClient
+// sends through. UseHTTPConfig replaces it at startup, before any
+// request is made.
+var httpTransport = NewHTTPTransport(DefaultHTTPConfig())
+
+// UseHTTPConfig reconfigures the shared transport, closing the idle
+// connections of the one it replaces
+func UseHTTPConfig(cfg HTTPConfig) {
+	old := httpTransport
+	httpTransport = NewHTTPTransport(cfg)
+	old.CloseIdleConnections()
+}
+
+// sharedTransport sends through whatever httpTransport is current, so
+// clients made before UseHTTPConfig still follow it
+type sharedTransport struct{}
+
+func (sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return httpTransport.RoundTrip(req)
+}
+
+func (sharedTransport) CloseIdleConnections() { httpTransport.CloseIdleConnections() }
+
+// newHTTPClient returns a client on the shared connection pool giving up
+// on a request after timeout, or never if 0. Clients differ only in their
+// timeout, so they are cheap; the connections are what is reused.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: sharedTransport{}, Timeout: timeout}
+}
+
+// httpClient is the shared client for requests without a timeout of their
+// own, bounded by their context instead
+var httpClient = newHTTPClient(0)
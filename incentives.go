@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RewardVaultFactory and RewardVault selectors
+var (
+	selectorAllVaultsLength      = []byte{0x4c, 0xd1, 0x85, 0x77} // allVaultsLength()
+	selectorAllVaults            = []byte{0x90, 0x94, 0xa9, 0x1e} // allVaults(uint256)
+	selectorStakeToken           = []byte{0x51, 0xed, 0x6a, 0x30} // stakeToken()
+	selectorGetWhitelistedTokens = []byte{0xe2, 0x6f, 0x79, 0x00} // getWhitelistedTokens()
+	selectorIncentives           = []byte{0x77, 0x4e, 0x90, 0x0b} // incentives(address)
+)
+
+// VaultIncentives is what a reward vault currently offers validators for
+// the BGT they direct to it
+type VaultIncentives struct {
+	Vault      Address              `json:"vault"`
+	StakeToken Address              `json:"stakeToken"`
+	Rates      map[Address]*big.Int `json:"rates"` // incentive token base units per BGT, of tokens with some left
+	Value      float64              `json:"value"` // BERA wei per BGT at the configured token prices
+}
+
+// IncentiveBook discovers the reward vaults of the RewardVaultFactory and
+// caches their incentive rates for TTL, so crediting PoL bonuses at build
+// time is a map lookup rather than a round of eth_calls. A transaction
+// calling a vault or its stake token is taken to feed that vault, and is
+// credited BGTPerTx BGT of its incentives as PoLBonus, paid through it as
+// PoLVault so cutting boards weigh it. Incentive tokens are valued at
+// Prices; those without a price are left out. Its methods are safe for
+// concurrent use.
+type IncentiveBook struct {
+	RPC      *RPCClient
+	Factory  Address
+	TTL      time.Duration
+	BGTPerTx float64
+	Prices   map[Address]float64 // BERA wei per incentive token base unit
+
+	mu      sync.Mutex
+	fetched time.Time
+	vaults  []*VaultIncentives
+	byAddr  map[string]*VaultIncentives // by lower-case vault and stake token address
+}
+
+// NewIncentiveBook returns a book configured from cfg reading the factory
+// at pol.reward_vault_factory, or nil if incentive discovery is disabled
+func NewIncentiveBook(rpc *RPCClient, factory string, cfg IncentivesConfig) (*IncentiveBook, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	b := &IncentiveBook{RPC: rpc, TTL: time.Duration(cfg.TTL), BGTPerTx: cfg.BGTPerTx, Prices: map[Address]float64{}}
+	var err error
+	if b.Factory, err = HexToAddress(factory); err != nil {
+		return nil, fmt.Errorf("pol.reward_vault_factory: %w", err)
+	}
+	for i, t := range cfg.Tokens {
+		token, err := HexToAddress(t.Address)
+		if err != nil {
+			return nil, fmt.Errorf("builder.incentives.tokens[%d]: %w", i, err)
+		}
+		b.Prices[token] = t.Price
+	}
+	return b, nil
+}
+
+// Refresh re-reads the vaults and their incentives if the cache is older
+// than TTL, reporting whether it did. The old cache is kept if reading
+// fails.
+func (b *IncentiveBook) Refresh(ctx context.Context) (bool, error) {
+	b.mu.Lock()
+	fresh := !b.fetched.IsZero() && time.Since(b.fetched) < b.TTL
+	b.mu.Unlock()
+	if fresh {
+		return false, nil
+	}
+	vaults, err := b.fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+	byAddr := make(map[string]*VaultIncentives, 2*len(vaults))
+	for _, v := range vaults {
+		byAddr[v.StakeToken.Hex()] = v
+		byAddr[v.Vault.Hex()] = v
+	}
+	b.mu.Lock()
+	b.vaults, b.byAddr, b.fetched = vaults, byAddr, time.Now()
+	b.mu.Unlock()
+	return true, nil
+}
+
+// fetch reads every vault of the factory, its stake token, whitelisted
+// incentive tokens and their rates. A vault any of whose reads fails is
+// left out until the next refresh.
+func (b *IncentiveBook) fetch(ctx context.Context) ([]*VaultIncentives, error) {
+	var length string
+	call := map[string]string{"to": b.Factory.Hex(), "data": EncodeHexBytes(selectorAllVaultsLength)}
+	if err := b.RPC.Call(ctx, &length, "eth_call", call, "latest"); err != nil {
+		return nil, fmt.Errorf("reading reward vault count: %w", err)
+	}
+	n, err := abiUint(length)
+	if err != nil {
+		return nil, fmt.Errorf("reading reward vault count: %w", err)
+	}
+
+	addrs, err := b.batch(ctx, int(n), func(i int) (Address, []byte) {
+		return b.Factory, abiCall(selectorAllVaults, new(big.Int).SetInt64(int64(i)).FillBytes(make([]byte, 32)))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing reward vaults: %w", err)
+	}
+	var vaults []*VaultIncentives
+	for _, res := range addrs {
+		if addr, err := abiAddress(res); err == nil {
+			vaults = append(vaults, &VaultIncentives{Vault: addr, Rates: map[Address]*big.Int{}})
+		}
+	}
+
+	info, err := b.batch(ctx, 2*len(vaults), func(i int) (Address, []byte) {
+		if i%2 == 0 {
+			return vaults[i/2].Vault, selectorStakeToken
+		}
+		return vaults[i/2].Vault, selectorGetWhitelistedTokens
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading reward vaults: %w", err)
+	}
+	type incentive struct {
+		vault *VaultIncentives
+		token Address
+	}
+	var wanted []incentive
+	for i, v := range vaults {
+		stake, err := abiAddress(info[2*i])
+		if err != nil {
+			continue
+		}
+		tokens, err := abiAddresses(info[2*i+1])
+		if err != nil {
+			continue
+		}
+		v.StakeToken = stake
+		for _, t := range tokens {
+			wanted = append(wanted, incentive{v, t})
+		}
+	}
+
+	rates, err := b.batch(ctx, len(wanted), func(i int) (Address, []byte) {
+		var word [32]byte
+		copy(word[12:], wanted[i].token[:])
+		return wanted[i].vault.Vault, abiCall(selectorIncentives, word[:])
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading vault incentives: %w", err)
+	}
+	for i, w := range wanted {
+		// incentives(token) returns (minIncentiveRate, incentiveRate,
+		// amountRemaining, ...); a rate with nothing left pays nothing
+		res := rates[i]
+		if len(res) < 96 {
+			continue
+		}
+		rate := new(big.Int).SetBytes(res[32:64])
+		if rate.Sign() == 0 || new(big.Int).SetBytes(res[64:96]).Sign() == 0 {
+			continue
+		}
+		w.vault.Rates[w.token] = rate
+		if price, ok := b.Prices[w.token]; ok {
+			r, _ := new(big.Float).SetInt(rate).Float64()
+			w.vault.Value += r * price
+		}
+	}
+	return vaults, nil
+}
+
+// batch makes n eth_calls, call i to the contract and calldata call
+// returns, and returns their decoded results; a failed call's is nil
+func (b *IncentiveBook) batch(ctx context.Context, n int, call func(i int) (Address, []byte)) ([][]byte, error) {
+	results := make([]string, n)
+	elems := make([]BatchElem, n)
+	for i := range elems {
+		to, data := call(i)
+		elems[i] = BatchElem{Method: "eth_call", Params: []any{map[string]string{"to": to.Hex(), "data": EncodeHexBytes(data)}, "latest"}, Result: &results[i]}
+	}
+	if err := b.RPC.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+	out := make([][]byte, n)
+	for i, e := range elems {
+		if e.Error == nil {
+			out[i], _ = ParseHexBytes(results[i])
+		}
+	}
+	return out, nil
+}
+
+// Lookup returns the cached incentives of the vault tx feeds: its PoLVault
+// if set, else the vault it calls or whose stake token it calls. It is nil
+// if that isn't a known vault.
+func (b *IncentiveBook) Lookup(tx *Transaction) *VaultIncentives {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if tx.PoLVault != "" {
+		return b.byAddr[strings.ToLower(tx.PoLVault)]
+	}
+	return b.byAddr[tx.To]
+}
+
+// Apply refreshes the book if it is stale and credits pooled transactions
+// feeding a known vault with its incentives, returning how many changed
+func (b *IncentiveBook) Apply(ctx context.Context, pool Mempool) (updated int, err error) {
+	ctx, span := StartSpan(ctx, "incentives.apply")
+	defer func() {
+		span.SetAttr("incentives.updated", updated)
+		span.SetError(err)
+		span.End()
+	}()
+	if _, err := b.Refresh(ctx); err != nil {
+		return 0, err
+	}
+	for _, tx := range pool.Txs() {
+		v := b.Lookup(tx)
+		if v == nil {
+			continue
+		}
+		bonus := int64(min(v.Value*b.BGTPerTx, math.MaxInt64))
+		vault := v.Vault.Hex()
+		if tx.PoLBonus == bonus && strings.EqualFold(tx.PoLVault, vault) {
+			continue
+		}
+		credited := *tx
+		credited.PoLBonus, credited.PoLVault = bonus, vault
+		pool.AddTx(&credited)
+		updated++
+	}
+	return updated, nil
+}
+
+// abiCall returns the calldata of selector with the given argument words
+func abiCall(selector []byte, args ...[]byte) []byte {
+	data := append([]byte{}, selector...)
+	for _, a := range args {
+		data = append(data, a...)
+	}
+	return data
+}
+
+// abiUint decodes a hex eth_call result holding one uint256 count, which
+// must fit an int32
+func abiUint(result string) (uint64, error) {
+	data, err := ParseHexBytes(result)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 32 {
+		return 0, errors.New("short return data")
+	}
+	n := new(big.Int).SetBytes(data[:32])
+	if !n.IsUint64() || n.Uint64() > math.MaxInt32 {
+		return 0, errors.New("value out of range")
+	}
+	return n.Uint64(), nil
+}
+
+// abiAddress decodes return data holding one address
+func abiAddress(data []byte) (Address, error) {
+	var a Address
+	if len(data) < 32 {
+		return a, errors.New("short return data")
+	}
+	copy(a[:], data[12:32])
+	return a, nil
+}
+
+// abiAddresses decodes return data holding one address[]
+func abiAddresses(data []byte) ([]Address, error) {
+	if len(data) < 64 {
+		return nil, errors.New("short return data")
+	}
+	off := new(big.Int).SetBytes(data[:32])
+	if !off.IsUint64() || off.Uint64() > uint64(len(data))-32 {
+		return nil, errors.New("offset out of range")
+	}
+	at := off.Uint64()
+	n := new(big.Int).SetBytes(data[at : at+32])
+	if !n.IsUint64() || n.Uint64() > (uint64(len(data))-at-32)/32 {
+		return nil, errors.New("short return data")
+	}
+	addrs := make([]Address, n.Uint64())
+	for i := range addrs {
+		word := data[at+32+uint64(i)*32:]
+		copy(addrs[i][:], word[12:32])
+	}
+	return addrs, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ErrInclusionList is returned when a build can't honour the inclusion list
+var ErrInclusionList = errors.New("inclusion list cannot be satisfied")
+
+// InclusionList names transactions that must be in the block, either by
+// hash (they must be in the pool) or as signed raw transactions
+type InclusionList struct {
+	Hashes []string   `json:"hashes"`
+	Raw    []HexBytes `json:"raw"`
+}
+
+// LoadInclusionList reads an inclusion list JSON file
+func LoadInclusionList(path string) (*InclusionList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l InclusionList
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// Apply forces the list into pool: raw transactions are decoded and
+// admitted regardless of admission filters, and every listed hash becomes
+// required in the next build
+func (l *InclusionList) Apply(pool *TxPool) error {
+	for i, raw := range l.Raw {
+		tx, err := DecodeRawTransaction(raw)
+		if err != nil {
+			return fmt.Errorf("inclusion list raw[%d]: %w", i, err)
+		}
+		pool.Force(tx)
+	}
+	for _, hash := range l.Hashes {
+		pool.Require(strings.ToLower(hash))
+	}
+	return nil
+}
+
+// Require makes hash required in every build until it leaves the pool.
+// Unlike Force it doesn't admit anything: the build fails until hash turns
+// up through the usual admission path.
+func (p *TxPool) Require(hash string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !slices.Contains(p.Required, hash) {
+		p.Required = append(p.Required, hash)
+	}
+}
+
+// Force admits tx bypassing the admission filters and nonce parking, and
+// requires it in every build until it leaves the pool
+func (p *TxPool) Force(tx *Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.AllTxs[tx.Hash]
+	if old != nil {
+		p.unqueue(tx.Hash)
+		p.trackSender(old, false)
+	}
+	p.arrive(tx, old)
+	p.Seen.Mark(tx.Hash)
+	p.stamp(tx)
+	p.AllTxs[tx.Hash] = tx
+	p.account(old, tx)
+	p.trackSender(tx, true)
+	p.enqueue(tx)
+	if !slices.Contains(p.Required, tx.Hash) {
+		p.Required = append(p.Required, tx.Hash)
+	}
+}
+
+// required returns the transactions the block must open with, failing with
+// ErrInclusionList if any is missing, conflicts with another or they don't
+// fit gasLimit together
+func (p *TxPool) required(gasLimit int64, graph *ConflictGraph) ([]*Transaction, error) {
+	if len(p.Required) == 0 {
+		return nil, nil
+	}
+	var txs []*Transaction
+	var problems []string
+	gas := int64(0)
+	short := false
+	ids := map[string]bool{}
+	for _, hash := range p.Required {
+		tx, ok := p.AllTxs[hash]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is not in the pool", hash))
+			continue
+		}
+		if ids[hash] {
+			continue
+		}
+		for _, id := range p.Required {
+			if ids[id] && graph.Neighbors(hash)[id] {
+				problems = append(problems, fmt.Sprintf("%s conflicts with %s", hash, id))
+			}
+		}
+		ids[hash] = true
+		if gas+tx.GasLimit > gasLimit && !short {
+			short = true
+			problems = append(problems, fmt.Sprintf("needs more than the block gas limit of %d", gasLimit))
+		}
+		gas += tx.PackGas()
+		txs = append(txs, tx)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w:\n  %s", ErrInclusionList, strings.Join(problems, "\n  "))
+	}
+	return txs, nil
+}
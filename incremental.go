@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// InsertResult is the outcome of inserting a transaction into a Candidate
+type InsertResult int
+
+const (
+	InsertSkipped InsertResult = iota // not worth what it would evict, or already in
+	Inserted                          // added, possibly evicting others
+	InsertRebuild                     // can't be placed incrementally; rebuild the block
+)
+
+func (r InsertResult) String() string {
+	switch r {
+	case Inserted:
+		return "inserted"
+	case InsertRebuild:
+		return "rebuild"
+	}
+	return "skipped"
+}
+
+// Candidate is the block last built for a slot, kept so transactions
+// arriving mid-slot can be added to it without repacking the whole pool.
+// A newcomer is appended if it fits; otherwise the candidate transactions
+// it conflicts with, then the lowest-scoring ones, are evicted to make
+// room, as long as together they score less than it; one whose sender's
+// earlier nonces were left out is skipped, as a rebuild would leave it out
+// too. Anything that can't be decided locally, such as a newcomer that is
+// itself a bundle, in a lane or required, one displacing a bundle or a
+// nonce its sender's later ones need, or a pool with proposal hooks, calls
+// for a full rebuild instead. A FirstCome candidate, built by "fcfs" or
+// "fair", never evicts: the newcomer only takes what is left, and only if
+// it arrived after everything packed, or with a Bucket in a later bucket,
+// as otherwise a rebuild would place it earlier.
+type Candidate struct {
+	GasLimit  int64
+	Txs       []*Transaction
+	FirstCome bool
+	Bucket    time.Duration   // arrival bucket of "fair"; 0 for "fcfs"
+	injected  map[string]bool // added by proposal hooks rather than pooled
+
+	// The express lane holder and encrypted reservation it was built
+	// with, which every insert keeps to
+	express   *ExpressGrant
+	encrypted *EncryptedSet
+	committed []string
+}
+
+// NewCandidate returns the candidate block txs built from pool
+func NewCandidate(pool *TxPool, gasLimit int64, txs []*Transaction) *Candidate {
+	c := &Candidate{GasLimit: gasLimit, Txs: txs, injected: make(map[string]bool), express: pool.Express, encrypted: pool.Encrypted, committed: pool.Committed}
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	for _, tx := range txs {
+		if len(tx.Bundle) == 0 && pool.AllTxs[tx.Hash] == nil {
+			c.injected[tx.Hash] = true
+		}
+	}
+	return c
+}
+
+// pinned reports whether tx must stay in the candidate whatever arrives
+func (c *Candidate) pinned(pool *TxPool, tx *Transaction) bool {
+	return c.injected[tx.Hash] || slices.Contains(pool.Required, tx.Hash) || pool.inLane(tx)
+}
+
+// Insert tries to add tx, which must be in pool, returning the outcome and
+// the transactions evicted for it. The candidate is only changed if tx is
+// inserted, and the result still validates against pool.
+func (c *Candidate) Insert(pool *TxPool, tx *Transaction) (InsertResult, []*Transaction) {
+	if slices.ContainsFunc(c.Txs, func(t *Transaction) bool { return t.Hash == tx.Hash }) {
+		return InsertSkipped, nil
+	}
+	pool.mu.RLock()
+	result, evicted := c.plan(pool, tx)
+	pool.mu.RUnlock()
+	if result != Inserted {
+		return result, nil
+	}
+	txs := slices.DeleteFunc(slices.Clone(c.Txs), func(t *Transaction) bool { return slices.Contains(evicted, t) })
+	txs = append(txs, tx)
+	pool.mu.RLock()
+	err := pool.validateBlock(c.GasLimit, txs, c.injected)
+	pool.mu.RUnlock()
+	if err != nil {
+		return InsertRebuild, nil
+	}
+	c.Txs = txs
+	return Inserted, evicted
+}
+
+// plan picks what inserting tx would evict; the caller holds pool's read
+// lock
+func (c *Candidate) plan(pool *TxPool, tx *Transaction) (InsertResult, []*Transaction) {
+	if len(pool.Hooks) > 0 || len(tx.Bundle) > 0 || c.pinned(pool, tx) {
+		return InsertRebuild, nil
+	}
+	// last[from] is the highest nonce of each sender in the candidate
+	last := make(map[string]int)
+	in := make(map[string]bool, len(c.Txs))
+	var bundles []*Transaction
+	for _, t := range c.Txs {
+		in[t.Hash] = true
+		if len(t.Bundle) > 0 {
+			bundles = append(bundles, t)
+		}
+		if n, ok := last[t.From]; t.From != "" && (!ok || t.Nonce > n) {
+			last[t.From] = t.Nonce
+		}
+	}
+	if tx.From != "" {
+		// tx must follow its sender's pooled lower nonces, all of them
+		// already in the candidate; one at its own nonce is a conflict
+		if n, ok := last[tx.From]; ok && n > tx.Nonce {
+			return InsertRebuild, nil
+		}
+		for hash, t := range pool.bySender[tx.From] {
+			if t.Nonce < tx.Nonce && !in[hash] {
+				return InsertSkipped, nil
+			}
+		}
+	}
+
+	var evicted []*Transaction
+	score := int64(0)
+	evict := func(t *Transaction) {
+		evicted = append(evicted, t)
+		score += t.Score()
+	}
+	// A transaction can only leave the candidate if it is its sender's
+	// last, or later nonces would be stranded
+	removable := func(t *Transaction) bool {
+		return len(t.Bundle) == 0 && (t.From == "" || last[t.From] == t.Nonce)
+	}
+	graph := pool.conflictGraph(append(bundles, tx)...)
+	neighbors := graph.Neighbors(tx.Hash)
+	reserved := c.encrypted.reserved()
+	if c.FirstCome {
+		after := func(t *Transaction) bool {
+			if c.Bucket > 0 {
+				return arrivalBucket(t, c.Bucket) >= arrivalBucket(tx, c.Bucket)
+			}
+			return arrival(t) > arrival(tx)
+		}
+		if slices.ContainsFunc(c.Txs, func(t *Transaction) bool { return !c.pinned(pool, t) && after(t) }) {
+			return InsertRebuild, nil
+		}
+		used, _ := packedGas(c.Txs)
+		if c.GasLimit-reserved-used < tx.PackGas() || slices.ContainsFunc(c.Txs, func(t *Transaction) bool { return neighbors[t.Hash] }) {
+			return InsertSkipped, nil
+		}
+		return Inserted, nil
+	}
+	for _, t := range c.Txs {
+		if !neighbors[t.Hash] {
+			continue
+		}
+		if c.pinned(pool, t) {
+			return InsertSkipped, nil
+		}
+		if !removable(t) {
+			return InsertRebuild, nil
+		}
+		evict(t)
+	}
+
+	used, _ := packedGas(c.Txs)
+	free := c.GasLimit - reserved - used
+	for _, t := range evicted {
+		free += t.PackGas()
+	}
+	if free < tx.PackGas() {
+		marginal := slices.DeleteFunc(slices.Clone(c.Txs), func(t *Transaction) bool {
+			return neighbors[t.Hash] || c.pinned(pool, t) || !removable(t)
+		})
+		slices.SortFunc(marginal, cmpScore)
+		for _, t := range marginal {
+			if free >= tx.PackGas() {
+				break
+			}
+			evict(t)
+			free += t.PackGas()
+		}
+		if free < tx.PackGas() {
+			return InsertSkipped, nil
+		}
+	}
+	if score >= tx.Score() {
+		return InsertSkipped, nil
+	}
+	return Inserted, evicted
+}
+
+// Arrivals returns the executable transactions admitted after seq (see
+// Transaction.Seq), in arrival order
+func (p *TxPool) Arrivals(seq uint64) []*Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var txs []*Transaction
+	for _, tx := range p.Heap.TxHeap {
+		if tx.Seq > seq {
+			txs = append(txs, tx)
+		}
+	}
+	sortByArrival(txs)
+	return txs
+}
+
+// followArrivals keeps the candidate c, built from the transactions that
+// had arrived by seq, current with those arriving after until ctx is
+// cancelled by the next head. Every interval anything new is inserted
+// into it, or the block rebuilt from the whole pool if a newcomer can't
+// be, a bundle in it has been cancelled or encrypted or committed
+// transactions have been revealed, and publish is called with the block whenever it
+// changes.
+func followArrivals(ctx context.Context, env *Env, pool *ShardedPool, c *Candidate, seq uint64, interval time.Duration, publish func([]*Transaction)) {
+	strategy := env.Config.Builder.Strategy
+	order := func(c *Candidate) {
+		c.FirstCome = strategy == "fcfs" || strategy == "fair"
+		if strategy == "fair" {
+			c.Bucket = time.Duration(env.Config.Builder.Fair.Bucket)
+		}
+	}
+	order(c)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		latest := arrivals.Load()
+		cancelled := pool.Hints.Cancelled(c.Txs)
+		decrypted := env.Encrypted != nil && env.Encrypted.Set(time.Now()).revealedSince(c.encrypted) ||
+			env.Commits != nil && slices.ContainsFunc(env.Commits.Slots(time.Now()), func(h string) bool { return !slices.Contains(c.committed, h) })
+		if latest <= seq && len(cancelled) == 0 && !decrypted {
+			continue
+		}
+		merged := pool.Merge()
+		merged.Express, merged.Encrypted, merged.Committed = c.express, c.encrypted, c.committed
+		newcomers := merged.Arrivals(seq)
+		seq = latest
+		inserted, evicted := 0, 0
+		rebuild := len(cancelled) > 0 || decrypted
+		for _, tx := range newcomers {
+			if rebuild {
+				break
+			}
+			result, out := c.Insert(merged, tx)
+			switch result {
+			case Inserted:
+				inserted++
+				evicted += len(out)
+			case InsertRebuild:
+				rebuild = true
+			}
+		}
+		if rebuild {
+			switch {
+			case len(cancelled) > 0:
+				fmt.Fprintf(env.Out, "Rebuilding without cancelled bundles %s\n", strings.Join(cancelled, ", "))
+			case decrypted:
+				fmt.Fprintln(env.Out, "Rebuilding for decrypted or revealed transactions")
+			default:
+				fmt.Fprintf(env.Out, "Rebuilding for %d transactions arrived mid-slot\n", len(newcomers))
+			}
+			selected, err := buildAndPrint(ctx, env, merged, c.GasLimit)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintf(env.Out, "Error rebuilding block: %v\n", err)
+				}
+				continue
+			}
+			c = NewCandidate(merged, c.GasLimit, selected)
+			order(c)
+			publish(c.Txs)
+			continue
+		}
+		if inserted > 0 {
+			fmt.Fprintf(env.Out, "Inserted %d of %d transactions arrived mid-slot, evicting %d; block value %s\n",
+				inserted, len(newcomers), evicted, FormatWei(candidateValue(c.Txs)))
+			publish(c.Txs)
+		}
+	}
+}
+
+// candidateValue sums the profit of txs
+func candidateValue(txs []*Transaction) int64 {
+	v := int64(0)
+	for _, tx := range txs {
+		v += tx.Profit()
+	}
+	return v
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIngressBatch bounds the requests of one JSON-RPC batch at /rpc
+const maxIngressBatch = 100
+
+// Ingress is a JSON-RPC endpoint wallets can use as their RPC, so users
+// send transactions straight to the builder. eth_sendRawTransaction is
+// decoded, its signature verified, and admitted into the pool: privately
+// for PrivateTTL unless the transaction is also forwarded to Forward, in
+// which case it is public anyway. The read-only eth_, net_ and web3_ calls
+// wallets make around a submission (nonces, gas estimates, receipts) are
+// proxied to Upstream. The txpool_ namespace answers from the builder's
+// own pool, listing only its public transactions.
+type Ingress struct {
+	Pool       Mempool
+	Upstream   *RPCClient    // answers everything but submissions; nil refuses them
+	Forward    []*RPCClient  // nodes submissions are also sent to
+	PrivateTTL time.Duration // how long unforwarded submissions are kept
+	Out        io.Writer     // forwarding failures are reported here
+}
+
+// NewIngress returns an ingress over pool configured from cfg, forwarding
+// with rpc's timeouts and retries
+func NewIngress(pool Mempool, upstream *RPCClient, cfg IngressConfig, rpc RPCConfig, private PrivateConfig) *Ingress {
+	in := &Ingress{Pool: pool, Upstream: upstream, PrivateTTL: time.Duration(private.TTL), Out: io.Discard}
+	for _, url := range cfg.Forward {
+		rpc.Endpoints = []string{url}
+		in.Forward = append(in.Forward, rpc.NewClient())
+	}
+	return in
+}
+
+// ingressRequest is a JSON-RPC request as wallets send it, with any id
+type ingressRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type ingressResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// ServeHTTP answers a JSON-RPC request or batch
+func (in *Ingress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []ingressRequest
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeHTTPJSON(w, ingressResponse{JSONRPC: "2.0", Error: &RPCError{Code: ErrCodeParse, Message: err.Error()}})
+			return
+		}
+		if len(batch) == 0 || len(batch) > maxIngressBatch {
+			writeHTTPJSON(w, ingressResponse{JSONRPC: "2.0", Error: &RPCError{Code: ErrCodeInvalidRequest,
+				Message: fmt.Sprintf("batch must have 1 to %d requests", maxIngressBatch)}})
+			return
+		}
+		resps := make([]ingressResponse, len(batch))
+		for i, req := range batch {
+			resps[i] = in.handle(r.Context(), req)
+		}
+		writeHTTPJSON(w, resps)
+		return
+	}
+	var req ingressRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeHTTPJSON(w, ingressResponse{JSONRPC: "2.0", Error: &RPCError{Code: ErrCodeParse, Message: err.Error()}})
+		return
+	}
+	writeHTTPJSON(w, in.handle(r.Context(), req))
+}
+
+// handle answers one request
+func (in *Ingress) handle(ctx context.Context, req ingressRequest) ingressResponse {
+	resp := ingressResponse{JSONRPC: "2.0", ID: req.ID}
+	var result any
+	var err error
+	switch {
+	case req.Method == "eth_sendRawTransaction":
+		result, err = in.sendRawTransaction(ctx, req.Params)
+	case strings.HasPrefix(req.Method, "txpool_"):
+		result, err = in.txpool(req.Method, req.Params)
+	case in.Upstream != nil && (strings.HasPrefix(req.Method, "eth_") || strings.HasPrefix(req.Method, "net_") || strings.HasPrefix(req.Method, "web3_")):
+		var raw json.RawMessage
+		params := make([]any, len(req.Params))
+		for i, p := range req.Params {
+			params[i] = p
+		}
+		err = in.Upstream.Call(ctx, &raw, req.Method, params...)
+		result = raw
+	default:
+		err = &RPCError{Code: ErrCodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+	}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: ErrCodeUnavailable, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = &RPCError{Code: ErrCodeInternal, Message: err.Error()}
+	}
+	return resp
+}
+
+// sendRawTransaction admits the signed transaction in params and forwards
+// it, returning its hash if the pool or any forward node took it
+func (in *Ingress) sendRawTransaction(ctx context.Context, params []json.RawMessage) (string, error) {
+	received := time.Now()
+	var hexRaw string
+	if len(params) != 1 || json.Unmarshal(params[0], &hexRaw) != nil {
+		return "", &RPCError{Code: ErrCodeInvalidParams, Message: "expected one hex-encoded signed transaction"}
+	}
+	raw, err := ParseHexBytes(hexRaw)
+	if err != nil {
+		return "", &RPCError{Code: ErrCodeInvalidParams, Message: err.Error()}
+	}
+	// Recovering the sender up front lets a sharded pool place it with the
+	// sender's other transactions
+	tx, err := RecoverSender(raw)
+	if err != nil {
+		return "", &RPCError{Code: ErrCodeInvalidParams, Message: fmt.Sprintf("invalid transaction: %v", err)}
+	}
+	tx.ReceivedAt = received
+	ttl := in.PrivateTTL
+	if len(in.Forward) > 0 {
+		ttl = 0
+	}
+	res, reason := in.Pool.Submit(tx, ttl)
+	pooled := res != TxRejected || reason == RejectDuplicate
+
+	forwarded := false
+	var firstErr error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range in.Forward {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var hash string
+			err := node.Call(ctx, &hash, "eth_sendRawTransaction", hexRaw)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(in.Out, "Error forwarding %s to %s: %v\n", tx.Hash, node.Endpoint, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			forwarded = true
+		}()
+	}
+	wg.Wait()
+
+	switch {
+	case pooled || forwarded: // if only forwarded, the network may still include it
+		return tx.Hash, nil
+	case firstErr != nil:
+		return "", firstErr
+	case reason == RejectPoolFull:
+		return "", &RPCError{Code: ErrCodeLimitExceeded, Message: "pool is full, retry later"}
+	}
+	return "", &RPCError{Code: ErrCodeTxRejected, Message: fmt.Sprintf("transaction rejected: %s", reason)}
+}
+
+// txpool answers the txpool_ methods of a geth node from the pool:
+// transactions grouped by sender and then nonce, pending (executable) apart
+// from queued (behind a nonce gap or unaffordable). Transactions of unknown
+// sender are listed under the zero address, and of several at one nonce
+// only the one paying the highest fee cap is.
+func (in *Ingress) txpool(method string, params []json.RawMessage) (any, error) {
+	pending, queued := in.Pool.Content()
+	switch method {
+	case "txpool_status":
+		return map[string]string{
+			"pending": EncodeHexUint64(uint64(len(pending))),
+			"queued":  EncodeHexUint64(uint64(len(queued))),
+		}, nil
+	case "txpool_content":
+		return map[string]any{
+			"pending": txpoolGroup(pending, encodeRPCTransaction),
+			"queued":  txpoolGroup(queued, encodeRPCTransaction),
+		}, nil
+	case "txpool_contentFrom":
+		var addr string
+		if len(params) != 1 || json.Unmarshal(params[0], &addr) != nil {
+			return nil, &RPCError{Code: ErrCodeInvalidParams, Message: "expected an address"}
+		}
+		from, err := HexToAddress(addr)
+		if err != nil {
+			return nil, &RPCError{Code: ErrCodeInvalidParams, Message: err.Error()}
+		}
+		out := map[string]map[string]rpcTransaction{}
+		for state, txs := range map[string][]*Transaction{"pending": pending, "queued": queued} {
+			if out[state] = txpoolGroup(txs, encodeRPCTransaction)[from.Hex()]; out[state] == nil {
+				out[state] = map[string]rpcTransaction{}
+			}
+		}
+		return out, nil
+	case "txpool_inspect":
+		return map[string]any{
+			"pending": txpoolGroup(pending, txpoolSummary),
+			"queued":  txpoolGroup(queued, txpoolSummary),
+		}, nil
+	}
+	return nil, &RPCError{Code: ErrCodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+}
+
+// txpoolGroup maps txs by sender and decimal nonce to what encode makes of
+// them
+func txpoolGroup[T any](txs []*Transaction, encode func(*Transaction) T) map[string]map[string]T {
+	best := map[string]map[int]*Transaction{}
+	for _, tx := range txs {
+		from := Address{}.Hex()
+		if tx.From != "" {
+			from = strings.ToLower(tx.From)
+		}
+		if best[from] == nil {
+			best[from] = map[int]*Transaction{}
+		}
+		if cur := best[from][tx.Nonce]; cur == nil || tx.GasPrice > cur.GasPrice {
+			best[from][tx.Nonce] = tx
+		}
+	}
+	out := make(map[string]map[string]T, len(best))
+	for from, byNonce := range best {
+		out[from] = make(map[string]T, len(byNonce))
+		for nonce, tx := range byNonce {
+			out[from][strconv.Itoa(nonce)] = encode(tx)
+		}
+	}
+	return out
+}
+
+// txpoolSummary describes tx the way txpool_inspect does
+func txpoolSummary(tx *Transaction) string {
+	value := "0"
+	if tx.Value != nil {
+		value = tx.Value.String()
+	}
+	to := "contract creation"
+	if tx.To != "" {
+		to = tx.To
+	}
+	return fmt.Sprintf("%s: %s wei + %d gas × %d wei", to, value, tx.GasLimit, tx.GasPrice)
+}
@@ -0,0 +1,746 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Exceptional halts, which consume all the gas a frame was given, and
+// the revert, which doesn't
+var (
+	errOutOfGas              = errors.New("out of gas")
+	errStackUnderflow        = errors.New("stack underflow")
+	errStackOverflow         = errors.New("stack overflow")
+	errInvalidJump           = errors.New("invalid jump destination")
+	errInvalidOpcode         = errors.New("invalid opcode")
+	errWriteProtection       = errors.New("write protection")
+	errReturnDataOutOfBounds = errors.New("return data out of bounds")
+	errExecutionReverted     = errors.New("execution reverted")
+)
+
+// Gas schedule (Cancun) beyond what opInfo fixes
+const (
+	gasWarmAccess      = 100  // warm account or slot (EIP-2929)
+	gasColdAccount     = 2600 // first access to an account
+	gasColdSload       = 2100 // first access to a slot
+	gasSstoreSet       = 20000
+	gasSstoreReset     = 5000 - gasColdSload
+	gasSstoreSentry    = 2300 // SSTORE fails with no more gas than this left (EIP-2200)
+	gasSstoreClear     = 4800 // refunded for clearing a slot (EIP-3529)
+	gasCallValue       = 9000
+	gasCallStipend     = 2300
+	gasNewAccount      = 25000
+	gasCopyWord        = 3
+	gasKeccakWord      = 6
+	gasExpByte         = 50
+	gasLogTopic        = 375
+	gasLogByte         = 8
+	gasCodeDeposit     = 200 // per byte of deployed code
+	gasInitCodeWord    = 2   // per word of init code (EIP-3860)
+	gasMemoryWord      = 3
+	gasQuadCoeffDiv    = 512
+	maxStack           = 1024
+	maxCallDepth       = 1024
+	maxCodeSize        = 24576
+	maxInitCodeSize    = 2 * maxCodeSize
+	maxMemoryExpansion = 0x1FFFFFFFE0 // past this, memory costs more gas than there is
+)
+
+var (
+	tt256   = new(big.Int).Lsh(big.NewInt(1), 256)
+	tt256m1 = new(big.Int).Sub(tt256, big.NewInt(1))
+	tt255   = new(big.Int).Lsh(big.NewInt(1), 255)
+)
+
+// u256 wraps x to 256 bits in place
+func u256(x *big.Int) *big.Int { return x.And(x, tt256m1) }
+
+// s256 returns x read as a two's complement signed word
+func s256(x *big.Int) *big.Int {
+	if x.Cmp(tt255) < 0 {
+		return x
+	}
+	return new(big.Int).Sub(x, tt256)
+}
+
+func toWordSize(n uint64) uint64 { return (n + 31) / 32 }
+
+func wordBytes(x *big.Int) Hash {
+	var h Hash
+	x.FillBytes(h[:])
+	return h
+}
+
+// wordAddress returns the address in the low 20 bytes of x
+func wordAddress(x *big.Int) Address {
+	h := wordHash(x)
+	return Address(h[12:])
+}
+
+// opInfo is an opcode's fixed gas and stack use
+type opInfo struct {
+	gas     uint64
+	pops    int
+	pushes  int
+	defined bool
+}
+
+var opTable = func() (t [256]opInfo) {
+	set := func(op byte, gas uint64, pops, pushes int) { t[op] = opInfo{gas, pops, pushes, true} }
+	set(0x00, 0, 0, 0) // STOP
+	for _, op := range []byte{0x01, 0x03, 0x10, 0x11, 0x12, 0x13, 0x14, 0x16, 0x17, 0x18, 0x1a, 0x1b, 0x1c, 0x1d} {
+		set(op, 3, 2, 1) // ADD SUB LT GT SLT SGT EQ AND OR XOR BYTE SHL SHR SAR
+	}
+	for _, op := range []byte{0x02, 0x04, 0x05, 0x06, 0x07, 0x0b} {
+		set(op, 5, 2, 1) // MUL DIV SDIV MOD SMOD SIGNEXTEND
+	}
+	set(0x08, 8, 3, 1)  // ADDMOD
+	set(0x09, 8, 3, 1)  // MULMOD
+	set(0x0a, 10, 2, 1) // EXP
+	set(0x15, 3, 1, 1)  // ISZERO
+	set(0x19, 3, 1, 1)  // NOT
+	set(0x20, 30, 2, 1) // KECCAK256
+	for _, op := range []byte{0x30, 0x32, 0x33, 0x34, 0x36, 0x38, 0x3a, 0x3d, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x48, 0x4a, 0x58, 0x59, 0x5a, 0x5f} {
+		set(op, 2, 0, 1) // environment and block values, PC, MSIZE, GAS, PUSH0
+	}
+	set(0x31, 0, 1, 1)   // BALANCE
+	set(0x35, 3, 1, 1)   // CALLDATALOAD
+	set(0x37, 3, 3, 0)   // CALLDATACOPY
+	set(0x39, 3, 3, 0)   // CODECOPY
+	set(0x3b, 0, 1, 1)   // EXTCODESIZE
+	set(0x3c, 0, 4, 0)   // EXTCODECOPY
+	set(0x3e, 3, 3, 0)   // RETURNDATACOPY
+	set(0x3f, 0, 1, 1)   // EXTCODEHASH
+	set(0x40, 20, 1, 1)  // BLOCKHASH
+	set(0x47, 5, 0, 1)   // SELFBALANCE
+	set(0x49, 3, 1, 1)   // BLOBHASH
+	set(0x50, 2, 1, 0)   // POP
+	set(0x51, 3, 1, 1)   // MLOAD
+	set(0x52, 3, 2, 0)   // MSTORE
+	set(0x53, 3, 2, 0)   // MSTORE8
+	set(0x54, 0, 1, 1)   // SLOAD
+	set(0x55, 0, 2, 0)   // SSTORE
+	set(0x56, 8, 1, 0)   // JUMP
+	set(0x57, 10, 2, 0)  // JUMPI
+	set(0x5b, 1, 0, 0)   // JUMPDEST
+	set(0x5c, 100, 1, 1) // TLOAD
+	set(0x5d, 100, 2, 0) // TSTORE
+	set(0x5e, 3, 3, 0)   // MCOPY
+	for n := range 32 {
+		set(byte(0x60+n), 3, 0, 1) // PUSH1-32
+	}
+	for n := 1; n <= 16; n++ {
+		set(byte(0x7f+n), 3, n, n+1)   // DUP1-16
+		set(byte(0x8f+n), 3, n+1, n+1) // SWAP1-16
+	}
+	for n := range 5 {
+		set(byte(0xa0+n), 375+uint64(n)*gasLogTopic, 2+n, 0) // LOG0-4
+	}
+	set(0xf0, 32000, 3, 1) // CREATE
+	set(0xf1, 0, 7, 1)     // CALL
+	set(0xf2, 0, 7, 1)     // CALLCODE
+	set(0xf3, 0, 2, 0)     // RETURN
+	set(0xf4, 0, 6, 1)     // DELEGATECALL
+	set(0xf5, 32000, 4, 1) // CREATE2
+	set(0xfa, 0, 6, 1)     // STATICCALL
+	set(0xfd, 0, 2, 0)     // REVERT
+	set(0xff, 5000, 1, 0)  // SELFDESTRUCT
+	return t
+}()
+
+// frame is one call's execution context
+type frame struct {
+	caller  Address  // msg.sender
+	address Address  // account whose storage and balance the code uses
+	value   *big.Int // msg.value
+	input   []byte
+	code    []byte
+	gas     uint64
+	static  bool
+
+	stack      []*big.Int
+	mem        []byte
+	returnData []byte // of the last call made
+	jumpdests  []bool
+}
+
+func (f *frame) useGas(gas uint64) bool {
+	if f.gas < gas {
+		return false
+	}
+	f.gas -= gas
+	return true
+}
+
+func (f *frame) pop() *big.Int {
+	x := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return x
+}
+
+func (f *frame) push(x *big.Int) { f.stack = append(f.stack, x) }
+
+func (f *frame) pushBool(b bool) {
+	if b {
+		f.push(big.NewInt(1))
+	} else {
+		f.push(new(big.Int))
+	}
+}
+
+func (f *frame) peek(n int) *big.Int { return f.stack[len(f.stack)-1-n] }
+
+// expand charges for growing memory to cover size bytes at offset, then
+// grows it, reporting false if the frame can't pay
+func (f *frame) expand(offset, size *big.Int) bool {
+	if size.Sign() == 0 {
+		return true
+	}
+	if !offset.IsUint64() || !size.IsUint64() || offset.Uint64() > maxMemoryExpansion || size.Uint64() > maxMemoryExpansion {
+		return false
+	}
+	end := offset.Uint64() + size.Uint64()
+	if end > maxMemoryExpansion {
+		return false
+	}
+	if end <= uint64(len(f.mem)) {
+		return true
+	}
+	words := toWordSize(end)
+	if !f.useGas(memoryGas(words) - memoryGas(uint64(len(f.mem))/32)) {
+		return false
+	}
+	f.mem = append(f.mem, make([]byte, words*32-uint64(len(f.mem)))...)
+	return true
+}
+
+func memoryGas(words uint64) uint64 {
+	return gasMemoryWord*words + words*words/gasQuadCoeffDiv
+}
+
+// memory charges for and returns the memory at offset, size bytes long
+func (f *frame) memory(offset, size *big.Int) ([]byte, error) {
+	if !f.expand(offset, size) {
+		return nil, errOutOfGas
+	}
+	if size.Sign() == 0 {
+		return nil, nil
+	}
+	o := offset.Uint64()
+	return f.mem[o : o+size.Uint64()], nil
+}
+
+// copyGas charges perWord for each word of size
+func (f *frame) copyGas(size *big.Int, perWord uint64) error {
+	if !size.IsUint64() || size.Uint64() > maxMemoryExpansion || !f.useGas(perWord*toWordSize(size.Uint64())) {
+		return errOutOfGas
+	}
+	return nil
+}
+
+// slice returns size bytes of data from offset, zero-padded past its end
+func slice(data []byte, offset *big.Int, size uint64) []byte {
+	out := make([]byte, size)
+	if offset.IsUint64() && offset.Uint64() < uint64(len(data)) {
+		copy(out, data[offset.Uint64():])
+	}
+	return out
+}
+
+// analyzeJumpdests marks the JUMPDESTs of code that aren't push data
+func analyzeJumpdests(code []byte) []bool {
+	dests := make([]bool, len(code))
+	for pc := 0; pc < len(code); pc++ {
+		op := code[pc]
+		if op == 0x5b {
+			dests[pc] = true
+		} else if op >= 0x60 && op <= 0x7f {
+			pc += int(op - 0x5f)
+		}
+	}
+	return dests
+}
+
+// accessAccount charges for touching addr, warming it
+func (e *evm) accessAccount(f *frame, addr Address) bool {
+	if e.state.addressWarm(addr) {
+		return f.useGas(gasWarmAccess)
+	}
+	e.state.warmAddress(addr)
+	return f.useGas(gasColdAccount)
+}
+
+// run executes f's code, returning its output
+func (e *evm) run(f *frame) ([]byte, error) {
+	if len(f.code) == 0 {
+		return nil, nil
+	}
+	f.jumpdests = analyzeJumpdests(f.code)
+	s := e.state
+	for pc := 0; pc < len(f.code); pc++ {
+		if s.err != nil {
+			return nil, s.err
+		}
+		op := f.code[pc]
+		info := &opTable[op]
+		if !info.defined {
+			return nil, errInvalidOpcode
+		}
+		if len(f.stack) < info.pops {
+			return nil, errStackUnderflow
+		}
+		if len(f.stack)-info.pops+info.pushes > maxStack {
+			return nil, errStackOverflow
+		}
+		if !f.useGas(info.gas) {
+			return nil, errOutOfGas
+		}
+
+		switch {
+		case op >= 0x60 && op <= 0x7f: // PUSH1-32
+			n := int(op - 0x5f)
+			end := min(pc+1+n, len(f.code))
+			v := new(big.Int).SetBytes(f.code[pc+1 : end])
+			v.Lsh(v, uint(8*(pc+1+n-end)))
+			f.push(v)
+			pc += n
+			continue
+		case op >= 0x80 && op <= 0x8f: // DUP
+			f.push(new(big.Int).Set(f.peek(int(op - 0x80))))
+			continue
+		case op >= 0x90 && op <= 0x9f: // SWAP
+			n := len(f.stack) - 1
+			m := n - int(op-0x8f)
+			f.stack[n], f.stack[m] = f.stack[m], f.stack[n]
+			continue
+		case op >= 0xa0 && op <= 0xa4: // LOG
+			if f.static {
+				return nil, errWriteProtection
+			}
+			offset, size := f.pop(), f.pop()
+			topics := make([]Hash, op-0xa0)
+			for i := range topics {
+				topics[i] = wordHash(f.pop())
+			}
+			if !size.IsUint64() || size.Uint64() > maxMemoryExpansion || !f.useGas(gasLogByte*size.Uint64()) {
+				return nil, errOutOfGas
+			}
+			data, err := f.memory(offset, size)
+			if err != nil {
+				return nil, err
+			}
+			s.addLog(Log{Address: f.address, Topics: topics, Data: append(HexBytes{}, data...)})
+			continue
+		}
+
+		switch op {
+		case 0x00: // STOP
+			return nil, nil
+		case 0x01: // ADD
+			x, y := f.pop(), f.peek(0)
+			u256(y.Add(x, y))
+		case 0x02: // MUL
+			x, y := f.pop(), f.peek(0)
+			u256(y.Mul(x, y))
+		case 0x03: // SUB
+			x, y := f.pop(), f.peek(0)
+			u256(y.Sub(x, y))
+		case 0x04: // DIV
+			x, y := f.pop(), f.peek(0)
+			if y.Sign() == 0 {
+				y.SetUint64(0)
+			} else {
+				y.Quo(x, y)
+			}
+		case 0x05: // SDIV
+			x, y := s256(f.pop()), s256(f.pop())
+			if y.Sign() == 0 {
+				f.push(new(big.Int))
+			} else {
+				f.push(u256(new(big.Int).Quo(x, y)))
+			}
+		case 0x06: // MOD
+			x, y := f.pop(), f.peek(0)
+			if y.Sign() == 0 {
+				y.SetUint64(0)
+			} else {
+				y.Mod(x, y)
+			}
+		case 0x07: // SMOD
+			x, y := s256(f.pop()), s256(f.pop())
+			if y.Sign() == 0 {
+				f.push(new(big.Int))
+			} else {
+				f.push(u256(new(big.Int).Rem(x, y)))
+			}
+		case 0x08, 0x09: // ADDMOD, MULMOD
+			x, y, m := f.pop(), f.pop(), f.peek(0)
+			if m.Sign() == 0 {
+				m.SetUint64(0)
+			} else if op == 0x08 {
+				m.Mod(x.Add(x, y), m)
+			} else {
+				m.Mod(x.Mul(x, y), m)
+			}
+		case 0x0a: // EXP
+			base, exp := f.pop(), f.peek(0)
+			if !f.useGas(gasExpByte * uint64((exp.BitLen()+7)/8)) {
+				return nil, errOutOfGas
+			}
+			exp.Exp(base, exp, tt256)
+		case 0x0b: // SIGNEXTEND
+			b, x := f.pop(), f.peek(0)
+			if b.Cmp(big.NewInt(31)) < 0 {
+				bit := uint(8*b.Uint64() + 7)
+				mask := new(big.Int).Lsh(big.NewInt(1), bit)
+				mask.Sub(mask, big.NewInt(1))
+				if x.Bit(int(bit)) == 1 {
+					u256(x.Or(x, mask.Not(mask)))
+				} else {
+					x.And(x, mask)
+				}
+			}
+		case 0x10: // LT
+			x, y := f.pop(), f.pop()
+			f.pushBool(x.Cmp(y) < 0)
+		case 0x11: // GT
+			x, y := f.pop(), f.pop()
+			f.pushBool(x.Cmp(y) > 0)
+		case 0x12: // SLT
+			x, y := s256(f.pop()), s256(f.pop())
+			f.pushBool(x.Cmp(y) < 0)
+		case 0x13: // SGT
+			x, y := s256(f.pop()), s256(f.pop())
+			f.pushBool(x.Cmp(y) > 0)
+		case 0x14: // EQ
+			x, y := f.pop(), f.pop()
+			f.pushBool(x.Cmp(y) == 0)
+		case 0x15: // ISZERO
+			f.pushBool(f.pop().Sign() == 0)
+		case 0x16: // AND
+			x, y := f.pop(), f.peek(0)
+			y.And(x, y)
+		case 0x17: // OR
+			x, y := f.pop(), f.peek(0)
+			y.Or(x, y)
+		case 0x18: // XOR
+			x, y := f.pop(), f.peek(0)
+			y.Xor(x, y)
+		case 0x19: // NOT
+			x := f.peek(0)
+			x.Xor(x, tt256m1)
+		case 0x1a: // BYTE
+			i, x := f.pop(), f.peek(0)
+			if i.Cmp(big.NewInt(32)) < 0 {
+				x.SetUint64(uint64(wordBytes(x)[i.Uint64()]))
+			} else {
+				x.SetUint64(0)
+			}
+		case 0x1b, 0x1c, 0x1d: // SHL, SHR, SAR
+			shift, x := f.pop(), f.peek(0)
+			n := uint(256)
+			if shift.IsUint64() && shift.Uint64() < 256 {
+				n = uint(shift.Uint64())
+			}
+			switch op {
+			case 0x1b:
+				u256(x.Lsh(x, n))
+			case 0x1c:
+				x.Rsh(x, n)
+			default:
+				x.Set(u256(new(big.Int).Rsh(s256(x), n)))
+			}
+		case 0x20: // KECCAK256
+			offset, size := f.pop(), f.pop()
+			if err := f.copyGas(size, gasKeccakWord); err != nil {
+				return nil, err
+			}
+			data, err := f.memory(offset, size)
+			if err != nil {
+				return nil, err
+			}
+			h := Keccak256(data)
+			f.push(new(big.Int).SetBytes(h[:]))
+		case 0x30: // ADDRESS
+			f.push(new(big.Int).SetBytes(f.address[:]))
+		case 0x31: // BALANCE
+			addr := wordAddress(f.pop())
+			if !e.accessAccount(f, addr) {
+				return nil, errOutOfGas
+			}
+			f.push(new(big.Int).Set(s.balance(addr)))
+		case 0x32: // ORIGIN
+			f.push(new(big.Int).SetBytes(e.origin[:]))
+		case 0x33: // CALLER
+			f.push(new(big.Int).SetBytes(f.caller[:]))
+		case 0x34: // CALLVALUE
+			f.push(new(big.Int).Set(f.value))
+		case 0x35: // CALLDATALOAD
+			x := f.peek(0)
+			x.SetBytes(slice(f.input, x, 32))
+		case 0x36: // CALLDATASIZE
+			f.push(big.NewInt(int64(len(f.input))))
+		case 0x37, 0x39: // CALLDATACOPY, CODECOPY
+			memOffset, offset, size := f.pop(), f.pop(), f.pop()
+			if err := f.copyGas(size, gasCopyWord); err != nil {
+				return nil, err
+			}
+			mem, err := f.memory(memOffset, size)
+			if err != nil {
+				return nil, err
+			}
+			src := f.input
+			if op == 0x39 {
+				src = f.code
+			}
+			copy(mem, slice(src, offset, uint64(len(mem))))
+		case 0x38: // CODESIZE
+			f.push(big.NewInt(int64(len(f.code))))
+		case 0x3a: // GASPRICE
+			f.push(new(big.Int).Set(e.gasPrice))
+		case 0x3b: // EXTCODESIZE
+			addr := wordAddress(f.pop())
+			if !e.accessAccount(f, addr) {
+				return nil, errOutOfGas
+			}
+			f.push(big.NewInt(int64(len(s.code(addr)))))
+		case 0x3c: // EXTCODECOPY
+			addr := wordAddress(f.pop())
+			memOffset, offset, size := f.pop(), f.pop(), f.pop()
+			if !e.accessAccount(f, addr) {
+				return nil, errOutOfGas
+			}
+			if err := f.copyGas(size, gasCopyWord); err != nil {
+				return nil, err
+			}
+			mem, err := f.memory(memOffset, size)
+			if err != nil {
+				return nil, err
+			}
+			copy(mem, slice(s.code(addr), offset, uint64(len(mem))))
+		case 0x3d: // RETURNDATASIZE
+			f.push(big.NewInt(int64(len(f.returnData))))
+		case 0x3e: // RETURNDATACOPY
+			memOffset, offset, size := f.pop(), f.pop(), f.pop()
+			end := new(big.Int).Add(offset, size)
+			if !end.IsUint64() || end.Uint64() > uint64(len(f.returnData)) {
+				return nil, errReturnDataOutOfBounds
+			}
+			if err := f.copyGas(size, gasCopyWord); err != nil {
+				return nil, err
+			}
+			mem, err := f.memory(memOffset, size)
+			if err != nil {
+				return nil, err
+			}
+			copy(mem, f.returnData[offset.Uint64():])
+		case 0x3f: // EXTCODEHASH
+			addr := wordAddress(f.pop())
+			if !e.accessAccount(f, addr) {
+				return nil, errOutOfGas
+			}
+			h := s.codeHash(addr)
+			f.push(new(big.Int).SetBytes(h[:]))
+		case 0x40: // BLOCKHASH
+			x := f.peek(0)
+			h, err := e.block.blockHash(x)
+			if err != nil {
+				s.fail(fmt.Errorf("BLOCKHASH of block %s: %w", x, err))
+				return nil, err
+			}
+			x.SetBytes(h[:])
+		case 0x41: // COINBASE
+			f.push(new(big.Int).SetBytes(e.block.Coinbase[:]))
+		case 0x42: // TIMESTAMP
+			f.push(new(big.Int).SetUint64(e.block.Time))
+		case 0x43: // NUMBER
+			f.push(new(big.Int).SetUint64(e.block.Number))
+		case 0x44: // PREVRANDAO: zero, as the node simulates with
+			f.push(new(big.Int))
+		case 0x45: // GASLIMIT
+			f.push(big.NewInt(e.block.GasLimit))
+		case 0x46: // CHAINID
+			f.push(new(big.Int).SetUint64(e.block.ChainID))
+		case 0x47: // SELFBALANCE
+			f.push(new(big.Int).Set(s.balance(f.address)))
+		case 0x48: // BASEFEE
+			f.push(big.NewInt(e.block.BaseFee))
+		case 0x49: // BLOBHASH: calls carry no blobs
+			f.peek(0).SetUint64(0)
+		case 0x4a: // BLOBBASEFEE: the excess blob gas isn't tracked
+			s.fail(fmt.Errorf("BLOBBASEFEE: %w", errUnsupported))
+			return nil, errUnsupported
+		case 0x50: // POP
+			f.pop()
+		case 0x51: // MLOAD
+			x := f.peek(0)
+			mem, err := f.memory(x, big.NewInt(32))
+			if err != nil {
+				return nil, err
+			}
+			x.SetBytes(mem)
+		case 0x52: // MSTORE
+			offset, v := f.pop(), f.pop()
+			mem, err := f.memory(offset, big.NewInt(32))
+			if err != nil {
+				return nil, err
+			}
+			v.FillBytes(mem)
+		case 0x53: // MSTORE8
+			offset, v := f.pop(), f.pop()
+			mem, err := f.memory(offset, big.NewInt(1))
+			if err != nil {
+				return nil, err
+			}
+			mem[0] = byte(v.Uint64())
+		case 0x54: // SLOAD
+			x := f.peek(0)
+			slot := wordHash(x)
+			gas := uint64(gasWarmAccess)
+			if !s.slotWarm(f.address, slot) {
+				s.warmSlot(f.address, slot)
+				gas = gasColdSload
+			}
+			if !f.useGas(gas) {
+				return nil, errOutOfGas
+			}
+			v := s.storage(f.address, slot)
+			x.SetBytes(v[:])
+		case 0x55: // SSTORE
+			if f.static {
+				return nil, errWriteProtection
+			}
+			if err := e.sstore(f, wordHash(f.pop()), wordHash(f.pop())); err != nil {
+				return nil, err
+			}
+		case 0x56: // JUMP
+			dest := f.pop()
+			if !dest.IsUint64() || dest.Uint64() >= uint64(len(f.code)) || !f.jumpdests[dest.Uint64()] {
+				return nil, errInvalidJump
+			}
+			pc = int(dest.Uint64())
+			continue
+		case 0x57: // JUMPI
+			dest, cond := f.pop(), f.pop()
+			if cond.Sign() != 0 {
+				if !dest.IsUint64() || dest.Uint64() >= uint64(len(f.code)) || !f.jumpdests[dest.Uint64()] {
+					return nil, errInvalidJump
+				}
+				pc = int(dest.Uint64())
+				continue
+			}
+		case 0x58: // PC
+			f.push(big.NewInt(int64(pc)))
+		case 0x59: // MSIZE
+			f.push(big.NewInt(int64(len(f.mem))))
+		case 0x5a: // GAS
+			f.push(new(big.Int).SetUint64(f.gas))
+		case 0x5b: // JUMPDEST
+		case 0x5c: // TLOAD
+			x := f.peek(0)
+			v := s.transientStorage(f.address, wordHash(x))
+			x.SetBytes(v[:])
+		case 0x5d: // TSTORE
+			if f.static {
+				return nil, errWriteProtection
+			}
+			slot, v := f.pop(), f.pop()
+			s.setTransientStorage(f.address, wordHash(slot), wordHash(v))
+		case 0x5e: // MCOPY
+			dst, src, size := f.pop(), f.pop(), f.pop()
+			if err := f.copyGas(size, gasCopyWord); err != nil {
+				return nil, err
+			}
+			end := dst
+			if src.Cmp(dst) > 0 {
+				end = src
+			}
+			if _, err := f.memory(end, size); err != nil {
+				return nil, err
+			}
+			if size.Sign() > 0 {
+				n := size.Uint64()
+				copy(f.mem[dst.Uint64():dst.Uint64()+n], f.mem[src.Uint64():src.Uint64()+n])
+			}
+		case 0x5f: // PUSH0
+			f.push(new(big.Int))
+		case 0xf0, 0xf5: // CREATE, CREATE2
+			if err := e.opCreate(f, op == 0xf5); err != nil {
+				return nil, err
+			}
+		case 0xf1, 0xf2, 0xf4, 0xfa: // CALL, CALLCODE, DELEGATECALL, STATICCALL
+			if err := e.opCall(f, op); err != nil {
+				return nil, err
+			}
+		case 0xf3, 0xfd: // RETURN, REVERT
+			offset, size := f.pop(), f.pop()
+			mem, err := f.memory(offset, size)
+			if err != nil {
+				return nil, err
+			}
+			out := append([]byte(nil), mem...)
+			if op == 0xfd {
+				return out, errExecutionReverted
+			}
+			return out, nil
+		case 0xff: // SELFDESTRUCT
+			if f.static {
+				return nil, errWriteProtection
+			}
+			if err := e.selfDestruct(f, wordAddress(f.pop())); err != nil {
+				return nil, err
+			}
+			return nil, s.err
+		}
+	}
+	return nil, s.err
+}
+
+// sstore charges for and makes a storage write, as EIP-2200 prices it
+// with EIP-2929 access costs and EIP-3529 refunds
+func (e *evm) sstore(f *frame, slot, v Hash) error {
+	s := e.state
+	if f.gas <= gasSstoreSentry {
+		return errOutOfGas
+	}
+	var gas uint64
+	if !s.slotWarm(f.address, slot) {
+		s.warmSlot(f.address, slot)
+		gas = gasColdSload
+	}
+	current, original := s.storage(f.address, slot), s.original(f.address, slot)
+	var zero Hash
+	switch {
+	case current == v:
+		gas += gasWarmAccess
+	case original == current && original == zero:
+		gas += gasSstoreSet
+	case original == current:
+		gas += gasSstoreReset
+		if v == zero {
+			s.addRefund(gasSstoreClear)
+		}
+	default:
+		gas += gasWarmAccess
+		if original != zero {
+			if current == zero {
+				s.subRefund(gasSstoreClear)
+			} else if v == zero {
+				s.addRefund(gasSstoreClear)
+			}
+		}
+		if original == v {
+			if original == zero {
+				s.addRefund(gasSstoreSet - gasWarmAccess)
+			} else {
+				s.addRefund(gasSstoreReset - gasWarmAccess)
+			}
+		}
+	}
+	if !f.useGas(gas) {
+		return errOutOfGas
+	}
+	s.setStorage(f.address, slot, v)
+	return nil
+}
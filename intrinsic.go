@@ -0,0 +1,47 @@
+package main
+
+import "slices"
+
+// Intrinsic gas schedule (Shanghai): what a transaction is charged before
+// any of its code runs, on top of txBaseGas
+const (
+	txCreateGas             = 32000 // contract creation
+	txDataZeroGas           = 4     // per zero calldata byte
+	txDataNonZeroGas        = 16    // per nonzero calldata byte
+	txInitCodeWordGas       = 2     // per 32-byte word of init code (EIP-3860)
+	accessListAddressGas    = 2400  // per access list address (EIP-2930)
+	accessListStorageKeyGas = 1900  // per access list storage key
+)
+
+// AccessTuple is an EIP-2930 access list entry
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+func equalAccessLists(a, b []AccessTuple) bool {
+	return slices.EqualFunc(a, b, func(x, y AccessTuple) bool {
+		return x.Address == y.Address && slices.Equal(x.StorageKeys, y.StorageKeys)
+	})
+}
+
+// IntrinsicGas returns the gas tx is charged up front for its calldata,
+// access list and, if it is known to create a contract, its init code. A
+// gas limit below it can never be valid.
+func (tx *Transaction) IntrinsicGas() int64 {
+	gas := int64(txBaseGas)
+	for _, b := range tx.Input {
+		if b == 0 {
+			gas += txDataZeroGas
+		} else {
+			gas += txDataNonZeroGas
+		}
+	}
+	if tx.Create {
+		gas += txCreateGas + txInitCodeWordGas*int64((len(tx.Input)+31)/32)
+	}
+	for _, t := range tx.AccessList {
+		gas += accessListAddressGas + accessListStorageKeyGas*int64(len(t.StorageKeys))
+	}
+	return gas
+}
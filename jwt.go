@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtRefresh is how long a token is reused before a fresh one is signed.
+// Execution clients refuse a token issued more than 60 seconds either side
+// of their clock, so this leaves half of that for clock drift.
+const jwtRefresh = 30 * time.Second
+
+// jwtMinSkew is the least clock offset acted on; the Date header it is
+// measured from only has second resolution
+const jwtMinSkew = 5 * time.Second
+
+// JWTAuth signs requests to an execution client's authenticated RPC port,
+// which serves the Engine API and the eth namespace, with the HS256 bearer
+// tokens it requires: an "iat" claim of the time of issue, signed with the
+// shared secret of the client's jwtsecret file. A token is reused for
+// jwtRefresh. If the node's clock is off from ours, as learned from the
+// Date header of a response refusing a token, tokens are issued at the
+// node's time instead. Its methods are safe for concurrent use.
+type JWTAuth struct {
+	secret []byte
+
+	mu     sync.Mutex
+	token  string
+	issued time.Time     // by our clock
+	skew   time.Duration // node's clock less ours
+}
+
+// LoadJWTSecret reads a jwtsecret file: 32 bytes in hex, with or without a
+// 0x prefix, as execution clients write it
+func LoadJWTSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("%s: secret is %d bytes, want 32", path, len(secret))
+	}
+	return secret, nil
+}
+
+// NewJWTAuth returns an authenticator signing with secret
+func NewJWTAuth(secret []byte) *JWTAuth {
+	return &JWTAuth{secret: secret}
+}
+
+// Token returns a token valid at now, signing a fresh one if the last is
+// older than jwtRefresh
+func (a *JWTAuth) Token(now time.Time) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" || now.Sub(a.issued) >= jwtRefresh || now.Before(a.issued) {
+		a.token = signJWT(a.secret, now.Add(a.skew))
+		a.issued = now
+	}
+	return a.token
+}
+
+// Authorize sets req's bearer token; a nil JWTAuth leaves it unsigned
+func (a *JWTAuth) Authorize(req *http.Request) {
+	if a != nil {
+		req.Header.Set("Authorization", "Bearer "+a.Token(time.Now()))
+	}
+}
+
+// Resync measures the node's clock from the Date header of resp, which
+// refused a token, and reports whether a token signed for that time might
+// be accepted where the last was not: the offset has moved by jwtMinSkew
+// or more since it was last measured.
+func (a *JWTAuth) Resync(resp *http.Response, now time.Time) bool {
+	if a == nil {
+		return false
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return false
+	}
+	skew := date.Sub(now)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if (skew - a.skew).Abs() < jwtMinSkew {
+		return false
+	}
+	a.skew, a.token = skew, ""
+	return true
+}
+
+// signJWT returns an HS256 token issued at iat
+func signJWT(secret []byte, iat time.Time) string {
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString(fmt.Appendf(nil, `{"iat":%d}`, iat.Unix()))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Keccak-256 as used by Ethereum (original Keccak padding, not SHA3-256)
+
+const keccak256Rate = 136
+
+var keccakRC = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+var keccakRotc = [24]int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55, 2, 14, 27, 41, 56, 8, 25, 43, 62, 18, 39, 61, 20, 44}
+
+var keccakPiln = [24]int{10, 7, 11, 17, 18, 3, 5, 16, 8, 21, 24, 4, 15, 23, 19, 13, 12, 2, 20, 14, 22, 9, 6, 1}
+
+// keccakF1600 applies the Keccak-f[1600] permutation to the state
+func keccakF1600(st *[25]uint64) {
+	var bc [5]uint64
+	for round := 0; round < 24; round++ {
+		// Theta
+		for i := 0; i < 5; i++ {
+			bc[i] = st[i] ^ st[i+5] ^ st[i+10] ^ st[i+15] ^ st[i+20]
+		}
+		for i := 0; i < 5; i++ {
+			t := bc[(i+4)%5] ^ bits.RotateLeft64(bc[(i+1)%5], 1)
+			for j := 0; j < 25; j += 5 {
+				st[j+i] ^= t
+			}
+		}
+		// Rho and pi
+		t := st[1]
+		for i := 0; i < 24; i++ {
+			j := keccakPiln[i]
+			bc[0] = st[j]
+			st[j] = bits.RotateLeft64(t, keccakRotc[i])
+			t = bc[0]
+		}
+		// Chi
+		for j := 0; j < 25; j += 5 {
+			for i := 0; i < 5; i++ {
+				bc[i] = st[j+i]
+			}
+			for i := 0; i < 5; i++ {
+				st[j+i] ^= ^bc[(i+1)%5] & bc[(i+2)%5]
+			}
+		}
+		// Iota
+		st[0] ^= keccakRC[round]
+	}
+}
+
+// Keccak256 returns the Keccak-256 hash of the concatenated inputs
+func Keccak256(data ...[]byte) Hash {
+	var k keccakState
+	for _, d := range data {
+		k.Write(d)
+	}
+	return k.Sum()
+}
+
+// keccakState is an incremental Keccak-256, as the RLPx frame MACs need:
+// data can be written after taking a Sum
+type keccakState struct {
+	st    [25]uint64
+	block [keccak256Rate]byte
+	n     int
+}
+
+func (k *keccakState) absorb() {
+	for i := 0; i < keccak256Rate/8; i++ {
+		k.st[i] ^= binary.LittleEndian.Uint64(k.block[i*8:])
+	}
+	keccakF1600(&k.st)
+	k.n = 0
+}
+
+// Write absorbs d
+func (k *keccakState) Write(d []byte) {
+	for len(d) > 0 {
+		c := copy(k.block[k.n:], d)
+		k.n += c
+		d = d[c:]
+		if k.n == keccak256Rate {
+			k.absorb()
+		}
+	}
+}
+
+// Sum returns the hash of everything written so far, leaving the state as
+// it was
+func (k *keccakState) Sum() Hash {
+	f := *k
+	// Pad: 0x01 ... 0x80 (merged into one byte when only one is left)
+	clear(f.block[f.n:])
+	f.block[f.n] ^= 0x01
+	f.block[keccak256Rate-1] ^= 0x80
+	f.absorb()
+
+	var h Hash
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(h[i*8:], f.st[i])
+	}
+	return h
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Lane reserves the top of every block for a class of transactions, such
+// as oracle price updates or system calls. Lanes are filled in config
+// order before anything else is packed, ordered by sender and nonce rather
+// than profit, up to MaxTxs transactions and MaxGas gas each. Matching
+// transactions that don't fit compete for the rest of the block normally.
+type Lane struct {
+	Config    LaneConfig
+	to        map[string]bool
+	from      map[string]bool
+	selectors [][]byte
+}
+
+// NewLanes compiles lane configs
+func NewLanes(cfgs []LaneConfig) ([]*Lane, error) {
+	lanes := make([]*Lane, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		l := &Lane{Config: cfg, to: map[string]bool{}, from: map[string]bool{}}
+		for _, a := range cfg.To {
+			l.to[strings.ToLower(a)] = true
+		}
+		for _, a := range cfg.From {
+			l.from[strings.ToLower(a)] = true
+		}
+		for _, s := range cfg.Selectors {
+			sel, err := ParseHexBytes(s)
+			if err != nil || len(sel) != 4 {
+				return nil, fmt.Errorf("lane %s: selector %q is not 4 bytes of hex", cfg.Name, s)
+			}
+			l.selectors = append(l.selectors, sel)
+		}
+		lanes = append(lanes, l)
+	}
+	return lanes, nil
+}
+
+// Match reports whether tx belongs in the lane: it must be sent from a
+// listed sender or to a listed recipient (if either list is set) and call
+// a listed selector (if any are set)
+func (l *Lane) Match(tx *Transaction) bool {
+	if len(l.to) > 0 || len(l.from) > 0 {
+		if !l.to[tx.To] && !l.from[tx.From] {
+			return false
+		}
+	}
+	if len(l.selectors) > 0 {
+		return len(tx.Input) >= 4 && slices.ContainsFunc(l.selectors, func(sel []byte) bool {
+			return bytes.Equal(tx.Input[:4], sel)
+		})
+	}
+	return len(l.to) > 0 || len(l.from) > 0
+}
+
+// fillLanes picks the lane transactions that open the block, within
+// gasLimit, skipping those already used and conflicts with them
+func (p *TxPool) fillLanes(gasLimit int64, used map[string]bool, graph *ConflictGraph) []*Transaction {
+	if len(p.Lanes) == 0 {
+		return nil
+	}
+	candidates := make([]*Transaction, 0)
+	for hash, tx := range p.AllTxs {
+		if _, parked := p.Queued[hash]; !parked {
+			candidates = append(candidates, tx)
+		}
+	}
+	slices.SortFunc(candidates, func(a, b *Transaction) int {
+		if c := strings.Compare(a.From, b.From); c != 0 {
+			return c
+		}
+		if a.Nonce != b.Nonce {
+			return a.Nonce - b.Nonce
+		}
+		return strings.Compare(a.Hash, b.Hash)
+	})
+
+	var selected []*Transaction
+	used = maps.Clone(used)
+	usedGas := int64(0)
+	for _, lane := range p.Lanes {
+		n, gas := 0, int64(0)
+		for _, tx := range candidates {
+			if lane.Config.MaxTxs > 0 && n >= lane.Config.MaxTxs {
+				break
+			}
+			if used[tx.Hash] || !lane.Match(tx) || graph.Conflicts(tx.Hash, used) {
+				continue
+			}
+			if (lane.Config.MaxGas > 0 && gas+tx.GasLimit > lane.Config.MaxGas) || usedGas+tx.GasLimit > gasLimit {
+				continue
+			}
+			used[tx.Hash] = true
+			n++
+			gas += tx.PackGas()
+			usedGas += tx.PackGas()
+			selected = append(selected, tx)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// lifecycleRecent is how many finished lifecycles are kept for lookup and
+// latency quantiles
+const lifecycleRecent = 1000
+
+// lifecycleBuckets are the upper bounds, in blocks, of the inclusion
+// latency histogram
+var lifecycleBuckets = []uint64{1, 2, 3, 5, 10, 20, 50, 100}
+
+// Outcome is how a transaction left the pool
+type Outcome string
+
+const (
+	OutcomeIncluded       Outcome = "included"        // mined while in the block we last built
+	OutcomeMinedElsewhere Outcome = "mined-elsewhere" // mined while we were leaving it out
+	OutcomeEvicted        Outcome = "evicted"         // dropped without being mined: replaced, dropped upstream or expired
+)
+
+// SkipReason is why a build left a pooled transaction out
+type SkipReason string
+
+const (
+	SkipQueued    SkipReason = "queued"     // behind a nonce gap or more than its sender can pay
+	SkipLow       SkipReason = "low-tip"    // below the tip floor and outpaced by the rest
+	SkipExcluded  SkipReason = "excluded"   // its bundle was excluded, e.g. by revert protection
+	SkipNotPacked SkipReason = "not-packed" // executable but outscored, conflicting or out of gas
+)
+
+// TxLifecycle is what happened to one transaction between its arrival and
+// its departure from the pool
+type TxLifecycle struct {
+	Hash       string     `json:"hash"`
+	FirstSeen  time.Time  `json:"firstSeen"`
+	FirstBlock uint64     `json:"firstBlock"`          // pending block it arrived at
+	Considered int        `json:"considered"`          // builds it was pooled for
+	Selected   int        `json:"selected"`            // builds that packed it
+	Skip       SkipReason `json:"skip,omitempty"`      // why the last build it missed left it out
+	Outcome    Outcome    `json:"outcome,omitempty"`   // set once it left the pool
+	LeftBlock  uint64     `json:"leftBlock,omitempty"` // head it left the pool at
+	LeftAt     time.Time  `json:"leftAt,omitzero"`
+
+	inLast bool // packed by the last build
+}
+
+// LifecycleStats aggregates the lifecycles of the transactions that left
+// the pool, and its churn per head
+type LifecycleStats struct {
+	Tracked  int                `json:"tracked"` // pooled transactions being followed
+	Outcomes map[Outcome]int    `json:"outcomes"`
+	Skips    map[SkipReason]int `json:"skips"` // reasons builds left pooled transactions out
+
+	// Latency is the inclusion latency of mined transactions, in blocks
+	// from arrival; Buckets counts those within each bound, cumulatively
+	Latency struct {
+		Count   int            `json:"count"`
+		Sum     uint64         `json:"sum"`
+		Buckets map[uint64]int `json:"buckets"`
+		P50     uint64         `json:"p50"` // over the recent mined transactions
+		P90     uint64         `json:"p90"`
+		P99     uint64         `json:"p99"`
+		Seconds float64        `json:"meanSeconds"`
+	} `json:"latency"`
+
+	// Heads synced, the transactions added and removed over them, and the
+	// mean share of the pool replaced per head
+	Heads     int     `json:"heads"`
+	Added     int     `json:"added"`
+	Removed   int     `json:"removed"`
+	ChurnRate float64 `json:"churnRate"`
+
+	// InclusionRate is the share of mined transactions we had packed
+	InclusionRate float64 `json:"inclusionRate"`
+}
+
+// Lifecycle follows every pooled transaction from arrival through the
+// builds that considered it to how it left the pool, and aggregates the
+// outcomes. Its methods are safe for concurrent use.
+type Lifecycle struct {
+	mu      sync.Mutex
+	live    map[string]*TxLifecycle
+	recent  []*TxLifecycle // finished, oldest first
+	stats   LifecycleStats
+	latency time.Duration // summed over mined transactions
+	churn   float64       // summed share of the pool removed per head
+}
+
+func NewLifecycle() *Lifecycle {
+	l := &Lifecycle{live: make(map[string]*TxLifecycle)}
+	l.stats.Outcomes = map[Outcome]int{}
+	l.stats.Skips = map[SkipReason]int{}
+	l.stats.Latency.Buckets = map[uint64]int{}
+	return l
+}
+
+// track returns the lifecycle of tx, starting one if it is new
+func (l *Lifecycle) track(tx *Transaction, head uint64) *TxLifecycle {
+	t := l.live[tx.Hash]
+	if t == nil {
+		t = &TxLifecycle{Hash: tx.Hash, FirstSeen: tx.SeenAt, FirstBlock: tx.SeenBlock}
+		if t.FirstSeen.IsZero() {
+			t.FirstSeen = time.Now().UTC()
+		}
+		if t.FirstBlock == 0 {
+			t.FirstBlock = head + 1
+		}
+		l.live[tx.Hash] = t
+		l.stats.Added++
+	}
+	return t
+}
+
+// Synced settles the transactions that left the pool when it synced on
+// head: those in mined, the hashes of head's transactions, were mined, the
+// rest evicted. pooled is what the pool holds now; new transactions in it
+// start being followed.
+func (l *Lifecycle) Synced(head uint64, mined map[string]bool, pooled []*Transaction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	before := len(l.live)
+	now := time.Now().UTC()
+	in := make(map[string]bool, len(pooled))
+	for _, tx := range pooled {
+		in[tx.Hash] = true
+	}
+	removed := 0
+	for hash, t := range l.live {
+		if in[hash] {
+			continue
+		}
+		delete(l.live, hash)
+		removed++
+		t.LeftBlock, t.LeftAt = head, now
+		switch {
+		case mined[hash] && t.inLast:
+			t.Outcome = OutcomeIncluded
+		case mined[hash]:
+			t.Outcome = OutcomeMinedElsewhere
+		default:
+			t.Outcome = OutcomeEvicted
+		}
+		l.stats.Outcomes[t.Outcome]++
+		if t.Outcome != OutcomeEvicted {
+			blocks := head + 1 - min(t.FirstBlock, head)
+			st := &l.stats.Latency
+			st.Count++
+			st.Sum += blocks
+			for _, b := range lifecycleBuckets {
+				if blocks <= b {
+					st.Buckets[b]++
+				}
+			}
+			l.latency += now.Sub(t.FirstSeen)
+		}
+		l.recent = append(l.recent, t)
+	}
+	if n := len(l.recent) - lifecycleRecent; n > 0 {
+		l.recent = slices.Delete(l.recent, 0, n)
+	}
+	for _, tx := range pooled {
+		l.track(tx, head)
+	}
+	l.stats.Heads++
+	l.stats.Removed += removed
+	if before > 0 {
+		l.churn += float64(removed) / float64(before)
+	}
+}
+
+// Built records a build from pool, an unshared merged pool, that packed
+// selected: every pooled transaction was considered, and those left out
+// get the reason why
+func (l *Lifecycle) Built(head uint64, pool *TxPool, selected []*Transaction) {
+	packed := make(map[string]bool, len(selected))
+	for _, tx := range selected {
+		packed[tx.Hash] = true
+		for _, h := range tx.Bundle {
+			packed[h] = true
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for hash, tx := range pool.AllTxs {
+		t := l.track(tx, head)
+		t.Considered++
+		t.inLast = packed[hash]
+		if t.inLast {
+			t.Selected++
+			t.Skip = ""
+			continue
+		}
+		_, queued := pool.Queued[hash]
+		switch {
+		case queued:
+			t.Skip = SkipQueued
+		case pool.Low.Has(hash):
+			t.Skip = SkipLow
+		case pool.Excluded[hash]:
+			t.Skip = SkipExcluded
+		default:
+			t.Skip = SkipNotPacked
+		}
+		l.stats.Skips[t.Skip]++
+	}
+}
+
+// Lookup returns the lifecycle of hash, pooled or recently departed
+func (l *Lifecycle) Lookup(hash string) (TxLifecycle, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.live[hash]; ok {
+		return *t, true
+	}
+	for _, t := range slices.Backward(l.recent) {
+		if t.Hash == hash {
+			return *t, true
+		}
+	}
+	return TxLifecycle{}, false
+}
+
+// Stats returns the aggregates so far
+func (l *Lifecycle) Stats() LifecycleStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.stats
+	st.Tracked = len(l.live)
+	st.Outcomes = maps.Clone(l.stats.Outcomes)
+	st.Skips = maps.Clone(l.stats.Skips)
+	st.Latency.Buckets = maps.Clone(l.stats.Latency.Buckets)
+	if st.Heads > 0 {
+		st.ChurnRate = l.churn / float64(st.Heads)
+	}
+	if n := st.Latency.Count; n > 0 {
+		st.Latency.Seconds = l.latency.Seconds() / float64(n)
+		st.InclusionRate = float64(st.Outcomes[OutcomeIncluded]) / float64(n)
+	}
+	var blocks []uint64
+	for _, t := range l.recent {
+		if t.Outcome != OutcomeEvicted {
+			blocks = append(blocks, t.LeftBlock+1-min(t.FirstBlock, t.LeftBlock))
+		}
+	}
+	if len(blocks) > 0 {
+		slices.Sort(blocks)
+		q := func(p float64) uint64 { return blocks[int(p*float64(len(blocks)-1))] }
+		st.Latency.P50, st.Latency.P90, st.Latency.P99 = q(0.5), q(0.9), q(0.99)
+	}
+	return st
+}
+
+// WriteMetrics writes the stats in the Prometheus text exposition format
+func (l *Lifecycle) WriteMetrics(w io.Writer) {
+	st := l.Stats()
+	metric := func(name, kind, help string, v any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("bce_pool_tracked_txs", "gauge", "Pooled transactions whose lifecycle is followed.", st.Tracked)
+	fmt.Fprintf(w, "# HELP bce_pool_departures_total Transactions that left the pool, by outcome.\n# TYPE bce_pool_departures_total counter\n")
+	for _, o := range []Outcome{OutcomeIncluded, OutcomeMinedElsewhere, OutcomeEvicted} {
+		fmt.Fprintf(w, "bce_pool_departures_total{outcome=%q} %d\n", o, st.Outcomes[o])
+	}
+	fmt.Fprintf(w, "# HELP bce_pool_skips_total Pooled transactions builds left out, by reason.\n# TYPE bce_pool_skips_total counter\n")
+	for _, r := range []SkipReason{SkipQueued, SkipLow, SkipExcluded, SkipNotPacked} {
+		fmt.Fprintf(w, "bce_pool_skips_total{reason=%q} %d\n", r, st.Skips[r])
+	}
+	fmt.Fprintf(w, "# HELP bce_pool_inclusion_latency_blocks Blocks from arrival to being mined.\n# TYPE bce_pool_inclusion_latency_blocks histogram\n")
+	for _, b := range lifecycleBuckets {
+		fmt.Fprintf(w, "bce_pool_inclusion_latency_blocks_bucket{le=\"%d\"} %d\n", b, st.Latency.Buckets[b])
+	}
+	fmt.Fprintf(w, "bce_pool_inclusion_latency_blocks_bucket{le=\"+Inf\"} %d\n", st.Latency.Count)
+	fmt.Fprintf(w, "bce_pool_inclusion_latency_blocks_sum %d\nbce_pool_inclusion_latency_blocks_count %d\n", st.Latency.Sum, st.Latency.Count)
+	metric("bce_pool_churn_rate", "gauge", "Mean share of the pool removed per head.", st.ChurnRate)
+	metric("bce_pool_inclusion_rate", "gauge", "Share of mined pooled transactions the last build had packed.", st.InclusionRate)
+}
+
+// TrackLifecycle settles the lifecycles of the transactions that left
+// pool when it synced on head, if lifecycle tracking is on
+func (e *Env) TrackLifecycle(ctx context.Context, pool Mempool, head uint64) {
+	if e.Lifecycle == nil {
+		return
+	}
+	hashes, err := FetchBlockTxHashes(ctx, e.RPC, head)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error fetching mined transactions: %v\n", err)
+		return
+	}
+	mined := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		mined[h] = true
+	}
+	e.Lifecycle.Synced(head, mined, pool.Txs())
+}
+
+// handleLifecycle returns the aggregates, or with ?hash= one transaction's
+// lifecycle
+func (s *APIServer) handleLifecycle(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		writeHTTPJSON(w, s.Lifecycle.Stats())
+		return
+	}
+	t, ok := s.Lifecycle.Lookup(hash)
+	if !ok {
+		http.Error(w, "transaction not tracked", http.StatusNotFound)
+		return
+	}
+	writeHTTPJSON(w, t)
+}
@@ -2,24 +2,58 @@ package main
 
 import (
 	"bytes"
-	"container/heap"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
-	"strconv"
+	"maps"
+	"math"
+	"math/big"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // Transaction represents a Berachain transaction
 type Transaction struct {
-	Hash          string   `json:"hash"`
-	GasPrice      int64    `json:"gasPrice"`
-	GasLimit      int64    `json:"gasLimit"`
-	MEVBonus      int64    `json:"mevBonus"`
-	PoLBonus      int64    `json:"polBonus"`
-	Nonce         int      `json:"nonce"`
-	ConflictsWith []string `json:"conflictsWith"`
+	Hash          string        `json:"hash"`
+	From          string        `json:"from,omitempty"`   // sender, when known
+	To            string        `json:"to,omitempty"`     // recipient; empty for contract creation or if unknown
+	Create        bool          `json:"create,omitempty"` // deploys a contract, as known from its envelope or node
+	GasPrice      int64         `json:"gasPrice"`
+	GasLimit      int64         `json:"gasLimit"`
+	MEVBonus      int64         `json:"mevBonus"`
+	PoLBonus      int64         `json:"polBonus"`
+	PoLVault      string        `json:"polVault,omitempty"` // reward vault its PoL incentive is paid through, when known
+	Nonce         int           `json:"nonce"`
+	ConflictsWith []string      `json:"conflictsWith"`
+	MustFollow    []string      `json:"mustFollow,omitempty"`    // hashes or bundle IDs it must come after when in the same block
+	Bundle        []string      `json:"bundle,omitempty"`        // member hashes if this merges a bundle
+	Input         HexBytes      `json:"input,omitempty"`         // calldata, when known
+	Raw           HexBytes      `json:"raw,omitempty"`           // signed RLP / typed envelope, when known
+	GasEstimate   int64         `json:"gasEstimate,omitempty"`   // expected gas used, when estimated; see PackGas
+	RevertProtect bool          `json:"revertProtect,omitempty"` // leave out of the block rather than include it reverting
+	Type          uint8         `json:"type,omitempty"`          // envelope type; 2 and up price by fee cap and tip cap
+	GasTipCap     int64         `json:"gasTipCap,omitempty"`     // maxPriorityFeePerGas of dynamic-fee transactions, whose GasPrice is the fee cap
+	AccessList    []AccessTuple `json:"accessList,omitempty"`    // EIP-2930 access list
+	ChainID       uint64        `json:"chainId,omitempty"`       // chain it is signed for, when known
+	Value         *big.Int      `json:"value,omitempty"`         // wei transferred; nil if none or unknown
+	SeenBlock     uint64        `json:"seenBlock,omitempty"`     // pending block it was first pooled at; stamped by the pool
+	Seq           uint64        `json:"seq,omitempty"`           // arrival order, breaking score ties; stamped by the pool
+	SeenAt        time.Time     `json:"seenAt,omitzero"`         // when it was first received; stamped by the pool
+	ReceivedAt    time.Time     `json:"-"`                       // when the endpoint it came in on read it, before admission; SeenAt if set
+	BaseFee       int64         `json:"-"`                       // base fee it is scored against; stamped by the pool
+	Boost         int64         `json:"-"`                       // aging boost and scorer adjustment to its score; stamped by the pool
+	Reputation    float64       `json:"-"`                       // its searcher's reputation if this merges a bundle; stamped by the hint book
+
+	members   []*Transaction // if this merges a bundle, which price it; see mergeBundle
+	recovered bool           // From, Hash and ChainID were recovered from Raw; see verifyOrigin
 }
 
 // RPCRequest represents a JSON-RPC request
@@ -48,7 +82,7 @@ type RPCError struct {
 type TxHeap []*Transaction
 
 func (h TxHeap) Len() int           { return len(h) }
-func (h TxHeap) Less(i, j int) bool { return h[i].Profit() > h[j].Profit() } // max-heap
+func (h TxHeap) Less(i, j int) bool { return cmpScore(h[i], h[j]) > 0 } // max-heap
 func (h TxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
 
 func (h *TxHeap) Push(x any) {
@@ -63,135 +97,1236 @@ func (h *TxHeap) Pop() any {
 	return x
 }
 
-// TxPool mocks a transaction pool
+// IndexedHeap is a TxHeap that tracks each transaction's position by hash
+// as it moves, so a pooled transaction can be removed or re-scored in place
+// in O(log n). The zero value is an empty heap.
+type IndexedHeap struct {
+	TxHeap
+	Metrics *HeapMetrics // times its operations; nil if off
+	index   map[string]int
+}
+
+func (h *IndexedHeap) Swap(i, j int) {
+	h.TxHeap.Swap(i, j)
+	h.index[h.TxHeap[i].Hash] = i
+	h.index[h.TxHeap[j].Hash] = j
+}
+
+func (h *IndexedHeap) Push(x any) {
+	tx := x.(*Transaction)
+	if h.index == nil {
+		h.index = make(map[string]int)
+	}
+	h.index[tx.Hash] = len(h.TxHeap)
+	h.TxHeap.Push(tx)
+}
+
+func (h *IndexedHeap) Pop() any {
+	tx := h.TxHeap.Pop().(*Transaction)
+	delete(h.index, tx.Hash)
+	return tx
+}
+
+// Add pushes tx onto the heap
+func (h *IndexedHeap) Add(tx *Transaction) {
+	h.Metrics.Push(h, tx)
+}
+
+// Has reports whether hash is in the heap
+func (h *IndexedHeap) Has(hash string) bool {
+	_, ok := h.index[hash]
+	return ok
+}
+
+// Remove takes hash out of the heap, returning nil if it isn't there
+func (h *IndexedHeap) Remove(hash string) *Transaction {
+	i, ok := h.index[hash]
+	if !ok {
+		return nil
+	}
+	return h.Metrics.Remove(h, i).(*Transaction)
+}
+
+// Replace swaps in tx for the transaction with the same hash and restores
+// heap order for its new score, reporting false if the hash isn't there
+func (h *IndexedHeap) Replace(tx *Transaction) bool {
+	i, ok := h.index[tx.Hash]
+	if !ok {
+		return false
+	}
+	h.TxHeap[i] = tx
+	h.Metrics.Fix(h, i)
+	return true
+}
+
+// Reset replaces the contents of the heap with txs
+func (h *IndexedHeap) Reset(txs []*Transaction) {
+	h.TxHeap = txs
+	h.index = make(map[string]int, len(txs))
+	for i, tx := range txs {
+		h.index[tx.Hash] = i
+	}
+	h.Metrics.Init(h)
+}
+
+// TxPool mocks a transaction pool.
+//
+// A TxPool is safe for concurrent use through its exported methods: those
+// that change the pool take the write lock, builds and listings take the
+// read lock, so several builds can run at once but block a concurrent
+// fetch until they finish. RPC round trips happen outside the lock.
+// Unexported methods expect the caller to hold it. The exported settings
+// (MinGasPrice, Policy, Lanes, Search and the like) must be configured
+// before the pool is shared, and the exported state (AllTxs, the heaps,
+// Queued, Private, Held, Required, Rejections) may only be read directly by the
+// goroutine that owns an unshared pool; use Txs, PublicTxs and Stats
+// otherwise. Hints has its own lock.
 type TxPool struct {
-	AllTxs map[string]*Transaction
-	Heap   TxHeap
+	mu sync.RWMutex
+
+	AllTxs      map[string]*Transaction
+	Heap        IndexedHeap
+	Low         IndexedHeap // below the tip floor in deprioritize mode; packed after Heap
+	Seen        *SeenCache
+	Quarantine  *Quarantine
+	MinGasPrice int64  // fee floor; cheaper transactions are rejected
+	ChainID     uint64 // transactions signed for other chains are rejected; 0 accepts any
+	BaseFee     int64  // base fee of the block being built
+	Block       uint64 // number of the block being built, learned from the pending block
+	MinTip      int64  // priority fee floor per gas, over BaseFee; 0 disables it
+	TipFloor    TipFloorMode
+
+	MaxPerSender int            // pooled transactions allowed per sender; 0 is unlimited
+	MaxSenderGas int64          // aggregate gas limit allowed per sender; 0 is unlimited
+	MaxBytes     int64          // approximate memory the pool may hold (see footprint); 0 is unbounded
+	MaxTxGas     int64          // largest gas limit admitted, usually the block gas limit; 0 is unlimited
+	Policy       *AddressPolicy // compliance block/allowlists; nil admits everyone
+	Rejections   map[RejectReason]int
+	Evicted      int                                // pushed out by a better transaction of the same sender
+	bytes        int64                              // approximate memory held by pooled transactions
+	peakBytes    int64                              // high-water mark of bytes
+	bySender     map[string]map[string]*Transaction // pooled transactions by sender, then hash
+
+	// Queued parks transactions whose nonce is past a gap in their
+	// sender's pooled nonces. They stay out of the heaps, and so out of
+	// every build, until the gap fills; see reschedule. So do those their
+	// sender's balance can't pay for.
+	Queued map[string]*Transaction
+
+	// Balances holds the latest known balance of senders; see SetBalances
+	Balances map[string]*big.Int
+
+	// Nonces holds the confirmed next nonce of senders, their transaction
+	// count at the latest block; see SetNonces
+	Nonces map[string]uint64
+
+	// Private holds the expiry of transactions submitted through the private
+	// order-flow endpoint. They are built with like any other transaction
+	// but never listed publicly, and survive SyncPending until they expire
+	// or show up in the public mempool.
+	Private map[string]time.Time
+
+	// Held holds the expiry of public transactions heard before the RPC
+	// node lists them, such as those gossiped over P2P. Unlike private ones
+	// they are listed, but like them they survive SyncPending until they
+	// expire or show up in the node's pending block.
+	Held map[string]time.Time
+
+	// Hints holds MEV-Share hints and the searcher bundles referencing
+	// them; matched bundles are merged in at build time
+	Hints *HintBook
+
+	// Lanes reserve the top of the block; see Lane
+	Lanes []*Lane
+
+	// Express is the express lane's holder for this build, whose
+	// transactions open the block ahead of the lanes; see ExpressLane
+	Express *ExpressGrant
+
+	// Encrypted is the gas this build reserves for encrypted transactions
+	// and the decrypted ones opening the block; see EncryptedPool
+	Encrypted *EncryptedSet
+
+	// Committed lists the revealed transactions of the commit-reveal
+	// experiment in the order their commitments fixed; see CommitReveal
+	Committed []string
+
+	// Hooks apply chain-specific ordering rules around the packer, in
+	// order; see ProposalHook
+	Hooks []*Hook
+
+	// Quotas cap the block gas of any one sender or contract; nil leaves
+	// them uncapped
+	Quotas *GasQuotas
+
+	// Boosts raise the score of calls to chosen contracts; see
+	// ContractBoost
+	Boosts []*ContractBoost
+
+	// Required lists hashes every build must include, ahead of the lanes
+	Required []string
+
+	// Excluded lists bundle IDs left out of every build from this pool,
+	// such as bundles revert protection found reverting
+	Excluded map[string]bool
+
+	// Exact bounds the exact conflict solver of the "wis" strategy
+	Exact     ExactConfig
+	lastExact atomic.Pointer[ExactResult]
+
+	// Ranking orders the candidates of the "greedy", "anneal" and
+	// "parallel" strategies, and those within a bucket of "fair"; empty
+	// ranks by score
+	Ranking Ranking
+
+	// Fair sets the arrival buckets of the "fair" strategy
+	Fair FairConfig
+
+	// Search tunes the "anneal" strategy
+	Search     SearchConfig
+	lastSearch atomic.Pointer[SearchResult]
+
+	// lastScan is the most recent budgeted scan; see ScanBudget
+	lastScan atomic.Pointer[ScanResult]
+
+	// Parallel tunes the "parallel" strategy, and Access supplies it the
+	// storage a transaction was traced reading and writing, nil if it
+	// wasn't; nil if nothing is traced
+	Parallel     ParallelConfig
+	Access       func(hash string) *AccessSet
+	lastParallel atomic.Pointer[ParallelResult]
+
+	// Aging boosts the score of long-waiting transactions
+	Aging AgingConfig
+
+	// Scorer names the registered Scorer transactions are ranked by; empty
+	// ranks by profit, weighted by Weights
+	Scorer  string
+	Weights ScoreWeights
+
+	// CuttingBoard, if set, weights PoL incentives in the default score by
+	// how much of them reach the proposer
+	CuttingBoard *CuttingBoard
+
+	// keepArrival trusts the SeenBlock, Seq and SeenAt transactions
+	// arrive with, as replays and restores do; otherwise admission stamps
+	// them
+	keepArrival bool
 }
 
+// TipFloorMode selects what happens to transactions tipping below MinTip
+type TipFloorMode string
+
+const (
+	TipFloorReject       TipFloorMode = "reject"       // never admitted
+	TipFloorDeprioritize TipFloorMode = "deprioritize" // admitted, packed last
+)
+
 func NewTxPool() *TxPool {
 	return &TxPool{
-		AllTxs: make(map[string]*Transaction),
-		Heap:   TxHeap{},
+		AllTxs:     make(map[string]*Transaction),
+		Seen:       NewSeenCache(DefaultSeenTTL),
+		Quarantine: NewQuarantine(DefaultQuarantineSize),
+		Rejections: make(map[RejectReason]int),
+		bySender:   make(map[string]map[string]*Transaction),
+		Queued:     make(map[string]*Transaction),
+		Private:    make(map[string]time.Time),
+		Held:       make(map[string]time.Time),
+		Hints:      NewHintBook(DefaultHintTTL),
+		Excluded:   make(map[string]bool),
+		Search:     DefaultSearchConfig(),
+		Exact:      DefaultExactConfig(),
+		Parallel:   DefaultParallelConfig(),
+		Weights:    DefaultScoreWeights(),
+	}
+}
+
+// AddResult describes what AddTx did with a transaction
+type AddResult int
+
+const (
+	TxAdded    AddResult = iota // new hash, pushed into the pool
+	TxReplaced                  // known hash with changed fields, updated in place
+	TxRejected                  // duplicate, or seen recently and no longer pooled
+)
+
+func (r AddResult) String() string {
+	switch r {
+	case TxAdded:
+		return "added"
+	case TxReplaced:
+		return "replaced"
+	case TxRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// DefaultMaxPerSender caps how many transactions one sender can keep pooled
+const DefaultMaxPerSender = 64
+
+// RejectReason is a stable code describing why AddTx turned a transaction away
+type RejectReason string
+
+const (
+	RejectDuplicate      RejectReason = "duplicate"         // identical to the pooled transaction
+	RejectSeen           RejectReason = "seen"              // left the pool within the SeenCache TTL
+	RejectBadSignature   RejectReason = "invalid_signature" // raw envelope doesn't verify
+	RejectWrongChain     RejectReason = "wrong_chain_id"    // signed for another chain
+	RejectUnprotected    RejectReason = "unprotected"       // legacy without a chain ID, replayable anywhere
+	RejectZeroGasPrice   RejectReason = "zero_gas_price"    // pays nothing
+	RejectTipAboveFeeCap RejectReason = "tip_above_fee_cap" // maxPriorityFeePerGas over maxFeePerGas
+	RejectIntrinsicGas   RejectReason = "intrinsic_gas"     // gas limit below its intrinsic gas
+	RejectUnderpriced    RejectReason = "underpriced"       // below MinGasPrice
+	RejectBelowTipFloor  RejectReason = "below_tip_floor"   // tips below MinTip
+	RejectSenderCap      RejectReason = "sender_cap"        // worth less than the rest of a sender at its caps
+	RejectOversized      RejectReason = "exceeds_block_gas" // gas limit can never fit a block
+	RejectPolicy         RejectReason = "address_policy"    // excluded by the address block/allowlist
+	RejectStaleNonce     RejectReason = "stale_nonce"       // nonce already used on chain
+	RejectPoolFull       RejectReason = "pool_full"         // would take the pool past MaxBytes
+)
+
+// AddTx admits tx into the pool, counting any rejection by reason in
+// Rejections. It is idempotent: a transaction already pooled, or seen
+// within the SeenCache TTL, is rejected. So are transactions for another
+// chain or whose raw signature doesn't verify (see checkOrigin), invalid
+// ones (tip cap over fee cap, gas limit below IntrinsicGas or above
+// MaxTxGas), and spam paying no gas price, less than MinGasPrice or, with
+// TipFloorReject, tips below MinTip.
+// A sender at MaxPerSender or MaxSenderGas makes room by evicting its
+// lowest-scoring transactions (see senderOverflow). While the memory
+// estimate is at MaxBytes only replacements are taken. Policy exclusions
+// are audited once and marked seen.
+func (p *TxPool) AddTx(tx *Transaction) AddResult {
+	verifyOrigin(tx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addTx(tx)
+}
+
+func (p *TxPool) addTx(tx *Transaction) AddResult {
+	res, reason := p.admit(tx)
+	if res == TxRejected {
+		p.Rejections[reason]++
 	}
+	return res
 }
 
-func (p *TxPool) AddTx(tx *Transaction) {
+func (p *TxPool) admit(tx *Transaction) (AddResult, RejectReason) {
+	if old, ok := p.AllTxs[tx.Hash]; ok {
+		p.Seen.Mark(tx.Hash)
+		if old.Equal(tx) {
+			return TxRejected, RejectDuplicate
+		}
+		p.arrive(tx, old)
+		p.stamp(tx)
+		p.AllTxs[tx.Hash] = tx
+		p.account(old, tx)
+		p.trackSender(old, false)
+		p.trackSender(tx, true)
+		if p.requeue(old, tx) {
+			return TxReplaced, ""
+		}
+		p.unqueue(tx.Hash)
+		p.schedule(tx)
+		if old.From != tx.From {
+			p.reschedule(old.From)
+		}
+		return TxReplaced, ""
+	}
+	if p.Seen.Seen(tx.Hash) {
+		return TxRejected, RejectSeen
+	}
+	if reason := p.checkOrigin(tx); reason != "" {
+		return TxRejected, reason
+	}
+	p.arrive(tx, nil)
+	p.stamp(tx)
+	switch {
+	case tx.GasPrice <= 0:
+		return TxRejected, RejectZeroGasPrice
+	case tx.DynamicFee() && tx.GasTipCap > tx.GasPrice:
+		return TxRejected, RejectTipAboveFeeCap
+	case tx.GasLimit < tx.IntrinsicGas():
+		return TxRejected, RejectIntrinsicGas
+	case tx.GasPrice < p.MinGasPrice:
+		return TxRejected, RejectUnderpriced
+	case p.belowTipFloor(tx) && p.TipFloor == TipFloorReject:
+		return TxRejected, RejectBelowTipFloor
+	case p.MaxTxGas > 0 && tx.GasLimit > p.MaxTxGas:
+		return TxRejected, RejectOversized
+	case tx.From != "" && uint64(tx.Nonce) < p.Nonces[tx.From]:
+		return TxRejected, RejectStaleNonce
+	case p.Policy != nil && p.Policy.Exclude(tx):
+		p.Seen.Mark(tx.Hash)
+		return TxRejected, RejectPolicy
+	case p.MaxBytes > 0 && p.bytes+tx.footprint() > p.MaxBytes:
+		return TxRejected, RejectPoolFull
+	}
+	overflow := p.senderOverflow(tx)
+	if slices.Contains(overflow, tx) {
+		return TxRejected, RejectSenderCap
+	}
+	for _, old := range overflow {
+		p.removeTx(old.Hash)
+		p.Evicted++
+	}
+	p.Seen.Mark(tx.Hash)
 	p.AllTxs[tx.Hash] = tx
-	heap.Push(&p.Heap, tx)
+	p.account(nil, tx)
+	p.trackSender(tx, true)
+	p.schedule(tx)
+	return TxAdded, ""
 }
 
-// Profit calculates the total profit from the tx
-func (tx *Transaction) Profit() int64 {
-	return tx.GasPrice*tx.GasLimit + tx.MEVBonus + tx.PoLBonus
+// senderOverflow returns what must go for tx's sender to stay within
+// MaxPerSender and MaxSenderGas once tx is added: its pooled transactions
+// and tx, lowest score first, until the rest fit. Required transactions
+// never go. A nil result means tx fits as is.
+func (p *TxPool) senderOverflow(tx *Transaction) []*Transaction {
+	if tx.From == "" || p.MaxPerSender <= 0 && p.MaxSenderGas <= 0 {
+		return nil
+	}
+	txs := append(slices.Collect(maps.Values(p.bySender[tx.From])), tx)
+	count, gas := len(txs), int64(0)
+	for _, t := range txs {
+		gas += t.GasLimit
+	}
+	over := func() bool {
+		return p.MaxPerSender > 0 && count > p.MaxPerSender || p.MaxSenderGas > 0 && gas > p.MaxSenderGas
+	}
+	if !over() {
+		return nil
+	}
+	slices.SortFunc(txs, cmpScore)
+	var overflow []*Transaction
+	for _, t := range txs {
+		if !over() {
+			break
+		}
+		if slices.Contains(p.Required, t.Hash) {
+			continue
+		}
+		overflow = append(overflow, t)
+		count, gas = count-1, gas-t.GasLimit
+	}
+	return overflow
+}
+
+// trackSender adds tx to, or removes it from, its sender's pooled
+// transactions
+func (p *TxPool) trackSender(tx *Transaction, add bool) {
+	if tx.From == "" {
+		return
+	}
+	if !add {
+		delete(p.bySender[tx.From], tx.Hash)
+		if len(p.bySender[tx.From]) == 0 {
+			delete(p.bySender, tx.From)
+		}
+		return
+	}
+	if p.bySender[tx.From] == nil {
+		p.bySender[tx.From] = make(map[string]*Transaction)
+	}
+	p.bySender[tx.From][tx.Hash] = tx
 }
 
-// FetchTransactions fetches pending transactions from Berachain RPC
-func (p *TxPool) FetchTransactions() error {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+// verifyOrigin recovers the sender of tx's raw envelope, if it carries one
+// whose sender hasn't been recovered already, as by RecoverSender. The
+// envelope must hash to tx.Hash and be signed by From, which is filled in
+// if unclaimed, as is ChainID. Recovery takes milliseconds, so the pool
+// does this before taking its lock, and checkOrigin then refuses an
+// envelope it couldn't verify.
+func verifyOrigin(tx *Transaction) {
+	if len(tx.Raw) == 0 || tx.recovered {
+		return
 	}
+	signed, err := RecoverSender(tx.Raw)
+	if err != nil || signed.Hash != strings.ToLower(tx.Hash) || (tx.From != "" && signed.From != strings.ToLower(tx.From)) {
+		return
+	}
+	tx.From, tx.ChainID, tx.recovered = signed.From, signed.ChainID, true
+}
 
-	// Get pending transactions from the mempool
-	blockReq := RPCRequest{
-		JSONRPC: "2.0",
-		Method:  "eth_getBlockByNumber",
-		Params:  []interface{}{"pending", true}, // "pending" to get mempool transactions
-		ID:      1,
+// checkOrigin rejects tx unless it is signed for the pool's ChainID. If tx
+// carries its raw envelope, verifyOrigin must have recovered its sender,
+// and legacy envelopes need EIP-155 replay protection. Transactions
+// fetched from the node come without one and are checked by their
+// reported chain ID only; the node has verified their signatures.
+func (p *TxPool) checkOrigin(tx *Transaction) RejectReason {
+	if len(tx.Raw) > 0 {
+		if !tx.recovered {
+			return RejectBadSignature
+		}
+		if p.ChainID != 0 && tx.ChainID == 0 {
+			return RejectUnprotected
+		}
+	}
+	if p.ChainID != 0 && tx.ChainID != 0 && tx.ChainID != p.ChainID {
+		return RejectWrongChain
 	}
+	return ""
+}
 
-	jsonData, err := json.Marshal(blockReq)
-	if err != nil {
-		return fmt.Errorf("error marshaling request: %v", err)
+// belowTipFloor reports whether tx tips less than MinTip at the current base fee
+func (p *TxPool) belowTipFloor(tx *Transaction) bool {
+	return p.MinTip > 0 && tx.EffectiveTip(p.BaseFee) < p.MinTip
+}
+
+// enqueue pushes tx onto Heap, or onto Low if it is below the tip floor
+func (p *TxPool) enqueue(tx *Transaction) {
+	if p.TipFloor == TipFloorDeprioritize && p.belowTipFloor(tx) {
+		p.Low.Add(tx)
+		return
 	}
+	p.Heap.Add(tx)
+}
 
-	req, err := http.NewRequest("POST", "https://rpc.berachain.com", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+// unqueue removes hash from whichever heap holds it, or from Queued
+func (p *TxPool) unqueue(hash string) {
+	if _, ok := p.Queued[hash]; ok {
+		delete(p.Queued, hash)
+	} else if p.Heap.Remove(hash) == nil {
+		p.Low.Remove(hash)
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
+
+// requeue re-scores a replacement in place when it keeps its sender, nonce
+// and heap, reporting false if it has to be rescheduled instead
+func (p *TxPool) requeue(old, tx *Transaction) bool {
+	if old.From != tx.From || old.Nonce != tx.Nonce {
+		return false
+	}
+	if p.TipFloor == TipFloorDeprioritize && p.belowTipFloor(tx) {
+		return p.Low.Replace(tx)
+	}
+	return p.Heap.Replace(tx)
+}
+
+// SetBaseFee updates the base fee effective tips and profits are measured
+// against, re-sorting the heaps by the new profits and, in deprioritize
+// mode, moving transactions across the tip floor between Heap and Low.
+// Transactions already admitted are never evicted.
+func (p *TxPool) SetBaseFee(baseFee int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setBaseFee(baseFee)
+}
+
+func (p *TxPool) setBaseFee(baseFee int64) {
+	if baseFee == p.BaseFee {
+		return
+	}
+	p.BaseFee = baseFee
+	p.restamp()
+}
+
+// restamp rescores every pooled transaction against the pool's base fee
+// and block, re-sorting the heaps
+func (p *TxPool) restamp() {
+	// Restamp copies: earlier builds may still be reading the old ones
+	restamp := func(old *Transaction) *Transaction {
+		tx := *old
+		p.stamp(&tx)
+		p.AllTxs[tx.Hash] = &tx
+		p.trackSender(&tx, true)
+		return &tx
+	}
+	for hash, tx := range p.Queued {
+		p.Queued[hash] = restamp(tx)
+	}
+	var high, low []*Transaction
+	for _, old := range slices.Concat(p.Heap.TxHeap, p.Low.TxHeap) {
+		if tx := restamp(old); p.TipFloor == TipFloorDeprioritize && p.belowTipFloor(tx) {
+			low = append(low, tx)
+		} else {
+			high = append(high, tx)
+		}
+	}
+	p.Heap.Reset(high)
+	p.Low.Reset(low)
+}
+
+// RemoveTx drops a transaction from the pool. Its hash stays in the
+// SeenCache, so a later fetch won't re-admit it until the entry expires.
+func (p *TxPool) RemoveTx(hash string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.removeTx(hash)
+}
+
+func (p *TxPool) removeTx(hash string) bool {
+	tx, ok := p.AllTxs[hash]
+	if !ok {
+		return false
+	}
+	p.trackSender(tx, false)
+	p.account(tx, nil)
+	delete(p.AllTxs, hash)
+	delete(p.Private, hash)
+	delete(p.Held, hash)
+	if i := slices.Index(p.Required, hash); i >= 0 {
+		p.Required = slices.Delete(p.Required, i, i+1)
+	}
+	p.unqueue(hash)
+	p.reschedule(tx.From)
+	return true
+}
+
+// Equal reports whether two transactions carry identical fields
+func (tx *Transaction) Equal(o *Transaction) bool {
+	return tx.Hash == o.Hash &&
+		tx.From == o.From &&
+		tx.To == o.To &&
+		tx.Create == o.Create &&
+		tx.GasPrice == o.GasPrice &&
+		tx.GasLimit == o.GasLimit &&
+		tx.MEVBonus == o.MEVBonus &&
+		tx.PoLBonus == o.PoLBonus &&
+		tx.PoLVault == o.PoLVault &&
+		tx.Nonce == o.Nonce &&
+		slices.Equal(tx.ConflictsWith, o.ConflictsWith) &&
+		slices.Equal(tx.Bundle, o.Bundle) &&
+		slices.Equal(tx.MustFollow, o.MustFollow) &&
+		bytes.Equal(tx.Input, o.Input) &&
+		bytes.Equal(tx.Raw, o.Raw) &&
+		tx.GasEstimate == o.GasEstimate &&
+		tx.RevertProtect == o.RevertProtect &&
+		tx.Type == o.Type &&
+		tx.GasTipCap == o.GasTipCap &&
+		equalAccessLists(tx.AccessList, o.AccessList) &&
+		tx.ChainID == o.ChainID &&
+		tx.ValueWei().Cmp(o.ValueWei()) == 0
+}
+
+// DynamicFee reports whether tx prices gas by fee cap and tip cap (EIP-1559)
+func (tx *Transaction) DynamicFee() bool { return tx.Type >= 2 }
+
+// EffectiveTip returns the priority fee per gas tx pays above baseFee,
+// capped at its tip cap if it has a dynamic fee, and negative if it can't
+// cover the base fee. A merged bundle's is its members' gas-weighted mean,
+// rounded down, or the lowest if one of them can't cover the base fee.
+func (tx *Transaction) EffectiveTip(baseFee int64) int64 {
+	if len(tx.members) > 0 {
+		_, tips, gas := tx.memberFees(baseFee)
+		lowest := int64(math.MaxInt64)
+		for _, m := range tx.members {
+			lowest = min(lowest, m.EffectiveTip(baseFee))
+		}
+		if lowest < 0 || gas == 0 {
+			return lowest
+		}
+		return tips / gas
+	}
+	tip := tx.GasPrice - baseFee
+	if tx.DynamicFee() {
+		tip = min(tip, tx.GasTipCap)
+	}
+	return tip
+}
+
+// PackGas returns the gas tx is counted as using once packed: its estimate
+// if it has one, else its gas limit. Whether it fits is still decided by
+// its gas limit.
+func (tx *Transaction) PackGas() int64 {
+	if tx.GasEstimate > 0 && tx.GasEstimate < tx.GasLimit {
+		return tx.GasEstimate
+	}
+	return tx.GasLimit
+}
 
-	resp, err := client.Do(req)
+// Fees splits what a transaction pays at its BaseFee, on the gas it is
+// counted as using, by where the money goes
+type Fees struct {
+	Burn     int64 // base fee, burned
+	Tip      int64 // priority fee, to the fee recipient
+	MEVBonus int64 // direct coinbase transfers
+	PoLBonus int64 // PoL incentives, to the proposer
+}
+
+func (tx *Transaction) Fees() Fees {
+	if len(tx.members) > 0 {
+		burn, tip, _ := tx.memberFees(tx.BaseFee)
+		return Fees{Burn: burn, Tip: tip, MEVBonus: tx.MEVBonus, PoLBonus: tx.PoLBonus}
+	}
+	gas := tx.PackGas()
+	return Fees{
+		Burn:     min(tx.GasPrice, tx.BaseFee) * gas,
+		Tip:      max(tx.EffectiveTip(tx.BaseFee), 0) * gas,
+		MEVBonus: tx.MEVBonus,
+		PoLBonus: tx.PoLBonus,
+	}
+}
+
+// memberFees sums what a merged bundle's members burn and tip at baseFee,
+// each on the gas it is counted as using, and that gas
+func (tx *Transaction) memberFees(baseFee int64) (burn, tip, gas int64) {
+	for _, m := range tx.members {
+		g := m.PackGas()
+		burn += min(m.GasPrice, baseFee) * g
+		tip += max(m.EffectiveTip(baseFee), 0) * g
+		gas += g
+	}
+	return burn, tip, gas
+}
+
+// Profit is what including tx earns the proposer: its priority fees,
+// coinbase transfers and PoL incentives. The base fee is burned, so it
+// counts for nothing; every strategy maximizes this.
+func (tx *Transaction) Profit() int64 {
+	f := tx.Fees()
+	return f.Tip + f.MEVBonus + f.PoLBonus
+}
+
+// FetchTransactions fetches pending transactions from Berachain RPC.
+// The request is bound to ctx, so cancelling ctx aborts an in-flight fetch.
+func (p *TxPool) FetchTransactions(ctx context.Context, rpc *RPCClient) error {
+	block, err := fetchPendingBlock(ctx, rpc)
 	if err != nil {
-		return fmt.Errorf("error making request: %v", err)
+		return err
 	}
-	defer resp.Body.Close()
+	_, span := StartSpan(ctx, "pool.admit", "pool.pending", len(block.Transactions))
+	defer span.End()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.addPending(block)
+	span.SetAttr("pool.pooled", len(p.AllTxs))
+	span.SetError(err)
+	return err
+}
 
-	body, err := io.ReadAll(resp.Body)
+// SyncPending fetches the pending transactions and removes pooled ones that
+// are no longer pending (mined or dropped upstream), returning how many
+// were removed. Private transactions are kept until they expire; once one
+// appears in the public mempool it is treated as public.
+func (p *TxPool) SyncPending(ctx context.Context, rpc *RPCClient) (int, error) {
+	block, err := fetchPendingBlock(ctx, rpc)
 	if err != nil {
-		return fmt.Errorf("error reading response: %v", err)
+		return 0, err
 	}
+	_, span := StartSpan(ctx, "pool.admit", "pool.pending", len(block.Transactions))
+	defer span.End()
+	removed, err := p.syncBlock(block)
+	span.SetAttr("pool.removed", removed, "pool.pooled", p.Stats().Pooled)
+	span.SetError(err)
+	return removed, err
+}
 
-	var blockResp struct {
-		JSONRPC string `json:"jsonrpc"`
-		ID      int    `json:"id"`
-		Result  struct {
-			Transactions []struct {
-				Hash     string `json:"hash"`
-				GasPrice string `json:"gasPrice"`
-				Gas      string `json:"gas"`
-				Nonce    string `json:"nonce"`
-			} `json:"transactions"`
-		} `json:"result"`
-		Error *RPCError `json:"error,omitempty"`
+// syncBlock implements SyncPending for an already fetched block
+func (p *TxPool) syncBlock(block *pendingBlock) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending, err := p.addPending(block)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	removed := 0
+	for hash := range p.AllTxs {
+		if expiry, private := p.Private[hash]; private {
+			if pending[hash] {
+				delete(p.Private, hash)
+				if i := slices.Index(p.Required, hash); i >= 0 {
+					p.Required = slices.Delete(p.Required, i, i+1)
+				}
+				continue
+			}
+			if now.Before(expiry) {
+				continue
+			}
+		}
+		if expiry, held := p.Held[hash]; held {
+			if pending[hash] {
+				delete(p.Held, hash)
+				continue
+			}
+			if now.Before(expiry) {
+				continue
+			}
+		}
+		if !pending[hash] && p.removeTx(hash) {
+			removed++
+		}
 	}
+	return removed, nil
+}
 
-	if err := json.Unmarshal(body, &blockResp); err != nil {
-		return fmt.Errorf("error unmarshaling response: %v", err)
+// AddPrivate admits a privately submitted transaction, kept for ttl unless
+// it is mined or turns up publicly first
+func (p *TxPool) AddPrivate(tx *Transaction, ttl time.Duration) AddResult {
+	verifyOrigin(tx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := p.addTx(tx)
+	if res != TxRejected {
+		p.Private[tx.Hash] = time.Now().Add(ttl)
 	}
+	return res
+}
 
-	if blockResp.Error != nil {
-		return fmt.Errorf("RPC error: %s", blockResp.Error.Message)
+// Submit admits tx like AddTx, keeping it private for ttl if positive, and
+// also returns why it was rejected
+func (p *TxPool) Submit(tx *Transaction, ttl time.Duration) (AddResult, RejectReason) {
+	verifyOrigin(tx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res, reason := p.admit(tx)
+	switch {
+	case res == TxRejected:
+		p.Rejections[reason]++
+	case ttl > 0:
+		p.Private[tx.Hash] = time.Now().Add(ttl)
 	}
+	return res, reason
+}
 
-	// Convert hex values to integers and create transactions
-	for _, tx := range blockResp.Result.Transactions {
-		gasPrice, _ := strconv.ParseInt(tx.GasPrice[2:], 16, 64)
-		gasLimit, _ := strconv.ParseInt(tx.Gas[2:], 16, 64)
-		nonce, _ := strconv.ParseInt(tx.Nonce[2:], 16, 64)
+// Hold admits tx publicly like AddTx, also returning why it was rejected,
+// and keeps it for ttl even if the next pending syncs don't list it, for
+// transactions heard before the RPC node has them. One pooled privately
+// becomes public, having been seen in the open.
+func (p *TxPool) Hold(tx *Transaction, ttl time.Duration) (AddResult, RejectReason) {
+	verifyOrigin(tx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res, reason := p.admit(tx)
+	if res == TxRejected {
+		p.Rejections[reason]++
+		if reason != RejectDuplicate {
+			return res, reason
+		}
+	}
+	delete(p.Private, tx.Hash)
+	if expiry := time.Now().Add(ttl); expiry.After(p.Held[tx.Hash]) {
+		p.Held[tx.Hash] = expiry
+	}
+	return res, reason
+}
 
-		transaction := &Transaction{
-			Hash:          tx.Hash,
-			GasPrice:      gasPrice,
-			GasLimit:      gasLimit,
-			Nonce:         int(nonce),
-			MEVBonus:      0, // This would need to be calculated or fetched from another source
-			PoLBonus:      0, // Same as above
-			ConflictsWith: []string{},
+// PublicTxs returns the pooled transactions that weren't submitted
+// privately, sorted by hash
+func (p *TxPool) PublicTxs() []*Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	txs := make([]*Transaction, 0, len(p.AllTxs))
+	for hash, tx := range p.AllTxs {
+		if _, private := p.Private[hash]; !private {
+			txs = append(txs, tx)
 		}
-		p.AddTx(transaction)
 	}
+	slices.SortFunc(txs, func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) })
+	return txs
+}
 
-	return nil
+// Content returns the public transactions split like a node's txpool:
+// pending ones are executable, queued ones are parked in Queued. Both are
+// sorted by hash.
+func (p *TxPool) Content() (pending, queued []*Transaction) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for hash, tx := range p.AllTxs {
+		if _, private := p.Private[hash]; private {
+			continue
+		}
+		if _, parked := p.Queued[hash]; parked {
+			queued = append(queued, tx)
+		} else {
+			pending = append(pending, tx)
+		}
+	}
+	byHash := func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) }
+	slices.SortFunc(pending, byHash)
+	slices.SortFunc(queued, byHash)
+	return pending, queued
 }
 
-func (p *TxPool) SelectTopTransactions(gasLimit int64) []*Transaction {
-	heap.Init(&p.Heap)
-	selected := []*Transaction{}
-	usedGas := int64(0)
-	usedIDs := map[string]bool{}
+// pendingBlock is the part of the pending block the pool consumes
+type pendingBlock struct {
+	Number        string           `json:"number"`
+	BaseFeePerGas string           `json:"baseFeePerGas"`
+	Transactions  []rpcTransaction `json:"transactions"`
+	Received      time.Time        `json:"-"` // when the response arrived
+}
 
-	for p.Heap.Len() > 0 && usedGas < gasLimit {
-		tx := heap.Pop(&p.Heap).(*Transaction)
-		conflict := false
-		for _, id := range tx.ConflictsWith {
-			if usedIDs[id] {
-				conflict = true
-				break
+// decodeStream decodes the block one transaction at a time, skipping the
+// fields the pool doesn't use, so a large pending block is never held as
+// raw JSON
+func (b *pendingBlock) decodeStream(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("pending block: expected object, got %v", tok)
+	}
+	return decodeFields(dec, func(key string) error {
+		switch key {
+		case "number":
+			return dec.Decode(&b.Number)
+		case "baseFeePerGas":
+			return dec.Decode(&b.BaseFeePerGas)
+		case "transactions":
+			if err := expectDelim(dec, '['); err != nil {
+				return err
 			}
+			for dec.More() {
+				var rtx rpcTransaction
+				if err := dec.Decode(&rtx); err != nil {
+					return err
+				}
+				b.Transactions = append(b.Transactions, rtx)
+			}
+			return expectDelim(dec, ']')
 		}
-		if conflict {
-			continue
+		return skipValue(dec)
+	})
+}
+
+// fetchPendingBlock fetches the pending block with full transactions
+func fetchPendingBlock(ctx context.Context, rpc *RPCClient) (*pendingBlock, error) {
+	var block pendingBlock
+	// "pending" to get mempool transactions
+	if err := rpc.Call(ctx, &block, "eth_getBlockByNumber", "pending", true); err != nil {
+		return nil, err
+	}
+	block.Received = time.Now()
+	return &block, nil
+}
+
+// addPending adds the pending block's transactions to the pool and returns
+// the set of hashes it contained
+func (p *TxPool) addPending(block *pendingBlock) (map[string]bool, error) {
+	p.Seen.Prune()
+	if block.BaseFeePerGas != "" {
+		baseFee, err := ParseHexInt64(block.BaseFeePerGas)
+		if err != nil {
+			return nil, fmt.Errorf("pending baseFeePerGas: %w", err)
+		}
+		p.setBaseFee(baseFee)
+	}
+	if block.Number != "" {
+		number, err := ParseHexUint64(block.Number)
+		if err != nil {
+			return nil, fmt.Errorf("pending number: %w", err)
 		}
-		if usedGas+tx.GasLimit > gasLimit {
+		p.setBlock(number)
+	}
+
+	// Convert hex values to integers, quarantining anything malformed
+	pending := make(map[string]bool, len(block.Transactions))
+	for _, rtx := range block.Transactions {
+		pending[rtx.Hash] = true
+		tx, reason, err := rtx.toTransaction()
+		if err != nil {
+			p.Quarantine.Add(rtx.Hash, reason, err)
 			continue
 		}
-		usedGas += tx.GasLimit
+		tx.ReceivedAt = block.Received
+		p.addTx(tx)
+	}
+
+	return pending, nil
+}
+
+// Txs returns every pooled transaction, sorted by hash
+func (p *TxPool) Txs() []*Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	txs := slices.Collect(maps.Values(p.AllTxs))
+	slices.SortFunc(txs, func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) })
+	return txs
+}
+
+// PoolStats is a snapshot of the pool's size and admission counters
+type PoolStats struct {
+	Pooled     int                  `json:"pooled"`     // everything in AllTxs
+	Executable int                  `json:"executable"` // in Heap, eligible for selection
+	Queued     int                  `json:"queued"`     // parked behind a nonce gap
+	Low        int                  `json:"low"`        // deprioritized below the tip floor
+	Private    int                  `json:"private"`
+	Held       int                  `json:"held"` // public but not yet listed by the node
+	Required   int                  `json:"required"`
+	Rejections map[RejectReason]int `json:"rejections"`
+	Evicted    int                  `json:"evicted"`   // pushed out by a sender's better transactions
+	Bytes      int64                `json:"bytes"`     // approximate memory held
+	PeakBytes  int64                `json:"peakBytes"` // high-water mark of Bytes
+	MaxBytes   int64                `json:"maxBytes,omitempty"`
+}
+
+// Stats returns a consistent snapshot of the pool's counters
+func (p *TxPool) Stats() PoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return PoolStats{
+		Pooled:     len(p.AllTxs),
+		Executable: p.Heap.Len(),
+		Queued:     len(p.Queued),
+		Low:        p.Low.Len(),
+		Private:    len(p.Private),
+		Held:       len(p.Held),
+		Required:   len(p.Required),
+		Rejections: maps.Clone(p.Rejections),
+		Evicted:    p.Evicted,
+		Bytes:      p.bytes,
+		PeakBytes:  p.peakBytes,
+		MaxBytes:   p.MaxBytes,
+	}
+}
+
+// SetMaxTxGas updates the largest gas limit admitted, usually to follow
+// the chain gas limit; pooled transactions are kept
+func (p *TxPool) SetMaxTxGas(gas int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.MaxTxGas = gas
+}
+
+// LastScan reports the scan the most recent build ran under a ScanBudget,
+// or nil if it had none
+func (p *TxPool) LastScan() *ScanResult { return p.lastScan.Load() }
+
+// LastSearch reports the most recent "anneal" run, or nil if there was none
+func (p *TxPool) LastSearch() *SearchResult { return p.lastSearch.Load() }
+
+// LastExact reports the exact solving of the most recent "wis" run, or nil
+// if there was none
+func (p *TxPool) LastExact() *ExactResult { return p.lastExact.Load() }
+
+// LastParallel reports the most recent "parallel" run, or nil if there was
+// none
+func (p *TxPool) LastParallel() *ParallelResult { return p.lastParallel.Load() }
+
+// rpcTransaction is a transaction object as returned by eth_getBlockByNumber
+type rpcTransaction struct {
+	Hash                 string        `json:"hash"`
+	Type                 string        `json:"type,omitempty"`
+	ChainID              string        `json:"chainId,omitempty"`
+	Value                string        `json:"value,omitempty"`
+	From                 string        `json:"from,omitempty"`
+	To                   string        `json:"to,omitempty"`
+	GasPrice             string        `json:"gasPrice"`
+	MaxFeePerGas         string        `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas,omitempty"`
+	Gas                  string        `json:"gas"`
+	Nonce                string        `json:"nonce"`
+	Input                string        `json:"input,omitempty"`
+	AccessList           []AccessTuple `json:"accessList,omitempty"`
+}
+
+// encodeRPCTransaction is the inverse of rpcTransaction.toTransaction
+func encodeRPCTransaction(tx *Transaction) rpcTransaction {
+	rtx := rpcTransaction{
+		Hash:       tx.Hash,
+		From:       tx.From,
+		To:         tx.To,
+		GasPrice:   EncodeHexUint64(uint64(tx.GasPrice)),
+		Gas:        EncodeHexUint64(uint64(tx.GasLimit)),
+		Nonce:      EncodeHexUint64(uint64(tx.Nonce)),
+		Input:      EncodeHexBytes(tx.Input),
+		AccessList: tx.AccessList,
+	}
+	if tx.Type != 0 {
+		rtx.Type = EncodeHexUint64(uint64(tx.Type))
+	}
+	if tx.ChainID != 0 {
+		rtx.ChainID = EncodeHexUint64(tx.ChainID)
+	}
+	if tx.Value != nil {
+		rtx.Value = EncodeHexBig(tx.Value)
+	}
+	if tx.DynamicFee() {
+		rtx.MaxFeePerGas = rtx.GasPrice
+		rtx.MaxPriorityFeePerGas = EncodeHexUint64(uint64(tx.GasTipCap))
+	}
+	return rtx
+}
+
+// toTransaction decodes the hex fields, returning a quarantine reason on failure
+func (rtx *rpcTransaction) toTransaction() (*Transaction, QuarantineReason, error) {
+	if rtx.Hash == "" {
+		return nil, ReasonMissingHash, errors.New("transaction without hash")
+	}
+	gasPrice, err := ParseHexInt64(rtx.GasPrice)
+	if err != nil {
+		return nil, ReasonBadGasPrice, err
+	}
+	gasLimit, err := ParseHexInt64(rtx.Gas)
+	if err != nil {
+		return nil, ReasonBadGasLimit, err
+	}
+	nonce, err := ParseHexInt64(rtx.Nonce)
+	if err != nil {
+		return nil, ReasonBadNonce, err
+	}
+	var input []byte
+	if rtx.Input != "" && rtx.Input != "0x" {
+		if input, err = ParseHexBytes(rtx.Input); err != nil {
+			return nil, ReasonBadInput, err
+		}
+	}
+	var value *big.Int
+	if rtx.Value != "" {
+		if value, err = ParseHexBig(rtx.Value); err != nil {
+			return nil, ReasonBadValue, err
+		}
+		if value.Sign() == 0 {
+			value = nil
+		}
+	}
+	var typ, tipCap int64
+	var chainID uint64
+	if rtx.ChainID != "" {
+		if chainID, err = ParseHexUint64(rtx.ChainID); err != nil {
+			return nil, ReasonBadChainID, err
+		}
+	}
+	if rtx.Type != "" {
+		if typ, err = ParseHexInt64(rtx.Type); err != nil || typ > 0xff {
+			return nil, ReasonBadType, fmt.Errorf("type %q", rtx.Type)
+		}
+	}
+	// Dynamic-fee transactions are priced at their fee cap; gasPrice is
+	// only what they would pay at the node's idea of the base fee
+	if typ >= 2 {
+		if gasPrice, err = ParseHexInt64(rtx.MaxFeePerGas); err != nil {
+			return nil, ReasonBadGasPrice, fmt.Errorf("maxFeePerGas: %w", err)
+		}
+		if tipCap, err = ParseHexInt64(rtx.MaxPriorityFeePerGas); err != nil {
+			return nil, ReasonBadGasPrice, fmt.Errorf("maxPriorityFeePerGas: %w", err)
+		}
+	}
+
+	return &Transaction{
+		Hash:          rtx.Hash,
+		From:          strings.ToLower(rtx.From),
+		To:            strings.ToLower(rtx.To),
+		Create:        rtx.To == "", // nodes list "to" as null for creations
+		Input:         input,
+		Type:          uint8(typ),
+		GasPrice:      gasPrice,
+		GasTipCap:     tipCap,
+		GasLimit:      gasLimit,
+		Nonce:         int(nonce),
+		AccessList:    rtx.AccessList,
+		ChainID:       chainID,
+		Value:         value,
+		MEVBonus:      0, // This would need to be calculated or fetched from another source
+		PoLBonus:      0, // Same as above
+		ConflictsWith: []string{},
+	}, "", nil
+}
+
+// SelectTopTransactions opens the block with the required transactions,
+// failing with ErrInclusionList if they can't all be included, and the
+// priority lanes, then greedily packs the most profitable non-conflicting
+// transactions and matched MEV-Share bundles into gasLimit, filling any
+// space left with deprioritized transactions and bundles. A bundle and its
+// members, or two bundles sharing a member, are never both included; see
+// ConflictGraph. A transaction waits for its sender's lower pooled nonces
+// and is left out with them; see nonceChains. It works on copies of the
+// heaps, so the pool is left intact for the next build. It checks ctx
+// between transactions and returns ctx.Err() if the build is cancelled or
+// its deadline passes. Once budget is spent it stops scanning and returns
+// the block so far; see LastScan.
+func (p *TxPool) SelectTopTransactions(ctx context.Context, gasLimit int64, budget ScanBudget) ([]*Transaction, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.lastScan.Store(nil)
+	return p.selectGreedy(withScanBudget(ctx, budget), gasLimit, nil)
+}
+
+// selectGreedy implements SelectTopTransactions, offering the block to best
+// (if not nil) every time it grows so an interrupted build can fall back on
+// its progress
+func (p *TxPool) selectGreedy(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	queued, low := slices.Clone(p.Heap.TxHeap), slices.Clone(p.Low.TxHeap)
+	merged, sandwiches := p.matchedBundles()
+	queued = append(queued, merged...)
+	low = append(low, sandwiches...)
+	graph := p.conflictGraph(slices.Concat(queued[p.Heap.Len():], low[p.Low.Len():])...)
+
+	selected, usedGas, usedIDs, err := p.openBlock(gasLimit, graph)
+	if err != nil {
+		return nil, err
+	}
+	if best != nil {
+		best.Offer(selected)
+	}
+
+	chains := p.nonceChains(selected)
+	var take func(tx *Transaction)
+	take = func(tx *Transaction) {
+		if usedIDs[tx.Hash] || graph.Conflicts(tx.Hash, usedIDs) {
+			chains.skip(tx)
+			return
+		}
+		if usedGas+tx.GasLimit > gasLimit || graph.OverQuota(tx, usedIDs, gasLimit) {
+			chains.skip(tx)
+			return
+		}
+		if chains.wait(tx) {
+			return
+		}
+		usedGas += tx.PackGas()
 		usedIDs[tx.Hash] = true
 		selected = append(selected, tx)
+		if best != nil {
+			best.Offer(selected)
+		}
+		for _, t := range chains.taken(tx) {
+			take(t)
+		}
+	}
+	scan := startScan(ctx, len(queued)+len(low))
+	defer p.finishScan(scan)
+	for _, txs := range []TxHeap{queued, low} {
+		h := &rankedHeap{txs: txs, cmp: p.rank()}
+		p.Heap.Metrics.Init(h)
+		for h.Len() > 0 && usedGas < gasLimit && scan.next() {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			take(p.Heap.Metrics.Pop(h).(*Transaction))
+		}
 	}
 
-	return selected
+	return selected, nil
+}
+
+// openBlock returns the required transactions followed by the decrypted,
+// committed, express lane and lane transactions, with the gas and hashes
+// they use
+func (p *TxPool) openBlock(gasLimit int64, graph *ConflictGraph) ([]*Transaction, int64, map[string]bool, error) {
+	selected := []*Transaction{}
+	usedGas := int64(0)
+	usedIDs := map[string]bool{}
+	required, err := p.required(gasLimit, graph)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	for _, tx := range required {
+		usedGas += tx.PackGas()
+		usedIDs[tx.Hash] = true
+		selected = append(selected, tx)
+	}
+	for _, slots := range [][]string{p.Encrypted.revealed(), p.Committed} {
+		for _, tx := range p.fillSlots(slots, gasLimit-usedGas, usedIDs, graph) {
+			usedGas += tx.PackGas()
+			usedIDs[tx.Hash] = true
+			selected = append(selected, tx)
+		}
+	}
+	for _, tx := range p.fillExpress(gasLimit-usedGas, usedIDs, graph) {
+		usedGas += tx.PackGas()
+		usedIDs[tx.Hash] = true
+		selected = append(selected, tx)
+	}
+	for _, tx := range p.fillLanes(gasLimit-usedGas, usedIDs, graph) {
+		usedGas += tx.PackGas()
+		usedIDs[tx.Hash] = true
+		selected = append(selected, tx)
+	}
+	return selected, usedGas, usedIDs, nil
 }
 
 // FormatWei converts wei to a human-readable string
@@ -201,24 +1336,22 @@ func FormatWei(wei int64) string {
 	return fmt.Sprintf("%.6f BERA", bera)
 }
 
-func main() {
-	pool := NewTxPool()
-
-	// Fetch transactions from Berachain RPC
-	if err := pool.FetchTransactions(); err != nil {
-		fmt.Printf("Error fetching transactions: %v\n", err)
-		return
-	}
-
-	blockGasLimit := int64(30000000) // https://docs.berachain.com/learn/help/faqs#what-do-berachain-s-performance-metrics-look-like
-	selectedTxs := pool.SelectTopTransactions(blockGasLimit)
-
-	fmt.Printf("\nSelected Transactions for Block (Gas Limit: %d):\n", blockGasLimit)
+// PrintBlock writes the selected transactions and their total profit
+func PrintBlock(w io.Writer, txs []*Transaction, gasLimit int64) {
+	fmt.Fprintf(w, "\nSelected Transactions for Block (Gas Limit: %d):\n", gasLimit)
 	totalProfit := int64(0)
-	for _, tx := range selectedTxs {
+	for _, tx := range txs {
 		txProfit := tx.Profit()
 		totalProfit += txProfit
-		fmt.Printf(" - %s | Profit: %s | Gas: %d\n", tx.Hash, FormatWei(txProfit), tx.GasLimit)
+		fmt.Fprintf(w, " - %s | Profit: %s | Gas: %d\n", tx.Hash, FormatWei(txProfit), tx.GasLimit)
 	}
-	fmt.Printf("\nTotal Profit: %s\n", FormatWei(totalProfit))
+	fmt.Fprintf(w, "\nTotal Profit: %s\n", FormatWei(totalProfit))
+}
+
+func main() {
+	// Root context, cancelled on SIGINT/SIGTERM so in-flight work aborts cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := RunCLI(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"unsafe"
+)
+
+// DefaultMaxPoolBytes caps the pool's memory estimate unless pool.max_bytes
+// says otherwise
+const DefaultMaxPoolBytes = 1 << 30
+
+// txOverhead approximates what the pool spends on a transaction besides
+// its variable-length fields: the struct itself and its entries in
+// AllTxs, the sender index, a heap and the seen cache
+const txOverhead = int64(unsafe.Sizeof(Transaction{})) + 256
+
+// footprint approximates the memory tx holds while pooled, in bytes
+func (tx *Transaction) footprint() int64 {
+	n := txOverhead + int64(len(tx.Hash)+len(tx.From)+len(tx.To)+len(tx.PoLVault)+len(tx.Input)+len(tx.Raw))
+	for _, hashes := range [][]string{tx.ConflictsWith, tx.MustFollow, tx.Bundle} {
+		for _, h := range hashes {
+			n += int64(unsafe.Sizeof(h)) + int64(len(h))
+		}
+	}
+	for _, t := range tx.AccessList {
+		n += int64(unsafe.Sizeof(t)) + int64(len(t.Address))
+		for _, key := range t.StorageKeys {
+			n += int64(unsafe.Sizeof(key)) + int64(len(key))
+		}
+	}
+	if tx.Value != nil {
+		n += int64(unsafe.Sizeof(*tx.Value)) + int64(len(tx.Value.Bits()))*int64(unsafe.Sizeof(big.Word(0)))
+	}
+	return n
+}
+
+// account moves the pool's memory estimate from old to tx, either of which
+// may be nil, raising the high-water mark as it grows
+func (p *TxPool) account(old, tx *Transaction) {
+	if old != nil {
+		p.bytes -= old.footprint()
+	}
+	if tx != nil {
+		p.bytes += tx.footprint()
+	}
+	p.peakBytes = max(p.peakBytes, p.bytes)
+}
+
+// Full reports whether the pool holds its MaxBytes, so endpoints should
+// turn transactions away before decoding them
+func (p *TxPool) Full() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.MaxBytes > 0 && p.bytes >= p.MaxBytes
+}
+
+// Full reports whether the shards together hold their MaxBytes
+func (s *ShardedPool) Full() bool {
+	var held, limit int64
+	for _, shard := range s.Shards {
+		shard.mu.RLock()
+		held, limit = held+shard.bytes, limit+shard.MaxBytes
+		shard.mu.RUnlock()
+	}
+	return limit > 0 && held >= limit
+}
+
+// backpressure turns a submission away with 503 Service Unavailable while
+// the pool is full, reporting whether it did
+func (s *APIServer) backpressure(w http.ResponseWriter) bool {
+	if !s.Pool.Full() {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "pool is full", http.StatusServiceUnavailable)
+	return true
+}
+
+// writePoolMetrics writes the pool's size and memory estimate in the
+// Prometheus text exposition format
+func writePoolMetrics(w io.Writer, st PoolStats) {
+	metric := func(name, kind, help string, v any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("bce_pool_transactions", "gauge", "Pooled transactions.", st.Pooled)
+	metric("bce_pool_bytes", "gauge", "Approximate memory held by pooled transactions.", st.Bytes)
+	metric("bce_pool_peak_bytes", "gauge", "High-water mark of the pool's memory estimate.", st.PeakBytes)
+	metric("bce_pool_max_bytes", "gauge", "Memory the pool may hold before rejecting new transactions; 0 is unbounded.", st.MaxBytes)
+	metric("bce_pool_full_rejections_total", "counter", "Transactions rejected because the pool was full.", st.Rejections[RejectPoolFull])
+	metric("bce_pool_sender_evictions_total", "counter", "Transactions pushed out by a better one of the same sender.", st.Evicted)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"sync"
+)
+
+// eth_simulateV1 with traceTransfers reports every native value transfer
+// as an ERC-20 style Transfer log from this pseudo-address
+var (
+	transferLogAddress = Address{0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee, 0xee}
+	transferLogTopic   = Keccak256([]byte("Transfer(address,address,uint256)"))
+)
+
+// MEVSimulator measures what pending transactions and bundles pay the
+// builder directly. It simulates each with eth_simulateV1 on top of the
+// latest block, the fee recipient overridden to the builder's address and
+// native transfers traced, and sums the value sent to that address: a
+// block.coinbase transfer or a payment straight to the builder. Fees are
+// not transfers, so tips aren't counted twice. The sum becomes the
+// transaction's MEVBonus, replacing whatever it declared; a bundle's is
+// measured with its members run in order and kept on the HintBook.
+//
+// With Local set, entries are executed in process instead, with the
+// builder as coinbase, and only those it can't run go to the node.
+//
+// Only transactions with a known sender can be simulated, as for revert
+// protection, and only native BERA payments are seen, not token ones.
+type MEVSimulator struct {
+	RPC        *RPCClient
+	Recipient  Address     // address payments are credited to
+	MaxTxs     int         // most profitable transactions simulated per analysis
+	MaxBundles int         // bundles simulated per analysis, most reputable searchers' first
+	Workers    *WorkerPool // simulates entries concurrently if set, else in one batch
+	Local      *LocalEVM   // executes entries in process first if set
+
+	mu    sync.Mutex
+	bonus map[string]int64 // by hash; simulated once per transaction
+}
+
+// NewMEVSimulator returns a simulator crediting payments to recipient, or
+// nil if MEV bonus simulation is disabled
+func NewMEVSimulator(rpc *RPCClient, recipient string, cfg MEVBonusConfig, workers *WorkerPool) (*MEVSimulator, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	addr, err := HexToAddress(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("mevbonus: recipient: %w", err)
+	}
+	return &MEVSimulator{RPC: rpc, Recipient: addr, MaxTxs: cfg.MaxTxs, MaxBundles: cfg.MaxBundles, Workers: workers, bonus: make(map[string]int64)}, nil
+}
+
+// simLogs is the part of an eth_simulateV1 call result the simulator uses
+type simLogs struct {
+	Logs []Log `json:"logs"`
+}
+
+// Analyze simulates the most profitable pooled transactions and the bundles
+// in hints not simulated yet, up to MaxBundles of them, and sets each one's
+// MEVBonus to what it pays the builder. It returns the number of
+// transactions and bundles whose MEVBonus changed.
+func (s *MEVSimulator) Analyze(ctx context.Context, pool Mempool, hints *HintBook) (updated int, err error) {
+	ctx, span := StartSpan(ctx, "mevbonus.simulate", "mevbonus.recipient", s.Recipient.Hex())
+	defer func() {
+		span.SetAttr("mevbonus.updated", updated)
+		span.SetError(err)
+		span.End()
+	}()
+
+	txs := pool.Txs()
+	slices.SortStableFunc(txs, func(x, y *Transaction) int { return cmpScore(y, x) })
+	if s.MaxTxs > 0 && len(txs) > s.MaxTxs {
+		txs = txs[:s.MaxTxs]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live := make(map[string]bool, len(txs))
+	var entries [][]*Transaction
+	for _, tx := range txs {
+		live[tx.Hash] = true
+		if _, done := s.bonus[tx.Hash]; !done && tx.From != "" {
+			entries = append(entries, []*Transaction{tx})
+		}
+	}
+	maps.DeleteFunc(s.bonus, func(hash string, _ int64) bool { return !live[hash] })
+	var bundles []string
+	if hints != nil {
+		ids, members := hints.Unsimulated()
+		for i, id := range ids {
+			if s.MaxBundles > 0 && len(bundles) == s.MaxBundles {
+				break
+			}
+			if !slices.ContainsFunc(members[i], func(m *Transaction) bool { return m.From == "" }) {
+				bundles = append(bundles, id)
+				entries = append(entries, members[i])
+			}
+		}
+	}
+	span.SetAttr("mevbonus.simulated", len(entries))
+	paid, err := s.simulate(ctx, entries)
+	if err != nil {
+		return 0, err
+	}
+
+	plain := len(entries) - len(bundles)
+	for i, v := range paid[:plain] {
+		if v >= 0 {
+			s.bonus[entries[i][0].Hash] = v
+		}
+	}
+	for i, id := range bundles {
+		if v := paid[plain+i]; v < 0 {
+			hints.SimulationFailed(id)
+		} else if hints.SetMEVBonus(id, v) {
+			updated++
+		}
+	}
+	for _, tx := range txs {
+		v, ok := s.bonus[tx.Hash]
+		if !ok || v == tx.MEVBonus {
+			continue
+		}
+		credited := *tx
+		credited.MEVBonus = v
+		pool.AddTx(&credited)
+		updated++
+	}
+	return updated, nil
+}
+
+// simulate runs each entry's transactions in order in its own simulation
+// and returns what each paid the recipient, or -1 for an entry whose
+// simulation failed so it is retried next time. Entries Local can't
+// execute, or all without it, are simulated by the node.
+func (s *MEVSimulator) simulate(ctx context.Context, entries [][]*Transaction) ([]int64, error) {
+	paid := make([]int64, len(entries))
+	var remote [][]*Transaction
+	var at []int
+	for i, txs := range entries {
+		if s.Local != nil {
+			if v, err := s.paidLocally(ctx, txs); err == nil {
+				paid[i] = v
+				continue
+			}
+		}
+		remote = append(remote, txs)
+		at = append(at, i)
+	}
+	if s.Local != nil {
+		SpanFromContext(ctx).SetAttr("mevbonus.local", len(entries)-len(remote))
+	}
+	simulated, err := s.simulateRemote(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	for j, v := range simulated {
+		paid[at[j]] = v
+	}
+	return paid, nil
+}
+
+// paidLocally executes txs in order with Local and returns what they sent
+// the recipient, saturating at math.MaxInt64
+func (s *MEVSimulator) paidLocally(ctx context.Context, txs []*Transaction) (int64, error) {
+	results, _, err := s.Local.Execute(ctx, s.Recipient, txs)
+	if err != nil {
+		return 0, err
+	}
+	var paid int64
+	for _, res := range results {
+		for _, t := range res.Transfers {
+			if t.To != s.Recipient {
+				continue
+			}
+			v := int64(math.MaxInt64)
+			if t.Value.IsInt64() {
+				v = t.Value.Int64()
+			}
+			paid = saturatingAdd(paid, v)
+		}
+	}
+	return paid, nil
+}
+
+// simulateRemote simulates each entry with eth_simulateV1, batched into
+// one request, as simulate does
+func (s *MEVSimulator) simulateRemote(ctx context.Context, entries [][]*Transaction) ([]int64, error) {
+	paid := make([]int64, len(entries))
+	if len(entries) == 0 {
+		return paid, nil
+	}
+	results := make([][]struct {
+		Calls []simLogs `json:"calls"`
+	}, len(entries))
+	elems := make([]BatchElem, len(entries))
+	for i, txs := range entries {
+		calls := make([]map[string]string, len(txs))
+		for j, tx := range txs {
+			calls[j] = callObject(tx)
+		}
+		params := map[string]any{
+			"blockStateCalls": []any{map[string]any{
+				"blockOverrides": map[string]string{"feeRecipient": s.Recipient.Hex()},
+				"calls":          calls,
+			}},
+			"traceTransfers": true,
+		}
+		elems[i] = BatchElem{Method: "eth_simulateV1", Params: []any{params, "latest"}, Result: &results[i]}
+	}
+	if s.Workers != nil {
+		heads := make([]*Transaction, len(entries))
+		groups := make([][]BatchElem, len(entries))
+		for i := range entries {
+			heads[i], groups[i] = entries[i][0], elems[i:i+1]
+		}
+		stats := s.Workers.Calls(ctx, s.RPC, heads, groups)
+		SpanFromContext(ctx).SetAttr("mevbonus.workers", stats.String())
+	} else if err := s.RPC.BatchCall(ctx, elems); err != nil {
+		return nil, fmt.Errorf("simulating builder payments: %w", err)
+	}
+	for i := range entries {
+		if elems[i].Error != nil || len(results[i]) != 1 {
+			paid[i] = -1
+			continue
+		}
+		for _, call := range results[i][0].Calls {
+			in, _ := nativeTransfers(call.Logs, s.Recipient)
+			paid[i] = saturatingAdd(paid[i], in)
+		}
+	}
+	return paid, nil
+}
+
+// isTransferLog reports whether l is a native transfer traced by
+// eth_simulateV1 rather than a log the transaction emitted
+func isTransferLog(l *Log) bool {
+	return l.Address == transferLogAddress && len(l.Topics) == 3 && l.Topics[0] == transferLogTopic
+}
+
+// nativeTransfers sums the traced native transfers into and out of addr
+// among logs, each saturating at math.MaxInt64
+func nativeTransfers(logs []Log, addr Address) (in, out int64) {
+	for i := range logs {
+		l := &logs[i]
+		if !isTransferLog(l) {
+			continue
+		}
+		v := int64(math.MaxInt64)
+		if b := new(big.Int).SetBytes(l.Data); b.IsInt64() {
+			v = b.Int64()
+		}
+		if Address(l.Topics[2][12:]) == addr {
+			in = saturatingAdd(in, v)
+		}
+		if Address(l.Topics[1][12:]) == addr {
+			out = saturatingAdd(out, v)
+		}
+	}
+	return in, out
+}
+
+// saturatingAdd returns x+y, or math.MaxInt64 or math.MinInt64 if that
+// overflows
+func saturatingAdd(x, y int64) int64 {
+	switch {
+	case y > 0 && x > math.MaxInt64-y:
+		return math.MaxInt64
+	case y < 0 && x < math.MinInt64-y:
+		return math.MinInt64
+	}
+	return x + y
+}
+
+// saturatingSub returns x-y, or math.MaxInt64 or math.MinInt64 if that
+// overflows
+func saturatingSub(x, y int64) int64 {
+	switch {
+	case y < 0 && x > math.MaxInt64+y:
+		return math.MaxInt64
+	case y > 0 && x < math.MinInt64+y:
+		return math.MinInt64
+	}
+	return x - y
+}
+
+// saturatingMul returns x*y, or math.MaxInt64 or math.MinInt64 if that
+// overflows
+func saturatingMul(x, y int64) int64 {
+	if x == 0 || y == 0 {
+		return 0
+	}
+	if p := x * y; p/y == x && !(x == math.MinInt64 && y == -1) {
+		return p
+	}
+	if (x > 0) == (y > 0) {
+		return math.MaxInt64
+	}
+	return math.MinInt64
+}
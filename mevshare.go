@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMEVShareURL is the Flashbots MEV-Share event stream
+const DefaultMEVShareURL = "https://mev-share.flashbots.net"
+
+// DefaultHintTTL is how long a hint is kept for bundles to match against
+const DefaultHintTTL = 30 * time.Second
+
+// DefaultHintGas prices a hinted transaction whose hint omits gasUsed
+const DefaultHintGas = int64(21000)
+
+// Hint is a partially revealed pending transaction from an MEV-Share event
+// stream. Only the fields the user chose to share are set.
+type Hint struct {
+	Hash        string    `json:"hash"`
+	Logs        []HintLog `json:"logs"`
+	Txs         []HintTx  `json:"txs"`
+	MevGasPrice string    `json:"mevGasPrice,omitempty"` // hex wei
+	GasUsed     string    `json:"gasUsed,omitempty"`     // hex
+	Received    time.Time `json:"-"`
+}
+
+// HintLog is a shared log of the hinted transaction
+type HintLog struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+// HintTx carries the shared fields of one transaction of the hint
+type HintTx struct {
+	To               string `json:"to,omitempty"`
+	FunctionSelector string `json:"functionSelector,omitempty"`
+	CallData         string `json:"callData,omitempty"`
+}
+
+// Transaction represents the hint in the pool: a transaction known only by
+// hash, priced at the shared MEV gas price if any. It has no raw bytes, so
+// it can only be built with as part of a bundle the matchmaker completes.
+func (h *Hint) Transaction() *Transaction {
+	tx := &Transaction{Hash: strings.ToLower(h.Hash), GasLimit: DefaultHintGas, ConflictsWith: []string{}}
+	if gas, err := ParseHexInt64(h.GasUsed); err == nil && gas > 0 {
+		tx.GasLimit = gas
+	}
+	if price, err := ParseHexInt64(h.MevGasPrice); err == nil {
+		tx.GasPrice = price
+	}
+	if len(h.Txs) == 1 {
+		tx.To = strings.ToLower(h.Txs[0].To)
+	}
+	return tx
+}
+
+// HintClient consumes an MEV-Share server-sent event stream
+type HintClient struct {
+	URL  string
+	HTTP *http.Client
+}
+
+func NewHintClient(url string) *HintClient {
+	return &HintClient{URL: url, HTTP: httpClient}
+}
+
+// Subscribe streams hints to fn until ctx is cancelled or the stream ends,
+// in which case it returns io.EOF. Events that don't decode are skipped.
+func (c *HintClient) Subscribe(ctx context.Context, fn func(*Hint)) error {
+	err := subscribeEvents(ctx, c.HTTP, c.URL, func(data []byte) {
+		var h Hint
+		if json.Unmarshal(data, &h) == nil && h.Hash != "" {
+			h.Received = time.Now()
+			fn(&h)
+		}
+	})
+	if err != nil && !errors.Is(err, io.EOF) && ctx.Err() == nil {
+		return fmt.Errorf("subscribing to hints: %w", err)
+	}
+	return err
+}
+
+// subscribeEvents reads the server-sent event stream at url, passing the
+// data of each event to fn, until ctx is cancelled or the stream ends, in
+// which case it returns io.EOF
+func subscribeEvents(ctx context.Context, client *http.Client, url string, fn func(data []byte)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPError{StatusCode: resp.StatusCode}
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	var data strings.Builder
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(rest, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue // comments, other fields, or keep-alives
+		}
+		fn([]byte(data.String()))
+		data.Reset()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// Bundle is an ordered group of transactions a searcher wants included
+// atomically. Body items either carry a full transaction or reference a
+// hinted one by hash.
+type Bundle struct {
+	ID       string       `json:"id"`
+	Searcher string       `json:"searcher,omitempty"`
+	Body     []BundleItem `json:"body"`
+
+	// RevertingTxHashes lists the members allowed to revert; with revert
+	// protection on, the bundle is dropped if any other member reverts
+	RevertingTxHashes []string `json:"revertingTxHashes,omitempty"`
+
+	// ReplacementUUID, chosen by the searcher, names the bundle for later
+	// replacement or cancellation: a bundle submitted with the same
+	// searcher and UUID takes the place of the earlier one
+	ReplacementUUID string `json:"replacementUuid,omitempty"`
+}
+
+// BundleCancellation withdraws the bundle a searcher submitted under a
+// replacement UUID
+type BundleCancellation struct {
+	Searcher        string `json:"searcher,omitempty"`
+	ReplacementUUID string `json:"replacementUuid"`
+}
+
+// BundleItem is one entry of a bundle body
+type BundleItem struct {
+	Hash string       `json:"hash,omitempty"` // reference to a hinted or pooled transaction
+	Tx   *Transaction `json:"tx,omitempty"`
+}
+
+// HintBook holds recent hints and the bundles that reference them. Its
+// methods are safe for concurrent use; TTL and Sandwich must be set before
+// it is shared.
+type HintBook struct {
+	TTL      time.Duration
+	Sandwich SandwichPolicy
+	Merge    bool // combine bundles competing for the same opportunity; see mergeOverlapping
+
+	mu         sync.Mutex
+	flagged    map[string]string // bundle ID -> victim hash of detected sandwiches
+	hints      map[string]*Hint
+	bundles    map[string]*Bundle
+	mev        map[string]int64     // bundle ID -> simulated payment to the builder
+	composites map[string][]string  // merged entry ID -> IDs of the bundles it combines, as of the last Matched
+	dropped    map[string]string    // bundle ID -> why the last Matched merged it away
+	uuids      map[string]string    // replacementKey -> ID of the bundle it names
+	cancelled  map[string]time.Time // bundle or merged entry ID -> when it was cancelled or replaced
+	searchers  map[string]*SearcherStats
+	built      map[string]builtBundle // bundle ID -> its searcher and members, of the last block built
+}
+
+func NewHintBook(ttl time.Duration) *HintBook {
+	return &HintBook{
+		TTL:      ttl,
+		Sandwich: SandwichReject,
+		flagged:  map[string]string{},
+		hints:    map[string]*Hint{},
+		bundles:  map[string]*Bundle{},
+		mev:      map[string]int64{},
+
+		composites: map[string][]string{},
+		dropped:    map[string]string{},
+		uuids:      map[string]string{},
+		cancelled:  map[string]time.Time{},
+		searchers:  map[string]*SearcherStats{},
+		built:      map[string]builtBundle{},
+	}
+}
+
+// AddHint records a hint, replacing an earlier one for the same hash
+func (b *HintBook) AddHint(h *Hint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h.Received.IsZero() {
+		h.Received = time.Now()
+	}
+	b.hints[strings.ToLower(h.Hash)] = h
+}
+
+// AddBundle records a bundle under its ID, replacing any earlier version.
+// A bundle with a ReplacementUUID also replaces the one its searcher last
+// submitted under that UUID, which is cancelled in the same step, so no
+// build sees both or neither; if the new bundle is rejected the old one
+// stays.
+func (b *HintBook) AddBundle(bundle *Bundle) error {
+	if bundle.ID == "" {
+		return fmt.Errorf("bundle without id")
+	}
+	if len(bundle.Body) == 0 {
+		return fmt.Errorf("bundle %s: empty body", bundle.ID)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, item := range bundle.Body {
+		if (item.Hash == "") == (item.Tx == nil) {
+			return fmt.Errorf("bundle %s: body[%d] needs exactly one of hash or tx", bundle.ID, i)
+		}
+		if item.Tx != nil && item.Tx.ConflictsWith == nil {
+			item.Tx.ConflictsWith = []string{}
+		}
+	}
+	if members := b.resolve(bundle); members != nil && b.Sandwich == SandwichReject {
+		if victim, ok := DetectSandwich(members); ok {
+			b.flagged[bundle.ID] = victim
+			return fmt.Errorf("bundle %s: sandwiches %s", bundle.ID, victim)
+		}
+	}
+	if bundle.ReplacementUUID != "" {
+		key := replacementKey(bundle.Searcher, bundle.ReplacementUUID)
+		if old, ok := b.uuids[key]; ok && old != bundle.ID {
+			b.cancel(old)
+		}
+		b.uuids[key] = bundle.ID
+	}
+	b.bundles[bundle.ID] = bundle
+	delete(b.mev, bundle.ID)
+	delete(b.cancelled, bundle.ID)
+	b.searcher(bundle.Searcher).Submitted++
+	return nil
+}
+
+// CancelBundle withdraws the bundle searcher last submitted under
+// replacement UUID uuid, returning its ID. From then on no block built or
+// bid on includes it (see Cancelled), until it is submitted again.
+func (b *HintBook) CancelBundle(searcher, uuid string) (string, error) {
+	if uuid == "" {
+		return "", fmt.Errorf("cancellation without replacementUuid")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := replacementKey(searcher, uuid)
+	id, ok := b.uuids[key]
+	if !ok {
+		return "", fmt.Errorf("no bundle with replacementUuid %s", uuid)
+	}
+	delete(b.uuids, key)
+	b.cancel(id)
+	return id, nil
+}
+
+// cancel drops bundle id, marking it and the merged entries of the last
+// Matched combining it as cancelled. Called with the book locked.
+func (b *HintBook) cancel(id string) {
+	now := time.Now()
+	delete(b.bundles, id)
+	delete(b.flagged, id)
+	delete(b.mev, id)
+	b.cancelled[id] = now
+	for entry, ids := range b.composites {
+		if slices.Contains(ids, id) {
+			b.cancelled[entry] = now
+		}
+	}
+}
+
+// Cancelled returns the IDs of the bundle entries of txs that have been
+// cancelled or replaced since they were matched. A block holding one must
+// be rebuilt rather than bid.
+func (b *HintBook) Cancelled(txs []*Transaction) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []string
+	for _, tx := range txs {
+		if _, ok := b.cancelled[tx.Hash]; ok && len(tx.Bundle) > 0 {
+			ids = append(ids, tx.Hash)
+		}
+	}
+	return ids
+}
+
+// replacementKey scopes a replacement UUID to the searcher choosing it
+func replacementKey(searcher, uuid string) string {
+	return searcher + "/" + strings.ToLower(uuid)
+}
+
+// resolve returns the bundle's transactions, or nil if a referenced hint
+// is unknown
+func (b *HintBook) resolve(bundle *Bundle) []*Transaction {
+	members := make([]*Transaction, 0, len(bundle.Body))
+	for _, item := range bundle.Body {
+		if item.Tx != nil {
+			members = append(members, item.Tx)
+			continue
+		}
+		h := b.hints[strings.ToLower(item.Hash)]
+		if h == nil {
+			return nil
+		}
+		members = append(members, h.Transaction())
+	}
+	return members
+}
+
+// Prune drops hints older than TTL along with bundles referencing them, and
+// forgets cancellations older than TTL
+func (b *HintBook) Prune() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cutoff := time.Now().Add(-b.TTL)
+	for hash, h := range b.hints {
+		if h.Received.Before(cutoff) {
+			delete(b.hints, hash)
+		}
+	}
+	for id, bundle := range b.bundles {
+		for _, item := range bundle.Body {
+			if item.Hash != "" && b.hints[strings.ToLower(item.Hash)] == nil {
+				delete(b.bundles, id)
+				delete(b.flagged, id)
+				delete(b.mev, id)
+				break
+			}
+		}
+	}
+	for key, id := range b.uuids {
+		if b.bundles[id] == nil {
+			delete(b.uuids, key)
+		}
+	}
+	for id, at := range b.cancelled {
+		if at.Before(cutoff) {
+			delete(b.cancelled, id)
+		}
+	}
+}
+
+// Len returns the number of hints and bundles held
+func (b *HintBook) Len() (hints, bundles int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.hints), len(b.bundles)
+}
+
+// Unsimulated returns the IDs and members of every resolvable bundle whose
+// payment to the builder hasn't been simulated yet, those of the most
+// reputable searchers first, so they are simulated first when not all can
+// be
+func (b *HintBook) Unsimulated() (ids []string, members [][]*Transaction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, bundle := range b.bundles {
+		if _, done := b.mev[id]; !done && b.resolve(bundle) != nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(x, y string) int {
+		rx, ry := b.reputation(b.bundles[x].Searcher), b.reputation(b.bundles[y].Searcher)
+		return cmp.Or(cmp.Compare(ry, rx), strings.Compare(x, y))
+	})
+	for _, id := range ids {
+		members = append(members, b.resolve(b.bundles[id]))
+	}
+	return ids, members
+}
+
+// SetMEVBonus records v as what bundle id pays the builder, replacing its
+// members' own MEVBonus when it is merged. It reports whether that changed
+// the bundle's MEVBonus; it is false if the bundle is gone.
+func (b *HintBook) SetMEVBonus(id string, v int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bundle := b.bundles[id]
+	if bundle == nil {
+		return false
+	}
+	b.mev[id] = v
+	b.searcher(bundle.Searcher).Simulated++
+	declared := int64(0)
+	for _, m := range b.resolve(bundle) {
+		declared += m.MEVBonus
+	}
+	return v != declared
+}
+
+// Flagged returns the victim hash of every bundle detected as a sandwich,
+// keyed by bundle ID
+func (b *HintBook) Flagged() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return maps.Clone(b.flagged)
+}
+
+// Matched merges every bundle whose hash references all resolve to known
+// hints into a single transaction, keyed by the bundle ID, whose profit is
+// the sum of its members', or their fees and the simulated payment to the
+// builder once SetMEVBonus has recorded one. Members are listed in Bundle so a packer never
+// includes them twice. Bundles detected as sandwiches are dropped, or
+// returned in low to be packed last, according to the Sandwich policy.
+// With Merge set, bundles competing for the same opportunity are first
+// combined into their most valuable compatible subset.
+func (b *HintBook) Matched() (merged, low []*Transaction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var cands []mergeCandidate
+	for _, bundle := range b.bundles {
+		members := b.resolve(bundle)
+		if members == nil {
+			continue
+		}
+		tx := mergeBundle(bundle.ID, members)
+		tx.Reputation = b.reputation(bundle.Searcher)
+		if v, ok := b.mev[bundle.ID]; ok {
+			for _, m := range members {
+				tx.MEVBonus -= m.MEVBonus
+			}
+			tx.MEVBonus += v
+		}
+		victim, sandwich := "", false
+		if b.Sandwich != SandwichAllow {
+			victim, sandwich = DetectSandwich(members)
+		}
+		switch {
+		case !sandwich && b.Merge:
+			cands = append(cands, mergeCandidate{id: bundle.ID, members: members, tx: tx})
+		case !sandwich:
+			merged = append(merged, tx)
+		case b.Sandwich == SandwichDeprioritize:
+			b.flagged[bundle.ID] = victim
+			low = append(low, tx)
+		default:
+			b.flagged[bundle.ID] = victim
+		}
+	}
+	if b.Merge {
+		merged = b.mergeOverlapping(cands)
+	}
+	return merged, low
+}
+
+// Members returns the transactions of bundle id, or of a merged entry of
+// several, and the lower-case hashes of those allowed to revert, or false
+// if the bundle is unknown or references an unknown hint
+func (b *HintBook) Members(id string) ([]*Transaction, map[string]bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.composites[id]; ok {
+		return b.composite(id)
+	}
+	bundle := b.bundles[id]
+	if bundle == nil {
+		return nil, nil, false
+	}
+	members := b.resolve(bundle)
+	if members == nil {
+		return nil, nil, false
+	}
+	reverting := make(map[string]bool, len(bundle.RevertingTxHashes))
+	for _, h := range bundle.RevertingTxHashes {
+		reverting[strings.ToLower(h)] = true
+	}
+	return members, reverting, true
+}
+
+// matchedBundles returns the pool's matched bundles, as HintBook.Matched,
+// less the excluded ones
+func (p *TxPool) matchedBundles() (merged, low []*Transaction) {
+	if p.Hints == nil {
+		return nil, nil
+	}
+	merged, low = p.Hints.Matched()
+	for _, tx := range slices.Concat(merged, low) {
+		p.stamp(tx)
+	}
+	excluded := func(tx *Transaction) bool { return p.Excluded[tx.Hash] }
+	return slices.DeleteFunc(merged, excluded), slices.DeleteFunc(low, excluded)
+}
+
+// mergeBundle folds members into one transaction carrying their total gas
+// and profit. Its fees at any base fee are the sum of what each member pays
+// at it (see Fees); its fee cap and tip cap are only the members'
+// gas-weighted means, rounded down, a legacy member's tip cap being its
+// gas price.
+func mergeBundle(id string, members []*Transaction) *Transaction {
+	tx := &Transaction{Hash: id, Type: 2, ConflictsWith: []string{}, members: members}
+	fees, tips := int64(0), int64(0)
+	for _, m := range members {
+		tx.GasLimit += m.GasLimit
+		fees += m.GasPrice * m.GasLimit
+		if m.DynamicFee() {
+			tips += m.GasTipCap * m.GasLimit
+		} else {
+			tips += m.GasPrice * m.GasLimit
+		}
+		tx.MEVBonus += m.MEVBonus
+		tx.PoLBonus += m.PoLBonus
+		tx.Bundle = append(tx.Bundle, m.Hash)
+		tx.ConflictsWith = append(tx.ConflictsWith, m.ConflictsWith...)
+	}
+	if tx.GasLimit > 0 {
+		tx.GasPrice = fees / tx.GasLimit
+		tx.GasTipCap = tips / tx.GasLimit
+	}
+	return tx
+}
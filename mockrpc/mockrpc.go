@@ -0,0 +1,506 @@
+// Package mockrpc is an in-process Berachain JSON-RPC server backed by
+// httptest. It serves a configurable pending block, chain heads,
+// txpool_content, balances, nonces and block filters, and can inject errors
+// and latency per method, so a node client's fetch and build paths can be
+// exercised end to end without a node. New heads are seen by polling
+// eth_getBlockByNumber or through eth_newBlockFilter and
+// eth_getFilterChanges; there is no eth_subscribe("newHeads"), as clients
+// talk to it over plain HTTP. It speaks the wire format only, and depends on
+// nothing but the standard library.
+package mockrpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChainID is the chain ID served by eth_chainId, Berachain mainnet's
+const ChainID = 80094
+
+// DefaultGasLimit is the gas limit of the genesis head
+const DefaultGasLimit = int64(30000000)
+
+// JSON-RPC error codes the server answers with
+const (
+	CodeParse          = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeServer         = -32000
+)
+
+// Tx is a transaction as served in blocks and txpool_content. A Type of 2
+// or more prices it by GasPrice as the fee cap and GasTipCap as the tip.
+type Tx struct {
+	Hash       string
+	From       string // empty to derive a placeholder from Hash
+	To         string
+	Type       uint8
+	ChainID    uint64
+	Nonce      int
+	GasPrice   int64
+	GasTipCap  int64
+	GasLimit   int64
+	Value      *big.Int
+	Input      []byte
+	AccessList []AccessTuple
+	Raw        []byte // served by eth_getRawTransactionByHash, if set
+}
+
+// AccessTuple is an EIP-2930 access list entry
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// Head is a block header the server has produced
+type Head struct {
+	Number     uint64
+	Hash       string
+	ParentHash string
+	GasLimit   int64
+	GasUsed    int64
+	BaseFee    int64
+	Timestamp  uint64
+}
+
+// Error is a JSON-RPC error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message) }
+
+// Server is the mock node. Its methods are safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	pending []*Tx
+	heads   []*Head
+	mined   map[uint64][]*Tx // included transactions by block number
+	filters map[string]int   // filter ID -> index of the next unseen head
+	faults  map[string][]fault
+	latency map[string]time.Duration
+	calls   map[string]int
+
+	balances map[string]*big.Int // eth_getBalance; others hold DefaultBalance
+}
+
+// DefaultBalance is the balance of accounts not given one with SetBalance
+var DefaultBalance = new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)
+
+// fault is an injected failure consumed by the next matching call
+type fault struct {
+	rpcErr *Error
+	status int
+}
+
+// New starts a server with a genesis head at DefaultGasLimit
+func New() *Server {
+	m := &Server{
+		mined:   make(map[uint64][]*Tx),
+		filters: make(map[string]int),
+		faults:  make(map[string][]fault),
+		latency: make(map[string]time.Duration),
+		calls:   make(map[string]int),
+
+		balances: make(map[string]*big.Int),
+	}
+	m.heads = []*Head{{
+		Number:    0,
+		Hash:      headHash(0),
+		GasLimit:  DefaultGasLimit,
+		Timestamp: uint64(time.Now().Unix()),
+	}}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+// SetBalance sets the balance eth_getBalance reports for addr
+func (m *Server) SetBalance(addr string, wei *big.Int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.balances[strings.ToLower(addr)] = wei
+}
+
+// SetPending replaces the transactions served in the pending block
+func (m *Server) SetPending(txs []*Tx) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append([]*Tx(nil), txs...)
+}
+
+// AddPending appends a transaction to the pending block
+func (m *Server) AddPending(tx *Tx) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, tx)
+}
+
+// NewHead appends a new head with the given gas limit (0 keeps the previous
+// limit), moves the included transactions from the pending set into it and
+// returns the head
+func (m *Server) NewHead(gasLimit int64, included ...string) *Head {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	parent := m.heads[len(m.heads)-1]
+	if gasLimit == 0 {
+		gasLimit = parent.GasLimit
+	}
+	h := &Head{
+		Number:     parent.Number + 1,
+		Hash:       headHash(parent.Number + 1),
+		ParentHash: parent.Hash,
+		GasLimit:   gasLimit,
+		Timestamp:  parent.Timestamp + 2,
+	}
+	m.heads = append(m.heads, h)
+
+	drop := make(map[string]bool, len(included))
+	for _, hash := range included {
+		drop[hash] = true
+	}
+	kept := m.pending[:0]
+	for _, tx := range m.pending {
+		if drop[tx.Hash] {
+			m.mined[h.Number] = append(m.mined[h.Number], tx)
+			h.GasUsed += tx.GasLimit
+		} else {
+			kept = append(kept, tx)
+		}
+	}
+	m.pending = kept
+	return h
+}
+
+// FailNext makes the next `times` calls to method return err
+func (m *Server) FailNext(method string, times int, err *Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < times; i++ {
+		m.faults[method] = append(m.faults[method], fault{rpcErr: err})
+	}
+}
+
+// FailNextHTTP makes the next `times` calls to method answer with an HTTP
+// status
+func (m *Server) FailNextHTTP(method string, times int, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < times; i++ {
+		m.faults[method] = append(m.faults[method], fault{status: status})
+	}
+}
+
+// SetLatency delays every call to method ("*" for all methods) by d
+func (m *Server) SetLatency(method string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency[method] = d
+}
+
+// Calls returns how many times method was called
+func (m *Server) Calls(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[method]
+}
+
+// request is a JSON-RPC request
+type request struct {
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+	ID     int    `json:"id"`
+}
+
+func (m *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var batch []request
+	if json.Unmarshal(body, &batch) == nil {
+		resps := make([]any, 0, len(batch))
+		for _, req := range batch {
+			resp, status := m.handle(r, req)
+			if status != 0 {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			resps = append(resps, resp)
+		}
+		writeJSON(w, resps)
+		return
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, reply(0, nil, &Error{Code: CodeParse, Message: err.Error()}))
+		return
+	}
+	resp, status := m.handle(r, req)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// handle answers one request, or returns an HTTP status to fail with
+func (m *Server) handle(r *http.Request, req request) (any, int) {
+	m.mu.Lock()
+	m.calls[req.Method]++
+	delay := m.latency[req.Method] + m.latency["*"]
+	var f *fault
+	if q := m.faults[req.Method]; len(q) > 0 {
+		f = &q[0]
+		m.faults[req.Method] = q[1:]
+	}
+	m.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return nil, http.StatusServiceUnavailable
+		}
+	}
+	if f != nil {
+		if f.status != 0 {
+			return nil, f.status
+		}
+		return reply(req.ID, nil, f.rpcErr), 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, rpcErr := m.dispatch(req)
+	return reply(req.ID, result, rpcErr), 0
+}
+
+func (m *Server) dispatch(req request) (any, *Error) {
+	head := m.heads[len(m.heads)-1]
+	switch req.Method {
+	case "eth_chainId":
+		return hexUint64(ChainID), nil
+	case "eth_blockNumber":
+		return hexUint64(head.Number), nil
+	case "eth_getBlockByNumber":
+		tag, _ := paramString(req.Params, 0)
+		full, _ := paramBool(req.Params, 1)
+		switch tag {
+		case "pending":
+			pending := &Head{
+				Number:     head.Number + 1,
+				ParentHash: head.Hash,
+				GasLimit:   head.GasLimit,
+				Timestamp:  head.Timestamp + 2,
+			}
+			return encodeBlock(pending, m.pending, full), nil
+		case "latest", "safe", "finalized":
+			return encodeBlock(head, nil, full), nil
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(tag, "0x"), 16, 64)
+		if err != nil || !strings.HasPrefix(tag, "0x") {
+			return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("invalid block number %q", tag)}
+		}
+		if n >= uint64(len(m.heads)) {
+			return nil, nil
+		}
+		return encodeBlock(m.heads[n], m.mined[n], full), nil
+	case "eth_getBalance":
+		addr, _ := paramString(req.Params, 0)
+		if wei, ok := m.balances[strings.ToLower(addr)]; ok {
+			return hexBig(wei), nil
+		}
+		return hexBig(DefaultBalance), nil
+	case "eth_getTransactionCount":
+		// The next nonce is the sender's lowest pending one, or follows
+		// its last mined transaction
+		addr, _ := paramString(req.Params, 0)
+		addr = strings.ToLower(addr)
+		next, pending := uint64(0), false
+		for _, tx := range m.pending {
+			if strings.ToLower(sender(tx)) == addr && (!pending || uint64(tx.Nonce) < next) {
+				next, pending = uint64(tx.Nonce), true
+			}
+		}
+		for _, txs := range m.mined {
+			for _, tx := range txs {
+				if !pending && strings.ToLower(sender(tx)) == addr {
+					next = max(next, uint64(tx.Nonce)+1)
+				}
+			}
+		}
+		return hexUint64(next), nil
+	case "eth_getRawTransactionByHash":
+		hash, _ := paramString(req.Params, 0)
+		for _, tx := range m.pending {
+			if tx.Hash == hash && len(tx.Raw) > 0 {
+				return hexBytes(tx.Raw), nil
+			}
+		}
+		return nil, nil
+	case "txpool_content":
+		bySender := map[string]map[string]wireTx{}
+		for _, tx := range m.pending {
+			from := sender(tx)
+			if bySender[from] == nil {
+				bySender[from] = map[string]wireTx{}
+			}
+			bySender[from][strconv.Itoa(tx.Nonce)] = encodeTx(tx)
+		}
+		return map[string]any{"pending": bySender, "queued": map[string]any{}}, nil
+	case "eth_newBlockFilter":
+		id := hexUint64(uint64(len(m.filters) + 1))
+		m.filters[id] = len(m.heads)
+		return id, nil
+	case "eth_getFilterChanges":
+		id, _ := paramString(req.Params, 0)
+		next, ok := m.filters[id]
+		if !ok {
+			return nil, &Error{Code: CodeServer, Message: "filter not found"}
+		}
+		hashes := []string{}
+		for _, h := range m.heads[next:] {
+			hashes = append(hashes, h.Hash)
+		}
+		m.filters[id] = len(m.heads)
+		return hashes, nil
+	}
+	return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+}
+
+// wireTx is a transaction object as a node serves it
+type wireTx struct {
+	Hash                 string        `json:"hash"`
+	Type                 string        `json:"type,omitempty"`
+	ChainID              string        `json:"chainId,omitempty"`
+	Value                string        `json:"value,omitempty"`
+	From                 string        `json:"from,omitempty"`
+	To                   string        `json:"to,omitempty"`
+	GasPrice             string        `json:"gasPrice"`
+	MaxFeePerGas         string        `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas,omitempty"`
+	Gas                  string        `json:"gas"`
+	Nonce                string        `json:"nonce"`
+	Input                string        `json:"input,omitempty"`
+	AccessList           []AccessTuple `json:"accessList,omitempty"`
+}
+
+func encodeTx(tx *Tx) wireTx {
+	w := wireTx{
+		Hash:       tx.Hash,
+		From:       tx.From,
+		To:         tx.To,
+		GasPrice:   hexUint64(uint64(tx.GasPrice)),
+		Gas:        hexUint64(uint64(tx.GasLimit)),
+		Nonce:      hexUint64(uint64(tx.Nonce)),
+		Input:      hexBytes(tx.Input),
+		AccessList: tx.AccessList,
+	}
+	if tx.Type != 0 {
+		w.Type = hexUint64(uint64(tx.Type))
+	}
+	if tx.ChainID != 0 {
+		w.ChainID = hexUint64(tx.ChainID)
+	}
+	if tx.Value != nil {
+		w.Value = hexBig(tx.Value)
+	}
+	if tx.Type >= 2 {
+		w.MaxFeePerGas = w.GasPrice
+		w.MaxPriorityFeePerGas = hexUint64(uint64(tx.GasTipCap))
+	}
+	return w
+}
+
+func encodeBlock(h *Head, txs []*Tx, full bool) map[string]any {
+	block := map[string]any{
+		"number":        hexUint64(h.Number),
+		"hash":          h.Hash,
+		"parentHash":    h.ParentHash,
+		"gasLimit":      hexUint64(uint64(h.GasLimit)),
+		"gasUsed":       hexUint64(uint64(h.GasUsed)),
+		"baseFeePerGas": hexUint64(uint64(h.BaseFee)),
+		"timestamp":     hexUint64(h.Timestamp),
+	}
+	if full {
+		encoded := make([]wireTx, len(txs))
+		for i, tx := range txs {
+			encoded[i] = encodeTx(tx)
+		}
+		block["transactions"] = encoded
+	} else {
+		hashes := make([]string, len(txs))
+		for i, tx := range txs {
+			hashes[i] = tx.Hash
+		}
+		block["transactions"] = hashes
+	}
+	return block
+}
+
+// sender returns the sender, or derives a stable placeholder address from
+// the hash if it is unknown
+func sender(tx *Tx) string {
+	if tx.From != "" {
+		return tx.From
+	}
+	if len(tx.Hash) >= 42 {
+		return tx.Hash[:42]
+	}
+	return tx.Hash
+}
+
+func headHash(n uint64) string {
+	return fmt.Sprintf("0xb10c%060x", n)
+}
+
+func hexUint64(v uint64) string { return "0x" + strconv.FormatUint(v, 16) }
+func hexBig(v *big.Int) string  { return "0x" + v.Text(16) }
+func hexBytes(b []byte) string  { return "0x" + hex.EncodeToString(b) }
+
+func reply(id int, result any, err *Error) map[string]any {
+	resp := map[string]any{"jsonrpc": "2.0", "id": id}
+	if err != nil {
+		resp["error"] = err
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+// writeJSON writes v as a JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func paramString(params []any, i int) (string, bool) {
+	if i >= len(params) {
+		return "", false
+	}
+	s, ok := params[i].(string)
+	return s, ok
+}
+
+func paramBool(params []any, i int) (bool, bool) {
+	if i >= len(params) {
+		return false, false
+	}
+	b, ok := params[i].(bool)
+	return b, ok
+}
@@ -0,0 +1,123 @@
+package mockrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"testing"
+)
+
+// call posts one request to m and decodes the result into result, returning
+// the error object, if any
+func call(t *testing.T, m *Server, result any, method string, params ...any) *Error {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	resp, err := http.Post(m.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: HTTP %d", method, resp.StatusCode)
+	}
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil && result != nil {
+		if err := json.Unmarshal(reply.Result, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reply.Error
+}
+
+func TestPendingAndMined(t *testing.T) {
+	m := New()
+	defer m.Close()
+	m.SetPending([]*Tx{
+		{Hash: "0xaa", From: "0xA", Nonce: 4, GasPrice: 7, GasLimit: 21000},
+		{Hash: "0xbb", From: "0xA", Nonce: 5, GasPrice: 9, GasTipCap: 2, GasLimit: 50000, Type: 2, Value: big.NewInt(16)},
+	})
+
+	var block struct {
+		Number       string   `json:"number"`
+		Transactions []wireTx `json:"transactions"`
+	}
+	if err := call(t, m, &block, "eth_getBlockByNumber", "pending", true); err != nil {
+		t.Fatal(err)
+	}
+	if block.Number != "0x1" || len(block.Transactions) != 2 {
+		t.Fatalf("pending block %+v", block)
+	}
+	if tx := block.Transactions[1]; tx.MaxFeePerGas != "0x9" || tx.MaxPriorityFeePerGas != "0x2" || tx.Value != "0x10" || tx.Nonce != "0x5" {
+		t.Errorf("dynamic fee transaction served as %+v", tx)
+	}
+
+	var nonce string
+	call(t, m, &nonce, "eth_getTransactionCount", "0xa", "latest")
+	if nonce != "0x4" {
+		t.Errorf("next nonce %s while pending, want 0x4", nonce)
+	}
+
+	h := m.NewHead(0, "0xaa")
+	if h.Number != 1 || h.GasUsed != 21000 || h.GasLimit != DefaultGasLimit {
+		t.Errorf("head %+v", h)
+	}
+	call(t, m, &block, "eth_getBlockByNumber", "0x1", true)
+	if len(block.Transactions) != 1 || block.Transactions[0].Hash != "0xaa" {
+		t.Errorf("mined block holds %+v", block.Transactions)
+	}
+	m.SetPending(nil)
+	call(t, m, &nonce, "eth_getTransactionCount", "0xA", "latest")
+	if nonce != "0x5" {
+		t.Errorf("next nonce %s once mined, want 0x5", nonce)
+	}
+}
+
+func TestFaultsAndFilters(t *testing.T) {
+	m := New()
+	defer m.Close()
+	m.FailNext("eth_chainId", 1, &Error{Code: CodeServer, Message: "busy"})
+	if err := call(t, m, nil, "eth_chainId"); err == nil || err.Message != "busy" {
+		t.Errorf("injected error came back as %v", err)
+	}
+	var id string
+	if err := call(t, m, &id, "eth_chainId"); err != nil || id != "0x138de" {
+		t.Errorf("chain ID %s, %v", id, err)
+	}
+	if n := m.Calls("eth_chainId"); n != 2 {
+		t.Errorf("%d calls counted, want 2", n)
+	}
+
+	m.FailNextHTTP("eth_blockNumber", 1, http.StatusBadGateway)
+	resp, err := http.Post(m.URL, "application/json", bytes.NewReader([]byte(`{"id":1,"method":"eth_blockNumber"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("HTTP %d, want 502", resp.StatusCode)
+	}
+
+	var filter string
+	call(t, m, &filter, "eth_newBlockFilter")
+	m.NewHead(0)
+	m.NewHead(0)
+	var hashes []string
+	call(t, m, &hashes, "eth_getFilterChanges", filter)
+	if len(hashes) != 2 || hashes[1] != headHash(2) {
+		t.Errorf("filter changes %v", hashes)
+	}
+	call(t, m, &hashes, "eth_getFilterChanges", filter)
+	if len(hashes) != 0 {
+		t.Errorf("filter repeated %v", hashes)
+	}
+	if err := call(t, m, nil, "eth_mining"); err == nil || err.Code != CodeMethodNotFound {
+		t.Errorf("unknown method answered %v", err)
+	}
+}
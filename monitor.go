@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ANSI sequences the monitor redraws with; they need nothing beyond a
+// VT100-compatible terminal, so the monitor works over any SSH session
+const (
+	ansiAltScreen  = "\x1b[?1049h\x1b[?25l" // switch to the alternate screen, hide the cursor
+	ansiMainScreen = "\x1b[?25h\x1b[?1049l" // show the cursor, back to the main screen
+	ansiHome       = "\x1b[H"
+	ansiClearLine  = "\x1b[K"
+	ansiClearBelow = "\x1b[J"
+)
+
+// FetchDashboardState polls the dashboard state of a running serve
+func FetchDashboardState(ctx context.Context, url string) (*DashboardState, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/dashboard/state", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s has no dashboard; enable api.dashboard on the serve", url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	var st DashboardState
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, fmt.Errorf("decoding dashboard state: %w", err)
+	}
+	return &st, nil
+}
+
+// Monitor renders dashboard states as a top-style text screen
+type Monitor struct {
+	URL   string
+	Rows  int // top transactions, churn samples and submissions listed
+	Width int // lines are cut to this many columns; 0 leaves them whole
+}
+
+// Render writes one screen of st, polled at, with err from the last poll
+// if it failed (st is then the last good state, or nil)
+func (m *Monitor) Render(w io.Writer, st *DashboardState, at time.Time, err error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "bce top - %s - %s\n", m.URL, at.Format(time.TimeOnly))
+	if err != nil {
+		fmt.Fprintf(&buf, "error: %v\n", err)
+	}
+	if st == nil {
+		m.flush(w, &buf)
+		return
+	}
+
+	p := st.Pool
+	fmt.Fprintf(&buf, "\nPool: %d pooled, %d executable, %d queued, %d deprioritized, %d private, %d required\n",
+		p.Pooled, p.Executable, p.Queued, p.Low, p.Private, p.Required)
+
+	added, removed := 0, 0
+	for _, c := range st.Churn {
+		added += c.Added
+		removed += c.Removed
+	}
+	fmt.Fprintf(&buf, "\nChurn: +%d -%d over the last %d heads\n", added, removed, len(st.Churn))
+	if len(st.Churn) > 0 {
+		tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "HEAD\tADDED\tREMOVED\tPOOLED\t")
+		for _, c := range st.Churn[:min(m.Rows, len(st.Churn))] {
+			fmt.Fprintf(tw, "#%d\t+%d\t-%d\t%d\t\n", c.Head, c.Added, c.Removed, c.Pooled)
+		}
+		tw.Flush()
+	}
+
+	if b := st.Block; b != nil {
+		t := b.Totals
+		fmt.Fprintf(&buf, "\nLast block on #%d (%s, %s): %d txs, gas %d/%d (%.1f%%), profit %s\n",
+			st.Head, b.Strategy, b.BuiltAt.Local().Format(time.TimeOnly), t.TxCount, t.Gas, b.GasLimit,
+			100*float64(t.Gas)/float64(max(b.GasLimit, 1)), FormatWei(t.Profit))
+		fmt.Fprintf(&buf, "  tips %s, MEV %s, PoL %s, burned %s\n",
+			FormatWei(t.Tip), FormatWei(t.MEVBonus), FormatWei(t.PoLBonus), FormatWei(t.Burn))
+	} else {
+		fmt.Fprintln(&buf, "\nLast block: none built yet")
+	}
+
+	fmt.Fprintln(&buf, "\nTop pending by score:")
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HASH\tFROM\tGAS PRICE\tGAS\tPROFIT\tSCORE")
+	for _, tx := range st.Top[:min(m.Rows, len(st.Top))] {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", shortHash(tx.Hash), shortHash(tx.From), tx.GasPrice, tx.Gas,
+			FormatWei(tx.Profit), FormatWei(tx.Score))
+	}
+	tw.Flush()
+
+	if len(st.Relays) > 0 {
+		fmt.Fprintln(&buf, "\nRelays:")
+		tw = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "URL\tSUBMITTED\tACCEPTED\tFAILED\tTIMED OUT\tLAST ERROR")
+		for _, r := range st.Relays {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n", r.URL, r.Submitted, r.Accepted, r.Failed, r.TimedOut, r.LastError)
+		}
+		tw.Flush()
+	}
+	if len(st.Submissions) > 0 {
+		fmt.Fprintln(&buf, "\nRecent submissions:")
+		tw = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		for _, s := range st.Submissions[:min(m.Rows, len(st.Submissions))] {
+			fmt.Fprintf(tw, "slot %d\t%s\t%s\t%s\t%s\n", s.Slot, s.URL, s.Status, s.Latency.Round(time.Millisecond), s.Error)
+		}
+		tw.Flush()
+	}
+	if sh := st.Shadow; sh != nil {
+		fmt.Fprintf(&buf, "\nShadow: %d blocks, %d ahead, %d missed, ours %s vs chain %s\n",
+			sh.Blocks, sh.Ahead, sh.Missed, FormatWei(sh.OurValue), FormatWei(sh.ChainValue))
+	}
+	m.flush(w, &buf)
+}
+
+// flush writes the rendered lines, each cut to the width
+func (m *Monitor) flush(w io.Writer, buf *bytes.Buffer) {
+	var out bytes.Buffer
+	for line := range strings.Lines(buf.String()) {
+		line = strings.TrimSuffix(line, "\n")
+		if r := []rune(line); m.Width > 0 && len(r) > m.Width {
+			line = string(r[:m.Width])
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	w.Write(out.Bytes())
+}
+
+// shortHash abbreviates a hash or address to its ends
+func shortHash(h string) string {
+	if len(h) <= 18 {
+		return h
+	}
+	return h[:10] + "…" + h[len(h)-6:]
+}
+
+// isTerminal reports whether w is a character device, such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+var topCommand = &Command{
+	Name:    "top",
+	Summary: "live terminal monitor of a running serve",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		api := fs.String("api", "", "URL of the serve's API (default: http:// and api.listen)")
+		interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+		rows := fs.Int("n", 15, "rows of transactions, churn and submissions to list")
+		once := fs.Bool("once", false, "print one plain screen and exit instead of redrawing")
+		return func(ctx context.Context, env *Env) error {
+			if *api == "" {
+				listen := env.Config.API.Listen
+				if listen == "" {
+					return fmt.Errorf("-api is required when api.listen is not configured")
+				}
+				if strings.HasPrefix(listen, ":") {
+					listen = "127.0.0.1" + listen
+				}
+				*api = "http://" + listen
+			}
+			m := &Monitor{URL: *api, Rows: *rows}
+			if *once {
+				st, err := FetchDashboardState(ctx, *api)
+				if err != nil {
+					return err
+				}
+				m.Render(env.Out, st, time.Now(), nil)
+				return nil
+			}
+			if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil {
+				m.Width = cols
+			}
+			tty := isTerminal(env.Out)
+			if tty {
+				fmt.Fprint(env.Out, ansiAltScreen)
+				defer fmt.Fprint(env.Out, ansiMainScreen)
+			}
+			var last *DashboardState
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for {
+				st, err := FetchDashboardState(ctx, *api)
+				if ctx.Err() != nil {
+					return nil
+				}
+				if err == nil {
+					last = st
+				}
+				var screen bytes.Buffer
+				m.Render(&screen, last, time.Now(), err)
+				if tty {
+					// redraw in place, clearing what the previous screen left
+					frame := strings.ReplaceAll(screen.String(), "\n", ansiClearLine+"\n")
+					fmt.Fprint(env.Out, ansiHome+frame+ansiClearBelow)
+				} else {
+					fmt.Fprintln(env.Out, screen.String())
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		}
+	},
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// schedule places a newly pooled tx: transactions without a known sender
+// can't be ordered by nonce and are always queued for selection, the rest
+// are parked and then promoted by reschedule if their nonce is executable
+func (p *TxPool) schedule(tx *Transaction) {
+	if tx.From == "" {
+		p.enqueue(tx)
+		return
+	}
+	p.Queued[tx.Hash] = tx
+	p.reschedule(tx.From)
+}
+
+// reschedule re-partitions from's pooled transactions between the heaps
+// and Queued. Starting at the sender's confirmed nonce if known, and its
+// lowest pooled nonce otherwise, transactions on
+// an unbroken run of nonces are executable ("pending") and eligible for
+// selection; everything past the first gap is parked in Queued until the
+// gap fills. Several transactions at one nonce are all executable, and the
+// conflict graph keeps more than one of them out of a block. If the
+// sender's balance is known, transactions are also parked once their cost
+// plus that of every lower nonce (the dearest transaction at each) exceeds
+// it: they can't be included without them. Required transactions are left
+// where Force put them.
+func (p *TxPool) reschedule(from string) {
+	txs := slices.Collect(maps.Values(p.bySender[from]))
+	if len(txs) == 0 {
+		return
+	}
+	slices.SortFunc(txs, func(a, b *Transaction) int {
+		if a.Nonce != b.Nonce {
+			return a.Nonce - b.Nonce
+		}
+		return strings.Compare(a.Hash, b.Hash)
+	})
+	next := txs[0].Nonce
+	if n, ok := p.Nonces[from]; ok {
+		next = int(n) // anything above the confirmed nonce waits for the nonces before it
+	}
+	balance := p.Balances[from]
+	below, dearest := new(big.Int), new(big.Int) // cost through the previous nonce; most at this one
+	for i, tx := range txs {
+		executable := tx.Nonce <= next
+		if tx.Nonce == next {
+			next++
+		}
+		if balance != nil {
+			if i > 0 && tx.Nonce != txs[i-1].Nonce {
+				below.Add(below, dearest)
+				dearest.SetInt64(0)
+			}
+			cost := tx.Cost()
+			if cost.Cmp(dearest) > 0 {
+				dearest.Set(cost)
+			}
+			if cost.Add(cost, below).Cmp(balance) > 0 {
+				executable = false
+			}
+		}
+		if slices.Contains(p.Required, tx.Hash) {
+			continue
+		}
+		_, parked := p.Queued[tx.Hash]
+		switch {
+		case executable && parked:
+			delete(p.Queued, tx.Hash)
+			p.enqueue(tx)
+		case !executable && !parked:
+			p.unqueue(tx.Hash)
+			p.Queued[tx.Hash] = tx
+		}
+	}
+}
+
+// FetchNonces returns the confirmed next nonce, the transaction count at
+// the latest block, of every sender of txs, fetched with one batch of
+// eth_getTransactionCount calls
+func FetchNonces(ctx context.Context, rpc *RPCClient, txs []*Transaction) (map[string]uint64, error) {
+	senders := txSenders(txs)
+	if len(senders) == 0 {
+		return nil, nil
+	}
+	hexes := make([]string, len(senders))
+	elems := make([]BatchElem, len(senders))
+	for i, from := range senders {
+		elems[i] = BatchElem{Method: "eth_getTransactionCount", Params: []any{from, "latest"}, Result: &hexes[i]}
+	}
+	if err := rpc.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+	nonces := make(map[string]uint64, len(senders))
+	for i, from := range senders {
+		if elems[i].Error != nil {
+			return nil, fmt.Errorf("nonce of %s: %w", from, elems[i].Error)
+		}
+		n, err := ParseHexUint64(hexes[i])
+		if err != nil {
+			return nil, fmt.Errorf("nonce of %s: %w", from, err)
+		}
+		nonces[from] = n
+	}
+	return nonces, nil
+}
+
+// SetNonces replaces the known confirmed sender nonces, drops the pooled
+// transactions whose nonce is already used on chain, and re-partitions
+// every sender's transactions from its confirmed nonce. It returns how
+// many were dropped.
+func (p *TxPool) SetNonces(nonces map[string]uint64) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Nonces = nonces
+	dropped := 0
+	for from, txs := range p.bySender {
+		n, ok := nonces[from]
+		if !ok {
+			continue
+		}
+		for hash, tx := range txs {
+			if uint64(tx.Nonce) < n && p.removeTx(hash) {
+				dropped++
+			}
+		}
+		p.reschedule(from)
+	}
+	return dropped
+}
+
+// SetNonces sets the confirmed sender nonces of every shard
+func (s *ShardedPool) SetNonces(nonces map[string]uint64) int {
+	dropped := 0
+	for _, shard := range s.Shards {
+		dropped += shard.SetNonces(nonces)
+	}
+	return dropped
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+)
+
+// BaseFeeChangeDenominator bounds the EIP-1559 base fee change per block
+const BaseFeeChangeDenominator = 8
+
+// DefaultOraclePercentiles are the tip percentiles reported by the oracle
+var DefaultOraclePercentiles = []int{10, 25, 50, 75, 90}
+
+// FeeOracle samples recent blocks to estimate the priority fee needed for
+// inclusion
+type FeeOracle struct {
+	RPC        *RPCClient
+	Blocks     int // number of recent blocks to sample
+	Percentile int // tip percentile used as the suggested minimum
+}
+
+// FeeEstimate summarises the tips paid in the sampled blocks
+type FeeEstimate struct {
+	Head         *Header
+	Blocks       int
+	Samples      int           // transactions sampled
+	NextBaseFee  int64         // base fee of the block being built
+	Tips         map[int]int64 // percentile -> effective tip in wei
+	SuggestedTip int64
+}
+
+// SuggestedGasPrice is the effective gas price a transaction needs to pay
+// the suggested tip on top of the next base fee
+func (e *FeeEstimate) SuggestedGasPrice() int64 {
+	return e.NextBaseFee + e.SuggestedTip
+}
+
+// NewFeeOracle returns an oracle configured from cfg
+func NewFeeOracle(rpc *RPCClient, cfg OracleConfig) *FeeOracle {
+	return &FeeOracle{RPC: rpc, Blocks: cfg.Blocks, Percentile: cfg.Percentile}
+}
+
+// Estimate fetches the last Blocks blocks in one batch and computes tip
+// percentiles over every transaction in them. The tip of a mined
+// transaction is its effective gas price minus its block's base fee.
+func (o *FeeOracle) Estimate(ctx context.Context) (*FeeEstimate, error) {
+	head, err := FetchHeader(ctx, o.RPC, "latest")
+	if err != nil {
+		return nil, fmt.Errorf("fetching head: %w", err)
+	}
+	n := min(uint64(o.Blocks), head.Number+1)
+	blocks := make([]*rpcBlock, n)
+	elems := make([]BatchElem, n)
+	for i := range elems {
+		elems[i] = BatchElem{
+			Method: "eth_getBlockByNumber",
+			Params: []any{EncodeHexUint64(head.Number - uint64(i)), true},
+			Result: &blocks[i],
+		}
+	}
+	if err := o.RPC.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+
+	var tips []int64
+	for i, elem := range elems {
+		if elem.Error != nil {
+			return nil, fmt.Errorf("block %d: %w", head.Number-uint64(i), elem.Error)
+		}
+		if blocks[i] == nil {
+			continue
+		}
+		header, txs, _, err := blocks[i].decode()
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", head.Number-uint64(i), err)
+		}
+		for _, tx := range txs {
+			tips = append(tips, max(tx.GasPrice-header.BaseFee, 0))
+		}
+	}
+	slices.Sort(tips)
+
+	est := &FeeEstimate{
+		Head:        head,
+		Blocks:      int(n),
+		Samples:     len(tips),
+		NextBaseFee: NextBaseFee(head),
+		Tips:        make(map[int]int64, len(DefaultOraclePercentiles)),
+	}
+	for _, p := range DefaultOraclePercentiles {
+		est.Tips[p] = percentile(tips, p)
+	}
+	est.SuggestedTip = percentile(tips, o.Percentile)
+	return est, nil
+}
+
+// NextBaseFee applies the EIP-1559 update rule to the parent header
+func NextBaseFee(parent *Header) int64 {
+	target := parent.GasTarget()
+	if parent.BaseFee == 0 || target == 0 || parent.GasUsed == target {
+		return parent.BaseFee
+	}
+	if parent.GasUsed > target {
+		delta := parent.BaseFee * (parent.GasUsed - target) / target / BaseFeeChangeDenominator
+		return parent.BaseFee + max(delta, 1)
+	}
+	delta := parent.BaseFee * (target - parent.GasUsed) / target / BaseFeeChangeDenominator
+	return parent.BaseFee - delta
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted values, or
+// 0 if there are none
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+var feesCommand = &Command{
+	Name:    "fees",
+	Summary: "estimate the priority fee needed for inclusion from recent blocks",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		blocks := fs.Int("blocks", 0, "number of recent blocks to sample (default: config)")
+		return func(ctx context.Context, env *Env) error {
+			oracle := NewFeeOracle(env.RPC, env.Config.Oracle)
+			if *blocks > 0 {
+				oracle.Blocks = *blocks
+			}
+			est, err := oracle.Estimate(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.Out, "Sampled %d transactions in blocks #%d-#%d\n", est.Samples, est.Head.Number+1-uint64(est.Blocks), est.Head.Number)
+			fmt.Fprintf(env.Out, "Next base fee: %d wei\n", est.NextBaseFee)
+			for _, p := range DefaultOraclePercentiles {
+				fmt.Fprintf(env.Out, "  p%-3d tip: %d wei\n", p, est.Tips[p])
+			}
+			fmt.Fprintf(env.Out, "Suggested min tip (p%d): %d wei, gas price %d wei\n", oracle.Percentile, est.SuggestedTip, est.SuggestedGasPrice())
+			return nil
+		}
+	},
+}
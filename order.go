@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// arrivals numbers admitted transactions in the order they arrive, across
+// every pool of the process, so shards merged for a build still agree on it
+var arrivals atomic.Uint64
+
+// arrive stamps tx with its arrival: the time it was received, the pending
+// block and the next sequence number, or, if it replaces old, those of old
+func (p *TxPool) arrive(tx, old *Transaction) {
+	switch {
+	case old != nil:
+		tx.SeenAt, tx.SeenBlock, tx.Seq = old.SeenAt, old.SeenBlock, old.Seq
+	case !p.keepArrival:
+		received := tx.ReceivedAt
+		if received.IsZero() {
+			received = time.Now()
+		}
+		tx.SeenAt, tx.SeenBlock, tx.Seq = received.UTC(), p.Block, arrivals.Add(1)
+	}
+}
+
+// observeArrival moves the sequence past seq, so transactions admitted
+// after a restore rank as arriving after the restored ones
+func observeArrival(seq uint64) {
+	for {
+		last := arrivals.Load()
+		if last >= seq || arrivals.CompareAndSwap(last, seq) {
+			return
+		}
+	}
+}
+
+// cmpScore orders transactions by rank: positive if a ranks above b. Ranks
+// follow Score; ties go to the earlier arrival, then to the lower hash, so
+// no two distinct transactions tie and every build over the same pool
+// picks and orders the same block whatever the heap layout. Transactions
+// of unknown arrival, such as merged bundles, come after the rest, and of
+// two bundles the one from the more reputable searcher goes first.
+func cmpScore(a, b *Transaction) int {
+	if c := cmp.Compare(a.Score(), b.Score()); c != 0 {
+		return c
+	}
+	if len(a.Bundle) > 0 && len(b.Bundle) > 0 {
+		if c := cmp.Compare(a.Reputation, b.Reputation); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(arrival(b), arrival(a)); c != 0 {
+		return c
+	}
+	return strings.Compare(b.Hash, a.Hash)
+}
+
+// Ranking selects the order greedy builds take candidates in
+type Ranking string
+
+const (
+	RankProfit  Ranking = "profit"  // by score
+	RankDensity Ranking = "density" // by score per unit of gas
+)
+
+// cmpDensity orders transactions like cmpScore, but by score per unit of
+// gas, so a small transaction paying well outranks a huge one paying a
+// little more in all
+func cmpDensity(a, b *Transaction) int {
+	density := func(tx *Transaction) float64 { return float64(tx.Score()) / float64(max(tx.PackGas(), 1)) }
+	return cmp.Or(cmp.Compare(density(a), density(b)), cmpScore(a, b))
+}
+
+// rank returns the comparator of the pool's Ranking
+func (p *TxPool) rank() func(a, b *Transaction) int {
+	if p.Ranking == RankDensity {
+		return cmpDensity
+	}
+	return cmpScore
+}
+
+// rankedHeap is a max-heap of transactions under any comparator
+type rankedHeap struct {
+	txs []*Transaction
+	cmp func(a, b *Transaction) int
+}
+
+func (h *rankedHeap) Len() int           { return len(h.txs) }
+func (h *rankedHeap) Less(i, j int) bool { return h.cmp(h.txs[i], h.txs[j]) > 0 }
+func (h *rankedHeap) Swap(i, j int)      { h.txs[i], h.txs[j] = h.txs[j], h.txs[i] }
+func (h *rankedHeap) Push(x any)         { h.txs = append(h.txs, x.(*Transaction)) }
+
+func (h *rankedHeap) Pop() any {
+	x := h.txs[len(h.txs)-1]
+	h.txs = h.txs[:len(h.txs)-1]
+	return x
+}
+
+// sortByArrival sorts txs by arrival, unknown last, then by hash
+func sortByArrival(txs []*Transaction) {
+	slices.SortFunc(txs, func(a, b *Transaction) int {
+		if c := cmp.Compare(arrival(a), arrival(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Hash, b.Hash)
+	})
+}
+
+func arrival(tx *Transaction) uint64 {
+	if tx.Seq == 0 {
+		return math.MaxUint64
+	}
+	return tx.Seq
+}
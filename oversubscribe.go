@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Oversubscriber lets the packer fill a block past its gas limit on
+// estimated gas used, then trims it once simulation has measured what each
+// entry actually uses. Packing counts an entry's estimate (see PackGas),
+// so conservative estimates leave gas unused; packing against a limit
+// Factor higher and trimming on measured usage reclaims it.
+//
+// The block is simulated in order with eth_simulateV1 on top of the latest
+// block, and walked keeping each entry whose gas limit fits in what the
+// real limit has left after the gas measured before it, as the EVM
+// requires, and dropping the rest along with any later entry of a dropped
+// entry's sender, whose nonces would no longer follow. A bundle must fit
+// all its members' limits at once. As dropping an entry can change what
+// later ones use, the trimmed block is simulated again, up to Rounds
+// times, until nothing more is dropped. Entries without a known sender
+// can't be simulated and count their estimate; required transactions are
+// never dropped.
+type Oversubscriber struct {
+	RPC    *RPCClient
+	Factor float64 // extra gas packed, as a fraction of the limit
+	Rounds int     // simulations of the trimmed block before it is taken as is
+}
+
+// NewOversubscriber returns an oversubscriber configured from cfg, or nil
+// if oversubscription is disabled
+func NewOversubscriber(rpc *RPCClient, cfg OversubscribeConfig) *Oversubscriber {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Oversubscriber{RPC: rpc, Factor: cfg.Factor, Rounds: cfg.Rounds}
+}
+
+// Limit returns the gas limit to pack against for a block of gasLimit
+func (o *Oversubscriber) Limit(gasLimit int64) int64 {
+	return gasLimit + int64(float64(gasLimit)*o.Factor)
+}
+
+// TrimResult reports how an oversubscribed block was trimmed
+type TrimResult struct {
+	Packed    int      // entries packed against the raised limit
+	Dropped   []string // entries trimmed, in block order
+	Rounds    int      // simulations run
+	GasUsed   int64    // measured gas used of the trimmed block
+	Confirmed bool     // the last simulation dropped nothing
+}
+
+// gasResult is the part of an eth_simulateV1 call result trimming uses
+type gasResult struct {
+	GasUsed HexUint64 `json:"gasUsed"`
+}
+
+// Trim simulates selected and trims it to gasLimit as described on
+// Oversubscriber. Kept entries come back as copies whose GasEstimate is
+// the gas measured, so the block validates against gasLimit.
+func (o *Oversubscriber) Trim(ctx context.Context, pool *TxPool, selected []*Transaction, gasLimit int64) (_ []*Transaction, res *TrimResult, err error) {
+	ctx, span := StartSpan(ctx, "oversubscribe.trim", "block.txs", len(selected), "block.gas_limit", gasLimit)
+	defer func() {
+		if res != nil {
+			span.SetAttr("oversubscribe.dropped", len(res.Dropped))
+		}
+		span.SetError(err)
+		span.End()
+	}()
+
+	res = &TrimResult{Packed: len(selected)}
+	for res.Rounds < max(o.Rounds, 1) {
+		used, err := o.simulate(ctx, pool, selected)
+		if err != nil {
+			return nil, res, err
+		}
+		res.Rounds++
+		kept, dropped, gas := o.fit(pool, selected, used, gasLimit)
+		selected, res.GasUsed = kept, gas
+		res.Dropped = append(res.Dropped, dropped...)
+		if res.Confirmed = len(dropped) == 0; res.Confirmed {
+			break
+		}
+	}
+	if err := pool.ValidateBlock(gasLimit, selected); err != nil {
+		return nil, res, fmt.Errorf("trimmed block: %w", err)
+	}
+	return selected, res, nil
+}
+
+// fit walks selected keeping what fits in gasLimit on the gas used
+// measured, -1 where unmeasured, and returns the kept entries, the hashes
+// of the dropped ones and the gas the kept ones use
+func (o *Oversubscriber) fit(pool *TxPool, selected []*Transaction, used []int64, gasLimit int64) ([]*Transaction, []string, int64) {
+	var kept []*Transaction
+	var dropped []string
+	stranded := map[string]bool{} // senders of dropped entries
+	gas := int64(0)
+	for i, tx := range selected {
+		members := o.members(pool, tx)
+		g := min(used[i], tx.GasLimit)
+		if g < 0 {
+			g = tx.PackGas()
+		}
+		required := slices.Contains(pool.Required, tx.Hash)
+		strands := slices.ContainsFunc(members, func(m *Transaction) bool { return m.From != "" && stranded[m.From] })
+		if !required && (gas+tx.GasLimit > gasLimit || strands) {
+			dropped = append(dropped, tx.Hash)
+			for _, m := range members {
+				if m.From != "" {
+					stranded[m.From] = true
+				}
+			}
+			continue
+		}
+		c := *tx
+		if g < c.GasLimit {
+			c.GasEstimate = g
+		}
+		kept = append(kept, &c)
+		gas += g
+	}
+	return kept, dropped, gas
+}
+
+// members returns the transactions of block entry tx: a bundle's members,
+// or tx itself
+func (o *Oversubscriber) members(pool *TxPool, tx *Transaction) []*Transaction {
+	if len(tx.Bundle) > 0 && pool.Hints != nil {
+		if members, _, ok := pool.Hints.Members(tx.Hash); ok {
+			return members
+		}
+	}
+	return []*Transaction{tx}
+}
+
+// simulate runs selected in order and returns the gas each entry used, or
+// -1 for one with a member of unknown sender, which is left out
+func (o *Oversubscriber) simulate(ctx context.Context, pool *TxPool, selected []*Transaction) ([]int64, error) {
+	used := make([]int64, len(selected))
+	var entries []int // of each call
+	var calls []map[string]string
+	for i, tx := range selected {
+		members := o.members(pool, tx)
+		if slices.ContainsFunc(members, func(m *Transaction) bool { return m.From == "" }) {
+			used[i] = -1
+			continue
+		}
+		for _, m := range members {
+			entries = append(entries, i)
+			calls = append(calls, callObject(m))
+		}
+	}
+	if len(calls) == 0 {
+		return used, nil
+	}
+	var blocks []struct {
+		Calls []gasResult `json:"calls"`
+	}
+	params := map[string]any{"blockStateCalls": []any{map[string]any{"calls": calls}}}
+	if err := o.RPC.Call(ctx, &blocks, "eth_simulateV1", params, "latest"); err != nil {
+		return nil, fmt.Errorf("simulating block: %w", err)
+	}
+	if len(blocks) != 1 || len(blocks[0].Calls) != len(calls) {
+		return nil, fmt.Errorf("simulating block: got results for %d blocks, want 1 of %d calls", len(blocks), len(calls))
+	}
+	for i, r := range blocks[0].Calls {
+		used[entries[i]] += int64(r.GasUsed)
+	}
+	return used, nil
+}
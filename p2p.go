@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// devp2p base protocol messages; capabilities follow from baseProtocolLength
+const (
+	p2pHello      = 0x00
+	p2pDisconnect = 0x01
+	p2pPing       = 0x02
+	p2pPong       = 0x03
+
+	baseProtocolLength = 0x10
+	p2pVersion         = 5 // snappy compression
+)
+
+// eth/68 messages, offset by baseProtocolLength as the only capability
+// the builder speaks
+const (
+	ethVersion = 68
+
+	ethStatus                     = baseProtocolLength + 0x00
+	ethTransactions               = baseProtocolLength + 0x02
+	ethGetBlockHeaders            = baseProtocolLength + 0x03
+	ethBlockHeaders               = baseProtocolLength + 0x04
+	ethGetBlockBodies             = baseProtocolLength + 0x05
+	ethBlockBodies                = baseProtocolLength + 0x06
+	ethNewPooledTransactionHashes = baseProtocolLength + 0x08
+	ethGetPooledTransactions      = baseProtocolLength + 0x09
+	ethPooledTransactions         = baseProtocolLength + 0x0a
+	ethGetReceipts                = baseProtocolLength + 0x0f
+	ethReceipts                   = baseProtocolLength + 0x10
+)
+
+// Disconnect reasons the builder sends
+const (
+	disconnectUselessPeer = 0x03
+	disconnectSubprotocol = 0x10
+)
+
+// p2pClientName is how the builder introduces itself in its hello
+const p2pClientName = "block-construction-engine"
+
+// p2pIdleTimeout drops a peer that sends nothing, not even a ping, for this long
+const p2pIdleTimeout = time.Minute
+
+// p2pMaxRequest caps the hashes requested in one GetPooledTransactions
+const p2pMaxRequest = 256
+
+// Defaults for the p2p section
+const (
+	DefaultP2PHold   = time.Minute
+	DefaultP2PRedial = 10 * time.Second
+)
+
+// Enode is the address of a devp2p node
+type Enode struct {
+	Pubkey *secpPoint
+	Addr   string // host:port
+}
+
+// ParseEnode parses an enode://<hex node key>@host:port URL
+func ParseEnode(s string) (*Enode, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "enode" || u.User == nil {
+		return nil, fmt.Errorf("%q is not an enode://<node key>@host:port URL", s)
+	}
+	id, err := hex.DecodeString(u.User.Username())
+	if err != nil {
+		return nil, fmt.Errorf("node key: %w", err)
+	}
+	pub, err := parseSecpPubkey(id)
+	if err != nil {
+		return nil, fmt.Errorf("node key: %w", err)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("%q has no port", s)
+	}
+	return &Enode{Pubkey: pub, Addr: u.Host}, nil
+}
+
+func (n *Enode) String() string {
+	return "enode://" + hex.EncodeToString(n.Pubkey.bytes64()) + "@" + n.Addr
+}
+
+// P2P receives pending transactions straight from execution clients over
+// devp2p (RLPx with eth/68) instead of waiting for the next RPC poll. It
+// dials Peers, redialling those that drop, and with Listen set also accepts
+// peers. Announced transactions are requested and, like broadcast ones,
+// admitted into Pool publicly and held there for Hold (see TxPool.Hold) so
+// the next pending sync doesn't drop them before the RPC node has them too.
+// The builder serves no chain data: it answers requests for it with
+// nothing.
+type P2P struct {
+	Key     *SigningKey
+	Peers   []*Enode
+	Listen  string
+	Pool    Mempool
+	ChainID uint64 // peers on another network are dropped; 0 accepts any
+	Hold    time.Duration
+	Redial  time.Duration
+	Out     io.Writer
+
+	mu    sync.Mutex
+	known *SeenCache // hashes already received or requested
+}
+
+// NewP2P returns a listener for cfg feeding pool, or nil if no peers are
+// configured and it doesn't listen
+func NewP2P(cfg P2PConfig, chainID uint64, pool Mempool) (*P2P, error) {
+	if len(cfg.Peers) == 0 && cfg.Listen == "" {
+		return nil, nil
+	}
+	p := &P2P{
+		Listen:  cfg.Listen,
+		Pool:    pool,
+		ChainID: chainID,
+		Hold:    time.Duration(cfg.Hold),
+		Redial:  time.Duration(cfg.Redial),
+		Out:     io.Discard,
+		known:   NewSeenCache(DefaultSeenTTL),
+	}
+	var err error
+	if cfg.NodeKeyFile != "" {
+		p.Key, err = LoadSigningKey(cfg.NodeKeyFile)
+	} else {
+		p.Key, err = GenerateSigningKey()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("p2p node key: %w", err)
+	}
+	for _, s := range cfg.Peers {
+		node, err := ParseEnode(s)
+		if err != nil {
+			return nil, err
+		}
+		p.Peers = append(p.Peers, node)
+	}
+	return p, nil
+}
+
+// Run dials the peers and accepts inbound ones until ctx is done
+func (p *P2P) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	if p.Listen != "" {
+		var lc net.ListenConfig
+		ln, err := lc.Listen(ctx, "tcp", p.Listen)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.Out, "p2p listening as %s\n", &Enode{Pubkey: p.Key.pub, Addr: ln.Addr().String()})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.accept(ctx, ln)
+		}()
+	}
+	for _, node := range p.Peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.dial(ctx, node)
+		}()
+	}
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case <-prune.C:
+			p.mu.Lock()
+			p.known.Prune()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// dial keeps a session with node up, redialling after Redial
+func (p *P2P) dial(ctx context.Context, node *Enode) {
+	for ctx.Err() == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", node.Addr)
+		if err == nil {
+			var c *rlpxConn
+			if c, err = dialRLPx(conn, p.Key, node.Pubkey); err == nil {
+				err = p.session(ctx, c)
+			} else {
+				conn.Close()
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Fprintf(p.Out, "p2p peer %s: %v; redialling in %s\n", node.Addr, err, p.Redial)
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.Redial):
+		}
+	}
+}
+
+// accept runs a session with every peer connecting to ln
+func (p *P2P) accept(ctx context.Context, ln net.Listener) {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(p.Out, "p2p accept: %v\n", err)
+			}
+			return
+		}
+		go func() {
+			c, err := acceptRLPx(conn, p.Key)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if err := p.session(ctx, c); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(p.Out, "p2p peer %s: %v\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// session negotiates eth/68 over c and feeds the pool until the peer
+// disconnects or ctx is done
+func (p *P2P) session(ctx context.Context, c *rlpxConn) error {
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	name, err := p.handshake(c)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.Out, "p2p connected to %s (%s)\n", c.conn.RemoteAddr(), name)
+
+	reqID := uint64(0)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(p2pIdleTimeout))
+		code, data, err := c.ReadMsg()
+		if err != nil {
+			return err
+		}
+		switch code {
+		case p2pPing:
+			err = c.WriteMsg(p2pPong, rlpList())
+		case p2pDisconnect:
+			return fmt.Errorf("disconnected: %s", disconnectReason(data))
+		case ethTransactions:
+			err = p.admit(data)
+		case ethNewPooledTransactionHashes:
+			err = p.request(c, data, &reqID)
+		case ethPooledTransactions:
+			var items [][]byte
+			if items, err = rlpEncodedItems(data); err == nil && len(items) == 2 {
+				err = p.admit(items[1])
+			}
+		case ethGetBlockHeaders, ethGetBlockBodies, ethGetPooledTransactions, ethGetReceipts:
+			err = emptyResponse(c, code, data)
+		}
+		if err != nil {
+			c.WriteMsg(p2pDisconnect, rlpList(rlpUint(disconnectSubprotocol)))
+			return fmt.Errorf("message %#x: %w", code, err)
+		}
+	}
+}
+
+// handshake exchanges hellos and statuses, returning the peer's client
+// name. The builder has no chain of its own, so it answers the peer's
+// status with the peer's own: the same genesis, head and fork ID.
+func (p *P2P) handshake(c *rlpxConn) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(rlpxHandshakeTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	hello := rlpList(
+		rlpUint(p2pVersion),
+		rlpBytes([]byte(p2pClientName)),
+		rlpList(rlpList(rlpBytes([]byte("eth")), rlpUint(ethVersion))),
+		rlpUint(0), // listen port, unused since discovery v4
+		rlpBytes(p.Key.pub.bytes64()),
+	)
+	if err := c.WriteMsg(p2pHello, hello); err != nil {
+		return "", err
+	}
+	code, data, err := c.ReadMsg()
+	if err != nil {
+		return "", err
+	}
+	switch code {
+	case p2pDisconnect:
+		return "", fmt.Errorf("disconnected: %s", disconnectReason(data))
+	case p2pHello:
+	default:
+		return "", fmt.Errorf("expected hello, got message %#x", code)
+	}
+	fields, err := rlpListItems(data)
+	if err != nil || len(fields) < 3 {
+		return "", errors.New("malformed hello")
+	}
+	version, _ := rlpToUint(fields[0])
+	name := string(fields[1])
+	caps, err := rlpItems(fields[2])
+	if err != nil {
+		return "", errors.New("malformed hello")
+	}
+	eth := false
+	for _, cp := range caps {
+		if f, err := rlpItems(cp); err == nil && len(f) == 2 && string(f[0]) == "eth" {
+			if v, _ := rlpToUint(f[1]); v == ethVersion {
+				eth = true
+			}
+		}
+	}
+	if !eth {
+		c.WriteMsg(p2pDisconnect, rlpList(rlpUint(disconnectUselessPeer)))
+		return "", fmt.Errorf("%s doesn't speak eth/%d", name, ethVersion)
+	}
+	c.snappy = version >= p2pVersion
+
+	for {
+		code, data, err = c.ReadMsg()
+		if err != nil {
+			return "", err
+		}
+		switch code {
+		case p2pPing:
+			if err := c.WriteMsg(p2pPong, rlpList()); err != nil {
+				return "", err
+			}
+			continue
+		case p2pDisconnect:
+			return "", fmt.Errorf("disconnected: %s", disconnectReason(data))
+		case ethStatus:
+		default:
+			return "", fmt.Errorf("expected status, got message %#x", code)
+		}
+		break
+	}
+	status, err := rlpListItems(data)
+	if err != nil || len(status) < 6 {
+		return "", errors.New("malformed status")
+	}
+	network, err := rlpToUint(status[1])
+	if err != nil {
+		return "", errors.New("malformed status")
+	}
+	if p.ChainID != 0 && network != p.ChainID {
+		c.WriteMsg(p2pDisconnect, rlpList(rlpUint(disconnectUselessPeer)))
+		return "", fmt.Errorf("%s is on network %d, want %d", name, network, p.ChainID)
+	}
+	if err := c.WriteMsg(ethStatus, data); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// request asks the peer for the announced transactions not yet seen.
+// Blob transactions are skipped: the builder doesn't pack their sidecars.
+// While the pool is full nothing is requested or marked seen, so the
+// transactions are fetched when announced again once there is room.
+func (p *P2P) request(c *rlpxConn, data []byte, reqID *uint64) error {
+	if p.Pool.Full() {
+		return nil
+	}
+	fields, err := rlpListItems(data)
+	if err != nil || len(fields) != 3 {
+		return errors.New("malformed announcement")
+	}
+	types := fields[0]
+	hashes, err := rlpItems(fields[2])
+	if err != nil || len(hashes) != len(types) {
+		return errors.New("malformed announcement")
+	}
+	var want [][]byte
+	p.mu.Lock()
+	for i, h := range hashes {
+		hash := EncodeHexBytes(h)
+		if len(h) != 32 || types[i] == 3 || p.known.Seen(hash) {
+			continue
+		}
+		p.known.Mark(hash)
+		want = append(want, rlpBytes(h))
+	}
+	p.mu.Unlock()
+	for len(want) > 0 {
+		n := min(len(want), p2pMaxRequest)
+		*reqID++
+		if err := c.WriteMsg(ethGetPooledTransactions, rlpList(rlpUint(*reqID), rlpList(want[:n]...))); err != nil {
+			return err
+		}
+		want = want[n:]
+	}
+	return nil
+}
+
+// admit decodes a list of transactions in their network encoding (legacy
+// ones as lists, typed ones as byte strings holding the envelope) and adds
+// them to the pool. Transactions that don't decode or verify are skipped.
+func (p *P2P) admit(list []byte) error {
+	received := time.Now()
+	items, err := rlpEncodedItems(list)
+	if err != nil {
+		return fmt.Errorf("malformed transactions: %w", err)
+	}
+	for _, item := range items {
+		isList, payload, _, err := rlpSplit(item)
+		if err != nil {
+			continue
+		}
+		raw := payload
+		if isList {
+			raw = item
+		}
+		tx, err := RecoverSender(raw)
+		if err != nil {
+			continue
+		}
+		p.mu.Lock()
+		p.known.Mark(tx.Hash)
+		p.mu.Unlock()
+		tx.ReceivedAt = received
+		p.Pool.Hold(tx, p.Hold)
+	}
+	return nil
+}
+
+// emptyResponse answers a request for chain data, which the builder
+// doesn't keep, with an empty list under the request's id
+func emptyResponse(c *rlpxConn, code uint64, data []byte) error {
+	fields, err := rlpListItems(data)
+	if err != nil || len(fields) == 0 {
+		return errors.New("malformed request")
+	}
+	resp := map[uint64]uint64{
+		ethGetBlockHeaders:       ethBlockHeaders,
+		ethGetBlockBodies:        ethBlockBodies,
+		ethGetPooledTransactions: ethPooledTransactions,
+		ethGetReceipts:           ethReceipts,
+	}[code]
+	return c.WriteMsg(resp, rlpList(rlpBytes(fields[0]), rlpList()))
+}
+
+// disconnectReason describes the reason in a disconnect message, which
+// some clients send as a list and others as a bare integer
+func disconnectReason(data []byte) string {
+	reasons := []string{
+		"disconnect requested", "network error", "breach of protocol", "useless peer",
+		"too many peers", "already connected", "incompatible p2p protocol version",
+		"invalid node identity", "client quitting", "unexpected identity",
+		"connected to self", "read timeout",
+	}
+	if fields, err := rlpListItems(data); err == nil && len(fields) > 0 {
+		data = fields[0]
+	} else if _, payload, _, err := rlpSplit(data); err == nil {
+		data = payload
+	}
+	n, err := rlpToUint(data)
+	switch {
+	case err != nil:
+		return "unknown reason"
+	case n < uint64(len(reasons)):
+		return reasons[n]
+	case n == disconnectSubprotocol:
+		return "subprotocol error"
+	}
+	return fmt.Sprintf("reason %#x", n)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// BlockPacker selects the transactions of a block from a pool, in
+// inclusion order. Pack is called with the pool read-locked, so it may read
+// the pool's fields but must not call methods that lock it. It offers
+// every block it grows to best, if not nil, so a build interrupted by the
+// deadline can fall back on its progress, and returns ctx's error if
+// interrupted. Whatever it returns is checked by ValidateBlock.
+type BlockPacker interface {
+	Pack(ctx context.Context, pool *TxPool, gasLimit int64, best *BestBlock) ([]*Transaction, error)
+}
+
+// PackerFunc adapts a function to a BlockPacker
+type PackerFunc func(ctx context.Context, pool *TxPool, gasLimit int64, best *BestBlock) ([]*Transaction, error)
+
+func (f PackerFunc) Pack(ctx context.Context, pool *TxPool, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	return f(ctx, pool, gasLimit, best)
+}
+
+// poolPacker adapts one of the pool's select methods to a BlockPacker
+func poolPacker(selectTxs func(*TxPool, context.Context, int64, *BestBlock) ([]*Transaction, error)) BlockPacker {
+	return PackerFunc(func(ctx context.Context, pool *TxPool, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+		return selectTxs(pool, ctx, gasLimit, best)
+	})
+}
+
+var (
+	packersMu sync.RWMutex
+	packers   = map[string]BlockPacker{
+		"greedy":         poolPacker((*TxPool).selectGreedy),
+		"greedy-density": poolPacker((*TxPool).selectDensity),
+		"wis":            poolPacker((*TxPool).selectWIS),
+		"dp":             poolPacker((*TxPool).selectDP),
+		"anneal":         poolPacker((*TxPool).selectAnneal),
+		"parallel":       poolPacker((*TxPool).selectParallel),
+		"fcfs":           poolPacker((*TxPool).selectFCFS),
+		"fair":           poolPacker((*TxPool).selectFair),
+	}
+)
+
+// RegisterPacker makes p selectable as builder.strategy under name. Like
+// scorers, packers are compiled in from an init function. It panics if
+// name is taken.
+func RegisterPacker(name string, p BlockPacker) {
+	packersMu.Lock()
+	defer packersMu.Unlock()
+	if _, ok := packers[name]; ok {
+		panic(fmt.Sprintf("packer %q registered twice", name))
+	}
+	packers[name] = p
+}
+
+// LookupPacker returns the packer registered under name
+func LookupPacker(name string) (BlockPacker, error) {
+	packersMu.RLock()
+	p, ok := packers[name]
+	packersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q (want one of %s)", name, strings.Join(PackerNames(), ", "))
+	}
+	return p, nil
+}
+
+// PackerNames lists the registered packers, sorted
+func PackerNames() []string {
+	packersMu.RLock()
+	defer packersMu.RUnlock()
+	names := make([]string, 0, len(packers))
+	for name := range packers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ValidateBlock checks a packer's output against the pool: every
+// transaction pooled (bundles aside) and included once, the required ones
+// all present, no two in conflict, no sender or contract over its gas
+// quota, none ahead of a transaction it depends on (see orderBlock) or
+// without a lower nonce of its sender that is still pooled, no bundle since
+// cancelled, and their gas within gasLimit
+func (p *TxPool) ValidateBlock(gasLimit int64, txs []*Transaction) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.validateBlock(gasLimit, txs, nil)
+}
+
+// validateBlock is ValidateBlock with the pool read-locked, accepting the
+// injected transactions proposal hooks added from outside the pool
+func (p *TxPool) validateBlock(gasLimit int64, txs []*Transaction, injected map[string]bool) error {
+	var problems []string
+	var bundles []*Transaction
+	for _, tx := range txs {
+		if tx == nil {
+			return errors.New("nil transaction")
+		}
+		if len(tx.Bundle) > 0 {
+			bundles = append(bundles, tx)
+		}
+	}
+	graph := p.conflictGraph(bundles...)
+	used := make(map[string]bool, len(txs))
+	gas := int64(0)
+	for _, tx := range txs {
+		switch {
+		case used[tx.Hash]:
+			problems = append(problems, fmt.Sprintf("%s is included twice", tx.Hash))
+			continue
+		case len(tx.Bundle) == 0 && p.AllTxs[tx.Hash] == nil && !injected[tx.Hash]:
+			problems = append(problems, fmt.Sprintf("%s is not in the pool", tx.Hash))
+		case graph.Conflicts(tx.Hash, used):
+			problems = append(problems, fmt.Sprintf("%s conflicts with an earlier transaction", tx.Hash))
+		case !injected[tx.Hash] && !slices.Contains(p.Required, tx.Hash) && !p.inLane(tx) && graph.OverQuota(tx, used, gasLimit):
+			problems = append(problems, fmt.Sprintf("%s takes its sender or contract over its gas quota", tx.Hash))
+		}
+		used[tx.Hash] = true
+		gas += tx.PackGas()
+	}
+	if gas > gasLimit {
+		problems = append(problems, fmt.Sprintf("uses %d gas, over the limit of %d", gas, gasLimit))
+	}
+	for _, hash := range p.Required {
+		if !used[hash] {
+			problems = append(problems, fmt.Sprintf("required %s is missing", hash))
+		}
+	}
+	problems = append(problems, p.orderProblems(txs)...)
+	gaps := p.nonceGaps(txs)
+	for i, tx := range txs {
+		if n, ok := gaps[i]; ok {
+			problems = append(problems, fmt.Sprintf("%s leaves out nonce %d of its sender, which is still pooled", tx.Hash, n))
+		}
+	}
+	if p.Hints != nil {
+		for _, id := range p.Hints.Cancelled(txs) {
+			problems = append(problems, fmt.Sprintf("bundle %s was cancelled", id))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// nonceChains holds a packer to taking each sender's nonces only as a
+// prefix: a transaction waits until the lower nonces it needs (a bundle's
+// members' included) are in the block, and is left out with any of them
+type nonceChains struct {
+	pool    *TxPool
+	filled  map[nonceSlot]bool
+	skipped map[string]bool
+	waiting map[nonceSlot][]*Transaction // by the highest lower nonce they lack
+}
+
+// nonceChains starts the chains of a block opening with selected. Called
+// with the pool read-locked.
+func (p *TxPool) nonceChains(selected []*Transaction) *nonceChains {
+	c := &nonceChains{pool: p, filled: map[nonceSlot]bool{}, skipped: map[string]bool{}, waiting: map[nonceSlot][]*Transaction{}}
+	for _, tx := range selected {
+		c.taken(tx)
+	}
+	return c
+}
+
+func (c *nonceChains) members(tx *Transaction) []*Transaction {
+	return c.pool.orderEntries([]*Transaction{tx})[0].members
+}
+
+// wait reports whether tx must stay out, parking it until the lower nonces
+// it lacks are taken, or for good if one of them was skipped. It is parked
+// on the highest of them for each sender, which is only taken once those
+// below are, so it is retried about once per sender.
+func (c *nonceChains) wait(tx *Transaction) bool {
+	members := c.members(tx)
+	own := make(map[nonceSlot]bool, len(members))
+	for _, m := range members {
+		own[slotOf(m)] = true
+	}
+	open := map[nonceSlot]bool{} // lacking; true while one pooled there may still be taken
+	for _, m := range members {
+		for _, t := range c.pool.lowerNonces(m) {
+			if s := slotOf(t); !c.filled[s] && !own[s] {
+				open[s] = open[s] || !c.skipped[t.Hash]
+			}
+		}
+	}
+	if len(open) == 0 {
+		return false
+	}
+	for _, ok := range open {
+		if !ok {
+			c.skipped[tx.Hash] = true
+			return true
+		}
+	}
+	highest := map[string]nonceSlot{}
+	for s := range open {
+		if h, ok := highest[s.from]; !ok || s.nonce > h.nonce {
+			highest[s.from] = s
+		}
+	}
+	for _, s := range highest {
+		if !slices.Contains(c.waiting[s], tx) {
+			c.waiting[s] = append(c.waiting[s], tx)
+		}
+	}
+	return true
+}
+
+// skip records that tx was left out, and with it its sender's later nonces
+func (c *nonceChains) skip(tx *Transaction) { c.skipped[tx.Hash] = true }
+
+// taken records that tx made the block, returning the transactions that
+// were waiting on its nonces, to be tried again
+func (c *nonceChains) taken(tx *Transaction) []*Transaction {
+	var next []*Transaction
+	for _, m := range c.members(tx) {
+		s := slotOf(m)
+		c.filled[s] = true
+		for _, t := range c.waiting[s] {
+			if !slices.Contains(next, t) {
+				next = append(next, t)
+			}
+		}
+		delete(c.waiting, s)
+	}
+	return next
+}
+
+// selectDensity is selectGreedy ranking by score per unit of gas instead of
+// score, which packs more value into a full block when many small
+// transactions compete with a few large ones
+func (p *TxPool) selectDensity(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	queued, low := slices.Clone(p.Heap.TxHeap), slices.Clone(p.Low.TxHeap)
+	merged, sandwiches := p.matchedBundles()
+	queued = append(queued, merged...)
+	low = append(low, sandwiches...)
+	graph := p.conflictGraph(slices.Concat(queued[p.Heap.Len():], low[p.Low.Len():])...)
+
+	selected, usedGas, used, err := p.openBlock(gasLimit, graph)
+	if err != nil {
+		return nil, err
+	}
+	if best != nil {
+		best.Offer(selected)
+	}
+	slices.SortFunc(queued, func(a, b *Transaction) int { return cmpDensity(b, a) })
+	// Deprioritized transactions only fill what is left, in score order
+	slices.SortFunc(low, func(a, b *Transaction) int { return cmpScore(b, a) })
+	chains := p.nonceChains(selected)
+	var take func(tx *Transaction)
+	take = func(tx *Transaction) {
+		if used[tx.Hash] || graph.Conflicts(tx.Hash, used) || usedGas+tx.GasLimit > gasLimit || graph.OverQuota(tx, used, gasLimit) {
+			chains.skip(tx)
+			return
+		}
+		if chains.wait(tx) {
+			return
+		}
+		usedGas += tx.PackGas()
+		used[tx.Hash] = true
+		selected = append(selected, tx)
+		if best != nil {
+			best.Offer(selected)
+		}
+		for _, t := range chains.taken(tx) {
+			take(t)
+		}
+	}
+	scan := startScan(ctx, len(queued)+len(low))
+	defer p.finishScan(scan)
+	for _, h := range []TxHeap{queued, low} {
+		for _, tx := range h {
+			if usedGas >= gasLimit || !scan.next() {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			take(tx)
+		}
+	}
+	return selected, nil
+}
+
+// dpBuckets is the resolution of the gas axis of selectDP's knapsack
+const dpBuckets = 4096
+
+// dpMaxItems caps the transactions selectDP's knapsack considers; the rest
+// only top the block up
+const dpMaxItems = 512
+
+// selectDP opens the block like selectGreedy, then solves the 0/1 knapsack
+// of the best-scoring queued transactions and bundles over the gas left by
+// dynamic programming, with gas rounded up to dpBuckets units so the chosen
+// set always fits. The knapsack ignores conflicts, so the chosen
+// transactions are taken in score order skipping any that conflict, and
+// the block is topped up like selectWIS.
+func (p *TxPool) selectDP(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	queued, low := slices.Clone(p.Heap.TxHeap), slices.Clone(p.Low.TxHeap)
+	merged, sandwiches := p.matchedBundles()
+	queued = append(queued, merged...)
+	low = append(low, sandwiches...)
+	graph := p.conflictGraph(slices.Concat(queued[p.Heap.Len():], low[p.Low.Len():])...)
+
+	selected, usedGas, used, err := p.openBlock(gasLimit, graph)
+	if err != nil {
+		return nil, err
+	}
+	if best != nil {
+		best.Offer(selected)
+	}
+	chains := p.nonceChains(selected)
+	var take func(tx *Transaction)
+	take = func(tx *Transaction) {
+		if used[tx.Hash] || graph.Conflicts(tx.Hash, used) || usedGas+tx.GasLimit > gasLimit || graph.OverQuota(tx, used, gasLimit) {
+			chains.skip(tx)
+			return
+		}
+		if chains.wait(tx) {
+			return
+		}
+		usedGas += tx.PackGas()
+		used[tx.Hash] = true
+		selected = append(selected, tx)
+		if best != nil {
+			best.Offer(selected)
+		}
+		for _, t := range chains.taken(tx) {
+			take(t)
+		}
+	}
+
+	heap.Init(&queued)
+	var candidates []*Transaction
+	for queued.Len() > 0 {
+		tx := heap.Pop(&queued).(*Transaction)
+		if !used[tx.Hash] && !graph.Conflicts(tx.Hash, used) {
+			candidates = append(candidates, tx)
+		}
+	}
+	items := candidates[:min(len(candidates), dpMaxItems)]
+	room := gasLimit - usedGas
+	unit := max((room+dpBuckets-1)/dpBuckets, 1)
+	capacity := int(room / unit)
+	value := make([]int64, capacity+1) // best score within w units
+	keep := make([][]bool, len(items))
+	for i, tx := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		keep[i] = make([]bool, capacity+1)
+		weight := int((tx.GasLimit + unit - 1) / unit)
+		if tx.Score() <= 0 || weight > capacity {
+			continue
+		}
+		for w := capacity; w >= weight; w-- {
+			if v := value[w-weight] + tx.Score(); v > value[w] {
+				value[w] = v
+				keep[i][w] = true
+			}
+		}
+	}
+	var chosen []*Transaction
+	for i, w := len(items)-1, capacity; i >= 0; i-- {
+		if keep[i][w] {
+			chosen = append(chosen, items[i])
+			w -= int((items[i].GasLimit + unit - 1) / unit)
+		}
+	}
+	slices.SortFunc(chosen, func(a, b *Transaction) int { return cmpScore(b, a) })
+	for _, tx := range chosen {
+		take(tx)
+	}
+	for _, h := range []TxHeap{candidates, low} {
+		heap.Init(&h)
+		for h.Len() > 0 && usedGas < gasLimit {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			take(heap.Pop(&h).(*Transaction))
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// gappedPool pools a sender's nonce 0, too big to share the block with
+// anything else, and its much better paying nonce 1, next to another
+// sender's nonce 0
+func gappedPool(t *testing.T) *TxPool {
+	t.Helper()
+	p := NewTxPool()
+	for _, tx := range []*Transaction{
+		{Hash: "s0", From: "S", Nonce: 0, GasLimit: 90000, GasPrice: 1},
+		{Hash: "s1", From: "S", Nonce: 1, GasLimit: 21000, GasPrice: 1000},
+		{Hash: "o0", From: "O", Nonce: 0, GasLimit: 80000, GasPrice: 100},
+	} {
+		if res := p.AddTx(tx); res == TxRejected {
+			t.Fatalf("%s rejected", tx.Hash)
+		}
+	}
+	return p
+}
+
+func hashes(txs []*Transaction) []string {
+	out := make([]string, len(txs))
+	for i, tx := range txs {
+		out[i] = tx.Hash
+	}
+	return out
+}
+
+func TestStrategiesKeepNoncePrefix(t *testing.T) {
+	// First come first served takes s0, which arrived first, and has no room
+	// left for anything else
+	arrival := map[string]bool{"fcfs": true, "fair": true}
+	for _, name := range PackerNames() {
+		t.Run(name, func(t *testing.T) {
+			p := gappedPool(t)
+			txs, err := SelectWithStrategy(context.Background(), p, name, 110000, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"o0"}
+			if arrival[name] {
+				want = []string{"s0"}
+			}
+			if got := hashes(txs); !slices.Equal(got, want) {
+				t.Errorf("block %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateBlockRejectsNonceGap(t *testing.T) {
+	p := gappedPool(t)
+	s1, o0 := p.AllTxs["s1"], p.AllTxs["o0"]
+	if err := p.ValidateBlock(110000, []*Transaction{s1, o0}); err == nil {
+		t.Error("block running nonce 1 without nonce 0 validated")
+	}
+	if err := p.ValidateBlock(200000, []*Transaction{p.AllTxs["s0"], s1, o0}); err != nil {
+		t.Errorf("full block: %v", err)
+	}
+}
+
+func TestStrategiesFillPastHeldNonce(t *testing.T) {
+	// s1 waits for s0, which doesn't fit beside o0, so p0 gets the room s1
+	// would have wasted
+	arrival := map[string]bool{"fcfs": true, "fair": true}
+	for _, name := range PackerNames() {
+		t.Run(name, func(t *testing.T) {
+			p := gappedPool(t)
+			p.AddTx(&Transaction{Hash: "p0", From: "P", Nonce: 0, GasLimit: 21000, GasPrice: 50})
+			txs, err := SelectWithStrategy(context.Background(), p, name, 110000, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"o0", "p0"}
+			if arrival[name] {
+				want = []string{"s0"}
+			}
+			if got := hashes(txs); !slices.Equal(got, want) {
+				t.Errorf("block %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ParallelConfig tunes the "parallel" strategy
+type ParallelConfig struct {
+	Workers int `json:"workers"` // goroutines speculating at once; 0 is one per CPU
+	Window  int `json:"window"`  // candidates speculated on per round
+}
+
+// DefaultParallelConfig is used unless builder.parallel says otherwise
+func DefaultParallelConfig() ParallelConfig {
+	return ParallelConfig{Window: 256}
+}
+
+// ParallelResult reports how a "parallel" pack went
+type ParallelResult struct {
+	Rounds     int
+	Speculated int // candidates admitted tentatively against the block as a round began
+	RolledBack int // of those, the ones a higher-ranked commit in the same round ruled out
+	Packed     int // transactions scheduled into waves, after the required and lane ones
+	Waves      int // groups of transactions with disjoint read/write sets
+}
+
+// selectParallel packs optimistically. Candidates are taken in rank order
+// (see Ranking) a window at a time, and every one in the window is checked at
+// once, on Parallel.Workers goroutines, against the block as it stood when
+// the round began: whether it fits, conflicts or breaks a quota, and what
+// it reads and writes. The round then commits them in score order, rolling
+// back any a higher-ranked commit in the same round invalidated, which on
+// a mostly independent pool is few, so the serial part stays small. It
+// picks what greedy would.
+//
+// Each committed transaction is scheduled into the first wave after every
+// earlier one it contends with, writing what that one reads or writes or
+// reading what it writes, and the block lists the waves in turn. The
+// transactions of a wave could run in parallel, and any order of them is
+// equivalent to the rank order they committed in, so the block is
+// serializable. Read/write sets come from Access where it has a trace,
+// else from the transaction: its sender's account and any account it pays
+// are written, and a contract it calls counts as written whole unless the
+// access list names the slots.
+func (p *TxPool) selectParallel(ctx context.Context, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	cfg := p.Parallel
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	window := max(cfg.Window, 1)
+
+	queued, low := slices.Clone(p.Heap.TxHeap), slices.Clone(p.Low.TxHeap)
+	merged, sandwiches := p.matchedBundles()
+	queued = append(queued, merged...)
+	low = append(low, sandwiches...)
+	graph := p.conflictGraph(slices.Concat(queued[p.Heap.Len():], low[p.Low.Len():])...)
+
+	selected, usedGas, used, err := p.openBlock(gasLimit, graph)
+	if err != nil {
+		return nil, err
+	}
+	if best != nil {
+		best.Offer(selected)
+	}
+	prefix := len(selected)
+
+	res := &ParallelResult{}
+	waves := newWaveSchedule()
+	var wave []int // of each packed transaction
+	chains := p.nonceChains(selected)
+	committed := map[string]bool{}
+	// commit takes tx, then retries those its sender's later nonces held back
+	var commit func(tx *Transaction, set *AccessSet)
+	commit = func(tx *Transaction, set *AccessSet) {
+		if chains.wait(tx) {
+			return
+		}
+		usedGas += tx.PackGas()
+		used[tx.Hash] = true
+		committed[tx.Hash] = true
+		selected = append(selected, tx)
+		wave = append(wave, waves.place(set))
+		for _, t := range chains.taken(tx) {
+			if used[t.Hash] || graph.Conflicts(t.Hash, used) || usedGas+t.GasLimit > gasLimit || graph.OverQuota(t, used, gasLimit) {
+				chains.skip(t)
+				continue
+			}
+			commit(t, p.accessSet(t))
+		}
+	}
+	for _, candidates := range [][]*Transaction{queued, low} {
+		rank := p.rank()
+		slices.SortFunc(candidates, func(a, b *Transaction) int { return rank(b, a) })
+		for start := 0; start < len(candidates) && usedGas < gasLimit; start += window {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			batch := candidates[start:min(start+window, len(candidates))]
+			ok, sets := p.speculate(batch, graph, used, gasLimit-usedGas, gasLimit, workers)
+			res.Rounds++
+			clear(committed)
+			for i, tx := range batch {
+				switch {
+				case used[tx.Hash]:
+					continue // taken after a lower nonce committed this round
+				case !ok[i]:
+					chains.skip(tx)
+					continue
+				}
+				res.Speculated++
+				if graph.Conflicts(tx.Hash, committed) ||
+					usedGas+tx.GasLimit > gasLimit || graph.OverQuota(tx, used, gasLimit) {
+					res.RolledBack++
+					chains.skip(tx)
+					continue
+				}
+				commit(tx, sets[i])
+			}
+			if best != nil && len(committed) > 0 {
+				best.Offer(selected)
+			}
+		}
+	}
+
+	packed := selected[prefix:]
+	order := make([]int, len(packed))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(wave[a], wave[b]) })
+	block := slices.Clone(selected[:prefix])
+	for _, i := range order {
+		block = append(block, packed[i])
+	}
+	res.Packed, res.Waves = len(packed), waves.count
+	p.lastParallel.Store(res)
+	return block, nil
+}
+
+// speculate checks each of batch against the transactions in used, which
+// leave free gas of the block, on up to workers goroutines, and works out
+// the read/write set of those that pass. Nothing it reads changes while it
+// runs.
+func (p *TxPool) speculate(batch []*Transaction, graph *ConflictGraph, used map[string]bool, free, gasLimit int64, workers int) ([]bool, []*AccessSet) {
+	ok := make([]bool, len(batch))
+	sets := make([]*AccessSet, len(batch))
+	var wg sync.WaitGroup
+	chunk := (len(batch) + workers - 1) / workers
+	for lo := 0; lo < len(batch); lo += chunk {
+		hi := min(lo+chunk, len(batch))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				tx := batch[i]
+				if used[tx.Hash] || tx.GasLimit > free || graph.Conflicts(tx.Hash, used) || graph.OverQuota(tx, used, gasLimit) {
+					continue
+				}
+				ok[i], sets[i] = true, p.accessSet(tx)
+			}
+		}()
+	}
+	wg.Wait()
+	return ok, sets
+}
+
+// accessSet returns what tx reads and writes, keyed like AccessSet with a
+// bare lower-case address for an account's balance and nonce and
+// "address/*" for the whole of a contract's storage. A bundle's is the
+// union of its members'. Called with the pool read-locked.
+func (p *TxPool) accessSet(tx *Transaction) *AccessSet {
+	set := &AccessSet{Reads: map[string]bool{}, Writes: map[string]bool{}}
+	members := []*Transaction{tx}
+	if len(tx.Bundle) > 0 && p.Hints != nil {
+		if m, _, ok := p.Hints.Members(tx.Hash); ok {
+			members = m
+		}
+	}
+	for _, m := range members {
+		if m.From != "" {
+			set.Writes[strings.ToLower(m.From)] = true
+		}
+		if m.To == "" {
+			continue
+		}
+		to := strings.ToLower(m.To)
+		if m.Value != nil && m.Value.Sign() > 0 {
+			set.Writes[to] = true
+		}
+		var traced *AccessSet
+		if p.Access != nil {
+			traced = p.Access(m.Hash)
+		}
+		switch {
+		case traced != nil:
+			// Whole-contract writes by untraced transactions still
+			// contend with the slots this one touches
+			for _, keys := range []map[string]bool{traced.Reads, traced.Writes} {
+				for key := range keys {
+					addr, _, _ := strings.Cut(key, "/")
+					set.Reads[addr+"/*"] = true
+				}
+			}
+			for key := range traced.Reads {
+				set.Reads[key] = true
+			}
+			for key := range traced.Writes {
+				set.Writes[key] = true
+			}
+		case len(m.AccessList) > 0:
+			for _, t := range m.AccessList {
+				set.Reads[strings.ToLower(t.Address)+"/*"] = true
+				for _, slot := range t.StorageKeys {
+					set.Writes[storageKey(t.Address, slot)] = true
+				}
+			}
+		case len(m.Input) > 0:
+			set.Writes[to+"/*"] = true
+		}
+	}
+	// A whole-contract write contends with every slot of the contract
+	for key := range set.Writes {
+		if addr, ok := strings.CutSuffix(key, "/*"); ok {
+			set.Reads[addr+"/*"] = true
+		}
+	}
+	return set
+}
+
+// waveSchedule assigns transactions, in commit order, to the earliest wave
+// after every earlier one they contend with
+type waveSchedule struct {
+	count     int
+	lastRead  map[string]int // latest wave reading each key
+	lastWrite map[string]int // latest wave writing each key
+}
+
+func newWaveSchedule() *waveSchedule {
+	return &waveSchedule{lastRead: map[string]int{}, lastWrite: map[string]int{}}
+}
+
+// place schedules a transaction accessing set and returns its wave
+func (s *waveSchedule) place(set *AccessSet) int {
+	wave := 0
+	after := func(last map[string]int, key string) {
+		if w, ok := last[key]; ok && w+1 > wave {
+			wave = w + 1
+		}
+	}
+	for key := range set.Reads {
+		after(s.lastWrite, key)
+	}
+	for key := range set.Writes {
+		after(s.lastWrite, key)
+		after(s.lastRead, key)
+	}
+	record := func(last map[string]int, key string) {
+		if w, ok := last[key]; !ok || wave > w {
+			last[key] = wave
+		}
+	}
+	for key := range set.Reads {
+		record(s.lastRead, key)
+	}
+	for key := range set.Writes {
+		record(s.lastWrite, key)
+	}
+	s.count = max(s.count, wave+1)
+	return wave
+}
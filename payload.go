@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// Withdrawal is a consensus-layer withdrawal credited in the block (EIP-4895)
+type Withdrawal struct {
+	Index          HexUint64 `json:"index"`
+	ValidatorIndex HexUint64 `json:"validatorIndex"`
+	Address        Address   `json:"address"`
+	Amount         HexUint64 `json:"amount"` // in gwei
+}
+
+// ExecutionPayload is the Engine API (Deneb) execution payload, the shape a
+// consensus client or relay consumes. Fields that require executing the
+// block (stateRoot, receiptsRoot, logsBloom, blockHash) stay zero until the
+// block is assembled from simulation results.
+type ExecutionPayload struct {
+	ParentHash    Hash         `json:"parentHash"`
+	FeeRecipient  Address      `json:"feeRecipient"`
+	StateRoot     Hash         `json:"stateRoot"`
+	ReceiptsRoot  Hash         `json:"receiptsRoot"`
+	LogsBloom     Bloom        `json:"logsBloom"`
+	PrevRandao    Hash         `json:"prevRandao"`
+	BlockNumber   HexUint64    `json:"blockNumber"`
+	GasLimit      HexUint64    `json:"gasLimit"`
+	GasUsed       HexUint64    `json:"gasUsed"`
+	Timestamp     HexUint64    `json:"timestamp"`
+	ExtraData     HexBytes     `json:"extraData"`
+	BaseFeePerGas HexUint64    `json:"baseFeePerGas"`
+	BlockHash     Hash         `json:"blockHash"`
+	Transactions  []HexBytes   `json:"transactions"` // raw RLP / typed envelopes
+	Withdrawals   []Withdrawal `json:"withdrawals"`
+	BlobGasUsed   HexUint64    `json:"blobGasUsed"`
+	ExcessBlobGas HexUint64    `json:"excessBlobGas"`
+}
+
+// BlockTemplate is a built block: the payload plus the bookkeeping the
+// builder needs to price and report it
+type BlockTemplate struct {
+	Payload          *ExecutionPayload `json:"executionPayload"`
+	ParentBeaconRoot *Hash             `json:"parentBeaconBlockRoot,omitempty"`
+	Value            int64             `json:"value"` // fee recipient's balance delta in wei; see BlockValue
+	Transactions     []*Transaction    `json:"-"`
+	Payment          *Transaction      `json:"-"` // proposer payment appended by AddPayment, if any
+}
+
+// NewBlockTemplate assembles a payload on top of parent with attrs from
+// the selected transactions, which must carry their raw encoding (see
+// FetchRawTransactions). The attributes' withdrawals are credited in the
+// block; they use no gas.
+func NewBlockTemplate(parent *Header, attrs *PayloadAttributes, gasLimit int64, txs []*Transaction) (*BlockTemplate, error) {
+	if err := attrs.Check(parent); err != nil {
+		return nil, err
+	}
+	p := &ExecutionPayload{
+		ParentHash:    attrs.ParentHash,
+		FeeRecipient:  attrs.SuggestedFeeRecipient,
+		PrevRandao:    attrs.PrevRandao,
+		BlockNumber:   HexUint64(parent.Number + 1),
+		GasLimit:      HexUint64(gasLimit),
+		Timestamp:     attrs.Timestamp,
+		BaseFeePerGas: HexUint64(parent.BaseFee),
+		Transactions:  make([]HexBytes, 0, len(txs)),
+		Withdrawals:   slices.Clone(attrs.Withdrawals),
+	}
+	if p.Withdrawals == nil {
+		p.Withdrawals = []Withdrawal{}
+	}
+
+	t := &BlockTemplate{Payload: p, ParentBeaconRoot: attrs.ParentBeaconBlockRoot, Transactions: txs}
+	for _, tx := range txs {
+		if len(tx.Raw) == 0 {
+			return nil, fmt.Errorf("transaction %s has no raw encoding", tx.Hash)
+		}
+		p.Transactions = append(p.Transactions, tx.Raw)
+		p.GasUsed += HexUint64(tx.GasLimit) // upper bound until executed
+	}
+	t.Value = BlockValue(txs, parent.BaseFee)
+	return t, nil
+}
+
+// ProposerRecipient returns the address the block's value goes to: the
+// recipient of the proposer payment, or else the payload's fee recipient
+func (t *BlockTemplate) ProposerRecipient() Address {
+	if t.Payment != nil {
+		if to, err := HexToAddress(t.Payment.To); err == nil {
+			return to
+		}
+	}
+	return t.Payload.FeeRecipient
+}
+
+// CheckFeeRecipient fails unless the block pays want, the fee recipient a
+// proposer registered; relays reject bids that pay anyone else
+func (t *BlockTemplate) CheckFeeRecipient(want Address) error {
+	if got := t.ProposerRecipient(); got != want {
+		return fmt.Errorf("block pays %s, but the proposer registered %s", got, want)
+	}
+	return nil
+}
+
+// NewBidTrace returns the relay bid message for t, which must have been
+// assembled so its block hash is set
+func NewBidTrace(t *BlockTemplate, slot uint64, builder, proposer BLSPubkey) *BidTrace {
+	p := t.Payload
+	return &BidTrace{
+		Slot:                 slot,
+		ParentHash:           p.ParentHash,
+		BlockHash:            p.BlockHash,
+		BuilderPubkey:        builder,
+		ProposerPubkey:       proposer,
+		ProposerFeeRecipient: t.ProposerRecipient(),
+		GasLimit:             uint64(p.GasLimit),
+		GasUsed:              uint64(p.GasUsed),
+		Value:                uint64(max(t.Value, 0)),
+	}
+}
+
+// FetchRawTransactions fills in the raw encoding of txs that lack one using
+// batched eth_getRawTransactionByHash calls
+func FetchRawTransactions(ctx context.Context, rpc *RPCClient, txs []*Transaction) error {
+	var missing []*Transaction
+	for _, tx := range txs {
+		if len(tx.Raw) == 0 {
+			missing = append(missing, tx)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	raws := make([]HexBytes, len(missing))
+	elems := make([]BatchElem, len(missing))
+	for i, tx := range missing {
+		elems[i] = BatchElem{
+			Method: "eth_getRawTransactionByHash",
+			Params: []any{tx.Hash},
+			Result: &raws[i],
+		}
+	}
+	if err := rpc.BatchCall(ctx, elems); err != nil {
+		return err
+	}
+	for i, tx := range missing {
+		if elems[i].Error != nil {
+			return fmt.Errorf("raw transaction %s: %w", tx.Hash, elems[i].Error)
+		}
+		if len(raws[i]) == 0 {
+			return fmt.Errorf("raw transaction %s not found", tx.Hash)
+		}
+		tx.Raw = raws[i]
+	}
+	return nil
+}
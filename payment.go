@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// paymentGas is the gas of the proposer payment, a plain value transfer
+const paymentGas = txBaseGas
+
+// DynamicFeeTx is an unsigned EIP-1559 transaction, as the builder signs
+// its own
+type DynamicFeeTx struct {
+	ChainID   uint64
+	Nonce     uint64
+	GasTipCap int64 // maxPriorityFeePerGas
+	GasFeeCap int64 // maxFeePerGas
+	Gas       int64
+	To        Address
+	Value     int64 // wei
+	Data      []byte
+}
+
+// fields returns the RLP items of the transaction before its signature
+func (t *DynamicFeeTx) fields() [][]byte {
+	return [][]byte{
+		rlpUint(t.ChainID),
+		rlpUint(t.Nonce),
+		rlpUint(uint64(t.GasTipCap)),
+		rlpUint(uint64(t.GasFeeCap)),
+		rlpUint(uint64(t.Gas)),
+		rlpBytes(t.To[:]),
+		rlpUint(uint64(t.Value)),
+		rlpBytes(t.Data),
+		rlpList(), // access list
+	}
+}
+
+// SigningHash returns the hash signed by the sender
+func (t *DynamicFeeTx) SigningHash() Hash {
+	return Keccak256([]byte{2}, rlpList(t.fields()...))
+}
+
+// Sign signs the transaction with key and returns it as a pooled
+// Transaction carrying its raw envelope
+func (t *DynamicFeeTx) Sign(key *SigningKey) *Transaction {
+	r, s, recID := key.Sign(t.SigningHash())
+	fields := append(t.fields(), rlpUint(uint64(recID)), rlpBig(r), rlpBig(s))
+	raw := append([]byte{2}, rlpList(fields...)...)
+	tx := &Transaction{
+		Hash:          Keccak256(raw).Hex(),
+		Type:          2,
+		ChainID:       t.ChainID,
+		From:          key.Address().Hex(),
+		To:            t.To.Hex(),
+		GasPrice:      t.GasFeeCap,
+		GasTipCap:     t.GasTipCap,
+		GasLimit:      t.Gas,
+		Nonce:         int(t.Nonce),
+		ConflictsWith: []string{},
+		Raw:           raw,
+	}
+	if len(t.Data) > 0 {
+		tx.Input = t.Data
+	}
+	if t.Value > 0 {
+		tx.Value = big.NewInt(t.Value)
+	}
+	return tx
+}
+
+// BlockValue returns what txs pay the block's fee recipient at baseFee:
+// their priority fees on the gas they are counted as using, plus their
+// direct coinbase transfers (MEVBonus). It is the fee recipient's balance
+// delta, the value relays score a block by; base fees are burned and PoL
+// incentives are paid out separately, so neither counts.
+func BlockValue(txs []*Transaction, baseFee int64) int64 {
+	value := int64(0)
+	for _, tx := range txs {
+		value += max(tx.EffectiveTip(baseFee), 0)*tx.PackGas() + tx.MEVBonus
+	}
+	return value
+}
+
+// AddPayment makes the builder's coinbase key the block's fee recipient
+// and appends a transaction paying everything the block earns it, less the
+// payment's own burned fee, to the proposer's fee recipient. The block's
+// value becomes the payment: the proposer's balance delta. nonce is the
+// coinbase account's next nonce.
+func (t *BlockTemplate) AddPayment(key *SigningKey, chainID, nonce uint64, proposer Address) error {
+	p := t.Payload
+	baseFee := int64(p.BaseFeePerGas)
+	surplus := BlockValue(t.Transactions, baseFee)
+	value := surplus - paymentGas*baseFee
+	if value <= 0 {
+		return fmt.Errorf("block earns %s, not enough to pay the proposer", FormatWei(surplus))
+	}
+	if uint64(p.GasUsed)+paymentGas > uint64(p.GasLimit) {
+		return fmt.Errorf("no gas left for the proposer payment")
+	}
+	payment := (&DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasFeeCap: baseFee,
+		Gas:       paymentGas,
+		To:        proposer,
+		Value:     value,
+	}).Sign(key)
+
+	p.FeeRecipient = key.Address()
+	p.Transactions = append(p.Transactions, payment.Raw)
+	p.GasUsed += paymentGas
+	t.Transactions = append(t.Transactions, payment)
+	t.Payment = payment
+	t.Value = value
+	return nil
+}
+
+// FetchNonce returns the next nonce of addr on top of the latest block
+func FetchNonce(ctx context.Context, rpc *RPCClient, addr Address) (uint64, error) {
+	var nonce string
+	if err := rpc.Call(ctx, &nonce, "eth_getTransactionCount", addr.Hex(), "latest"); err != nil {
+		return 0, fmt.Errorf("fetching nonce of %s: %w", addr, err)
+	}
+	return ParseHexUint64(nonce)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// errUnsupported is returned by local execution for what it can't run, so
+// the caller falls back to the node
+var errUnsupported = errors.New("not supported by local execution")
+
+// precompile is a contract implemented natively. gas returns what running
+// it on input costs, and run its output; a run error consumes all the gas
+// it was given.
+type precompile struct {
+	gas func(input []byte) uint64
+	run func(input []byte) ([]byte, error)
+}
+
+// precompiles are the Cancun precompiled contracts, by address. Those of
+// the alt_bn128 curve and KZG point evaluation are recognised but not
+// implemented, nor are Prague's BLS12-381 ones.
+var precompiles = map[Address]*precompile{
+	precompileAddress(1):  {gas: fixedGas(3000), run: ecrecoverPrecompile},
+	precompileAddress(2):  {gas: wordGas(60, 12), run: func(in []byte) ([]byte, error) { h := sha256.Sum256(in); return h[:], nil }},
+	precompileAddress(3):  {gas: wordGas(600, 120), run: ripemd160Precompile},
+	precompileAddress(4):  {gas: wordGas(15, 3), run: func(in []byte) ([]byte, error) { return append([]byte(nil), in...), nil }},
+	precompileAddress(5):  {gas: modexpGas, run: modexpPrecompile},
+	precompileAddress(6):  unsupportedPrecompile,
+	precompileAddress(7):  unsupportedPrecompile,
+	precompileAddress(8):  unsupportedPrecompile,
+	precompileAddress(9):  {gas: blake2fGas, run: blake2fPrecompile},
+	precompileAddress(10): unsupportedPrecompile,
+}
+
+// praguePrecompiles are the BLS12-381 precompiles Prague adds
+var praguePrecompiles = []Address{
+	precompileAddress(0x0b), precompileAddress(0x0c), precompileAddress(0x0d), precompileAddress(0x0e),
+	precompileAddress(0x0f), precompileAddress(0x10), precompileAddress(0x11),
+}
+
+var unsupportedPrecompile = &precompile{
+	gas: fixedGas(0),
+	run: func([]byte) ([]byte, error) { return nil, errUnsupported },
+}
+
+func precompileAddress(n byte) Address {
+	var a Address
+	a[19] = n
+	return a
+}
+
+func fixedGas(gas uint64) func([]byte) uint64 {
+	return func([]byte) uint64 { return gas }
+}
+
+// wordGas charges base plus perWord for each 32-byte word of input
+func wordGas(base, perWord uint64) func([]byte) uint64 {
+	return func(in []byte) uint64 { return base + perWord*toWordSize(uint64(len(in))) }
+}
+
+// rightPad returns in extended with zeros to at least n bytes
+func rightPad(in []byte, n int) []byte {
+	if len(in) >= n {
+		return in
+	}
+	out := make([]byte, n)
+	copy(out, in)
+	return out
+}
+
+// ecrecoverPrecompile returns the address that signed a hash, left-padded
+// to a word, or nothing for an invalid signature. Unlike a transaction
+// signature, s may be in the upper half of the order.
+func ecrecoverPrecompile(in []byte) ([]byte, error) {
+	in = rightPad(in, 128)
+	v := new(big.Int).SetBytes(in[32:64])
+	r := new(big.Int).SetBytes(in[64:96])
+	s := new(big.Int).SetBytes(in[96:128])
+	if !v.IsUint64() || (v.Uint64() != 27 && v.Uint64() != 28) || s.Sign() <= 0 || s.Cmp(secpN) >= 0 {
+		return nil, nil
+	}
+	recID := byte(v.Uint64() - 27)
+	// (r, n-s) signs for the same key as (r, s), with R negated
+	if s.Cmp(secpHalfN) > 0 {
+		s.Sub(secpN, s)
+		recID ^= 1
+	}
+	addr, err := RecoverAddress(Hash(in[:32]), r, s, recID)
+	if err != nil {
+		return nil, nil
+	}
+	return append(make([]byte, 12), addr[:]...), nil
+}
+
+// modexpInput splits a modexp input into its lengths and the rest
+func modexpInput(in []byte) (baseLen, expLen, modLen *big.Int, rest []byte) {
+	head := rightPad(in, 96)
+	baseLen = new(big.Int).SetBytes(head[:32])
+	expLen = new(big.Int).SetBytes(head[32:64])
+	modLen = new(big.Int).SetBytes(head[64:96])
+	if len(in) > 96 {
+		rest = in[96:]
+	}
+	return baseLen, expLen, modLen, rest
+}
+
+// modexpGas prices modexp as EIP-2565 has it, saturating: lengths too
+// large for a uint64 still cost nothing if the base and modulus are empty
+func modexpGas(in []byte) uint64 {
+	baseLen, expLen, modLen, rest := modexpInput(in)
+	bl, el, ml := saturatingUint64(baseLen), saturatingUint64(expLen), saturatingUint64(modLen)
+
+	// The first 32 bytes of the exponent decide its iteration count
+	var head []byte
+	if bl < uint64(len(rest)) {
+		head = rest[bl:]
+	}
+	head = rightPad(head, int(min(el, 32)))[:min(el, 32)]
+	var iterations uint64
+	if el > 32 {
+		var hi uint64
+		if hi, iterations = bits.Mul64(el-32, 8); hi != 0 {
+			iterations = math.MaxUint64
+		}
+	}
+	if msb := new(big.Int).SetBytes(head).BitLen(); msb > 0 {
+		var carry uint64
+		if iterations, carry = bits.Add64(iterations, uint64(msb-1), 0); carry != 0 {
+			iterations = math.MaxUint64
+		}
+	}
+	iterations = max(iterations, 1)
+
+	x := max(bl, ml)
+	words := x / 8
+	if x%8 != 0 {
+		words++
+	}
+	hi, complexity := bits.Mul64(words, words)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+	if hi, gas := bits.Mul64(complexity, iterations); hi == 0 {
+		return max(200, gas/3)
+	}
+	return math.MaxUint64
+}
+
+func saturatingUint64(x *big.Int) uint64 {
+	if !x.IsUint64() {
+		return math.MaxUint64
+	}
+	return x.Uint64()
+}
+
+// modexpPrecompile returns base**exp % mod, as many bytes long as mod
+func modexpPrecompile(in []byte) ([]byte, error) {
+	baseLen, expLen, modLen, rest := modexpInput(in)
+	bl, el, ml := baseLen.Uint64(), expLen.Uint64(), modLen.Uint64()
+	if bl == 0 && ml == 0 {
+		return nil, nil
+	}
+	data := rightPad(rest, int(bl+el+ml))
+	base := new(big.Int).SetBytes(data[:bl])
+	exp := new(big.Int).SetBytes(data[bl : bl+el])
+	mod := new(big.Int).SetBytes(data[bl+el : bl+el+ml])
+	out := make([]byte, ml)
+	if mod.BitLen() == 0 {
+		return out, nil
+	}
+	new(big.Int).Exp(base, exp, mod).FillBytes(out)
+	return out, nil
+}
+
+// RIPEMD-160, for the precompile at address 3
+
+var (
+	ripemdR1 = [80]uint8{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		7, 4, 13, 1, 10, 6, 15, 3, 12, 0, 9, 5, 2, 14, 11, 8,
+		3, 10, 14, 4, 9, 15, 8, 1, 2, 7, 0, 6, 13, 11, 5, 12,
+		1, 9, 11, 10, 0, 8, 12, 4, 13, 3, 7, 15, 14, 5, 6, 2,
+		4, 0, 5, 9, 7, 12, 2, 10, 14, 1, 3, 8, 11, 6, 15, 13,
+	}
+	ripemdR2 = [80]uint8{
+		5, 14, 7, 0, 9, 2, 11, 4, 13, 6, 15, 8, 1, 10, 3, 12,
+		6, 11, 3, 7, 0, 13, 5, 10, 14, 15, 8, 12, 4, 9, 1, 2,
+		15, 5, 1, 3, 7, 14, 6, 9, 11, 8, 12, 2, 10, 0, 4, 13,
+		8, 6, 4, 1, 3, 11, 15, 0, 5, 12, 2, 13, 9, 7, 10, 14,
+		12, 15, 10, 4, 1, 5, 8, 7, 6, 2, 13, 14, 0, 3, 9, 11,
+	}
+	ripemdS1 = [80]uint8{
+		11, 14, 15, 12, 5, 8, 7, 9, 11, 13, 14, 15, 6, 7, 9, 8,
+		7, 6, 8, 13, 11, 9, 7, 15, 7, 12, 15, 9, 11, 7, 13, 12,
+		11, 13, 6, 7, 14, 9, 13, 15, 14, 8, 13, 6, 5, 12, 7, 5,
+		11, 12, 14, 15, 14, 15, 9, 8, 9, 14, 5, 6, 8, 6, 5, 12,
+		9, 15, 5, 11, 6, 8, 13, 12, 5, 12, 13, 14, 11, 8, 5, 6,
+	}
+	ripemdS2 = [80]uint8{
+		8, 9, 9, 11, 13, 15, 15, 5, 7, 7, 8, 11, 14, 14, 12, 6,
+		9, 13, 15, 7, 12, 8, 9, 11, 7, 7, 12, 7, 6, 15, 13, 11,
+		9, 7, 15, 11, 8, 6, 6, 14, 12, 13, 5, 14, 13, 13, 7, 5,
+		15, 5, 8, 11, 14, 14, 6, 14, 6, 9, 12, 9, 12, 5, 15, 8,
+		8, 5, 12, 9, 12, 5, 14, 6, 8, 13, 6, 5, 15, 13, 11, 11,
+	}
+	ripemdK1 = [5]uint32{0x00000000, 0x5a827999, 0x6ed9eba1, 0x8f1bbcdc, 0xa953fd4e}
+	ripemdK2 = [5]uint32{0x50a28be6, 0x5c4dd124, 0x6d703ef3, 0x7a6d76e9, 0x00000000}
+)
+
+// ripemdF is the round function of round j/16
+func ripemdF(j int, x, y, z uint32) uint32 {
+	switch j / 16 {
+	case 0:
+		return x ^ y ^ z
+	case 1:
+		return (x & y) | (^x & z)
+	case 2:
+		return (x | ^y) ^ z
+	case 3:
+		return (x & z) | (y & ^z)
+	}
+	return x ^ (y | ^z)
+}
+
+func ripemd160(data []byte) [20]byte {
+	h := [5]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476, 0xc3d2e1f0}
+	msg := append([]byte(nil), data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	var x [16]uint32
+	for block := msg; len(block) > 0; block = block[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		a1, b1, c1, d1, e1 := h[0], h[1], h[2], h[3], h[4]
+		a2, b2, c2, d2, e2 := a1, b1, c1, d1, e1
+		for j := range 80 {
+			t := bits.RotateLeft32(a1+ripemdF(j, b1, c1, d1)+x[ripemdR1[j]]+ripemdK1[j/16], int(ripemdS1[j])) + e1
+			a1, e1, d1, c1, b1 = e1, d1, bits.RotateLeft32(c1, 10), b1, t
+			t = bits.RotateLeft32(a2+ripemdF(79-j, b2, c2, d2)+x[ripemdR2[j]]+ripemdK2[j/16], int(ripemdS2[j])) + e2
+			a2, e2, d2, c2, b2 = e2, d2, bits.RotateLeft32(c2, 10), b2, t
+		}
+		t := h[1] + c1 + d2
+		h[1] = h[2] + d1 + e2
+		h[2] = h[3] + e1 + a2
+		h[3] = h[4] + a1 + b2
+		h[4] = h[0] + b1 + c2
+		h[0] = t
+	}
+	var out [20]byte
+	for i, v := range h {
+		binary.LittleEndian.PutUint32(out[4*i:], v)
+	}
+	return out
+}
+
+// ripemd160Precompile returns the RIPEMD-160 hash of its input, left-padded
+// to a word
+func ripemd160Precompile(in []byte) ([]byte, error) {
+	h := ripemd160(in)
+	return append(make([]byte, 12), h[:]...), nil
+}
+
+// BLAKE2b compression, for the precompile at address 9 (EIP-152)
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]uint8{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2bF is the BLAKE2b compression function F over rounds rounds
+func blake2bF(h *[8]uint64, m *[16]uint64, t [2]uint64, final bool, rounds uint32) {
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= t[0]
+	v[13] ^= t[1]
+	if final {
+		v[14] = ^v[14]
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for i := range rounds {
+		s := &blake2bSigma[i%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+const blake2fInputLength = 213
+
+// blake2fGas charges a gas per round
+func blake2fGas(in []byte) uint64 {
+	if len(in) != blake2fInputLength {
+		return 0
+	}
+	return uint64(binary.BigEndian.Uint32(in))
+}
+
+// blake2fPrecompile runs F on a rounds, h, m, t, f input
+func blake2fPrecompile(in []byte) ([]byte, error) {
+	if len(in) != blake2fInputLength {
+		return nil, errors.New("blake2f: invalid input length")
+	}
+	if in[212] > 1 {
+		return nil, errors.New("blake2f: invalid final block flag")
+	}
+	var h [8]uint64
+	var m [16]uint64
+	for i := range h {
+		h[i] = binary.LittleEndian.Uint64(in[4+8*i:])
+	}
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(in[68+8*i:])
+	}
+	t := [2]uint64{binary.LittleEndian.Uint64(in[196:]), binary.LittleEndian.Uint64(in[204:])}
+	blake2bF(&h, &m, t, in[212] == 1, binary.BigEndian.Uint32(in))
+	out := make([]byte, 64)
+	for i, v := range h {
+		binary.LittleEndian.PutUint64(out[8*i:], v)
+	}
+	return out, nil
+}
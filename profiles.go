@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PoLContracts are the Proof-of-Liquidity contract addresses of a chain
+type PoLContracts struct {
+	BGT                   string `json:"bgt"`
+	BeraChef              string `json:"berachef"`
+	BlockRewardController string `json:"block_reward_controller"`
+	Distributor           string `json:"distributor"`
+	RewardVaultFactory    string `json:"reward_vault_factory"`
+}
+
+// Forks records which protocol upgrades are active on a chain
+type Forks struct {
+	London   bool `json:"london"`   // EIP-1559 base fee
+	Shanghai bool `json:"shanghai"` // withdrawals
+	Cancun   bool `json:"cancun"`   // blob gas, parent beacon root
+	Prague   bool `json:"prague"`   // execution requests
+}
+
+// ChainProfile bundles everything that differs between networks
+type ChainProfile struct {
+	Name      string
+	ChainID   uint64 // 0 accepts any chain
+	Endpoints []string
+	GasLimit  int64 // 0 follows the live chain gas limit
+	BlockTime time.Duration
+	PoL       PoLContracts
+	WBERA     string // wrapped native token, if known
+	Forks     Forks
+}
+
+// Berachain PoL contracts are deployed at the same addresses on mainnet
+// and Bepolia: https://docs.berachain.com/developers/deployed-contracts
+var berachainPoL = PoLContracts{
+	BGT:                   "0x656b95E550C07a9ffe548bd4085c72418Ceb1dba",
+	BeraChef:              "0xdf960E8F3F19C481dDE769edEDD439ea1a63426a",
+	BlockRewardController: "0x1AE7dD7AE06F6C58B4524d9c1f816094B1bcCD8e",
+	Distributor:           "0xD2f19a79b026Fb636A7c300bF5947df113940761",
+	RewardVaultFactory:    "0x94Ad6Ac84f6C6FbA8b8CCbD71d9f4f101def52a8",
+}
+
+var berachainForks = Forks{London: true, Shanghai: true, Cancun: true, Prague: true}
+
+// ChainProfiles are the built-in networks, selected by the chain config key
+var ChainProfiles = map[string]*ChainProfile{
+	"mainnet": {
+		Name:      "mainnet",
+		ChainID:   80094,
+		Endpoints: []string{DefaultRPCEndpoint},
+		BlockTime: 2 * time.Second,
+		PoL:       berachainPoL,
+		WBERA:     "0x6969696969696969696969696969696969696969",
+		Forks:     berachainForks,
+	},
+	"bepolia": {
+		Name:      "bepolia",
+		ChainID:   80069,
+		Endpoints: []string{"https://bepolia.rpc.berachain.com"},
+		BlockTime: 2 * time.Second,
+		PoL:       berachainPoL,
+		WBERA:     "0x6969696969696969696969696969696969696969",
+		Forks:     berachainForks,
+	},
+	"bartio": {
+		Name:      "bartio",
+		ChainID:   80084,
+		Endpoints: []string{"https://bartio.rpc.berachain.com"},
+		BlockTime: 2 * time.Second,
+		Forks:     Forks{London: true, Shanghai: true, Cancun: true},
+	},
+	"evm": {
+		Name:      "evm",
+		Endpoints: []string{"http://localhost:8545"},
+		BlockTime: 12 * time.Second,
+		Forks:     Forks{London: true, Shanghai: true, Cancun: true},
+	},
+}
+
+// ProfileNames returns the built-in profile names, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(ChainProfiles))
+	for name := range ChainProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProfile returns the named chain profile
+func LookupProfile(name string) (*ChainProfile, error) {
+	p, ok := ChainProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain %q (want one of %v)", name, ProfileNames())
+	}
+	return p, nil
+}
+
+// VerifyChainID checks that the endpoint serves the chain the config expects
+func VerifyChainID(ctx context.Context, rpc *RPCClient, want uint64) error {
+	if want == 0 {
+		return nil
+	}
+	var hex string
+	if err := rpc.Call(ctx, &hex, "eth_chainId"); err != nil {
+		return fmt.Errorf("querying chain ID: %w", err)
+	}
+	got, err := ParseHexUint64(hex)
+	if err != nil {
+		return fmt.Errorf("querying chain ID: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("endpoint %s serves chain ID %d, config expects %d", rpc.Endpoint, got, want)
+	}
+	return nil
+}
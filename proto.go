@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Minimal protobuf wire encoding for the messages of builder.proto. Fields
+// are appended with the protoAppend* helpers, which follow proto3 and skip
+// zero values; protoFields walks an encoded message field by field.
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoLen     = 2
+	protoFixed32 = 5
+)
+
+var errProtoTruncated = errors.New("protobuf: truncated message")
+
+func protoAppendTag(b []byte, num, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(wire))
+}
+
+// protoAppendUint appends a varint field; int64 fields pass their two's
+// complement, as protobuf's int64 does
+func protoAppendUint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(protoAppendTag(b, num, protoVarint), v)
+}
+
+func protoAppendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protoAppendLen(b, num, []byte(s))
+}
+
+// protoAppendLen appends a length-delimited field even if empty, as
+// repeated and message fields need
+func protoAppendLen(b []byte, num int, data []byte) []byte {
+	b = binary.AppendUvarint(protoAppendTag(b, num, protoLen), uint64(len(data)))
+	return append(b, data...)
+}
+
+// protoFields calls fn for every field of msg. v holds the value of varint
+// and fixed fields; data the payload of length-delimited ones.
+func protoFields(msg []byte, fn func(num, wire int, v uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		msg = msg[n:]
+		num, wire := int(tag>>3), int(tag&7)
+		if num == 0 {
+			return errors.New("protobuf: field number 0")
+		}
+		var v uint64
+		var data []byte
+		switch wire {
+		case protoVarint:
+			if v, n = binary.Uvarint(msg); n <= 0 {
+				return errProtoTruncated
+			}
+			msg = msg[n:]
+		case protoFixed64:
+			if len(msg) < 8 {
+				return errProtoTruncated
+			}
+			v, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case protoFixed32:
+			if len(msg) < 4 {
+				return errProtoTruncated
+			}
+			v, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		case protoLen:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return errProtoTruncated
+			}
+			data, msg = msg[n:n+int(size)], msg[n+int(size):]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", wire)
+		}
+		if err := fn(num, wire, v, data); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// protoWant rejects a field encoded with an unexpected wire type
+func protoWant(wire, want int) error {
+	if wire != want {
+		return fmt.Errorf("protobuf: wire type %d, want %d", wire, want)
+	}
+	return nil
+}
+
+// MarshalProto encodes tx as a builder.v1.Transaction
+func (tx *Transaction) MarshalProto() []byte { return tx.appendProto(nil) }
+
+func (tx *Transaction) appendProto(b []byte) []byte {
+	b = protoAppendString(b, 1, tx.Hash)
+	b = protoAppendString(b, 2, tx.From)
+	b = protoAppendString(b, 3, tx.To)
+	b = protoAppendUint(b, 4, uint64(tx.GasPrice))
+	b = protoAppendUint(b, 5, uint64(tx.GasLimit))
+	b = protoAppendUint(b, 6, uint64(tx.MEVBonus))
+	b = protoAppendUint(b, 7, uint64(tx.PoLBonus))
+	b = protoAppendUint(b, 8, uint64(tx.Nonce))
+	for _, h := range tx.ConflictsWith {
+		b = protoAppendLen(b, 9, []byte(h))
+	}
+	for _, h := range tx.Bundle {
+		b = protoAppendLen(b, 10, []byte(h))
+	}
+	if len(tx.Input) > 0 {
+		b = protoAppendLen(b, 11, tx.Input)
+	}
+	if len(tx.Raw) > 0 {
+		b = protoAppendLen(b, 12, tx.Raw)
+	}
+	if tx.RevertProtect {
+		b = protoAppendUint(b, 13, 1)
+	}
+	if tx.Type != 0 {
+		b = protoAppendUint(b, 14, uint64(tx.Type))
+	}
+	if tx.GasTipCap != 0 {
+		b = protoAppendUint(b, 15, uint64(tx.GasTipCap))
+	}
+	if tx.ChainID != 0 {
+		b = protoAppendUint(b, 17, tx.ChainID)
+	}
+	if tx.Value != nil && tx.Value.Sign() > 0 {
+		b = protoAppendLen(b, 18, tx.Value.Bytes())
+	}
+	for _, h := range tx.MustFollow {
+		b = protoAppendLen(b, 19, []byte(h))
+	}
+	for _, t := range tx.AccessList {
+		entry := protoAppendString(nil, 1, t.Address)
+		for _, key := range t.StorageKeys {
+			entry = protoAppendLen(entry, 2, []byte(key))
+		}
+		b = protoAppendLen(b, 16, entry)
+	}
+	return b
+}
+
+// UnmarshalProto decodes a builder.v1.Transaction into tx
+func (tx *Transaction) UnmarshalProto(b []byte) error {
+	*tx = Transaction{ConflictsWith: []string{}}
+	return protoFields(b, func(num, wire int, v uint64, data []byte) error {
+		switch num {
+		case 1, 2, 3, 9, 10, 11, 12, 16, 18, 19:
+			if err := protoWant(wire, protoLen); err != nil {
+				return err
+			}
+		case 4, 5, 6, 7, 8, 13, 14, 15, 17:
+			if err := protoWant(wire, protoVarint); err != nil {
+				return err
+			}
+		}
+		switch num {
+		case 1:
+			tx.Hash = string(data)
+		case 2:
+			tx.From = string(data)
+		case 3:
+			tx.To = string(data)
+		case 4:
+			tx.GasPrice = int64(v)
+		case 5:
+			tx.GasLimit = int64(v)
+		case 6:
+			tx.MEVBonus = int64(v)
+		case 7:
+			tx.PoLBonus = int64(v)
+		case 8:
+			tx.Nonce = int(v)
+		case 9:
+			tx.ConflictsWith = append(tx.ConflictsWith, string(data))
+		case 10:
+			tx.Bundle = append(tx.Bundle, string(data))
+		case 11:
+			tx.Input = append(HexBytes(nil), data...)
+		case 12:
+			tx.Raw = append(HexBytes(nil), data...)
+		case 13:
+			tx.RevertProtect = v != 0
+		case 14:
+			tx.Type = uint8(v)
+		case 15:
+			tx.GasTipCap = int64(v)
+		case 17:
+			tx.ChainID = v
+		case 18:
+			tx.Value = new(big.Int).SetBytes(data)
+		case 19:
+			tx.MustFollow = append(tx.MustFollow, string(data))
+		case 16:
+			t := AccessTuple{StorageKeys: []string{}}
+			err := protoFields(data, func(num, wire int, _ uint64, data []byte) error {
+				if num != 1 && num != 2 {
+					return nil
+				}
+				if err := protoWant(wire, protoLen); err != nil {
+					return err
+				}
+				if num == 1 {
+					t.Address = string(data)
+				} else {
+					t.StorageKeys = append(t.StorageKeys, string(data))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			tx.AccessList = append(tx.AccessList, t)
+		}
+		return nil
+	})
+}
+
+// MarshalTransactionList encodes txs as a builder.v1.TransactionList. Being
+// a single repeated field, two encoded lists concatenate into one.
+func MarshalTransactionList(txs []*Transaction) []byte {
+	var b []byte
+	for _, tx := range txs {
+		b = protoAppendLen(b, 1, tx.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalTransactionList decodes a builder.v1.TransactionList
+func UnmarshalTransactionList(b []byte) ([]*Transaction, error) {
+	txs := []*Transaction{}
+	err := protoFields(b, func(num, wire int, v uint64, data []byte) error {
+		if num != 1 {
+			return nil
+		}
+		if err := protoWant(wire, protoLen); err != nil {
+			return err
+		}
+		tx := &Transaction{}
+		if err := tx.UnmarshalProto(data); err != nil {
+			return fmt.Errorf("transaction %d: %w", len(txs), err)
+		}
+		txs = append(txs, tx)
+		return nil
+	})
+	return txs, err
+}
+
+// MarshalProto encodes the bundle as a builder.v1.Bundle
+func (bundle *Bundle) MarshalProto() []byte {
+	b := protoAppendString(nil, 1, bundle.ID)
+	b = protoAppendString(b, 2, bundle.Searcher)
+	for _, item := range bundle.Body {
+		var ib []byte
+		ib = protoAppendString(ib, 1, item.Hash)
+		if item.Tx != nil {
+			ib = protoAppendLen(ib, 2, item.Tx.MarshalProto())
+		}
+		b = protoAppendLen(b, 3, ib)
+	}
+	for _, h := range bundle.RevertingTxHashes {
+		b = protoAppendLen(b, 4, []byte(h))
+	}
+	return protoAppendString(b, 5, bundle.ReplacementUUID)
+}
+
+// UnmarshalProto decodes a builder.v1.Bundle into bundle
+func (bundle *Bundle) UnmarshalProto(b []byte) error {
+	*bundle = Bundle{}
+	return protoFields(b, func(num, wire int, v uint64, data []byte) error {
+		if num > 5 {
+			return nil
+		}
+		if err := protoWant(wire, protoLen); err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			bundle.ID = string(data)
+		case 2:
+			bundle.Searcher = string(data)
+		case 3:
+			var item BundleItem
+			err := protoFields(data, func(num, wire int, v uint64, data []byte) error {
+				if num > 2 {
+					return nil
+				}
+				if err := protoWant(wire, protoLen); err != nil {
+					return err
+				}
+				if num == 1 {
+					item.Hash = string(data)
+					return nil
+				}
+				item.Tx = &Transaction{}
+				return item.Tx.UnmarshalProto(data)
+			})
+			if err != nil {
+				return err
+			}
+			bundle.Body = append(bundle.Body, item)
+		case 4:
+			bundle.RevertingTxHashes = append(bundle.RevertingTxHashes, string(data))
+		case 5:
+			bundle.ReplacementUUID = string(data)
+		}
+		return nil
+	})
+}
+
+// MarshalProto encodes c as a builder.v1.CancelBundleRequest
+func (c *BundleCancellation) MarshalProto() []byte {
+	b := protoAppendString(nil, 1, c.Searcher)
+	return protoAppendString(b, 2, c.ReplacementUUID)
+}
+
+// UnmarshalProto decodes a builder.v1.CancelBundleRequest into c
+func (c *BundleCancellation) UnmarshalProto(b []byte) error {
+	*c = BundleCancellation{}
+	return protoFields(b, func(num, wire int, v uint64, data []byte) error {
+		if num > 2 {
+			return nil
+		}
+		if err := protoWant(wire, protoLen); err != nil {
+			return err
+		}
+		if num == 1 {
+			c.Searcher = string(data)
+		} else {
+			c.ReplacementUUID = string(data)
+		}
+		return nil
+	})
+}
+
+// MarshalProto encodes c as a builder.v1.BlockCandidate
+func (c *BlockCandidate) MarshalProto() []byte {
+	b := protoAppendUint(nil, 1, c.Head)
+	b = protoAppendString(b, 2, c.Strategy)
+	b = protoAppendUint(b, 3, uint64(c.Value))
+	b = protoAppendUint(b, 4, uint64(c.TxCount))
+	b = protoAppendUint(b, 5, uint64(c.GasUsed))
+	b = protoAppendUint(b, 6, uint64(c.GasLimit))
+	return protoAppendUint(b, 7, uint64(c.BuiltAt.UnixNano()))
+}
+
+// UnmarshalProto decodes a builder.v1.BlockCandidate into c
+func (c *BlockCandidate) UnmarshalProto(b []byte) error {
+	*c = BlockCandidate{}
+	return protoFields(b, func(num, wire int, v uint64, data []byte) error {
+		switch num {
+		case 2:
+			if err := protoWant(wire, protoLen); err != nil {
+				return err
+			}
+			c.Strategy = string(data)
+			return nil
+		case 1, 3, 4, 5, 6, 7:
+			if err := protoWant(wire, protoVarint); err != nil {
+				return err
+			}
+		}
+		switch num {
+		case 1:
+			c.Head = v
+		case 3:
+			c.Value = int64(v)
+		case 4:
+			c.TxCount = int(v)
+		case 5:
+			c.GasUsed = int64(v)
+		case 6:
+			c.GasLimit = int64(v)
+		case 7:
+			c.BuiltAt = time.Unix(0, int64(v)).UTC()
+		}
+		return nil
+	})
+}
+
+// MarshalProto encodes s as a builder.v1.PoolStats; rejections are a
+// map<string, int64>, encoded as repeated key/value entries
+func (s *PoolStats) MarshalProto() []byte {
+	b := protoAppendUint(nil, 1, uint64(s.Pooled))
+	b = protoAppendUint(b, 2, uint64(s.Executable))
+	b = protoAppendUint(b, 3, uint64(s.Queued))
+	b = protoAppendUint(b, 4, uint64(s.Low))
+	b = protoAppendUint(b, 5, uint64(s.Private))
+	b = protoAppendUint(b, 6, uint64(s.Required))
+	for reason, n := range s.Rejections {
+		entry := protoAppendString(nil, 1, string(reason))
+		entry = protoAppendUint(entry, 2, uint64(n))
+		b = protoAppendLen(b, 7, entry)
+	}
+	return b
+}
@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+// DefaultQuarantineSize bounds how many malformed transactions are retained
+const DefaultQuarantineSize = 1000
+
+// QuarantineReason is a stable code describing why a transaction was rejected
+// as malformed
+type QuarantineReason string
+
+const (
+	ReasonMissingHash QuarantineReason = "missing_hash"
+	ReasonBadGasPrice QuarantineReason = "bad_gas_price"
+	ReasonBadGasLimit QuarantineReason = "bad_gas_limit"
+	ReasonBadNonce    QuarantineReason = "bad_nonce"
+	ReasonBadInput    QuarantineReason = "bad_input"
+	ReasonBadType     QuarantineReason = "bad_type"
+	ReasonBadChainID  QuarantineReason = "bad_chain_id"
+	ReasonBadValue    QuarantineReason = "bad_value"
+)
+
+// QuarantinedTx records a transaction that could not be decoded into the pool
+type QuarantinedTx struct {
+	Hash   string           `json:"hash"`
+	Reason QuarantineReason `json:"reason"`
+	Detail string           `json:"detail"`
+	At     time.Time        `json:"at"`
+}
+
+// Quarantine is a bounded list of malformed transactions kept for inspection
+// instead of being admitted with corrupted fields
+type Quarantine struct {
+	Max     int
+	Entries []QuarantinedTx
+}
+
+func NewQuarantine(max int) *Quarantine {
+	return &Quarantine{Max: max}
+}
+
+// Add records a malformed transaction, evicting the oldest entry when full
+func (q *Quarantine) Add(hash string, reason QuarantineReason, err error) {
+	entry := QuarantinedTx{Hash: hash, Reason: reason, At: time.Now()}
+	if err != nil {
+		entry.Detail = err.Error()
+	}
+	if q.Max > 0 && len(q.Entries) >= q.Max {
+		q.Entries = append(q.Entries[:0], q.Entries[1:]...)
+	}
+	q.Entries = append(q.Entries, entry)
+}
+
+// Counts returns the number of quarantined transactions per reason
+func (q *Quarantine) Counts() map[QuarantineReason]int {
+	counts := make(map[QuarantineReason]int)
+	for _, e := range q.Entries {
+		counts[e.Reason]++
+	}
+	return counts
+}
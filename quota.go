@@ -0,0 +1,103 @@
+package main
+
+import "strings"
+
+// GasQuotas caps the gas one sender, or the calls to one contract, may
+// take in a block, keeping any single party from crowding out the rest.
+// Packers skip a transaction that would take its sender or contract past
+// its cap and carry on filling the block with others; required
+// transactions are included regardless but count toward the caps.
+type GasQuotas struct {
+	Config    QuotaConfig
+	contracts map[string]ContractQuota // by lowercase address
+}
+
+// NewGasQuotas compiles cfg, returning nil if it sets no caps
+func NewGasQuotas(cfg QuotaConfig) *GasQuotas {
+	if cfg.SenderShare <= 0 && len(cfg.Contracts) == 0 {
+		return nil
+	}
+	q := &GasQuotas{Config: cfg, contracts: make(map[string]ContractQuota, len(cfg.Contracts))}
+	for _, c := range cfg.Contracts {
+		q.contracts[strings.ToLower(c.Address)] = c
+	}
+	return q
+}
+
+// senderCap returns the most gas one sender may take out of gasLimit, or
+// -1 if senders are uncapped
+func (q *GasQuotas) senderCap(gasLimit int64) int64 {
+	if q.Config.SenderShare <= 0 {
+		return -1
+	}
+	return int64(q.Config.SenderShare * float64(gasLimit))
+}
+
+// contractCap returns the most gas calls to contract may take out of
+// gasLimit, or -1 if it is uncapped: the lower of its absolute and
+// relative caps where both are set
+func (q *GasQuotas) contractCap(contract string, gasLimit int64) int64 {
+	c, ok := q.contracts[contract]
+	if !ok {
+		return -1
+	}
+	limit := int64(-1)
+	if c.MaxGas > 0 {
+		limit = c.MaxGas
+	}
+	if c.Share > 0 {
+		if share := int64(c.Share * float64(gasLimit)); limit < 0 || share < limit {
+			limit = share
+		}
+	}
+	return limit
+}
+
+// quotaIndex groups the transactions a build can pick from by what their
+// gas counts against
+type quotaIndex struct {
+	q        *GasQuotas
+	bySender map[string][]*Transaction
+	byTo     map[string][]*Transaction // capped contracts only
+}
+
+func newQuotaIndex(q *GasQuotas, txs []*Transaction) *quotaIndex {
+	x := &quotaIndex{q: q, bySender: map[string][]*Transaction{}, byTo: map[string][]*Transaction{}}
+	for _, tx := range txs {
+		if tx.From != "" && q.Config.SenderShare > 0 {
+			x.bySender[tx.From] = append(x.bySender[tx.From], tx)
+		}
+		if _, capped := q.contracts[tx.To]; capped {
+			x.byTo[tx.To] = append(x.byTo[tx.To], tx)
+		}
+	}
+	return x
+}
+
+// over reports whether adding tx to the transactions in takes its sender
+// or contract past its cap
+func (x *quotaIndex) over(tx *Transaction, in func(hash string) bool, gasLimit int64) bool {
+	taken := func(txs []*Transaction) int64 {
+		gas := tx.PackGas()
+		for _, other := range txs {
+			if other.Hash != tx.Hash && in(other.Hash) {
+				gas += other.PackGas()
+			}
+		}
+		return gas
+	}
+	if limit := x.q.senderCap(gasLimit); limit >= 0 && tx.From != "" && taken(x.bySender[tx.From]) > limit {
+		return true
+	}
+	if limit := x.q.contractCap(tx.To, gasLimit); limit >= 0 && taken(x.byTo[tx.To]) > limit {
+		return true
+	}
+	return false
+}
+
+// OverQuota reports whether adding tx to the transactions in used would
+// take its sender or contract past its gas quota out of gasLimit. It is
+// always false without quotas.
+func (g *ConflictGraph) OverQuota(tx *Transaction, used map[string]bool, gasLimit int64) bool {
+	return g.quotas != nil && g.quotas.over(tx, func(hash string) bool { return used[hash] }, gasLimit)
+}
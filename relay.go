@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// registrationRefresh is how soon a relay is asked again for proposer
+// duties after a lookup missed
+const registrationRefresh = 30 * time.Second
+
+// ValidatorRegistration is the builder-specs registration a proposer signs
+// to tell builders how to build its blocks
+type ValidatorRegistration struct {
+	FeeRecipient Address   `json:"fee_recipient"`
+	GasLimit     uint64    `json:"gas_limit,string"` // preferred gas limit
+	Timestamp    uint64    `json:"timestamp,string"`
+	Pubkey       BLSPubkey `json:"pubkey"`
+}
+
+// ProposerDuty is a registered proposer scheduled for a slot. BeaconKit
+// numbers slots by block height, so the block built on head N fills slot
+// N+1.
+type ProposerDuty struct {
+	Slot           uint64 `json:"slot,string"`
+	ValidatorIndex uint64 `json:"validator_index,string"`
+	Entry          struct {
+		Message   ValidatorRegistration `json:"message"`
+		Signature BLSSignature          `json:"signature"`
+	} `json:"entry"`
+}
+
+// RelayClient talks to an MEV-Boost relay's builder API
+type RelayClient struct {
+	URL  string
+	HTTP *http.Client
+}
+
+func NewRelayClient(url string) *RelayClient {
+	return &RelayClient{URL: strings.TrimSuffix(url, "/"), HTTP: newHTTPClient(10 * time.Second)}
+}
+
+// Validators returns the registered proposers of the current and next
+// epoch
+func (c *RelayClient) Validators(ctx context.Context) ([]ProposerDuty, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"/relay/v1/builder/validators", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching proposer duties: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode}
+	}
+	var duties []ProposerDuty
+	if err := json.NewDecoder(resp.Body).Decode(&duties); err != nil {
+		return nil, fmt.Errorf("decoding proposer duties: %w", err)
+	}
+	return duties, nil
+}
+
+// RegistrationBook caches proposer duties by slot, refreshing them from a
+// relay when a slot is missing. Its methods are safe for concurrent use.
+type RegistrationBook struct {
+	Relay *RelayClient
+
+	mu      sync.Mutex
+	duties  map[uint64]*ProposerDuty
+	fetched time.Time
+}
+
+func NewRegistrationBook(relay *RelayClient) *RegistrationBook {
+	return &RegistrationBook{Relay: relay, duties: make(map[uint64]*ProposerDuty)}
+}
+
+// ForSlot returns the registration of slot's proposer, or nil if it hasn't
+// registered. Duties are refetched on a miss, at most every
+// registrationRefresh, and those of earlier slots dropped.
+func (b *RegistrationBook) ForSlot(ctx context.Context, slot uint64) (*ValidatorRegistration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d := b.duties[slot]; d != nil || time.Since(b.fetched) < registrationRefresh {
+		return registration(d), nil
+	}
+	b.fetched = time.Now()
+	duties, err := b.Relay.Validators(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for slot := range b.duties {
+		delete(b.duties, slot)
+	}
+	for i := range duties {
+		if d := &duties[i]; d.Slot >= slot {
+			b.duties[d.Slot] = d
+		}
+	}
+	return registration(b.duties[slot]), nil
+}
+
+func registration(d *ProposerDuty) *ValidatorRegistration {
+	if d == nil {
+		return nil
+	}
+	return &d.Entry.Message
+}
+
+// TargetGasLimit returns the gas limit of a child of a block with
+// parentLimit that moves toward the proposer's preferred limit as far as
+// the protocol allows: by less than parentLimit/1024 per block.
+func TargetGasLimit(parentLimit, preferred int64) int64 {
+	step := parentLimit/1024 - 1
+	switch {
+	case preferred > parentLimit:
+		return min(preferred, parentLimit+step)
+	case preferred < parentLimit:
+		return max(preferred, parentLimit-step, 5000)
+	}
+	return parentLimit
+}
+
+// domainApplicationBuilder is the signature domain type of builder bids
+var domainApplicationBuilder = [4]byte{0, 0, 0, 1}
+
+// BuilderSigningRoot returns the root a builder signs for msg: the bid's
+// hash tree root in the application builder domain, which is computed from
+// the genesis fork version and a zero genesis validators root
+func BuilderSigningRoot(msg *BidTrace, genesisForkVersion [4]byte) Hash {
+	var version Hash
+	copy(version[:], genesisForkVersion[:])
+	forkDataRoot := merkleize([]Hash{version, {}})
+	var domain Hash
+	copy(domain[:4], domainApplicationBuilder[:])
+	copy(domain[4:], forkDataRoot[:28])
+	return merkleize([]Hash{msg.HashTreeRoot(), domain})
+}
+
+// BidSigner returns the BLS signature of a signing root
+type BidSigner func(ctx context.Context, root Hash) (BLSSignature, error)
+
+// CommandSigner signs by running command with the 0x-prefixed root as an
+// extra argument and reading the 0x-prefixed signature it prints. BLS
+// signing is left to external tooling holding the builder key.
+func CommandSigner(command string) BidSigner {
+	args := strings.Fields(command)
+	return func(ctx context.Context, root Hash) (BLSSignature, error) {
+		var sig BLSSignature
+		out, err := exec.CommandContext(ctx, args[0], append(args[1:], root.Hex())...).Output()
+		if err != nil {
+			return sig, fmt.Errorf("running bid signer: %w", err)
+		}
+		if err := sig.UnmarshalText(bytes.TrimSpace(out)); err != nil {
+			return sig, fmt.Errorf("bid signer output: %w", err)
+		}
+		return sig, nil
+	}
+}
+
+// SubmitBlock posts a sealed bid to the relay, SSZ-encoded. With
+// cancellations, the relay lets it replace an earlier, higher bid of this
+// builder for the slot.
+func (c *RelayClient) SubmitBlock(ctx context.Context, req *SubmitBlockRequest, cancellations bool) error {
+	body, err := req.MarshalSSZ()
+	if err != nil {
+		return err
+	}
+	u := c.URL + "/relay/v1/builder/blocks"
+	if cancellations {
+		u += "?cancellations=1"
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.HTTP.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(msg))}
+	}
+	return nil
+}
+
+// RelayTarget is one relay bids are submitted to, with its policy and
+// running metrics
+type RelayTarget struct {
+	Client        *RelayClient
+	Timeout       time.Duration
+	Optimistic    bool
+	Cancellations bool
+
+	mu    sync.Mutex
+	stats RelayStats
+}
+
+// RelayStats counts a relay's submissions
+type RelayStats struct {
+	URL       string        `json:"url"`
+	Submitted int           `json:"submitted"`
+	Accepted  int           `json:"accepted"`
+	Failed    int           `json:"failed"`
+	TimedOut  int           `json:"timedOut"`
+	Cancelled int           `json:"cancelled"` // superseded by a newer head before the relay answered
+	Latency   time.Duration `json:"latencyNs"` // summed over accepted submissions
+	LastError string        `json:"lastError,omitempty"`
+}
+
+// MeanLatency returns the mean latency of accepted submissions
+func (s RelayStats) MeanLatency() time.Duration {
+	if s.Accepted == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Accepted)
+}
+
+// RelayResult is the outcome of one submission
+type RelayResult struct {
+	URL     string
+	Latency time.Duration
+	Err     error
+	Pending bool // optimistic: sent without waiting for the verdict
+}
+
+// Submitter seals built blocks into signed bids and submits them to every
+// configured relay concurrently. Its methods are safe for concurrent use.
+type Submitter struct {
+	Relays      []*RelayTarget
+	Builder     BLSPubkey
+	ForkVersion [4]byte
+	Sign        BidSigner
+}
+
+// NewSubmitter returns a submitter configured from cfg, or nil if no relay
+// is to be submitted to
+func NewSubmitter(cfg RelayConfig) (*Submitter, error) {
+	if len(cfg.Submit) == 0 {
+		return nil, nil
+	}
+	s := &Submitter{Sign: CommandSigner(cfg.Signer)}
+	if err := s.Builder.UnmarshalText([]byte(cfg.BuilderPubkey)); err != nil {
+		return nil, fmt.Errorf("relay.builder_pubkey: %w", err)
+	}
+	if err := decodeFixedHex(cfg.GenesisForkVersion, s.ForkVersion[:]); err != nil {
+		return nil, fmt.Errorf("relay.genesis_fork_version: %w", err)
+	}
+	for _, target := range cfg.Submit {
+		s.Relays = append(s.Relays, &RelayTarget{
+			Client:        NewRelayClient(target.URL),
+			Timeout:       time.Duration(target.Timeout),
+			Optimistic:    target.Optimistic,
+			Cancellations: target.Cancellations,
+			stats:         RelayStats{URL: target.URL},
+		})
+	}
+	return s, nil
+}
+
+// Seal signs the bid for t, an assembled block for slot proposed by
+// proposer
+func (s *Submitter) Seal(ctx context.Context, t *BlockTemplate, slot uint64, proposer BLSPubkey) (*SubmitBlockRequest, error) {
+	msg := NewBidTrace(t, slot, s.Builder, proposer)
+	sig, err := s.Sign(ctx, BuilderSigningRoot(msg, s.ForkVersion))
+	if err != nil {
+		return nil, err
+	}
+	return &SubmitBlockRequest{Message: msg, ExecutionPayload: t.Payload, BlobsBundle: &BlobsBundle{}, Signature: sig}, nil
+}
+
+// Submit sends req to every relay at once, each under its own timeout,
+// and returns once the non-optimistic ones have answered. Optimistic
+// relays are reported pending and finish in the background. Cancelling
+// ctx, as a new head does, abandons submissions still in flight.
+func (s *Submitter) Submit(ctx context.Context, req *SubmitBlockRequest) []RelayResult {
+	results := make([]RelayResult, len(s.Relays))
+	var wg sync.WaitGroup
+	for i, r := range s.Relays {
+		if r.Optimistic {
+			results[i] = RelayResult{URL: r.Client.URL, Pending: true}
+			go r.submit(ctx, req)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.submit(ctx, req)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (r *RelayTarget) submit(ctx context.Context, req *SubmitBlockRequest) RelayResult {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := r.Client.SubmitBlock(ctx, req, r.Cancellations)
+	res := RelayResult{URL: r.Client.URL, Latency: time.Since(start), Err: err}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Submitted++
+	switch {
+	case err == nil:
+		r.stats.Accepted++
+		r.stats.Latency += res.Latency
+		return res
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.stats.TimedOut++
+	case ctx.Err() != nil:
+		r.stats.Cancelled++
+	default:
+		r.stats.Failed++
+	}
+	r.stats.LastError = err.Error()
+	return res
+}
+
+// Stats returns the metrics of every relay
+func (s *Submitter) Stats() []RelayStats {
+	out := make([]RelayStats, len(s.Relays))
+	for i, r := range s.Relays {
+		r.mu.Lock()
+		out[i] = r.stats
+		r.mu.Unlock()
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// BuildReport is the machine-readable summary of one built block
+type BuildReport struct {
+	BuiltAt      time.Time    `json:"builtAt"`
+	Source       string       `json:"source"`
+	Strategy     string       `json:"strategy"`
+	GasLimit     int64        `json:"gasLimit"`
+	PoolSize     int          `json:"poolSize"`
+	Transactions []ReportTx   `json:"transactions"`
+	Totals       ReportTotals `json:"totals"`
+}
+
+// ReportTx is one included transaction, in inclusion order
+type ReportTx struct {
+	Index    int    `json:"index"`
+	Hash     string `json:"hash"`
+	Gas      int64  `json:"gas"` // counted as used; see PackGas
+	GasPrice int64  `json:"gasPrice"`
+	Burn     int64  `json:"burn"` // base fee on Gas, burned
+	Tip      int64  `json:"tip"`  // priority fee on Gas
+	MEVBonus int64  `json:"mevBonus"`
+	PoLBonus int64  `json:"polBonus"`
+	Profit   int64  `json:"profit"`
+}
+
+// ReportTotals sums the per-transaction columns
+type ReportTotals struct {
+	TxCount  int   `json:"txCount"`
+	Gas      int64 `json:"gas"`
+	Burn     int64 `json:"burn"`
+	Tip      int64 `json:"tip"`
+	MEVBonus int64 `json:"mevBonus"`
+	PoLBonus int64 `json:"polBonus"`
+	Profit   int64 `json:"profit"`
+}
+
+// NewBuildReport summarizes the selected transactions of a build
+func NewBuildReport(env *Env, pool *TxPool, gasLimit int64, selected []*Transaction) *BuildReport {
+	r := &BuildReport{
+		BuiltAt:      time.Now().UTC(),
+		Source:       env.Source,
+		Strategy:     env.Config.Builder.Strategy,
+		GasLimit:     gasLimit,
+		PoolSize:     pool.Stats().Pooled,
+		Transactions: make([]ReportTx, len(selected)),
+	}
+	for i, tx := range selected {
+		fees := tx.Fees()
+		rtx := ReportTx{
+			Index:    i,
+			Hash:     tx.Hash,
+			Gas:      tx.PackGas(),
+			GasPrice: tx.GasPrice,
+			Burn:     fees.Burn,
+			Tip:      fees.Tip,
+			MEVBonus: fees.MEVBonus,
+			PoLBonus: fees.PoLBonus,
+			Profit:   tx.Profit(),
+		}
+		r.Transactions[i] = rtx
+		r.Totals.TxCount++
+		r.Totals.Gas += rtx.Gas
+		r.Totals.Burn += rtx.Burn
+		r.Totals.Tip += rtx.Tip
+		r.Totals.MEVBonus += rtx.MEVBonus
+		r.Totals.PoLBonus += rtx.PoLBonus
+		r.Totals.Profit += rtx.Profit
+	}
+	return r
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *BuildReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per transaction followed by a totals row
+func (r *BuildReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"index", "hash", "gas", "gasPrice", "burn", "tip", "mevBonus", "polBonus", "profit"})
+	i64 := func(v int64) string { return strconv.FormatInt(v, 10) }
+	for _, tx := range r.Transactions {
+		cw.Write([]string{strconv.Itoa(tx.Index), tx.Hash, i64(tx.Gas), i64(tx.GasPrice),
+			i64(tx.Burn), i64(tx.Tip), i64(tx.MEVBonus), i64(tx.PoLBonus), i64(tx.Profit)})
+	}
+	t := r.Totals
+	cw.Write([]string{"total", strconv.Itoa(t.TxCount), i64(t.Gas), "", i64(t.Burn), i64(t.Tip), i64(t.MEVBonus), i64(t.PoLBonus), i64(t.Profit)})
+	cw.Flush()
+	return cw.Error()
+}
+
+// encode renders the report in format ("json" or "csv")
+func (r *BuildReport) encode(format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "csv":
+		err := r.WriteCSV(&buf)
+		return buf.Bytes(), "text/csv", err
+	case "json":
+		err := r.WriteJSON(&buf)
+		return buf.Bytes(), "application/json", err
+	}
+	return nil, "", fmt.Errorf("unknown report format %q", format)
+}
+
+// reportClient posts reports to the webhook
+var reportClient = newHTTPClient(10 * time.Second)
+
+// Export writes the report to cfg.Dir and/or POSTs it to cfg.Webhook
+func (r *BuildReport) Export(ctx context.Context, cfg ReportConfig) (err error) {
+	ctx, span := StartSpan(ctx, "report.export", "report.format", cfg.Format)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	data, contentType, err := r.encode(cfg.Format)
+	if err != nil {
+		return err
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(cfg.Dir, fmt.Sprintf("build-%d.%s", r.BuiltAt.UnixNano(), cfg.Format))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	if cfg.Webhook != "" {
+		req, err := http.NewRequestWithContext(ctx, "POST", cfg.Webhook, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		injectTraceparent(ctx, req)
+		resp, err := reportClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("posting report: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("posting report: %w", &HTTPError{StatusCode: resp.StatusCode})
+		}
+	}
+	return nil
+}
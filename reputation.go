@@ -0,0 +1,235 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// SearcherStats is what became of one searcher's bundles, and the
+// reputation it earned
+type SearcherStats struct {
+	Searcher  string  `json:"searcher"`
+	Submitted int     `json:"submitted"` // bundles accepted
+	Simulated int     `json:"simulated"` // payment simulations run
+	SimFailed int     `json:"simFailed"` // of those, the ones that failed
+	Reverted  int     `json:"reverted"`  // bundles revert protection dropped from a block
+	Built     int     `json:"built"`     // bundles in the last block built before a head
+	Landed    int     `json:"landed"`    // of those, the ones whose members were all mined by the head
+	Score     float64 `json:"score"`
+}
+
+// score rates the searcher in (0, 1]: the product of its simulation
+// success rate, the share of its packed bundles that didn't revert and the
+// share that landed, each counting one extra success, so a searcher
+// without a history scores 1 and every failure lowers it
+func (s *SearcherStats) score() float64 {
+	sim := float64(s.Simulated-s.SimFailed+1) / float64(s.Simulated+1)
+	clean := float64(s.Built+1) / float64(s.Built+s.Reverted+1)
+	landed := float64(s.Landed+1) / float64(s.Built+1)
+	return sim * clean * landed
+}
+
+// builtBundle is a bundle of the last block built, waiting for the next
+// head to tell whether it landed
+type builtBundle struct {
+	searcher string
+	members  []string
+}
+
+// searcher returns the stats of searcher, starting them if it is new.
+// Called with the book locked.
+func (b *HintBook) searcher(searcher string) *SearcherStats {
+	s := b.searchers[searcher]
+	if s == nil {
+		s = &SearcherStats{Searcher: searcher}
+		b.searchers[searcher] = s
+	}
+	return s
+}
+
+// reputation returns the score of searcher, 1 if it has no history. Called
+// with the book locked.
+func (b *HintBook) reputation(searcher string) float64 {
+	if s := b.searchers[searcher]; s != nil {
+		return s.score()
+	}
+	return 1
+}
+
+// sources returns the IDs of the bundles block entry id stands for: those
+// a merged entry combines, read from its ID once a later Matched has
+// forgotten it, or id itself. Called with the book locked.
+func (b *HintBook) sources(id string) []string {
+	if ids, ok := b.composites[id]; ok {
+		return ids
+	}
+	if ids, ok := strings.CutPrefix(id, "merged:"); ok {
+		return strings.Split(ids, "+")
+	}
+	return []string{id}
+}
+
+// SimulationFailed counts a failed payment simulation of bundle id against
+// its searcher
+func (b *HintBook) SimulationFailed(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if bundle := b.bundles[id]; bundle != nil {
+		s := b.searcher(bundle.Searcher)
+		s.Simulated++
+		s.SimFailed++
+	}
+}
+
+// Reverted counts the bundle entries among ids, which revert protection
+// dropped, against their searchers
+func (b *HintBook) Reverted(ids []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range ids {
+		for _, source := range b.sources(id) {
+			if bundle := b.bundles[source]; bundle != nil {
+				b.searcher(bundle.Searcher).Reverted++
+			}
+		}
+	}
+}
+
+// Built records the bundles of selected, the block just built, replacing
+// those of the block before; Landed settles them once the next head is
+// known
+func (b *HintBook) Built(selected []*Transaction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.built)
+	for _, tx := range selected {
+		if len(tx.Bundle) == 0 {
+			continue
+		}
+		for _, source := range b.sources(tx.Hash) {
+			bundle := b.bundles[source]
+			if bundle == nil {
+				continue
+			}
+			built := builtBundle{searcher: bundle.Searcher}
+			for _, m := range b.resolve(bundle) {
+				built.members = append(built.members, strings.ToLower(m.Hash))
+			}
+			b.built[source] = built
+		}
+	}
+}
+
+// Landed settles the bundles of the last block built against mined, the
+// lower-case hashes of the transactions of the head that followed: each
+// counts as built for its searcher, and as landed if all its members were
+// mined, by us or anyone else
+func (b *HintBook) Landed(mined map[string]bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, built := range b.built {
+		s := b.searcher(built.searcher)
+		s.Built++
+		if !slices.ContainsFunc(built.members, func(h string) bool { return !mined[h] }) {
+			s.Landed++
+		}
+	}
+	clear(b.built)
+}
+
+// Searchers returns the stats of every searcher seen, most reputable first
+func (b *HintBook) Searchers() []SearcherStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]SearcherStats, 0, len(b.searchers))
+	for _, s := range b.searchers {
+		st := *s
+		st.Score = s.score()
+		out = append(out, st)
+	}
+	slices.SortFunc(out, func(x, y SearcherStats) int {
+		return cmp.Or(cmp.Compare(y.Score, x.Score), strings.Compare(x.Searcher, y.Searcher))
+	})
+	return out
+}
+
+// ResetSearcher forgets the history of searcher, restoring its reputation
+// to that of a newcomer, and reports whether it had one
+func (b *HintBook) ResetSearcher(searcher string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.searchers[searcher]
+	delete(b.searchers, searcher)
+	return ok
+}
+
+// TrackSearchers settles whether the bundles of the last block built
+// landed in head
+func (e *Env) TrackSearchers(ctx context.Context, hints *HintBook, head uint64) {
+	hints.mu.Lock()
+	pending := len(hints.built)
+	hints.mu.Unlock()
+	if pending == 0 {
+		return
+	}
+	hashes, err := FetchBlockTxHashes(ctx, e.RPC, head)
+	if err != nil {
+		fmt.Fprintf(e.Out, "Error fetching mined transactions: %v\n", err)
+		return
+	}
+	mined := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		mined[strings.ToLower(h)] = true
+	}
+	hints.Landed(mined)
+}
+
+// handleSearchers returns every searcher's stats, or with ?searcher= one
+// searcher's
+func (s *APIServer) handleSearchers(w http.ResponseWriter, r *http.Request) {
+	stats := s.Hints.Searchers()
+	if !r.URL.Query().Has("searcher") {
+		writeHTTPJSON(w, stats)
+		return
+	}
+	name := r.URL.Query().Get("searcher")
+	i := slices.IndexFunc(stats, func(st SearcherStats) bool { return st.Searcher == name })
+	if i < 0 {
+		http.Error(w, "searcher not tracked", http.StatusNotFound)
+		return
+	}
+	writeHTTPJSON(w, stats[i])
+}
+
+// handleResetSearcher forgets a searcher's history, named in a JSON body
+// as {"searcher": ...}
+func (s *APIServer) handleResetSearcher(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmissionSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req struct {
+		Searcher string `json:"searcher"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.Hints.ResetSearcher(req.Searcher) {
+		http.Error(w, "searcher not tracked", http.StatusNotFound)
+		return
+	}
+	writeHTTPJSON(w, map[string]string{"searcher": req.Searcher})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RevertGuard enforces revert protection on built blocks. It simulates the
+// block in its final order with eth_simulateV1 on top of the latest block,
+// each transaction seeing the state left by those before it, and reports
+// the bundles with a member that reverted without being listed in the
+// bundle's revertingTxHashes, and the transactions marked RevertProtect
+// that reverted. Protect then excludes them and rebuilds, so the gas they
+// freed is re-packed.
+//
+// Only transactions with a known sender can be simulated. Plain ones
+// without are left out of the simulation, and bundles with such a member,
+// such as a backrun of a MEV-Share hint whose transaction is hidden, are
+// kept unchecked. Required transactions are never dropped.
+type RevertGuard struct {
+	RPC    *RPCClient
+	Rounds int // rebuilds before any still reverting are dropped without re-packing
+}
+
+// NewRevertGuard returns a guard configured from cfg, or nil if revert
+// protection is disabled
+func NewRevertGuard(rpc *RPCClient, cfg RevertProtectionConfig) *RevertGuard {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &RevertGuard{RPC: rpc, Rounds: cfg.Rounds}
+}
+
+// simCall is one simulated call and the block entry it belongs to
+type simCall struct {
+	entry     string // hash of the selected transaction or bundle
+	protected bool   // the entry is dropped if this call reverts
+}
+
+// simResult is the part of an eth_simulateV1 call result the guard uses
+type simResult struct {
+	Status string `json:"status"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Simulate runs selected in order and returns the hashes of the entries
+// that must be dropped, in block order
+func (g *RevertGuard) Simulate(ctx context.Context, pool *TxPool, selected []*Transaction) (reverted []string, err error) {
+	ctx, span := StartSpan(ctx, "revert.simulate", "block.txs", len(selected))
+	defer func() {
+		span.SetAttr("revert.dropped", len(reverted))
+		span.SetError(err)
+		span.End()
+	}()
+
+	var sims []simCall
+	var calls []map[string]string
+	for _, tx := range selected {
+		if len(tx.Bundle) == 0 {
+			if tx.From == "" {
+				continue
+			}
+			sims = append(sims, simCall{entry: tx.Hash, protected: tx.RevertProtect && !slices.Contains(pool.Required, tx.Hash)})
+			calls = append(calls, callObject(tx))
+			continue
+		}
+		if pool.Hints == nil {
+			continue
+		}
+		members, reverting, ok := pool.Hints.Members(tx.Hash)
+		if !ok || slices.ContainsFunc(members, func(m *Transaction) bool { return m.From == "" }) {
+			continue
+		}
+		for _, m := range members {
+			sims = append(sims, simCall{entry: tx.Hash, protected: !reverting[strings.ToLower(m.Hash)]})
+			calls = append(calls, callObject(m))
+		}
+	}
+	span.SetAttr("revert.calls", len(calls))
+	if len(calls) == 0 {
+		return nil, nil
+	}
+
+	var blocks []struct {
+		Calls []simResult `json:"calls"`
+	}
+	params := map[string]any{"blockStateCalls": []any{map[string]any{"calls": calls}}}
+	if err := g.RPC.Call(ctx, &blocks, "eth_simulateV1", params, "latest"); err != nil {
+		return nil, fmt.Errorf("simulating block: %w", err)
+	}
+	if len(blocks) != 1 || len(blocks[0].Calls) != len(calls) {
+		return nil, fmt.Errorf("simulating block: got results for %d blocks, want 1 of %d calls", len(blocks), len(calls))
+	}
+	for i, res := range blocks[0].Calls {
+		s := sims[i]
+		if s.protected && (res.Status != "0x1" || res.Error != nil) && !slices.Contains(reverted, s.entry) {
+			reverted = append(reverted, s.entry)
+		}
+	}
+	return reverted, nil
+}
+
+// Protect simulates selected, excludes whatever reverts from pool and
+// calls rebuild for a new block, until one simulates cleanly or Rounds
+// rebuilds have been made; anything still reverting then is dropped from
+// the last block without re-packing. It returns the final block and the
+// hashes of the transactions and bundles dropped along the way. If a
+// simulation fails, the block built so far is returned with the error.
+func (g *RevertGuard) Protect(ctx context.Context, pool *TxPool, selected []*Transaction, timedOut bool, rebuild func() ([]*Transaction, bool, error)) ([]*Transaction, bool, []string, error) {
+	var dropped []string
+	for round := 0; ; round++ {
+		reverted, err := g.Simulate(ctx, pool, selected)
+		if err != nil || len(reverted) == 0 {
+			return selected, timedOut, dropped, err
+		}
+		dropped = append(dropped, reverted...)
+		if round == g.Rounds {
+			selected = slices.DeleteFunc(selected, func(tx *Transaction) bool { return slices.Contains(reverted, tx.Hash) })
+			return selected, timedOut, dropped, nil
+		}
+		for _, hash := range reverted {
+			pool.Exclude(hash)
+		}
+		if selected, timedOut, err = rebuild(); err != nil {
+			return nil, false, dropped, err
+		}
+	}
+}
+
+// Exclude leaves hash out of every later build from the pool: a pooled
+// transaction is removed and a bundle ID added to Excluded
+func (p *TxPool) Exclude(hash string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.removeTx(hash) {
+		return
+	}
+	if p.Excluded == nil {
+		p.Excluded = make(map[string]bool)
+	}
+	p.Excluded[hash] = true
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// Minimal RLP encoding. Values are encoded bottom-up: scalars and strings
+// with the rlp* helpers, lists by wrapping already-encoded items.
+
+// rlpBytes encodes a byte string
+func rlpBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(rlpHeader(0x80, len(b)), b...)
+}
+
+// rlpUint encodes an unsigned integer as its minimal big-endian bytes
+func rlpUint(v uint64) []byte {
+	if v == 0 {
+		return []byte{0x80}
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	i := 0
+	for buf[i] == 0 {
+		i++
+	}
+	return rlpBytes(buf[i:])
+}
+
+// rlpBig encodes a non-negative big integer
+func rlpBig(v *big.Int) []byte {
+	if v == nil || v.Sign() == 0 {
+		return []byte{0x80}
+	}
+	return rlpBytes(v.Bytes())
+}
+
+// rlpList wraps already-encoded items in a list
+func rlpList(items ...[]byte) []byte {
+	size := 0
+	for _, it := range items {
+		size += len(it)
+	}
+	out := rlpHeader(0xc0, size)
+	for _, it := range items {
+		out = append(out, it...)
+	}
+	return out
+}
+
+// rlpHeader returns the prefix for a string (0x80) or list (0xc0) payload
+func rlpHeader(base byte, size int) []byte {
+	if size < 56 {
+		return []byte{base + byte(size)}
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(size))
+	i := 0
+	for buf[i] == 0 {
+		i++
+	}
+	return append([]byte{base + 55 + byte(8-i)}, buf[i:]...)
+}
+
+// Minimal RLP decoding, enough to pick fields out of transactions.
+
+var errRLPSize = errors.New("rlp: input too short")
+
+// rlpSplit reads the first item of b, returning whether it is a list, its
+// payload and the remaining input
+func rlpSplit(b []byte) (isList bool, payload, rest []byte, err error) {
+	if len(b) == 0 {
+		return false, nil, nil, errRLPSize
+	}
+	prefix := b[0]
+	var offset, size int
+	switch {
+	case prefix < 0x80:
+		return false, b[:1], b[1:], nil
+	case prefix < 0xb8:
+		offset, size = 1, int(prefix-0x80)
+	case prefix < 0xc0:
+		offset, size, err = rlpLongSize(b, int(prefix-0xb7))
+	case prefix < 0xf8:
+		isList, offset, size = true, 1, int(prefix-0xc0)
+	default:
+		isList = true
+		offset, size, err = rlpLongSize(b, int(prefix-0xf7))
+	}
+	if err != nil {
+		return false, nil, nil, err
+	}
+	if offset+size > len(b) {
+		return false, nil, nil, errRLPSize
+	}
+	return isList, b[offset : offset+size], b[offset+size:], nil
+}
+
+func rlpLongSize(b []byte, n int) (offset, size int, err error) {
+	if n > 8 || 1+n > len(b) {
+		return 0, 0, errRLPSize
+	}
+	var buf [8]byte
+	copy(buf[8-n:], b[1:1+n])
+	v := binary.BigEndian.Uint64(buf[:])
+	if v > uint64(len(b)) {
+		return 0, 0, errRLPSize
+	}
+	return 1 + n, int(v), nil
+}
+
+// rlpListItems splits an encoded list into its items' payloads
+func rlpListItems(b []byte) ([][]byte, error) {
+	isList, payload, rest, err := rlpSplit(b)
+	if err != nil {
+		return nil, err
+	}
+	if !isList || len(rest) != 0 {
+		return nil, errors.New("rlp: expected a single list")
+	}
+	return rlpItems(payload)
+}
+
+// rlpItems splits a list payload into its items' payloads
+func rlpItems(payload []byte) ([][]byte, error) {
+	var items [][]byte
+	for len(payload) > 0 {
+		_, item, rest, err := rlpSplit(payload)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		payload = rest
+	}
+	return items, nil
+}
+
+// rlpEncodedItems splits an encoded list into its items' full encodings
+func rlpEncodedItems(b []byte) ([][]byte, error) {
+	isList, payload, rest, err := rlpSplit(b)
+	if err != nil {
+		return nil, err
+	}
+	if !isList || len(rest) != 0 {
+		return nil, errors.New("rlp: expected a single list")
+	}
+	var items [][]byte
+	for len(payload) > 0 {
+		_, _, rest, err := rlpSplit(payload)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, payload[:len(payload)-len(rest)])
+		payload = rest
+	}
+	return items, nil
+}
+
+// rlpToUint decodes a big-endian scalar payload of at most 8 bytes
+func rlpToUint(b []byte) (uint64, error) {
+	if len(b) > 8 {
+		return 0, errors.New("rlp: integer overflows uint64")
+	}
+	var buf [8]byte
+	copy(buf[8-len(b):], b)
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+)
+
+// RLPx, the encrypted transport of devp2p: an ECIES handshake (EIP-8
+// format) agreeing on session secrets, then length-prefixed frames
+// encrypted with AES-256-CTR and authenticated by running Keccak MACs.
+// See https://github.com/ethereum/devp2p/blob/master/rlpx.md
+
+// eciesOverhead is what ECIES adds to a message: the ephemeral public key,
+// the IV and the HMAC
+const eciesOverhead = 65 + 16 + 32
+
+// rlpxMaxFrame bounds a frame, and so a message, at what the 24-bit frame
+// size allows
+const rlpxMaxFrame = 1<<24 - 1
+
+// rlpxMaxMessage bounds a decompressed message
+const rlpxMaxMessage = 16 << 20
+
+// rlpxHandshakeTimeout bounds the handshake and protocol negotiation
+const rlpxHandshakeTimeout = 10 * time.Second
+
+// eciesEncrypt encrypts msg to pub, authenticating macData along with it
+func eciesEncrypt(pub *secpPoint, msg, macData []byte) ([]byte, error) {
+	eph, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	z, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	ke, km := eciesKeys(z)
+	out := make([]byte, 0, eciesOverhead+len(msg))
+	out = append(out, 0x04)
+	out = append(out, eph.pub.bytes64()...)
+	iv := make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	out = append(out, iv...)
+	block, err := aes.NewCipher(ke)
+	if err != nil {
+		return nil, err
+	}
+	c := make([]byte, len(msg))
+	cipher.NewCTR(block, iv).XORKeyStream(c, msg)
+	out = append(out, c...)
+	mac := hmac.New(sha256.New, km)
+	mac.Write(iv)
+	mac.Write(c)
+	mac.Write(macData)
+	return mac.Sum(out), nil
+}
+
+// eciesDecrypt decrypts data encrypted to key by eciesEncrypt
+func eciesDecrypt(key *SigningKey, data, macData []byte) ([]byte, error) {
+	if len(data) < eciesOverhead || data[0] != 0x04 {
+		return nil, errors.New("ecies: malformed message")
+	}
+	pub, err := parseSecpPubkey(data[1:65])
+	if err != nil {
+		return nil, fmt.Errorf("ecies: %w", err)
+	}
+	z, err := key.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	ke, km := eciesKeys(z)
+	iv, c, tag := data[65:81], data[81:len(data)-32], data[len(data)-32:]
+	mac := hmac.New(sha256.New, km)
+	mac.Write(iv)
+	mac.Write(c)
+	mac.Write(macData)
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return nil, errors.New("ecies: invalid message authentication code")
+	}
+	block, err := aes.NewCipher(ke)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, len(c))
+	cipher.NewCTR(block, iv).XORKeyStream(msg, c)
+	return msg, nil
+}
+
+// eciesKeys derives the AES-128 and HMAC keys from the shared secret z by
+// the NIST SP 800-56 concatenation KDF over SHA-256
+func eciesKeys(z []byte) (ke, km []byte) {
+	k := sha256.Sum256(append([]byte{0, 0, 0, 1}, z...))
+	mk := sha256.Sum256(k[16:])
+	return k[:16], mk[:]
+}
+
+// rlpxSecrets are the session secrets both sides derive from the handshake
+type rlpxSecrets struct {
+	aes, mac              Hash
+	egressMAC, ingressMAC keccakState
+}
+
+// deriveSecrets computes the session secrets from the ephemeral key
+// agreement, both nonces and the handshake packets as sent
+func deriveSecrets(eph *SigningKey, remoteEph *secpPoint, initNonce, respNonce, auth, ack []byte, initiator bool) (*rlpxSecrets, error) {
+	ephShared, err := eph.ECDH(remoteEph)
+	if err != nil {
+		return nil, err
+	}
+	inner := Keccak256(respNonce, initNonce)
+	shared := Keccak256(ephShared, inner[:])
+	s := &rlpxSecrets{aes: Keccak256(ephShared, shared[:])}
+	s.mac = Keccak256(ephShared, s.aes[:])
+
+	// Each side's egress MAC starts from the other side's nonce and its
+	// own handshake packet
+	xor := func(nonce []byte) []byte {
+		b := make([]byte, 32)
+		for i := range b {
+			b[i] = s.mac[i] ^ nonce[i]
+		}
+		return b
+	}
+	initMAC, respMAC := &s.egressMAC, &s.ingressMAC
+	if !initiator {
+		initMAC, respMAC = respMAC, initMAC
+	}
+	initMAC.Write(xor(respNonce))
+	initMAC.Write(auth)
+	respMAC.Write(xor(initNonce))
+	respMAC.Write(ack)
+	return s, nil
+}
+
+// rlpxConn is an established RLPx session. Reads and writes may each be
+// done from one goroutine at a time.
+type rlpxConn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	remote    *secpPoint // the peer's node key
+	enc, dec  cipher.Stream
+	macCipher cipher.Block
+	egressMAC keccakState
+	ingrMAC   keccakState
+	snappy    bool // compress messages, once both sides said hello with version 5
+}
+
+func newRLPxConn(conn net.Conn, r *bufio.Reader, remote *secpPoint, s *rlpxSecrets) (*rlpxConn, error) {
+	encBlock, err := aes.NewCipher(s.aes[:])
+	if err != nil {
+		return nil, err
+	}
+	macBlock, err := aes.NewCipher(s.mac[:])
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, encBlock.BlockSize())
+	return &rlpxConn{
+		conn:      conn,
+		r:         r,
+		remote:    remote,
+		enc:       cipher.NewCTR(encBlock, iv),
+		dec:       cipher.NewCTR(encBlock, iv),
+		macCipher: macBlock,
+		egressMAC: s.egressMAC,
+		ingrMAC:   s.ingressMAC,
+	}, nil
+}
+
+// dialRLPx runs the initiator side of the handshake on conn, an outbound
+// connection to the node with public key remote
+func dialRLPx(conn net.Conn, key *SigningKey, remote *secpPoint) (*rlpxConn, error) {
+	conn.SetDeadline(time.Now().Add(rlpxHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+
+	eph, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	static, err := key.ECDH(remote)
+	if err != nil {
+		return nil, err
+	}
+	var signed Hash
+	for i := range signed {
+		signed[i] = static[i] ^ nonce[i]
+	}
+	sr, ss, v := eph.Sign(signed)
+	sig := make([]byte, 65)
+	sr.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:64])
+	sig[64] = v
+	auth, err := sealHandshake(remote, rlpList(rlpBytes(sig), rlpBytes(key.pub.bytes64()), rlpBytes(nonce), rlpUint(4)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(auth); err != nil {
+		return nil, err
+	}
+
+	ack, body, err := readHandshake(r, key)
+	if err != nil {
+		return nil, fmt.Errorf("reading ack: %w", err)
+	}
+	if len(body) < 2 {
+		return nil, errors.New("ack has too few fields")
+	}
+	remoteEph, err := parseSecpPubkey(body[0])
+	if err != nil {
+		return nil, fmt.Errorf("ack: %w", err)
+	}
+	if len(body[1]) != 32 {
+		return nil, errors.New("ack nonce is not 32 bytes")
+	}
+	secrets, err := deriveSecrets(eph, remoteEph, nonce, body[1], auth, ack, true)
+	if err != nil {
+		return nil, err
+	}
+	return newRLPxConn(conn, r, remote, secrets)
+}
+
+// acceptRLPx runs the recipient side of the handshake on an inbound
+// connection
+func acceptRLPx(conn net.Conn, key *SigningKey) (*rlpxConn, error) {
+	conn.SetDeadline(time.Now().Add(rlpxHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+
+	auth, body, err := readHandshake(r, key)
+	if err != nil {
+		return nil, fmt.Errorf("reading auth: %w", err)
+	}
+	if len(body) < 3 || len(body[0]) != 65 || len(body[2]) != 32 {
+		return nil, errors.New("malformed auth")
+	}
+	remote, err := parseSecpPubkey(body[1])
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	initNonce := body[2]
+	static, err := key.ECDH(remote)
+	if err != nil {
+		return nil, err
+	}
+	var signed Hash
+	for i := range signed {
+		signed[i] = static[i] ^ initNonce[i]
+	}
+	sig := body[0]
+	remoteEph, err := recoverPubkey(signed, sig)
+	if err != nil {
+		return nil, fmt.Errorf("auth signature: %w", err)
+	}
+
+	eph, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ack, err := sealHandshake(remote, rlpList(rlpBytes(eph.pub.bytes64()), rlpBytes(nonce), rlpUint(4)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(ack); err != nil {
+		return nil, err
+	}
+	secrets, err := deriveSecrets(eph, remoteEph, initNonce, nonce, auth, ack, false)
+	if err != nil {
+		return nil, err
+	}
+	return newRLPxConn(conn, r, remote, secrets)
+}
+
+// recoverPubkey returns the public key that made the 65-byte r||s||v
+// signature sig of hash
+func recoverPubkey(hash Hash, sig []byte) (*secpPoint, error) {
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64])
+	return recoverPoint(hash, r, s, sig[64])
+}
+
+// sealHandshake pads an auth or ack body with random bytes, as EIP-8 asks
+// so that packets are distinguishable from the old format, and encrypts it
+// to remote behind its size prefix
+func sealHandshake(remote *secpPoint, body []byte) ([]byte, error) {
+	var n [1]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, err
+	}
+	pad := make([]byte, 100+int(n[0])%100)
+	if _, err := rand.Read(pad); err != nil {
+		return nil, err
+	}
+	body = append(body, pad...)
+	prefix := binary.BigEndian.AppendUint16(nil, uint16(len(body)+eciesOverhead))
+	enc, err := eciesEncrypt(remote, body, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, enc...), nil
+}
+
+// readHandshake reads an EIP-8 auth or ack packet and returns it whole
+// along with the payloads of its body's list. Fields beyond those the
+// protocol version knows are ignored.
+func readHandshake(r io.Reader, key *SigningKey) (packet []byte, fields [][]byte, err error) {
+	prefix := make([]byte, 2)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, nil, err
+	}
+	size := binary.BigEndian.Uint16(prefix)
+	packet = make([]byte, 2+int(size))
+	copy(packet, prefix)
+	if _, err := io.ReadFull(r, packet[2:]); err != nil {
+		return nil, nil, err
+	}
+	body, err := eciesDecrypt(key, packet[2:], prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	isList, payload, _, err := rlpSplit(body) // the padding follows the list
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isList {
+		return nil, nil, errors.New("handshake body is not a list")
+	}
+	if fields, err = rlpItems(payload); err != nil {
+		return nil, nil, err
+	}
+	return packet, fields, nil
+}
+
+// updateMAC folds seed into mac through the MAC cipher and returns the
+// first 16 bytes of the new digest
+func (c *rlpxConn) updateMAC(mac *keccakState, seed []byte) []byte {
+	sum := mac.Sum()
+	var buf [16]byte
+	c.macCipher.Encrypt(buf[:], sum[:16])
+	for i := range buf {
+		buf[i] ^= seed[i]
+	}
+	mac.Write(buf[:])
+	sum = mac.Sum()
+	return sum[:16]
+}
+
+// WriteMsg sends message code with its RLP-encoded data in one frame
+func (c *rlpxConn) WriteMsg(code uint64, data []byte) error {
+	if c.snappy {
+		data = snappyEncode(data)
+	}
+	frame := append(rlpUint(code), data...)
+	if len(frame) > rlpxMaxFrame {
+		return fmt.Errorf("message of %d bytes is too large for a frame", len(frame))
+	}
+	header := make([]byte, 16)
+	header[0], header[1], header[2] = byte(len(frame)>>16), byte(len(frame)>>8), byte(len(frame))
+	copy(header[3:], []byte{0xc2, 0x80, 0x80}) // header data: capability and context ids, both 0
+	c.enc.XORKeyStream(header, header)
+	headerMAC := c.updateMAC(&c.egressMAC, header)
+
+	padded := make([]byte, (len(frame)+15)/16*16)
+	copy(padded, frame)
+	c.enc.XORKeyStream(padded, padded)
+	c.egressMAC.Write(padded)
+	seed := c.egressMAC.Sum()
+	frameMAC := c.updateMAC(&c.egressMAC, seed[:16])
+
+	out := make([]byte, 0, 32+len(padded)+16)
+	out = append(append(append(append(out, header...), headerMAC...), padded...), frameMAC...)
+	_, err := c.conn.Write(out)
+	return err
+}
+
+// ReadMsg reads the next message, returning its code and RLP-encoded data
+func (c *rlpxConn) ReadMsg() (code uint64, data []byte, err error) {
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return 0, nil, err
+	}
+	if !hmac.Equal(c.updateMAC(&c.ingrMAC, header[:16]), header[16:]) {
+		return 0, nil, errors.New("rlpx: bad header MAC")
+	}
+	c.dec.XORKeyStream(header[:16], header[:16])
+	size := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	padded := (size + 15) / 16 * 16
+	frame := make([]byte, padded+16)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return 0, nil, err
+	}
+	c.ingrMAC.Write(frame[:padded])
+	seed := c.ingrMAC.Sum()
+	if !hmac.Equal(c.updateMAC(&c.ingrMAC, seed[:16]), frame[padded:]) {
+		return 0, nil, errors.New("rlpx: bad frame MAC")
+	}
+	c.dec.XORKeyStream(frame[:padded], frame[:padded])
+	frame = frame[:size]
+
+	_, codeBytes, rest, err := rlpSplit(frame)
+	if err != nil {
+		return 0, nil, fmt.Errorf("rlpx: message code: %w", err)
+	}
+	if code, err = rlpToUint(codeBytes); err != nil {
+		return 0, nil, fmt.Errorf("rlpx: message code: %w", err)
+	}
+	if c.snappy {
+		if rest, err = snappyDecode(rest, rlpxMaxMessage); err != nil {
+			return 0, nil, fmt.Errorf("rlpx: message %#x: %w", code, err)
+		}
+	}
+	return code, rest, nil
+}
+
+// Close closes the connection
+func (c *rlpxConn) Close() error { return c.conn.Close() }
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRPCEndpoint is the public Berachain mainnet RPC
+const DefaultRPCEndpoint = "https://rpc.berachain.com"
+
+// Standard and commonly used JSON-RPC error codes
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	ErrCodeServer         = -32000
+	ErrCodeNotFound       = -32001
+	ErrCodeUnavailable    = -32002
+	ErrCodeTxRejected     = -32003
+	ErrCodeLimitExceeded  = -32005
+)
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("RPC error %d: %s", e.Code, e.Message)
+}
+
+// Retryable reports whether the error code indicates a transient node-side
+// condition (overload, rate limiting, internal failure) worth retrying.
+func (e *RPCError) Retryable() bool {
+	switch e.Code {
+	case ErrCodeInternal, ErrCodeServer, ErrCodeUnavailable, ErrCodeLimitExceeded:
+		return true
+	}
+	return false
+}
+
+// HTTPError is returned when the RPC endpoint answers with a non-2xx status
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the status is a transient server-side failure
+func (e *HTTPError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryable classifies an RPC call error as transient (retry) or fatal
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Retryable()
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Retryable()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryPolicy controls how failed RPC calls are retried
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first; <= 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // cap on any single delay; 0 is uncapped
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   250 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// Backoff returns the delay before retry number attempt (starting at 1),
+// using exponential growth with full jitter.
+func (rp RetryPolicy) Backoff(attempt int) time.Duration {
+	d := rp.BaseDelay
+	for i := 1; i < attempt && (rp.MaxDelay <= 0 || d < rp.MaxDelay) && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if rp.MaxDelay > 0 && d > rp.MaxDelay {
+		d = rp.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d) + 1))
+}
+
+// rpcMessage is the raw JSON-RPC response envelope
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// DefaultMaxBatchSize keeps batches under the limit most public nodes enforce
+const DefaultMaxBatchSize = 100
+
+// DefaultMaxResponseSize bounds a response body; a full pending block on a
+// busy chain is a few megabytes
+const DefaultMaxResponseSize = 64 << 20
+
+// ErrResponseTooLarge is returned once a response body passes MaxResponseSize
+var ErrResponseTooLarge = errors.New("response exceeds size limit")
+
+// RPCClient is a minimal JSON-RPC client for a Berachain node
+type RPCClient struct {
+	Endpoint        string
+	HTTP            *http.Client
+	Retry           RetryPolicy
+	MaxBatchSize    int   // calls per HTTP POST in BatchCall
+	MaxResponseSize int64 // bytes read from one response before giving up; 0 is unlimited
+
+	// Breakers, if set, route calls among the endpoints they guard, primary
+	// first, in place of Endpoint
+	Breakers []*CircuitBreaker
+
+	Auth *JWTAuth // signs every request if set, for the node's authenticated port
+
+	nextID atomic.Int64
+}
+
+func NewRPCClient(endpoint string) *RPCClient {
+	return &RPCClient{
+		Endpoint:        endpoint,
+		HTTP:            newHTTPClient(10 * time.Second),
+		Retry:           DefaultRetryPolicy(),
+		MaxBatchSize:    DefaultMaxBatchSize,
+		MaxResponseSize: DefaultMaxResponseSize,
+	}
+}
+
+// Call invokes method with params and decodes the result into result,
+// retrying transient failures according to the client's RetryPolicy.
+func (c *RPCClient) Call(ctx context.Context, result any, method string, params ...any) error {
+	ctx, span := startSpan(ctx, "rpc "+method, SpanClient, []any{"rpc.system", "jsonrpc", "rpc.method", method, "server.address", c.Endpoint})
+	defer span.End()
+	err := c.withRetry(ctx, func() error {
+		return c.call(ctx, result, method, params)
+	})
+	span.SetError(err)
+	return err
+}
+
+func (c *RPCClient) withRetry(ctx context.Context, fn func() error) error {
+	var err error
+	attempt := 1
+	defer func() { SpanFromContext(ctx).SetAttr("rpc.attempts", attempt) }()
+	for ; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= c.Retry.MaxAttempts || !IsRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(c.Retry.Backoff(attempt)):
+		}
+	}
+}
+
+func (c *RPCClient) call(ctx context.Context, result any, method string, params []any) error {
+	if params == nil {
+		params = []any{}
+	}
+	req := RPCRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+		ID:      int(c.nextID.Add(1)),
+	}
+	body, err := c.post(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// Decode the result straight off the wire rather than buffering the
+	// body and the result separately
+	dec := json.NewDecoder(body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return fmt.Errorf("error unmarshaling response: %w", err)
+	}
+	var rpcErr *RPCError
+	var resultErr error
+	err = decodeFields(dec, func(key string) error {
+		switch key {
+		case "error":
+			return dec.Decode(&rpcErr)
+		case "result":
+			if result == nil {
+				return skipValue(dec)
+			}
+			if sd, ok := result.(streamDecoder); ok {
+				resultErr = sd.decodeStream(dec)
+			} else {
+				resultErr = dec.Decode(result)
+			}
+			if resultErr != nil && !isSyntaxError(resultErr) {
+				// A well-formed value of the wrong shape; skip on
+				return nil
+			}
+			return resultErr
+		}
+		return skipValue(dec)
+	})
+	switch {
+	case errors.Is(err, ErrResponseTooLarge):
+		return err
+	case err != nil && resultErr == nil:
+		return fmt.Errorf("error unmarshaling response: %w", err)
+	case rpcErr != nil:
+		return rpcErr
+	case resultErr != nil:
+		return fmt.Errorf("error decoding %s result: %w", method, resultErr)
+	}
+	return nil
+}
+
+// streamDecoder is implemented by results that decode themselves from the
+// response stream, such as a pending block handled one transaction at a time
+type streamDecoder interface {
+	decodeStream(dec *json.Decoder) error
+}
+
+// expectDelim consumes the next token, which must be delim
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+// decodeFields calls fn for each key of the object whose opening brace dec
+// has just consumed, with dec positioned at the value, which fn must
+// consume; it consumes the closing brace
+func decodeFields(dec *json.Decoder, fn func(key string) error) error {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("expected object key, got %v", tok)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// skipValue consumes the next value without keeping it
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// isSyntaxError reports whether err means the stream itself is broken, as
+// opposed to a value that doesn't fit its destination
+func isSyntaxError(err error) bool {
+	var typeErr *json.UnmarshalTypeError
+	return !errors.As(err, &typeErr)
+}
+
+// BatchElem is a single call within a JSON-RPC batch
+type BatchElem struct {
+	Method string
+	Params []any
+	Result any   // decoded into on success; may be nil
+	Error  error // set by BatchCall if this call failed
+}
+
+// BatchCall sends elems as JSON-RPC batches (arrays of requests), at most
+// MaxBatchSize per HTTP POST, and correlates responses back to their elems
+// by ID. Transport failures are retried per batch and returned; failures of
+// individual calls are recorded in the corresponding elem's Error.
+func (c *RPCClient) BatchCall(ctx context.Context, elems []BatchElem) error {
+	size := c.MaxBatchSize
+	if size <= 0 {
+		size = len(elems)
+	}
+	for start := 0; start < len(elems); start += size {
+		chunk := elems[start:min(start+size, len(elems))]
+		ctx, span := startSpan(ctx, "rpc batch", SpanClient, []any{"rpc.system", "jsonrpc", "rpc.batch_size", len(chunk), "server.address", c.Endpoint})
+		err := c.withRetry(ctx, func() error {
+			return c.batchCall(ctx, chunk)
+		})
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RPCClient) batchCall(ctx context.Context, elems []BatchElem) error {
+	reqs := make([]RPCRequest, len(elems))
+	byID := make(map[int]*BatchElem, len(elems))
+	for i := range elems {
+		params := elems[i].Params
+		if params == nil {
+			params = []any{}
+		}
+		reqs[i] = RPCRequest{
+			JSONRPC: "2.0",
+			Method:  elems[i].Method,
+			Params:  params,
+			ID:      int(c.nextID.Add(1)),
+		}
+		byID[reqs[i].ID] = &elems[i]
+		elems[i].Error = nil
+	}
+
+	body, err := c.post(ctx, reqs)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	// Responses are decoded one at a time as they arrive
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("error unmarshaling batch response: %w", err)
+	}
+	if tok == json.Delim('{') {
+		// Nodes without batch support answer with a single error object
+		var rpcErr *RPCError
+		err := decodeFields(dec, func(key string) error {
+			if key == "error" {
+				return dec.Decode(&rpcErr)
+			}
+			return skipValue(dec)
+		})
+		if err == nil && rpcErr != nil {
+			return rpcErr
+		}
+		return fmt.Errorf("error unmarshaling batch response: not an array")
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("error unmarshaling batch response: unexpected %v", tok)
+	}
+
+	for dec.More() {
+		var msg rpcMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, ErrResponseTooLarge) {
+				return err
+			}
+			return fmt.Errorf("error unmarshaling batch response: %w", err)
+		}
+		elem, ok := byID[msg.ID]
+		if !ok {
+			continue
+		}
+		delete(byID, msg.ID)
+		switch {
+		case msg.Error != nil:
+			elem.Error = msg.Error
+		case elem.Result != nil:
+			if err := json.Unmarshal(msg.Result, elem.Result); err != nil {
+				elem.Error = fmt.Errorf("error decoding %s result: %w", elem.Method, err)
+			}
+		}
+	}
+	for _, elem := range byID {
+		elem.Error = fmt.Errorf("missing response for %s in batch", elem.Method)
+	}
+	return nil
+}
+
+// post sends payload to the endpoint, or the first healthy one of
+// Breakers, and returns the response body, which the caller must close;
+// reading past MaxResponseSize fails with ErrResponseTooLarge
+func (c *RPCClient) post(ctx context.Context, payload any) (io.ReadCloser, error) {
+	endpoint, breaker, err := c.route(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if breaker == nil {
+		return c.send(ctx, endpoint, payload)
+	}
+	SpanFromContext(ctx).SetAttr("server.address", endpoint)
+	body, err := c.send(ctx, endpoint, payload)
+	breaker.record(endpointFailed(ctx, err), time.Now())
+	return body, err
+}
+
+// send posts payload to endpoint. A token refused because the node's clock
+// is off from ours is signed again for its time and the request resent once.
+func (c *RPCClient) send(ctx context.Context, endpoint string, payload any) (io.ReadCloser, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	var resp *http.Response
+	for resent := false; ; resent = true {
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		injectTraceparent(ctx, req)
+		c.Auth.Authorize(req)
+
+		if resp, err = c.HTTP.Do(req); err != nil {
+			return nil, fmt.Errorf("error making request: %w", err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized || resent || !c.Auth.Resync(resp, time.Now()) {
+			break
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+	return &limitedBody{ReadCloser: resp.Body, max: c.MaxResponseSize}, nil
+}
+
+// limitedBody fails reads once more than max bytes have come through. The
+// error is sticky, since json.Decoder drops a read error that arrives
+// alongside data it can still use.
+type limitedBody struct {
+	io.ReadCloser
+	max  int64
+	read int64
+	err  error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.max > 0 && b.read > b.max {
+		b.err = fmt.Errorf("%w of %d bytes", ErrResponseTooLarge, b.max)
+		return n - int(b.read-b.max), b.err
+	}
+	return n, err
+}
@@ -0,0 +1,36 @@
+package main
+
+// SandwichPolicy decides what happens to bundles that look like sandwich
+// attacks
+type SandwichPolicy string
+
+const (
+	SandwichReject       SandwichPolicy = "reject"       // never built with
+	SandwichDeprioritize SandwichPolicy = "deprioritize" // packed after everything else
+	SandwichAllow        SandwichPolicy = "allow"
+)
+
+// DetectSandwich reports the victim of a sandwich in members, if any: a
+// transaction enclosed by two transactions from the same sender, all three
+// calling the same contract (the pool or its router). Members with an
+// unknown sender can be victims but never attackers.
+func DetectSandwich(members []*Transaction) (victim string, ok bool) {
+	for j := 1; j < len(members)-1; j++ {
+		v := members[j]
+		if v.To == "" {
+			continue
+		}
+		for i := 0; i < j; i++ {
+			front := members[i]
+			if front.From == "" || front.From == v.From || front.To != v.To {
+				continue
+			}
+			for _, back := range members[j+1:] {
+				if back.From == front.From && back.To == v.To {
+					return v.Hash, true
+				}
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ProposerSchedule limits serve to the slots our own validators propose.
+// Their duties come from the relay's schedule like any proposer's; on the
+// other slots the pool is still synced, so it is warm when our turn comes,
+// but nothing is simulated, built or bid unless Shadow keeps building
+// every slot without bidding.
+type ProposerSchedule struct {
+	Validators map[BLSPubkey]bool
+	Shadow     bool
+}
+
+// NewProposerSchedule compiles cfg, returning nil if it lists no
+// validators, in which case every slot is built
+func NewProposerSchedule(cfg RelayConfig) (*ProposerSchedule, error) {
+	if len(cfg.Validators) == 0 {
+		return nil, nil
+	}
+	s := &ProposerSchedule{Validators: make(map[BLSPubkey]bool, len(cfg.Validators)), Shadow: cfg.ShadowOtherSlots}
+	for i, v := range cfg.Validators {
+		var pubkey BLSPubkey
+		if err := pubkey.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("relay.validators[%d]: %w", i, err)
+		}
+		s.Validators[pubkey] = true
+	}
+	return s, nil
+}
+
+// Ours reports whether the slot with proposer reg, nil if unregistered, is
+// one of our validators'. Every slot is ours without a schedule.
+func (s *ProposerSchedule) Ours(reg *ValidatorRegistration) bool {
+	return s == nil || reg != nil && s.Validators[reg.Pubkey]
+}
+
+// Build reports whether a slot with proposer reg is built at all
+func (s *ProposerSchedule) Build(reg *ValidatorRegistration) bool {
+	return s == nil || s.Shadow || s.Ours(reg)
+}
+
+// SlotsOf returns the slots of the cached duties proposed by one of
+// validators, in order
+func (b *RegistrationBook) SlotsOf(validators map[BLSPubkey]bool) []uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var slots []uint64
+	for slot, d := range b.duties {
+		if validators[d.Entry.Message.Pubkey] {
+			slots = append(slots, slot)
+		}
+	}
+	slices.Sort(slots)
+	return slots
+}
+
+// SkipSlot reports whether serve leaves the slot after head unbuilt
+// because it isn't ours, saying when the next of ours is if known
+func (e *Env) SkipSlot(head *Header, reg *ValidatorRegistration) bool {
+	if e.Schedule.Build(reg) {
+		return false
+	}
+	next := "none known"
+	if e.Proposers != nil {
+		for _, slot := range e.Proposers.SlotsOf(e.Schedule.Validators) {
+			if slot > head.Number+1 {
+				next = fmt.Sprintf("slot %d", slot)
+				break
+			}
+		}
+	}
+	fmt.Fprintf(e.Out, "Slot %d is not ours (next: %s); syncing only\n", head.Number+1, next)
+	return true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"sync"
+)
+
+// BuildContext is what a Scorer knows of the block being built
+type BuildContext struct {
+	Block   uint64 // number of the block being built; 0 if unknown
+	BaseFee int64  // burned per gas
+	ChainID uint64 // 0 if unchecked
+	Weights ScoreWeights
+	Board   *CuttingBoard // the proposer's reward allocation; nil if unknown
+}
+
+// ScoreWeights weighs the parts of a transaction's profit in the default
+// score, so builders serving validators with different incentives can tune
+// what blocks favor without a custom Scorer
+type ScoreWeights struct {
+	Tip    float64 `json:"tip"`
+	MEV    float64 `json:"mev"`
+	PoL    float64 `json:"pol"`
+	PerGas bool    `json:"per_gas"` // rank by weighted value per gas, scaled to a plain transfer's 21000 gas
+}
+
+// DefaultScoreWeights scores transactions by their plain profit
+func DefaultScoreWeights() ScoreWeights {
+	return ScoreWeights{Tip: 1, MEV: 1, PoL: 1}
+}
+
+// Neutral reports whether the weights leave the score at the profit
+func (w ScoreWeights) Neutral() bool { return w == DefaultScoreWeights() }
+
+// Score returns tx's weighted value, counting polShare of its PoL incentive
+func (w ScoreWeights) Score(tx *Transaction, polShare float64) int64 {
+	f := tx.Fees()
+	v := w.Tip*float64(f.Tip) + w.MEV*float64(f.MEVBonus) + w.PoL*polShare*float64(f.PoLBonus)
+	if w.PerGas {
+		v = v * txBaseGas / float64(max(tx.PackGas(), 1))
+	}
+	switch {
+	case v >= math.MaxInt64:
+		return math.MaxInt64
+	case v <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(v)
+}
+
+// Scorer values a transaction for packing, in wei, in place of its
+// profit. It lets teams rank by their own MEV or PoL estimates without
+// touching the strategies: everything that orders transactions uses the
+// score, while payments, reports and bids still use the profit. Score is
+// called whenever the pool stamps a transaction (on admission and when the
+// base fee or block changes), never during a build, and must be safe for
+// concurrent use.
+type Scorer interface {
+	Score(tx *Transaction, ctx BuildContext) *big.Int
+}
+
+// ScorerFunc adapts a function to a Scorer
+type ScorerFunc func(tx *Transaction, ctx BuildContext) *big.Int
+
+func (f ScorerFunc) Score(tx *Transaction, ctx BuildContext) *big.Int { return f(tx, ctx) }
+
+// DefaultScorer is the scorer builds use unless builder.scorer names another
+const DefaultScorer = "profit"
+
+var (
+	scorersMu sync.RWMutex
+	scorers   = map[string]Scorer{
+		DefaultScorer: ScorerFunc(func(tx *Transaction, _ BuildContext) *big.Int { return big.NewInt(tx.Profit()) }),
+	}
+)
+
+// RegisterScorer makes s selectable as builder.scorer under name. Scorers
+// are compiled in: add a file to the package whose init function registers
+// one. It panics if name is taken.
+func RegisterScorer(name string, s Scorer) {
+	scorersMu.Lock()
+	defer scorersMu.Unlock()
+	if _, ok := scorers[name]; ok {
+		panic(fmt.Sprintf("scorer %q registered twice", name))
+	}
+	scorers[name] = s
+}
+
+// LookupScorer returns the scorer registered under name
+func LookupScorer(name string) (Scorer, error) {
+	scorersMu.RLock()
+	s, ok := scorers[name]
+	scorersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown scorer %q (want one of %v)", name, ScorerNames())
+	}
+	return s, nil
+}
+
+// ScorerNames lists the registered scorers, sorted
+func ScorerNames() []string {
+	scorersMu.RLock()
+	defer scorersMu.RUnlock()
+	names := make([]string, 0, len(scorers))
+	for name := range scorers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// customScored reports whether a scorer other than the default ranks the pool
+func (p *TxPool) customScored() bool {
+	return p.Scorer != "" && p.Scorer != DefaultScorer
+}
+
+// scoreAdjustment returns what the pool's scorer, or else its weights and
+// cutting board, add to tx's profit. The score saturates at the int64
+// range scores are kept in, as does the adjustment.
+func (p *TxPool) scoreAdjustment(tx *Transaction) int64 {
+	if !p.customScored() {
+		if p.Weights.Neutral() && p.CuttingBoard == nil {
+			return 0
+		}
+		return saturatingSub(p.Weights.Score(tx, p.CuttingBoard.Share(tx)), tx.Profit())
+	}
+	s, err := LookupScorer(p.Scorer)
+	if err != nil {
+		return 0 // configs are validated; a replayed trace checks for itself
+	}
+	score := s.Score(tx, BuildContext{Block: p.Block, BaseFee: p.BaseFee, ChainID: p.ChainID, Weights: p.Weights, Board: p.CuttingBoard})
+	if score == nil {
+		return 0
+	}
+	v := int64(math.MaxInt64)
+	switch {
+	case score.IsInt64():
+		v = score.Int64()
+	case score.Sign() < 0:
+		v = math.MinInt64
+	}
+	return saturatingSub(v, tx.Profit())
+}
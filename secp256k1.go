@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Minimal secp256k1 arithmetic for signing the builder's own transactions
+// and its devp2p handshakes. It favours clarity over speed and is not
+// constant-time, so it must only ever hold keys of hot wallets and node
+// identities the builder controls.
+
+var (
+	secpP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secpN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secpGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secpGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	secpHalfN = new(big.Int).Rsh(secpN, 1)
+)
+
+// secpPoint is an affine curve point; nil is the point at infinity
+type secpPoint struct{ x, y *big.Int }
+
+func secpAdd(a, b *secpPoint) *secpPoint {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	var slope *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return nil
+		}
+		// Tangent: 3x² / 2y
+		num := new(big.Int).Mul(a.x, a.x)
+		num.Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(a.y, 1)
+		slope = num.Mul(num, den.ModInverse(den, secpP))
+	} else {
+		num := new(big.Int).Sub(b.y, a.y)
+		den := new(big.Int).Sub(b.x, a.x)
+		den.Mod(den, secpP)
+		slope = num.Mul(num, den.ModInverse(den, secpP))
+	}
+	slope.Mod(slope, secpP)
+	x := new(big.Int).Mul(slope, slope)
+	x.Sub(x, a.x).Sub(x, b.x).Mod(x, secpP)
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, slope).Sub(y, a.y).Mod(y, secpP)
+	return &secpPoint{x, y}
+}
+
+func secpMul(p *secpPoint, k *big.Int) *secpPoint {
+	var r *secpPoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = secpAdd(r, r)
+		if k.Bit(i) == 1 {
+			r = secpAdd(r, p)
+		}
+	}
+	return r
+}
+
+// SigningKey is a secp256k1 private key
+type SigningKey struct {
+	d   *big.Int
+	pub *secpPoint
+}
+
+// ParseSigningKey parses a hex private key, with or without 0x
+func ParseSigningKey(s string) (*SigningKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "0x") {
+		s = "0x" + s
+	}
+	var b [32]byte
+	if err := decodeFixedHex(s, b[:]); err != nil {
+		return nil, err
+	}
+	d := new(big.Int).SetBytes(b[:])
+	if d.Sign() == 0 || d.Cmp(secpN) >= 0 {
+		return nil, errors.New("private key out of range")
+	}
+	return &SigningKey{d: d, pub: secpMul(&secpPoint{secpGx, secpGy}, d)}, nil
+}
+
+// GenerateSigningKey returns a random key
+func GenerateSigningKey() (*SigningKey, error) {
+	var b [32]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		d := new(big.Int).SetBytes(b[:])
+		if d.Sign() > 0 && d.Cmp(secpN) < 0 {
+			return &SigningKey{d: d, pub: secpMul(&secpPoint{secpGx, secpGy}, d)}, nil
+		}
+	}
+}
+
+// parseSecpPubkey parses a 64-byte uncompressed public key (without the
+// 0x04 prefix), checking that it is on the curve
+func parseSecpPubkey(b []byte) (*secpPoint, error) {
+	if len(b) != 64 {
+		return nil, fmt.Errorf("public key is %d bytes, want 64", len(b))
+	}
+	x, y := new(big.Int).SetBytes(b[:32]), new(big.Int).SetBytes(b[32:])
+	y2 := new(big.Int).Mul(y, y)
+	x3 := new(big.Int).Exp(x, big.NewInt(3), secpP)
+	if x.Cmp(secpP) >= 0 || y.Cmp(secpP) >= 0 || y2.Sub(y2, x3).Sub(y2, big.NewInt(7)).Mod(y2, secpP).Sign() != 0 {
+		return nil, errors.New("public key is not on the curve")
+	}
+	return &secpPoint{x, y}, nil
+}
+
+// bytes64 encodes p as 64 uncompressed bytes, without the 0x04 prefix
+func (p *secpPoint) bytes64() []byte {
+	b := make([]byte, 64)
+	p.x.FillBytes(b[:32])
+	p.y.FillBytes(b[32:])
+	return b
+}
+
+// ECDH returns the x coordinate of the key times pub, the shared secret of
+// an ECIES exchange
+func (k *SigningKey) ECDH(pub *secpPoint) ([]byte, error) {
+	s := secpMul(pub, k.d)
+	if s == nil {
+		return nil, errors.New("shared secret is the point at infinity")
+	}
+	return s.x.FillBytes(make([]byte, 32)), nil
+}
+
+// LoadSigningKey reads a hex private key from path
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseSigningKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Address returns the account address of the key
+func (k *SigningKey) Address() Address { return pubkeyAddress(k.pub) }
+
+func pubkeyAddress(pub *secpPoint) Address {
+	h := Keccak256(pub.bytes64())
+	var a Address
+	copy(a[:], h[12:])
+	return a
+}
+
+// RecoverAddress returns the address of the key that signed hash with r, s
+// and recovery id recID. As Ethereum requires since Homestead, s must be
+// in the lower half of the order.
+func RecoverAddress(hash Hash, r, s *big.Int, recID byte) (Address, error) {
+	q, err := recoverPoint(hash, r, s, recID)
+	if err != nil {
+		return Address{}, err
+	}
+	return pubkeyAddress(q), nil
+}
+
+// recoverPoint returns the public key that signed hash with r, s and recID
+func recoverPoint(hash Hash, r, s *big.Int, recID byte) (*secpPoint, error) {
+	if r.Sign() <= 0 || r.Cmp(secpN) >= 0 || s.Sign() <= 0 || s.Cmp(secpHalfN) > 0 || recID > 1 {
+		return nil, errors.New("signature values out of range")
+	}
+	// R is the point with x = r and y of parity recID: y² = x³ + 7, and as
+	// p ≡ 3 mod 4 the square root is y = (y²)^((p+1)/4)
+	y2 := new(big.Int).Exp(r, big.NewInt(3), secpP)
+	y2.Add(y2, big.NewInt(7)).Mod(y2, secpP)
+	y := new(big.Int).Exp(y2, new(big.Int).Rsh(new(big.Int).Add(secpP, big.NewInt(1)), 2), secpP)
+	if new(big.Int).Exp(y, big.NewInt(2), secpP).Cmp(y2) != 0 {
+		return nil, errors.New("signature r is not on the curve")
+	}
+	if y.Bit(0) != uint(recID) {
+		y.Sub(secpP, y)
+	}
+
+	// Q = r⁻¹(sR - eG)
+	rInv := new(big.Int).ModInverse(r, secpN)
+	u1 := new(big.Int).SetBytes(hash[:])
+	u1.Neg(u1).Mul(u1, rInv).Mod(u1, secpN)
+	u2 := new(big.Int).Mul(s, rInv)
+	u2.Mod(u2, secpN)
+	q := secpAdd(secpMul(&secpPoint{secpGx, secpGy}, u1), secpMul(&secpPoint{r, y}, u2))
+	if q == nil {
+		return nil, errors.New("signature recovers to the point at infinity")
+	}
+	return q, nil
+}
+
+// Sign signs hash with a deterministic RFC 6979 nonce and returns r, s and
+// the recovery id (y parity of R). s is normalized to the lower half of the
+// order, as Ethereum requires.
+func (k *SigningKey) Sign(hash Hash) (r, s *big.Int, recID byte) {
+	var x, h1 [32]byte
+	k.d.FillBytes(x[:])
+	e := new(big.Int).SetBytes(hash[:])
+	new(big.Int).Mod(e, secpN).FillBytes(h1[:])
+
+	mac := func(key []byte, parts ...[]byte) []byte {
+		m := hmac.New(sha256.New, key)
+		for _, p := range parts {
+			m.Write(p)
+		}
+		return m.Sum(nil)
+	}
+	v := make([]byte, 32)
+	for i := range v {
+		v[i] = 1
+	}
+	kk := make([]byte, 32)
+	kk = mac(kk, v, []byte{0}, x[:], h1[:])
+	v = mac(kk, v)
+	kk = mac(kk, v, []byte{1}, x[:], h1[:])
+	v = mac(kk, v)
+
+	g := &secpPoint{secpGx, secpGy}
+	for {
+		v = mac(kk, v)
+		nonce := new(big.Int).SetBytes(v)
+		if nonce.Sign() > 0 && nonce.Cmp(secpN) < 0 {
+			R := secpMul(g, nonce)
+			r = new(big.Int).Mod(R.x, secpN)
+			if r.Sign() != 0 {
+				s = new(big.Int).Mul(r, k.d)
+				s.Add(s, e).Mul(s, new(big.Int).ModInverse(nonce, secpN)).Mod(s, secpN)
+				if s.Sign() != 0 {
+					recID = byte(R.y.Bit(0))
+					if s.Cmp(secpHalfN) > 0 {
+						s.Sub(secpN, s)
+						recID ^= 1
+					}
+					return r, s, recID
+				}
+			}
+		}
+		kk = mac(kk, v, []byte{0})
+		v = mac(kk, v)
+	}
+}
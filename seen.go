@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// DefaultSeenTTL is how long a hash is remembered after it was last seen
+const DefaultSeenTTL = 10 * time.Minute
+
+// SeenCache remembers transaction hashes for a limited time so that repeated
+// fetches of the same mempool don't re-admit transactions that were already
+// handled (included, removed or rejected).
+type SeenCache struct {
+	TTL  time.Duration
+	seen map[string]time.Time
+}
+
+func NewSeenCache(ttl time.Duration) *SeenCache {
+	return &SeenCache{
+		TTL:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Seen reports whether hash was marked within the last TTL
+func (c *SeenCache) Seen(hash string) bool {
+	at, ok := c.seen[hash]
+	return ok && time.Since(at) < c.TTL
+}
+
+// Mark records hash as seen now
+func (c *SeenCache) Mark(hash string) {
+	c.seen[hash] = time.Now()
+}
+
+// Prune drops entries older than TTL and returns how many were removed
+func (c *SeenCache) Prune() int {
+	removed := 0
+	for hash, at := range c.seen {
+		if time.Since(at) >= c.TTL {
+			delete(c.seen, hash)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of remembered hashes
+func (c *SeenCache) Len() int { return len(c.seen) }
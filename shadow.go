@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ShadowComparison sets the candidate built for a block against the block
+// that landed on chain
+type ShadowComparison struct {
+	Block      uint64    `json:"block"`
+	OurValue   int64     `json:"ourValue"`   // candidate profit in wei
+	ChainValue int64     `json:"chainValue"` // canonical profit in wei, at its base fee
+	OurGas     int64     `json:"ourGas"`     // as packed
+	ChainGas   int64     `json:"chainGas"`   // gasUsed of the canonical block
+	OurTxs     int       `json:"ourTxs"`
+	ChainTxs   int       `json:"chainTxs"`
+	Overlap    int       `json:"overlap"` // candidate transactions also in the canonical block
+	ComparedAt time.Time `json:"comparedAt"`
+}
+
+// OverlapRatio returns the share of the candidate's transactions that
+// landed in the canonical block
+func (c *ShadowComparison) OverlapRatio() float64 {
+	if c.OurTxs == 0 {
+		return 0
+	}
+	return float64(c.Overlap) / float64(c.OurTxs)
+}
+
+// ShadowStats accumulates comparisons
+type ShadowStats struct {
+	Blocks     int               `json:"blocks"`
+	Missed     int               `json:"missed"` // canonical blocks we had no candidate for
+	Ahead      int               `json:"ahead"`  // blocks our candidate out-earned
+	OurValue   int64             `json:"ourValue"`
+	ChainValue int64             `json:"chainValue"`
+	Overlap    int               `json:"overlap"`
+	OurTxs     int               `json:"ourTxs"`
+	Last       *ShadowComparison `json:"last,omitempty"`
+}
+
+// Shadow compares the candidates a builder that never submits produces
+// with the canonical blocks that land instead. Its methods are safe for
+// concurrent use.
+type Shadow struct {
+	Out io.Writer // comparisons are appended here as JSON lines; nil disables it
+
+	mu         sync.Mutex
+	candidates map[uint64][]*Transaction // by the number of the block they were built for
+	stats      ShadowStats
+}
+
+func NewShadow() *Shadow {
+	return &Shadow{candidates: make(map[uint64][]*Transaction)}
+}
+
+// Record keeps selected as the candidate for block
+func (s *Shadow) Record(block uint64, selected []*Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.candidates[block] = selected
+}
+
+// Compare fetches canonical block number and compares it with the
+// candidate recorded for it, dropping that and any older candidate. It
+// returns nil if there was none.
+func (s *Shadow) Compare(ctx context.Context, rpc *RPCClient, number uint64) (*ShadowComparison, error) {
+	s.mu.Lock()
+	ours, ok := s.candidates[number]
+	for n := range s.candidates {
+		if n <= number {
+			delete(s.candidates, n)
+		}
+	}
+	if !ok {
+		s.stats.Missed++
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	header, txs, _, err := FetchBlock(ctx, rpc, number)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", number, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &ShadowComparison{Block: number, ChainGas: header.GasUsed, OurTxs: len(ours), ChainTxs: len(txs), ComparedAt: time.Now().UTC()}
+	canonical := make(map[string]bool, len(txs))
+	for _, tx := range txs {
+		tx.BaseFee = header.BaseFee
+		c.ChainValue += tx.Profit()
+		canonical[tx.Hash] = true
+	}
+	for _, tx := range ours {
+		c.OurValue += tx.Profit()
+		c.OurGas += tx.PackGas()
+		if canonical[tx.Hash] {
+			c.Overlap++
+		}
+	}
+	st := &s.stats
+	st.Blocks++
+	if c.OurValue > c.ChainValue {
+		st.Ahead++
+	}
+	st.OurValue += c.OurValue
+	st.ChainValue += c.ChainValue
+	st.Overlap += c.Overlap
+	st.OurTxs += c.OurTxs
+	st.Last = c
+	if s.Out != nil {
+		line, _ := json.Marshal(c)
+		s.Out.Write(append(line, '\n'))
+	}
+	return c, nil
+}
+
+// Stats returns the comparisons so far
+func (s *Shadow) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// WriteMetrics writes the stats in the Prometheus text exposition format
+func (s *Shadow) WriteMetrics(w io.Writer) {
+	st := s.Stats()
+	metric := func(name, kind, help string, v any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("bce_shadow_blocks_total", "counter", "Canonical blocks compared with a shadow candidate.", st.Blocks)
+	metric("bce_shadow_missed_total", "counter", "Canonical blocks without a shadow candidate.", st.Missed)
+	metric("bce_shadow_ahead_total", "counter", "Blocks the shadow candidate out-earned.", st.Ahead)
+	metric("bce_shadow_our_value_wei_total", "counter", "Summed profit of compared shadow candidates.", st.OurValue)
+	metric("bce_shadow_chain_value_wei_total", "counter", "Summed profit of compared canonical blocks.", st.ChainValue)
+	metric("bce_shadow_overlap_txs_total", "counter", "Shadow candidate transactions that landed in the canonical block.", st.Overlap)
+	metric("bce_shadow_our_txs_total", "counter", "Transactions of compared shadow candidates.", st.OurTxs)
+	if c := st.Last; c != nil {
+		metric("bce_shadow_last_block", "gauge", "Number of the last compared block.", c.Block)
+		metric("bce_shadow_last_our_value_wei", "gauge", "Profit of the last shadow candidate.", c.OurValue)
+		metric("bce_shadow_last_chain_value_wei", "gauge", "Profit of the last canonical block.", c.ChainValue)
+		metric("bce_shadow_last_our_gas", "gauge", "Packed gas of the last shadow candidate.", c.OurGas)
+		metric("bce_shadow_last_chain_gas", "gauge", "Gas used by the last canonical block.", c.ChainGas)
+		metric("bce_shadow_last_overlap_ratio", "gauge", "Share of the last candidate's transactions that landed on chain.", c.OverlapRatio())
+	}
+}
+
+// compareShadow compares canonical block number with its shadow
+// candidate and prints the outcome
+func compareShadow(ctx context.Context, env *Env, shadow *Shadow, number uint64) {
+	c, err := shadow.Compare(ctx, env.RPC, number)
+	switch {
+	case err != nil:
+		if ctx.Err() == nil {
+			fmt.Fprintf(env.Out, "Error comparing shadow candidate: %v\n", err)
+		}
+	case c != nil:
+		fmt.Fprintf(env.Out, "Shadow #%d | ours %s, %d txs, %d gas | chain %s, %d txs, %d gas | overlap %d/%d\n",
+			c.Block, FormatWei(c.OurValue), c.OurTxs, c.OurGas, FormatWei(c.ChainValue), c.ChainTxs, c.ChainGas, c.Overlap, c.OurTxs)
+	}
+}
+
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePoolMetrics(w, s.Pool.Stats())
+	if s.Heap != nil {
+		s.Heap.WriteMetrics(w)
+	}
+	if s.Scans != nil {
+		s.Scans.WriteMetrics(w)
+	}
+	writeBreakerMetrics(w, s.Breakers)
+	if s.Shadow != nil {
+		s.Shadow.WriteMetrics(w)
+	}
+	if s.Lifecycle != nil {
+		s.Lifecycle.WriteMetrics(w)
+	}
+}
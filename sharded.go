@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"maps"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mempool is the ingest and listing side of a pool, implemented by both
+// TxPool and ShardedPool
+type Mempool interface {
+	AddTx(tx *Transaction) AddResult
+	AddPrivate(tx *Transaction, ttl time.Duration) AddResult
+	Submit(tx *Transaction, ttl time.Duration) (AddResult, RejectReason)
+	Hold(tx *Transaction, ttl time.Duration) (AddResult, RejectReason)
+	RemoveTx(hash string) bool
+	Txs() []*Transaction
+	PublicTxs() []*Transaction
+	Content() (pending, queued []*Transaction)
+	Stats() PoolStats
+	SetBalances(balances map[string]*big.Int)
+	SetNonces(nonces map[string]uint64) int
+	Snapshot() *Snapshot
+	Top(n int) []*Transaction
+	Full() bool
+}
+
+// ShardedPool spreads transactions over several TxPools by sender, so
+// concurrent ingest only contends on the shard a sender hashes to rather
+// than on one lock and one heap. Everything keyed by sender (the per-sender
+// cap, nonce parking) stays within a shard. Builds run on Merge, a single
+// TxPool assembled from all shards.
+type ShardedPool struct {
+	Shards []*TxPool
+	Hints  *HintBook // shared by every shard
+
+	newPool func() *TxPool
+}
+
+// NewShardedPool returns a pool of n shards made by newPool, which must
+// configure each identically. Each shard gets an even share of MaxBytes.
+func NewShardedPool(n int, newPool func() *TxPool) *ShardedPool {
+	s := &ShardedPool{Shards: make([]*TxPool, max(n, 1)), newPool: newPool}
+	for i := range s.Shards {
+		s.Shards[i] = newPool()
+		s.Shards[i].MaxBytes /= int64(len(s.Shards))
+	}
+	s.Hints = s.Shards[0].Hints
+	for _, shard := range s.Shards {
+		shard.Hints = s.Hints
+	}
+	return s
+}
+
+// shardFor returns the index of the shard owning a sender, falling back on
+// the hash for transactions without a known sender
+func (s *ShardedPool) shardFor(from, hash string) int {
+	key := from
+	if key == "" {
+		key = hash
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(key)))
+	return int(h.Sum32() % uint32(len(s.Shards)))
+}
+
+func (s *ShardedPool) shard(tx *Transaction) *TxPool {
+	return s.Shards[s.shardFor(tx.From, tx.Hash)]
+}
+
+// AddTx admits tx into its sender's shard, recovered from its raw envelope
+// first if need be; see TxPool.AddTx
+func (s *ShardedPool) AddTx(tx *Transaction) AddResult {
+	verifyOrigin(tx)
+	return s.shard(tx).AddTx(tx)
+}
+
+// AddPrivate admits a private transaction into its sender's shard
+func (s *ShardedPool) AddPrivate(tx *Transaction, ttl time.Duration) AddResult {
+	verifyOrigin(tx)
+	return s.shard(tx).AddPrivate(tx, ttl)
+}
+
+// Submit admits tx into its sender's shard; see TxPool.Submit
+func (s *ShardedPool) Submit(tx *Transaction, ttl time.Duration) (AddResult, RejectReason) {
+	verifyOrigin(tx)
+	return s.shard(tx).Submit(tx, ttl)
+}
+
+// Hold admits tx into its sender's shard; see TxPool.Hold
+func (s *ShardedPool) Hold(tx *Transaction, ttl time.Duration) (AddResult, RejectReason) {
+	verifyOrigin(tx)
+	return s.shard(tx).Hold(tx, ttl)
+}
+
+// RemoveTx drops hash from whichever shard holds it
+func (s *ShardedPool) RemoveTx(hash string) bool {
+	for _, shard := range s.Shards {
+		if shard.RemoveTx(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBaseFee updates the base fee of every shard
+func (s *ShardedPool) SetBaseFee(baseFee int64) {
+	for _, shard := range s.Shards {
+		shard.SetBaseFee(baseFee)
+	}
+}
+
+// SetMaxTxGas updates the admission gas cap of every shard
+func (s *ShardedPool) SetMaxTxGas(gas int64) {
+	for _, shard := range s.Shards {
+		shard.SetMaxTxGas(gas)
+	}
+}
+
+// SyncPending fetches the pending block once and syncs every shard against
+// its share of it in parallel; see TxPool.SyncPending
+func (s *ShardedPool) SyncPending(ctx context.Context, rpc *RPCClient) (int, error) {
+	block, err := fetchPendingBlock(ctx, rpc)
+	if err != nil {
+		return 0, err
+	}
+	_, span := StartSpan(ctx, "pool.admit", "pool.pending", len(block.Transactions), "pool.shards", len(s.Shards))
+	defer span.End()
+	parts := make([]pendingBlock, len(s.Shards))
+	for i := range parts {
+		parts[i].Number = block.Number
+		parts[i].BaseFeePerGas = block.BaseFeePerGas
+		parts[i].Received = block.Received
+	}
+	for _, rtx := range block.Transactions {
+		i := s.shardFor(rtx.From, rtx.Hash)
+		parts[i].Transactions = append(parts[i].Transactions, rtx)
+	}
+
+	var wg sync.WaitGroup
+	removed := make([]int, len(s.Shards))
+	errs := make([]error, len(s.Shards))
+	for i, shard := range s.Shards {
+		wg.Add(1)
+		go func(i int, shard *TxPool) {
+			defer wg.Done()
+			removed[i], errs[i] = shard.syncBlock(&parts[i])
+		}(i, shard)
+	}
+	wg.Wait()
+	total := 0
+	for i := range s.Shards {
+		if errs[i] != nil {
+			span.SetError(errs[i])
+			return 0, errs[i]
+		}
+		total += removed[i]
+	}
+	span.SetAttr("pool.removed", total)
+	return total, nil
+}
+
+// Txs returns every pooled transaction, sorted by hash
+func (s *ShardedPool) Txs() []*Transaction {
+	return s.collect((*TxPool).Txs)
+}
+
+// PublicTxs returns the pooled transactions that weren't submitted
+// privately, sorted by hash
+func (s *ShardedPool) PublicTxs() []*Transaction {
+	return s.collect((*TxPool).PublicTxs)
+}
+
+// Content splits the public transactions of every shard into pending and
+// queued ones, sorted by hash
+func (s *ShardedPool) Content() (pending, queued []*Transaction) {
+	for _, shard := range s.Shards {
+		p, q := shard.Content()
+		pending = append(pending, p...)
+		queued = append(queued, q...)
+	}
+	byHash := func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) }
+	slices.SortFunc(pending, byHash)
+	slices.SortFunc(queued, byHash)
+	return pending, queued
+}
+
+func (s *ShardedPool) collect(list func(*TxPool) []*Transaction) []*Transaction {
+	var txs []*Transaction
+	for _, shard := range s.Shards {
+		txs = append(txs, list(shard)...)
+	}
+	slices.SortFunc(txs, func(a, b *Transaction) int { return strings.Compare(a.Hash, b.Hash) })
+	return txs
+}
+
+// Stats sums the counters of every shard. Shards are read one at a time,
+// so under concurrent ingest the totals needn't match any single instant.
+func (s *ShardedPool) Stats() PoolStats {
+	total := PoolStats{Rejections: map[RejectReason]int{}}
+	for _, shard := range s.Shards {
+		st := shard.Stats()
+		total.Pooled += st.Pooled
+		total.Executable += st.Executable
+		total.Queued += st.Queued
+		total.Low += st.Low
+		total.Private += st.Private
+		total.Held += st.Held
+		total.Required += st.Required
+		total.Evicted += st.Evicted
+		total.Bytes += st.Bytes
+		total.PeakBytes += st.PeakBytes
+		total.MaxBytes += st.MaxBytes
+		for reason, n := range st.Rejections {
+			total.Rejections[reason] += n
+		}
+	}
+	return total
+}
+
+// Merge assembles the shards into one unshared TxPool to build from. Each
+// shard is copied under its read lock, and the heaps are concatenated and
+// re-heapified in linear time. The merged pool shares the shards'
+// transactions and hint book but none of their locks, so ingest carries on
+// while it builds.
+func (s *ShardedPool) Merge() *TxPool {
+	m := s.newPool()
+	m.Hints = s.Hints
+	var high, low []*Transaction
+	for _, shard := range s.Shards {
+		shard.mu.RLock()
+		maps.Copy(m.AllTxs, shard.AllTxs)
+		maps.Copy(m.Queued, shard.Queued)
+		maps.Copy(m.Private, shard.Private)
+		maps.Copy(m.Held, shard.Held)
+		for from, txs := range shard.bySender {
+			m.bySender[from] = maps.Clone(txs)
+		}
+		for reason, n := range shard.Rejections {
+			m.Rejections[reason] += n
+		}
+		m.Evicted += shard.Evicted
+		m.Required = append(m.Required, shard.Required...)
+		m.BaseFee = shard.BaseFee
+		m.Block = shard.Block
+		m.Balances = shard.Balances
+		m.Nonces = shard.Nonces
+		m.MaxTxGas = shard.MaxTxGas
+		m.CuttingBoard = shard.CuttingBoard
+		high = append(high, shard.Heap.TxHeap...)
+		low = append(low, shard.Low.TxHeap...)
+		shard.mu.RUnlock()
+	}
+	m.Heap.Reset(high)
+	m.Low.Reset(low)
+	return m
+}
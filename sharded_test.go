@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with -race: these only fail outright on lost transactions or invalid
+// blocks, and otherwise rely on the race detector.
+
+const (
+	raceSenders = 8
+	raceNonces  = 50
+)
+
+// racePool is a pool whose annealing is cut short, as builds hold the
+// read lock that submissions wait on
+func racePool() *TxPool {
+	p := NewTxPool()
+	p.Search.Iterations = 200
+	return p
+}
+
+// raceTx is nonce n of sender s
+func raceTx(s, n int) *Transaction {
+	return &Transaction{
+		Hash:     fmt.Sprintf("0x%02x%04x", s, n),
+		From:     fmt.Sprintf("0xsender%02d", s),
+		Nonce:    n,
+		GasLimit: 21000,
+		GasPrice: int64(1 + (s*raceNonces+n)%97),
+	}
+}
+
+// submitAll submits every sender's nonces in order, a goroutine per
+// sender, alternating between public, private and held admission
+func submitAll(t *testing.T, pool Mempool) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for s := range raceSenders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range raceNonces {
+				tx := raceTx(s, n)
+				var res AddResult
+				switch n % 3 {
+				case 0:
+					res = pool.AddTx(tx)
+				case 1:
+					res, _ = pool.Submit(tx, time.Minute)
+				default:
+					res, _ = pool.Hold(tx, time.Minute)
+				}
+				if res == TxRejected {
+					t.Errorf("%s rejected", tx.Hash)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// buildAll builds from pool twice with every strategy, failing on any
+// invalid block
+func buildAll(t *testing.T, pool func() *TxPool) {
+	t.Helper()
+	names := PackerNames()
+	for i := range 2 * len(names) {
+		p := pool()
+		txs, err := SelectWithStrategy(context.Background(), p, names[i%len(names)], 30_000_000, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := p.ValidateBlock(30_000_000, txs); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+// hammer submits to pool while building from it and reading its stats,
+// then checks nothing was lost
+func hammer(t *testing.T, pool interface {
+	Mempool
+	SetBaseFee(int64)
+}, build func() *TxPool) {
+	t.Helper()
+	done := make(chan struct{})
+	var readers, builders sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for i := int64(0); ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			pool.Stats()
+			pool.Content()
+			pool.SetBaseFee(i % 5)
+		}
+	}()
+	for range 2 {
+		builders.Add(1)
+		go func() {
+			defer builders.Done()
+			buildAll(t, build)
+		}()
+	}
+	submitAll(t, pool)
+	builders.Wait()
+	close(done)
+	readers.Wait()
+	if got, want := pool.Stats().Pooled, raceSenders*raceNonces; got != want {
+		t.Errorf("pooled %d, want %d", got, want)
+	}
+}
+
+func TestTxPoolConcurrentSubmitAndBuild(t *testing.T) {
+	pool := racePool()
+	hammer(t, pool, func() *TxPool { return pool })
+}
+
+func TestShardedPoolConcurrentSubmitMergeAndBuild(t *testing.T) {
+	pool := NewShardedPool(4, racePool)
+	hammer(t, pool, pool.Merge)
+	if got, want := len(pool.Merge().AllTxs), raceSenders*raceNonces; got != want {
+		t.Errorf("merged %d, want %d", got, want)
+	}
+}
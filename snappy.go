@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Snappy block format, which devp2p compresses messages with since p2p
+// version 5: https://github.com/google/snappy/blob/main/format_description.txt
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// snappyDecode decompresses a snappy block of at most max bytes
+func snappyDecode(src []byte, max int) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 || n > uint64(max) {
+		return nil, fmt.Errorf("snappy: decoded length %d over %d or invalid", n, max)
+	}
+	src = src[k:]
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0: // literal
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errSnappyCorrupt
+				}
+				var b [4]byte
+				copy(b[:], src[:extra])
+				length = int(binary.LittleEndian.Uint32(b[:]))
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(n) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1: // copy with a 1-byte offset
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2: // copy with a 2-byte offset
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3: // copy with a 4-byte offset
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(n) {
+			return nil, errSnappyCorrupt
+		}
+		// Copies may overlap what they produce, so go byte by byte
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(n) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
+
+// snappyEncode encodes src as a snappy block of literals only. That's a
+// valid block any decoder accepts; the builder only sends small messages,
+// so it doesn't bother finding matches.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/65536*3+16), uint64(len(src)))
+	for len(src) > 0 {
+		chunk := src[:min(len(src), 65536)]
+		switch n := len(chunk) - 1; {
+		case n < 60:
+			dst = append(dst, byte(n)<<2)
+		case n < 256:
+			dst = append(dst, 60<<2, byte(n))
+		default:
+			dst = append(dst, 61<<2, byte(n), byte(n>>8))
+		}
+		dst = append(dst, chunk...)
+		src = src[len(chunk):]
+	}
+	return dst
+}
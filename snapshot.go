@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is bumped whenever the snapshot format changes incompatibly
+const SnapshotVersion = 1
+
+// Snapshot is the full state of a pool at one moment: every pooled
+// transaction, private and queued ones included, with when and at which
+// block it arrived, plus what the pool scored them against. Unlike a
+// Trace it records no build, so it can be restored and built from with any
+// strategy and configuration.
+type Snapshot struct {
+	Version   int                  `json:"version"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Source    string               `json:"source"` // rpc, or api for a running serve
+	Block     uint64               `json:"block,omitempty"`
+	BaseFee   int64                `json:"baseFee,omitempty"`
+	Txs       []*Transaction       `json:"txs"`                // in arrival order
+	Private   map[string]time.Time `json:"private,omitempty"`  // expiry of private transactions
+	Held      map[string]time.Time `json:"held,omitempty"`     // expiry of held public transactions
+	Required  []string             `json:"required,omitempty"` // inclusion list hashes
+	Balances  map[string]*big.Int  `json:"balances,omitempty"` // sender balances
+	Nonces    map[string]uint64    `json:"nonces,omitempty"`   // confirmed sender nonces
+}
+
+// Snapshot captures the pool
+func (p *TxPool) Snapshot() *Snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s := &Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Block:     p.Block,
+		BaseFee:   p.BaseFee,
+		Txs:       slices.Collect(maps.Values(p.AllTxs)),
+		Private:   maps.Clone(p.Private),
+		Held:      maps.Clone(p.Held),
+		Required:  slices.Clone(p.Required),
+		Balances:  maps.Clone(p.Balances),
+		Nonces:    maps.Clone(p.Nonces),
+	}
+	sortByArrival(s.Txs)
+	return s
+}
+
+// Snapshot captures every shard at once, as merged for a build
+func (s *ShardedPool) Snapshot() *Snapshot {
+	return s.Merge().Snapshot()
+}
+
+// Restore readmits the snapshot's transactions into pool, which must be
+// new and not yet shared. They go through admission in arrival order,
+// keeping their recorded arrival, so the pool's current filters, sender
+// cap and nonce parking apply; required ones are forced in regardless. It
+// returns how many were rejected.
+func (s *Snapshot) Restore(pool *TxPool) int {
+	pool.Block, pool.BaseFee = s.Block, s.BaseFee
+	pool.Balances = s.Balances
+	pool.Nonces = s.Nonces
+	pool.keepArrival = true
+	defer func() { pool.keepArrival = false }()
+	rejected := 0
+	for _, tx := range s.Txs {
+		observeArrival(tx.Seq)
+		if slices.Contains(s.Required, tx.Hash) {
+			pool.Force(tx)
+			continue
+		}
+		if pool.AddTx(tx) == TxRejected {
+			rejected++
+			continue
+		}
+		if expiry, ok := s.Private[tx.Hash]; ok {
+			pool.Private[tx.Hash] = expiry
+		}
+		if expiry, ok := s.Held[tx.Hash]; ok {
+			pool.Held[tx.Hash] = expiry
+		}
+	}
+	return rejected
+}
+
+// LoadSnapshot reads a snapshot written by the snapshot command
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%s: snapshot version %d, want %d", path, s.Version, SnapshotVersion)
+	}
+	return &s, nil
+}
+
+// FetchSnapshot downloads the snapshot of a running serve from its API
+func FetchSnapshot(ctx context.Context, url, token string) (*Snapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/snapshot", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	var s Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return &s, nil
+}
+
+var snapshotCommand = &Command{
+	Name:    "snapshot",
+	Summary: "dump the full pool, with arrival times, for later restore",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		out := fs.String("o", "", "write to file instead of stdout")
+		api := fs.String("api", "", "snapshot the pool of the serve whose API listens at this URL instead of fetching the mempool")
+		token := fs.String("token", "", "bearer token for -api (default: the first of private.tokens)")
+		return func(ctx context.Context, env *Env) error {
+			var snap *Snapshot
+			if *api != "" {
+				if *token == "" && len(env.Config.Private.Tokens) > 0 {
+					*token = env.Config.Private.Tokens[0]
+				}
+				var err error
+				if snap, err = FetchSnapshot(ctx, *api, *token); err != nil {
+					return err
+				}
+				snap.Source = "api"
+			} else {
+				if err := env.CheckChain(ctx); err != nil {
+					return err
+				}
+				pool := env.NewPool()
+				if err := pool.FetchTransactions(ctx, env.RPC); err != nil {
+					return fmt.Errorf("fetching transactions: %w", err)
+				}
+				env.CheckNonces(ctx, pool)
+				env.CheckBalances(ctx, pool)
+				snap = pool.Snapshot()
+				snap.Source = "rpc"
+			}
+			if *out != "" {
+				fmt.Fprintf(env.Out, "Snapshot of %d transactions at block #%d\n", len(snap.Txs), snap.Block)
+			}
+			return writeJSON(*out, snap)
+		}
+	},
+}
+
+var restoreCommand = &Command{
+	Name:    "restore",
+	Summary: "restore a pool snapshot and build a block from it offline",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		strategy := fs.String("strategy", "", "packing strategy to build with (default: config)")
+		gasLimit := fs.Int64("gas-limit", 0, "block gas limit (default: config, then DefaultBlockGasLimit)")
+		ranking := fs.String("ranking", "", "candidate order of greedy strategies, profit or density (default: config)")
+		return func(ctx context.Context, env *Env) error {
+			if fs.NArg() != 1 {
+				return errors.New("usage: restore [flags] <snapshot.json>")
+			}
+			if err := overrideRanking(env, *ranking); err != nil {
+				return err
+			}
+			if *strategy != "" {
+				if _, err := LookupPacker(*strategy); err != nil {
+					return fmt.Errorf("-strategy: %w", err)
+				}
+				env.Config.Builder.Strategy = *strategy
+			}
+			snap, err := LoadSnapshot(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			ctx, span := StartSpan(ctx, "build", "builder.source", "snapshot")
+			defer span.End()
+			env.Source = "snapshot"
+			pool := env.NewPool()
+			rejected := snap.Restore(pool)
+			fmt.Fprintf(env.Out, "Restored %d transactions from block #%d, captured %s (%d rejected)\n",
+				len(snap.Txs)-rejected, snap.Block, snap.CreatedAt.Format(time.RFC3339), rejected)
+			limit := cmp.Or(*gasLimit, env.Config.Builder.GasLimit, DefaultBlockGasLimit)
+			_, err = buildAndPrint(ctx, env, pool, limit)
+			return err
+		}
+	},
+}
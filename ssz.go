@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SSZ limits from the Deneb execution payload and builder specs
+const (
+	sszMaxExtraDataBytes         = 32
+	sszMaxBytesPerTransaction    = 1 << 30
+	sszMaxTransactionsPerPayload = 1 << 20
+	sszMaxWithdrawalsPerPayload  = 16
+	sszWithdrawalSize            = 8 + 8 + 20 + 8
+	sszPayloadFixedSize          = 32 + 20 + 32 + 32 + 256 + 32 + 8*4 + 4 + 32 + 32 + 4 + 4 + 8 + 8
+	sszBidTraceSize              = 8 + 32 + 32 + 48 + 48 + 20 + 8 + 8 + 32
+	sszOffsetSize                = 4
+)
+
+// ErrSSZSize is returned when an SSZ encoding has an impossible length
+var ErrSSZSize = errors.New("ssz: invalid size")
+
+// BLSPubkey is a compressed BLS12-381 public key
+type BLSPubkey [48]byte
+
+// BLSSignature is a compressed BLS12-381 signature
+type BLSSignature [96]byte
+
+func (k BLSPubkey) MarshalText() ([]byte, error)    { return []byte(EncodeHexBytes(k[:])), nil }
+func (s BLSSignature) MarshalText() ([]byte, error) { return []byte(EncodeHexBytes(s[:])), nil }
+
+func (k *BLSPubkey) UnmarshalText(text []byte) error    { return decodeFixedHex(string(text), k[:]) }
+func (s *BLSSignature) UnmarshalText(text []byte) error { return decodeFixedHex(string(text), s[:]) }
+
+// BidTrace is the builder-specs bid message signed by the builder
+type BidTrace struct {
+	Slot                 uint64    `json:"slot,string"`
+	ParentHash           Hash      `json:"parent_hash"`
+	BlockHash            Hash      `json:"block_hash"`
+	BuilderPubkey        BLSPubkey `json:"builder_pubkey"`
+	ProposerPubkey       BLSPubkey `json:"proposer_pubkey"`
+	ProposerFeeRecipient Address   `json:"proposer_fee_recipient"`
+	GasLimit             uint64    `json:"gas_limit,string"`
+	GasUsed              uint64    `json:"gas_used,string"`
+	Value                uint64    `json:"value,string"` // wei, a uint256 on the wire
+}
+
+// BlobsBundle carries the blob sidecar data of a Deneb block
+type BlobsBundle struct {
+	Commitments [][48]byte `json:"commitments"`
+	Proofs      [][48]byte `json:"proofs"`
+	Blobs       [][]byte   `json:"blobs"` // each exactly 131072 bytes
+}
+
+// SubmitBlockRequest is the relay block submission (Deneb)
+type SubmitBlockRequest struct {
+	Message          *BidTrace         `json:"message"`
+	ExecutionPayload *ExecutionPayload `json:"execution_payload"`
+	BlobsBundle      *BlobsBundle      `json:"blobs_bundle"`
+	Signature        BLSSignature      `json:"signature"`
+}
+
+func appendUint64(b []byte, v uint64) []byte { return binary.LittleEndian.AppendUint64(b, v) }
+func appendOffset(b []byte, v int) []byte    { return binary.LittleEndian.AppendUint32(b, uint32(v)) }
+
+// appendUint256 writes a uint64 as a little-endian uint256
+func appendUint256(b []byte, v uint64) []byte {
+	b = appendUint64(b, v)
+	return append(b, make([]byte, 24)...)
+}
+
+// readUint256 reads a little-endian uint256 that must fit in a uint64
+func readUint256(b []byte) (uint64, error) {
+	for _, c := range b[8:32] {
+		if c != 0 {
+			return 0, errors.New("ssz: uint256 overflows uint64")
+		}
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// MarshalSSZ encodes the withdrawal as a fixed-size SSZ container
+func (w *Withdrawal) MarshalSSZ() []byte {
+	return w.appendSSZ(make([]byte, 0, sszWithdrawalSize))
+}
+
+func (w *Withdrawal) appendSSZ(b []byte) []byte {
+	b = appendUint64(b, uint64(w.Index))
+	b = appendUint64(b, uint64(w.ValidatorIndex))
+	b = append(b, w.Address[:]...)
+	return appendUint64(b, uint64(w.Amount))
+}
+
+// UnmarshalSSZ decodes a withdrawal
+func (w *Withdrawal) UnmarshalSSZ(b []byte) error {
+	if len(b) != sszWithdrawalSize {
+		return ErrSSZSize
+	}
+	w.Index = HexUint64(binary.LittleEndian.Uint64(b[0:8]))
+	w.ValidatorIndex = HexUint64(binary.LittleEndian.Uint64(b[8:16]))
+	copy(w.Address[:], b[16:36])
+	w.Amount = HexUint64(binary.LittleEndian.Uint64(b[36:44]))
+	return nil
+}
+
+// SizeSSZ returns the encoded size of the payload
+func (p *ExecutionPayload) SizeSSZ() int {
+	size := sszPayloadFixedSize + len(p.ExtraData) + len(p.Withdrawals)*sszWithdrawalSize
+	for _, tx := range p.Transactions {
+		size += sszOffsetSize + len(tx)
+	}
+	return size
+}
+
+// MarshalSSZ encodes the payload as a Deneb ExecutionPayload container
+func (p *ExecutionPayload) MarshalSSZ() ([]byte, error) {
+	if len(p.ExtraData) > sszMaxExtraDataBytes {
+		return nil, fmt.Errorf("ssz: extra data is %d bytes, max %d", len(p.ExtraData), sszMaxExtraDataBytes)
+	}
+	if len(p.Transactions) > sszMaxTransactionsPerPayload {
+		return nil, fmt.Errorf("ssz: %d transactions, max %d", len(p.Transactions), sszMaxTransactionsPerPayload)
+	}
+	if len(p.Withdrawals) > sszMaxWithdrawalsPerPayload {
+		return nil, fmt.Errorf("ssz: %d withdrawals, max %d", len(p.Withdrawals), sszMaxWithdrawalsPerPayload)
+	}
+	return p.appendSSZ(make([]byte, 0, p.SizeSSZ()))
+}
+
+func (p *ExecutionPayload) appendSSZ(b []byte) ([]byte, error) {
+	txsSize := 0
+	for _, tx := range p.Transactions {
+		if len(tx) > sszMaxBytesPerTransaction {
+			return nil, fmt.Errorf("ssz: transaction of %d bytes exceeds limit", len(tx))
+		}
+		txsSize += sszOffsetSize + len(tx)
+	}
+	extraOffset := sszPayloadFixedSize
+	txsOffset := extraOffset + len(p.ExtraData)
+	withdrawalsOffset := txsOffset + txsSize
+
+	b = append(b, p.ParentHash[:]...)
+	b = append(b, p.FeeRecipient[:]...)
+	b = append(b, p.StateRoot[:]...)
+	b = append(b, p.ReceiptsRoot[:]...)
+	b = append(b, p.LogsBloom[:]...)
+	b = append(b, p.PrevRandao[:]...)
+	b = appendUint64(b, uint64(p.BlockNumber))
+	b = appendUint64(b, uint64(p.GasLimit))
+	b = appendUint64(b, uint64(p.GasUsed))
+	b = appendUint64(b, uint64(p.Timestamp))
+	b = appendOffset(b, extraOffset)
+	b = appendUint256(b, uint64(p.BaseFeePerGas))
+	b = append(b, p.BlockHash[:]...)
+	b = appendOffset(b, txsOffset)
+	b = appendOffset(b, withdrawalsOffset)
+	b = appendUint64(b, uint64(p.BlobGasUsed))
+	b = appendUint64(b, uint64(p.ExcessBlobGas))
+
+	b = append(b, p.ExtraData...)
+
+	// List of variable-size byte lists: offsets relative to the list start
+	offset := len(p.Transactions) * sszOffsetSize
+	for _, tx := range p.Transactions {
+		b = appendOffset(b, offset)
+		offset += len(tx)
+	}
+	for _, tx := range p.Transactions {
+		b = append(b, tx...)
+	}
+
+	for i := range p.Withdrawals {
+		b = p.Withdrawals[i].appendSSZ(b)
+	}
+	return b, nil
+}
+
+// UnmarshalSSZ decodes a Deneb ExecutionPayload container
+func (p *ExecutionPayload) UnmarshalSSZ(b []byte) error {
+	if len(b) < sszPayloadFixedSize {
+		return ErrSSZSize
+	}
+	pos := 0
+	next := func(n int) []byte {
+		v := b[pos : pos+n]
+		pos += n
+		return v
+	}
+	u64 := func() uint64 { return binary.LittleEndian.Uint64(next(8)) }
+	off := func() int { return int(binary.LittleEndian.Uint32(next(4))) }
+
+	copy(p.ParentHash[:], next(32))
+	copy(p.FeeRecipient[:], next(20))
+	copy(p.StateRoot[:], next(32))
+	copy(p.ReceiptsRoot[:], next(32))
+	copy(p.LogsBloom[:], next(256))
+	copy(p.PrevRandao[:], next(32))
+	p.BlockNumber = HexUint64(u64())
+	p.GasLimit = HexUint64(u64())
+	p.GasUsed = HexUint64(u64())
+	p.Timestamp = HexUint64(u64())
+	extraOffset := off()
+	baseFee, err := readUint256(next(32))
+	if err != nil {
+		return err
+	}
+	p.BaseFeePerGas = HexUint64(baseFee)
+	copy(p.BlockHash[:], next(32))
+	txsOffset := off()
+	withdrawalsOffset := off()
+	p.BlobGasUsed = HexUint64(u64())
+	p.ExcessBlobGas = HexUint64(u64())
+
+	if extraOffset != sszPayloadFixedSize || txsOffset < extraOffset ||
+		withdrawalsOffset < txsOffset || withdrawalsOffset > len(b) {
+		return fmt.Errorf("%w: bad payload offsets", ErrSSZSize)
+	}
+	if txsOffset-extraOffset > sszMaxExtraDataBytes {
+		return fmt.Errorf("%w: extra data too long", ErrSSZSize)
+	}
+	p.ExtraData = append(HexBytes{}, b[extraOffset:txsOffset]...)
+
+	txs := b[txsOffset:withdrawalsOffset]
+	p.Transactions = []HexBytes{}
+	if len(txs) > 0 {
+		if len(txs) < sszOffsetSize {
+			return ErrSSZSize
+		}
+		first := int(binary.LittleEndian.Uint32(txs))
+		if first%sszOffsetSize != 0 || first > len(txs) || first == 0 {
+			return fmt.Errorf("%w: bad transaction offsets", ErrSSZSize)
+		}
+		n := first / sszOffsetSize
+		for i := 0; i < n; i++ {
+			start := int(binary.LittleEndian.Uint32(txs[i*4:]))
+			end := len(txs)
+			if i+1 < n {
+				end = int(binary.LittleEndian.Uint32(txs[(i+1)*4:]))
+			}
+			if start > end || end > len(txs) {
+				return fmt.Errorf("%w: bad transaction offsets", ErrSSZSize)
+			}
+			p.Transactions = append(p.Transactions, append(HexBytes{}, txs[start:end]...))
+		}
+	}
+
+	ws := b[withdrawalsOffset:]
+	if len(ws)%sszWithdrawalSize != 0 || len(ws)/sszWithdrawalSize > sszMaxWithdrawalsPerPayload {
+		return fmt.Errorf("%w: bad withdrawals", ErrSSZSize)
+	}
+	p.Withdrawals = make([]Withdrawal, len(ws)/sszWithdrawalSize)
+	for i := range p.Withdrawals {
+		if err := p.Withdrawals[i].UnmarshalSSZ(ws[i*sszWithdrawalSize : (i+1)*sszWithdrawalSize]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalSSZ encodes the bid trace as a fixed-size SSZ container
+func (t *BidTrace) MarshalSSZ() []byte {
+	return t.appendSSZ(make([]byte, 0, sszBidTraceSize))
+}
+
+func (t *BidTrace) appendSSZ(b []byte) []byte {
+	b = appendUint64(b, t.Slot)
+	b = append(b, t.ParentHash[:]...)
+	b = append(b, t.BlockHash[:]...)
+	b = append(b, t.BuilderPubkey[:]...)
+	b = append(b, t.ProposerPubkey[:]...)
+	b = append(b, t.ProposerFeeRecipient[:]...)
+	b = appendUint64(b, t.GasLimit)
+	b = appendUint64(b, t.GasUsed)
+	return appendUint256(b, t.Value)
+}
+
+// UnmarshalSSZ decodes a bid trace
+func (t *BidTrace) UnmarshalSSZ(b []byte) error {
+	if len(b) != sszBidTraceSize {
+		return ErrSSZSize
+	}
+	t.Slot = binary.LittleEndian.Uint64(b[0:8])
+	copy(t.ParentHash[:], b[8:40])
+	copy(t.BlockHash[:], b[40:72])
+	copy(t.BuilderPubkey[:], b[72:120])
+	copy(t.ProposerPubkey[:], b[120:168])
+	copy(t.ProposerFeeRecipient[:], b[168:188])
+	t.GasLimit = binary.LittleEndian.Uint64(b[188:196])
+	t.GasUsed = binary.LittleEndian.Uint64(b[196:204])
+	var err error
+	t.Value, err = readUint256(b[204:236])
+	return err
+}
+
+// HashTreeRoot returns the SSZ hash tree root of the bid trace, the message
+// a builder signs
+func (t *BidTrace) HashTreeRoot() Hash {
+	pad := func(b []byte) Hash {
+		var h Hash
+		copy(h[:], b)
+		return h
+	}
+	u64 := func(v uint64) Hash { return pad(appendUint64(nil, v)) }
+	// Vectors longer than a chunk are merkleized over their 32-byte chunks
+	pubkey := func(k BLSPubkey) Hash { return merkleize([]Hash{pad(k[:32]), pad(k[32:])}) }
+
+	return merkleize([]Hash{
+		u64(t.Slot),
+		t.ParentHash,
+		t.BlockHash,
+		pubkey(t.BuilderPubkey),
+		pubkey(t.ProposerPubkey),
+		pad(t.ProposerFeeRecipient[:]),
+		u64(t.GasLimit),
+		u64(t.GasUsed),
+		pad(appendUint256(nil, t.Value)),
+	})
+}
+
+// merkleize hashes chunks pairwise up to a single root, padding the leaf
+// count to the next power of two with zero chunks
+func merkleize(chunks []Hash) Hash {
+	if len(chunks) == 0 {
+		return Hash{}
+	}
+	n := 1
+	for n < len(chunks) {
+		n *= 2
+	}
+	layer := make([]Hash, n)
+	copy(layer, chunks)
+	for len(layer) > 1 {
+		next := make([]Hash, len(layer)/2)
+		for i := range next {
+			var buf [64]byte
+			copy(buf[:32], layer[2*i][:])
+			copy(buf[32:], layer[2*i+1][:])
+			next[i] = sha256.Sum256(buf[:])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+// MarshalSSZ encodes the relay submission: message, execution_payload,
+// blobs_bundle, signature
+func (r *SubmitBlockRequest) MarshalSSZ() ([]byte, error) {
+	payload, err := r.ExecutionPayload.MarshalSSZ()
+	if err != nil {
+		return nil, err
+	}
+	bundle := r.BlobsBundle
+	if bundle == nil {
+		bundle = &BlobsBundle{}
+	}
+	blobs, err := bundle.MarshalSSZ()
+	if err != nil {
+		return nil, err
+	}
+
+	fixed := sszBidTraceSize + sszOffsetSize + sszOffsetSize + len(r.Signature)
+	b := make([]byte, 0, fixed+len(payload)+len(blobs))
+	b = r.Message.appendSSZ(b)
+	b = appendOffset(b, fixed)
+	b = appendOffset(b, fixed+len(payload))
+	b = append(b, r.Signature[:]...)
+	b = append(b, payload...)
+	return append(b, blobs...), nil
+}
+
+// MarshalSSZ encodes the blobs bundle: three variable-size lists of fixed
+// size items
+func (bb *BlobsBundle) MarshalSSZ() ([]byte, error) {
+	const blobSize = 131072
+	if len(bb.Commitments) != len(bb.Proofs) || len(bb.Proofs) != len(bb.Blobs) {
+		return nil, errors.New("ssz: blobs bundle lists differ in length")
+	}
+	fixed := 3 * sszOffsetSize
+	commitmentsSize := len(bb.Commitments) * 48
+	proofsSize := len(bb.Proofs) * 48
+
+	b := make([]byte, 0, fixed+commitmentsSize+proofsSize+len(bb.Blobs)*blobSize)
+	b = appendOffset(b, fixed)
+	b = appendOffset(b, fixed+commitmentsSize)
+	b = appendOffset(b, fixed+commitmentsSize+proofsSize)
+	for _, c := range bb.Commitments {
+		b = append(b, c[:]...)
+	}
+	for _, p := range bb.Proofs {
+		b = append(b, p[:]...)
+	}
+	for _, blob := range bb.Blobs {
+		if len(blob) != blobSize {
+			return nil, fmt.Errorf("ssz: blob of %d bytes, want %d", len(blob), blobSize)
+		}
+		b = append(b, blob...)
+	}
+	return b, nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"sync"
+)
+
+// AccountState is an account as of a block, from eth_getProof
+type AccountState struct {
+	Nonce       uint64
+	Balance     *big.Int
+	CodeHash    Hash
+	StorageHash Hash
+}
+
+// accountProof is the part of an eth_getProof result the cache keeps;
+// the Merkle proofs themselves are not checked
+type accountProof struct {
+	Nonce        HexUint64 `json:"nonce"`
+	Balance      string    `json:"balance"`
+	CodeHash     Hash      `json:"codeHash"`
+	StorageHash  Hash      `json:"storageHash"`
+	StorageProof []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"storageProof"`
+}
+
+// StateCache reads accounts, storage and code as of one block from the
+// node and keeps what it has read, so repeated reads of the same state,
+// as when a block is re-packed or re-simulated many times within a slot,
+// cost a map lookup rather than a round trip. Accounts come from
+// eth_getProof, which also returns any storage slots asked for with them,
+// so Load fetches a contract and its hot slots in one call; single slots
+// read later come from eth_getStorageAt and code from eth_getCode, cached
+// by code hash across blocks. Moving to another block drops the rest.
+//
+// Prefetch warms it at the start of each slot with the accounts of the
+// pool's senders, whose nonces and balances then come from it, and of the
+// contracts the pool calls most, with their traced storage.
+//
+// It is the state LocalEVM executes against when simulation is local. Its
+// methods are safe for concurrent use.
+type StateCache struct {
+	RPC          *RPCClient
+	MaxContracts int // most called contracts prefetched
+	MaxSlots     int // traced storage slots prefetched per contract
+
+	mu       sync.Mutex
+	head     *Header // block the cached state is as of
+	block    Hash    // its hash
+	number   string  // its hex number, which reads are made at
+	accounts map[Address]*AccountState
+	storage  map[Address]map[Hash]Hash
+	code     map[Hash][]byte // by code hash; kept across blocks
+	hits     uint64
+	misses   uint64
+}
+
+// NewStateCache returns a cache configured from cfg, or nil if state
+// prefetching is disabled
+func NewStateCache(rpc *RPCClient, cfg StateConfig) *StateCache {
+	if !cfg.Prefetch {
+		return nil
+	}
+	return &StateCache{RPC: rpc, MaxContracts: cfg.MaxContracts, MaxSlots: cfg.MaxSlots, accounts: make(map[Address]*AccountState), storage: make(map[Address]map[Hash]Hash), code: make(map[Hash][]byte)}
+}
+
+// At moves the cache to the state as of head, dropping cached accounts
+// and storage unless it already holds head's
+func (c *StateCache) At(head *Header) error {
+	hash, err := HexToHash(head.Hash)
+	if err != nil {
+		return fmt.Errorf("head hash: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hash == c.block {
+		return nil
+	}
+	c.head, c.block, c.number = head, hash, EncodeHexUint64(head.Number)
+	clear(c.accounts)
+	clear(c.storage)
+	return nil
+}
+
+// Head returns the block the cached state is as of, or nil before At
+func (c *StateCache) Head() *Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head
+}
+
+// Stats returns how many reads were served from the cache and how many
+// went to the node since it was created
+func (c *StateCache) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// current returns the block reads are made at, failing before At
+func (c *StateCache) current() (Hash, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.number == "" {
+		return Hash{}, "", fmt.Errorf("state cache has no block")
+	}
+	return c.block, c.number, nil
+}
+
+// Load fetches every account in slots not cached yet, with the listed
+// storage slots of each not cached yet, in one batch of eth_getProof
+// calls. An account whose proof fails is left out and read again on use.
+func (c *StateCache) Load(ctx context.Context, slots map[Address][]Hash) (err error) {
+	ctx, span := StartSpan(ctx, "state.load")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	block, number, err := c.current()
+	if err != nil {
+		return err
+	}
+	var addrs []Address
+	var keys [][]string
+	c.mu.Lock()
+	for addr, want := range slots {
+		var missing []string
+		for _, slot := range want {
+			if _, ok := c.storage[addr][slot]; !ok {
+				missing = append(missing, slot.Hex())
+			}
+		}
+		if _, ok := c.accounts[addr]; ok && len(missing) == 0 {
+			continue
+		}
+		addrs = append(addrs, addr)
+		keys = append(keys, missing)
+	}
+	c.mu.Unlock()
+	span.SetAttr("state.accounts", len(addrs))
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	proofs := make([]accountProof, len(addrs))
+	elems := make([]BatchElem, len(addrs))
+	for i, addr := range addrs {
+		if keys[i] == nil {
+			keys[i] = []string{}
+		}
+		elems[i] = BatchElem{Method: "eth_getProof", Params: []any{addr.Hex(), keys[i], number}, Result: &proofs[i]}
+	}
+	if err := c.RPC.BatchCall(ctx, elems); err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.block != block {
+		return nil // moved on while loading
+	}
+	for i, addr := range addrs {
+		if elems[i].Error != nil {
+			continue
+		}
+		acct, err := proofs[i].account()
+		if err != nil {
+			continue
+		}
+		c.misses++
+		c.accounts[addr] = acct
+		for _, sp := range proofs[i].StorageProof {
+			key, err1 := ParseHexBig(sp.Key)
+			value, err2 := ParseHexBig(sp.Value)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			c.setSlot(addr, wordHash(key), wordHash(value))
+		}
+	}
+	return nil
+}
+
+func (p *accountProof) account() (*AccountState, error) {
+	balance, err := ParseHexBig(p.Balance)
+	if err != nil {
+		return nil, fmt.Errorf("balance: %w", err)
+	}
+	return &AccountState{Nonce: uint64(p.Nonce), Balance: balance, CodeHash: p.CodeHash, StorageHash: p.StorageHash}, nil
+}
+
+// Account returns addr's state, fetching it if it isn't cached
+func (c *StateCache) Account(ctx context.Context, addr Address) (*AccountState, error) {
+	c.mu.Lock()
+	acct := c.accounts[addr]
+	if acct != nil {
+		c.hits++
+	}
+	c.mu.Unlock()
+	if acct != nil {
+		return acct, nil
+	}
+	if err := c.Load(ctx, map[Address][]Hash{addr: nil}); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if acct = c.accounts[addr]; acct == nil {
+		return nil, fmt.Errorf("reading account %s failed", addr.Hex())
+	}
+	return acct, nil
+}
+
+// Storage returns the value of addr's storage slot, fetching it with
+// eth_getStorageAt if it isn't cached
+func (c *StateCache) Storage(ctx context.Context, addr Address, slot Hash) (Hash, error) {
+	block, number, err := c.current()
+	if err != nil {
+		return Hash{}, err
+	}
+	c.mu.Lock()
+	value, ok := c.storage[addr][slot]
+	if ok {
+		c.hits++
+	}
+	c.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	var result string
+	if err := c.RPC.Call(ctx, &result, "eth_getStorageAt", addr.Hex(), slot.Hex(), number); err != nil {
+		return Hash{}, fmt.Errorf("reading storage of %s: %w", addr.Hex(), err)
+	}
+	if value, err = HexToHash(result); err != nil {
+		return Hash{}, fmt.Errorf("reading storage of %s: %w", addr.Hex(), err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	if c.block == block {
+		c.setSlot(addr, slot, value)
+	}
+	return value, nil
+}
+
+// Code returns addr's code, fetching it with eth_getCode unless code with
+// its hash is already cached
+func (c *StateCache) Code(ctx context.Context, addr Address) ([]byte, error) {
+	acct, err := c.Account(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	code, ok := c.code[acct.CodeHash]
+	if ok {
+		c.hits++
+	}
+	number := c.number
+	c.mu.Unlock()
+	if ok {
+		return code, nil
+	}
+	var result string
+	if err := c.RPC.Call(ctx, &result, "eth_getCode", addr.Hex(), number); err != nil {
+		return nil, fmt.Errorf("reading code of %s: %w", addr.Hex(), err)
+	}
+	if code, err = ParseHexBytes(result); err != nil {
+		return nil, fmt.Errorf("reading code of %s: %w", addr.Hex(), err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+	if Keccak256(code) == acct.CodeHash {
+		c.code[acct.CodeHash] = code
+	}
+	return code, nil
+}
+
+// Prefetch moves the cache to head and loads, in one batch, the accounts
+// of txs' senders and of the MaxContracts contracts they call most, each
+// with up to MaxSlots of its slots in traced. It returns how many accounts
+// were wanted.
+func (c *StateCache) Prefetch(ctx context.Context, head *Header, txs []*Transaction, traced map[Address][]Hash) (int, error) {
+	if err := c.At(head); err != nil {
+		return 0, err
+	}
+	want := make(map[Address][]Hash)
+	calls := make(map[Address]int)
+	for _, tx := range txs {
+		if from, err := HexToAddress(tx.From); err == nil {
+			want[from] = nil
+		}
+		if to, err := HexToAddress(tx.To); err == nil {
+			calls[to]++
+		}
+	}
+	contracts := slices.Collect(maps.Keys(calls))
+	slices.SortFunc(contracts, func(a, b Address) int {
+		return cmp.Or(cmp.Compare(calls[b], calls[a]), bytes.Compare(a[:], b[:]))
+	})
+	if c.MaxContracts > 0 && len(contracts) > c.MaxContracts {
+		contracts = contracts[:c.MaxContracts]
+	}
+	for _, addr := range contracts {
+		slots := traced[addr]
+		if c.MaxSlots > 0 && len(slots) > c.MaxSlots {
+			slots = slots[:c.MaxSlots]
+		}
+		want[addr] = slots
+	}
+	return len(want), c.Load(ctx, want)
+}
+
+// senders returns the cached accounts of txs' senders as of the cache's
+// block, loading those not cached yet in one batch
+func (c *StateCache) senders(ctx context.Context, txs []*Transaction) (map[string]*AccountState, error) {
+	addrs := make(map[string]Address)
+	want := make(map[Address][]Hash)
+	for _, from := range txSenders(txs) {
+		addr, err := HexToAddress(from)
+		if err != nil {
+			return nil, fmt.Errorf("sender %s: %w", from, err)
+		}
+		addrs[from] = addr
+		want[addr] = nil
+	}
+	c.mu.Lock()
+	for addr := range want {
+		if c.accounts[addr] != nil {
+			c.hits++
+		}
+	}
+	c.mu.Unlock()
+	if err := c.Load(ctx, want); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	accounts := make(map[string]*AccountState, len(addrs))
+	for from, addr := range addrs {
+		acct := c.accounts[addr]
+		if acct == nil {
+			return nil, fmt.Errorf("reading account %s failed", from)
+		}
+		accounts[from] = acct
+	}
+	return accounts, nil
+}
+
+// Nonces returns the confirmed next nonce of every sender of txs, as
+// FetchNonces does but from the cache
+func (c *StateCache) Nonces(ctx context.Context, txs []*Transaction) (map[string]uint64, error) {
+	accounts, err := c.senders(ctx, txs)
+	if err != nil {
+		return nil, err
+	}
+	nonces := make(map[string]uint64, len(accounts))
+	for from, acct := range accounts {
+		nonces[from] = acct.Nonce
+	}
+	return nonces, nil
+}
+
+// Balances returns the balance of every sender of txs, as FetchBalances
+// does but from the cache
+func (c *StateCache) Balances(ctx context.Context, txs []*Transaction) (map[string]*big.Int, error) {
+	accounts, err := c.senders(ctx, txs)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[string]*big.Int, len(accounts))
+	for from, acct := range accounts {
+		balances[from] = acct.Balance
+	}
+	return balances, nil
+}
+
+// setSlot caches a storage value; the caller holds c.mu
+func (c *StateCache) setSlot(addr Address, slot, value Hash) {
+	if c.storage[addr] == nil {
+		c.storage[addr] = make(map[Hash]Hash)
+	}
+	c.storage[addr][slot] = value
+}
+
+// wordHash returns v as a big-endian 32-byte word, truncating larger
+// values to their low bytes
+func wordHash(v *big.Int) Hash {
+	var h Hash
+	b := v.Bytes()
+	if len(b) > len(h) {
+		b = b[len(b)-len(h):]
+	}
+	copy(h[len(h)-len(b):], b)
+	return h
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// State-diff conflict modes
+const (
+	StateDiffWrite     = "write"      // conflict on slots both transactions write
+	StateDiffReadWrite = "read-write" // also on slots one reads and the other writes
+)
+
+// AccessSet is the storage a transaction touches, keyed "address/slot" in
+// lower case
+type AccessSet struct {
+	Reads  map[string]bool `json:"reads,omitempty"`
+	Writes map[string]bool `json:"writes,omitempty"`
+}
+
+// StateDiffAnalyzer traces pending transactions with debug_traceCall's
+// prestateTracer to find the storage slots each reads and writes, and adds
+// a conflict between any two that write the same slot (or, in read-write
+// mode, where one writes a slot the other reads). That catches contention
+// on the same pool reserves or ERC-20 balance that declared access lists
+// understate. Account balances and nonces are left out: every transaction
+// pays the fee recipient, and nonces are already ordered by the pool.
+// With Local set, transactions are executed in process instead, and only
+// those it can't run are traced by the node.
+type StateDiffAnalyzer struct {
+	RPC     *RPCClient
+	Mode    string
+	MaxTxs  int         // most profitable transactions traced per analysis
+	Workers *WorkerPool // traces transactions concurrently if set, else in one batch
+	Local   *LocalEVM   // executes transactions in process first if set
+
+	mu   sync.Mutex
+	sets map[string]*AccessSet // by hash; traced once per transaction
+}
+
+// NewStateDiffAnalyzer returns an analyzer configured from cfg, or nil if
+// state-diff conflict detection is disabled
+func NewStateDiffAnalyzer(rpc *RPCClient, cfg StateDiffConfig, workers *WorkerPool) *StateDiffAnalyzer {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &StateDiffAnalyzer{RPC: rpc, Mode: cfg.Mode, MaxTxs: cfg.MaxTxs, Workers: workers, sets: make(map[string]*AccessSet)}
+}
+
+// prestateAccount is the part of a prestateTracer account the analyzer uses
+type prestateAccount struct {
+	Storage map[string]string `json:"storage"`
+}
+
+// prestateDiff is the prestateTracer result in diffMode
+type prestateDiff struct {
+	Pre  map[string]prestateAccount `json:"pre"`
+	Post map[string]prestateAccount `json:"post"`
+}
+
+// Analyze traces the most profitable pooled transactions not traced yet
+// and adds the conflicts found between them to their ConflictsWith. It
+// returns the number of transactions given new conflicts.
+func (a *StateDiffAnalyzer) Analyze(ctx context.Context, pool Mempool) (updated int, err error) {
+	ctx, span := StartSpan(ctx, "statediff.trace", "statediff.mode", a.Mode)
+	defer func() {
+		span.SetAttr("statediff.updated", updated)
+		span.SetError(err)
+		span.End()
+	}()
+
+	txs := pool.Txs()
+	slices.SortStableFunc(txs, func(x, y *Transaction) int { return cmpScore(y, x) })
+	if a.MaxTxs > 0 && len(txs) > a.MaxTxs {
+		txs = txs[:a.MaxTxs]
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	live := make(map[string]bool, len(txs))
+	var untraced []*Transaction
+	for _, tx := range txs {
+		live[tx.Hash] = true
+		if a.sets[tx.Hash] == nil && tx.From != "" {
+			untraced = append(untraced, tx)
+		}
+	}
+	maps.DeleteFunc(a.sets, func(hash string, _ *AccessSet) bool { return !live[hash] })
+	span.SetAttr("statediff.traced", len(untraced))
+	if err := a.trace(ctx, untraced); err != nil {
+		return 0, err
+	}
+
+	conflicts := a.conflicts(txs)
+	for _, tx := range txs {
+		added := conflicts[tx.Hash]
+		if len(added) == 0 {
+			continue
+		}
+		merged := slices.Clone(tx.ConflictsWith)
+		for _, h := range added {
+			if !slices.Contains(merged, h) {
+				merged = append(merged, h)
+			}
+		}
+		if len(merged) == len(tx.ConflictsWith) {
+			continue
+		}
+		updatedTx := *tx
+		updatedTx.ConflictsWith = merged
+		pool.AddTx(&updatedTx)
+		updated++
+	}
+	return updated, nil
+}
+
+// trace fills in the access sets of txs, executing each with Local if set
+// and tracing the rest with one batch of debug_traceCall requests: diff
+// mode for writes, and in read-write mode plain prestate for everything
+// touched. A transaction whose trace fails is left untraced and retried
+// next time.
+func (a *StateDiffAnalyzer) trace(ctx context.Context, txs []*Transaction) error {
+	if a.Local != nil {
+		var remote []*Transaction
+		for _, tx := range txs {
+			_, set, err := a.Local.Execute(ctx, Address{}, []*Transaction{tx})
+			if err != nil {
+				remote = append(remote, tx)
+				continue
+			}
+			a.sets[tx.Hash] = set
+		}
+		SpanFromContext(ctx).SetAttr("statediff.local", len(txs)-len(remote))
+		txs = remote
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+	calls := 1
+	if a.Mode == StateDiffReadWrite {
+		calls = 2
+	}
+	diffs := make([]prestateDiff, len(txs))
+	pres := make([]map[string]prestateAccount, len(txs))
+	elems := make([]BatchElem, 0, calls*len(txs))
+	for i, tx := range txs {
+		call := callObject(tx)
+		elems = append(elems, BatchElem{
+			Method: "debug_traceCall",
+			Params: []any{call, "pending", map[string]any{"tracer": "prestateTracer", "tracerConfig": map[string]bool{"diffMode": true}}},
+			Result: &diffs[i],
+		})
+		if calls == 2 {
+			elems = append(elems, BatchElem{
+				Method: "debug_traceCall",
+				Params: []any{call, "pending", map[string]any{"tracer": "prestateTracer"}},
+				Result: &pres[i],
+			})
+		}
+	}
+	if a.Workers != nil {
+		groups := make([][]BatchElem, len(txs))
+		for i := range txs {
+			groups[i] = elems[calls*i : calls*(i+1)]
+		}
+		stats := a.Workers.Calls(ctx, a.RPC, txs, groups)
+		SpanFromContext(ctx).SetAttr("statediff.workers", stats.String())
+	} else if err := a.RPC.BatchCall(ctx, elems); err != nil {
+		return fmt.Errorf("tracing state diffs: %w", err)
+	}
+	for i, tx := range txs {
+		if slices.ContainsFunc(elems[calls*i:calls*(i+1)], func(e BatchElem) bool { return e.Error != nil }) {
+			continue
+		}
+		set := &AccessSet{Reads: map[string]bool{}, Writes: map[string]bool{}}
+		// A slot in post changed; one only in pre was cleared
+		for _, side := range []map[string]prestateAccount{diffs[i].Pre, diffs[i].Post} {
+			for addr, acct := range side {
+				for slot := range acct.Storage {
+					set.Writes[storageKey(addr, slot)] = true
+				}
+			}
+		}
+		for addr, acct := range pres[i] {
+			for slot := range acct.Storage {
+				set.Reads[storageKey(addr, slot)] = true
+			}
+		}
+		a.sets[tx.Hash] = set
+	}
+	return nil
+}
+
+// callObject returns tx as the transaction call object of eth_call and the
+// debug and simulation methods built on it. Dynamic-fee transactions keep
+// their caps, so execution charges the tip they would actually pay.
+func callObject(tx *Transaction) map[string]string {
+	call := map[string]string{"from": tx.From, "gas": EncodeHexUint64(uint64(tx.GasLimit))}
+	if tx.DynamicFee() {
+		call["maxFeePerGas"] = EncodeHexUint64(uint64(tx.GasPrice))
+		call["maxPriorityFeePerGas"] = EncodeHexUint64(uint64(tx.GasTipCap))
+	} else {
+		call["gasPrice"] = EncodeHexUint64(uint64(tx.GasPrice))
+	}
+	if tx.To != "" {
+		call["to"] = tx.To
+	}
+	if tx.Value != nil && tx.Value.Sign() > 0 {
+		call["value"] = EncodeHexBig(tx.Value)
+	}
+	if len(tx.Input) > 0 {
+		call["data"] = EncodeHexBytes(tx.Input)
+	}
+	return call
+}
+
+// Access returns the storage tx touches, or nil if it hasn't been traced.
+// The set is shared and must not be changed.
+func (a *StateDiffAnalyzer) Access(hash string) *AccessSet {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sets[hash]
+}
+
+// Slots returns the storage slots traced transactions read or write, by
+// contract, in no particular order
+func (a *StateDiffAnalyzer) Slots() map[Address][]Hash {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seen := make(map[string]bool)
+	slots := make(map[Address][]Hash)
+	for _, set := range a.sets {
+		for _, keys := range []map[string]bool{set.Reads, set.Writes} {
+			for key := range keys {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				addr, slot, _ := strings.Cut(key, "/")
+				a, err1 := HexToAddress(addr)
+				s, err2 := HexToHash(slot)
+				if err1 == nil && err2 == nil {
+					slots[a] = append(slots[a], s)
+				}
+			}
+		}
+	}
+	return slots
+}
+
+func storageKey(addr, slot string) string {
+	return strings.ToLower(addr) + "/" + strings.ToLower(slot)
+}
+
+// conflicts returns, for each of txs, the hashes of the others it
+// contends with over storage
+func (a *StateDiffAnalyzer) conflicts(txs []*Transaction) map[string][]string {
+	writers := map[string][]string{}
+	readers := map[string][]string{}
+	for _, tx := range txs {
+		set := a.sets[tx.Hash]
+		if set == nil {
+			continue
+		}
+		for key := range set.Writes {
+			writers[key] = append(writers[key], tx.Hash)
+		}
+		if a.Mode == StateDiffReadWrite {
+			for key := range set.Reads {
+				if !set.Writes[key] {
+					readers[key] = append(readers[key], tx.Hash)
+				}
+			}
+		}
+	}
+
+	out := map[string][]string{}
+	link := func(x, y string) {
+		if x != y && !slices.Contains(out[x], y) {
+			out[x] = append(out[x], y)
+			out[y] = append(out[y], x)
+		}
+	}
+	for key, ws := range writers {
+		for i, w := range ws {
+			for _, other := range ws[i+1:] {
+				link(w, other)
+			}
+			for _, r := range readers[key] {
+				link(w, r)
+			}
+		}
+	}
+	for hash := range out {
+		slices.SortFunc(out[hash], cmp.Compare)
+	}
+	return out
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseTOML decodes the subset of TOML used by config files: tables,
+// arrays of tables, dotted keys, strings, integers, floats, booleans,
+// arrays and inline tables. Dates and multi-line strings are not supported.
+func parseTOML(src string) (map[string]any, error) {
+	p := &tomlParser{src: src, line: 1}
+	root := map[string]any{}
+	current := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+		switch {
+		case p.peek() == '[' && strings.HasPrefix(p.src[p.pos:], "[["):
+			p.pos += 2
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]]"); err != nil {
+				return nil, err
+			}
+			if current, err = p.appendTable(root, keys); err != nil {
+				return nil, err
+			}
+		case p.peek() == '[':
+			p.pos++
+			keys, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if current, err = p.table(root, keys); err != nil {
+				return nil, err
+			}
+		default:
+			if err := p.keyValue(current); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool { return p.pos >= len(p.src) }
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpace skips spaces and tabs on the current line
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) expect(tok string) error {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], tok) {
+		return p.errorf("expected %q", tok)
+	}
+	p.pos += len(tok)
+	return nil
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if p.peek() == '\r' {
+		p.pos++
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("unexpected %q after value", p.peek())
+	}
+	return nil
+}
+
+// key parses a possibly dotted, possibly quoted key
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		var k string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			k = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected key")
+			}
+			k = p.src[start:p.pos]
+		}
+		keys = append(keys, k)
+		p.skipSpace()
+		if p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) keyValue(dst map[string]any) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	p.skipSpace()
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	tbl, err := p.table(dst, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, dup := tbl[last]; dup {
+		return p.errorf("duplicate key %q", strings.Join(keys, "."))
+	}
+	tbl[last] = v
+	return nil
+}
+
+// table walks (creating as needed) the nested table named by keys
+func (p *tomlParser) table(root map[string]any, keys []string) (map[string]any, error) {
+	cur := root
+	for _, k := range keys {
+		switch next := cur[k].(type) {
+		case nil:
+			t := map[string]any{}
+			cur[k] = t
+			cur = t
+		case map[string]any:
+			cur = next
+		case []any:
+			// Dotted access into an array of tables refers to its last element
+			last, ok := next[len(next)-1].(map[string]any)
+			if !ok {
+				return nil, p.errorf("key %q is not a table", k)
+			}
+			cur = last
+		default:
+			return nil, p.errorf("key %q is not a table", k)
+		}
+	}
+	return cur, nil
+}
+
+func (p *tomlParser) appendTable(root map[string]any, keys []string) (map[string]any, error) {
+	parent, err := p.table(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	t := map[string]any{}
+	switch arr := parent[last].(type) {
+	case nil:
+		parent[last] = []any{t}
+	case []any:
+		parent[last] = append(arr, t)
+	default:
+		return nil, p.errorf("key %q is not an array of tables", last)
+	}
+	return t, nil
+}
+
+func (p *tomlParser) value() (any, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += 5
+		return false, nil
+	default:
+		return p.number()
+	}
+}
+
+func (p *tomlParser) number() (any, error) {
+	start := p.pos
+	for !p.eof() && strings.IndexByte("+-0123456789_.eExabcdefABCDEFo", p.peek()) >= 0 {
+		p.pos++
+	}
+	raw := strings.ReplaceAll(p.src[start:p.pos], "_", "")
+	if raw == "" {
+		return nil, p.errorf("expected a value")
+	}
+	if i, err := strconv.ParseInt(raw, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q", p.src[start:p.pos])
+}
+
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		if c == quote {
+			return sb.String(), nil
+		}
+		if c != '\\' || quote == '\'' {
+			sb.WriteByte(c)
+			continue
+		}
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		esc := p.peek()
+		p.pos++
+		switch esc {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case '"', '\\':
+			sb.WriteByte(esc)
+		case 'u', 'U':
+			n := 4
+			if esc == 'U' {
+				n = 8
+			}
+			if p.pos+n > len(p.src) {
+				return "", p.errorf("short unicode escape")
+			}
+			r, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", p.errorf("invalid unicode escape")
+			}
+			sb.WriteRune(rune(r))
+			p.pos += n
+		default:
+			return "", p.errorf("invalid escape \\%c", esc)
+		}
+	}
+}
+
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++ // [
+	arr := []any{}
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return arr, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) inlineTable() (map[string]any, error) {
+	p.pos++ // {
+	t := map[string]any{}
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.pos++
+		return t, nil
+	}
+	for {
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return t, nil
+		default:
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
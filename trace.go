@@ -0,0 +1,334 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// TraceVersion is bumped whenever the trace format changes incompatibly
+const TraceVersion = 1
+
+// Trace is a replayable record of one build: the pool contents in heap order,
+// the strategy and RNG seed used, and the block that came out
+type Trace struct {
+	Version     int            `json:"version"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	Source      string         `json:"source"` // rpc, fixture, synthetic, mock
+	Strategy    string         `json:"strategy"`
+	Seed        uint64         `json:"seed"`
+	GasLimit    int64          `json:"gasLimit"`
+	BaseFee     int64          `json:"baseFee,omitempty"` // profits are measured against it
+	Block       uint64         `json:"block,omitempty"`   // block number inputs are aged against
+	Aging       *AgingConfig   `json:"aging,omitempty"`
+	Scorer      string         `json:"scorer,omitempty"` // custom scorer inputs were ranked by
+	Weights     *ScoreWeights  `json:"weights,omitempty"`
+	Board       *CuttingBoard  `json:"cuttingBoard,omitempty"` // proposer allocation PoL incentives were weighted by
+	Lanes       []LaneConfig   `json:"lanes,omitempty"`
+	Express     *ExpressGrant  `json:"express,omitempty"`   // express lane holder
+	Encrypted   *EncryptedSet  `json:"encrypted,omitempty"` // gas reserved for encrypted transactions, and the decrypted ones
+	Committed   []string       `json:"committed,omitempty"` // revealed transactions in commit-reveal order
+	Hooks       []HookConfig   `json:"hooks,omitempty"`
+	Quotas      *QuotaConfig   `json:"quotas,omitempty"`
+	Boosts      []BoostConfig  `json:"boosts,omitempty"`
+	Required    []string       `json:"required,omitempty"` // inclusion list hashes
+	Inputs      []*Transaction `json:"inputs"`
+	Low         []*Transaction `json:"low,omitempty"` // deprioritized inputs, in heap order
+	Selected    []string       `json:"selected"`
+	TotalProfit int64          `json:"totalProfit"`
+	TimedOut    bool           `json:"timedOut,omitempty"` // Selected is the best block at the deadline
+
+	// Ranking records the candidate order of greedy strategies, if not
+	// by profit
+	Ranking Ranking `json:"ranking,omitempty"`
+
+	// Search and SearchIterations record the local search run by "anneal"
+	Search           *SearchConfig `json:"search,omitempty"`
+	SearchIterations int           `json:"searchIterations,omitempty"`
+
+	// Exact and ExactNodes record the exact solving of "wis"; ExactNodes
+	// is set only if the budget ran out
+	Exact      *ExactConfig `json:"exact,omitempty"`
+	ExactNodes int          `json:"exactNodes,omitempty"`
+
+	// ScanExamined records how many candidates the packer examined
+	// before its ScanBudget ran out, if it did
+	ScanExamined int `json:"scanExamined,omitempty"`
+
+	// Fair records the arrival buckets of "fair"
+	Fair *FairConfig `json:"fair,omitempty"`
+
+	// Access records the traced read/write sets "parallel" ordered by
+	Access map[string]*AccessSet `json:"access,omitempty"`
+}
+
+// NewTrace captures the pool state and the result of a build
+func NewTrace(pool *TxPool, source, strategy string, seed uint64, gasLimit int64, selected []*Transaction) *Trace {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	t := &Trace{
+		Version:   TraceVersion,
+		CreatedAt: time.Now().UTC(),
+		Source:    source,
+		Strategy:  strategy,
+		Seed:      seed,
+		GasLimit:  gasLimit,
+		BaseFee:   pool.BaseFee,
+		Block:     pool.Block,
+		Inputs:    slices.Clone(pool.Heap.TxHeap),
+		Low:       slices.Clone(pool.Low.TxHeap),
+		Selected:  make([]string, len(selected)),
+	}
+	for _, lane := range pool.Lanes {
+		t.Lanes = append(t.Lanes, lane.Config)
+	}
+	t.Express = pool.Express
+	t.Encrypted = pool.Encrypted
+	t.Committed = pool.Committed
+	for _, hook := range pool.Hooks {
+		t.Hooks = append(t.Hooks, hook.Config)
+	}
+	for _, b := range pool.Boosts {
+		t.Boosts = append(t.Boosts, b.Config)
+	}
+	if pool.Quotas != nil {
+		quotas := pool.Quotas.Config
+		t.Quotas = &quotas
+	}
+	t.Required = slices.Clone(pool.Required)
+	if pool.customScored() {
+		t.Scorer = pool.Scorer
+	}
+	if !pool.Weights.Neutral() {
+		weights := pool.Weights
+		t.Weights = &weights
+	}
+	t.Board = pool.CuttingBoard
+	if pool.Aging.Enabled() {
+		aging := pool.Aging
+		t.Aging = &aging
+	}
+	if strategy == "anneal" {
+		search := pool.Search
+		t.Search = &search
+		if r := pool.LastSearch(); r != nil {
+			t.SearchIterations = r.Iterations
+		}
+	}
+	if strategy == "wis" {
+		exact := pool.Exact
+		t.Exact = &exact
+		if r := pool.LastExact(); r != nil && r.BudgetSpent {
+			t.ExactNodes = r.Nodes
+		}
+	}
+	if strategy == "fair" {
+		fair := pool.Fair
+		t.Fair = &fair
+	}
+	if pool.Ranking != RankProfit {
+		t.Ranking = pool.Ranking
+	}
+	if strategy == "parallel" && pool.Access != nil {
+		for _, tx := range slices.Concat(t.Inputs, t.Low) {
+			if set := pool.Access(tx.Hash); set != nil {
+				if t.Access == nil {
+					t.Access = map[string]*AccessSet{}
+				}
+				t.Access[tx.Hash] = set
+			}
+		}
+	}
+	for i, tx := range selected {
+		t.Selected[i] = tx.Hash
+		t.TotalProfit += tx.Profit()
+	}
+	return t
+}
+
+// Save writes the trace into dir under a timestamped name and returns the path
+func (t *Trace) Save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("trace-%d.json", t.CreatedAt.UnixNano()))
+	return path, writeJSON(path, t)
+}
+
+// LoadTrace reads a trace written by Save
+func LoadTrace(path string) (*Trace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Trace
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if t.Version != TraceVersion {
+		return nil, fmt.Errorf("%s: trace version %d, want %d", path, t.Version, TraceVersion)
+	}
+	return &t, nil
+}
+
+// Replay rebuilds the pool from the trace inputs (pushed in recorded heap
+// order, which reproduces the same heap) and re-runs the recorded strategy
+func (t *Trace) Replay(ctx context.Context) ([]*Transaction, error) {
+	pool := NewTxPool()
+	lanes, err := NewLanes(t.Lanes)
+	if err != nil {
+		return nil, err
+	}
+	pool.Lanes = lanes
+	pool.Express = t.Express
+	pool.Encrypted = t.Encrypted
+	pool.Committed = t.Committed
+	if pool.Hooks, err = NewHooks(t.Hooks); err != nil {
+		return nil, err
+	}
+	if t.Quotas != nil {
+		pool.Quotas = NewGasQuotas(*t.Quotas)
+	}
+	if pool.Boosts, err = NewContractBoosts(t.Boosts); err != nil {
+		return nil, err
+	}
+	pool.BaseFee = t.BaseFee
+	pool.Block = t.Block
+	if t.Aging != nil {
+		pool.Aging = *t.Aging
+	}
+	if t.Scorer != "" {
+		if _, err := LookupScorer(t.Scorer); err != nil {
+			return nil, err
+		}
+		pool.Scorer = t.Scorer
+	}
+	if t.Weights != nil {
+		pool.Weights = *t.Weights
+	}
+	pool.CuttingBoard = t.Board
+	pool.keepArrival = true
+	for _, tx := range t.Inputs {
+		if slices.Contains(t.Required, tx.Hash) {
+			pool.Force(tx)
+		} else {
+			pool.AddTx(tx)
+		}
+	}
+	pool.Required = slices.Clone(t.Required)
+	for _, tx := range t.Low {
+		pool.stamp(tx)
+		pool.AllTxs[tx.Hash] = tx
+		heap.Push(&pool.Low, tx)
+	}
+	if t.Search != nil {
+		pool.Search = *t.Search
+		pool.Search.Budget = 0
+		pool.Search.stopAfter = t.SearchIterations
+	}
+	if t.Exact != nil {
+		pool.Exact = *t.Exact
+		pool.Exact.stopAfter = t.ExactNodes
+	}
+	pool.Exact.Budget = 0
+	pool.Ranking = t.Ranking
+	if t.Fair != nil {
+		pool.Fair = *t.Fair
+	}
+	if t.Access != nil {
+		pool.Access = func(hash string) *AccessSet { return t.Access[hash] }
+	}
+	return SelectWithStrategy(withScanBudget(ctx, ScanBudget{Candidates: t.ScanExamined}), pool, t.Strategy, t.GasLimit, nil)
+}
+
+// Verify replays the trace and reports the first divergence from the
+// recorded block, if any. A build cut short by its deadline is replayed to
+// completion, so only the recorded prefix is compared.
+func (t *Trace) Verify(ctx context.Context) error {
+	got, err := t.Replay(ctx)
+	if err != nil {
+		return err
+	}
+	n := max(len(got), len(t.Selected))
+	if t.TimedOut {
+		n = len(t.Selected)
+	}
+	for i := 0; i < n; i++ {
+		var want, have string
+		if i < len(t.Selected) {
+			want = t.Selected[i]
+		}
+		if i < len(got) {
+			have = got[i].Hash
+		}
+		if want != have {
+			return fmt.Errorf("block diverges at position %d: recorded %q, replayed %q (%d vs %d txs)",
+				i, want, have, len(t.Selected), len(got))
+		}
+	}
+	return nil
+}
+
+// SelectWithStrategy runs the named packing strategy over pool, between the
+// pool's proposal hooks. If best is not nil, the strategy offers it every
+// improvement as it goes, before the hooks apply. A transaction whose
+// sender's lower nonce didn't make the block is dropped, as it could never
+// run; see dropNonceGaps.
+func SelectWithStrategy(ctx context.Context, pool *TxPool, strategy string, gasLimit int64, best *BestBlock) ([]*Transaction, error) {
+	packer, err := LookupPacker(strategy)
+	if err != nil {
+		return nil, err
+	}
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	pool.lastScan.Store(nil) // so LastScan never reports an earlier build's
+	txs, injected, err := pool.packWithHooks(ctx, gasLimit, func(limit int64) ([]*Transaction, error) {
+		return packer.Pack(ctx, pool, limit, best)
+	})
+	if err == nil {
+		txs, err = pool.orderBlock(pool.dropNonceGaps(txs, injected))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.validateBlock(gasLimit, txs, injected); err != nil {
+		return nil, fmt.Errorf("strategy %q built an invalid block: %w", strategy, err)
+	}
+	return txs, nil
+}
+
+var replayCommand = &Command{
+	Name:    "replay",
+	Summary: "re-run recorded build traces and verify they produce the same block",
+	Setup: func(fs *flag.FlagSet) func(context.Context, *Env) error {
+		return func(ctx context.Context, env *Env) error {
+			if fs.NArg() == 0 {
+				return errors.New("usage: replay [flags] <trace.json>...")
+			}
+			failed := 0
+			for _, path := range fs.Args() {
+				t, err := LoadTrace(path)
+				if err == nil {
+					err = t.Verify(ctx)
+				}
+				if err != nil {
+					failed++
+					fmt.Fprintf(env.Out, "FAIL %s: %v\n", path, err)
+					continue
+				}
+				fmt.Fprintf(env.Out, "ok   %s (%d txs, %s)\n", path, len(t.Selected), FormatWei(t.TotalProfit))
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d traces diverged", failed, fs.NArg())
+			}
+			return nil
+		}
+	},
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tracer records spans across a build (RPC calls, pool admission, backrun
+// simulation, packing, report export) and exports each finished trace to an
+// OTLP/HTTP collector as JSON, which Jaeger and Tempo both accept on
+// :4318/v1/traces. The tracer travels in the context: StartSpan on a
+// context without one returns a nil span, and every Span method is a no-op
+// on nil, so instrumented code needn't check whether tracing is on.
+type Tracer struct {
+	Endpoint string // OTLP/HTTP traces URL
+	Service  string // service.name resource attribute
+	HTTP     *http.Client
+	OnError  func(error) // called when an export fails; optional
+
+	mu      sync.Mutex
+	pending []*Span
+	wg      sync.WaitGroup
+}
+
+// NewTracer returns a tracer exporting to endpoint
+func NewTracer(endpoint, service string) *Tracer {
+	return &Tracer{Endpoint: endpoint, Service: service, HTTP: newHTTPClient(10 * time.Second)}
+}
+
+// SpanKind is the OTLP span kind
+type SpanKind int
+
+const (
+	SpanInternal SpanKind = 1
+	SpanClient   SpanKind = 3
+)
+
+// Span is one timed operation within a trace
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    SpanKind
+	start   time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []spanAttr
+	err   error
+}
+
+type spanAttr struct {
+	key   string
+	value any
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// WithTracer returns ctx carrying t; spans started under it are recorded
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// SpanFromContext returns the span current in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// StartSpan starts a span named name as a child of the span in ctx, or as
+// the root of a new trace, and returns a context carrying it. attrs are
+// alternating keys and values, as for SetAttr.
+func StartSpan(ctx context.Context, name string, attrs ...any) (context.Context, *Span) {
+	return startSpan(ctx, name, SpanInternal, attrs)
+}
+
+func startSpan(ctx context.Context, name string, kind SpanKind, attrs []any) (context.Context, *Span) {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	s.SetAttr(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttr records attributes given as alternating string keys and values;
+// values may be strings, integers, floats or bools
+func (s *Span) SetAttr(kv ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		if key, ok := kv[i].(string); ok {
+			s.attrs = append(s.attrs, spanAttr{key, kv[i+1]})
+		}
+	}
+}
+
+// SetError marks the span failed with err; nil is ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes the span. Ending the root span of a trace exports everything
+// finished so far in the background.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	t := s.tracer
+	t.mu.Lock()
+	t.pending = append(t.pending, s)
+	t.mu.Unlock()
+	if s.parent == [8]byte{} {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			if err := t.Flush(context.Background()); err != nil && t.OnError != nil {
+				t.OnError(err)
+			}
+		}()
+	}
+}
+
+// Traceparent returns the W3C trace context header value for the span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID)
+}
+
+// injectTraceparent propagates the span in ctx to an outgoing request
+func injectTraceparent(ctx context.Context, req *http.Request) {
+	if s := SpanFromContext(ctx); s != nil {
+		req.Header.Set("traceparent", s.Traceparent())
+	}
+}
+
+// Flush exports every finished span not yet exported
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(t.otlp(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("exporting %d spans: %w", len(spans), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("exporting %d spans: %w", len(spans), &HTTPError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))})
+	}
+	return nil
+}
+
+// Close waits for exports in flight, then flushes whatever is left
+func (t *Tracer) Close(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.wg.Wait()
+	return t.Flush(ctx)
+}
+
+// otlp encodes spans as an OTLP ExportTraceServiceRequest in its JSON
+// mapping: IDs in hex, nanosecond timestamps and 64-bit integers as strings
+func (t *Tracer) otlp(spans []*Span) any {
+	type kv = map[string]any
+	out := make([]kv, len(spans))
+	for i, s := range spans {
+		s.mu.Lock()
+		span := kv{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+			"status":            kv{"code": 1},
+		}
+		if s.parent != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			span["status"] = kv{"code": 2, "message": s.err.Error()}
+		}
+		s.mu.Unlock()
+		out[i] = span
+	}
+	return kv{"resourceSpans": []kv{{
+		"resource":   kv{"attributes": otlpAttrs([]spanAttr{{"service.name", t.Service}})},
+		"scopeSpans": []kv{{"scope": kv{"name": "block-construction-engine"}, "spans": out}},
+	}}}
+}
+
+func otlpAttrs(attrs []spanAttr) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.value.(type) {
+		case string:
+			v = map[string]any{"stringValue": x}
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case uint64:
+			v = map[string]any{"intValue": strconv.FormatUint(x, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": x}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": a.key, "value": v})
+	}
+	return out
+}
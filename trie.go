@@ -0,0 +1,127 @@
+package main
+
+// EmptyRootHash is the root of an empty Merkle Patricia trie
+var EmptyRootHash = Keccak256(rlpBytes(nil))
+
+// trieEntry is a key/value pair with the key expanded to nibbles
+type trieEntry struct {
+	key   []byte
+	value []byte
+}
+
+// TrieRoot computes the Merkle Patricia trie root of the given key/value
+// pairs. Keys must be unique. This builds the trie in memory in one pass and
+// is meant for per-block tries (transactions, receipts, withdrawals).
+func TrieRoot(keys, values [][]byte) Hash {
+	if len(keys) == 0 {
+		return EmptyRootHash
+	}
+	entries := make([]trieEntry, len(keys))
+	for i := range keys {
+		entries[i] = trieEntry{key: keyNibbles(keys[i]), value: values[i]}
+	}
+	return Keccak256(trieNode(entries, 0))
+}
+
+// DeriveListRoot computes the root of the trie keyed by RLP(index), as used
+// for transactionsRoot, receiptsRoot and withdrawalsRoot
+func DeriveListRoot(items [][]byte) Hash {
+	keys := make([][]byte, len(items))
+	for i := range items {
+		keys[i] = rlpUint(uint64(i))
+	}
+	return TrieRoot(keys, items)
+}
+
+func keyNibbles(key []byte) []byte {
+	n := make([]byte, len(key)*2)
+	for i, b := range key {
+		n[2*i] = b >> 4
+		n[2*i+1] = b & 0x0f
+	}
+	return n
+}
+
+// trieNode returns the RLP encoding of the node holding entries, whose keys
+// share their first depth nibbles
+func trieNode(entries []trieEntry, depth int) []byte {
+	if len(entries) == 1 {
+		e := entries[0]
+		return rlpList(rlpBytes(hexPrefix(e.key[depth:], true)), rlpBytes(e.value))
+	}
+
+	// Extension node over the longest shared prefix
+	prefix := 0
+	for {
+		pos := depth + prefix
+		if pos >= len(entries[0].key) {
+			break
+		}
+		nib := entries[0].key[pos]
+		shared := true
+		for _, e := range entries[1:] {
+			if pos >= len(e.key) || e.key[pos] != nib {
+				shared = false
+				break
+			}
+		}
+		if !shared {
+			break
+		}
+		prefix++
+	}
+	if prefix > 0 {
+		child := trieNode(entries, depth+prefix)
+		return rlpList(rlpBytes(hexPrefix(entries[0].key[depth:depth+prefix], false)), trieRef(child))
+	}
+
+	// Branch node: one slot per nibble plus a value slot
+	var groups [16][]trieEntry
+	var value []byte
+	for _, e := range entries {
+		if len(e.key) == depth {
+			value = e.value
+			continue
+		}
+		nib := e.key[depth]
+		groups[nib] = append(groups[nib], e)
+	}
+	items := make([][]byte, 17)
+	for i, g := range groups {
+		if len(g) == 0 {
+			items[i] = rlpBytes(nil)
+			continue
+		}
+		items[i] = trieRef(trieNode(g, depth+1))
+	}
+	items[16] = rlpBytes(value)
+	return rlpList(items...)
+}
+
+// trieRef embeds small nodes inline and references larger ones by hash
+func trieRef(node []byte) []byte {
+	if len(node) < 32 {
+		return node
+	}
+	h := Keccak256(node)
+	return rlpBytes(h[:])
+}
+
+// hexPrefix applies the compact nibble encoding with the leaf flag
+func hexPrefix(nibbles []byte, leaf bool) []byte {
+	flag := byte(0)
+	if leaf {
+		flag = 2
+	}
+	out := make([]byte, 0, len(nibbles)/2+1)
+	if len(nibbles)%2 == 1 {
+		out = append(out, (flag+1)<<4|nibbles[0])
+		nibbles = nibbles[1:]
+	} else {
+		out = append(out, flag<<4)
+	}
+	for i := 0; i < len(nibbles); i += 2 {
+		out = append(out, nibbles[i]<<4|nibbles[i+1])
+	}
+	return out
+}
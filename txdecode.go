@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// DecodeRawTransaction decodes a signed legacy, EIP-2930, EIP-1559 or
+// EIP-4844 transaction envelope into the fields the builder uses. The
+// sender is not recovered (see RecoverSender). Dynamic-fee transactions are
+// priced at their fee cap, with their tip cap in GasTipCap.
+func DecodeRawTransaction(raw []byte) (*Transaction, error) {
+	typ := txType(raw)
+	payload := raw
+	if typ != 0 {
+		payload = raw[1:]
+	}
+	items, err := rlpListItems(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Field positions of nonce, price, gas, to, data and access list per
+	// envelope type
+	var nonceAt, priceAt, gasAt, toAt, dataAt, accessAt, fields int
+	switch typ {
+	case 0:
+		nonceAt, priceAt, gasAt, toAt, dataAt, accessAt, fields = 0, 1, 2, 3, 5, -1, 9
+	case 1:
+		nonceAt, priceAt, gasAt, toAt, dataAt, accessAt, fields = 1, 2, 3, 4, 6, 7, 11
+	case 2:
+		nonceAt, priceAt, gasAt, toAt, dataAt, accessAt, fields = 1, 3, 4, 5, 7, 8, 12
+	case 3:
+		nonceAt, priceAt, gasAt, toAt, dataAt, accessAt, fields = 1, 3, 4, 5, 7, 8, 14
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", typ)
+	}
+	if len(items) != fields {
+		return nil, fmt.Errorf("type %d transaction has %d fields, want %d", typ, len(items), fields)
+	}
+
+	nonce, err := rlpToUint(items[nonceAt])
+	if err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	price, err := rlpToUint(items[priceAt])
+	if err != nil || price > math.MaxInt64 {
+		return nil, fmt.Errorf("gas price out of range")
+	}
+	gas, err := rlpToUint(items[gasAt])
+	if err != nil || gas > math.MaxInt64 {
+		return nil, fmt.Errorf("gas limit out of range")
+	}
+	tx := &Transaction{
+		Hash:          Keccak256(raw).Hex(),
+		Type:          typ,
+		GasPrice:      int64(price),
+		GasLimit:      int64(gas),
+		Nonce:         int(nonce),
+		ConflictsWith: []string{},
+		Raw:           HexBytes(raw),
+	}
+	if to := items[toAt]; len(to) == 20 {
+		tx.To = EncodeHexBytes(to)
+	} else {
+		tx.Create = len(to) == 0
+	}
+	if len(items[dataAt]) > 0 {
+		tx.Input = HexBytes(items[dataAt])
+	}
+	if value := items[dataAt-1]; len(value) > 0 { // value precedes data in every type
+		tx.Value = new(big.Int).SetBytes(value)
+	}
+	if tx.DynamicFee() {
+		tip, err := rlpToUint(items[2])
+		if err != nil || tip > math.MaxInt64 {
+			return nil, fmt.Errorf("tip cap out of range")
+		}
+		tx.GasTipCap = int64(tip)
+	}
+	if accessAt >= 0 {
+		if tx.AccessList, err = decodeAccessList(items[accessAt]); err != nil {
+			return nil, fmt.Errorf("access list: %w", err)
+		}
+	}
+	if typ == 0 {
+		v, err := rlpToUint(items[6])
+		if err != nil {
+			return nil, fmt.Errorf("signature v: %w", err)
+		}
+		if v >= 35 {
+			tx.ChainID = (v - 35) / 2
+		}
+	} else if tx.ChainID, err = rlpToUint(items[0]); err != nil {
+		return nil, fmt.Errorf("chain ID: %w", err)
+	}
+	return tx, nil
+}
+
+// RecoverSender verifies the signature of a raw transaction envelope and
+// returns the decoded transaction with From set to the signer. Its ChainID
+// is the chain it was signed for, zero for a legacy transaction without
+// replay protection (pre-EIP-155).
+func RecoverSender(raw []byte) (*Transaction, error) {
+	tx, err := DecodeRawTransaction(raw)
+	if err != nil {
+		return nil, err
+	}
+	typ := txType(raw)
+	items, err := rlpEncodedItems(raw[min(int(typ), 1):])
+	if err != nil {
+		return nil, err
+	}
+	n := len(items)
+	var sig [3][]byte // v, r, s
+	for i, item := range items[n-3:] {
+		if _, sig[i], _, err = rlpSplit(item); err != nil {
+			return nil, err
+		}
+	}
+	v, err := rlpToUint(sig[0])
+	if err != nil {
+		return nil, fmt.Errorf("signature v: %w", err)
+	}
+
+	// The signing hash covers every field but the signature; EIP-155
+	// legacy transactions append the chain ID and two zeros instead
+	var hash Hash
+	var recID uint64
+	switch {
+	case typ != 0:
+		hash, recID = Keccak256([]byte{typ}, rlpList(items[:n-3]...)), v
+	case v == 27 || v == 28:
+		hash, recID = Keccak256(rlpList(items[:n-3]...)), v-27
+	case v >= 35:
+		fields := append(items[:n-3:n-3], rlpUint(tx.ChainID), rlpUint(0), rlpUint(0))
+		hash, recID = Keccak256(rlpList(fields...)), (v-35)%2
+	default:
+		return nil, fmt.Errorf("signature v %d is invalid", v)
+	}
+	if recID > 1 {
+		return nil, fmt.Errorf("signature y parity %d is invalid", recID)
+	}
+	from, err := RecoverAddress(hash, new(big.Int).SetBytes(sig[1]), new(big.Int).SetBytes(sig[2]), byte(recID))
+	if err != nil {
+		return nil, err
+	}
+	tx.From, tx.recovered = from.Hex(), true
+	return tx, nil
+}
+
+// decodeAccessList decodes the payload of an RLP access list
+func decodeAccessList(payload []byte) ([]AccessTuple, error) {
+	entries, err := rlpItems(payload)
+	if err != nil {
+		return nil, err
+	}
+	var list []AccessTuple
+	for _, entry := range entries {
+		fields, err := rlpItems(entry)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) != 2 || len(fields[0]) != 20 {
+			return nil, errors.New("malformed entry")
+		}
+		keys, err := rlpItems(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		t := AccessTuple{Address: EncodeHexBytes(fields[0]), StorageKeys: []string{}}
+		for _, key := range keys {
+			if len(key) != 32 {
+				return nil, errors.New("malformed storage key")
+			}
+			t.StorageKeys = append(t.StorageKeys, EncodeHexBytes(key))
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Hash is a 32-byte Keccak hash
+type Hash [32]byte
+
+// Address is a 20-byte account address
+type Address [20]byte
+
+// Bloom is a 2048-bit logs bloom filter
+type Bloom [256]byte
+
+// HexUint64 is a uint64 encoded in JSON as a 0x-prefixed quantity
+type HexUint64 uint64
+
+// HexBytes is a byte slice encoded in JSON as 0x-prefixed hex data
+type HexBytes []byte
+
+func (h Hash) Hex() string    { return EncodeHexBytes(h[:]) }
+func (a Address) Hex() string { return EncodeHexBytes(a[:]) }
+func (b Bloom) Hex() string   { return EncodeHexBytes(b[:]) }
+
+func (h Hash) String() string    { return h.Hex() }
+func (a Address) String() string { return a.Hex() }
+
+// IsZero reports whether a is the zero address
+func (a Address) IsZero() bool { return a == Address{} }
+
+// HexToHash parses a 0x-prefixed 32-byte hash
+func HexToHash(s string) (Hash, error) {
+	var h Hash
+	return h, decodeFixedHex(s, h[:])
+}
+
+// HexToAddress parses a 0x-prefixed 20-byte address
+func HexToAddress(s string) (Address, error) {
+	var a Address
+	return a, decodeFixedHex(s, a[:])
+}
+
+func decodeFixedHex(s string, dst []byte) error {
+	b, err := ParseHexBytes(s)
+	if err != nil {
+		return err
+	}
+	if len(b) != len(dst) {
+		return fmt.Errorf("hex string %q has length %d, want %d", s, len(b), len(dst))
+	}
+	copy(dst, b)
+	return nil
+}
+
+func (h Hash) MarshalText() ([]byte, error)    { return []byte(h.Hex()), nil }
+func (a Address) MarshalText() ([]byte, error) { return []byte(a.Hex()), nil }
+func (b Bloom) MarshalText() ([]byte, error)   { return []byte(b.Hex()), nil }
+
+func (h *Hash) UnmarshalText(text []byte) error    { return decodeFixedHex(string(text), h[:]) }
+func (a *Address) UnmarshalText(text []byte) error { return decodeFixedHex(string(text), a[:]) }
+func (b *Bloom) UnmarshalText(text []byte) error   { return decodeFixedHex(string(text), b[:]) }
+
+func (v HexUint64) MarshalText() ([]byte, error) {
+	return []byte(EncodeHexUint64(uint64(v))), nil
+}
+
+func (v *HexUint64) UnmarshalText(text []byte) error {
+	n, err := ParseHexUint64(string(text))
+	*v = HexUint64(n)
+	return err
+}
+
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(EncodeHexBytes(b)), nil
+}
+
+func (b *HexBytes) UnmarshalText(text []byte) error {
+	v, err := ParseHexBytes(string(text))
+	*b = v
+	return err
+}
+
+// MarshalJSON keeps nil HexBytes as "0x" rather than null
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(EncodeHexBytes(b))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlockCandidate summarizes one built block for feed subscribers
+type BlockCandidate struct {
+	Head     uint64    `json:"head"` // number of the parent block built on
+	Strategy string    `json:"strategy"`
+	Value    int64     `json:"value"` // total profit in wei
+	TxCount  int       `json:"txCount"`
+	GasUsed  int64     `json:"gasUsed"` // as packed: estimated where known, else gas limits
+	GasLimit int64     `json:"gasLimit"`
+	BuiltAt  time.Time `json:"builtAt"`
+}
+
+// NewBlockCandidate summarizes selected as built on head
+func NewBlockCandidate(head uint64, strategy string, gasLimit int64, selected []*Transaction) *BlockCandidate {
+	c := &BlockCandidate{Head: head, Strategy: strategy, TxCount: len(selected), GasLimit: gasLimit, BuiltAt: time.Now().UTC()}
+	for _, tx := range selected {
+		c.Value += tx.Profit()
+		c.GasUsed += tx.PackGas()
+	}
+	return c
+}
+
+// feedBuffer is how many candidates a subscriber may fall behind by before
+// it is dropped
+const feedBuffer = 16
+
+// BuildFeed fans built block candidates out to subscribers. Publishing never
+// blocks the builder: a subscriber that falls feedBuffer candidates behind
+// is disconnected.
+type BuildFeed struct {
+	mu   sync.Mutex
+	subs map[*feedSub]bool
+}
+
+type feedSub struct {
+	ch chan *BlockCandidate
+}
+
+// NewBuildFeed returns a feed without subscribers
+func NewBuildFeed() *BuildFeed {
+	return &BuildFeed{subs: make(map[*feedSub]bool)}
+}
+
+// Publish sends c to every subscriber
+func (f *BuildFeed) Publish(c *BlockCandidate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		select {
+		case sub.ch <- c:
+		default:
+			close(sub.ch)
+			delete(f.subs, sub)
+		}
+	}
+}
+
+// Subscribers returns the number of connected subscribers
+func (f *BuildFeed) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+func (f *BuildFeed) subscribe() *feedSub {
+	sub := &feedSub{ch: make(chan *BlockCandidate, feedBuffer)}
+	f.mu.Lock()
+	f.subs[sub] = true
+	f.mu.Unlock()
+	return sub
+}
+
+func (f *BuildFeed) unsubscribe(sub *feedSub) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs[sub] {
+		close(sub.ch)
+		delete(f.subs, sub)
+	}
+}
+
+// wsPingInterval keeps idle feed connections alive through proxies
+const wsPingInterval = 30 * time.Second
+
+// handleBlocksWS upgrades to a WebSocket and pushes every block candidate
+// as a JSON text message until the client goes away
+func (s *APIServer) handleBlocksWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sub := s.Feed.subscribe()
+	defer s.Feed.unsubscribe(sub)
+
+	// Clients have nothing to say; the reader answers pings and notices
+	// when they close
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.readLoop()
+	}()
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case c, ok := <-sub.ch:
+			if !ok {
+				conn.writeClose(wsClosePolicy, "too slow")
+				return
+			}
+			data, err := json.Marshal(c)
+			if err != nil {
+				return
+			}
+			if err := conn.writeFrame(wsText, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// WebSocket opcodes and close codes (RFC 6455)
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+	wsClosePolicy   = 1008
+	wsCloseTooBig   = 1009
+)
+
+// wsMaxMessage bounds client frames; the feed expects none but control
+// frames
+const wsMaxMessage = 4 << 10
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsConn is the server side of a WebSocket connection. Writes are
+// serialized; reads happen on one goroutine.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex
+}
+
+// upgradeWebSocket completes the opening handshake, or answers with an
+// HTTP error and returns it
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket"):
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket upgrade")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	case key == "":
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", http.StatusInternalServerError)
+		return nil, errors.New("response writer can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *wsConn) Close() error { return c.conn.Close() }
+
+// writeFrame sends one unfragmented, unmasked frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	hdr := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = binary.BigEndian.AppendUint16(append(hdr, 126), uint16(n))
+	default:
+		hdr = binary.BigEndian.AppendUint64(append(hdr, 127), uint64(n))
+	}
+	c.rw.Write(hdr)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+func (c *wsConn) writeClose(code uint16, reason string) error {
+	return c.writeFrame(wsClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+}
+
+// readLoop reads client frames until the connection closes, answering
+// pings and echoing the close handshake
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			var code uint16 = wsCloseProtocol
+			if errors.Is(err, errWSTooBig) {
+				code = wsCloseTooBig
+			}
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				c.writeClose(code, "")
+			}
+			return
+		}
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsClose:
+			c.writeClose(wsCloseNormal, "")
+			return
+		}
+	}
+}
+
+var errWSTooBig = errors.New("websocket frame too large")
+
+// readFrame reads one client frame, which RFC 6455 requires to be masked
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.rw, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := hdr[0] & 0x0f
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage || (opcode >= wsClose && n > 125) {
+		return 0, nil, errWSTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
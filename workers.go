@@ -0,0 +1,253 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// errDependencyFailed marks a task skipped because one it runs after failed
+var errDependencyFailed = errors.New("dependency failed")
+
+// SimTask is one unit of simulation work for a WorkerPool
+type SimTask struct {
+	ID    string
+	After []string // IDs of tasks that must succeed first; unknown IDs are ignored
+	Run   func(ctx context.Context) error
+}
+
+// SimResult is how a SimTask went
+type SimResult struct {
+	ID       string
+	Err      error // nil on success
+	Elapsed  time.Duration
+	Skipped  bool // not run because a dependency failed or a cycle blocked it
+	TimedOut bool
+}
+
+// SimStats aggregates the results of a WorkerPool run
+type SimStats struct {
+	Tasks, Failed, TimedOut, Skipped int
+	Elapsed                          time.Duration // wall-clock time of the run
+	Busy                             time.Duration // summed task time; Busy/Elapsed is the parallelism achieved
+}
+
+// WorkerPool runs independent simulations concurrently, at most Workers at
+// a time, each under its own Timeout, so a large pool is simulated within
+// a slot rather than in one batch whose slowest call holds up the rest.
+// Tasks run only after those they depend on have succeeded, as a sender's
+// transaction after its lower nonces; if one fails or times out its
+// dependents are skipped rather than run against the wrong state.
+type WorkerPool struct {
+	Workers int
+	Timeout time.Duration // per task; 0 is none
+}
+
+// NewWorkerPool returns a pool configured from cfg, or nil if simulations
+// are batched instead
+func NewWorkerPool(cfg SimulationConfig) *WorkerPool {
+	if cfg.Workers <= 0 {
+		return nil
+	}
+	return &WorkerPool{Workers: cfg.Workers, Timeout: time.Duration(cfg.TaskTimeout)}
+}
+
+// Run runs tasks and returns their results in task order with aggregate
+// stats. It returns early only if ctx is cancelled, leaving the tasks not
+// started unrun and marked skipped.
+func (w *WorkerPool) Run(ctx context.Context, tasks []SimTask) ([]SimResult, SimStats) {
+	start := time.Now()
+	results := make([]SimResult, len(tasks))
+	index := make(map[string]int, len(tasks))
+	for i, t := range tasks {
+		results[i].ID = t.ID
+		index[t.ID] = i
+	}
+	waiting := make([]int, len(tasks))
+	dependents := make([][]int, len(tasks))
+	var ready []int
+	for i, t := range tasks {
+		for _, id := range t.After {
+			if j, ok := index[id]; ok && j != i {
+				waiting[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	jobs := make(chan int)
+	done := make(chan int)
+	for range min(w.Workers, len(tasks)) {
+		go func() {
+			for i := range jobs {
+				w.run(ctx, &tasks[i], &results[i])
+				done <- i
+			}
+		}()
+	}
+
+	// skip marks i and everything after it skipped, returning how many
+	finished := make([]bool, len(tasks))
+	var skip func(i int, err error) int
+	skip = func(i int, err error) int {
+		if finished[i] {
+			return 0
+		}
+		finished[i] = true
+		results[i].Err, results[i].Skipped = err, true
+		n := 1
+		for _, d := range dependents[i] {
+			n += skip(d, errDependencyFailed)
+		}
+		return n
+	}
+	remaining, running := len(tasks), 0
+	for remaining > 0 {
+		if ctx.Err() != nil && running == 0 {
+			for i := range tasks {
+				if !finished[i] {
+					skip(i, ctx.Err())
+				}
+			}
+			break
+		}
+		if len(ready) == 0 && running == 0 {
+			// What is left waits on itself
+			for i := range tasks {
+				if !finished[i] {
+					remaining -= skip(i, errors.New("dependency cycle"))
+				}
+			}
+			break
+		}
+		// Once ctx is cancelled nothing more starts, and running tasks
+		// are waited for
+		var send chan int
+		var next int
+		var cancelled <-chan struct{}
+		if ctx.Err() == nil {
+			cancelled = ctx.Done()
+			if len(ready) > 0 {
+				send, next = jobs, ready[0]
+			}
+		}
+		select {
+		case send <- next:
+			ready = ready[1:]
+			running++
+		case i := <-done:
+			running--
+			remaining--
+			finished[i] = true
+			for _, d := range dependents[i] {
+				if finished[d] {
+					continue
+				}
+				if results[i].Err != nil {
+					remaining -= skip(d, errDependencyFailed)
+				} else if waiting[d]--; waiting[d] == 0 {
+					ready = append(ready, d)
+				}
+			}
+		case <-cancelled:
+		}
+	}
+	close(jobs)
+
+	stats := SimStats{Tasks: len(tasks), Elapsed: time.Since(start)}
+	for _, r := range results {
+		stats.Busy += r.Elapsed
+		switch {
+		case r.Skipped:
+			stats.Skipped++
+		case r.TimedOut:
+			stats.TimedOut++
+		case r.Err != nil:
+			stats.Failed++
+		}
+	}
+	return results, stats
+}
+
+// run runs t under the pool's timeout, recording the outcome in r
+func (w *WorkerPool) run(ctx context.Context, t *SimTask, r *SimResult) {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	r.Err = t.Run(ctx)
+	r.Elapsed = time.Since(start)
+	r.TimedOut = r.Err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// String summarizes s for logs
+func (s SimStats) String() string {
+	return fmt.Sprintf("%d tasks in %s (%.1fx parallel): %d failed, %d timed out, %d skipped",
+		s.Tasks, s.Elapsed.Round(time.Millisecond), float64(s.Busy)/float64(max(s.Elapsed, 1)), s.Failed, s.TimedOut, s.Skipped)
+}
+
+// nonceChain returns, for each of txs, the hashes it must be simulated
+// after: the same sender's transaction among txs with the next lower nonce
+func nonceChain(txs []*Transaction) [][]string {
+	bySender := make(map[string][]int)
+	for i, tx := range txs {
+		if tx.From != "" {
+			bySender[tx.From] = append(bySender[tx.From], i)
+		}
+	}
+	after := make([][]string, len(txs))
+	for _, idx := range bySender {
+		slices.SortStableFunc(idx, func(a, b int) int { return cmp.Compare(txs[a].Nonce, txs[b].Nonce) })
+		for k := 1; k < len(idx); k++ {
+			if txs[idx[k]].Nonce > txs[idx[k-1]].Nonce {
+				after[idx[k]] = []string{txs[idx[k-1]].Hash}
+			}
+		}
+	}
+	return after
+}
+
+// Calls makes the calls of each group of elems as one task, recording
+// each call's error in its elem as BatchCall does. Group i simulates
+// txs[i], and runs after the group of the same sender's next lower nonce.
+func (w *WorkerPool) Calls(ctx context.Context, rpc *RPCClient, txs []*Transaction, groups [][]BatchElem) SimStats {
+	index := make(map[string]string, len(txs))
+	for i := len(txs) - 1; i >= 0; i-- {
+		index[txs[i].Hash] = strconv.Itoa(i)
+	}
+	after := nonceChain(txs)
+	tasks := make([]SimTask, len(groups))
+	for i, group := range groups {
+		tasks[i] = SimTask{ID: strconv.Itoa(i), Run: func(ctx context.Context) error {
+			var first error
+			for j := range group {
+				e := &group[j]
+				if e.Error = rpc.Call(ctx, e.Result, e.Method, e.Params...); e.Error != nil && first == nil {
+					first = e.Error
+				}
+			}
+			return first
+		}}
+		for _, h := range after[i] {
+			tasks[i].After = append(tasks[i].After, index[h])
+		}
+	}
+	results, stats := w.Run(ctx, tasks)
+	for i, r := range results {
+		if r.Skipped {
+			for j := range groups[i] {
+				groups[i][j].Error = r.Err
+			}
+		}
+	}
+	return stats
+}